@@ -0,0 +1,5 @@
+-- +migrate Up
+ALTER TABLE commands
+    ADD COLUMN IF NOT EXISTS cancelled_at TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS cancelled_by TEXT,
+    ADD COLUMN IF NOT EXISTS cancel_reason TEXT;
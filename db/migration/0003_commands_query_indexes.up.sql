@@ -0,0 +1,7 @@
+-- +migrate Up
+CREATE INDEX IF NOT EXISTS commands_router_status_created_idx
+    ON commands (router_id, status, created_at);
+
+-- keyset pagination of ListCommands: ORDER BY created_at DESC, id DESC
+CREATE INDEX IF NOT EXISTS commands_created_id_idx
+    ON commands (created_at, id);
@@ -0,0 +1,36 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS campaigns (
+    id UUID PRIMARY KEY,
+    name TEXT NOT NULL DEFAULT '',
+    command_type TEXT NOT NULL,
+    priority SMALLINT NOT NULL DEFAULT 50,
+    status TEXT NOT NULL,
+    pause_reason TEXT,
+    total_waves INT NOT NULL,
+    current_wave INT NOT NULL DEFAULT 0,
+    checked_from_wave INT NOT NULL DEFAULT 1,
+    soak_seconds BIGINT NOT NULL DEFAULT 0,
+    max_failure_rate DOUBLE PRECISION NOT NULL,
+    next_wave_at TIMESTAMP,
+    created_by TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    updated_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS campaigns_status_idx ON campaigns (status);
+
+ALTER TABLE commands ADD COLUMN IF NOT EXISTS campaign_id UUID REFERENCES campaigns(id);
+
+CREATE INDEX IF NOT EXISTS commands_campaign_idx
+    ON commands (campaign_id) WHERE campaign_id IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS campaign_targets (
+    campaign_id UUID NOT NULL REFERENCES campaigns(id),
+    router_id UUID NOT NULL REFERENCES routers(id),
+    wave INT NOT NULL,
+    command_id UUID REFERENCES commands(id),
+    PRIMARY KEY (campaign_id, router_id)
+);
+
+CREATE INDEX IF NOT EXISTS campaign_targets_wave_idx
+    ON campaign_targets (campaign_id, wave);
@@ -0,0 +1,2 @@
+-- +migrate Up
+ALTER TABLE routers ADD COLUMN IF NOT EXISTS decommissioned_at TIMESTAMP;
@@ -0,0 +1,13 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS api_keys (
+    id UUID PRIMARY KEY,
+    name TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    roles TEXT[] NOT NULL DEFAULT '{}',
+    key_hash TEXT NOT NULL UNIQUE,
+    created_by TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    expires_at TIMESTAMP,
+    revoked_by TEXT,
+    revoked_at TIMESTAMP
+);
@@ -0,0 +1,17 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS roles (
+    name TEXT PRIMARY KEY,
+    description TEXT,
+    permissions JSONB NOT NULL DEFAULT '[]',
+    updated_by TEXT,
+    updated_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+INSERT INTO roles (name, description, permissions) VALUES
+    ('admin', 'every method, command type and router', '[{"methods": ["*"]}]'),
+    ('noc', 'reads everything', '[{"methods": [
+        "CommandService.GetCommand", "CommandService.ListCommands", "CommandService.GetWorkflow",
+        "CampaignService.GetCampaign", "JobService.GetJob", "JobService.ListJobs",
+        "ApprovalService.GetApproval"
+    ]}]')
+ON CONFLICT (name) DO NOTHING;
@@ -0,0 +1,5 @@
+-- +migrate Up
+ALTER TABLE commands ADD COLUMN IF NOT EXISTS signature_key_id TEXT;
+ALTER TABLE commands ADD COLUMN IF NOT EXISTS signature BYTEA;
+ALTER TABLE commands ADD COLUMN IF NOT EXISTS not_before TIMESTAMP;
+ALTER TABLE commands ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
@@ -0,0 +1,12 @@
+-- +migrate Up
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS id UUID NOT NULL DEFAULT gen_random_uuid();
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMP;
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS completed_at TIMESTAMP;
+
+-- keys with a result are complete; the others are free to be taken over
+UPDATE idempotency_keys
+SET completed_at = created_at
+WHERE completed_at IS NULL AND (command_ids IS NOT NULL OR job_id IS NOT NULL);
+
+UPDATE idempotency_keys SET lease_expires_at = created_at WHERE lease_expires_at IS NULL;
+ALTER TABLE idempotency_keys ALTER COLUMN lease_expires_at SET NOT NULL;
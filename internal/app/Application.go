@@ -2,89 +2,281 @@ package app
 
 import (
 	"context"
-	"log"
+	"encoding/json"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/textproto"
 	"os"
 	"os/signal"
+	"router-manager/internal/audit"
+	"router-manager/internal/auth"
 	"router-manager/internal/config"
+	"router-manager/internal/encryption"
+	"router-manager/internal/health"
+	"router-manager/internal/logging"
+	"router-manager/internal/metrics"
+	"router-manager/internal/model"
 	"router-manager/internal/pb"
+	"router-manager/internal/ratelimit"
 	"router-manager/internal/repository/postgres"
 	"router-manager/internal/repository/redis"
 	"router-manager/internal/service"
+	"router-manager/internal/signing"
+	"router-manager/internal/tracing"
+	"slices"
 	"syscall"
+	"time"
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc/filters"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/trace"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 type Application struct {
-	service *service.CommandService
+	service   *service.CommandService
+	campaigns *service.CampaignService
+	jobs      *service.JobService
+	approvals *service.ApprovalService
+	apiKeys   *service.ApiKeyService
+	roles     *service.RoleService
+	routers   *service.RouterCredentialService
+	audit     *service.AuditService
+	recorder  *audit.Recorder
+
+	svcConfig    *config.Service
+	authConfig   *config.Auth
+	tlsConfig    *config.TLS
+	rateConfig   *config.RateLimit
+	healthConfig *config.Health
 
 	pg  *config.Postgres
 	red *config.Redis
 
 	grpcServer *grpc.Server
 	httpServer *http.Server
+	health     *health.Checker
+
+	log *slog.Logger
 }
 
-func NewApplication() *Application {
-	app := &Application{}
+func NewApplication(logger *slog.Logger) *Application {
+	app := &Application{log: logger}
 
 	app.pg = config.NewPostgres()
-	log.Println("Connected to PostgreSQL")
+	app.log.Info("Connected to PostgreSQL")
 
 	app.red = config.InitRedis()
-	log.Println("Connected to Redis")
+	if app.red.Healthy() {
+		app.log.Info("Connected to Redis")
+	}
 
-	pgRepo := postgres.NewPostgresRepository(app.pg.Pool)
-	redRepo := redis.NewRedisRepository(app.red.Client)
+	pgRepo := postgres.NewPostgresRepository(app.pg.Pool, postgres.WithLogger(logger))
+	redRepo := redis.NewCircuitBreakerRepository(redis.NewRedisRepository(app.red.Client, redis.WithLogger(logger)), app.red)
 
-	app.service = service.NewCommandService(pgRepo, redRepo)
+	app.svcConfig = config.LoadService()
+	svcOpts := []service.Option{
+		service.WithIdempotencyKeyTTL(app.svcConfig.IdempotencyKeyTTL),
+		service.WithIdempotencyKeyLease(app.svcConfig.IdempotencyKeyLease),
+		service.WithSendBatching(app.svcConfig.SendBatchSize, app.svcConfig.AsyncSendThreshold, app.svcConfig.SendRate),
+		service.WithApprovals(app.svcConfig.ApprovalCommandTypes, app.svcConfig.ApprovalTTL),
+		service.WithRouterRegistration(app.svcConfig.AutoRegisterRouters),
+		service.WithRouterOnlineWindow(app.svcConfig.RouterOnlineWindow),
+		service.WithLogger(logger),
+	}
+	if app.svcConfig.SigningKeyFile != "" {
+		signer, err := signing.LoadSigner(app.svcConfig.SigningKeyFile, app.svcConfig.PreviousSigningKeysFile, app.svcConfig.SignatureTTL)
+		if err != nil {
+			logging.Fatal("Couldn't load command signing key", "error", err)
+		}
+		svcOpts = append(svcOpts, service.WithCommandSigner(signer))
+		app.log.Info("Commands are signed", "key_id", signer.PublicKeys()[0].ID)
+	} else {
+		app.log.Warn("COMMAND_SIGNING_KEY_FILE is not set, commands are unsigned")
+	}
+	if app.svcConfig.PayloadKeysFile != "" {
+		keyring, err := encryption.LoadKeyring(app.svcConfig.PayloadKeysFile)
+		if err != nil {
+			logging.Fatal("Couldn't load payload keys", "error", err)
+		}
+		svcOpts = append(svcOpts, service.WithPayloadEncryption(keyring))
+		app.log.Info("Sensitive payloads are sealed", "key_id", keyring.CurrentKeyID())
+	} else {
+		app.log.Warn("PAYLOAD_KEYS_FILE is not set, sensitive payloads are stored in plaintext")
+	}
+	app.service = service.NewCommandService(pgRepo, redRepo, svcOpts...)
+	app.campaigns = service.NewCampaignService(pgRepo, redRepo, app.service)
+	app.jobs = service.NewJobService(pgRepo,
+		service.WithJobWorkers(app.svcConfig.JobWorkers, app.svcConfig.JobLease, app.svcConfig.JobPollInterval),
+	)
+	app.jobs.Handle(model.JobSendCommand, app.service.RunSendJob)
+	app.approvals = service.NewApprovalService(pgRepo, redRepo)
+	app.apiKeys = service.NewApiKeyService(pgRepo)
+	app.roles = service.NewRoleService(pgRepo)
+	app.routers = service.NewRouterCredentialService(pgRepo)
+	app.audit = service.NewAuditService(pgRepo)
 
-	app.grpcServer = grpc.NewServer()
+	app.authConfig = config.LoadAuth()
+	app.tlsConfig = config.LoadTLS()
+	app.rateConfig = config.LoadRateLimit()
+	rateLimits := redis.NewRateLimitRepository(app.red.Client, app.red)
+	app.grpcServer = grpc.NewServer(app.serverOptions(pgRepo, rateLimits)...)
 	pb.RegisterCommandServiceServer(app.grpcServer, app.service)
+	pb.RegisterCampaignServiceServer(app.grpcServer, app.campaigns)
+	pb.RegisterJobServiceServer(app.grpcServer, app.jobs)
+	pb.RegisterApprovalServiceServer(app.grpcServer, app.approvals)
+	pb.RegisterApiKeyServiceServer(app.grpcServer, app.apiKeys)
+	pb.RegisterRoleServiceServer(app.grpcServer, app.roles)
+	pb.RegisterRouterCredentialServiceServer(app.grpcServer, app.routers)
+	pb.RegisterAuditServiceServer(app.grpcServer, app.audit)
+
+	app.healthConfig = config.LoadHealth()
+	app.setupHealth()
 
-	mux := runtime.NewServeMux()
+	mux := runtime.NewServeMux(
+		runtime.WithIncomingHeaderMatcher(incomingHeader),
+		runtime.WithOutgoingHeaderMatcher(outgoingHeader),
+	)
 
+	metricsHandler := promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{})
 	mux.HandlePath("GET", "/metrics", func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
-		promhttp.Handler().ServeHTTP(w, r)
+		metricsHandler.ServeHTTP(w, r)
+	})
+	mux.HandlePath("GET", "/status", app.handleStatus)
+	mux.HandlePath("GET", "/healthz", func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		app.health.Liveness(w, r)
+	})
+	mux.HandlePath("GET", "/readyz", func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		app.health.Readiness(w, r)
 	})
 
 	ctx := context.Background()
-	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	endpoint, opts := app.gatewayEndpoint()
+
+	err := pb.RegisterCommandServiceHandlerFromEndpoint(ctx, mux, endpoint, opts)
+	if err != nil {
+		logging.Fatal("Couldn't register REST handler", "error", err)
+	}
+	err = pb.RegisterCampaignServiceHandlerFromEndpoint(ctx, mux, endpoint, opts)
+	if err != nil {
+		logging.Fatal("Couldn't register REST handler", "error", err)
+	}
+	err = pb.RegisterJobServiceHandlerFromEndpoint(ctx, mux, endpoint, opts)
+	if err != nil {
+		logging.Fatal("Couldn't register REST handler", "error", err)
+	}
+	err = pb.RegisterApprovalServiceHandlerFromEndpoint(ctx, mux, endpoint, opts)
+	if err != nil {
+		logging.Fatal("Couldn't register REST handler", "error", err)
+	}
+	err = pb.RegisterApiKeyServiceHandlerFromEndpoint(ctx, mux, endpoint, opts)
+	if err != nil {
+		logging.Fatal("Couldn't register REST handler", "error", err)
+	}
+	err = pb.RegisterRoleServiceHandlerFromEndpoint(ctx, mux, endpoint, opts)
+	if err != nil {
+		logging.Fatal("Couldn't register REST handler", "error", err)
+	}
+	err = pb.RegisterRouterCredentialServiceHandlerFromEndpoint(ctx, mux, endpoint, opts)
+	if err != nil {
+		logging.Fatal("Couldn't register REST handler", "error", err)
+	}
 
-	err := pb.RegisterCommandServiceHandlerFromEndpoint(ctx, mux, "localhost:50051", opts)
+	err = pb.RegisterAuditServiceHandlerFromEndpoint(ctx, mux, endpoint, opts)
 	if err != nil {
-		log.Fatalf("Couldn't register REST handler: %v", err)
+		logging.Fatal("Couldn't register REST handler", "error", err)
 	}
 
 	app.httpServer = &http.Server{
 		Addr:    ":8080",
-		Handler: mux,
+		Handler: traceHTTP(mux),
+	}
+	if app.tlsConfig.HTTPEnabled() {
+		tlsConfig, err := auth.ServerTLS(app.tlsConfig.HTTPCertFile, app.tlsConfig.HTTPKeyFile, "")
+		if err != nil {
+			logging.Fatal("Couldn't set up REST TLS", "error", err)
+		}
+		app.httpServer.TLSConfig = tlsConfig
 	}
 	return app
 }
 
 func (a *Application) Run() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the cache may hold commands written while this instance was down, or
+	// in an older layout: rebuild it before serving
+	if a.red.Healthy() {
+		if err := a.red.Resync(ctx, a.service.ResyncCache); err != nil {
+			a.log.Warn("Failed to resync Redis cache, running without cache until it succeeds", "error", err)
+		}
+	}
+
+	// reconnect to Redis in the background and resync the cache when it's back
+	go a.red.WatchHealth(ctx, a.service.ResyncCache)
+	// keeps the gRPC health service up to date
+	go a.health.Watch(ctx, a.healthConfig.CheckInterval)
+
+	go runEvery(ctx, "purge idempotency keys", time.Hour, a.service.PurgeIdempotencyKeys)
+	go runEvery(ctx, "advance campaigns", a.svcConfig.CampaignTickInterval, a.campaigns.AdvanceCampaigns)
+	go runEvery(ctx, "expire approvals", time.Minute, a.approvals.ExpireApprovals)
+	go runEvery(ctx, "refresh gauges", a.svcConfig.MetricsRefreshInterval, a.service.RefreshGauges)
+
+	jobsDone := make(chan struct{})
+	go func() {
+		a.jobs.Run(ctx)
+		close(jobsDone)
+	}()
+
+	// audit events are appended until the last call is handled
+	auditCtx, stopAudit := context.WithCancel(context.Background())
+	auditDone := make(chan struct{})
+	go func() {
+		a.recorder.Run(auditCtx)
+		close(auditDone)
+	}()
+
 	go func() {
-		lis, err := net.Listen("tcp", ":50051")
+		lis, err := net.Listen("tcp", grpcAddr)
 		if err != nil {
-			log.Fatalf("Failed to listen gRPC: %v", err)
+			logging.Fatal("Failed to listen gRPC", "error", err)
 		}
-		log.Println("gRPC server running on :50051")
+		a.log.Info("gRPC server running", "addr", grpcAddr, "tls", a.tlsConfig.GRPCEnabled())
 		if err := a.grpcServer.Serve(lis); err != nil {
-			log.Fatalf("gRPC server failed: %v", err)
+			logging.Fatal("gRPC server failed", "error", err)
 		}
 	}()
 
+	if a.tlsConfig.GatewaySocket != "" {
+		go func() {
+			lis, err := a.listenGateway()
+			if err != nil {
+				logging.Fatal("Failed to listen gateway socket", "error", err)
+			}
+			a.log.Info("gRPC server running for the gateway", "socket", a.tlsConfig.GatewaySocket)
+			if err := a.grpcServer.Serve(lis); err != nil {
+				logging.Fatal("gRPC server failed", "error", err)
+			}
+		}()
+	}
+
 	go func() {
-		log.Println("REST API running on :8080")
-		if err := a.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("HTTP server failed: %v", err)
+		a.log.Info("REST API running", "addr", ":8080", "tls", a.tlsConfig.HTTPEnabled())
+		var err error
+		if a.tlsConfig.HTTPEnabled() {
+			err = a.httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = a.httpServer.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			logging.Fatal("HTTP server failed", "error", err)
 		}
 	}()
 
@@ -92,21 +284,186 @@ func (a *Application) Run() {
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	<-c
 
-	log.Println("Shutting down...")
+	a.log.Info("Shutting down...")
+	// load balancers take the instance out before it stops serving
+	a.health.Drain()
+	time.Sleep(a.healthConfig.DrainDelay)
+	cancel()
+
+	// interrupted jobs are handed back before the database goes away
+	<-jobsDone
 
 	a.grpcServer.GracefulStop()
 	if err := a.httpServer.Shutdown(context.Background()); err != nil {
-		log.Printf("HTTP shutdown error: %v", err)
+		a.log.Error("HTTP shutdown error", "error", err)
 	}
 
+	stopAudit()
+	<-auditDone
+
 	if a.pg != nil {
 		a.pg.Close()
-		log.Println("PostgreSQL connection closed")
+		a.log.Info("PostgreSQL connection closed")
 	}
 	if a.red != nil {
 		a.red.Close()
-		log.Println("Redis connection closed")
+		a.log.Info("Redis connection closed")
+	}
+
+	a.log.Info("Application stopped")
+}
+
+// serverOptions sets up the transport credentials and the interceptors of
+// the gRPC server.
+func (a *Application) serverOptions(pgRepo postgres.PostgresRepo, rateLimits redis.RateLimitRepo) []grpc.ServerOption {
+	// spans of the calls, children of the gateway's or the caller's; probes
+	// aren't traced
+	opts := []grpc.ServerOption{grpc.StatsHandler(otelgrpc.NewServerHandler(
+		otelgrpc.WithFilter(filters.Not(filters.HealthCheck())),
+	))}
+	if creds := a.grpcCredentials(); creds != nil {
+		opts = append(opts, grpc.Creds(creds))
+	}
+
+	// routers don't have operator credentials
+	routerMethods := []string{pb.CommandService_PollCommands_FullMethodName, pb.CommandService_AckCommand_FullMethodName}
+	// the signing keys are public, routers fetch them without credentials;
+	// so is health, probes have none
+	healthMethods := []string{healthpb.Health_Check_FullMethodName, healthpb.Health_Watch_FullMethodName}
+	publicMethods := slices.Concat([]string{pb.CommandService_GetSigningKeys_FullMethodName}, routerMethods, healthMethods)
+
+	// every other interceptor logs with the request attributes; polls and
+	// probes come all the time
+	requests := logging.NewRequestLogger(a.log, slices.Concat(routerMethods, healthMethods)...)
+	// calls the authenticators reject are recorded as well
+	a.recorder = audit.NewRecorder(pgRepo, routerMethods...)
+	interceptors := []grpc.UnaryServerInterceptor{requests.UnaryInterceptor(), a.recorder.RejectionInterceptor()}
+	streamInterceptors := []grpc.StreamServerInterceptor{requests.StreamInterceptor()}
+	if a.authConfig.RouterAuthEnabled {
+		interceptors = append(interceptors, auth.NewRouterAuthenticator(pgRepo, routerMethods...).UnaryInterceptor())
+	}
+
+	var limiter *ratelimit.Limiter
+	if a.rateConfig.Enabled {
+		limits, err := ratelimit.ParseLimits(a.rateConfig.Limits)
+		if err != nil {
+			logging.Fatal("Couldn't parse RATE_LIMITS", "error", err)
+		}
+		limiter = ratelimit.NewLimiter(rateLimits, limits, routerMethods...)
+	}
+
+	if a.authConfig.Enabled {
+		authOpts := []auth.Option{
+			auth.WithPublicMethods(publicMethods...),
+			auth.WithBootstrapKey(a.authConfig.BootstrapApiKey),
+		}
+		if a.authConfig.JWKSFile != "" {
+			jwks, err := auth.LoadJWKS(a.authConfig.JWKSFile, a.authConfig.JWTIssuer, a.authConfig.JWTAudience)
+			if err != nil {
+				logging.Fatal("Couldn't load JWKS", "error", err)
+			}
+			authOpts = append(authOpts, auth.WithJWKS(jwks))
+		}
+		authenticator := auth.NewAuthenticator(pgRepo, authOpts...)
+		authorizer := auth.NewAuthorizer(pgRepo, a.authConfig.PolicyRefreshInterval)
+
+		interceptors = append(interceptors, authenticator.UnaryInterceptor())
+		streamInterceptors = append(streamInterceptors, authenticator.StreamInterceptor(), authorizer.StreamInterceptor())
+		// throttled calls don't reach the audit log, refused ones do
+		if limiter != nil {
+			interceptors = append(interceptors, limiter.UnaryInterceptor())
+		}
+		interceptors = append(interceptors, a.recorder.UnaryInterceptor(), authorizer.UnaryInterceptor())
+	} else {
+		if limiter != nil {
+			interceptors = append(interceptors, limiter.UnaryInterceptor())
+		}
+		interceptors = append(interceptors, a.recorder.UnaryInterceptor())
+		if len(a.svcConfig.ApprovalCommandTypes) > 0 {
+			a.log.Warn("Authentication is disabled: approvals can't be decided, commands that need one expire",
+				"command_types", a.svcConfig.ApprovalCommandTypes)
+		}
+	}
+
+	return append(opts,
+		grpc.ChainUnaryInterceptor(interceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+	)
+}
+
+// incomingHeader forwards the credential, caller and request id headers of
+// REST calls to the gRPC server, besides the ones
+// runtime.DefaultHeaderMatcher does (Authorization among them).
+func incomingHeader(key string) (string, bool) {
+	switch textproto.CanonicalMIMEHeaderKey(key) {
+	case "X-Api-Key":
+		return "x-api-key", true
+	case "X-Caller-Id":
+		return "x-caller-id", true
+	case "X-Router-Secret":
+		return "x-router-secret", true
+	case "X-Request-Id":
+		return logging.RequestIDHeader, true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+// outgoingHeader passes the retry-after header of throttled calls on as
+// Retry-After and the request id as X-Request-Id; other headers get the
+// default Grpc-Metadata- prefix.
+func outgoingHeader(key string) (string, bool) {
+	switch key {
+	case "retry-after":
+		return "Retry-After", true
+	case logging.RequestIDHeader:
+		return "X-Request-Id", true
+	}
+	return runtime.MetadataHeaderPrefix + key, true
+}
+
+// traceHTTP starts a span for every REST call, continuing the trace of the
+// caller's traceparent header. Scrapes, status checks and probes aren't
+// traced.
+func traceHTTP(handler http.Handler) http.Handler {
+	return otelhttp.NewHandler(handler, "gateway",
+		otelhttp.WithSpanNameFormatter(func(operation string, r *http.Request) string {
+			return r.Method + " " + r.URL.Path
+		}),
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			switch r.URL.Path {
+			case "/metrics", "/status", "/healthz", "/readyz":
+				return false
+			}
+			return true
+		}),
+	)
+}
+
+// runEvery calls fn every interval until ctx is done, each run in a span
+// named after the task.
+func runEvery(ctx context.Context, task string, interval time.Duration, fn func(ctx context.Context)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			runCtx, span := tracing.Tracer().Start(ctx, task, trace.WithNewRoot())
+			fn(runCtx)
+			span.End()
+		}
+	}
+}
+
+// handleStatus reports whether the service is running with or without its cache.
+func (a *Application) handleStatus(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	cache := "up"
+	if !a.red.Healthy() {
+		cache = "down"
 	}
 
-	log.Println("Application stopped")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"cache": cache})
 }
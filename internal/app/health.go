@@ -0,0 +1,55 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"router-manager/internal/config"
+	"router-manager/internal/health"
+	"slices"
+
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// setupHealth registers the gRPC health service, reporting each service of
+// the server, and checks the dependencies readiness depends on. Redis isn't
+// one of them: without it commands are served from PostgreSQL.
+func (a *Application) setupHealth() {
+	server := grpchealth.NewServer()
+	var services []string
+	for name := range a.grpcServer.GetServiceInfo() {
+		services = append(services, name)
+	}
+	slices.Sort(services)
+	healthpb.RegisterHealthServer(a.grpcServer, server)
+
+	a.health = health.NewChecker(
+		health.WithTimeout(a.healthConfig.CheckTimeout),
+		health.WithGRPC(server, services...),
+	)
+
+	a.health.Add("postgres", a.pg.Pool.Ping)
+
+	version, versionErr := config.LatestMigration(config.MigrationsDir)
+	a.health.Add("migrations", func(ctx context.Context) error {
+		if versionErr != nil {
+			return fmt.Errorf("failed to read migrations: %w", versionErr)
+		}
+		return a.pg.CheckSchema(ctx, version)
+	})
+
+	a.health.Add("workers", func(ctx context.Context) error {
+		if running, workers := a.jobs.Running(); running < workers {
+			return fmt.Errorf("%d of %d job workers running", running, workers)
+		}
+		return nil
+	})
+
+	a.health.AddOptional("redis", func(ctx context.Context) error {
+		if !a.red.Healthy() {
+			return errors.New("unreachable, commands are served from PostgreSQL")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,94 @@
+package app
+
+import (
+	"net"
+	"os"
+	"router-manager/internal/auth"
+	"router-manager/internal/logging"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const grpcAddr = ":50051"
+
+// grpcCredentials returns the transport credentials of the gRPC server, nil
+// for plaintext.
+func (a *Application) grpcCredentials() credentials.TransportCredentials {
+	if !a.tlsConfig.GRPCEnabled() {
+		return nil
+	}
+
+	config, err := auth.ServerTLS(a.tlsConfig.GRPCCertFile, a.tlsConfig.GRPCKeyFile, a.tlsConfig.ClientCAFile, "h2")
+	if err != nil {
+		logging.Fatal("Couldn't set up gRPC TLS", "error", err)
+	}
+	if a.tlsConfig.ClientCAFile != "" {
+		a.log.Info("gRPC listener accepts router device certificates")
+	}
+
+	return unixOrTLS{credentials.NewTLS(config)}
+}
+
+// unixOrTLS serves TLS except on the gateway's unix socket, which only
+// local processes with access to the file can reach.
+type unixOrTLS struct {
+	credentials.TransportCredentials
+}
+
+func (c unixOrTLS) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	if conn.LocalAddr().Network() == "unix" {
+		return insecure.NewCredentials().ServerHandshake(conn)
+	}
+	return c.TransportCredentials.ServerHandshake(conn)
+}
+
+func (c unixOrTLS) Clone() credentials.TransportCredentials {
+	return unixOrTLS{c.TransportCredentials.Clone()}
+}
+
+// gatewayEndpoint returns the address the gateway dials the gRPC server at,
+// and how: over the unix socket if there is one, else over TLS if the
+// server serves it.
+func (a *Application) gatewayEndpoint() (string, []grpc.DialOption) {
+	// the gateway passes the trace of the REST call on to the server
+	traced := grpc.WithStatsHandler(otelgrpc.NewClientHandler())
+
+	if a.tlsConfig.GatewaySocket != "" {
+		return "unix:" + a.tlsConfig.GatewaySocket,
+			[]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials()), traced}
+	}
+
+	if !a.tlsConfig.GRPCEnabled() {
+		return "localhost" + grpcAddr,
+			[]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials()), traced}
+	}
+
+	config, err := auth.ClientTLS(a.tlsConfig.GatewayCAFile, a.tlsConfig.GatewayServerName)
+	if err != nil {
+		logging.Fatal("Couldn't set up gateway TLS", "error", err)
+	}
+	return "localhost" + grpcAddr,
+		[]grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(config)), traced}
+}
+
+// listenGateway listens on the gateway's unix socket, replacing the file a
+// previous run left behind.
+func (a *Application) listenGateway() (net.Listener, error) {
+	path := a.tlsConfig.GatewaySocket
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		lis.Close()
+		return nil, err
+	}
+	return lis, nil
+}
@@ -0,0 +1,335 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"router-manager/internal/auth"
+	"router-manager/internal/metrics"
+	"router-manager/internal/model"
+	"router-manager/internal/pb"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// how long a call may wait for room in a full queue, and how long
+// appending a batch of events may take
+const recordTimeout = 5 * time.Second
+
+// events waiting to be appended, and the most appended in one transaction
+const (
+	queueSize = 1024
+	maxBatch  = 100
+)
+
+// waits between attempts to append a batch of events that failed, e.g.
+// while PostgreSQL is down
+var appendRetries = []time.Duration{time.Second, 5 * time.Second, 15 * time.Second}
+
+// targets of a list, e.g. the routers of a SendCommand, named in full
+const maxListedTargets = 5
+
+// Store is where audit events are appended.
+type Store interface {
+	AppendAuditEvents(ctx context.Context, events []model.AuditEvent) error
+}
+
+// Recorder appends an audit event for each call of a method that changes
+// something, whatever its outcome. Reads (Get*, List*, Export*) and health
+// checks aren't recorded, nor polls that deliver nothing: routers poll all the time.
+//
+// Calls only queue their events; Run appends them in batches, so that the
+// calls don't wait for the lock that keeps the log chained.
+type Recorder struct {
+	store Store
+	// methods routers call, whose actor is the router
+	routerMethods map[string]bool
+
+	queue   chan model.AuditEvent
+	retries []time.Duration
+}
+
+func NewRecorder(store Store, routerMethods ...string) *Recorder {
+	r := &Recorder{
+		store:         store,
+		routerMethods: make(map[string]bool),
+		queue:         make(chan model.AuditEvent, queueSize),
+		retries:       appendRetries,
+	}
+	for _, method := range routerMethods {
+		r.routerMethods[method] = true
+	}
+	return r
+}
+
+// UnaryInterceptor records calls once they are handled. It must run after
+// the Authenticator's, to know the principal, and before the Authorizer's,
+// so that refused calls are recorded too.
+func (r *Recorder) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if reached, ok := ctx.Value(reachedKey{}).(*bool); ok {
+			*reached = true
+		}
+
+		resp, err := handler(ctx, req)
+
+		if recorded(info.FullMethod, resp) {
+			r.Record(ctx, info.FullMethod, req, err)
+		}
+		return resp, err
+	}
+}
+
+// reachedKey holds the flag UnaryInterceptor sets for the calls that
+// RejectionInterceptor watches.
+type reachedKey struct{}
+
+// RejectionInterceptor records the calls rejected before they reach
+// UnaryInterceptor, by the authenticators: it must run before them.
+// Throttled calls aren't recorded, a client over its limit would flood the
+// audit log.
+func (r *Recorder) RejectionInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		reached := new(bool)
+		resp, err := handler(context.WithValue(ctx, reachedKey{}, reached), req)
+
+		if !*reached && err != nil && status.Code(err) != codes.ResourceExhausted && !readOnly(info.FullMethod) {
+			r.Record(ctx, info.FullMethod, req, err)
+		}
+		return resp, err
+	}
+}
+
+func recorded(fullMethod string, resp any) bool {
+	if readOnly(fullMethod) {
+		return false
+	}
+
+	if fullMethod == pb.CommandService_PollCommands_FullMethodName {
+		poll, ok := resp.(*pb.PollResponse)
+		return ok && len(poll.Commands)+len(poll.Cancellations) > 0
+	}
+	return true
+}
+
+// readOnly reports whether the method only reads.
+func readOnly(fullMethod string) bool {
+	_, method, _ := strings.Cut(auth.MethodName(fullMethod), ".")
+	for _, prefix := range []string{"Get", "List", "Export", "Check"} {
+		if strings.HasPrefix(method, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Record queues the event of a call that ended with err. If the queue stays
+// full for recordTimeout the event is logged instead, and counted as
+// dropped: the call has happened either way.
+func (r *Recorder) Record(ctx context.Context, fullMethod string, req any, err error) {
+	// the actor, target and error come from the client, even before it is
+	// authenticated
+	event := &model.AuditEvent{
+		OccurredAt:    time.Now().UTC().Truncate(time.Microsecond),
+		Actor:         storable(r.actor(ctx, fullMethod, req)),
+		Action:        auth.MethodName(fullMethod),
+		Target:        storable(target(req)),
+		RequestDigest: digest(req),
+		Outcome:       status.Code(err).String(),
+		SourceIP:      storable(auth.SourceIP(ctx)),
+	}
+	if err != nil {
+		event.Error = storable(status.Convert(err).Message())
+	}
+
+	timer := time.NewTimer(recordTimeout)
+	defer timer.Stop()
+
+	select {
+	case r.queue <- *event:
+	case <-timer.C:
+		metrics.AuditEventsDropped.WithLabelValues(metrics.AuditQueueFull).Inc()
+		slog.ErrorContext(ctx, "Failed to record audit event", "event", *event, "error", "audit queue is full")
+	}
+}
+
+// storable is s as PostgreSQL text can hold it: invalid UTF-8 replaced and
+// NUL bytes escaped.
+func storable(s string) string {
+	return strings.ReplaceAll(strings.ToValidUTF8(s, "\uFFFD"), "\x00", `\x00`)
+}
+
+// Run appends the queued events until ctx is done, then the ones still
+// queued: it should be stopped once the server handles no more calls.
+func (r *Recorder) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			r.drain()
+			return
+		case event := <-r.queue:
+			r.append(r.batch(event))
+		}
+	}
+}
+
+// drain appends the queued events.
+func (r *Recorder) drain() {
+	for {
+		select {
+		case event := <-r.queue:
+			r.append(r.batch(event))
+		default:
+			return
+		}
+	}
+}
+
+// batch returns first and the events queued after it, up to maxBatch.
+func (r *Recorder) batch(first model.AuditEvent) []model.AuditEvent {
+	events := []model.AuditEvent{first}
+	for len(events) < maxBatch {
+		select {
+		case event := <-r.queue:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+	return events
+}
+
+// append stores the events, trying again after each of the retries if it
+// fails, then each event on its own, so that an event the store rejects
+// doesn't take the others with it. Events that can't be stored are logged
+// instead, and counted as dropped.
+func (r *Recorder) append(events []model.AuditEvent) {
+	err := r.appendBatch(events)
+	for i := 0; err != nil && i < len(r.retries); i++ {
+		time.Sleep(r.retries[i])
+		err = r.appendBatch(events)
+	}
+	if err == nil {
+		return
+	}
+
+	for _, event := range events {
+		if len(events) > 1 {
+			if err = r.appendBatch([]model.AuditEvent{event}); err == nil {
+				continue
+			}
+		}
+		metrics.AuditEventsDropped.WithLabelValues(metrics.AuditAppendFailed).Inc()
+		slog.Error("Failed to record audit event", "event", event, "error", err)
+	}
+}
+
+func (r *Recorder) appendBatch(events []model.AuditEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), recordTimeout)
+	defer cancel()
+
+	return r.store.AppendAuditEvents(ctx, events)
+}
+
+// actor is the principal of the call; routers are named by the serial
+// number the RouterAuthenticator checked.
+func (r *Recorder) actor(ctx context.Context, fullMethod string, req any) string {
+	if principal, ok := auth.FromContext(ctx); ok {
+		return principal.Subject
+	}
+
+	if router, ok := req.(interface{ GetSerialNumber() string }); ok && r.routerMethods[fullMethod] {
+		return "router:" + router.GetSerialNumber()
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("x-caller-id"); len(values) > 0 && values[0] != "" {
+			return values[0]
+		}
+	}
+	return "anonymous"
+}
+
+// target describes what the request acts on: its ids, serial numbers,
+// names and command types, those of the messages it holds included, as
+// "name=value" pairs.
+func target(req any) string {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return ""
+	}
+
+	var pairs []string
+	describe(msg.ProtoReflect(), &pairs)
+	return strings.Join(pairs, " ")
+}
+
+func describe(m protoreflect.Message, pairs *[]string) {
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		name := string(field.Name())
+		if field.IsMap() || !m.Has(field) {
+			continue
+		}
+
+		switch {
+		case field.Kind() == protoreflect.MessageKind && field.IsList():
+			list := m.Get(field).List()
+			for j := 0; j < min(list.Len(), maxListedTargets); j++ {
+				describe(list.Get(j).Message(), pairs)
+			}
+			if list.Len() > maxListedTargets {
+				*pairs = append(*pairs, fmt.Sprintf("(%d more %s)", list.Len()-maxListedTargets, name))
+			}
+
+		case field.Kind() == protoreflect.MessageKind:
+			describe(m.Get(field).Message(), pairs)
+
+		case field.Kind() == protoreflect.StringKind && targetField(name) && field.IsList():
+			list := m.Get(field).List()
+			for j := 0; j < min(list.Len(), maxListedTargets); j++ {
+				*pairs = append(*pairs, name+"="+list.Get(j).String())
+			}
+			if list.Len() > maxListedTargets {
+				*pairs = append(*pairs, fmt.Sprintf("(%d more %s)", list.Len()-maxListedTargets, name))
+			}
+
+		case field.Kind() == protoreflect.StringKind && targetField(name):
+			*pairs = append(*pairs, name+"="+m.Get(field).String())
+		}
+	}
+}
+
+// targetField reports whether a string field names what a call acts on.
+func targetField(name string) bool {
+	switch name {
+	case "id", "name", "subject", "command_type":
+		return true
+	}
+	return strings.HasSuffix(name, "_id") || strings.HasPrefix(name, "serial_")
+}
+
+// digest is the hex SHA-256 of the request.
+func digest(req any) string {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return ""
+	}
+
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
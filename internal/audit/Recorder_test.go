@@ -0,0 +1,312 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"router-manager/internal/auth"
+	"router-manager/internal/metrics"
+	"router-manager/internal/model"
+	"router-manager/internal/pb"
+	mockspg "router-manager/internal/repository/postgres/mocks"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+var routerMethods = []string{pb.CommandService_PollCommands_FullMethodName, pb.CommandService_AckCommand_FullMethodName}
+
+// setupRecorder returns a recorder and the events it appended.
+func setupRecorder(t *testing.T) (*Recorder, *[]model.AuditEvent, *mockspg.MockPostgresRepo) {
+	ctrl := gomock.NewController(t)
+	mockPostgres := mockspg.NewMockPostgresRepo(ctrl)
+
+	var events []model.AuditEvent
+	mockPostgres.EXPECT().AppendAuditEvents(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, batch []model.AuditEvent) error {
+			events = append(events, batch...)
+			return nil
+		}).AnyTimes()
+
+	return NewRecorder(mockPostgres, routerMethods...), &events, mockPostgres
+}
+
+// call runs the request through the interceptor with a handler returning
+// resp and err, and appends the queued events.
+func call(r *Recorder, ctx context.Context, method string, req, resp any, err error) (any, error) {
+	defer r.drain()
+	return r.UnaryInterceptor()(ctx, req, &grpc.UnaryServerInfo{FullMethod: method},
+		func(ctx context.Context, req any) (any, error) { return resp, err })
+}
+
+func TestRecorder_RecordsChanges(t *testing.T) {
+	r, events, _ := setupRecorder(t)
+
+	ctx := auth.NewContext(context.Background(), &auth.Principal{Subject: "alice"})
+	req := &pb.SendCommandRequest{CommandType: "REBOOT", Routers: []*pb.Router{{SerialNumber: "SN1"}, {RouterId: "r-2"}}}
+	_, err := call(r, ctx, pb.CommandService_SendCommand_FullMethodName, req, &pb.SendCommandResponse{}, nil)
+	require.NoError(t, err)
+
+	require.Len(t, *events, 1)
+	event := (*events)[0]
+	assert.Equal(t, "alice", event.Actor)
+	assert.Equal(t, "CommandService.SendCommand", event.Action)
+	assert.Equal(t, "serial_number=SN1 router_id=r-2 command_type=REBOOT", event.Target)
+	assert.Len(t, event.RequestDigest, 64)
+	assert.Equal(t, "OK", event.Outcome)
+	assert.Empty(t, event.Error)
+	assert.False(t, event.OccurredAt.IsZero())
+
+	// the same request has the same digest
+	_, err = call(r, ctx, pb.CommandService_SendCommand_FullMethodName, req, &pb.SendCommandResponse{}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, event.RequestDigest, (*events)[1].RequestDigest)
+}
+
+func TestRecorder_RecordsFailures(t *testing.T) {
+	r, events, _ := setupRecorder(t)
+
+	refused := status.Error(codes.PermissionDenied, "alice may not call CommandService.CancelCommand")
+	_, err := call(r, context.Background(), pb.CommandService_CancelCommand_FullMethodName,
+		&pb.CancelCommandRequest{CommandId: "c-1"}, nil, refused)
+	assert.Equal(t, refused, err)
+
+	require.Len(t, *events, 1)
+	assert.Equal(t, "anonymous", (*events)[0].Actor)
+	assert.Equal(t, "command_id=c-1", (*events)[0].Target)
+	assert.Equal(t, "PermissionDenied", (*events)[0].Outcome)
+	assert.Equal(t, "alice may not call CommandService.CancelCommand", (*events)[0].Error)
+}
+
+func TestRecorder_SkipsReads(t *testing.T) {
+	r, events, _ := setupRecorder(t)
+
+	for _, method := range []string{
+		pb.CommandService_GetCommand_FullMethodName,
+		pb.CommandService_ListCommands_FullMethodName,
+		pb.AuditService_ListAuditEvents_FullMethodName,
+		healthpb.Health_Check_FullMethodName,
+	} {
+		_, err := call(r, context.Background(), method, &pb.GetCommandRequest{}, nil, nil)
+		require.NoError(t, err)
+	}
+	assert.Empty(t, *events)
+}
+
+func TestRecorder_Routers(t *testing.T) {
+	r, events, _ := setupRecorder(t)
+	poll := &pb.PollRequest{RouterId: "r-1", SerialNumber: "SN1"}
+
+	// polls that deliver nothing don't change anything
+	_, err := call(r, context.Background(), pb.CommandService_PollCommands_FullMethodName, poll, &pb.PollResponse{}, nil)
+	require.NoError(t, err)
+	assert.Empty(t, *events)
+
+	_, err = call(r, context.Background(), pb.CommandService_PollCommands_FullMethodName, poll,
+		&pb.PollResponse{Commands: []*pb.Command{{Id: "c-1"}}}, nil)
+	require.NoError(t, err)
+	_, err = call(r, context.Background(), pb.CommandService_AckCommand_FullMethodName,
+		&pb.AckRequest{RouterId: "r-1", SerialNumber: "SN1", CommandId: "c-1"}, &pb.AckResponse{}, nil)
+	require.NoError(t, err)
+
+	require.Len(t, *events, 2)
+	assert.Equal(t, "router:SN1", (*events)[0].Actor)
+	assert.Equal(t, "CommandService.PollCommands", (*events)[0].Action)
+	assert.Equal(t, "router:SN1", (*events)[1].Actor)
+	assert.Equal(t, "router_id=r-1 serial_number=SN1 command_id=c-1", (*events)[1].Target)
+}
+
+// guarded runs the request through RejectionInterceptor, then guard, which
+// stands for the authenticators, then UnaryInterceptor.
+func guarded(r *Recorder, guard grpc.UnaryServerInterceptor, method string, req any) (any, error) {
+	defer r.drain()
+	info := &grpc.UnaryServerInfo{FullMethod: method}
+	return r.RejectionInterceptor()(context.Background(), req, info, func(ctx context.Context, req any) (any, error) {
+		return guard(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+			return r.UnaryInterceptor()(ctx, req, info, func(context.Context, any) (any, error) {
+				return &pb.PollResponse{Commands: []*pb.Command{{Id: "c-1"}}}, nil
+			})
+		})
+	})
+}
+
+// rejecting is a guard rejecting every call with err.
+func rejecting(err error) grpc.UnaryServerInterceptor {
+	return func(context.Context, any, *grpc.UnaryServerInfo, grpc.UnaryHandler) (any, error) {
+		return nil, err
+	}
+}
+
+func TestRecorder_RecordsRejections(t *testing.T) {
+	r, events, _ := setupRecorder(t)
+	poll := &pb.PollRequest{RouterId: "r-1", SerialNumber: "SN1"}
+
+	_, err := guarded(r, rejecting(status.Error(codes.Unauthenticated, "invalid credentials")),
+		pb.CommandService_PollCommands_FullMethodName, poll)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	require.Len(t, *events, 1)
+	assert.Equal(t, "router:SN1", (*events)[0].Actor)
+	assert.Equal(t, "CommandService.PollCommands", (*events)[0].Action)
+	assert.Equal(t, "Unauthenticated", (*events)[0].Outcome)
+	assert.Equal(t, "invalid credentials", (*events)[0].Error)
+
+	// throttled calls and reads aren't recorded
+	_, err = guarded(r, rejecting(status.Error(codes.ResourceExhausted, "rate limit exceeded")),
+		pb.CommandService_PollCommands_FullMethodName, poll)
+	assert.Error(t, err)
+	_, err = guarded(r, rejecting(status.Error(codes.Unauthenticated, "missing credentials")),
+		pb.CommandService_GetCommand_FullMethodName, &pb.GetCommandRequest{})
+	assert.Error(t, err)
+	assert.Len(t, *events, 1)
+
+	// calls let through are recorded once, by UnaryInterceptor
+	pass := func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return handler(auth.NewContext(ctx, &auth.Principal{Subject: "alice"}), req)
+	}
+	_, err = guarded(r, pass, pb.CommandService_PollCommands_FullMethodName, poll)
+	require.NoError(t, err)
+	require.Len(t, *events, 2)
+	assert.Equal(t, "alice", (*events)[1].Actor)
+	assert.Equal(t, "OK", (*events)[1].Outcome)
+}
+
+func TestRecorder_ManyTargets(t *testing.T) {
+	r, events, _ := setupRecorder(t)
+
+	req := &pb.SendCommandRequest{CommandType: "REBOOT"}
+	for i := range 7 {
+		req.Routers = append(req.Routers, &pb.Router{SerialNumber: fmt.Sprintf("SN%d", i)})
+	}
+	_, err := call(r, context.Background(), pb.CommandService_SendCommand_FullMethodName, req, nil, nil)
+	require.NoError(t, err)
+
+	require.Len(t, *events, 1)
+	assert.Equal(t, "serial_number=SN0 serial_number=SN1 serial_number=SN2 serial_number=SN3 serial_number=SN4 (2 more routers) command_type=REBOOT",
+		(*events)[0].Target)
+}
+
+func TestRecorder_StoreDown(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockPostgres := mockspg.NewMockPostgresRepo(ctrl)
+	r := NewRecorder(mockPostgres)
+	r.retries = []time.Duration{time.Millisecond, time.Millisecond}
+
+	// the first attempt and both retries fail
+	mockPostgres.EXPECT().AppendAuditEvents(gomock.Any(), gomock.Any()).Return(fmt.Errorf("connection refused")).Times(3)
+	dropped := metrics.AuditEventsDropped.WithLabelValues(metrics.AuditAppendFailed)
+	before := testutil.ToFloat64(dropped)
+
+	// the call has happened either way
+	resp, err := call(r, context.Background(), pb.CommandService_CancelCommand_FullMethodName,
+		&pb.CancelCommandRequest{CommandId: "c-1"}, &pb.CancelCommandsResponse{}, nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+	assert.Equal(t, before+1, testutil.ToFloat64(dropped))
+}
+
+func TestRecorder_RetriesBatch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockPostgres := mockspg.NewMockPostgresRepo(ctrl)
+	r := NewRecorder(mockPostgres)
+	r.retries = []time.Duration{time.Millisecond}
+
+	var events []model.AuditEvent
+	gomock.InOrder(
+		mockPostgres.EXPECT().AppendAuditEvents(gomock.Any(), gomock.Any()).Return(fmt.Errorf("connection refused")),
+		mockPostgres.EXPECT().AppendAuditEvents(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(ctx context.Context, batch []model.AuditEvent) error {
+				events = append(events, batch...)
+				return nil
+			}),
+	)
+
+	for _, id := range []string{"c-1", "c-2"} {
+		r.Record(context.Background(), pb.CommandService_CancelCommand_FullMethodName, &pb.CancelCommandRequest{CommandId: id}, nil)
+	}
+	r.drain()
+
+	require.Len(t, events, 2)
+}
+
+func TestRecorder_BadEventInBatch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockPostgres := mockspg.NewMockPostgresRepo(ctrl)
+	r := NewRecorder(mockPostgres)
+	r.retries = []time.Duration{time.Millisecond}
+
+	// the store rejects any batch holding c-bad
+	var events []model.AuditEvent
+	mockPostgres.EXPECT().AppendAuditEvents(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, batch []model.AuditEvent) error {
+			for _, event := range batch {
+				if event.Target == "command_id=c-bad" {
+					return fmt.Errorf("value too long")
+				}
+			}
+			events = append(events, batch...)
+			return nil
+		}).AnyTimes()
+	dropped := metrics.AuditEventsDropped.WithLabelValues(metrics.AuditAppendFailed)
+	before := testutil.ToFloat64(dropped)
+
+	for _, id := range []string{"c-1", "c-bad", "c-2"} {
+		r.Record(context.Background(), pb.CommandService_CancelCommand_FullMethodName, &pb.CancelCommandRequest{CommandId: id}, nil)
+	}
+	r.drain()
+
+	// the others are appended on their own
+	require.Len(t, events, 2)
+	assert.Equal(t, "command_id=c-1", events[0].Target)
+	assert.Equal(t, "command_id=c-2", events[1].Target)
+	assert.Equal(t, before+1, testutil.ToFloat64(dropped))
+}
+
+func TestRecorder_EscapesNul(t *testing.T) {
+	r, events, _ := setupRecorder(t)
+
+	_, err := call(r, context.Background(), pb.CommandService_CancelCommand_FullMethodName,
+		&pb.CancelCommandRequest{CommandId: "c-\x00\xff"}, nil, status.Error(codes.NotFound, "command c-\x00 not found"))
+	require.Error(t, err)
+
+	require.Len(t, *events, 1)
+	assert.Equal(t, "command_id=c-\\x00\uFFFD", (*events)[0].Target)
+	assert.Equal(t, `command c-\x00 not found`, (*events)[0].Error)
+}
+
+func TestRecorder_AppendsInBatches(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockPostgres := mockspg.NewMockPostgresRepo(ctrl)
+	r := NewRecorder(mockPostgres)
+
+	// calls only queue their events
+	for i := range maxBatch + 1 {
+		r.Record(context.Background(), pb.CommandService_CancelCommand_FullMethodName,
+			&pb.CancelCommandRequest{CommandId: fmt.Sprintf("c-%d", i)}, nil)
+	}
+
+	var batches [][]model.AuditEvent
+	mockPostgres.EXPECT().AppendAuditEvents(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, events []model.AuditEvent) error {
+			batches = append(batches, events)
+			return nil
+		}).Times(2)
+
+	// once stopped, Run appends what is still queued
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r.Run(ctx)
+
+	require.Len(t, batches, 2)
+	assert.Len(t, batches[0], maxBatch)
+	assert.Equal(t, "command_id=c-0", batches[0][0].Target)
+	require.Len(t, batches[1], 1)
+	assert.Equal(t, fmt.Sprintf("command_id=c-%d", maxBatch), batches[1][0].Target)
+}
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"router-manager/internal/model"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// BootstrapSubject is the principal of the bootstrap API key.
+const BootstrapSubject = "bootstrap"
+
+// KeyStore finds API keys by the hash of their secret.
+type KeyStore interface {
+	GetApiKeyByHash(ctx context.Context, hash string) (*model.ApiKey, error)
+}
+
+// Authenticator authenticates the callers of the gRPC methods, with an API
+// key (x-api-key metadata or "authorization: ApiKey <key>") or a JWT
+// ("authorization: Bearer <token>"), and puts the principal in the context.
+type Authenticator struct {
+	keys KeyStore
+	// nil: JWTs are not accepted
+	jwks *JWKS
+	// hash of the API key configured at startup, "" = none
+	bootstrapHash string
+	// methods that are called without operator credentials
+	public map[string]bool
+}
+
+// Option configures optional Authenticator settings.
+type Option func(a *Authenticator)
+
+// WithJWKS accepts JWTs signed with the keys of the JWKS.
+func WithJWKS(jwks *JWKS) Option {
+	return func(a *Authenticator) {
+		a.jwks = jwks
+	}
+}
+
+// WithBootstrapKey accepts the key as an admin API key. It lets the first
+// keys be created, and should be unset once they are.
+func WithBootstrapKey(key string) Option {
+	return func(a *Authenticator) {
+		if key != "" {
+			a.bootstrapHash = model.HashApiKeySecret(key)
+		}
+	}
+}
+
+// WithPublicMethods lets the full gRPC methods through unauthenticated.
+func WithPublicMethods(methods ...string) Option {
+	return func(a *Authenticator) {
+		for _, method := range methods {
+			a.public[method] = true
+		}
+	}
+}
+
+func NewAuthenticator(keys KeyStore, opts ...Option) *Authenticator {
+	a := &Authenticator{
+		keys:   keys,
+		public: make(map[string]bool),
+	}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	return a
+}
+
+// UnaryInterceptor rejects calls without valid credentials with
+// Unauthenticated, except those of public methods.
+func (a *Authenticator) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if a.public[info.FullMethod] {
+			return handler(ctx, req)
+		}
+
+		principal, err := a.Authenticate(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(NewContext(ctx, principal), req)
+	}
+}
+
+// StreamInterceptor is UnaryInterceptor for streaming methods.
+func (a *Authenticator) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if a.public[info.FullMethod] {
+			return handler(srv, ss)
+		}
+
+		principal, err := a.Authenticate(ss.Context())
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &serverStream{ServerStream: ss, ctx: NewContext(ss.Context(), principal)})
+	}
+}
+
+// serverStream is a stream whose handler gets ctx as the context of the call.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// Authenticate returns the principal the credentials of the call belong to.
+// Why credentials were refused is logged, not returned to the caller.
+func (a *Authenticator) Authenticate(ctx context.Context) (*Principal, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	if key := first(md, "x-api-key"); key != "" {
+		return a.authenticateApiKey(ctx, key)
+	}
+
+	scheme, credentials, _ := strings.Cut(first(md, "authorization"), " ")
+	credentials = strings.TrimSpace(credentials)
+	switch {
+	case credentials == "":
+		return nil, status.Error(codes.Unauthenticated, "missing credentials")
+	case strings.EqualFold(scheme, "ApiKey"):
+		return a.authenticateApiKey(ctx, credentials)
+	case strings.EqualFold(scheme, "Bearer"):
+		return a.authenticateJWT(credentials)
+	}
+
+	return nil, status.Errorf(codes.Unauthenticated, "unsupported authorization scheme %q", scheme)
+}
+
+func (a *Authenticator) authenticateApiKey(ctx context.Context, secret string) (*Principal, error) {
+	hash := model.HashApiKeySecret(secret)
+
+	if a.bootstrapHash != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(a.bootstrapHash)) == 1 {
+		return &Principal{Subject: BootstrapSubject, Method: MethodApiKey, Roles: []string{RoleAdmin}}, nil
+	}
+
+	key, err := a.keys.GetApiKeyByHash(ctx, hash)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to look up API key", "error", err)
+		return nil, status.Error(codes.Unavailable, "failed to check credentials")
+	}
+	if key == nil {
+		slog.WarnContext(ctx, "Unknown API key refused")
+		return nil, status.Error(codes.Unauthenticated, "invalid credentials")
+	}
+	if !key.Active(time.Now()) {
+		slog.WarnContext(ctx, "Revoked or expired API key refused", "key_id", key.ID, "subject", key.Subject)
+		return nil, status.Error(codes.Unauthenticated, "invalid credentials")
+	}
+
+	return &Principal{
+		Subject: key.Subject,
+		Method:  MethodApiKey,
+		KeyID:   key.ID.String(),
+		Roles:   key.Roles,
+	}, nil
+}
+
+func (a *Authenticator) authenticateJWT(token string) (*Principal, error) {
+	if a.jwks == nil {
+		return nil, status.Error(codes.Unauthenticated, "bearer tokens are not accepted")
+	}
+
+	claims, err := a.jwks.Verify(token, time.Now())
+	if err != nil {
+		slog.Warn("JWT refused", "error", err)
+		return nil, status.Error(codes.Unauthenticated, "invalid credentials")
+	}
+
+	return &Principal{
+		Subject: claims.Subject,
+		Method:  MethodJWT,
+		Roles:   claims.Roles,
+	}, nil
+}
+
+func first(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"router-manager/internal/model"
+	mockspg "router-manager/internal/repository/postgres/mocks"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func setupAuthenticator(t *testing.T, opts ...Option) (*Authenticator, *mockspg.MockPostgresRepo) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockPostgres := mockspg.NewMockPostgresRepo(ctrl)
+
+	return NewAuthenticator(mockPostgres, opts...), mockPostgres
+}
+
+func withMetadata(pairs ...string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(pairs...))
+}
+
+// call runs the interceptor of a on the method and returns the principal
+// the handler got.
+func call(a *Authenticator, ctx context.Context, method string) (*Principal, error) {
+	var principal *Principal
+	_, err := a.UnaryInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method},
+		func(ctx context.Context, req any) (any, error) {
+			principal, _ = FromContext(ctx)
+			return nil, nil
+		})
+	return principal, err
+}
+
+func TestAuthenticator_ApiKey(t *testing.T) {
+	a, mockPostgres := setupAuthenticator(t)
+
+	secret, hash, err := model.NewApiKeySecret()
+	require.NoError(t, err)
+	key := &model.ApiKey{ID: uuid.New(), Subject: "deploy-bot", Roles: []string{"noc"}, KeyHash: hash}
+	mockPostgres.EXPECT().GetApiKeyByHash(gomock.Any(), hash).Return(key, nil).Times(2)
+
+	// the x-api-key header and the ApiKey scheme are the same
+	for _, ctx := range []context.Context{
+		withMetadata("x-api-key", secret),
+		withMetadata("authorization", "ApiKey "+secret),
+	} {
+		principal, err := call(a, ctx, "/proto.CommandService/SendCommand")
+
+		require.NoError(t, err)
+		assert.Equal(t, "deploy-bot", principal.Subject)
+		assert.Equal(t, MethodApiKey, principal.Method)
+		assert.Equal(t, key.ID.String(), principal.KeyID)
+		assert.True(t, principal.HasRole("noc"))
+	}
+}
+
+func TestAuthenticator_ApiKeyRefused(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Minute)
+
+	tests := []struct {
+		name string
+		key  *model.ApiKey
+		err  error
+		code codes.Code
+	}{
+		{name: "unknown", code: codes.Unauthenticated},
+		{name: "revoked", key: &model.ApiKey{Subject: "bob", RevokedAt: &past}, code: codes.Unauthenticated},
+		{name: "expired", key: &model.ApiKey{Subject: "bob", ExpiresAt: &past}, code: codes.Unauthenticated},
+		{name: "store down", err: fmt.Errorf("connection refused"), code: codes.Unavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, mockPostgres := setupAuthenticator(t)
+			mockPostgres.EXPECT().GetApiKeyByHash(gomock.Any(), gomock.Any()).Return(tt.key, tt.err)
+
+			_, err := call(a, withMetadata("x-api-key", "rmk_whatever"), "/proto.CommandService/SendCommand")
+
+			assert.Equal(t, tt.code, status.Code(err))
+		})
+	}
+}
+
+func TestAuthenticator_BootstrapKey(t *testing.T) {
+	a, _ := setupAuthenticator(t, WithBootstrapKey("let-me-in"))
+
+	principal, err := call(a, withMetadata("x-api-key", "let-me-in"), "/proto.ApiKeyService/CreateApiKey")
+
+	require.NoError(t, err)
+	assert.Equal(t, BootstrapSubject, principal.Subject)
+	assert.True(t, principal.HasRole(RoleAdmin))
+}
+
+func TestAuthenticator_JWT(t *testing.T) {
+	keys := newTestKeys(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	keys.writeJWKS(t, path)
+	jwks, err := LoadJWKS(path, "", "router-manager")
+	require.NoError(t, err)
+
+	a, _ := setupAuthenticator(t, WithJWKS(jwks))
+
+	token := keys.sign(t, "ES256", "ec", validClaims())
+	principal, err := call(a, withMetadata("authorization", "Bearer "+token), "/proto.CommandService/ListCommands")
+
+	require.NoError(t, err)
+	assert.Equal(t, "alice", principal.Subject)
+	assert.Equal(t, MethodJWT, principal.Method)
+	assert.Equal(t, []string{"noc"}, principal.Roles)
+
+	_, err = call(a, withMetadata("authorization", "Bearer "+token+"x"), "/proto.CommandService/ListCommands")
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestAuthenticator_NoJWKS(t *testing.T) {
+	a, _ := setupAuthenticator(t)
+
+	_, err := call(a, withMetadata("authorization", "Bearer some.jwt.token"), "/proto.CommandService/ListCommands")
+
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestAuthenticator_MissingCredentials(t *testing.T) {
+	a, _ := setupAuthenticator(t)
+
+	_, err := call(a, context.Background(), "/proto.CommandService/SendCommand")
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = call(a, withMetadata("authorization", "Basic YWxpY2U6c2VjcmV0"), "/proto.CommandService/SendCommand")
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// x-caller-id proves nothing
+	_, err = call(a, withMetadata("x-caller-id", "alice"), "/proto.CommandService/SendCommand")
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestAuthenticator_PublicMethods(t *testing.T) {
+	a, _ := setupAuthenticator(t, WithPublicMethods("/proto.CommandService/PollCommands"))
+
+	principal, err := call(a, context.Background(), "/proto.CommandService/PollCommands")
+
+	require.NoError(t, err)
+	assert.Nil(t, principal)
+}
+
+// stream is a server stream carrying ctx.
+type stream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *stream) Context() context.Context { return s.ctx }
+
+func TestAuthenticator_Streams(t *testing.T) {
+	a, _ := setupAuthenticator(t, WithBootstrapKey("let-me-in"))
+	method := "/proto.AuditService/ExportAuditEvents"
+
+	var principal *Principal
+	handler := func(srv any, ss grpc.ServerStream) error {
+		principal, _ = FromContext(ss.Context())
+		return nil
+	}
+
+	err := a.StreamInterceptor()(nil, &stream{ctx: withMetadata("x-api-key", "let-me-in")},
+		&grpc.StreamServerInfo{FullMethod: method}, handler)
+	require.NoError(t, err)
+	assert.Equal(t, BootstrapSubject, principal.Subject)
+
+	err = a.StreamInterceptor()(nil, &stream{ctx: context.Background()},
+		&grpc.StreamServerInfo{FullMethod: method}, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
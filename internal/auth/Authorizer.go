@@ -0,0 +1,366 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"router-manager/internal/model"
+	"router-manager/internal/pb"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// PolicyStore holds the roles, and the commands and routers calls may name
+// by id only.
+type PolicyStore interface {
+	ListRoles(ctx context.Context) ([]model.Role, error)
+	GetCommandById(ctx context.Context, id uuid.UUID) (*model.Command, error)
+	FindRouterByRouterId(ctx context.Context, id string) (*model.Router, error)
+}
+
+// Authorizer checks the permissions of the roles of the principal against
+// the method and what the call acts on: its command types and routers.
+// Roles are cached for refreshInterval, so changes take up to that long to
+// apply.
+type Authorizer struct {
+	store           PolicyStore
+	refreshInterval time.Duration
+
+	mu     sync.Mutex
+	roles  map[string]model.Role
+	loaded time.Time
+}
+
+func NewAuthorizer(store PolicyStore, refreshInterval time.Duration) *Authorizer {
+	return &Authorizer{
+		store:           store,
+		refreshInterval: refreshInterval,
+	}
+}
+
+// UnaryInterceptor rejects the calls the principal isn't allowed to make
+// with PermissionDenied. It must run after the Authenticator's.
+func (a *Authorizer) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		principal, ok := FromContext(ctx)
+		if !ok {
+			// only public methods get past the Authenticator without one
+			return handler(ctx, req)
+		}
+
+		if err := a.Authorize(ctx, principal, info.FullMethod, req); err != nil {
+			return nil, err
+		}
+
+		granted, err := a.withGrants(ctx, principal)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load roles", "error", err)
+			return nil, status.Error(codes.Unavailable, "failed to check permissions")
+		}
+
+		return handler(granted, req)
+	}
+}
+
+// StreamInterceptor is UnaryInterceptor for streaming methods. The request
+// isn't known yet when the stream starts, so permissions must cover any
+// command type and router.
+func (a *Authorizer) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+		principal, ok := FromContext(ctx)
+		if !ok {
+			return handler(srv, ss)
+		}
+
+		if err := a.Authorize(ctx, principal, info.FullMethod, nil); err != nil {
+			return err
+		}
+
+		granted, err := a.withGrants(ctx, principal)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load roles", "error", err)
+			return status.Error(codes.Unavailable, "failed to check permissions")
+		}
+
+		return handler(srv, &serverStream{ServerStream: ss, ctx: granted})
+	}
+}
+
+type grantsKey struct{}
+
+// grants are what the authorized principal of a call may do.
+type grants struct {
+	admin       bool
+	permissions []model.Permission
+}
+
+// withGrants returns a copy of ctx carrying the permissions of the
+// principal, for Allowed.
+func (a *Authorizer) withGrants(ctx context.Context, principal *Principal) (context.Context, error) {
+	g := &grants{admin: principal.HasRole(RoleAdmin)}
+	if !g.admin {
+		permissions, err := a.permissions(ctx, principal.Roles)
+		if err != nil {
+			return nil, err
+		}
+		g.permissions = permissions
+	}
+	return context.WithValue(ctx, grantsKey{}, g), nil
+}
+
+// Allowed reports whether the principal of the call may do the action, a
+// method or model.RevealPayloads, for a command type on the router with the
+// serial number, "" if it isn't known. Calls the Authorizer didn't check
+// are allowed nothing.
+func Allowed(ctx context.Context, action, commandType, serial string) bool {
+	g, ok := ctx.Value(grantsKey{}).(*grants)
+	if !ok {
+		return false
+	}
+	if g.admin {
+		return true
+	}
+
+	if serial == "" {
+		serial = model.AnyTarget
+	}
+	return slices.ContainsFunc(g.permissions, func(p model.Permission) bool {
+		return p.Allows(action, commandType, serial)
+	})
+}
+
+// Authorize returns nil if the permissions of the principal cover the call,
+// or a PermissionDenied error that tells what isn't covered.
+func (a *Authorizer) Authorize(ctx context.Context, principal *Principal, fullMethod string, req any) error {
+	method := MethodName(fullMethod)
+
+	// admins don't depend on the stored roles, so they can fix them
+	if principal.HasRole(RoleAdmin) {
+		return nil
+	}
+
+	permissions, err := a.permissions(ctx, principal.Roles)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load roles", "error", err)
+		return status.Error(codes.Unavailable, "failed to check permissions")
+	}
+
+	allowed := slices.ContainsFunc(permissions, func(p model.Permission) bool {
+		return p.AllowsMethod(method)
+	})
+	if !allowed {
+		return status.Errorf(codes.PermissionDenied, "%s may not call %s", principal.Subject, method)
+	}
+
+	target, err := a.target(ctx, req)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to resolve target", "method", method, "error", err)
+		return status.Error(codes.Unavailable, "failed to check permissions")
+	}
+
+	for _, commandType := range target.commandTypes {
+		for _, router := range target.routers {
+			covered := slices.ContainsFunc(permissions, func(p model.Permission) bool {
+				return p.Allows(method, commandType, router.serialPrefix)
+			})
+			if !covered {
+				return status.Errorf(codes.PermissionDenied, "%s may not call %s for %s on %s",
+					principal.Subject, method, describeCommandType(commandType), router)
+			}
+		}
+	}
+
+	return nil
+}
+
+// permissions returns the permissions of the roles; unknown roles have none.
+func (a *Authorizer) permissions(ctx context.Context, roles []string) ([]model.Permission, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.roles == nil || time.Since(a.loaded) >= a.refreshInterval {
+		list, err := a.store.ListRoles(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		a.roles = make(map[string]model.Role, len(list))
+		for _, role := range list {
+			a.roles[role.Name] = role
+		}
+		a.loaded = time.Now()
+	}
+
+	var permissions []model.Permission
+	for _, name := range roles {
+		permissions = append(permissions, a.roles[name].Permissions...)
+	}
+	return permissions, nil
+}
+
+// callTarget is what a call acts on; model.AnyTarget stands for what the
+// call doesn't restrict.
+type callTarget struct {
+	commandTypes []string
+	routers      []routerTarget
+}
+
+// routerTarget is a router or, for prefix selectors, the routers whose
+// serial numbers start with serialPrefix.
+type routerTarget struct {
+	serialPrefix string
+	prefix       bool
+}
+
+func (r routerTarget) String() string {
+	switch {
+	case r.serialPrefix == model.AnyTarget:
+		return "any router"
+	case r.prefix:
+		return fmt.Sprintf("routers %s*", r.serialPrefix)
+	}
+	return fmt.Sprintf("router %s", r.serialPrefix)
+}
+
+func describeCommandType(commandType string) string {
+	if commandType == model.AnyTarget {
+		return "any command type"
+	}
+	return commandType
+}
+
+// target tells the command types and routers the request names. Routers and
+// commands named by id are looked up; unknown ones count as any.
+func (a *Authorizer) target(ctx context.Context, req any) (*callTarget, error) {
+	target := &callTarget{}
+	addType := func(commandType string) {
+		if !slices.Contains(target.commandTypes, commandType) {
+			target.commandTypes = append(target.commandTypes, commandType)
+		}
+	}
+	addSerial := func(serial string) {
+		target.routers = append(target.routers, routerTarget{serialPrefix: serial})
+	}
+	addRouterId := func(id string) error {
+		router, err := a.findRouter(ctx, id)
+		if err != nil {
+			return err
+		}
+		if router == nil {
+			addSerial(model.AnyTarget)
+			return nil
+		}
+		addSerial(router.SerialNumber)
+		return nil
+	}
+	addCommandId := func(id string) error {
+		commandId, err := uuid.Parse(id)
+		if err != nil {
+			return nil
+		}
+		command, err := a.store.GetCommandById(ctx, commandId)
+		if err != nil || command == nil {
+			return err
+		}
+		addType(command.CommandType)
+		return addRouterId(command.RouterID.String())
+	}
+
+	var err error
+	switch r := req.(type) {
+	case *pb.SendCommandRequest:
+		addType(r.CommandType)
+		for _, router := range r.Routers {
+			addSerial(router.SerialNumber)
+		}
+
+	case *pb.SubmitWorkflowRequest:
+		for _, step := range r.Steps {
+			addType(step.CommandType)
+		}
+		addSerial(r.SerialNumber)
+
+	case *pb.CreateCampaignRequest:
+		addType(r.CommandType)
+		switch {
+		case r.Selector == nil || r.Selector.AllRouters:
+		case r.Selector.SerialPrefix != "":
+			target.routers = append(target.routers, routerTarget{serialPrefix: r.Selector.SerialPrefix, prefix: true})
+		default:
+			for _, serial := range r.Selector.SerialNumbers {
+				addSerial(serial)
+			}
+		}
+
+	case *pb.ListCommandsRequest:
+		if r.CommandType != "" {
+			addType(r.CommandType)
+		}
+		if r.SerialNumber != "" {
+			addSerial(r.SerialNumber)
+		} else if r.RouterId != "" {
+			err = addRouterId(r.RouterId)
+		}
+
+	case *pb.CancelCommandsRequest:
+		if r.CommandType != "" {
+			addType(r.CommandType)
+		}
+		if r.RouterId != "" {
+			err = addRouterId(r.RouterId)
+		}
+
+	case *pb.IssueRouterCredentialRequest:
+		if r.SerialNumber != "" {
+			addSerial(r.SerialNumber)
+		} else {
+			err = addRouterId(r.RouterId)
+		}
+
+	case *pb.RevokeRouterCredentialRequest:
+		err = addRouterId(r.RouterId)
+
+	case *pb.GetCommandRequest:
+		err = addCommandId(r.CommandId)
+
+	case *pb.CancelCommandRequest:
+		err = addCommandId(r.CommandId)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(target.commandTypes) == 0 {
+		target.commandTypes = []string{model.AnyTarget}
+	}
+	if len(target.routers) == 0 {
+		addSerial(model.AnyTarget)
+	}
+	return target, nil
+}
+
+func (a *Authorizer) findRouter(ctx context.Context, id string) (*model.Router, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		// the handler rejects the request
+		return nil, nil
+	}
+	return a.store.FindRouterByRouterId(ctx, id)
+}
+
+// MethodName turns "/proto.CommandService/SendCommand" into
+// "CommandService.SendCommand".
+func MethodName(fullMethod string) string {
+	service, method, _ := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if i := strings.LastIndex(service, "."); i >= 0 {
+		service = service[i+1:]
+	}
+	return service + "." + method
+}
@@ -0,0 +1,288 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"router-manager/internal/model"
+	"router-manager/internal/pb"
+	mockspg "router-manager/internal/repository/postgres/mocks"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// testRoles lets helpdesk reboot the routers of its region, noc read
+// everything, and wifi-support see the Wi-Fi passwords of its region.
+var testRoles = []model.Role{
+	{Name: "helpdesk", Permissions: []model.Permission{
+		{Methods: []string{"CommandService.SendCommand", "CommandService.GetCommand"},
+			CommandTypes: []string{"REBOOT"}, SerialPrefixes: []string{"MSK-"}},
+		{Methods: []string{"CommandService.ListCommands"}, SerialPrefixes: []string{"MSK-"}},
+	}},
+	{Name: "noc", Permissions: []model.Permission{
+		{Methods: []string{"CommandService.GetCommand", "CommandService.ListCommands", "CampaignService.*"}},
+	}},
+	{Name: "wifi-support", Permissions: []model.Permission{
+		{Methods: []string{"CommandService.GetCommand", model.RevealPayloads},
+			CommandTypes: []string{"SET_WIFI_PASSWORD"}, SerialPrefixes: []string{"MSK-"}},
+	}},
+}
+
+func setupAuthorizer(t *testing.T) (*Authorizer, *mockspg.MockPostgresRepo) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockPostgres := mockspg.NewMockPostgresRepo(ctrl)
+	mockPostgres.EXPECT().ListRoles(gomock.Any()).Return(testRoles, nil).AnyTimes()
+
+	return NewAuthorizer(mockPostgres, time.Minute), mockPostgres
+}
+
+func principal(roles ...string) *Principal {
+	return &Principal{Subject: "alice", Roles: roles}
+}
+
+func TestAuthorizer_Authorize(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal *Principal
+		method    string
+		req       any
+		denied    string
+	}{
+		{
+			name:      "reboot in region",
+			principal: principal("helpdesk"),
+			method:    pb.CommandService_SendCommand_FullMethodName,
+			req: &pb.SendCommandRequest{CommandType: "REBOOT", Routers: []*pb.Router{
+				{SerialNumber: "MSK-001"}, {SerialNumber: "MSK-002"},
+			}},
+		},
+		{
+			name:      "reboot out of region",
+			principal: principal("helpdesk"),
+			method:    pb.CommandService_SendCommand_FullMethodName,
+			req: &pb.SendCommandRequest{CommandType: "REBOOT", Routers: []*pb.Router{
+				{SerialNumber: "MSK-001"}, {SerialNumber: "SPB-001"},
+			}},
+			denied: "alice may not call CommandService.SendCommand for REBOOT on router SPB-001",
+		},
+		{
+			name:      "other command type",
+			principal: principal("helpdesk"),
+			method:    pb.CommandService_SendCommand_FullMethodName,
+			req: &pb.SendCommandRequest{CommandType: "FACTORY_RESET", Routers: []*pb.Router{
+				{SerialNumber: "MSK-001"},
+			}},
+			denied: "alice may not call CommandService.SendCommand for FACTORY_RESET on router MSK-001",
+		},
+		{
+			name:      "method not granted",
+			principal: principal("noc"),
+			method:    pb.CommandService_SendCommand_FullMethodName,
+			req:       &pb.SendCommandRequest{CommandType: "REBOOT"},
+			denied:    "alice may not call CommandService.SendCommand",
+		},
+		{
+			name:      "service wildcard",
+			principal: principal("noc"),
+			method:    pb.CampaignService_CreateCampaign_FullMethodName,
+			req: &pb.CreateCampaignRequest{CommandType: "REBOOT",
+				Selector: &pb.RouterSelector{AllRouters: true}},
+		},
+		{
+			name:      "listing every router",
+			principal: principal("helpdesk"),
+			method:    pb.CommandService_ListCommands_FullMethodName,
+			req:       &pb.ListCommandsRequest{},
+			denied:    "alice may not call CommandService.ListCommands for any command type on any router",
+		},
+		{
+			name:      "listing a router in region",
+			principal: principal("helpdesk"),
+			method:    pb.CommandService_ListCommands_FullMethodName,
+			req:       &pb.ListCommandsRequest{SerialNumber: "MSK-001", CommandType: "UPDATE_FIRMWARE"},
+		},
+		{
+			name:      "roles add up",
+			principal: principal("helpdesk", "noc"),
+			method:    pb.CommandService_ListCommands_FullMethodName,
+			req:       &pb.ListCommandsRequest{},
+		},
+		{
+			name:      "unknown role",
+			principal: principal("auditor"),
+			method:    pb.CommandService_ListCommands_FullMethodName,
+			req:       &pb.ListCommandsRequest{},
+			denied:    "alice may not call CommandService.ListCommands",
+		},
+		{
+			name:      "admin",
+			principal: principal(RoleAdmin),
+			method:    pb.RoleService_PutRole_FullMethodName,
+			req:       &pb.PutRoleRequest{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := setupAuthorizer(t)
+
+			err := a.Authorize(context.Background(), tt.principal, tt.method, tt.req)
+
+			if tt.denied == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Equal(t, codes.PermissionDenied, status.Code(err))
+			assert.Equal(t, tt.denied, status.Convert(err).Message())
+		})
+	}
+}
+
+func TestAuthorizer_CampaignSelector(t *testing.T) {
+	a, _ := setupAuthorizer(t)
+	roles := []model.Role{{Name: "regional", Permissions: []model.Permission{
+		{Methods: []string{"CampaignService.CreateCampaign"}, SerialPrefixes: []string{"MSK-"}},
+	}}}
+	a.roles = map[string]model.Role{"regional": roles[0]}
+	a.loaded = time.Now()
+
+	create := func(selector *pb.RouterSelector) error {
+		return a.Authorize(context.Background(), principal("regional"), pb.CampaignService_CreateCampaign_FullMethodName,
+			&pb.CreateCampaignRequest{CommandType: "REBOOT", Selector: selector})
+	}
+
+	assert.NoError(t, create(&pb.RouterSelector{SerialPrefix: "MSK-1"}))
+	assert.NoError(t, create(&pb.RouterSelector{SerialNumbers: []string{"MSK-1", "MSK-2"}}))
+
+	err := create(&pb.RouterSelector{SerialPrefix: "M"})
+	assert.Equal(t, "alice may not call CampaignService.CreateCampaign for REBOOT on routers M*", status.Convert(err).Message())
+
+	err = create(&pb.RouterSelector{AllRouters: true})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestAuthorizer_LooksUpCommands(t *testing.T) {
+	a, mockPostgres := setupAuthorizer(t)
+
+	routerId := uuid.New()
+	inRegion := &model.Command{ID: uuid.New(), RouterID: routerId, CommandType: "REBOOT"}
+	mockPostgres.EXPECT().GetCommandById(gomock.Any(), inRegion.ID).Return(inRegion, nil)
+	mockPostgres.EXPECT().FindRouterByRouterId(gomock.Any(), routerId.String()).
+		Return(&model.Router{ID: routerId, SerialNumber: "MSK-001"}, nil)
+
+	err := a.Authorize(context.Background(), principal("helpdesk"), pb.CommandService_GetCommand_FullMethodName,
+		&pb.GetCommandRequest{CommandId: inRegion.ID.String()})
+	assert.NoError(t, err)
+
+	otherType := &model.Command{ID: uuid.New(), RouterID: routerId, CommandType: "FACTORY_RESET"}
+	mockPostgres.EXPECT().GetCommandById(gomock.Any(), otherType.ID).Return(otherType, nil)
+	mockPostgres.EXPECT().FindRouterByRouterId(gomock.Any(), routerId.String()).
+		Return(&model.Router{ID: routerId, SerialNumber: "MSK-001"}, nil)
+
+	err = a.Authorize(context.Background(), principal("helpdesk"), pb.CommandService_GetCommand_FullMethodName,
+		&pb.GetCommandRequest{CommandId: otherType.ID.String()})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestAuthorizer_StoreDown(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockPostgres := mockspg.NewMockPostgresRepo(ctrl)
+	mockPostgres.EXPECT().ListRoles(gomock.Any()).Return(nil, fmt.Errorf("connection refused"))
+
+	a := NewAuthorizer(mockPostgres, time.Minute)
+	err := a.Authorize(context.Background(), principal("noc"), pb.CommandService_ListCommands_FullMethodName,
+		&pb.ListCommandsRequest{})
+
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestAuthorizer_CachesRoles(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockPostgres := mockspg.NewMockPostgresRepo(ctrl)
+	mockPostgres.EXPECT().ListRoles(gomock.Any()).Return(testRoles, nil).Times(1)
+
+	a := NewAuthorizer(mockPostgres, time.Minute)
+	for range 3 {
+		err := a.Authorize(context.Background(), principal("noc"), pb.CommandService_ListCommands_FullMethodName,
+			&pb.ListCommandsRequest{})
+		require.NoError(t, err)
+	}
+
+	// a role change applies once the cache is stale
+	mockPostgres.EXPECT().ListRoles(gomock.Any()).Return(nil, nil)
+	a.loaded = time.Now().Add(-time.Minute)
+
+	err := a.Authorize(context.Background(), principal("noc"), pb.CommandService_ListCommands_FullMethodName,
+		&pb.ListCommandsRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestAuthorizer_UnaryInterceptor(t *testing.T) {
+	a, _ := setupAuthorizer(t)
+	handled := false
+	handler := func(ctx context.Context, req any) (any, error) {
+		handled = true
+		return nil, nil
+	}
+
+	// public methods have no principal
+	_, err := a.UnaryInterceptor()(context.Background(), &pb.PollRequest{},
+		&grpc.UnaryServerInfo{FullMethod: pb.CommandService_PollCommands_FullMethodName}, handler)
+	require.NoError(t, err)
+	assert.True(t, handled)
+
+	handled = false
+	ctx := NewContext(context.Background(), principal("noc"))
+	_, err = a.UnaryInterceptor()(ctx, &pb.SendCommandRequest{CommandType: "REBOOT"},
+		&grpc.UnaryServerInfo{FullMethod: pb.CommandService_SendCommand_FullMethodName}, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.False(t, handled)
+}
+
+func TestAllowed(t *testing.T) {
+	a, mockPostgres := setupAuthorizer(t)
+
+	routerId := uuid.New()
+	command := &model.Command{ID: uuid.New(), RouterID: routerId, CommandType: "SET_WIFI_PASSWORD"}
+	mockPostgres.EXPECT().GetCommandById(gomock.Any(), command.ID).Return(command, nil).AnyTimes()
+	mockPostgres.EXPECT().FindRouterByRouterId(gomock.Any(), routerId.String()).
+		Return(&model.Router{ID: routerId, SerialNumber: "MSK-001"}, nil).AnyTimes()
+
+	// grants are what the interceptor hands to the handler
+	grantsOf := func(p *Principal) context.Context {
+		var granted context.Context
+		handler := func(ctx context.Context, req any) (any, error) {
+			granted = ctx
+			return nil, nil
+		}
+		_, err := a.UnaryInterceptor()(NewContext(context.Background(), p), &pb.GetCommandRequest{CommandId: command.ID.String()},
+			&grpc.UnaryServerInfo{FullMethod: pb.CommandService_GetCommand_FullMethodName}, handler)
+		require.NoError(t, err)
+		return granted
+	}
+
+	support := grantsOf(principal("wifi-support"))
+	assert.True(t, Allowed(support, model.RevealPayloads, "SET_WIFI_PASSWORD", "MSK-1"))
+	assert.False(t, Allowed(support, model.RevealPayloads, "SET_WIFI_PASSWORD", "SPB-1"))
+	assert.False(t, Allowed(support, model.RevealPayloads, "SET_WIFI_PASSWORD", ""))
+	assert.False(t, Allowed(support, model.RevealPayloads, "REBOOT", "MSK-1"))
+
+	// noc may read the command, but not its payload
+	noc := grantsOf(principal("noc"))
+	assert.False(t, Allowed(noc, model.RevealPayloads, "SET_WIFI_PASSWORD", "MSK-1"))
+
+	admin := grantsOf(principal(RoleAdmin))
+	assert.True(t, Allowed(admin, model.RevealPayloads, "SET_WIFI_PASSWORD", ""))
+
+	// calls the Authorizer didn't check
+	assert.False(t, Allowed(NewContext(context.Background(), principal(RoleAdmin)), model.RevealPayloads, "SET_WIFI_PASSWORD", ""))
+}
@@ -0,0 +1,382 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long JWKS trusts its file before checking whether it changed
+const jwksCheckInterval = 30 * time.Second
+
+// tolerated clock skew between the token issuer and this service
+const clockSkew = 30 * time.Second
+
+// Claims are the JWT claims the service uses.
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+	Roles     []string `json:"roles"`
+}
+
+// audience is the aud claim: a single string or a list of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("aud must be a string or a list of strings")
+	}
+	*a = list
+	return nil
+}
+
+// JWKS verifies JWTs against the public keys of a JWKS file. The file is
+// read again when it changes, so that keys can be rotated without a restart.
+// RS256, ES256, ES384 and EdDSA (Ed25519) signatures are accepted.
+type JWKS struct {
+	path string
+	// required iss and aud claims, "" = not checked
+	issuer   string
+	audience string
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	modTime time.Time
+	checked time.Time
+}
+
+// LoadJWKS reads the JWKS file.
+func LoadJWKS(path, issuer, audience string) (*JWKS, error) {
+	j := &JWKS{path: path, issuer: issuer, audience: audience}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := j.load(info.ModTime()); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *JWKS) load(modTime time.Time) error {
+	data, err := os.ReadFile(j.path)
+	if err != nil {
+		return err
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("invalid JWKS %s: %w", j.path, err)
+	}
+
+	j.keys = keys
+	j.modTime = modTime
+	return nil
+}
+
+// refresh reloads the file if it changed since it was last read. A file that
+// can't be read keeps the current keys in use.
+func (j *JWKS) refresh(now time.Time) {
+	if now.Sub(j.checked) < jwksCheckInterval {
+		return
+	}
+	j.checked = now
+
+	info, err := os.Stat(j.path)
+	if err != nil {
+		slog.Warn("Failed to check JWKS", "path", j.path, "error", err)
+		return
+	}
+	if info.ModTime().Equal(j.modTime) {
+		return
+	}
+
+	if err := j.load(info.ModTime()); err != nil {
+		slog.Warn("Keeping previous JWKS keys", "path", j.path, "error", err)
+		return
+	}
+	slog.Info("JWKS reloaded", "path", j.path, "keys", len(j.keys))
+}
+
+// key returns the key a token signed with kid must be verified with.
+func (j *JWKS) key(kid string, now time.Time) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.refresh(now)
+
+	if kid == "" {
+		// tokens without kid are only accepted while there is one key
+		if len(j.keys) != 1 {
+			return nil, fmt.Errorf("token has no kid")
+		}
+		for _, key := range j.keys {
+			return key, nil
+		}
+	}
+
+	key, ok := j.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	return key, nil
+}
+
+// Verify checks the signature and the claims of a compact JWT and returns
+// its claims.
+func (j *JWKS) Verify(token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid header: %w", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding: %w", err)
+	}
+
+	key, err := j.key(header.Kid, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid claims: %w", err)
+	}
+	if err := j.checkClaims(&claims, now); err != nil {
+		return nil, err
+	}
+
+	return &claims, nil
+}
+
+func (j *JWKS) checkClaims(claims *Claims, now time.Time) error {
+	if claims.Subject == "" {
+		return fmt.Errorf("token has no sub")
+	}
+	if claims.ExpiresAt == 0 {
+		return fmt.Errorf("token has no exp")
+	}
+	if !now.Before(time.Unix(claims.ExpiresAt, 0).Add(clockSkew)) {
+		return fmt.Errorf("token expired")
+	}
+	if claims.NotBefore != 0 && now.Add(clockSkew).Before(time.Unix(claims.NotBefore, 0)) {
+		return fmt.Errorf("token not valid yet")
+	}
+	if j.issuer != "" && claims.Issuer != j.issuer {
+		return fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	if j.audience != "" && !slices.Contains(claims.Audience, j.audience) {
+		return fmt.Errorf("token is not meant for %q", j.audience)
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// verifySignature checks the signature with the key, which must be of the
+// type alg names; "none" and HMAC algorithms are never accepted.
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key doesn't match alg %s", alg)
+		}
+		digest := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature); err != nil {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+
+	case "ES256", "ES384":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key doesn't match alg %s", alg)
+		}
+		var digest []byte
+		if alg == "ES256" {
+			if pub.Curve != elliptic.P256() {
+				return fmt.Errorf("key doesn't match alg %s", alg)
+			}
+			sum := sha256.Sum256(signed)
+			digest = sum[:]
+		} else {
+			if pub.Curve != elliptic.P384() {
+				return fmt.Errorf("key doesn't match alg %s", alg)
+			}
+			sum := sha512.Sum384(signed)
+			digest = sum[:]
+		}
+
+		// JWS signatures are r || s, each the size of the curve
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+
+	case "EdDSA":
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("key doesn't match alg %s", alg)
+		}
+		if !ed25519.Verify(pub, signed, signature) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported alg %q", alg)
+}
+
+// jwk is a public key of a JWKS, RFC 7517.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS returns the signature keys of a JWKS by kid.
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no signature keys")
+	}
+	return keys, nil
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid n: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, fmt.Errorf("invalid e")
+		}
+		if n.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA keys must have at least 2048 bits")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x: %w", err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y: %w", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid x")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("unsupported kty %q", k.Kty)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty value")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testKeys are the signing keys behind a JWKS file.
+type testKeys struct {
+	rsa     *rsa.PrivateKey
+	ecdsa   *ecdsa.PrivateKey
+	ed25519 ed25519.PrivateKey
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	return &testKeys{rsa: rsaKey, ecdsa: ecKey, ed25519: edKey}
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// writeJWKS writes the public keys as "rsa", "ec" and "ed" to a JWKS file.
+func (k *testKeys) writeJWKS(t *testing.T, path string) {
+	set := map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "use": "sig",
+			"n": b64(k.rsa.N.Bytes()), "e": b64(big.NewInt(int64(k.rsa.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256",
+			"x": b64(k.ecdsa.X.FillBytes(make([]byte, 32))), "y": b64(k.ecdsa.Y.FillBytes(make([]byte, 32)))},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519",
+			"x": b64(k.ed25519.Public().(ed25519.PublicKey))},
+	}}
+
+	data, err := json.Marshal(set)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+}
+
+// sign returns a JWT with the claims signed by the key kid stands for.
+func (k *testKeys) sign(t *testing.T, alg, kid string, claims map[string]any) string {
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch alg {
+	case "RS256":
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, k.ecdsa, digest[:])
+		require.NoError(t, err)
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case "EdDSA":
+		signature = ed25519.Sign(k.ed25519, []byte(signed))
+	}
+
+	return signed + "." + b64(signature)
+}
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"sub":   "alice",
+		"iss":   "https://idp.example.com",
+		"aud":   []string{"router-manager", "other"},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"roles": []string{"noc"},
+	}
+}
+
+func setupJWKS(t *testing.T) (*JWKS, *testKeys, string) {
+	keys := newTestKeys(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	keys.writeJWKS(t, path)
+
+	jwks, err := LoadJWKS(path, "https://idp.example.com", "router-manager")
+	require.NoError(t, err)
+
+	return jwks, keys, path
+}
+
+func TestJWKS_Verify(t *testing.T) {
+	jwks, keys, _ := setupJWKS(t)
+
+	for _, tt := range []struct{ alg, kid string }{{"RS256", "rsa"}, {"ES256", "ec"}, {"EdDSA", "ed"}} {
+		t.Run(tt.alg, func(t *testing.T) {
+			claims, err := jwks.Verify(keys.sign(t, tt.alg, tt.kid, validClaims()), time.Now())
+
+			require.NoError(t, err)
+			assert.Equal(t, "alice", claims.Subject)
+			assert.Equal(t, []string{"noc"}, claims.Roles)
+		})
+	}
+}
+
+func TestJWKS_Refused(t *testing.T) {
+	jwks, keys, _ := setupJWKS(t)
+
+	tests := []struct {
+		name  string
+		token func() string
+	}{
+		{"expired", func() string {
+			claims := validClaims()
+			claims["exp"] = time.Now().Add(-time.Hour).Unix()
+			return keys.sign(t, "RS256", "rsa", claims)
+		}},
+		{"no exp", func() string {
+			claims := validClaims()
+			delete(claims, "exp")
+			return keys.sign(t, "RS256", "rsa", claims)
+		}},
+		{"not valid yet", func() string {
+			claims := validClaims()
+			claims["nbf"] = time.Now().Add(time.Hour).Unix()
+			return keys.sign(t, "RS256", "rsa", claims)
+		}},
+		{"other audience", func() string {
+			claims := validClaims()
+			claims["aud"] = "billing"
+			return keys.sign(t, "RS256", "rsa", claims)
+		}},
+		{"other issuer", func() string {
+			claims := validClaims()
+			claims["iss"] = "https://evil.example.com"
+			return keys.sign(t, "RS256", "rsa", claims)
+		}},
+		{"no subject", func() string {
+			claims := validClaims()
+			delete(claims, "sub")
+			return keys.sign(t, "RS256", "rsa", claims)
+		}},
+		{"unknown kid", func() string {
+			return keys.sign(t, "RS256", "gone", validClaims())
+		}},
+		{"alg of another key", func() string {
+			return keys.sign(t, "ES256", "rsa", validClaims())
+		}},
+		{"alg none", func() string {
+			token := keys.sign(t, "RS256", "rsa", validClaims())
+			header := b64([]byte(`{"alg":"none","kid":"rsa"}`))
+			return header + token[len(b64([]byte(`{"alg":"RS256","kid":"rsa","typ":"JWT"}`))):]
+		}},
+		{"tampered claims", func() string {
+			token := keys.sign(t, "EdDSA", "ed", validClaims())
+			claims := validClaims()
+			claims["sub"] = "mallory"
+			forged := keys.sign(t, "EdDSA", "ed", claims)
+			// the claims of one token with the signature of the other
+			return forged[:len(forged)-86] + token[len(token)-86:]
+		}},
+		{"malformed", func() string {
+			return "not-a-jwt"
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := jwks.Verify(tt.token(), time.Now())
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestJWKS_ReloadsChangedFile(t *testing.T) {
+	jwks, _, path := setupJWKS(t)
+
+	// the keys are rotated
+	rotated := newTestKeys(t)
+	rotated.writeJWKS(t, path)
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, later, later))
+
+	token := rotated.sign(t, "RS256", "rsa", validClaims())
+
+	// the file is only checked every jwksCheckInterval
+	jwks.checked = time.Now()
+	_, err := jwks.Verify(token, time.Now())
+	assert.Error(t, err)
+
+	_, err = jwks.Verify(token, time.Now().Add(jwksCheckInterval))
+	assert.NoError(t, err)
+}
+
+func TestLoadJWKS_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"keys": [{"kty": "oct", "kid": "hmac", "k": "c2VjcmV0"}]}`), 0o600))
+
+	_, err := LoadJWKS(path, "", "")
+	assert.Error(t, err)
+
+	_, err = LoadJWKS(filepath.Join(t.TempDir(), "missing.json"), "", "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// SourceIP is the address of the client. REST clients come through the
+// gateway, which appends theirs to x-forwarded-for; the header is only
+// trusted from it, and only its last address, which a client can't set.
+func SourceIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	var ip string
+	if p.Addr.Network() != "unix" {
+		ip = p.Addr.String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+		if parsed := net.ParseIP(ip); parsed == nil || !parsed.IsLoopback() {
+			return ip
+		}
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("x-forwarded-for"); len(values) > 0 {
+			forwarded := strings.Split(values[len(values)-1], ",")
+			if last := strings.TrimSpace(forwarded[len(forwarded)-1]); last != "" {
+				return last
+			}
+		}
+	}
+	if ip == "" {
+		return "local"
+	}
+	return ip
+}
@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestSourceIP(t *testing.T) {
+	withPeer := func(addr net.Addr, forwarded ...string) context.Context {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+		if len(forwarded) > 0 {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-forwarded-for", forwarded[0]))
+		}
+		return ctx
+	}
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.7"), Port: 40000}
+	loopback := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 40000}
+	unix := &net.UnixAddr{Name: "/run/router-manager.sock", Net: "unix"}
+
+	assert.Equal(t, "10.0.0.7", SourceIP(withPeer(remote)))
+	// only the gateway may forward addresses, and only its own entry counts
+	assert.Equal(t, "10.0.0.7", SourceIP(withPeer(remote, "192.0.2.1")))
+	assert.Equal(t, "192.0.2.9", SourceIP(withPeer(loopback, "192.0.2.1, 192.0.2.9")))
+	assert.Equal(t, "192.0.2.9", SourceIP(withPeer(unix, "192.0.2.9")))
+	assert.Equal(t, "127.0.0.1", SourceIP(withPeer(loopback)))
+	assert.Equal(t, "local", SourceIP(withPeer(unix)))
+	assert.Equal(t, "", SourceIP(context.Background()))
+}
@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"context"
+	"slices"
+)
+
+// RoleAdmin is the built-in role that may do anything, manage API keys and
+// roles included.
+const RoleAdmin = "admin"
+
+// how a principal authenticated
+const (
+	MethodApiKey = "api_key"
+	MethodJWT    = "jwt"
+)
+
+// Principal is the authenticated operator or automation behind a call.
+type Principal struct {
+	// Subject names the principal in audit records, approvals and jobs
+	Subject string
+	// Method is MethodApiKey or MethodJWT
+	Method string
+	// KeyID is the id of the API key, empty for JWTs
+	KeyID string
+	Roles []string
+}
+
+// HasRole reports whether the principal was granted the role.
+func (p *Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+type principalKey struct{}
+
+// NewContext returns a copy of ctx carrying the principal.
+func NewContext(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the principal of the call, if it was authenticated.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok
+}
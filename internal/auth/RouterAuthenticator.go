@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"router-manager/internal/model"
+	"strings"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// RouterStore finds router credentials by the hash of their secret, and
+// routers by id.
+type RouterStore interface {
+	GetRouterCredentialByHash(ctx context.Context, hash string) (*model.RouterCredential, error)
+	FindRouterByRouterId(ctx context.Context, id string) (*model.Router, error)
+}
+
+// routerRequest is a request of a router-facing method.
+type routerRequest interface {
+	GetRouterId() string
+	GetSerialNumber() string
+}
+
+// RouterAuthenticator authenticates routers on the router-facing methods,
+// with a device certificate verified by the mTLS listener, or else with the
+// secret issued to them (x-router-secret metadata or
+// "authorization: Router <secret>"). A router may only act as itself: the
+// router_id and serial_number of the request must be those of the
+// certificate or credential.
+type RouterAuthenticator struct {
+	routers RouterStore
+	methods map[string]bool
+}
+
+func NewRouterAuthenticator(routers RouterStore, methods ...string) *RouterAuthenticator {
+	a := &RouterAuthenticator{
+		routers: routers,
+		methods: make(map[string]bool),
+	}
+	for _, method := range methods {
+		a.methods[method] = true
+	}
+	return a
+}
+
+// UnaryInterceptor rejects calls of the router-facing methods without a
+// device certificate or a valid credential with Unauthenticated, and calls on behalf of another
+// router with PermissionDenied; the others carry the router they were
+// authenticated as, see RouterFromContext. Other methods are let through.
+func (a *RouterAuthenticator) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !a.methods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+
+		r, ok := req.(routerRequest)
+		if !ok {
+			return nil, status.Errorf(codes.Internal, "%s is not a router method", info.FullMethod)
+		}
+
+		if serial := peerDeviceSerial(ctx); serial != "" {
+			if err := a.checkDevice(ctx, serial, r); err != nil {
+				return nil, err
+			}
+			return handler(NewRouterContext(ctx, r.GetRouterId()), req)
+		}
+
+		credential, err := a.Authenticate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if r.GetRouterId() != credential.RouterID.String() || r.GetSerialNumber() != credential.SerialNumber {
+			slog.WarnContext(ctx, "Router refused acting as another router",
+				"credential_router_id", credential.RouterID, "credential_serial_number", credential.SerialNumber,
+				"serial_number", r.GetSerialNumber())
+			return nil, status.Errorf(codes.PermissionDenied, "credential of router %s can't be used for router %s (%s)",
+				credential.SerialNumber, r.GetRouterId(), r.GetSerialNumber())
+		}
+
+		return handler(NewRouterContext(ctx, credential.RouterID.String()), req)
+	}
+}
+
+type routerKey struct{}
+
+// NewRouterContext returns a copy of ctx carrying the id of the router the
+// call was authenticated as.
+func NewRouterContext(ctx context.Context, routerId string) context.Context {
+	return context.WithValue(ctx, routerKey{}, routerId)
+}
+
+// RouterFromContext returns the id of the router the call was authenticated
+// as, if it was.
+func RouterFromContext(ctx context.Context) (string, bool) {
+	routerId, ok := ctx.Value(routerKey{}).(string)
+	return routerId, ok
+}
+
+// Authenticate returns the credential the secret of the call belongs to.
+func (a *RouterAuthenticator) Authenticate(ctx context.Context) (*model.RouterCredential, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	secret := first(md, "x-router-secret")
+	if secret == "" {
+		scheme, value, _ := strings.Cut(first(md, "authorization"), " ")
+		if strings.EqualFold(scheme, "Router") {
+			secret = strings.TrimSpace(value)
+		}
+	}
+	if secret == "" {
+		return nil, status.Error(codes.Unauthenticated, "missing router credentials")
+	}
+
+	credential, err := a.routers.GetRouterCredentialByHash(ctx, model.HashApiKeySecret(secret))
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to look up router credential", "error", err)
+		return nil, status.Error(codes.Unavailable, "failed to check credentials")
+	}
+	if credential == nil {
+		slog.WarnContext(ctx, "Unknown or revoked router secret refused")
+		return nil, status.Error(codes.Unauthenticated, "invalid credentials")
+	}
+
+	return credential, nil
+}
+
+// checkDevice refuses requests of the router with a certificate for serial
+// on behalf of another router.
+func (a *RouterAuthenticator) checkDevice(ctx context.Context, serial string, r routerRequest) error {
+	denied := status.Errorf(codes.PermissionDenied, "certificate of router %s can't be used for router %s (%s)",
+		serial, r.GetRouterId(), r.GetSerialNumber())
+
+	if r.GetSerialNumber() != serial {
+		slog.WarnContext(ctx, "Router certificate refused acting as another router", "certificate_serial", serial, "serial_number", r.GetSerialNumber())
+		return denied
+	}
+	if _, err := uuid.Parse(r.GetRouterId()); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid router_id: %v", err)
+	}
+
+	router, err := a.routers.FindRouterByRouterId(ctx, r.GetRouterId())
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to look up router", "error", err)
+		return status.Error(codes.Unavailable, "failed to check credentials")
+	}
+	if router == nil || router.SerialNumber != serial {
+		slog.WarnContext(ctx, "Router certificate refused acting as another router", "certificate_serial", serial)
+		return denied
+	}
+	return nil
+}
+
+// peerDeviceSerial returns the serial number of the client certificate the
+// TLS listener verified, "" if there is none.
+func peerDeviceSerial(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return DeviceSerial(info.State.VerifiedChains[0][0])
+}
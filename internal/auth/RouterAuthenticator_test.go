@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"router-manager/internal/model"
+	"router-manager/internal/pb"
+	mockspg "router-manager/internal/repository/postgres/mocks"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func setupRouterAuthenticator(t *testing.T) (*RouterAuthenticator, *mockspg.MockPostgresRepo) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockPostgres := mockspg.NewMockPostgresRepo(ctrl)
+
+	return NewRouterAuthenticator(mockPostgres, pb.CommandService_PollCommands_FullMethodName), mockPostgres
+}
+
+// poll runs the interceptor of a on PollCommands and reports whether the
+// handler was called, as the router of the request.
+func poll(a *RouterAuthenticator, ctx context.Context, req *pb.PollRequest) (bool, error) {
+	handled := false
+	_, err := a.UnaryInterceptor()(ctx, req, &grpc.UnaryServerInfo{FullMethod: pb.CommandService_PollCommands_FullMethodName},
+		func(ctx context.Context, _ any) (any, error) {
+			routerId, _ := RouterFromContext(ctx)
+			handled = routerId == req.RouterId
+			return nil, nil
+		})
+	return handled, err
+}
+
+func TestRouterAuthenticator(t *testing.T) {
+	a, mockPostgres := setupRouterAuthenticator(t)
+
+	secret, hash, err := model.NewRouterSecret()
+	require.NoError(t, err)
+	credential := &model.RouterCredential{RouterID: uuid.New(), SerialNumber: "SN-1", SecretHash: hash}
+	mockPostgres.EXPECT().GetRouterCredentialByHash(gomock.Any(), hash).Return(credential, nil).Times(3)
+
+	own := &pb.PollRequest{RouterId: credential.RouterID.String(), SerialNumber: "SN-1"}
+
+	// the x-router-secret header and the Router scheme are the same
+	for _, ctx := range []context.Context{
+		withMetadata("x-router-secret", secret),
+		withMetadata("authorization", "Router "+secret),
+	} {
+		handled, err := poll(a, ctx, own)
+
+		require.NoError(t, err)
+		assert.True(t, handled)
+	}
+
+	// another router's queue
+	handled, err := poll(a, withMetadata("x-router-secret", secret),
+		&pb.PollRequest{RouterId: uuid.NewString(), SerialNumber: "SN-1"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.False(t, handled)
+}
+
+func TestRouterAuthenticator_Refused(t *testing.T) {
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		credential *model.RouterCredential
+		err        error
+		code       codes.Code
+	}{
+		{name: "missing", ctx: context.Background(), code: codes.Unauthenticated},
+		{name: "operator key", ctx: withMetadata("authorization", "ApiKey rmk_whatever"), code: codes.Unauthenticated},
+		{name: "unknown or revoked", ctx: withMetadata("x-router-secret", "rms_whatever"), code: codes.Unauthenticated},
+		{name: "store down", ctx: withMetadata("x-router-secret", "rms_whatever"), err: fmt.Errorf("connection refused"), code: codes.Unavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, mockPostgres := setupRouterAuthenticator(t)
+			mockPostgres.EXPECT().GetRouterCredentialByHash(gomock.Any(), gomock.Any()).Return(tt.credential, tt.err).AnyTimes()
+
+			handled, err := poll(a, tt.ctx, &pb.PollRequest{RouterId: uuid.NewString(), SerialNumber: "SN-1"})
+
+			assert.Equal(t, tt.code, status.Code(err))
+			assert.False(t, handled)
+		})
+	}
+}
+
+func TestRouterAuthenticator_OtherMethods(t *testing.T) {
+	a, _ := setupRouterAuthenticator(t)
+
+	handled := false
+	_, err := a.UnaryInterceptor()(context.Background(), &pb.ListCommandsRequest{},
+		&grpc.UnaryServerInfo{FullMethod: pb.CommandService_ListCommands_FullMethodName},
+		func(ctx context.Context, req any) (any, error) {
+			_, authenticated := RouterFromContext(ctx)
+			handled = !authenticated
+			return nil, nil
+		})
+
+	require.NoError(t, err)
+	assert.True(t, handled)
+}
+
+func TestRouterAuthenticator_DeviceCertificate(t *testing.T) {
+	a, mockPostgres := setupRouterAuthenticator(t)
+
+	router := &model.Router{ID: uuid.New(), SerialNumber: "SN-1"}
+	other := &model.Router{ID: uuid.New(), SerialNumber: "SN-2"}
+	mockPostgres.EXPECT().
+		FindRouterByRouterId(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, id string) (*model.Router, error) {
+			for _, r := range []*model.Router{router, other} {
+				if r.ID.String() == id {
+					return r, nil
+				}
+			}
+			return nil, nil
+		}).AnyTimes()
+
+	// the TLS listener verified a certificate for SN-1
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "SN-1"}}
+	ctx := peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{
+		State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}},
+	}})
+
+	handled, err := poll(a, ctx, &pb.PollRequest{RouterId: router.ID.String(), SerialNumber: "SN-1"})
+	require.NoError(t, err)
+	assert.True(t, handled)
+
+	// another serial, or the id of another router
+	for _, req := range []*pb.PollRequest{
+		{RouterId: other.ID.String(), SerialNumber: "SN-2"},
+		{RouterId: other.ID.String(), SerialNumber: "SN-1"},
+		{RouterId: uuid.NewString(), SerialNumber: "SN-1"},
+	} {
+		handled, err := poll(a, ctx, req)
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		assert.False(t, handled)
+	}
+}
@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// how long certificates and CA bundles are trusted before checking whether
+// their files changed
+const tlsCheckInterval = 30 * time.Second
+
+// tlsNow is the clock of the handshakes, replaced in tests
+var tlsNow = time.Now
+
+// watchedFiles holds what was loaded from a set of files, and loads it
+// again when one of them changes, at most every tlsCheckInterval. Files that
+// fail to load keep the previous value, so a half-written rotation doesn't
+// break the listener.
+type watchedFiles[T any] struct {
+	paths []string
+	load  func() (T, error)
+
+	mu       sync.Mutex
+	value    T
+	modTimes []time.Time
+	checked  time.Time
+}
+
+func watchFiles[T any](load func() (T, error), paths ...string) (*watchedFiles[T], error) {
+	w := &watchedFiles[T]{paths: paths, load: load}
+
+	modTimes, err := w.stat()
+	if err != nil {
+		return nil, err
+	}
+	if w.value, err = load(); err != nil {
+		return nil, err
+	}
+	w.modTimes = modTimes
+	w.checked = time.Now()
+
+	return w, nil
+}
+
+func (w *watchedFiles[T]) stat() ([]time.Time, error) {
+	modTimes := make([]time.Time, len(w.paths))
+	for i, path := range w.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// get returns the current value, reloading it if the files changed.
+func (w *watchedFiles[T]) get(now time.Time) T {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if now.Sub(w.checked) < tlsCheckInterval {
+		return w.value
+	}
+	w.checked = now
+
+	modTimes, err := w.stat()
+	if err != nil {
+		slog.Warn("Failed to check certificate files", "paths", w.paths, "error", err)
+		return w.value
+	}
+	if equalTimes(modTimes, w.modTimes) {
+		return w.value
+	}
+
+	value, err := w.load()
+	if err != nil {
+		slog.Warn("Keeping previous certificate files", "paths", w.paths, "error", err)
+		return w.value
+	}
+	w.value, w.modTimes = value, modTimes
+	slog.Info("Certificate files reloaded", "paths", w.paths)
+
+	return w.value
+}
+
+func equalTimes(a, b []time.Time) bool {
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return len(a) == len(b)
+}
+
+func loadCertificate(certFile, keyFile string) func() (*tls.Certificate, error) {
+	return func() (*tls.Certificate, error) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &cert, nil
+	}
+}
+
+func loadCAPool(path string) func() (*x509.CertPool, error) {
+	return func() (*x509.CertPool, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates in %s", path)
+		}
+		return pool, nil
+	}
+}
+
+// ServerTLS returns the TLS config of a listener serving the certificate of
+// certFile and keyFile. With a clientCAFile, client certificates signed by
+// one of its CAs are verified and identify routers (see DeviceSerial);
+// clients without a certificate are still accepted, they authenticate with
+// credentials instead. The files are read again when they change.
+// nextProtos are the ALPN protocols served, "h2" for gRPC.
+func ServerTLS(certFile, keyFile, clientCAFile string, nextProtos ...string) (*tls.Config, error) {
+	cert, err := watchFiles(loadCertificate(certFile, keyFile), certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: nextProtos,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return cert.get(tlsNow()), nil
+		},
+	}
+	if clientCAFile == "" {
+		return base, nil
+	}
+
+	clientCAs, err := watchFiles(loadCAPool(clientCAFile), clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client CA bundle: %w", err)
+	}
+
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		config := base.Clone()
+		config.GetConfigForClient = nil
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		config.ClientCAs = clientCAs.get(tlsNow())
+		return config, nil
+	}
+	return base, nil
+}
+
+// ClientTLS returns the TLS config of a client of serverName, verifying its
+// certificate against the CAs of caFile, or the system ones if it is empty.
+// caFile is read again when it changes.
+func ClientTLS(caFile, serverName string) (*tls.Config, error) {
+	if caFile == "" {
+		return &tls.Config{MinVersion: tls.VersionTLS12, ServerName: serverName}, nil
+	}
+
+	roots, err := watchFiles(loadCAPool(caFile), caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA bundle: %w", err)
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		// the chain is verified by VerifyConnection, against the current roots
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return fmt.Errorf("server sent no certificate")
+			}
+			intermediates := x509.NewCertPool()
+			for _, cert := range state.PeerCertificates[1:] {
+				intermediates.AddCert(cert)
+			}
+			_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+				DNSName:       serverName,
+				Roots:         roots.get(tlsNow()),
+				Intermediates: intermediates,
+			})
+			return err
+		},
+	}, nil
+}
+
+// DeviceSerial returns the router serial number a verified client
+// certificate stands for: its subject common name, or else its first DNS
+// name.
+func DeviceSerial(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	return ""
+}
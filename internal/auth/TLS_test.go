@@ -0,0 +1,235 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCA issues certificates for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{cert: cert, key: key}
+}
+
+// writeCA writes the CA certificate to path.
+func (ca *testCA) writeCA(t *testing.T, path string) {
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+}
+
+// issue returns a certificate for the subject and DNS names, and its key.
+func (ca *testCA) issue(t *testing.T, commonName string, usage x509.ExtKeyUsage, dnsNames ...string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// writeCert writes the certificate and its key to PEM files.
+func writeCert(t *testing.T, cert tls.Certificate, certFile, keyFile string) {
+	keyDer, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+}
+
+// handshake connects a client with the config to a server with the config,
+// and returns the connection state of the server.
+func handshake(t *testing.T, server, client *tls.Config) (tls.ConnectionState, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lis.Close()
+
+	type result struct {
+		state tls.ConnectionState
+		err   error
+	}
+	accepted := make(chan result, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			accepted <- result{err: err}
+			return
+		}
+		defer conn.Close()
+		s := tls.Server(conn, server)
+		err = s.Handshake()
+		accepted <- result{state: s.ConnectionState(), err: err}
+	}()
+
+	conn, err := tls.Dial("tcp", lis.Addr().String(), client)
+	if err == nil {
+		// the server refuses client certificates after the client's handshake
+		_, err = conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+
+	r := <-accepted
+	if r.err != nil {
+		return r.state, r.err
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return r.state, err
+	}
+	return r.state, nil
+}
+
+type tlsFiles struct {
+	ca, cert, key string
+}
+
+func setupServerTLS(t *testing.T) (*testCA, tlsFiles) {
+	dir := t.TempDir()
+	files := tlsFiles{
+		ca:   filepath.Join(dir, "ca.pem"),
+		cert: filepath.Join(dir, "server.pem"),
+		key:  filepath.Join(dir, "server.key"),
+	}
+
+	ca := newTestCA(t)
+	ca.writeCA(t, files.ca)
+	writeCert(t, ca.issue(t, "router-manager", x509.ExtKeyUsageServerAuth, "localhost"), files.cert, files.key)
+
+	return ca, files
+}
+
+func TestServerTLS_DeviceCertificates(t *testing.T) {
+	ca, files := setupServerTLS(t)
+
+	server, err := ServerTLS(files.cert, files.key, files.ca)
+	require.NoError(t, err)
+	client, err := ClientTLS(files.ca, "localhost")
+	require.NoError(t, err)
+
+	// a router with a device certificate
+	device := ca.issue(t, "SN-1", x509.ExtKeyUsageClientAuth)
+	withCert := client.Clone()
+	withCert.Certificates = []tls.Certificate{device}
+
+	state, err := handshake(t, server, withCert)
+	require.NoError(t, err)
+	require.NotEmpty(t, state.VerifiedChains)
+	assert.Equal(t, "SN-1", DeviceSerial(state.VerifiedChains[0][0]))
+
+	// clients without one authenticate otherwise
+	state, err = handshake(t, server, client)
+	require.NoError(t, err)
+	assert.Empty(t, state.VerifiedChains)
+
+	// certificates of other CAs are refused
+	foreign := client.Clone()
+	foreign.Certificates = []tls.Certificate{newTestCA(t).issue(t, "SN-1", x509.ExtKeyUsageClientAuth)}
+	_, err = handshake(t, server, foreign)
+	assert.Error(t, err)
+}
+
+func TestServerTLS_ReloadsChangedFiles(t *testing.T) {
+	_, files := setupServerTLS(t)
+
+	server, err := ServerTLS(files.cert, files.key, "")
+	require.NoError(t, err)
+
+	// the CA and the certificate are rotated
+	rotated := newTestCA(t)
+	rotated.writeCA(t, files.ca)
+	writeCert(t, rotated.issue(t, "router-manager", x509.ExtKeyUsageServerAuth, "localhost"), files.cert, files.key)
+	later := time.Now().Add(time.Minute)
+	for _, path := range []string{files.ca, files.cert, files.key} {
+		require.NoError(t, os.Chtimes(path, later, later))
+	}
+
+	client, err := ClientTLS(files.ca, "localhost")
+	require.NoError(t, err)
+
+	// the files are only checked every tlsCheckInterval
+	_, err = handshake(t, server, client)
+	assert.Error(t, err)
+
+	expireChecks(t)
+	_, err = handshake(t, server, client)
+	assert.NoError(t, err)
+}
+
+func TestServerTLS_KeepsPreviousOnBrokenFiles(t *testing.T) {
+	_, files := setupServerTLS(t)
+
+	server, err := ServerTLS(files.cert, files.key, "")
+	require.NoError(t, err)
+	client, err := ClientTLS(files.ca, "localhost")
+	require.NoError(t, err)
+
+	// a rotation caught halfway
+	require.NoError(t, os.WriteFile(files.key, []byte("not a key"), 0o600))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(files.key, later, later))
+
+	expireChecks(t)
+	_, err = handshake(t, server, client)
+	assert.NoError(t, err)
+}
+
+func TestDeviceSerial(t *testing.T) {
+	assert.Equal(t, "SN-1", DeviceSerial(&x509.Certificate{Subject: pkix.Name{CommonName: "SN-1"}, DNSNames: []string{"sn-2"}}))
+	assert.Equal(t, "sn-2", DeviceSerial(&x509.Certificate{DNSNames: []string{"sn-2"}}))
+	assert.Equal(t, "", DeviceSerial(&x509.Certificate{}))
+}
+
+// expireChecks makes the files be checked again on the next handshakes, by
+// moving the clock forward.
+func expireChecks(t *testing.T) {
+	t.Helper()
+	tlsNow = func() time.Time { return time.Now().Add(tlsCheckInterval) }
+	t.Cleanup(func() { tlsNow = time.Now })
+}
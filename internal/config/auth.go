@@ -0,0 +1,53 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Auth holds the operator authentication settings read from the environment.
+type Auth struct {
+	// false lets every call through, with x-caller-id as the caller
+	Enabled bool
+
+	// JWTs are accepted if a JWKS file is set; iss and aud are checked
+	// when set
+	JWKSFile    string
+	JWTIssuer   string
+	JWTAudience string
+
+	// admin API key that isn't stored, to create the first keys with
+	BootstrapApiKey string
+
+	// false lets routers poll and ack without credentials
+	RouterAuthEnabled bool
+
+	// how long role changes take to apply
+	PolicyRefreshInterval time.Duration
+}
+
+func LoadAuth() *Auth {
+	auth := &Auth{
+		Enabled: boolFromEnv("AUTH_ENABLED", true),
+
+		JWKSFile:    os.Getenv("AUTH_JWKS_FILE"),
+		JWTIssuer:   os.Getenv("AUTH_JWT_ISSUER"),
+		JWTAudience: os.Getenv("AUTH_JWT_AUDIENCE"),
+
+		BootstrapApiKey: os.Getenv("AUTH_BOOTSTRAP_API_KEY"),
+
+		RouterAuthEnabled: boolFromEnv("ROUTER_AUTH_ENABLED", true),
+
+		PolicyRefreshInterval: durationFromEnv("POLICY_REFRESH_INTERVAL", 30*time.Second),
+	}
+
+	if !auth.Enabled {
+		slog.Warn("Authentication is disabled, anyone can call the management API")
+	}
+	if !auth.RouterAuthEnabled {
+		slog.Warn("Router authentication is disabled, anyone can poll and ack any router's commands")
+	}
+
+	return auth
+}
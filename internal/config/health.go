@@ -0,0 +1,22 @@
+package config
+
+import "time"
+
+// Health holds the health check settings read from the environment.
+type Health struct {
+	// how often the dependencies are checked for the gRPC health service
+	CheckInterval time.Duration
+	// how long each dependency check may take
+	CheckTimeout time.Duration
+	// how long the service is reported not ready before it stops serving,
+	// for load balancers to take it out
+	DrainDelay time.Duration
+}
+
+func LoadHealth() *Health {
+	return &Health{
+		CheckInterval: durationFromEnv("HEALTH_CHECK_INTERVAL", 5*time.Second),
+		CheckTimeout:  durationFromEnv("HEALTH_CHECK_TIMEOUT", 2*time.Second),
+		DrainDelay:    durationFromEnv("SHUTDOWN_DRAIN_DELAY", 5*time.Second),
+	}
+}
@@ -0,0 +1,19 @@
+package config
+
+// Logging holds the log settings read from the environment.
+type Logging struct {
+	// "json" or "text"
+	Format string
+	// lowest level logged: "debug", "info", "warn" or "error"
+	Level string
+	// "stdout", "stderr" or a file the logs are appended to
+	Output string
+}
+
+func LoadLogging() *Logging {
+	return &Logging{
+		Format: stringFromEnv("LOG_FORMAT", "json"),
+		Level:  stringFromEnv("LOG_LEVEL", "info"),
+		Output: stringFromEnv("LOG_OUTPUT", "stdout"),
+	}
+}
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+)
+
+// routers poll every few seconds; these leave room for that, not for loops
+const defaultRateLimits = "CommandService.PollCommands=30/m:10," +
+	"CommandService.AckCommand=120/m:30," +
+	"CommandService.SendCommand=60/m:20"
+
+// RateLimit holds the rate limit settings read from the environment.
+type RateLimit struct {
+	// false lets every call through
+	Enabled bool
+	// limits per method, see ratelimit.ParseLimits
+	Limits string
+}
+
+func LoadRateLimit() *RateLimit {
+	limits, ok := os.LookupEnv("RATE_LIMITS")
+	if !ok {
+		limits = defaultRateLimits
+	}
+
+	rateLimit := &RateLimit{
+		Enabled: boolFromEnv("RATE_LIMIT_ENABLED", true),
+		Limits:  limits,
+	}
+	if !rateLimit.Enabled {
+		slog.Warn("Rate limiting is disabled")
+	}
+
+	return rateLimit
+}
@@ -2,17 +2,34 @@ package config
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"os"
+	"router-manager/internal/metrics"
+	"router-manager/internal/tracing"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+// how often the health watcher pings Redis
+const redisHealthInterval = 5 * time.Second
+
+// how many connection errors in a row open the circuit
+const redisFailureThreshold = 3
+
 type Redis struct {
 	Client *redis.Client
+
+	healthy atomic.Bool
+	// the cache is being rebuilt: it is written to, not read yet
+	resyncing atomic.Bool
+	// connection errors since Redis last answered
+	failures atomic.Int32
 }
 
+// InitRedis never fails: if Redis can't be reached the app starts in
+// degraded mode and WatchHealth reconnects in the background.
 func InitRedis() *Redis {
 	addr := os.Getenv("REDIS_GO_URL")
 	if addr == "" {
@@ -28,16 +45,116 @@ func InitRedis() *Redis {
 		ReadTimeout:  3 * time.Second,
 		WriteTimeout: 3 * time.Second,
 	})
+	client.AddHook(tracing.NewRedisHook(nil))
+
+	r := &Redis{Client: client}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	_, err := client.Ping(ctx).Result()
-	if err != nil {
-		log.Fatal("failed to ping Redis: %w", err)
+	if err := r.ping(ctx); err != nil {
+		slog.Warn("Failed to ping Redis, running without cache", "error", err)
+		r.setHealthy(false)
+	} else {
+		r.setHealthy(true)
+	}
+
+	return r
+}
+
+// Healthy reports whether the cache may be used on the hot path.
+func (r *Redis) Healthy() bool {
+	return r.healthy.Load()
+}
+
+// Writable reports whether the cache may be written to: it is healthy or
+// being rebuilt.
+func (r *Redis) Writable() bool {
+	return r.healthy.Load() || r.resyncing.Load()
+}
+
+// ReportFailure counts a connection error. redisFailureThreshold of them in
+// a row open the circuit; the cache stays unused until the next successful
+// ping.
+func (r *Redis) ReportFailure(err error) {
+	if r.failures.Add(1) < redisFailureThreshold {
+		return
+	}
+	if r.healthy.CompareAndSwap(true, false) {
+		slog.Warn("Redis marked unhealthy", "error", err, "failures", redisFailureThreshold)
+		metrics.CacheUp.Set(0)
+	}
+}
+
+// ReportSuccess resets the count of connection errors: Redis answered.
+func (r *Redis) ReportSuccess() {
+	if r.failures.Load() != 0 {
+		r.failures.Store(0)
 	}
+}
+
+// WatchHealth pings Redis until ctx is done. Every time the cache comes back
+// it is resynced from PostgreSQL before it is used again, see Resync.
+func (r *Redis) WatchHealth(ctx context.Context, resync func(ctx context.Context) error) {
+	ticker := time.NewTicker(redisHealthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, redisHealthInterval)
+		err := r.ping(pingCtx)
+		cancel()
+
+		if err != nil {
+			r.ReportFailure(err)
+			continue
+		}
+		r.ReportSuccess()
+
+		if !r.healthy.Load() {
+			slog.Info("Redis is reachable again, resyncing the cache")
+			// the circuit stays open, the next ping tries again
+			if err := r.Resync(ctx, resync); err != nil {
+				slog.Warn("Failed to resync Redis cache", "error", err)
+			}
+		}
+	}
+}
 
-	return &Redis{Client: client}
+// Resync rebuilds the cache with resync and only then lets it be read. While
+// it runs the cache is written to but not read, so that writes made
+// meanwhile aren't lost and nothing reads a half-built cache.
+func (r *Redis) Resync(ctx context.Context, resync func(ctx context.Context) error) error {
+	r.setHealthy(false)
+	r.resyncing.Store(true)
+	defer r.resyncing.Store(false)
+
+	if err := resync(ctx); err != nil {
+		return err
+	}
+
+	r.failures.Store(0)
+	r.setHealthy(true)
+	return nil
+}
+
+func (r *Redis) ping(ctx context.Context) error {
+	_, err := r.Client.Ping(ctx).Result()
+	return err
+}
+
+func (r *Redis) setHealthy(healthy bool) {
+	r.healthy.Store(healthy)
+	if healthy {
+		metrics.CacheUp.Set(1)
+	} else {
+		metrics.CacheUp.Set(0)
+	}
 }
 
 func (r *Redis) Close() {
@@ -0,0 +1,167 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Service holds the CommandService settings read from the environment.
+type Service struct {
+	// how long a SendCommand idempotency key is remembered
+	IdempotencyKeyTTL time.Duration
+	// how long a SendCommand in progress holds its key before a retry takes it over
+	IdempotencyKeyLease time.Duration
+	// how often running campaigns are checked for waves to release
+	CampaignTickInterval time.Duration
+
+	// routers per bulk write of SendCommand
+	SendBatchSize int
+	// SendCommand calls with more routers run as a background job
+	AsyncSendThreshold int
+	// routers per second a send job goes through, 0 = unlimited
+	SendRate float64
+
+	// background job workers of this instance
+	JobWorkers int
+	// how long a job stays with its worker without progress
+	JobLease time.Duration
+	// how often idle workers look for jobs
+	JobPollInterval time.Duration
+
+	// command types whose commands wait for a second principal's approval
+	ApprovalCommandTypes []string
+	// how long commands wait for approval before they are cancelled
+	ApprovalTTL time.Duration
+
+	// SendCommand registers unknown serial numbers instead of skipping them
+	AutoRegisterRouters bool
+
+	// how often the command and router gauges are read from PostgreSQL
+	MetricsRefreshInterval time.Duration
+	// routers seen within it count as online
+	RouterOnlineWindow time.Duration
+
+	// PEM Ed25519 key commands are signed with, none = unsigned commands
+	SigningKeyFile string
+	// PEM public keys of earlier signing keys, still published to routers
+	PreviousSigningKeysFile string
+	// how long a command signature is valid from its delivery
+	SignatureTTL time.Duration
+
+	// master keys payloads of sensitive command types are sealed with, the
+	// current one first; none = stored in plaintext
+	PayloadKeysFile string
+}
+
+func LoadService() *Service {
+	return &Service{
+		IdempotencyKeyTTL:    durationFromEnv("IDEMPOTENCY_KEY_TTL", 24*time.Hour),
+		IdempotencyKeyLease:  durationFromEnv("IDEMPOTENCY_KEY_LEASE", time.Minute),
+		CampaignTickInterval: durationFromEnv("CAMPAIGN_TICK_INTERVAL", 10*time.Second),
+
+		SendBatchSize:      intFromEnv("SEND_BATCH_SIZE", 500),
+		AsyncSendThreshold: intFromEnv("ASYNC_SEND_THRESHOLD", 1000),
+		SendRate:           floatFromEnv("SEND_RATE", 1000),
+
+		JobWorkers:      intFromEnv("JOB_WORKERS", 4),
+		JobLease:        durationFromEnv("JOB_LEASE", time.Minute),
+		JobPollInterval: durationFromEnv("JOB_POLL_INTERVAL", time.Second),
+
+		ApprovalCommandTypes: listFromEnv("APPROVAL_COMMAND_TYPES", []string{"FACTORY_RESET", "UPDATE_FIRMWARE"}),
+		ApprovalTTL:          durationFromEnv("APPROVAL_TTL", 24*time.Hour),
+
+		AutoRegisterRouters: boolFromEnv("AUTO_REGISTER_ROUTERS", true),
+
+		MetricsRefreshInterval: durationFromEnv("METRICS_REFRESH_INTERVAL", 30*time.Second),
+		RouterOnlineWindow:     durationFromEnv("ROUTER_ONLINE_WINDOW", 2*time.Minute),
+
+		SigningKeyFile:          os.Getenv("COMMAND_SIGNING_KEY_FILE"),
+		PreviousSigningKeysFile: os.Getenv("COMMAND_PREVIOUS_KEYS_FILE"),
+		SignatureTTL:            durationFromEnv("COMMAND_SIGNATURE_TTL", 7*24*time.Hour),
+
+		PayloadKeysFile: os.Getenv("PAYLOAD_KEYS_FILE"),
+	}
+}
+
+func stringFromEnv(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+func durationFromEnv(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Warn("Invalid duration, using the default", "name", name, "value", value, "default", def, "error", err)
+		return def
+	}
+	return d
+}
+
+func intFromEnv(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		slog.Warn("Invalid integer, using the default", "name", name, "value", value, "default", def)
+		return def
+	}
+	return n
+}
+
+func floatFromEnv(name string, def float64) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < 0 {
+		slog.Warn("Invalid number, using the default", "name", name, "value", value, "default", def)
+		return def
+	}
+	return f
+}
+
+func boolFromEnv(name string, def bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("Invalid boolean, using the default", "name", name, "value", value, "default", def)
+		return def
+	}
+	return b
+}
+
+// listFromEnv reads a comma-separated list; a variable that is set but empty
+// is an empty list.
+func listFromEnv(name string, def []string) []string {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return def
+	}
+
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"os"
+	"router-manager/internal/logging"
+)
+
+// TLS holds the listener TLS settings read from the environment. The files
+// are read again when they change.
+type TLS struct {
+	// certificate of the gRPC listener, none = plaintext
+	GRPCCertFile string
+	GRPCKeyFile  string
+	// CAs of router device certificates; set = mTLS on the gRPC listener
+	ClientCAFile string
+
+	// certificate of the REST listener, none = plaintext
+	HTTPCertFile string
+	HTTPKeyFile  string
+
+	// unix socket the gateway reaches the gRPC server through, instead of
+	// dialing its TCP listener
+	GatewaySocket string
+	// CAs and name of the gRPC certificate, for the gateway to verify it
+	// when it dials over TLS; no CA file = the system ones
+	GatewayCAFile     string
+	GatewayServerName string
+}
+
+func LoadTLS() *TLS {
+	t := &TLS{
+		GRPCCertFile: os.Getenv("GRPC_TLS_CERT_FILE"),
+		GRPCKeyFile:  os.Getenv("GRPC_TLS_KEY_FILE"),
+		ClientCAFile: os.Getenv("GRPC_TLS_CLIENT_CA_FILE"),
+
+		HTTPCertFile: os.Getenv("HTTP_TLS_CERT_FILE"),
+		HTTPKeyFile:  os.Getenv("HTTP_TLS_KEY_FILE"),
+
+		GatewaySocket:     os.Getenv("GATEWAY_SOCKET"),
+		GatewayCAFile:     os.Getenv("GATEWAY_TLS_CA_FILE"),
+		GatewayServerName: os.Getenv("GATEWAY_TLS_SERVER_NAME"),
+	}
+	if t.GatewayServerName == "" {
+		t.GatewayServerName = "localhost"
+	}
+
+	if t.ClientCAFile != "" && !t.GRPCEnabled() {
+		logging.Fatal("GRPC_TLS_CLIENT_CA_FILE needs GRPC_TLS_CERT_FILE and GRPC_TLS_KEY_FILE")
+	}
+
+	return t
+}
+
+// GRPCEnabled reports whether the gRPC listener serves TLS.
+func (t *TLS) GRPCEnabled() bool {
+	return t.GRPCCertFile != "" && t.GRPCKeyFile != ""
+}
+
+// HTTPEnabled reports whether the REST listener serves TLS.
+func (t *TLS) HTTPEnabled() bool {
+	return t.HTTPCertFile != "" && t.HTTPKeyFile != ""
+}
@@ -0,0 +1,25 @@
+package config
+
+// Tracing holds the trace settings read from the environment. The OTLP
+// exporter reads its own OTEL_EXPORTER_OTLP_* variables.
+type Tracing struct {
+	// "none", "stdout" or "otlp"
+	Exporter string
+	// share of the traces started here that are kept, 0 to 1
+	SampleRatio float64
+	// service.name of the spans
+	ServiceName string
+}
+
+func LoadTracing() *Tracing {
+	ratio := floatFromEnv("TRACING_SAMPLE_RATIO", 1)
+	if ratio > 1 {
+		ratio = 1
+	}
+
+	return &Tracing{
+		Exporter:    stringFromEnv("TRACING_EXPORTER", "none"),
+		SampleRatio: ratio,
+		ServiceName: stringFromEnv("OTEL_SERVICE_NAME", "router-manager"),
+	}
+}
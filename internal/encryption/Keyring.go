@@ -0,0 +1,231 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"router-manager/internal/model"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// size of master and data keys: AES-256
+const keySize = 32
+
+// MasterKey is a key data keys are wrapped with.
+type MasterKey struct {
+	ID  string
+	Key []byte
+}
+
+// Keyring seals command payloads with envelope encryption: each payload is
+// encrypted with its own data key, and the data key is wrapped with the
+// current master key. Previous master keys are kept to open the payloads
+// they wrapped; a key can be dropped from the keyring once no command sealed
+// with it is left to deliver or inspect.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring wraps new data keys with current; previous keys only open.
+func NewKeyring(current MasterKey, previous ...MasterKey) (*Keyring, error) {
+	k := &Keyring{current: current.ID, keys: make(map[string]cipher.AEAD)}
+
+	for _, key := range append([]MasterKey{current}, previous...) {
+		if key.ID == "" {
+			return nil, fmt.Errorf("master key without id")
+		}
+		if _, ok := k.keys[key.ID]; ok {
+			return nil, fmt.Errorf("master key %s given twice", key.ID)
+		}
+		aead, err := newAEAD(key.Key)
+		if err != nil {
+			return nil, fmt.Errorf("master key %s: %w", key.ID, err)
+		}
+		k.keys[key.ID] = aead
+	}
+	return k, nil
+}
+
+// LoadKeyring reads the master keys of path: one per line, its id and its
+// 32 bytes in base64, separated by a space. The first key is the current
+// one. Blank lines and lines starting with # are skipped.
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []MasterKey
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want a key id and a base64 key", path, line)
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid key: %w", path, line, err)
+		}
+		keys = append(keys, MasterKey{ID: fields[0], Key: key})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys in %s", path)
+	}
+
+	return NewKeyring(keys[0], keys[1:]...)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("key is %d bytes, want %d", len(key), keySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// CurrentKeyID returns the id of the key new data keys are wrapped with.
+func (k *Keyring) CurrentKeyID() string {
+	return k.current
+}
+
+// envelope is stored in place of a sealed payload, so that it stays valid
+// JSON wherever payloads are kept.
+type envelope struct {
+	Sealed *sealedPayload `json:"sealed"`
+}
+
+type sealedPayload struct {
+	// KeyID is the master key that wrapped DataKey
+	KeyID string `json:"key_id"`
+	// DataKey is the nonce and the wrapped data key
+	DataKey []byte `json:"data_key"`
+	// Ciphertext is the nonce and the encrypted payload
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// IsSealed reports whether the payload is a sealed one.
+func IsSealed(payload json.RawMessage) bool {
+	if !bytes.HasPrefix(bytes.TrimSpace(payload), []byte(`{"sealed"`)) {
+		return false
+	}
+	var e envelope
+	return json.Unmarshal(payload, &e) == nil && e.Sealed != nil
+}
+
+// Seal replaces the payload of the command with its sealed envelope; both
+// encryptions are bound to the command id, so an envelope can't be moved to
+// another command. Sealed payloads are left as they are.
+func (k *Keyring) Seal(cmd *model.Command) error {
+	sealed, err := k.SealPayload(cmd.ID, cmd.Payload)
+	if err != nil {
+		return err
+	}
+	cmd.Payload = sealed
+	return nil
+}
+
+// Open replaces the sealed payload of the command with the payload it was
+// sealed from. Payloads that aren't sealed are left as they are.
+func (k *Keyring) Open(cmd *model.Command) error {
+	payload, err := k.OpenPayload(cmd.ID, cmd.Payload)
+	if err != nil {
+		return fmt.Errorf("failed to open payload of command %s: %w", cmd.ID, err)
+	}
+	cmd.Payload = payload
+	return nil
+}
+
+// SealPayload returns the sealed envelope of a payload kept by what has the
+// id, e.g. a campaign whose waves create the commands; both encryptions are
+// bound to the id. Sealed payloads are returned as they are.
+func (k *Keyring) SealPayload(id uuid.UUID, payload json.RawMessage) (json.RawMessage, error) {
+	if len(payload) == 0 || IsSealed(payload) {
+		return payload, nil
+	}
+
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := encrypt(aead, payload, id[:])
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := encrypt(k.keys[k.current], dataKey, id[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(envelope{Sealed: &sealedPayload{KeyID: k.current, DataKey: wrapped, Ciphertext: ciphertext}})
+}
+
+// OpenPayload returns the payload a sealed envelope of the id was sealed
+// from. Payloads that aren't sealed are returned as they are.
+func (k *Keyring) OpenPayload(id uuid.UUID, payload json.RawMessage) (json.RawMessage, error) {
+	if !IsSealed(payload) {
+		return payload, nil
+	}
+
+	var e envelope
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return nil, err
+	}
+	master, ok := k.keys[e.Sealed.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("sealed with unknown key %s", e.Sealed.KeyID)
+	}
+
+	dataKey, err := decrypt(master, e.Sealed.DataKey, id[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	opened, err := decrypt(aead, e.Sealed.Ciphertext, id[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+
+	return opened, nil
+}
+
+// encrypt returns the random nonce followed by the ciphertext.
+func encrypt(aead cipher.AEAD, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func decrypt(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additional)
+}
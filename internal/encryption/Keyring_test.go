@@ -0,0 +1,151 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"router-manager/internal/model"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMasterKey(t *testing.T, id string) MasterKey {
+	key := make([]byte, keySize)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return MasterKey{ID: id, Key: key}
+}
+
+func newKeyring(t *testing.T, current MasterKey, previous ...MasterKey) *Keyring {
+	keyring, err := NewKeyring(current, previous...)
+	require.NoError(t, err)
+	return keyring
+}
+
+func newCommand() *model.Command {
+	return &model.Command{
+		ID:          uuid.New(),
+		CommandType: "SET_WIFI_PASSWORD",
+		Payload:     []byte(`{"password":"hunter2"}`),
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	keyring := newKeyring(t, newMasterKey(t, "k1"))
+
+	cmd := newCommand()
+	require.NoError(t, keyring.Seal(cmd))
+
+	assert.True(t, IsSealed(cmd.Payload))
+	assert.True(t, json.Valid(cmd.Payload))
+	assert.NotContains(t, string(cmd.Payload), "hunter2")
+
+	// sealing twice doesn't wrap the envelope
+	sealed := string(cmd.Payload)
+	require.NoError(t, keyring.Seal(cmd))
+	assert.Equal(t, sealed, string(cmd.Payload))
+
+	require.NoError(t, keyring.Open(cmd))
+	assert.Equal(t, `{"password":"hunter2"}`, string(cmd.Payload))
+
+	// plaintext payloads are left alone
+	require.NoError(t, keyring.Open(cmd))
+	assert.Equal(t, `{"password":"hunter2"}`, string(cmd.Payload))
+}
+
+func TestOpen_BoundToCommand(t *testing.T) {
+	keyring := newKeyring(t, newMasterKey(t, "k1"))
+
+	cmd := newCommand()
+	require.NoError(t, keyring.Seal(cmd))
+
+	other := newCommand()
+	other.Payload = cmd.Payload
+	assert.Error(t, keyring.Open(other))
+}
+
+func TestSealPayload(t *testing.T) {
+	keyring := newKeyring(t, newMasterKey(t, "k1"))
+	id := uuid.New()
+
+	sealed, err := keyring.SealPayload(id, json.RawMessage(`{"password":"hunter2"}`))
+	require.NoError(t, err)
+	assert.True(t, IsSealed(sealed))
+
+	opened, err := keyring.OpenPayload(id, sealed)
+	require.NoError(t, err)
+	assert.Equal(t, `{"password":"hunter2"}`, string(opened))
+
+	// the envelope is bound to the id it was sealed for
+	_, err = keyring.OpenPayload(uuid.New(), sealed)
+	assert.Error(t, err)
+}
+
+func TestOpen_Rotation(t *testing.T) {
+	old, current := newMasterKey(t, "k1"), newMasterKey(t, "k2")
+
+	cmd := newCommand()
+	require.NoError(t, newKeyring(t, old).Seal(cmd))
+
+	// the previous key still opens what it sealed, new payloads use the current one
+	rotated := newKeyring(t, current, old)
+	fresh := newCommand()
+	require.NoError(t, rotated.Seal(fresh))
+	assert.Contains(t, string(fresh.Payload), `"key_id":"k2"`)
+
+	opened := *cmd
+	require.NoError(t, rotated.Open(&opened))
+	assert.Equal(t, `{"password":"hunter2"}`, string(opened.Payload))
+
+	// once dropped, it doesn't
+	err := newKeyring(t, current).Open(cmd)
+	assert.ErrorContains(t, err, "unknown key k1")
+}
+
+func TestNewKeyring_InvalidKeys(t *testing.T) {
+	_, err := NewKeyring(MasterKey{ID: "k1", Key: []byte("short")})
+	assert.Error(t, err)
+
+	_, err = NewKeyring(newMasterKey(t, ""))
+	assert.Error(t, err)
+
+	_, err = NewKeyring(newMasterKey(t, "k1"), newMasterKey(t, "k1"))
+	assert.Error(t, err)
+}
+
+func TestLoadKeyring(t *testing.T) {
+	dir := t.TempDir()
+	old, current := newMasterKey(t, "k1"), newMasterKey(t, "k2")
+
+	path := filepath.Join(dir, "payload.keys")
+	content := fmt.Sprintf("# current first\n%s %s\n\n%s %s\n",
+		current.ID, base64.StdEncoding.EncodeToString(current.Key),
+		old.ID, base64.StdEncoding.EncodeToString(old.Key))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	keyring, err := LoadKeyring(path)
+	require.NoError(t, err)
+	assert.Equal(t, "k2", keyring.CurrentKeyID())
+
+	cmd := newCommand()
+	require.NoError(t, newKeyring(t, old).Seal(cmd))
+	assert.NoError(t, keyring.Open(cmd))
+
+	for name, content := range map[string]string{
+		"empty":      "# nothing yet\n",
+		"no key":     "k1\n",
+		"not base64": "k1 ***\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+			_, err := LoadKeyring(path)
+			assert.Error(t, err)
+		})
+	}
+}
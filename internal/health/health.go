@@ -0,0 +1,231 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// statuses of the dependencies and of the service
+const (
+	StatusUp       = "up"
+	StatusDown     = "down"
+	StatusReady    = "ready"
+	StatusNotReady = "not_ready"
+	// the service is shutting down: it still serves, but shouldn't get new traffic
+	StatusDraining = "draining"
+)
+
+// Check returns an error if the dependency can't be used.
+type Check func(ctx context.Context) error
+
+type check struct {
+	name string
+	fn   Check
+	// a failing optional dependency is reported, the service stays ready
+	optional bool
+}
+
+// Dependency is the status of a dependency in a Report.
+type Dependency struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Optional bool   `json:"optional,omitempty"`
+}
+
+// Report is the readiness of the service and the status of each dependency.
+type Report struct {
+	Status       string                `json:"status"`
+	Dependencies map[string]Dependency `json:"dependencies"`
+}
+
+// Ready reports whether the service should get traffic.
+func (r Report) Ready() bool {
+	return r.Status == StatusReady
+}
+
+// Checker checks the dependencies of the service for readiness probes, on
+// HTTP and over the gRPC health-checking protocol. Once it drains, the
+// service is reported not ready whatever its dependencies.
+type Checker struct {
+	checks  []check
+	timeout time.Duration
+
+	draining atomic.Bool
+	// the gRPC health service kept in sync by Watch; nil = none
+	grpc *grpchealth.Server
+	// gRPC services reported along with the overall status ""
+	services []string
+}
+
+// Option configures optional Checker settings.
+type Option func(c *Checker)
+
+// WithTimeout sets how long each check may take, 2s by default.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Checker) {
+		c.timeout = timeout
+	}
+}
+
+// WithGRPC reports readiness on the gRPC health server, as the overall
+// status "" and as the status of each of services.
+func WithGRPC(server *grpchealth.Server, services ...string) Option {
+	return func(c *Checker) {
+		c.grpc = server
+		c.services = services
+	}
+}
+
+func NewChecker(opts ...Option) *Checker {
+	c := &Checker{timeout: 2 * time.Second}
+	for _, opt := range opts {
+		opt(c)
+	}
+	// not serving until the first check
+	c.setServing(false)
+	return c
+}
+
+// Add checks a dependency the service can't work without.
+func (c *Checker) Add(name string, fn Check) {
+	c.checks = append(c.checks, check{name: name, fn: fn})
+}
+
+// AddOptional checks a dependency the service can work without, degraded.
+func (c *Checker) AddOptional(name string, fn Check) {
+	c.checks = append(c.checks, check{name: name, fn: fn, optional: true})
+}
+
+// Check runs all the checks at once.
+func (c *Checker) Check(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	errs := make([]error, len(c.checks))
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = check.fn(ctx)
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusReady, Dependencies: make(map[string]Dependency, len(c.checks))}
+	for i, check := range c.checks {
+		dependency := Dependency{Status: StatusUp, Optional: check.optional}
+		if errs[i] != nil {
+			dependency.Status = StatusDown
+			dependency.Error = errs[i].Error()
+			if !check.optional {
+				report.Status = StatusNotReady
+			}
+		}
+		report.Dependencies[check.name] = dependency
+	}
+
+	if c.draining.Load() {
+		report.Status = StatusDraining
+	}
+	return report
+}
+
+// Drain reports the service not ready from now on, so that load balancers
+// stop sending it traffic before it shuts down.
+func (c *Checker) Drain() {
+	if !c.draining.CompareAndSwap(false, true) {
+		return
+	}
+	slog.Info("Draining: the service is reported not ready")
+	if c.grpc != nil {
+		// NOT_SERVING for good, whatever Watch sets next
+		c.grpc.Shutdown()
+	}
+}
+
+// Watch checks the dependencies every interval until ctx is done and keeps
+// the gRPC health service in sync, logging changes of readiness.
+func (c *Checker) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	ready := false
+	for {
+		report := c.Check(ctx)
+		if report.Ready() != ready && ctx.Err() == nil {
+			ready = report.Ready()
+			if ready {
+				slog.InfoContext(ctx, "Service is ready")
+			} else {
+				slog.WarnContext(ctx, "Service is not ready", "status", report.Status, "dependencies", down(report))
+			}
+		}
+		c.setServing(report.Ready())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// down lists the dependencies that are down.
+func down(report Report) []string {
+	var names []string
+	for name, dependency := range report.Dependencies {
+		if dependency.Status == StatusDown {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (c *Checker) setServing(serving bool) {
+	if c.grpc == nil {
+		return
+	}
+
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if serving {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+	c.grpc.SetServingStatus("", status)
+	for _, service := range c.services {
+		c.grpc.SetServingStatus(service, status)
+	}
+}
+
+// Liveness answers liveness probes: the process is up and serving HTTP.
+func (c *Checker) Liveness(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readiness answers readiness probes with the Report, 503 unless ready.
+func (c *Checker) Readiness(w http.ResponseWriter, r *http.Request) {
+	report := c.Check(r.Context())
+
+	code := http.StatusOK
+	if !report.Ready() {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, report)
+}
+
+func writeJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Warn("Failed to write health response", "error", err)
+	}
+}
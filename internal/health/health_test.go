@@ -0,0 +1,144 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func up(context.Context) error { return nil }
+
+// serving returns the status of service on the gRPC health server.
+func serving(t *testing.T, server *grpchealth.Server, service string) healthpb.HealthCheckResponse_ServingStatus {
+	resp, err := server.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	require.NoError(t, err)
+	return resp.Status
+}
+
+func TestChecker_Check(t *testing.T) {
+	c := NewChecker()
+	c.Add("postgres", up)
+	c.AddOptional("redis", func(context.Context) error { return errors.New("connection refused") })
+
+	// an optional dependency down leaves the service ready
+	report := c.Check(context.Background())
+	assert.True(t, report.Ready())
+	assert.Equal(t, Dependency{Status: StatusUp}, report.Dependencies["postgres"])
+	assert.Equal(t, Dependency{Status: StatusDown, Error: "connection refused", Optional: true}, report.Dependencies["redis"])
+
+	c.Add("migrations", func(context.Context) error { return errors.New("migration 16 failed halfway") })
+	report = c.Check(context.Background())
+	assert.False(t, report.Ready())
+	assert.Equal(t, StatusNotReady, report.Status)
+	assert.Equal(t, StatusDown, report.Dependencies["migrations"].Status)
+}
+
+func TestChecker_Timeout(t *testing.T) {
+	c := NewChecker(WithTimeout(10 * time.Millisecond))
+	c.Add("postgres", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	report := c.Check(context.Background())
+	assert.False(t, report.Ready())
+	assert.Equal(t, context.DeadlineExceeded.Error(), report.Dependencies["postgres"].Error)
+}
+
+func TestChecker_Handlers(t *testing.T) {
+	healthy := true
+	c := NewChecker()
+	c.Add("postgres", func(context.Context) error {
+		if !healthy {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+
+	get := func(handler http.HandlerFunc) (int, map[string]any) {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return w.Code, body
+	}
+
+	code, body := get(c.Readiness)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StatusReady, body["status"])
+
+	healthy = false
+	code, body = get(c.Readiness)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusNotReady, body["status"])
+	assert.Equal(t, map[string]any{"status": "down", "error": "connection refused"},
+		body["dependencies"].(map[string]any)["postgres"])
+
+	// liveness doesn't depend on anything
+	code, body = get(c.Liveness)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", body["status"])
+}
+
+func TestChecker_Watch(t *testing.T) {
+	server := grpchealth.NewServer()
+	var down atomic.Bool
+	c := NewChecker(WithGRPC(server, "proto.CommandService"))
+	c.Add("postgres", func(context.Context) error {
+		if down.Load() {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+
+	// not serving before the first check
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, serving(t, server, ""))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Watch(ctx, time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		return serving(t, server, "") == healthpb.HealthCheckResponse_SERVING &&
+			serving(t, server, "proto.CommandService") == healthpb.HealthCheckResponse_SERVING
+	}, time.Second, time.Millisecond)
+
+	down.Store(true)
+	assert.Eventually(t, func() bool {
+		return serving(t, server, "") == healthpb.HealthCheckResponse_NOT_SERVING
+	}, time.Second, time.Millisecond)
+}
+
+func TestChecker_Drain(t *testing.T) {
+	server := grpchealth.NewServer()
+	c := NewChecker(WithGRPC(server, "proto.CommandService"))
+	c.Add("postgres", up)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Watch(ctx, time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return serving(t, server, "") == healthpb.HealthCheckResponse_SERVING
+	}, time.Second, time.Millisecond)
+
+	c.Drain()
+
+	report := c.Check(context.Background())
+	assert.Equal(t, StatusDraining, report.Status)
+	assert.Equal(t, StatusUp, report.Dependencies["postgres"].Status)
+
+	// checks that pass don't bring the service back
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, serving(t, server, ""))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, serving(t, server, "proto.CommandService"))
+}
@@ -0,0 +1,139 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// metadata the request id comes in and goes back with
+const RequestIDHeader = "x-request-id"
+
+// longest request id taken from a client
+const maxRequestIDLength = 128
+
+// RequestLogger correlates the log lines of a call: their context carries
+// its request id, method, and the router and command ids of its request.
+// It logs a line when the call ends.
+type RequestLogger struct {
+	log *slog.Logger
+	// methods routers call all the time, logged at the debug level
+	quiet map[string]bool
+}
+
+func NewRequestLogger(logger *slog.Logger, quietMethods ...string) *RequestLogger {
+	l := &RequestLogger{log: logger, quiet: make(map[string]bool)}
+	for _, method := range quietMethods {
+		l.quiet[method] = true
+	}
+	return l
+}
+
+// UnaryInterceptor must run first, so that every other interceptor logs
+// with the request attributes.
+func (l *RequestLogger) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx = l.start(ctx, info.FullMethod, req)
+		started := time.Now()
+
+		resp, err := handler(ctx, req)
+
+		l.end(ctx, info.FullMethod, started, err)
+		return resp, err
+	}
+}
+
+func (l *RequestLogger) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := l.start(ss.Context(), info.FullMethod, nil)
+		started := time.Now()
+
+		err := handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+
+		l.end(ctx, info.FullMethod, started, err)
+		return err
+	}
+}
+
+// start returns the context of the call with its attributes, and sends the
+// request id back to the client.
+func (l *RequestLogger) start(ctx context.Context, fullMethod string, req any) context.Context {
+	requestID := incomingRequestID(ctx)
+	if requestID == "" {
+		requestID = uuid.NewString()
+	}
+	// there's no header to set outside a gRPC server, e.g. in tests
+	_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, requestID))
+
+	args := []any{RequestIDKey, requestID, MethodKey, strings.TrimPrefix(fullMethod, "/")}
+	if r, ok := req.(interface{ GetRouterId() string }); ok && r.GetRouterId() != "" {
+		args = append(args, RouterIDKey, r.GetRouterId())
+	}
+	if r, ok := req.(interface{ GetCommandId() string }); ok && r.GetCommandId() != "" {
+		args = append(args, CommandIDKey, r.GetCommandId())
+	}
+	return With(ctx, args...)
+}
+
+func (l *RequestLogger) end(ctx context.Context, fullMethod string, started time.Time, err error) {
+	code := status.Code(err)
+
+	level := slog.LevelInfo
+	switch {
+	case serverError(code):
+		level = slog.LevelError
+	case err == nil && l.quiet[fullMethod]:
+		level = slog.LevelDebug
+	}
+
+	args := []any{"code", code.String(), "duration", time.Since(started)}
+	if err != nil {
+		args = append(args, "error", status.Convert(err).Message())
+	}
+	l.log.Log(ctx, level, "call handled", args...)
+}
+
+// serverError reports whether code means the call failed on our side
+// rather than the caller's.
+func serverError(code codes.Code) bool {
+	switch code {
+	case codes.Internal, codes.Unknown, codes.DataLoss, codes.Unavailable, codes.DeadlineExceeded, codes.Unimplemented:
+		return true
+	}
+	return false
+}
+
+// incomingRequestID is the request id the client, or the gateway, sent.
+func incomingRequestID(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	values := md.Get(RequestIDHeader)
+	if len(values) == 0 {
+		return ""
+	}
+
+	id := strings.TrimSpace(values[0])
+	if len(id) > maxRequestIDLength {
+		id = id[:maxRequestIDLength]
+	}
+	return id
+}
+
+// serverStream is a stream whose handler gets ctx as the context of the call.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,93 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"router-manager/internal/pb"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func setupRequestLogger(t *testing.T) (*RequestLogger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "json", "debug")
+	require.NoError(t, err)
+	return NewRequestLogger(logger, pb.CommandService_PollCommands_FullMethodName), &buf
+}
+
+// call runs the request through the interceptor; the handler logs a line
+// and ends with err.
+func call(l *RequestLogger, ctx context.Context, method string, req any, err error) error {
+	_, err = l.UnaryInterceptor()(ctx, req, &grpc.UnaryServerInfo{FullMethod: method},
+		func(ctx context.Context, req any) (any, error) {
+			l.log.InfoContext(ctx, "in handler")
+			return nil, err
+		})
+	return err
+}
+
+func TestRequestLogger_Attributes(t *testing.T) {
+	l, buf := setupRequestLogger(t)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(RequestIDHeader, "req-1"))
+	req := &pb.AckRequest{RouterId: "r-1", SerialNumber: "SN1", CommandId: "c-1"}
+	require.NoError(t, call(l, ctx, pb.CommandService_AckCommand_FullMethodName, req, nil))
+
+	logged := lines(t, buf)
+	require.Len(t, logged, 2)
+	for _, line := range logged {
+		assert.Equal(t, "req-1", line[RequestIDKey])
+		assert.Equal(t, "proto.CommandService/AckCommand", line[MethodKey])
+		assert.Equal(t, "r-1", line[RouterIDKey])
+		assert.Equal(t, "c-1", line[CommandIDKey])
+	}
+	assert.Equal(t, "call handled", logged[1]["msg"])
+	assert.Equal(t, "INFO", logged[1]["level"])
+	assert.Equal(t, "OK", logged[1]["code"])
+}
+
+func TestRequestLogger_GeneratesRequestIds(t *testing.T) {
+	l, buf := setupRequestLogger(t)
+
+	for range 2 {
+		require.NoError(t, call(l, context.Background(), pb.CommandService_ListCommands_FullMethodName, &pb.ListCommandsRequest{}, nil))
+	}
+
+	logged := lines(t, buf)
+	require.Len(t, logged, 4)
+	assert.NotEmpty(t, logged[0][RequestIDKey])
+	assert.Equal(t, logged[0][RequestIDKey], logged[1][RequestIDKey])
+	assert.NotEqual(t, logged[0][RequestIDKey], logged[2][RequestIDKey])
+	assert.NotContains(t, logged[0], RouterIDKey)
+}
+
+func TestRequestLogger_Levels(t *testing.T) {
+	l, buf := setupRequestLogger(t)
+	poll := &pb.PollRequest{RouterId: "r-1", SerialNumber: "SN1"}
+
+	require.NoError(t, call(l, context.Background(), pb.CommandService_PollCommands_FullMethodName, poll, nil))
+	_ = call(l, context.Background(), pb.CommandService_PollCommands_FullMethodName, poll,
+		status.Error(codes.NotFound, "there is no router with such serial_number: SN1"))
+	_ = call(l, context.Background(), pb.CommandService_SendCommand_FullMethodName, &pb.SendCommandRequest{},
+		status.Error(codes.Internal, "failed to save commands"))
+
+	var ends []map[string]any
+	for _, line := range lines(t, buf) {
+		if line["msg"] == "call handled" {
+			ends = append(ends, line)
+		}
+	}
+	require.Len(t, ends, 3)
+	// routers poll all the time
+	assert.Equal(t, "DEBUG", ends[0]["level"])
+	assert.Equal(t, "INFO", ends[1]["level"])
+	assert.Equal(t, "NotFound", ends[1]["code"])
+	assert.Equal(t, "there is no router with such serial_number: SN1", ends[1]["error"])
+	assert.Equal(t, "ERROR", ends[2]["level"])
+}
@@ -0,0 +1,139 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// keys of the request attributes
+const (
+	RequestIDKey = "request_id"
+	MethodKey    = "method"
+	RouterIDKey  = "router_id"
+	CommandIDKey = "command_id"
+	TraceIDKey   = "trace_id"
+	SpanIDKey    = "span_id"
+)
+
+type attrsKey struct{}
+
+// With returns a copy of ctx whose log lines carry args too, key-value
+// pairs as for slog.Logger.With. Later values replace earlier ones of the
+// same key.
+func With(ctx context.Context, args ...any) context.Context {
+	added := slog.Group("", args...).Value.Group()
+	if len(added) == 0 {
+		return ctx
+	}
+
+	previous := attrs(ctx)
+	merged := make([]slog.Attr, 0, len(previous)+len(added))
+	for _, attr := range previous {
+		if !hasKey(added, attr.Key) {
+			merged = append(merged, attr)
+		}
+	}
+	merged = append(merged, added...)
+	return context.WithValue(ctx, attrsKey{}, merged)
+}
+
+func attrs(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	return attrs
+}
+
+func hasKey(attrs []slog.Attr, key string) bool {
+	for _, attr := range attrs {
+		if attr.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// RequestID returns the request id of ctx, "" outside of a request.
+func RequestID(ctx context.Context) string {
+	for _, attr := range attrs(ctx) {
+		if attr.Key == RequestIDKey {
+			return attr.Value.String()
+		}
+	}
+	return ""
+}
+
+// contextHandler adds the attributes of the context to the records, and
+// the ids of its span if it's sampled.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	r.AddAttrs(attrs(ctx)...)
+	if span := trace.SpanContextFromContext(ctx); span.IsSampled() {
+		r.AddAttrs(slog.String(TraceIDKey, span.TraceID().String()), slog.String(SpanIDKey, span.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// New returns a logger writing to w as "json" or "text", from level
+// ("debug", "info", "warn" or "error") up. Its lines carry the attributes
+// With added to the context they are logged with.
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format %q, want json or text", format)
+	}
+
+	return slog.New(contextHandler{handler}), nil
+}
+
+// Open opens where logs go: "stdout", "stderr" or a file, appended to.
+func Open(output string) (io.WriteCloser, error) {
+	switch output {
+	case "", "stdout":
+		return nopCloser{os.Stdout}, nil
+	case "stderr":
+		return nopCloser{os.Stderr}, nil
+	}
+	return os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// Fatal logs msg at the error level with the default logger and exits.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
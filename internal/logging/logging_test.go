@@ -0,0 +1,94 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// lines decodes the JSON lines written to buf.
+func lines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var decoded []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var fields map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &fields))
+		decoded = append(decoded, fields)
+	}
+	return decoded
+}
+
+func TestNew_ContextAttributes(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "json", "info")
+	require.NoError(t, err)
+
+	ctx := With(context.Background(), RequestIDKey, "req-1", RouterIDKey, "r-1")
+	ctx = With(ctx, RouterIDKey, "r-2", CommandIDKey, "c-1")
+
+	logger.With("component", "test").InfoContext(ctx, "Commands sent", "commands", 2)
+	logger.DebugContext(ctx, "not logged")
+	logger.Info("no request")
+
+	logged := lines(t, &buf)
+	require.Len(t, logged, 2)
+	assert.Equal(t, "Commands sent", logged[0]["msg"])
+	assert.Equal(t, "INFO", logged[0]["level"])
+	assert.Equal(t, "test", logged[0]["component"])
+	assert.Equal(t, float64(2), logged[0]["commands"])
+	assert.Equal(t, "req-1", logged[0][RequestIDKey])
+	// later values win
+	assert.Equal(t, "r-2", logged[0][RouterIDKey])
+	assert.Equal(t, "c-1", logged[0][CommandIDKey])
+
+	assert.NotContains(t, logged[1], RequestIDKey)
+
+	assert.Equal(t, "req-1", RequestID(ctx))
+	assert.Equal(t, "", RequestID(context.Background()))
+}
+
+func TestNew_TraceIds(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "json", "info")
+	require.NoError(t, err)
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	sampled := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled})
+	dropped := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID})
+
+	logger.InfoContext(trace.ContextWithSpanContext(context.Background(), sampled), "sampled")
+	logger.InfoContext(trace.ContextWithSpanContext(context.Background(), dropped), "dropped")
+
+	logged := lines(t, &buf)
+	require.Len(t, logged, 2)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", logged[0][TraceIDKey])
+	assert.Equal(t, "00f067aa0ba902b7", logged[0][SpanIDKey])
+	assert.NotContains(t, logged[1], TraceIDKey)
+}
+
+func TestNew_Text(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "text", "debug")
+	require.NoError(t, err)
+
+	logger.DebugContext(With(context.Background(), RequestIDKey, "req-1"), "Poll commands")
+	assert.Contains(t, buf.String(), "level=DEBUG")
+	assert.Contains(t, buf.String(), `msg="Poll commands" request_id=req-1`)
+}
+
+func TestNew_InvalidSettings(t *testing.T) {
+	_, err := New(&bytes.Buffer{}, "xml", "info")
+	assert.Error(t, err)
+
+	_, err = New(&bytes.Buffer{}, "json", "verbose")
+	assert.Error(t, err)
+}
@@ -1,14 +1,27 @@
 package metrics
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"google.golang.org/grpc/status"
+)
+
+// Registry holds the metrics of the service, served on /metrics. Tests
+// gather it to check what was recorded.
+var Registry = prometheus.NewRegistry()
+
+// delivery happens on the next poll, a few seconds, unless the router is
+// offline or the command is scheduled
+var latencyBuckets = []float64{1, 5, 15, 30, 60, 300, 900, 3600, 6 * 3600, 24 * 3600}
 
 // metrics variables
 var (
-	SendCommandCalls = prometheus.NewCounter(
+	SendCommandCalls = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "command_service_send_comand_total",
-			Help: "Total number of /send_command calls",
+			Help: "Total number of /send_command calls, by gRPC code",
 		},
+		[]string{"code"},
 	)
 
 	SendCommandHistogramm = prometheus.NewHistogram(
@@ -19,11 +32,12 @@ var (
 		},
 	)
 
-	CommadsPollCalls = prometheus.NewCounter(
+	CommadsPollCalls = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "command_service_commands_poll_total",
-			Help: "Total number of /commands/poll calls",
+			Help: "Total number of /commands/poll calls, by gRPC code",
 		},
+		[]string{"code"},
 	)
 
 	CommandsPollHistogramm = prometheus.NewHistogram(
@@ -34,11 +48,12 @@ var (
 		},
 	)
 
-	CommandsAckCalls = prometheus.NewCounter(
+	CommandsAckCalls = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "command_service_commands_ack_total",
-			Help: "Total number of /commands/ack calls",
+			Help: "Total number of /commands/ack calls, by gRPC code",
 		},
+		[]string{"code"},
 	)
 
 	CommandsAckHistogramm = prometheus.NewHistogram(
@@ -48,15 +63,139 @@ var (
 			Buckets: []float64{0.01, 0.05, 0.1, 0.2, 0.5, 1.0, 2.0, 5.0},
 		},
 	)
+
+	CommandsCreated = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "command_service_commands_created_total",
+			Help: "Total number of commands created, by command type",
+		},
+		[]string{"command_type"},
+	)
+
+	Commands = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "command_service_commands",
+			Help: "Number of commands in PostgreSQL, by status",
+		},
+		[]string{"status"},
+	)
+
+	CommandTimeToSent = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "command_service_command_time_to_sent_seconds",
+			Help:    "Time from the creation of a command to its delivery, by command type",
+			Buckets: latencyBuckets,
+		},
+		[]string{"command_type"},
+	)
+
+	CommandTimeToAck = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "command_service_command_time_to_ack_seconds",
+			Help:    "Time from the creation of a command to its acknowledgement, by command type",
+			Buckets: latencyBuckets,
+		},
+		[]string{"command_type"},
+	)
+
+	CacheUp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "command_service_cache_up",
+			Help: "Whether the Redis cache is used (1) or bypassed (0)",
+		},
+	)
+
+	CacheLookups = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "command_service_cache_lookups_total",
+			Help: "Total number of Redis lookups, by lookup and result (hit or miss)",
+		},
+		[]string{"lookup", "result"},
+	)
+
+	CacheFallbacks = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "command_service_cache_fallbacks_total",
+			Help: "Total number of Redis lookups that failed and were served by PostgreSQL, by lookup",
+		},
+		[]string{"lookup"},
+	)
+
+	Routers = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "command_service_routers",
+			Help: "Number of routers in service, by state (online if seen lately, offline)",
+		},
+		[]string{"state"},
+	)
+
+	ThrottledCalls = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "command_service_throttled_calls_total",
+			Help: "Total number of calls rejected by rate limits, by method and caller kind (router or operator)",
+		},
+		[]string{"method", "caller"},
+	)
+
+	AuditEventsDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "command_service_audit_events_dropped_total",
+			Help: "Total number of audit events that couldn't be appended and were only logged, by reason (queue_full or append_failed)",
+		},
+		[]string{"reason"},
+	)
 )
 
+// labels of CacheLookups
+const (
+	CacheHit  = "hit"
+	CacheMiss = "miss"
+)
+
+// labels of AuditEventsDropped
+const (
+	AuditQueueFull    = "queue_full"
+	AuditAppendFailed = "append_failed"
+)
+
+// labels of Routers
+const (
+	RoutersOnline  = "online"
+	RoutersOffline = "offline"
+)
+
+// Code is the label of the outcome of a call: its gRPC code, OK if err is
+// nil.
+func Code(err error) string {
+	return status.Code(err).String()
+}
+
 func init() {
-	prometheus.MustRegister(SendCommandCalls)
-	prometheus.MustRegister(SendCommandHistogramm)
+	// what the default registry has
+	Registry.MustRegister(collectors.NewGoCollector())
+	Registry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	Registry.MustRegister(SendCommandCalls)
+	Registry.MustRegister(SendCommandHistogramm)
+
+	Registry.MustRegister(CommadsPollCalls)
+	Registry.MustRegister(CommandsPollHistogramm)
+
+	Registry.MustRegister(CommandsAckCalls)
+	Registry.MustRegister(CommandsAckHistogramm)
+
+	Registry.MustRegister(CommandsCreated)
+	Registry.MustRegister(Commands)
+	Registry.MustRegister(CommandTimeToSent)
+	Registry.MustRegister(CommandTimeToAck)
+
+	Registry.MustRegister(CacheUp)
+	Registry.MustRegister(CacheLookups)
+	Registry.MustRegister(CacheFallbacks)
+
+	Registry.MustRegister(Routers)
 
-	prometheus.MustRegister(CommadsPollCalls)
-	prometheus.MustRegister(CommandsPollHistogramm)
+	Registry.MustRegister(ThrottledCalls)
 
-	prometheus.MustRegister(CommandsAckCalls)
-	prometheus.MustRegister(CommandsAckHistogramm)
+	Registry.MustRegister(AuditEventsDropped)
 }
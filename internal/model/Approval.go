@@ -0,0 +1,73 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// approval statuses
+const (
+	ApprovalPending  = "PENDING"
+	ApprovalApproved = "APPROVED"
+	ApprovalRejected = "REJECTED"
+	ApprovalExpired  = "EXPIRED"
+)
+
+// ApprovalExpiredReason is the cancel reason of commands whose approval expired.
+const ApprovalExpiredReason = "approval expired"
+
+// RejectedReason is the cancel reason of commands whose approval was rejected.
+func RejectedReason(reason string) string {
+	if reason == "" {
+		return "approval rejected"
+	}
+	return fmt.Sprintf("approval rejected: %s", reason)
+}
+
+// Approval holds back the commands of one SendCommand call, or a campaign,
+// of a command type that needs a second pair of eyes. The commands are
+// AWAITING_APPROVAL until another principal approves them, which makes them
+// PENDING, or rejects them; rejected commands and those still waiting at
+// ExpiresAt are cancelled, and the campaign aborted. The row is kept as the
+// record of who asked and who decided.
+type Approval struct {
+	ID          uuid.UUID `db:"id"`
+	CommandType string    `db:"command_type"`
+	Status      string    `db:"status"`
+
+	RequestedBy string    `db:"requested_by"`
+	RequestedAt time.Time `db:"requested_at"`
+	ExpiresAt   time.Time `db:"expires_at"`
+
+	DecidedBy string     `db:"decided_by"`
+	DecidedAt *time.Time `db:"decided_at"`
+	Reason    string     `db:"reason"`
+
+	// Commands is the number of commands held by the approval.
+	Commands int `db:"-"`
+	// CampaignID is the campaign held by the approval, if any.
+	CampaignID *uuid.UUID `db:"-"`
+}
+
+// Expired reports whether the approval can't be decided any more.
+func (a *Approval) Expired(now time.Time) bool {
+	return !now.Before(a.ExpiresAt)
+}
+
+// ApprovalDecision is what the second principal decided.
+type ApprovalDecision struct {
+	Approve bool
+	By      string
+	Reason  string
+	At      time.Time
+}
+
+// Status is the status the decision moves the approval to.
+func (d ApprovalDecision) Status() string {
+	if d.Approve {
+		return ApprovalApproved
+	}
+	return ApprovalRejected
+}
@@ -0,0 +1,84 @@
+package model
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// AuditEvent records a call that changed something, or tried to: who made
+// it, what it acted on and how it ended. Events are only ever appended, each
+// one chained to the one before it by Hash.
+type AuditEvent struct {
+	// Seq orders the events
+	Seq        int64     `db:"seq"`
+	OccurredAt time.Time `db:"occurred_at"`
+	// Actor is the principal, "router:<serial number>" for routers
+	Actor string `db:"actor"`
+	// Action is the method called, "Service.Method"
+	Action string `db:"action"`
+	// Target tells what the call acted on, e.g. "router_id=… command_type=REBOOT"
+	Target string `db:"target"`
+	// RequestDigest is the hex SHA-256 of the request
+	RequestDigest string `db:"request_digest"`
+	// Outcome is the gRPC status code of the call, "OK" if it succeeded
+	Outcome  string `db:"outcome"`
+	Error    string `db:"error"`
+	SourceIP string `db:"source_ip"`
+
+	// PrevHash is the Hash of the event before, none for the first one
+	PrevHash []byte `db:"prev_hash"`
+	Hash     []byte `db:"hash"`
+}
+
+// ComputeHash returns the SHA-256 of PrevHash and the fields of the event,
+// each as a big-endian uint32 length followed by its bytes; OccurredAt is in
+// Unix microseconds. Changing an event, or removing one, breaks the chain.
+func (e *AuditEvent) ComputeHash() []byte {
+	h := sha256.New()
+	for _, field := range [][]byte{
+		e.PrevHash,
+		[]byte(strconv.FormatInt(e.OccurredAt.UnixMicro(), 10)),
+		[]byte(e.Actor),
+		[]byte(e.Action),
+		[]byte(e.Target),
+		[]byte(e.RequestDigest),
+		[]byte(e.Outcome),
+		[]byte(e.Error),
+		[]byte(e.SourceIP),
+	} {
+		binary.Write(h, binary.BigEndian, uint32(len(field)))
+		h.Write(field)
+	}
+	return h.Sum(nil)
+}
+
+// VerifyAuditChain checks that events, consecutive and in order, are each
+// chained to the one before, the first one to prevHash, and that their
+// hashes match their fields. The error names the first event that isn't.
+func VerifyAuditChain(prevHash []byte, events []AuditEvent) error {
+	for i := range events {
+		event := &events[i]
+		if !bytes.Equal(event.PrevHash, prevHash) {
+			return fmt.Errorf("audit event %d doesn't follow the one before it", event.Seq)
+		}
+		if !bytes.Equal(event.Hash, event.ComputeHash()) {
+			return fmt.Errorf("audit event %d doesn't match its hash", event.Seq)
+		}
+		prevHash = event.Hash
+	}
+	return nil
+}
+
+// AuditFilter selects audit events; zero fields match everything and the
+// time range is [from, to).
+type AuditFilter struct {
+	Actor  string
+	Action string
+
+	OccurredFrom *time.Time
+	OccurredTo   *time.Time
+}
@@ -0,0 +1,169 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// campaign statuses
+const (
+	CampaignAwaitingApproval = "AWAITING_APPROVAL"
+	CampaignRunning          = "RUNNING"
+	CampaignPaused           = "PAUSED"
+	CampaignAborted          = "ABORTED"
+	CampaignCompleted        = "COMPLETED"
+)
+
+// Campaign rolls a command out to a set of routers in waves. A wave is
+// released once the soak time after the previous one has passed; the
+// campaign pauses itself when the failure rate of the commands released
+// since it was last started exceeds MaxFailureRate. A campaign of a command
+// type that needs approval is AWAITING_APPROVAL until its approval is
+// decided: approved it starts RUNNING, otherwise it is ABORTED.
+type Campaign struct {
+	ID          uuid.UUID `db:"id"`
+	Name        string    `db:"name"`
+	CommandType string    `db:"command_type"`
+	Priority    int       `db:"priority"`
+	// Payload of the commands, sealed like theirs if the type is sensitive
+	Payload json.RawMessage `db:"payload"`
+
+	Status      string     `db:"status"`
+	PauseReason string     `db:"pause_reason"`
+	ApprovalID  *uuid.UUID `db:"approval_id"`
+
+	TotalWaves  int `db:"total_waves"`
+	CurrentWave int `db:"current_wave"`
+	// waves before this one are left out of the failure rate, so that a
+	// resumed campaign isn't paused again for the same failures
+	CheckedFromWave int `db:"checked_from_wave"`
+
+	SoakTime       time.Duration `db:"soak_seconds"`
+	MaxFailureRate float64       `db:"max_failure_rate"`
+	NextWaveAt     *time.Time    `db:"next_wave_at"`
+
+	CreatedBy string    `db:"created_by"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+// RouterSelector picks the target routers of a campaign: an explicit list of
+// serial numbers, a serial number prefix or every known router.
+type RouterSelector struct {
+	SerialNumbers []string
+	SerialPrefix  string
+	All           bool
+}
+
+// Validate checks that exactly one way of selecting routers is used.
+func (s RouterSelector) Validate() error {
+	used := 0
+	if len(s.SerialNumbers) > 0 {
+		used++
+	}
+	if s.SerialPrefix != "" {
+		used++
+	}
+	if s.All {
+		used++
+	}
+	if used != 1 {
+		return fmt.Errorf("exactly one of serial numbers, serial prefix or all routers must be selected")
+	}
+	return nil
+}
+
+// WaveSize is either a number of routers or a percentage of all targets.
+type WaveSize struct {
+	Count   int
+	Percent float64
+}
+
+// PlanWaves splits total targets into waves. Targets left over after the
+// listed waves form one last wave; waves that would be empty are dropped.
+func PlanWaves(total int, sizes []WaveSize) ([]int, error) {
+	var waves []int
+	assigned := 0
+	for i, size := range sizes {
+		var n int
+		switch {
+		case size.Count > 0 && size.Percent > 0:
+			return nil, fmt.Errorf("wave %d has both a count and a percentage", i+1)
+		case size.Count > 0:
+			n = size.Count
+		case size.Percent > 0 && size.Percent <= 100:
+			n = int(math.Ceil(float64(total) * size.Percent / 100))
+		default:
+			return nil, fmt.Errorf("wave %d needs a positive count or a percentage up to 100", i+1)
+		}
+
+		n = min(n, total-assigned)
+		if n > 0 {
+			waves = append(waves, n)
+			assigned += n
+		}
+	}
+
+	if assigned < total {
+		waves = append(waves, total-assigned)
+	}
+
+	return waves, nil
+}
+
+// CampaignTarget is a router of a campaign and the wave it belongs to.
+// CommandID is set once its wave is released.
+type CampaignTarget struct {
+	CampaignID uuid.UUID  `db:"campaign_id"`
+	RouterID   uuid.UUID  `db:"router_id"`
+	Wave       int        `db:"wave"`
+	CommandID  *uuid.UUID `db:"command_id"`
+}
+
+// WaveProgress counts the targets of a wave by the status of their command;
+// targets of a wave that wasn't released yet are Unreleased.
+type WaveProgress struct {
+	Wave       int
+	Unreleased int
+	Statuses   map[string]int
+}
+
+// Targets is the number of routers in the wave.
+func (p WaveProgress) Targets() int {
+	total := p.Unreleased
+	for _, n := range p.Statuses {
+		total += n
+	}
+	return total
+}
+
+// Active reports whether any command of the wave can still change status.
+func (p WaveProgress) Active() bool {
+	for _, status := range []string{StatusPending, StatusBlocked, StatusSent, StatusCancelling} {
+		if p.Statuses[status] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// FailureRate is failed / (acked + failed) over the waves from fromWave on;
+// it is 0 while no command has finished.
+func FailureRate(progress []WaveProgress, fromWave int) float64 {
+	acked, failed := 0, 0
+	for _, wave := range progress {
+		if wave.Wave < fromWave {
+			continue
+		}
+		acked += wave.Statuses[StatusAcked]
+		failed += wave.Statuses[StatusFailed]
+	}
+	if acked+failed == 0 {
+		return 0
+	}
+	return float64(failed) / float64(acked+failed)
+}
@@ -0,0 +1,27 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CancelFilter selects the commands to cancel; empty fields match everything.
+type CancelFilter struct {
+	CommandID   *uuid.UUID
+	RouterID    *uuid.UUID
+	CommandType string
+	CampaignID  *uuid.UUID
+}
+
+// Empty reports whether the filter would match every command.
+func (f CancelFilter) Empty() bool {
+	return f.CommandID == nil && f.RouterID == nil && f.CommandType == "" && f.CampaignID == nil
+}
+
+// Cancellation records who cancelled commands and why.
+type Cancellation struct {
+	By     string
+	Reason string
+	At     time.Time
+}
@@ -7,13 +7,139 @@ import (
 	"github.com/google/uuid"
 )
 
+// command statuses
+const (
+	StatusPending = "PENDING"
+	StatusSent    = "SENT"
+	StatusAcked   = "ACKED"
+
+	// StatusCancelling is a SENT command that was cancelled; the router gets
+	// a cancellation notice on its next poll and the command becomes CANCELLED.
+	StatusCancelling = "CANCELLING"
+	StatusCancelled  = "CANCELLED"
+
+	// StatusBlocked is a workflow step waiting for its prerequisites to be ACKED.
+	StatusBlocked = "BLOCKED"
+	// StatusFailed is a SENT command the router reported as failed.
+	StatusFailed = "FAILED"
+	// StatusAwaitingApproval is a command held back until its approval is decided.
+	StatusAwaitingApproval = "AWAITING_APPROVAL"
+)
+
+// command priorities: commands are delivered by priority, highest first, and
+// then in order of creation
+const (
+	MinPriority     = 0
+	MaxPriority     = 100
+	DefaultPriority = 50
+)
+
 type Command struct {
 	ID          uuid.UUID       `db:"id"`
 	RouterID    uuid.UUID       `db:"router_id"`
 	CommandType string          `db:"command_type"`
 	Payload     json.RawMessage `db:"payload"`
 	Status      string          `db:"status"`
+	Priority    int             `db:"priority"`
 	SentAt      *time.Time      `db:"sent_at"`
 	AckedAt     *time.Time      `db:"acked_at"`
 	CreatedAt   time.Time       `db:"created_at"`
+
+	CancelledAt  *time.Time `db:"cancelled_at"`
+	CancelledBy  string     `db:"cancelled_by"`
+	CancelReason string     `db:"cancel_reason"`
+
+	// Error is what the router reported for a FAILED command.
+	Error string `db:"error"`
+
+	WorkflowID   *uuid.UUID `db:"workflow_id"`
+	WorkflowStep string     `db:"workflow_step"`
+
+	CampaignID *uuid.UUID `db:"campaign_id"`
+
+	ApprovalID *uuid.UUID `db:"approval_id"`
+
+	// signature of the command over its id, router, type, payload and
+	// validity, made when it is created and again when it is delivered;
+	// none for commands from before signing was set up
+	SignatureKeyID string     `db:"signature_key_id"`
+	Signature      []byte     `db:"signature"`
+	NotBefore      *time.Time `db:"not_before"`
+	ExpiresAt      *time.Time `db:"expires_at"`
+}
+
+// cancel reasons of commands that can't be delivered for their signature
+const (
+	// SignatureExpiredReason: the signature expired before delivery
+	SignatureExpiredReason = "signature expired"
+	// SignatureInvalidReason: the stored signature doesn't match the command
+	SignatureInvalidReason = "invalid signature"
+)
+
+// PreviousStatus returns the only status a command may move to status from.
+func PreviousStatus(status string) (string, bool) {
+	switch status {
+	case StatusSent:
+		return StatusPending, true
+	case StatusAcked, StatusFailed:
+		return StatusSent, true
+	case StatusCancelled:
+		return StatusCancelling, true
+	}
+	return "", false
+}
+
+// Transition moves the command to status if it is in the expected previous
+// status and reports whether anything changed.
+func (c *Command) Transition(status string, now time.Time) bool {
+	previous, ok := PreviousStatus(status)
+	if !ok || c.Status != previous {
+		return false
+	}
+
+	switch status {
+	case StatusSent:
+		c.SentAt = &now
+	case StatusAcked, StatusFailed:
+		c.AckedAt = &now
+	}
+	c.Status = status
+
+	return true
+}
+
+// statusStages orders the statuses along the life of a command: a command
+// only ever moves to a later stage.
+var statusStages = map[string]int{
+	StatusAwaitingApproval: 0,
+	StatusBlocked:          0,
+	StatusPending:          1,
+	StatusSent:             2,
+	StatusCancelling:       3,
+	StatusAcked:            4,
+	StatusFailed:           4,
+	StatusCancelled:        4,
+}
+
+// NewerThan reports whether c, a version of the same command as other, is
+// further along in its life: other is an outdated copy of it.
+func (c *Command) NewerThan(other *Command) bool {
+	return statusStages[c.Status] > statusStages[other.Status]
+}
+
+// ValidPriority reports whether priority is in the supported range.
+func ValidPriority(priority int) bool {
+	return priority >= MinPriority && priority <= MaxPriority
+}
+
+// DeliveredBefore reports whether c is delivered before other: higher
+// priority first, then older first; the id breaks ties.
+func (c *Command) DeliveredBefore(other *Command) bool {
+	if c.Priority != other.Priority {
+		return c.Priority > other.Priority
+	}
+	if !c.CreatedAt.Equal(other.CreatedAt) {
+		return c.CreatedAt.Before(other.CreatedAt)
+	}
+	return c.ID.String() < other.ID.String()
 }
@@ -0,0 +1,30 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CommandFilter selects commands for listing; zero fields match everything
+// and time ranges are [from, to).
+type CommandFilter struct {
+	RouterID     *uuid.UUID
+	SerialNumber string
+	CommandType  string
+	Statuses     []string
+
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	SentFrom    *time.Time
+	SentTo      *time.Time
+	AckedFrom   *time.Time
+	AckedTo     *time.Time
+}
+
+// Cursor is the position of the last command of a page; the next page
+// starts right after it in (created_at, id) descending order.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
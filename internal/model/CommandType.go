@@ -0,0 +1,176 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// coalescing policies: what SendCommand does when the router already has
+// PENDING commands of the same type
+const (
+	// CoalesceKeepAll queues every command
+	CoalesceKeepAll = "KEEP_ALL"
+	// CoalesceReplacePending cancels the older PENDING commands in favour of the new one
+	CoalesceReplacePending = "REPLACE_PENDING"
+	// CoalesceDropIdentical doesn't queue a command if an identical one is PENDING
+	CoalesceDropIdentical = "DROP_IF_IDENTICAL"
+)
+
+// CommandType describes how commands of one type are handled.
+type CommandType struct {
+	Name     string
+	Coalesce string
+	// Priority is used when SendCommand doesn't set one
+	Priority int
+	// Sensitive payloads are encrypted at rest and only shown to callers
+	// allowed to RevealPayloads
+	Sensitive bool
+}
+
+var commandTypes = map[string]CommandType{
+	"REBOOT":              {Name: "REBOOT", Coalesce: CoalesceDropIdentical, Priority: DefaultPriority},
+	"FACTORY_RESET":       {Name: "FACTORY_RESET", Coalesce: CoalesceDropIdentical, Priority: DefaultPriority},
+	"FACTORY_RESET_ABORT": {Name: "FACTORY_RESET_ABORT", Coalesce: CoalesceDropIdentical, Priority: MaxPriority},
+	"SECURITY_PATCH":      {Name: "SECURITY_PATCH", Coalesce: CoalesceReplacePending, Priority: 90},
+	"UPDATE_FIRMWARE":     {Name: "UPDATE_FIRMWARE", Coalesce: CoalesceReplacePending, Priority: DefaultPriority},
+	"SET_WIFI_PASSWORD":   {Name: "SET_WIFI_PASSWORD", Coalesce: CoalesceReplacePending, Priority: DefaultPriority, Sensitive: true},
+	"RUN_DIAGNOSTICS":     {Name: "RUN_DIAGNOSTICS", Coalesce: CoalesceKeepAll, Priority: 10},
+}
+
+// LookupCommandType returns the definition of a command type. Unknown types
+// are accepted and keep every command.
+func LookupCommandType(name string) CommandType {
+	if commandType, ok := commandTypes[name]; ok {
+		return commandType
+	}
+	return CommandType{Name: name, Coalesce: CoalesceKeepAll, Priority: DefaultPriority}
+}
+
+// DefaultPayload is the payload of commands of the type sent without one.
+func DefaultPayload(commandType string) json.RawMessage {
+	return json.RawMessage(fmt.Sprintf(`{"command": "%s"}`, commandType))
+}
+
+// CoalesceResult tells what a coalescing save did with the new command.
+type CoalesceResult struct {
+	// ExistingID is the identical PENDING command the new one was dropped
+	// in favour of; the new command was not saved.
+	ExistingID *uuid.UUID
+	// Replaced are the older PENDING commands cancelled in favour of the new one.
+	Replaced []Command
+	// AlreadySaved is set if a command with the id of the new one exists: an
+	// earlier attempt of the same send saved it, nothing was written again.
+	AlreadySaved bool
+}
+
+// Coalesce applies the policy to the new command given the router's current
+// commands. It returns the commands to update, or the id of the existing
+// command if the new one must not be saved.
+func Coalesce(policy string, command *Command, current []Command, now time.Time) (*CoalesceResult, error) {
+	result := &CoalesceResult{}
+
+	switch policy {
+	case CoalesceKeepAll:
+	case CoalesceDropIdentical:
+		for _, existing := range current {
+			if existing.Status == StatusPending && existing.CommandType == command.CommandType &&
+				SamePayload(existing.Payload, command.Payload) {
+				id := existing.ID
+				result.ExistingID = &id
+				return result, nil
+			}
+		}
+	case CoalesceReplacePending:
+		for _, existing := range current {
+			if existing.Status == StatusPending && existing.CommandType == command.CommandType {
+				existing.Supersede(command.ID, now)
+				result.Replaced = append(result.Replaced, existing)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown coalescing policy: %s", policy)
+	}
+
+	return result, nil
+}
+
+// CoalesceBatch applies the policy to commands sent one after another, given
+// the PENDING commands of their routers: a command is coalesced with the
+// existing ones and with those before it in cmds. It returns the result of
+// each command, the commands to insert and the existing commands replaced;
+// a command of cmds that is replaced later on is inserted cancelled.
+func CoalesceBatch(policy string, cmds []Command, pending []Command, now time.Time) ([]*CoalesceResult, []Command, []Command, error) {
+	type queue struct {
+		routerId    uuid.UUID
+		commandType string
+	}
+	queues := make(map[queue][]Command)
+	for _, cmd := range pending {
+		key := queue{cmd.RouterID, cmd.CommandType}
+		queues[key] = append(queues[key], cmd)
+	}
+
+	results := make([]*CoalesceResult, len(cmds))
+	var inserted, replaced []Command
+	insertedAt := make(map[uuid.UUID]int)
+
+	for i := range cmds {
+		cmd := &cmds[i]
+		key := queue{cmd.RouterID, cmd.CommandType}
+
+		result, err := Coalesce(policy, cmd, queues[key], now)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		results[i] = result
+		if result.ExistingID != nil {
+			continue
+		}
+
+		// a replaced command may be one of the batch that isn't written yet
+		for _, superseded := range result.Replaced {
+			if j, ok := insertedAt[superseded.ID]; ok {
+				inserted[j] = superseded
+			} else {
+				replaced = append(replaced, superseded)
+			}
+		}
+		if len(result.Replaced) > 0 {
+			queues[key] = nil
+		}
+
+		insertedAt[cmd.ID] = len(inserted)
+		inserted = append(inserted, *cmd)
+		queues[key] = append(queues[key], *cmd)
+	}
+
+	return results, inserted, replaced, nil
+}
+
+// Supersede cancels a PENDING command that was replaced by a newer one.
+func (c *Command) Supersede(by uuid.UUID, now time.Time) {
+	c.Status = StatusCancelled
+	c.CancelledAt = &now
+	c.CancelledBy = SystemActor
+	c.CancelReason = SupersededReason(by)
+}
+
+// SystemActor is recorded as the author of changes made by the service itself.
+const SystemActor = "system"
+
+func SupersededReason(by uuid.UUID) string {
+	return "superseded by " + by.String()
+}
+
+// SamePayload compares JSON payloads ignoring formatting and key order.
+func SamePayload(a, b json.RawMessage) bool {
+	var left, right interface{}
+	if json.Unmarshal(a, &left) != nil || json.Unmarshal(b, &right) != nil {
+		return string(a) == string(b)
+	}
+	return reflect.DeepEqual(left, right)
+}
@@ -0,0 +1,30 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// IdempotencyKey remembers the result of a SendCommand call so that a retry
+// with the same key returns the same commands. Calls that were turned into a
+// job store JobID instead of CommandIDs. ApprovalID is set if the commands
+// await approval.
+//
+// A call holds its key until LeaseExpiresAt; CompletedAt is set once it
+// finished, even if it created nothing. A retry takes over a key whose call
+// neither finished nor holds it any more. The ids of everything the call
+// creates are derived from ID, which the retry keeps, so it finds what the
+// earlier attempt created instead of creating it again.
+type IdempotencyKey struct {
+	Caller         string      `db:"caller"`
+	Key            string      `db:"idempotency_key"`
+	RequestHash    string      `db:"request_hash"`
+	ID             uuid.UUID   `db:"id"`
+	CommandIDs     []uuid.UUID `db:"command_ids"`
+	JobID          *uuid.UUID  `db:"job_id"`
+	ApprovalID     *uuid.UUID  `db:"approval_id"`
+	CreatedAt      time.Time   `db:"created_at"`
+	LeaseExpiresAt time.Time   `db:"lease_expires_at"`
+	CompletedAt    *time.Time  `db:"completed_at"`
+}
@@ -0,0 +1,85 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// job statuses; a RUNNING job that is cancelled is CANCELLING until its
+// worker notices
+const (
+	JobPending    = "PENDING"
+	JobRunning    = "RUNNING"
+	JobCancelling = "CANCELLING"
+	JobSucceeded  = "SUCCEEDED"
+	JobFailed     = "FAILED"
+	JobCancelled  = "CANCELLED"
+)
+
+// job kinds
+const (
+	JobSendCommand = "SEND_COMMAND"
+)
+
+// ErrJobCancelled is returned by a job handler that stopped because the job
+// was cancelled.
+var ErrJobCancelled = errors.New("job cancelled")
+
+// ErrJobLeaseLost means another worker took the job over, e.g. because this
+// one didn't renew its lease in time.
+var ErrJobLeaseLost = errors.New("job lease lost")
+
+// Job is an operation that runs in the background after the call that
+// started it has returned. Processed counts the items of Total done so far;
+// a job taken over by another worker goes on from there.
+type Job struct {
+	ID      uuid.UUID       `db:"id"`
+	Kind    string          `db:"kind"`
+	Status  string          `db:"status"`
+	Request json.RawMessage `db:"request"`
+
+	Total     int    `db:"total"`
+	Processed int    `db:"processed"`
+	Error     string `db:"error"`
+
+	// the worker running the job, until its lease expires
+	LeaseOwner     string     `db:"lease_owner"`
+	LeaseExpiresAt *time.Time `db:"lease_expires_at"`
+
+	// the approval the commands created by the job await
+	ApprovalID *uuid.UUID `db:"approval_id"`
+
+	CreatedBy  string     `db:"created_by"`
+	CreatedAt  time.Time  `db:"created_at"`
+	UpdatedAt  time.Time  `db:"updated_at"`
+	FinishedAt *time.Time `db:"finished_at"`
+}
+
+// Finish moves the job to its final status.
+func (j *Job) Finish(err error, now time.Time) {
+	switch {
+	case err == nil:
+		j.Status = JobSucceeded
+	case errors.Is(err, ErrJobCancelled):
+		j.Status = JobCancelled
+	default:
+		j.Status = JobFailed
+		j.Error = err.Error()
+	}
+	j.UpdatedAt = now
+	j.FinishedAt = &now
+}
+
+// Done reports whether the job reached a final status.
+func (j *Job) Done() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed || j.Status == JobCancelled
+}
+
+// JobFilter selects jobs for listing; zero fields match everything.
+type JobFilter struct {
+	Kind     string
+	Statuses []string
+}
@@ -0,0 +1,70 @@
+package model
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// AnyTarget stands for any command type or any router, when a call doesn't
+// name them: only permissions without that restriction cover it.
+const AnyTarget = "*"
+
+// RevealPayloads is not a method but is granted like one: callers need it to
+// see the payloads of sensitive command types. "*" grants it.
+const RevealPayloads = "Payloads.Reveal"
+
+// Role is a named set of permissions. Principals get their roles from their
+// API key or JWT.
+type Role struct {
+	Name        string       `db:"name"`
+	Description string       `db:"description"`
+	Permissions []Permission `db:"permissions"`
+	UpdatedBy   string       `db:"updated_by"`
+	UpdatedAt   time.Time    `db:"updated_at"`
+}
+
+// Permission allows calling Methods: "Service.Method", "Service.*" or "*".
+// With CommandTypes only for commands of those types, with SerialPrefixes
+// only for routers whose serial number starts with one of the prefixes.
+type Permission struct {
+	Methods        []string `json:"methods"`
+	CommandTypes   []string `json:"command_types,omitempty"`
+	SerialPrefixes []string `json:"serial_prefixes,omitempty"`
+}
+
+// AllowsMethod reports whether the permission covers the method, whatever
+// it acts on.
+func (p *Permission) AllowsMethod(method string) bool {
+	service, _, _ := strings.Cut(method, ".")
+	for _, pattern := range p.Methods {
+		if pattern == "*" || pattern == method || pattern == service+".*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether the permission covers calling the method for a
+// command type on routers whose serial numbers start with serialPrefix; a
+// single router is its whole serial number. Either may be AnyTarget.
+func (p *Permission) Allows(method, commandType, serialPrefix string) bool {
+	if !p.AllowsMethod(method) {
+		return false
+	}
+	if len(p.CommandTypes) > 0 && (commandType == AnyTarget || !slices.Contains(p.CommandTypes, commandType)) {
+		return false
+	}
+	if len(p.SerialPrefixes) == 0 {
+		return true
+	}
+	if serialPrefix == AnyTarget {
+		return false
+	}
+	for _, prefix := range p.SerialPrefixes {
+		if strings.HasPrefix(serialPrefix, prefix) {
+			return true
+		}
+	}
+	return false
+}
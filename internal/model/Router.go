@@ -13,4 +13,20 @@ type Router struct {
 	IPAddress    net.IP     `db:"ip_address"`
 	LastSeenAt   *time.Time `db:"last_seen_at"`
 	CreatedAt    time.Time  `db:"created_at"`
+	// set once the router is taken out of service; it gets no new commands
+	DecommissionedAt *time.Time `db:"decommissioned_at"`
 }
+
+// plan actions of a router in SendCommand
+const (
+	PlanCreate   = "CREATE"
+	PlanCoalesce = "COALESCE"
+	PlanSkip     = "SKIP"
+)
+
+// reasons for SendCommand to skip a router
+const (
+	SkipInvalid        = "INVALID"
+	SkipUnknown        = "UNKNOWN_ROUTER"
+	SkipDecommissioned = "DECOMMISSIONED"
+)
@@ -0,0 +1,27 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RouterSecretPrefix starts every router secret.
+const RouterSecretPrefix = "rms_"
+
+// RouterCredential is the secret a router authenticates its polls and acks
+// with. Only its hash is stored, on the router row; the secret is returned
+// once, when it is issued.
+type RouterCredential struct {
+	RouterID     uuid.UUID `db:"id"`
+	SerialNumber string    `db:"serial_number"`
+	SecretHash   string    `db:"credential_hash"`
+	IssuedBy     string    `db:"credential_issued_by"`
+	IssuedAt     time.Time `db:"credential_issued_at"`
+}
+
+// NewRouterSecret returns a random router secret and its hash, which is
+// computed like the hash of an API key.
+func NewRouterSecret() (string, string, error) {
+	return newSecret(RouterSecretPrefix)
+}
@@ -0,0 +1,120 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// workflow statuses, derived from the statuses of the steps
+const (
+	WorkflowRunning   = "RUNNING"
+	WorkflowCompleted = "COMPLETED"
+	WorkflowFailed    = "FAILED"
+	WorkflowCancelled = "CANCELLED"
+)
+
+// Workflow is a DAG of commands for one router. A step is BLOCKED until all
+// its prerequisites are ACKED; if one of them fails or is cancelled, the
+// steps depending on it are cancelled.
+type Workflow struct {
+	ID        uuid.UUID `db:"id"`
+	RouterID  uuid.UUID `db:"router_id"`
+	Name      string    `db:"name"`
+	CreatedAt time.Time `db:"created_at"`
+
+	Steps []WorkflowStep
+}
+
+// WorkflowStep is a command of the workflow; Command.WorkflowStep is its key.
+type WorkflowStep struct {
+	Command   Command
+	DependsOn []string
+}
+
+// Status summarizes the statuses of the steps.
+func (w *Workflow) Status() string {
+	failed, running, cancelled := false, false, false
+	for _, step := range w.Steps {
+		switch step.Command.Status {
+		case StatusFailed:
+			failed = true
+		case StatusCancelled:
+			cancelled = true
+		case StatusAcked:
+		default:
+			running = true
+		}
+	}
+
+	switch {
+	case failed:
+		return WorkflowFailed
+	case running:
+		return WorkflowRunning
+	case cancelled:
+		return WorkflowCancelled
+	}
+	return WorkflowCompleted
+}
+
+// ValidateSteps checks that step keys are unique, prerequisites exist and
+// the dependencies have no cycle.
+func ValidateSteps(steps []WorkflowStep) error {
+	if len(steps) == 0 {
+		return fmt.Errorf("workflow has no steps")
+	}
+
+	dependsOn := make(map[string][]string, len(steps))
+	for _, step := range steps {
+		key := step.Command.WorkflowStep
+		if key == "" {
+			return fmt.Errorf("step key is required")
+		}
+		if _, ok := dependsOn[key]; ok {
+			return fmt.Errorf("duplicate step key %q", key)
+		}
+		dependsOn[key] = step.DependsOn
+	}
+
+	for key, prerequisites := range dependsOn {
+		for _, prerequisite := range prerequisites {
+			if _, ok := dependsOn[prerequisite]; !ok {
+				return fmt.Errorf("step %q depends on unknown step %q", key, prerequisite)
+			}
+		}
+	}
+
+	// depth-first search, a step met again while on the stack closes a cycle
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int, len(steps))
+	var visit func(key string) error
+	visit = func(key string) error {
+		switch state[key] {
+		case visiting:
+			return fmt.Errorf("dependency cycle through step %q", key)
+		case done:
+			return nil
+		}
+		state[key] = visiting
+		for _, prerequisite := range dependsOn[key] {
+			if err := visit(prerequisite); err != nil {
+				return err
+			}
+		}
+		state[key] = done
+		return nil
+	}
+
+	for _, step := range steps {
+		if err := visit(step.Command.WorkflowStep); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
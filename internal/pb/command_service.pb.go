@@ -1,6 +1,6 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // versions:
-// 	protoc-gen-go v1.36.7
+// 	protoc-gen-go v1.36.8
 // 	protoc        v6.32.0--rc2
 // source: command_service.proto
 
@@ -10,6 +10,7 @@ import (
 	_ "google.golang.org/genproto/googleapis/api/annotations"
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
 	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
 	reflect "reflect"
 	sync "sync"
@@ -76,11 +77,21 @@ func (x *Router) GetSerialNumber() string {
 	return ""
 }
 
-// тело отправки команды роутеру
+// тело отправки команды роутеру;
+// повтор запроса с тем же idempotency_key от того же клиента
+// возвращает id уже созданных команд;
+// priority от 0 до 100, больше = срочнее; если не задан,
+// берётся приоритет типа команды (по умолчанию 50)
 type SendCommandRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Routers       []*Router              `protobuf:"bytes,1,rep,name=routers,proto3" json:"routers,omitempty"`
-	CommandType   string                 `protobuf:"bytes,2,opt,name=command_type,json=commandType,proto3" json:"command_type,omitempty"`
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Routers        []*Router              `protobuf:"bytes,1,rep,name=routers,proto3" json:"routers,omitempty"`
+	CommandType    string                 `protobuf:"bytes,2,opt,name=command_type,json=commandType,proto3" json:"command_type,omitempty"`
+	IdempotencyKey string                 `protobuf:"bytes,3,opt,name=idempotency_key,json=idempotencyKey,proto3" json:"idempotency_key,omitempty"`
+	Priority       *int32                 `protobuf:"varint,4,opt,name=priority,proto3,oneof" json:"priority,omitempty"`
+	// ничего не сохранять, только вернуть план по каждому роутеру
+	DryRun bool `protobuf:"varint,5,opt,name=dry_run,json=dryRun,proto3" json:"dry_run,omitempty"`
+	// JSON, который получит роутер; если не задан - {"command": "<command_type>"}
+	Payload       string `protobuf:"bytes,6,opt,name=payload,proto3" json:"payload,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -129,11 +140,41 @@ func (x *SendCommandRequest) GetCommandType() string {
 	return ""
 }
 
-// тело запроса команд роутера
+func (x *SendCommandRequest) GetIdempotencyKey() string {
+	if x != nil {
+		return x.IdempotencyKey
+	}
+	return ""
+}
+
+func (x *SendCommandRequest) GetPriority() int32 {
+	if x != nil && x.Priority != nil {
+		return *x.Priority
+	}
+	return 0
+}
+
+func (x *SendCommandRequest) GetDryRun() bool {
+	if x != nil {
+		return x.DryRun
+	}
+	return false
+}
+
+func (x *SendCommandRequest) GetPayload() string {
+	if x != nil {
+		return x.Payload
+	}
+	return ""
+}
+
+// тело запроса команд роутера;
+// max_commands ограничивает число команд в ответе, 0 = без ограничения
 type PollRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	RouterId      string                 `protobuf:"bytes,1,opt,name=router_id,json=routerId,proto3" json:"router_id,omitempty"`
 	SerialNumber  string                 `protobuf:"bytes,2,opt,name=serial_number,json=serialNumber,proto3" json:"serial_number,omitempty"`
+	MaxCommands   uint32                 `protobuf:"varint,3,opt,name=max_commands,json=maxCommands,proto3" json:"max_commands,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -182,12 +223,24 @@ func (x *PollRequest) GetSerialNumber() string {
 	return ""
 }
 
-// тело запроса "ack"
+func (x *PollRequest) GetMaxCommands() uint32 {
+	if x != nil {
+		return x.MaxCommands
+	}
+	return 0
+}
+
+// тело запроса "ack";
+// без command_id подтверждаются все отправленные команды роутера,
+// с command_id - только она; непустой error переводит команду
+// в FAILED (требует command_id), зависящие от неё шаги workflow отменяются
 type AckRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	RouterId      string                 `protobuf:"bytes,1,opt,name=router_id,json=routerId,proto3" json:"router_id,omitempty"`
 	SerialNumber  string                 `protobuf:"bytes,2,opt,name=serial_number,json=serialNumber,proto3" json:"serial_number,omitempty"`
 	CommandType   string                 `protobuf:"bytes,3,opt,name=command_type,json=commandType,proto3" json:"command_type,omitempty"`
+	CommandId     string                 `protobuf:"bytes,4,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"`
+	Error         string                 `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -243,29 +296,61 @@ func (x *AckRequest) GetCommandType() string {
 	return ""
 }
 
-// ответ на отправку команды = статус
-type SendCommandResponse struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Status        string                 `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
-	Id            []string               `protobuf:"bytes,2,rep,name=id,proto3" json:"id,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+func (x *AckRequest) GetCommandId() string {
+	if x != nil {
+		return x.CommandId
+	}
+	return ""
 }
 
-func (x *SendCommandResponse) Reset() {
-	*x = SendCommandResponse{}
+func (x *AckRequest) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+// полная информация о команде для операторов
+type CommandInfo struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	Id           string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	RouterId     string                 `protobuf:"bytes,2,opt,name=router_id,json=routerId,proto3" json:"router_id,omitempty"`
+	CommandType  string                 `protobuf:"bytes,3,opt,name=command_type,json=commandType,proto3" json:"command_type,omitempty"`
+	Payload      string                 `protobuf:"bytes,4,opt,name=payload,proto3" json:"payload,omitempty"`
+	Status       string                 `protobuf:"bytes,5,opt,name=status,proto3" json:"status,omitempty"`
+	CreatedAt    *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	SentAt       *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=sent_at,json=sentAt,proto3" json:"sent_at,omitempty"`
+	AckedAt      *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=acked_at,json=ackedAt,proto3" json:"acked_at,omitempty"`
+	CancelledAt  *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=cancelled_at,json=cancelledAt,proto3" json:"cancelled_at,omitempty"`
+	CancelledBy  string                 `protobuf:"bytes,10,opt,name=cancelled_by,json=cancelledBy,proto3" json:"cancelled_by,omitempty"`
+	CancelReason string                 `protobuf:"bytes,11,opt,name=cancel_reason,json=cancelReason,proto3" json:"cancel_reason,omitempty"`
+	Priority     int32                  `protobuf:"varint,12,opt,name=priority,proto3" json:"priority,omitempty"`
+	Error        string                 `protobuf:"bytes,13,opt,name=error,proto3" json:"error,omitempty"`
+	WorkflowId   string                 `protobuf:"bytes,14,opt,name=workflow_id,json=workflowId,proto3" json:"workflow_id,omitempty"`
+	WorkflowStep string                 `protobuf:"bytes,15,opt,name=workflow_step,json=workflowStep,proto3" json:"workflow_step,omitempty"`
+	CampaignId   string                 `protobuf:"bytes,16,opt,name=campaign_id,json=campaignId,proto3" json:"campaign_id,omitempty"`
+	ApprovalId   string                 `protobuf:"bytes,17,opt,name=approval_id,json=approvalId,proto3" json:"approval_id,omitempty"`
+	// payload скрыт: тип команды чувствительный, а у вызывающего
+	// нет права Payloads.Reveal
+	PayloadRedacted bool `protobuf:"varint,18,opt,name=payload_redacted,json=payloadRedacted,proto3" json:"payload_redacted,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *CommandInfo) Reset() {
+	*x = CommandInfo{}
 	mi := &file_command_service_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *SendCommandResponse) String() string {
+func (x *CommandInfo) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*SendCommandResponse) ProtoMessage() {}
+func (*CommandInfo) ProtoMessage() {}
 
-func (x *SendCommandResponse) ProtoReflect() protoreflect.Message {
+func (x *CommandInfo) ProtoReflect() protoreflect.Message {
 	mi := &file_command_service_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -277,50 +362,159 @@ func (x *SendCommandResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use SendCommandResponse.ProtoReflect.Descriptor instead.
-func (*SendCommandResponse) Descriptor() ([]byte, []int) {
+// Deprecated: Use CommandInfo.ProtoReflect.Descriptor instead.
+func (*CommandInfo) Descriptor() ([]byte, []int) {
 	return file_command_service_proto_rawDescGZIP(), []int{4}
 }
 
-func (x *SendCommandResponse) GetStatus() string {
+func (x *CommandInfo) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *CommandInfo) GetRouterId() string {
+	if x != nil {
+		return x.RouterId
+	}
+	return ""
+}
+
+func (x *CommandInfo) GetCommandType() string {
+	if x != nil {
+		return x.CommandType
+	}
+	return ""
+}
+
+func (x *CommandInfo) GetPayload() string {
+	if x != nil {
+		return x.Payload
+	}
+	return ""
+}
+
+func (x *CommandInfo) GetStatus() string {
 	if x != nil {
 		return x.Status
 	}
 	return ""
 }
 
-func (x *SendCommandResponse) GetId() []string {
+func (x *CommandInfo) GetCreatedAt() *timestamppb.Timestamp {
 	if x != nil {
-		return x.Id
+		return x.CreatedAt
 	}
 	return nil
 }
 
-// информация о команде роутера
-type Command struct {
+func (x *CommandInfo) GetSentAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.SentAt
+	}
+	return nil
+}
+
+func (x *CommandInfo) GetAckedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.AckedAt
+	}
+	return nil
+}
+
+func (x *CommandInfo) GetCancelledAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CancelledAt
+	}
+	return nil
+}
+
+func (x *CommandInfo) GetCancelledBy() string {
+	if x != nil {
+		return x.CancelledBy
+	}
+	return ""
+}
+
+func (x *CommandInfo) GetCancelReason() string {
+	if x != nil {
+		return x.CancelReason
+	}
+	return ""
+}
+
+func (x *CommandInfo) GetPriority() int32 {
+	if x != nil {
+		return x.Priority
+	}
+	return 0
+}
+
+func (x *CommandInfo) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *CommandInfo) GetWorkflowId() string {
+	if x != nil {
+		return x.WorkflowId
+	}
+	return ""
+}
+
+func (x *CommandInfo) GetWorkflowStep() string {
+	if x != nil {
+		return x.WorkflowStep
+	}
+	return ""
+}
+
+func (x *CommandInfo) GetCampaignId() string {
+	if x != nil {
+		return x.CampaignId
+	}
+	return ""
+}
+
+func (x *CommandInfo) GetApprovalId() string {
+	if x != nil {
+		return x.ApprovalId
+	}
+	return ""
+}
+
+func (x *CommandInfo) GetPayloadRedacted() bool {
+	if x != nil {
+		return x.PayloadRedacted
+	}
+	return false
+}
+
+// запрос команды по id
+type GetCommandRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
-	CommandType   string                 `protobuf:"bytes,2,opt,name=command_type,json=commandType,proto3" json:"command_type,omitempty"`
-	Payload       string                 `protobuf:"bytes,3,opt,name=payload,proto3" json:"payload,omitempty"`
-	CreatedAt     *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	CommandId     string                 `protobuf:"bytes,1,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *Command) Reset() {
-	*x = Command{}
+func (x *GetCommandRequest) Reset() {
+	*x = GetCommandRequest{}
 	mi := &file_command_service_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *Command) String() string {
+func (x *GetCommandRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*Command) ProtoMessage() {}
+func (*GetCommandRequest) ProtoMessage() {}
 
-func (x *Command) ProtoReflect() protoreflect.Message {
+func (x *GetCommandRequest) ProtoReflect() protoreflect.Message {
 	mi := &file_command_service_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -332,61 +526,41 @@ func (x *Command) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use Command.ProtoReflect.Descriptor instead.
-func (*Command) Descriptor() ([]byte, []int) {
+// Deprecated: Use GetCommandRequest.ProtoReflect.Descriptor instead.
+func (*GetCommandRequest) Descriptor() ([]byte, []int) {
 	return file_command_service_proto_rawDescGZIP(), []int{5}
 }
 
-func (x *Command) GetId() string {
-	if x != nil {
-		return x.Id
-	}
-	return ""
-}
-
-func (x *Command) GetCommandType() string {
-	if x != nil {
-		return x.CommandType
-	}
-	return ""
-}
-
-func (x *Command) GetPayload() string {
+func (x *GetCommandRequest) GetCommandId() string {
 	if x != nil {
-		return x.Payload
+		return x.CommandId
 	}
 	return ""
 }
 
-func (x *Command) GetCreatedAt() *timestamppb.Timestamp {
-	if x != nil {
-		return x.CreatedAt
-	}
-	return nil
-}
-
-// список команд роутера
-type PollResponse struct {
+// интервал времени [from, to), любая граница может быть пустой
+type TimeRange struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Commands      []*Command             `protobuf:"bytes,1,rep,name=commands,proto3" json:"commands,omitempty"`
+	From          *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=from,proto3" json:"from,omitempty"`
+	To            *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=to,proto3" json:"to,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *PollResponse) Reset() {
-	*x = PollResponse{}
+func (x *TimeRange) Reset() {
+	*x = TimeRange{}
 	mi := &file_command_service_proto_msgTypes[6]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *PollResponse) String() string {
+func (x *TimeRange) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*PollResponse) ProtoMessage() {}
+func (*TimeRange) ProtoMessage() {}
 
-func (x *PollResponse) ProtoReflect() protoreflect.Message {
+func (x *TimeRange) ProtoReflect() protoreflect.Message {
 	mi := &file_command_service_proto_msgTypes[6]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -398,40 +572,56 @@ func (x *PollResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use PollResponse.ProtoReflect.Descriptor instead.
-func (*PollResponse) Descriptor() ([]byte, []int) {
+// Deprecated: Use TimeRange.ProtoReflect.Descriptor instead.
+func (*TimeRange) Descriptor() ([]byte, []int) {
 	return file_command_service_proto_rawDescGZIP(), []int{6}
 }
 
-func (x *PollResponse) GetCommands() []*Command {
+func (x *TimeRange) GetFrom() *timestamppb.Timestamp {
 	if x != nil {
-		return x.Commands
+		return x.From
 	}
 	return nil
 }
 
-// ответ на "ack" = статус 'ACKED'
-type AckResponse struct {
+func (x *TimeRange) GetTo() *timestamppb.Timestamp {
+	if x != nil {
+		return x.To
+	}
+	return nil
+}
+
+// фильтры списка команд; результат отсортирован по created_at и id
+// от новых к старым, страницы передаются через page_token
+type ListCommandsRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Status        string                 `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
+	RouterId      string                 `protobuf:"bytes,1,opt,name=router_id,json=routerId,proto3" json:"router_id,omitempty"`
+	SerialNumber  string                 `protobuf:"bytes,2,opt,name=serial_number,json=serialNumber,proto3" json:"serial_number,omitempty"`
+	CommandType   string                 `protobuf:"bytes,3,opt,name=command_type,json=commandType,proto3" json:"command_type,omitempty"`
+	Status        []string               `protobuf:"bytes,4,rep,name=status,proto3" json:"status,omitempty"`
+	Created       *TimeRange             `protobuf:"bytes,5,opt,name=created,proto3" json:"created,omitempty"`
+	Sent          *TimeRange             `protobuf:"bytes,6,opt,name=sent,proto3" json:"sent,omitempty"`
+	Acked         *TimeRange             `protobuf:"bytes,7,opt,name=acked,proto3" json:"acked,omitempty"`
+	PageSize      int32                  `protobuf:"varint,8,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	PageToken     string                 `protobuf:"bytes,9,opt,name=page_token,json=pageToken,proto3" json:"page_token,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *AckResponse) Reset() {
-	*x = AckResponse{}
+func (x *ListCommandsRequest) Reset() {
+	*x = ListCommandsRequest{}
 	mi := &file_command_service_proto_msgTypes[7]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *AckResponse) String() string {
+func (x *ListCommandsRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*AckResponse) ProtoMessage() {}
+func (*ListCommandsRequest) ProtoMessage() {}
 
-func (x *AckResponse) ProtoReflect() protoreflect.Message {
+func (x *ListCommandsRequest) ProtoReflect() protoreflect.Message {
 	mi := &file_command_service_proto_msgTypes[7]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -443,55 +633,4184 @@ func (x *AckResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use AckResponse.ProtoReflect.Descriptor instead.
-func (*AckResponse) Descriptor() ([]byte, []int) {
+// Deprecated: Use ListCommandsRequest.ProtoReflect.Descriptor instead.
+func (*ListCommandsRequest) Descriptor() ([]byte, []int) {
 	return file_command_service_proto_rawDescGZIP(), []int{7}
 }
 
-func (x *AckResponse) GetStatus() string {
+func (x *ListCommandsRequest) GetRouterId() string {
+	if x != nil {
+		return x.RouterId
+	}
+	return ""
+}
+
+func (x *ListCommandsRequest) GetSerialNumber() string {
+	if x != nil {
+		return x.SerialNumber
+	}
+	return ""
+}
+
+func (x *ListCommandsRequest) GetCommandType() string {
+	if x != nil {
+		return x.CommandType
+	}
+	return ""
+}
+
+func (x *ListCommandsRequest) GetStatus() []string {
 	if x != nil {
 		return x.Status
 	}
+	return nil
+}
+
+func (x *ListCommandsRequest) GetCreated() *TimeRange {
+	if x != nil {
+		return x.Created
+	}
+	return nil
+}
+
+func (x *ListCommandsRequest) GetSent() *TimeRange {
+	if x != nil {
+		return x.Sent
+	}
+	return nil
+}
+
+func (x *ListCommandsRequest) GetAcked() *TimeRange {
+	if x != nil {
+		return x.Acked
+	}
+	return nil
+}
+
+func (x *ListCommandsRequest) GetPageSize() int32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *ListCommandsRequest) GetPageToken() string {
+	if x != nil {
+		return x.PageToken
+	}
 	return ""
 }
 
-var File_command_service_proto protoreflect.FileDescriptor
+// страница списка команд
+type ListCommandsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Commands      []*CommandInfo         `protobuf:"bytes,1,rep,name=commands,proto3" json:"commands,omitempty"`
+	NextPageToken string                 `protobuf:"bytes,2,opt,name=next_page_token,json=nextPageToken,proto3" json:"next_page_token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
 
-const file_command_service_proto_rawDesc = "" +
-	"\n" +
-	"\x15command_service.proto\x12\x05proto\x1a\x1fgoogle/protobuf/timestamp.proto\x1a\x1cgoogle/api/annotations.proto\"J\n" +
-	"\x06Router\x12\x1b\n" +
-	"\trouter_id\x18\x01 \x01(\tR\brouterId\x12#\n" +
-	"\rserial_number\x18\x02 \x01(\tR\fserialNumber\"`\n" +
-	"\x12SendCommandRequest\x12'\n" +
-	"\arouters\x18\x01 \x03(\v2\r.proto.RouterR\arouters\x12!\n" +
-	"\fcommand_type\x18\x02 \x01(\tR\vcommandType\"O\n" +
-	"\vPollRequest\x12\x1b\n" +
-	"\trouter_id\x18\x01 \x01(\tR\brouterId\x12#\n" +
-	"\rserial_number\x18\x02 \x01(\tR\fserialNumber\"q\n" +
-	"\n" +
-	"AckRequest\x12\x1b\n" +
+func (x *ListCommandsResponse) Reset() {
+	*x = ListCommandsResponse{}
+	mi := &file_command_service_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListCommandsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListCommandsResponse) ProtoMessage() {}
+
+func (x *ListCommandsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListCommandsResponse.ProtoReflect.Descriptor instead.
+func (*ListCommandsResponse) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ListCommandsResponse) GetCommands() []*CommandInfo {
+	if x != nil {
+		return x.Commands
+	}
+	return nil
+}
+
+func (x *ListCommandsResponse) GetNextPageToken() string {
+	if x != nil {
+		return x.NextPageToken
+	}
+	return ""
+}
+
+// команда, объединённая с уже ожидающими по политике её типа:
+// DROP_IF_IDENTICAL - новая не создана, command_id = id существующей;
+// REPLACE_PENDING - новая создана, replaced_ids отменены в её пользу
+type CoalescedCommand struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SerialNumber  string                 `protobuf:"bytes,1,opt,name=serial_number,json=serialNumber,proto3" json:"serial_number,omitempty"`
+	CommandId     string                 `protobuf:"bytes,2,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"`
+	Policy        string                 `protobuf:"bytes,3,opt,name=policy,proto3" json:"policy,omitempty"`
+	ReplacedIds   []string               `protobuf:"bytes,4,rep,name=replaced_ids,json=replacedIds,proto3" json:"replaced_ids,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CoalescedCommand) Reset() {
+	*x = CoalescedCommand{}
+	mi := &file_command_service_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CoalescedCommand) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CoalescedCommand) ProtoMessage() {}
+
+func (x *CoalescedCommand) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CoalescedCommand.ProtoReflect.Descriptor instead.
+func (*CoalescedCommand) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *CoalescedCommand) GetSerialNumber() string {
+	if x != nil {
+		return x.SerialNumber
+	}
+	return ""
+}
+
+func (x *CoalescedCommand) GetCommandId() string {
+	if x != nil {
+		return x.CommandId
+	}
+	return ""
+}
+
+func (x *CoalescedCommand) GetPolicy() string {
+	if x != nil {
+		return x.Policy
+	}
+	return ""
+}
+
+func (x *CoalescedCommand) GetReplacedIds() []string {
+	if x != nil {
+		return x.ReplacedIds
+	}
+	return nil
+}
+
+// решение SendCommand по одному роутеру запроса:
+// CREATE - будет создана новая команда, replaced_ids - ожидающие
+// команды, которые будут отменены в её пользу (reason = политика);
+// COALESCE - новая не создаётся, command_id - существующая команда
+// (reason = политика); SKIP - роутер пропущен, reason - INVALID,
+// UNKNOWN_ROUTER или DECOMMISSIONED; register - неизвестный роутер
+// будет зарегистрирован, router_id у него пуст
+type RouterPlan struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SerialNumber  string                 `protobuf:"bytes,1,opt,name=serial_number,json=serialNumber,proto3" json:"serial_number,omitempty"`
+	RouterId      string                 `protobuf:"bytes,2,opt,name=router_id,json=routerId,proto3" json:"router_id,omitempty"`
+	Action        string                 `protobuf:"bytes,3,opt,name=action,proto3" json:"action,omitempty"`
+	Reason        string                 `protobuf:"bytes,4,opt,name=reason,proto3" json:"reason,omitempty"`
+	CommandId     string                 `protobuf:"bytes,5,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"`
+	ReplacedIds   []string               `protobuf:"bytes,6,rep,name=replaced_ids,json=replacedIds,proto3" json:"replaced_ids,omitempty"`
+	Register      bool                   `protobuf:"varint,7,opt,name=register,proto3" json:"register,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RouterPlan) Reset() {
+	*x = RouterPlan{}
+	mi := &file_command_service_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RouterPlan) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RouterPlan) ProtoMessage() {}
+
+func (x *RouterPlan) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RouterPlan.ProtoReflect.Descriptor instead.
+func (*RouterPlan) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *RouterPlan) GetSerialNumber() string {
+	if x != nil {
+		return x.SerialNumber
+	}
+	return ""
+}
+
+func (x *RouterPlan) GetRouterId() string {
+	if x != nil {
+		return x.RouterId
+	}
+	return ""
+}
+
+func (x *RouterPlan) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *RouterPlan) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *RouterPlan) GetCommandId() string {
+	if x != nil {
+		return x.CommandId
+	}
+	return ""
+}
+
+func (x *RouterPlan) GetReplacedIds() []string {
+	if x != nil {
+		return x.ReplacedIds
+	}
+	return nil
+}
+
+func (x *RouterPlan) GetRegister() bool {
+	if x != nil {
+		return x.Register
+	}
+	return false
+}
+
+// ответ на отправку команды = статус;
+// большие рассылки выполняются в фоне: id пуст, job_id - задача,
+// ход которой можно узнать через JobService.GetJob;
+// команды типов, требующих подтверждения, создаются в статусе
+// AWAITING_APPROVAL и ждут решения по approval_id (ApprovalService);
+// пропущенные роутеры не получают id и перечислены в skipped;
+// при dry_run ничего не сохраняется, id пуст, а plan содержит
+// решение по каждому роутеру в порядке запроса
+type SendCommandResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Status        string                 `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
+	Id            []string               `protobuf:"bytes,2,rep,name=id,proto3" json:"id,omitempty"`
+	Coalesced     []*CoalescedCommand    `protobuf:"bytes,3,rep,name=coalesced,proto3" json:"coalesced,omitempty"`
+	JobId         string                 `protobuf:"bytes,4,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	ApprovalId    string                 `protobuf:"bytes,5,opt,name=approval_id,json=approvalId,proto3" json:"approval_id,omitempty"`
+	Skipped       []*RouterPlan          `protobuf:"bytes,6,rep,name=skipped,proto3" json:"skipped,omitempty"`
+	Plan          []*RouterPlan          `protobuf:"bytes,7,rep,name=plan,proto3" json:"plan,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SendCommandResponse) Reset() {
+	*x = SendCommandResponse{}
+	mi := &file_command_service_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SendCommandResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SendCommandResponse) ProtoMessage() {}
+
+func (x *SendCommandResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SendCommandResponse.ProtoReflect.Descriptor instead.
+func (*SendCommandResponse) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *SendCommandResponse) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *SendCommandResponse) GetId() []string {
+	if x != nil {
+		return x.Id
+	}
+	return nil
+}
+
+func (x *SendCommandResponse) GetCoalesced() []*CoalescedCommand {
+	if x != nil {
+		return x.Coalesced
+	}
+	return nil
+}
+
+func (x *SendCommandResponse) GetJobId() string {
+	if x != nil {
+		return x.JobId
+	}
+	return ""
+}
+
+func (x *SendCommandResponse) GetApprovalId() string {
+	if x != nil {
+		return x.ApprovalId
+	}
+	return ""
+}
+
+func (x *SendCommandResponse) GetSkipped() []*RouterPlan {
+	if x != nil {
+		return x.Skipped
+	}
+	return nil
+}
+
+func (x *SendCommandResponse) GetPlan() []*RouterPlan {
+	if x != nil {
+		return x.Plan
+	}
+	return nil
+}
+
+// информация о команде роутера
+type Command struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	CommandType   string                 `protobuf:"bytes,2,opt,name=command_type,json=commandType,proto3" json:"command_type,omitempty"`
+	Payload       string                 `protobuf:"bytes,3,opt,name=payload,proto3" json:"payload,omitempty"`
+	CreatedAt     *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	Priority      int32                  `protobuf:"varint,5,opt,name=priority,proto3" json:"priority,omitempty"`
+	Signature     *CommandSignature      `protobuf:"bytes,6,opt,name=signature,proto3" json:"signature,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Command) Reset() {
+	*x = Command{}
+	mi := &file_command_service_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Command) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Command) ProtoMessage() {}
+
+func (x *Command) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Command.ProtoReflect.Descriptor instead.
+func (*Command) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *Command) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Command) GetCommandType() string {
+	if x != nil {
+		return x.CommandType
+	}
+	return ""
+}
+
+func (x *Command) GetPayload() string {
+	if x != nil {
+		return x.Payload
+	}
+	return ""
+}
+
+func (x *Command) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *Command) GetPriority() int32 {
+	if x != nil {
+		return x.Priority
+	}
+	return 0
+}
+
+func (x *Command) GetSignature() *CommandSignature {
+	if x != nil {
+		return x.Signature
+	}
+	return nil
+}
+
+// подпись команды Ed25519, сделанная при её доставке роутеру; подписаны
+// "router-manager-command-v1", id, router_id, command_type, payload,
+// not_before и expires_at (Unix-секунды, десятичной строкой) - каждое
+// поле как uint32 big-endian длина и байты UTF-8; роутер проверяет
+// подпись ключом key_id из GET /api/v1/signing_keys и отбрасывает
+// команды вне [not_before, expires_at)
+type CommandSignature struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KeyId         string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	Signature     []byte                 `protobuf:"bytes,2,opt,name=signature,proto3" json:"signature,omitempty"`
+	NotBefore     *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=not_before,json=notBefore,proto3" json:"not_before,omitempty"`
+	ExpiresAt     *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CommandSignature) Reset() {
+	*x = CommandSignature{}
+	mi := &file_command_service_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CommandSignature) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CommandSignature) ProtoMessage() {}
+
+func (x *CommandSignature) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CommandSignature.ProtoReflect.Descriptor instead.
+func (*CommandSignature) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *CommandSignature) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+func (x *CommandSignature) GetSignature() []byte {
+	if x != nil {
+		return x.Signature
+	}
+	return nil
+}
+
+func (x *CommandSignature) GetNotBefore() *timestamppb.Timestamp {
+	if x != nil {
+		return x.NotBefore
+	}
+	return nil
+}
+
+func (x *CommandSignature) GetExpiresAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return nil
+}
+
+// уведомление об отмене уже отправленной команды
+type CancellationNotice struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CommandId     string                 `protobuf:"bytes,1,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"`
+	Reason        string                 `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	CancelledAt   *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=cancelled_at,json=cancelledAt,proto3" json:"cancelled_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CancellationNotice) Reset() {
+	*x = CancellationNotice{}
+	mi := &file_command_service_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CancellationNotice) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CancellationNotice) ProtoMessage() {}
+
+func (x *CancellationNotice) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CancellationNotice.ProtoReflect.Descriptor instead.
+func (*CancellationNotice) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *CancellationNotice) GetCommandId() string {
+	if x != nil {
+		return x.CommandId
+	}
+	return ""
+}
+
+func (x *CancellationNotice) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *CancellationNotice) GetCancelledAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CancelledAt
+	}
+	return nil
+}
+
+type GetSigningKeysRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetSigningKeysRequest) Reset() {
+	*x = GetSigningKeysRequest{}
+	mi := &file_command_service_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetSigningKeysRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetSigningKeysRequest) ProtoMessage() {}
+
+func (x *GetSigningKeysRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetSigningKeysRequest.ProtoReflect.Descriptor instead.
+func (*GetSigningKeysRequest) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{15}
+}
+
+// публичный ключ подписи команд; current - ключ, которым подписываются
+// новые команды, прежние публикуются, пока действуют их подписи
+type SigningKey struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KeyId         string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	Algorithm     string                 `protobuf:"bytes,2,opt,name=algorithm,proto3" json:"algorithm,omitempty"`
+	PublicKey     []byte                 `protobuf:"bytes,3,opt,name=public_key,json=publicKey,proto3" json:"public_key,omitempty"`
+	Current       bool                   `protobuf:"varint,4,opt,name=current,proto3" json:"current,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SigningKey) Reset() {
+	*x = SigningKey{}
+	mi := &file_command_service_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SigningKey) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SigningKey) ProtoMessage() {}
+
+func (x *SigningKey) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SigningKey.ProtoReflect.Descriptor instead.
+func (*SigningKey) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *SigningKey) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+func (x *SigningKey) GetAlgorithm() string {
+	if x != nil {
+		return x.Algorithm
+	}
+	return ""
+}
+
+func (x *SigningKey) GetPublicKey() []byte {
+	if x != nil {
+		return x.PublicKey
+	}
+	return nil
+}
+
+func (x *SigningKey) GetCurrent() bool {
+	if x != nil {
+		return x.Current
+	}
+	return false
+}
+
+type GetSigningKeysResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Keys          []*SigningKey          `protobuf:"bytes,1,rep,name=keys,proto3" json:"keys,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetSigningKeysResponse) Reset() {
+	*x = GetSigningKeysResponse{}
+	mi := &file_command_service_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetSigningKeysResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetSigningKeysResponse) ProtoMessage() {}
+
+func (x *GetSigningKeysResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetSigningKeysResponse.ProtoReflect.Descriptor instead.
+func (*GetSigningKeysResponse) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *GetSigningKeysResponse) GetKeys() []*SigningKey {
+	if x != nil {
+		return x.Keys
+	}
+	return nil
+}
+
+// список команд роутера и отмены ранее отправленных команд;
+// команды упорядочены по priority (от большего к меньшему),
+// при равном приоритете - по created_at (от старых к новым).
+// Команда считается отправленной (SENT), только если попала в ответ:
+// команды сверх max_commands остаются PENDING до следующего опроса
+type PollResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Commands      []*Command             `protobuf:"bytes,1,rep,name=commands,proto3" json:"commands,omitempty"`
+	Cancellations []*CancellationNotice  `protobuf:"bytes,2,rep,name=cancellations,proto3" json:"cancellations,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PollResponse) Reset() {
+	*x = PollResponse{}
+	mi := &file_command_service_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PollResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PollResponse) ProtoMessage() {}
+
+func (x *PollResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PollResponse.ProtoReflect.Descriptor instead.
+func (*PollResponse) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *PollResponse) GetCommands() []*Command {
+	if x != nil {
+		return x.Commands
+	}
+	return nil
+}
+
+func (x *PollResponse) GetCancellations() []*CancellationNotice {
+	if x != nil {
+		return x.Cancellations
+	}
+	return nil
+}
+
+// шаг workflow: команда и ключи шагов, которые должны быть ACKED до её отправки
+type WorkflowStep struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Key         string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	CommandType string                 `protobuf:"bytes,2,opt,name=command_type,json=commandType,proto3" json:"command_type,omitempty"`
+	DependsOn   []string               `protobuf:"bytes,3,rep,name=depends_on,json=dependsOn,proto3" json:"depends_on,omitempty"`
+	Priority    *int32                 `protobuf:"varint,4,opt,name=priority,proto3,oneof" json:"priority,omitempty"`
+	// JSON, который получит роутер; если не задан - {"command": "<command_type>"}
+	Payload       string `protobuf:"bytes,5,opt,name=payload,proto3" json:"payload,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WorkflowStep) Reset() {
+	*x = WorkflowStep{}
+	mi := &file_command_service_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WorkflowStep) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WorkflowStep) ProtoMessage() {}
+
+func (x *WorkflowStep) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WorkflowStep.ProtoReflect.Descriptor instead.
+func (*WorkflowStep) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *WorkflowStep) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *WorkflowStep) GetCommandType() string {
+	if x != nil {
+		return x.CommandType
+	}
+	return ""
+}
+
+func (x *WorkflowStep) GetDependsOn() []string {
+	if x != nil {
+		return x.DependsOn
+	}
+	return nil
+}
+
+func (x *WorkflowStep) GetPriority() int32 {
+	if x != nil && x.Priority != nil {
+		return *x.Priority
+	}
+	return 0
+}
+
+func (x *WorkflowStep) GetPayload() string {
+	if x != nil {
+		return x.Payload
+	}
+	return ""
+}
+
+// тело создания workflow для роутера;
+// sequential = шаги выполняются по порядку, depends_on не задаётся
+type SubmitWorkflowRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SerialNumber  string                 `protobuf:"bytes,1,opt,name=serial_number,json=serialNumber,proto3" json:"serial_number,omitempty"`
+	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Steps         []*WorkflowStep        `protobuf:"bytes,3,rep,name=steps,proto3" json:"steps,omitempty"`
+	Sequential    bool                   `protobuf:"varint,4,opt,name=sequential,proto3" json:"sequential,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubmitWorkflowRequest) Reset() {
+	*x = SubmitWorkflowRequest{}
+	mi := &file_command_service_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubmitWorkflowRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubmitWorkflowRequest) ProtoMessage() {}
+
+func (x *SubmitWorkflowRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubmitWorkflowRequest.ProtoReflect.Descriptor instead.
+func (*SubmitWorkflowRequest) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *SubmitWorkflowRequest) GetSerialNumber() string {
+	if x != nil {
+		return x.SerialNumber
+	}
+	return ""
+}
+
+func (x *SubmitWorkflowRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *SubmitWorkflowRequest) GetSteps() []*WorkflowStep {
+	if x != nil {
+		return x.Steps
+	}
+	return nil
+}
+
+func (x *SubmitWorkflowRequest) GetSequential() bool {
+	if x != nil {
+		return x.Sequential
+	}
+	return false
+}
+
+// запрос workflow по id
+type GetWorkflowRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	WorkflowId    string                 `protobuf:"bytes,1,opt,name=workflow_id,json=workflowId,proto3" json:"workflow_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetWorkflowRequest) Reset() {
+	*x = GetWorkflowRequest{}
+	mi := &file_command_service_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetWorkflowRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetWorkflowRequest) ProtoMessage() {}
+
+func (x *GetWorkflowRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetWorkflowRequest.ProtoReflect.Descriptor instead.
+func (*GetWorkflowRequest) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *GetWorkflowRequest) GetWorkflowId() string {
+	if x != nil {
+		return x.WorkflowId
+	}
+	return ""
+}
+
+// шаг workflow с текущим состоянием команды
+type WorkflowStepInfo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	DependsOn     []string               `protobuf:"bytes,2,rep,name=depends_on,json=dependsOn,proto3" json:"depends_on,omitempty"`
+	Command       *CommandInfo           `protobuf:"bytes,3,opt,name=command,proto3" json:"command,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WorkflowStepInfo) Reset() {
+	*x = WorkflowStepInfo{}
+	mi := &file_command_service_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WorkflowStepInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WorkflowStepInfo) ProtoMessage() {}
+
+func (x *WorkflowStepInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WorkflowStepInfo.ProtoReflect.Descriptor instead.
+func (*WorkflowStepInfo) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *WorkflowStepInfo) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *WorkflowStepInfo) GetDependsOn() []string {
+	if x != nil {
+		return x.DependsOn
+	}
+	return nil
+}
+
+func (x *WorkflowStepInfo) GetCommand() *CommandInfo {
+	if x != nil {
+		return x.Command
+	}
+	return nil
+}
+
+// состояние workflow: RUNNING, COMPLETED, FAILED или CANCELLED
+type WorkflowInfo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	RouterId      string                 `protobuf:"bytes,2,opt,name=router_id,json=routerId,proto3" json:"router_id,omitempty"`
+	Name          string                 `protobuf:"bytes,3,opt,name=name,proto3" json:"name,omitempty"`
+	Status        string                 `protobuf:"bytes,4,opt,name=status,proto3" json:"status,omitempty"`
+	CreatedAt     *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	Steps         []*WorkflowStepInfo    `protobuf:"bytes,6,rep,name=steps,proto3" json:"steps,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WorkflowInfo) Reset() {
+	*x = WorkflowInfo{}
+	mi := &file_command_service_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WorkflowInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WorkflowInfo) ProtoMessage() {}
+
+func (x *WorkflowInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WorkflowInfo.ProtoReflect.Descriptor instead.
+func (*WorkflowInfo) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *WorkflowInfo) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *WorkflowInfo) GetRouterId() string {
+	if x != nil {
+		return x.RouterId
+	}
+	return ""
+}
+
+func (x *WorkflowInfo) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *WorkflowInfo) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *WorkflowInfo) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *WorkflowInfo) GetSteps() []*WorkflowStepInfo {
+	if x != nil {
+		return x.Steps
+	}
+	return nil
+}
+
+// ответ на "ack" = статус 'ACKED'
+type AckResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Status        string                 `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AckResponse) Reset() {
+	*x = AckResponse{}
+	mi := &file_command_service_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AckResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AckResponse) ProtoMessage() {}
+
+func (x *AckResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AckResponse.ProtoReflect.Descriptor instead.
+func (*AckResponse) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{24}
+}
+
+func (x *AckResponse) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+// тело отмены одной команды
+type CancelCommandRequest struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	CommandId string                 `protobuf:"bytes,1,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"`
+	// кто отменяет; при включённой аутентификации игнорируется,
+	// записывается аутентифицированный вызывающий
+	CancelledBy   string `protobuf:"bytes,2,opt,name=cancelled_by,json=cancelledBy,proto3" json:"cancelled_by,omitempty"`
+	Reason        string `protobuf:"bytes,3,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CancelCommandRequest) Reset() {
+	*x = CancelCommandRequest{}
+	mi := &file_command_service_proto_msgTypes[25]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CancelCommandRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CancelCommandRequest) ProtoMessage() {}
+
+func (x *CancelCommandRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[25]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CancelCommandRequest.ProtoReflect.Descriptor instead.
+func (*CancelCommandRequest) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{25}
+}
+
+func (x *CancelCommandRequest) GetCommandId() string {
+	if x != nil {
+		return x.CommandId
+	}
+	return ""
+}
+
+func (x *CancelCommandRequest) GetCancelledBy() string {
+	if x != nil {
+		return x.CancelledBy
+	}
+	return ""
+}
+
+func (x *CancelCommandRequest) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+// тело отмены команд роутера, типа команды и/или кампании
+type CancelCommandsRequest struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	RouterId    string                 `protobuf:"bytes,1,opt,name=router_id,json=routerId,proto3" json:"router_id,omitempty"`
+	CommandType string                 `protobuf:"bytes,2,opt,name=command_type,json=commandType,proto3" json:"command_type,omitempty"`
+	// как в CancelCommandRequest
+	CancelledBy   string `protobuf:"bytes,3,opt,name=cancelled_by,json=cancelledBy,proto3" json:"cancelled_by,omitempty"`
+	Reason        string `protobuf:"bytes,4,opt,name=reason,proto3" json:"reason,omitempty"`
+	CampaignId    string `protobuf:"bytes,5,opt,name=campaign_id,json=campaignId,proto3" json:"campaign_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CancelCommandsRequest) Reset() {
+	*x = CancelCommandsRequest{}
+	mi := &file_command_service_proto_msgTypes[26]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CancelCommandsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CancelCommandsRequest) ProtoMessage() {}
+
+func (x *CancelCommandsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[26]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CancelCommandsRequest.ProtoReflect.Descriptor instead.
+func (*CancelCommandsRequest) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{26}
+}
+
+func (x *CancelCommandsRequest) GetRouterId() string {
+	if x != nil {
+		return x.RouterId
+	}
+	return ""
+}
+
+func (x *CancelCommandsRequest) GetCommandType() string {
+	if x != nil {
+		return x.CommandType
+	}
+	return ""
+}
+
+func (x *CancelCommandsRequest) GetCancelledBy() string {
+	if x != nil {
+		return x.CancelledBy
+	}
+	return ""
+}
+
+func (x *CancelCommandsRequest) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *CancelCommandsRequest) GetCampaignId() string {
+	if x != nil {
+		return x.CampaignId
+	}
+	return ""
+}
+
+// результат отмены: PENDING команды отменены сразу,
+// по SENT командам роутер получит уведомление при следующем poll
+type CancelCommandsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Cancelled     []string               `protobuf:"bytes,1,rep,name=cancelled,proto3" json:"cancelled,omitempty"`
+	Cancelling    []string               `protobuf:"bytes,2,rep,name=cancelling,proto3" json:"cancelling,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CancelCommandsResponse) Reset() {
+	*x = CancelCommandsResponse{}
+	mi := &file_command_service_proto_msgTypes[27]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CancelCommandsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CancelCommandsResponse) ProtoMessage() {}
+
+func (x *CancelCommandsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[27]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CancelCommandsResponse.ProtoReflect.Descriptor instead.
+func (*CancelCommandsResponse) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{27}
+}
+
+func (x *CancelCommandsResponse) GetCancelled() []string {
+	if x != nil {
+		return x.Cancelled
+	}
+	return nil
+}
+
+func (x *CancelCommandsResponse) GetCancelling() []string {
+	if x != nil {
+		return x.Cancelling
+	}
+	return nil
+}
+
+// выбор роутеров кампании: ровно одно из полей
+type RouterSelector struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SerialNumbers []string               `protobuf:"bytes,1,rep,name=serial_numbers,json=serialNumbers,proto3" json:"serial_numbers,omitempty"`
+	SerialPrefix  string                 `protobuf:"bytes,2,opt,name=serial_prefix,json=serialPrefix,proto3" json:"serial_prefix,omitempty"`
+	AllRouters    bool                   `protobuf:"varint,3,opt,name=all_routers,json=allRouters,proto3" json:"all_routers,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RouterSelector) Reset() {
+	*x = RouterSelector{}
+	mi := &file_command_service_proto_msgTypes[28]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RouterSelector) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RouterSelector) ProtoMessage() {}
+
+func (x *RouterSelector) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[28]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RouterSelector.ProtoReflect.Descriptor instead.
+func (*RouterSelector) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{28}
+}
+
+func (x *RouterSelector) GetSerialNumbers() []string {
+	if x != nil {
+		return x.SerialNumbers
+	}
+	return nil
+}
+
+func (x *RouterSelector) GetSerialPrefix() string {
+	if x != nil {
+		return x.SerialPrefix
+	}
+	return ""
+}
+
+func (x *RouterSelector) GetAllRouters() bool {
+	if x != nil {
+		return x.AllRouters
+	}
+	return false
+}
+
+// размер волны: число роутеров или процент от всех целей;
+// роутеры, не попавшие в перечисленные волны, образуют последнюю волну
+type CampaignWave struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Count         uint32                 `protobuf:"varint,1,opt,name=count,proto3" json:"count,omitempty"`
+	Percent       float64                `protobuf:"fixed64,2,opt,name=percent,proto3" json:"percent,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CampaignWave) Reset() {
+	*x = CampaignWave{}
+	mi := &file_command_service_proto_msgTypes[29]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CampaignWave) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CampaignWave) ProtoMessage() {}
+
+func (x *CampaignWave) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[29]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CampaignWave.ProtoReflect.Descriptor instead.
+func (*CampaignWave) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{29}
+}
+
+func (x *CampaignWave) GetCount() uint32 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+func (x *CampaignWave) GetPercent() float64 {
+	if x != nil {
+		return x.Percent
+	}
+	return 0
+}
+
+// тело создания кампании;
+// max_failure_rate - доля FAILED среди завершённых команд (0..1),
+// при превышении кампания ставится на паузу
+type CreateCampaignRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Name           string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	CommandType    string                 `protobuf:"bytes,2,opt,name=command_type,json=commandType,proto3" json:"command_type,omitempty"`
+	Priority       *int32                 `protobuf:"varint,3,opt,name=priority,proto3,oneof" json:"priority,omitempty"`
+	Selector       *RouterSelector        `protobuf:"bytes,4,opt,name=selector,proto3" json:"selector,omitempty"`
+	Waves          []*CampaignWave        `protobuf:"bytes,5,rep,name=waves,proto3" json:"waves,omitempty"`
+	SoakTime       *durationpb.Duration   `protobuf:"bytes,6,opt,name=soak_time,json=soakTime,proto3" json:"soak_time,omitempty"`
+	MaxFailureRate float64                `protobuf:"fixed64,7,opt,name=max_failure_rate,json=maxFailureRate,proto3" json:"max_failure_rate,omitempty"`
+	// кто создаёт; при включённой аутентификации игнорируется,
+	// записывается аутентифицированный вызывающий
+	CreatedBy string `protobuf:"bytes,8,opt,name=created_by,json=createdBy,proto3" json:"created_by,omitempty"`
+	// JSON, который получат роутеры; если не задан - {"command": "<command_type>"}
+	Payload       string `protobuf:"bytes,9,opt,name=payload,proto3" json:"payload,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateCampaignRequest) Reset() {
+	*x = CreateCampaignRequest{}
+	mi := &file_command_service_proto_msgTypes[30]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateCampaignRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateCampaignRequest) ProtoMessage() {}
+
+func (x *CreateCampaignRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[30]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateCampaignRequest.ProtoReflect.Descriptor instead.
+func (*CreateCampaignRequest) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{30}
+}
+
+func (x *CreateCampaignRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *CreateCampaignRequest) GetCommandType() string {
+	if x != nil {
+		return x.CommandType
+	}
+	return ""
+}
+
+func (x *CreateCampaignRequest) GetPriority() int32 {
+	if x != nil && x.Priority != nil {
+		return *x.Priority
+	}
+	return 0
+}
+
+func (x *CreateCampaignRequest) GetSelector() *RouterSelector {
+	if x != nil {
+		return x.Selector
+	}
+	return nil
+}
+
+func (x *CreateCampaignRequest) GetWaves() []*CampaignWave {
+	if x != nil {
+		return x.Waves
+	}
+	return nil
+}
+
+func (x *CreateCampaignRequest) GetSoakTime() *durationpb.Duration {
+	if x != nil {
+		return x.SoakTime
+	}
+	return nil
+}
+
+func (x *CreateCampaignRequest) GetMaxFailureRate() float64 {
+	if x != nil {
+		return x.MaxFailureRate
+	}
+	return 0
+}
+
+func (x *CreateCampaignRequest) GetCreatedBy() string {
+	if x != nil {
+		return x.CreatedBy
+	}
+	return ""
+}
+
+func (x *CreateCampaignRequest) GetPayload() string {
+	if x != nil {
+		return x.Payload
+	}
+	return ""
+}
+
+// запрос кампании по id
+type GetCampaignRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CampaignId    string                 `protobuf:"bytes,1,opt,name=campaign_id,json=campaignId,proto3" json:"campaign_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetCampaignRequest) Reset() {
+	*x = GetCampaignRequest{}
+	mi := &file_command_service_proto_msgTypes[31]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetCampaignRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetCampaignRequest) ProtoMessage() {}
+
+func (x *GetCampaignRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[31]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetCampaignRequest.ProtoReflect.Descriptor instead.
+func (*GetCampaignRequest) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{31}
+}
+
+func (x *GetCampaignRequest) GetCampaignId() string {
+	if x != nil {
+		return x.CampaignId
+	}
+	return ""
+}
+
+// пауза, возобновление или отмена кампании
+type CampaignActionRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CampaignId    string                 `protobuf:"bytes,1,opt,name=campaign_id,json=campaignId,proto3" json:"campaign_id,omitempty"`
+	Reason        string                 `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CampaignActionRequest) Reset() {
+	*x = CampaignActionRequest{}
+	mi := &file_command_service_proto_msgTypes[32]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CampaignActionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CampaignActionRequest) ProtoMessage() {}
+
+func (x *CampaignActionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[32]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CampaignActionRequest.ProtoReflect.Descriptor instead.
+func (*CampaignActionRequest) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{32}
+}
+
+func (x *CampaignActionRequest) GetCampaignId() string {
+	if x != nil {
+		return x.CampaignId
+	}
+	return ""
+}
+
+func (x *CampaignActionRequest) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+// состояние одной волны
+type WaveProgress struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Wave          uint32                 `protobuf:"varint,1,opt,name=wave,proto3" json:"wave,omitempty"`
+	Targets       uint32                 `protobuf:"varint,2,opt,name=targets,proto3" json:"targets,omitempty"`
+	Unreleased    uint32                 `protobuf:"varint,3,opt,name=unreleased,proto3" json:"unreleased,omitempty"`
+	Statuses      map[string]uint32      `protobuf:"bytes,4,rep,name=statuses,proto3" json:"statuses,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WaveProgress) Reset() {
+	*x = WaveProgress{}
+	mi := &file_command_service_proto_msgTypes[33]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WaveProgress) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WaveProgress) ProtoMessage() {}
+
+func (x *WaveProgress) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[33]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WaveProgress.ProtoReflect.Descriptor instead.
+func (*WaveProgress) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{33}
+}
+
+func (x *WaveProgress) GetWave() uint32 {
+	if x != nil {
+		return x.Wave
+	}
+	return 0
+}
+
+func (x *WaveProgress) GetTargets() uint32 {
+	if x != nil {
+		return x.Targets
+	}
+	return 0
+}
+
+func (x *WaveProgress) GetUnreleased() uint32 {
+	if x != nil {
+		return x.Unreleased
+	}
+	return 0
+}
+
+func (x *WaveProgress) GetStatuses() map[string]uint32 {
+	if x != nil {
+		return x.Statuses
+	}
+	return nil
+}
+
+// состояние кампании: AWAITING_APPROVAL, RUNNING, PAUSED, ABORTED или COMPLETED;
+// кампания типа, требующего подтверждения, создаётся в AWAITING_APPROVAL
+// и запускается, когда второй оператор примет approval_id (ApprovalService);
+// при отказе или истечении подтверждения кампания отменяется
+type CampaignInfo struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Id             string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name           string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	CommandType    string                 `protobuf:"bytes,3,opt,name=command_type,json=commandType,proto3" json:"command_type,omitempty"`
+	Priority       int32                  `protobuf:"varint,4,opt,name=priority,proto3" json:"priority,omitempty"`
+	Status         string                 `protobuf:"bytes,5,opt,name=status,proto3" json:"status,omitempty"`
+	PauseReason    string                 `protobuf:"bytes,6,opt,name=pause_reason,json=pauseReason,proto3" json:"pause_reason,omitempty"`
+	TotalWaves     uint32                 `protobuf:"varint,7,opt,name=total_waves,json=totalWaves,proto3" json:"total_waves,omitempty"`
+	CurrentWave    uint32                 `protobuf:"varint,8,opt,name=current_wave,json=currentWave,proto3" json:"current_wave,omitempty"`
+	SoakTime       *durationpb.Duration   `protobuf:"bytes,9,opt,name=soak_time,json=soakTime,proto3" json:"soak_time,omitempty"`
+	MaxFailureRate float64                `protobuf:"fixed64,10,opt,name=max_failure_rate,json=maxFailureRate,proto3" json:"max_failure_rate,omitempty"`
+	FailureRate    float64                `protobuf:"fixed64,11,opt,name=failure_rate,json=failureRate,proto3" json:"failure_rate,omitempty"`
+	NextWaveAt     *timestamppb.Timestamp `protobuf:"bytes,12,opt,name=next_wave_at,json=nextWaveAt,proto3" json:"next_wave_at,omitempty"`
+	CreatedBy      string                 `protobuf:"bytes,13,opt,name=created_by,json=createdBy,proto3" json:"created_by,omitempty"`
+	CreatedAt      *timestamppb.Timestamp `protobuf:"bytes,14,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	Waves          []*WaveProgress        `protobuf:"bytes,15,rep,name=waves,proto3" json:"waves,omitempty"`
+	ApprovalId     string                 `protobuf:"bytes,16,opt,name=approval_id,json=approvalId,proto3" json:"approval_id,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *CampaignInfo) Reset() {
+	*x = CampaignInfo{}
+	mi := &file_command_service_proto_msgTypes[34]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CampaignInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CampaignInfo) ProtoMessage() {}
+
+func (x *CampaignInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[34]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CampaignInfo.ProtoReflect.Descriptor instead.
+func (*CampaignInfo) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{34}
+}
+
+func (x *CampaignInfo) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *CampaignInfo) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *CampaignInfo) GetCommandType() string {
+	if x != nil {
+		return x.CommandType
+	}
+	return ""
+}
+
+func (x *CampaignInfo) GetPriority() int32 {
+	if x != nil {
+		return x.Priority
+	}
+	return 0
+}
+
+func (x *CampaignInfo) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *CampaignInfo) GetPauseReason() string {
+	if x != nil {
+		return x.PauseReason
+	}
+	return ""
+}
+
+func (x *CampaignInfo) GetTotalWaves() uint32 {
+	if x != nil {
+		return x.TotalWaves
+	}
+	return 0
+}
+
+func (x *CampaignInfo) GetCurrentWave() uint32 {
+	if x != nil {
+		return x.CurrentWave
+	}
+	return 0
+}
+
+func (x *CampaignInfo) GetSoakTime() *durationpb.Duration {
+	if x != nil {
+		return x.SoakTime
+	}
+	return nil
+}
+
+func (x *CampaignInfo) GetMaxFailureRate() float64 {
+	if x != nil {
+		return x.MaxFailureRate
+	}
+	return 0
+}
+
+func (x *CampaignInfo) GetFailureRate() float64 {
+	if x != nil {
+		return x.FailureRate
+	}
+	return 0
+}
+
+func (x *CampaignInfo) GetNextWaveAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.NextWaveAt
+	}
+	return nil
+}
+
+func (x *CampaignInfo) GetCreatedBy() string {
+	if x != nil {
+		return x.CreatedBy
+	}
+	return ""
+}
+
+func (x *CampaignInfo) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *CampaignInfo) GetWaves() []*WaveProgress {
+	if x != nil {
+		return x.Waves
+	}
+	return nil
+}
+
+func (x *CampaignInfo) GetApprovalId() string {
+	if x != nil {
+		return x.ApprovalId
+	}
+	return ""
+}
+
+// запрос фоновой задачи по id
+type GetJobRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	JobId         string                 `protobuf:"bytes,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetJobRequest) Reset() {
+	*x = GetJobRequest{}
+	mi := &file_command_service_proto_msgTypes[35]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetJobRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetJobRequest) ProtoMessage() {}
+
+func (x *GetJobRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[35]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetJobRequest.ProtoReflect.Descriptor instead.
+func (*GetJobRequest) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{35}
+}
+
+func (x *GetJobRequest) GetJobId() string {
+	if x != nil {
+		return x.JobId
+	}
+	return ""
+}
+
+// фоновая задача: PENDING, RUNNING, CANCELLING, SUCCEEDED, FAILED или CANCELLED;
+// processed из total - сколько элементов уже обработано
+type JobInfo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Kind          string                 `protobuf:"bytes,2,opt,name=kind,proto3" json:"kind,omitempty"`
+	Status        string                 `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	Total         uint32                 `protobuf:"varint,4,opt,name=total,proto3" json:"total,omitempty"`
+	Processed     uint32                 `protobuf:"varint,5,opt,name=processed,proto3" json:"processed,omitempty"`
+	Error         string                 `protobuf:"bytes,6,opt,name=error,proto3" json:"error,omitempty"`
+	CreatedBy     string                 `protobuf:"bytes,7,opt,name=created_by,json=createdBy,proto3" json:"created_by,omitempty"`
+	CreatedAt     *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	UpdatedAt     *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	FinishedAt    *timestamppb.Timestamp `protobuf:"bytes,10,opt,name=finished_at,json=finishedAt,proto3" json:"finished_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *JobInfo) Reset() {
+	*x = JobInfo{}
+	mi := &file_command_service_proto_msgTypes[36]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *JobInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*JobInfo) ProtoMessage() {}
+
+func (x *JobInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[36]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use JobInfo.ProtoReflect.Descriptor instead.
+func (*JobInfo) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{36}
+}
+
+func (x *JobInfo) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *JobInfo) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *JobInfo) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *JobInfo) GetTotal() uint32 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *JobInfo) GetProcessed() uint32 {
+	if x != nil {
+		return x.Processed
+	}
+	return 0
+}
+
+func (x *JobInfo) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *JobInfo) GetCreatedBy() string {
+	if x != nil {
+		return x.CreatedBy
+	}
+	return ""
+}
+
+func (x *JobInfo) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *JobInfo) GetUpdatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return nil
+}
+
+func (x *JobInfo) GetFinishedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.FinishedAt
+	}
+	return nil
+}
+
+// фильтр списка задач, пустые поля не ограничивают выборку;
+// задачи идут от новых к старым, page_token - из предыдущего ответа
+type ListJobsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Kind          string                 `protobuf:"bytes,1,opt,name=kind,proto3" json:"kind,omitempty"`
+	Status        []string               `protobuf:"bytes,2,rep,name=status,proto3" json:"status,omitempty"`
+	PageSize      uint32                 `protobuf:"varint,3,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	PageToken     string                 `protobuf:"bytes,4,opt,name=page_token,json=pageToken,proto3" json:"page_token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListJobsRequest) Reset() {
+	*x = ListJobsRequest{}
+	mi := &file_command_service_proto_msgTypes[37]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListJobsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListJobsRequest) ProtoMessage() {}
+
+func (x *ListJobsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[37]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListJobsRequest.ProtoReflect.Descriptor instead.
+func (*ListJobsRequest) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{37}
+}
+
+func (x *ListJobsRequest) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *ListJobsRequest) GetStatus() []string {
+	if x != nil {
+		return x.Status
+	}
+	return nil
+}
+
+func (x *ListJobsRequest) GetPageSize() uint32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *ListJobsRequest) GetPageToken() string {
+	if x != nil {
+		return x.PageToken
+	}
+	return ""
+}
+
+type ListJobsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Jobs          []*JobInfo             `protobuf:"bytes,1,rep,name=jobs,proto3" json:"jobs,omitempty"`
+	NextPageToken string                 `protobuf:"bytes,2,opt,name=next_page_token,json=nextPageToken,proto3" json:"next_page_token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListJobsResponse) Reset() {
+	*x = ListJobsResponse{}
+	mi := &file_command_service_proto_msgTypes[38]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListJobsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListJobsResponse) ProtoMessage() {}
+
+func (x *ListJobsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[38]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListJobsResponse.ProtoReflect.Descriptor instead.
+func (*ListJobsResponse) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{38}
+}
+
+func (x *ListJobsResponse) GetJobs() []*JobInfo {
+	if x != nil {
+		return x.Jobs
+	}
+	return nil
+}
+
+func (x *ListJobsResponse) GetNextPageToken() string {
+	if x != nil {
+		return x.NextPageToken
+	}
+	return ""
+}
+
+// отмена задачи: ожидающая отменяется сразу,
+// выполняемая переходит в CANCELLING и останавливается после текущей порции
+type CancelJobRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	JobId         string                 `protobuf:"bytes,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CancelJobRequest) Reset() {
+	*x = CancelJobRequest{}
+	mi := &file_command_service_proto_msgTypes[39]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CancelJobRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CancelJobRequest) ProtoMessage() {}
+
+func (x *CancelJobRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[39]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CancelJobRequest.ProtoReflect.Descriptor instead.
+func (*CancelJobRequest) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{39}
+}
+
+func (x *CancelJobRequest) GetJobId() string {
+	if x != nil {
+		return x.JobId
+	}
+	return ""
+}
+
+// запрос подтверждения по id
+type GetApprovalRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ApprovalId    string                 `protobuf:"bytes,1,opt,name=approval_id,json=approvalId,proto3" json:"approval_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetApprovalRequest) Reset() {
+	*x = GetApprovalRequest{}
+	mi := &file_command_service_proto_msgTypes[40]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetApprovalRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetApprovalRequest) ProtoMessage() {}
+
+func (x *GetApprovalRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[40]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetApprovalRequest.ProtoReflect.Descriptor instead.
+func (*GetApprovalRequest) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{40}
+}
+
+func (x *GetApprovalRequest) GetApprovalId() string {
+	if x != nil {
+		return x.ApprovalId
+	}
+	return ""
+}
+
+// решение по подтверждению: approve = true переводит команды в PENDING
+// и запускает кампанию, иначе они отменяются; решение принимает
+// аутентифицированный оператор, не тот, кто отправил команды или создал
+// кампанию; без аутентификации подтверждения не принимаются
+type DecideApprovalRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ApprovalId    string                 `protobuf:"bytes,1,opt,name=approval_id,json=approvalId,proto3" json:"approval_id,omitempty"`
+	Approve       bool                   `protobuf:"varint,2,opt,name=approve,proto3" json:"approve,omitempty"`
+	Reason        string                 `protobuf:"bytes,3,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DecideApprovalRequest) Reset() {
+	*x = DecideApprovalRequest{}
+	mi := &file_command_service_proto_msgTypes[41]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DecideApprovalRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DecideApprovalRequest) ProtoMessage() {}
+
+func (x *DecideApprovalRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[41]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DecideApprovalRequest.ProtoReflect.Descriptor instead.
+func (*DecideApprovalRequest) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{41}
+}
+
+func (x *DecideApprovalRequest) GetApprovalId() string {
+	if x != nil {
+		return x.ApprovalId
+	}
+	return ""
+}
+
+func (x *DecideApprovalRequest) GetApprove() bool {
+	if x != nil {
+		return x.Approve
+	}
+	return false
+}
+
+func (x *DecideApprovalRequest) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+// подтверждение команд одного вызова SendCommand или кампании:
+// PENDING, APPROVED, REJECTED или EXPIRED (не принято до expires_at);
+// commands - число команд под этим подтверждением, campaign_id - кампания
+type ApprovalInfo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	CommandType   string                 `protobuf:"bytes,2,opt,name=command_type,json=commandType,proto3" json:"command_type,omitempty"`
+	Status        string                 `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	RequestedBy   string                 `protobuf:"bytes,4,opt,name=requested_by,json=requestedBy,proto3" json:"requested_by,omitempty"`
+	RequestedAt   *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=requested_at,json=requestedAt,proto3" json:"requested_at,omitempty"`
+	ExpiresAt     *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	DecidedBy     string                 `protobuf:"bytes,7,opt,name=decided_by,json=decidedBy,proto3" json:"decided_by,omitempty"`
+	DecidedAt     *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=decided_at,json=decidedAt,proto3" json:"decided_at,omitempty"`
+	Reason        string                 `protobuf:"bytes,9,opt,name=reason,proto3" json:"reason,omitempty"`
+	Commands      uint32                 `protobuf:"varint,10,opt,name=commands,proto3" json:"commands,omitempty"`
+	CampaignId    string                 `protobuf:"bytes,11,opt,name=campaign_id,json=campaignId,proto3" json:"campaign_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ApprovalInfo) Reset() {
+	*x = ApprovalInfo{}
+	mi := &file_command_service_proto_msgTypes[42]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ApprovalInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ApprovalInfo) ProtoMessage() {}
+
+func (x *ApprovalInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[42]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ApprovalInfo.ProtoReflect.Descriptor instead.
+func (*ApprovalInfo) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{42}
+}
+
+func (x *ApprovalInfo) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *ApprovalInfo) GetCommandType() string {
+	if x != nil {
+		return x.CommandType
+	}
+	return ""
+}
+
+func (x *ApprovalInfo) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *ApprovalInfo) GetRequestedBy() string {
+	if x != nil {
+		return x.RequestedBy
+	}
+	return ""
+}
+
+func (x *ApprovalInfo) GetRequestedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.RequestedAt
+	}
+	return nil
+}
+
+func (x *ApprovalInfo) GetExpiresAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return nil
+}
+
+func (x *ApprovalInfo) GetDecidedBy() string {
+	if x != nil {
+		return x.DecidedBy
+	}
+	return ""
+}
+
+func (x *ApprovalInfo) GetDecidedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.DecidedAt
+	}
+	return nil
+}
+
+func (x *ApprovalInfo) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *ApprovalInfo) GetCommands() uint32 {
+	if x != nil {
+		return x.Commands
+	}
+	return 0
+}
+
+func (x *ApprovalInfo) GetCampaignId() string {
+	if x != nil {
+		return x.CampaignId
+	}
+	return ""
+}
+
+// создание API-ключа: subject - от чьего имени ключ аутентифицирует,
+// roles - его роли; без ttl ключ бессрочный
+type CreateApiKeyRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Subject       string                 `protobuf:"bytes,2,opt,name=subject,proto3" json:"subject,omitempty"`
+	Roles         []string               `protobuf:"bytes,3,rep,name=roles,proto3" json:"roles,omitempty"`
+	Ttl           *durationpb.Duration   `protobuf:"bytes,4,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateApiKeyRequest) Reset() {
+	*x = CreateApiKeyRequest{}
+	mi := &file_command_service_proto_msgTypes[43]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateApiKeyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateApiKeyRequest) ProtoMessage() {}
+
+func (x *CreateApiKeyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[43]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateApiKeyRequest.ProtoReflect.Descriptor instead.
+func (*CreateApiKeyRequest) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{43}
+}
+
+func (x *CreateApiKeyRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *CreateApiKeyRequest) GetSubject() string {
+	if x != nil {
+		return x.Subject
+	}
+	return ""
+}
+
+func (x *CreateApiKeyRequest) GetRoles() []string {
+	if x != nil {
+		return x.Roles
+	}
+	return nil
+}
+
+func (x *CreateApiKeyRequest) GetTtl() *durationpb.Duration {
+	if x != nil {
+		return x.Ttl
+	}
+	return nil
+}
+
+// API-ключ без секрета; хранится только хэш секрета
+type ApiKeyInfo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Subject       string                 `protobuf:"bytes,3,opt,name=subject,proto3" json:"subject,omitempty"`
+	Roles         []string               `protobuf:"bytes,4,rep,name=roles,proto3" json:"roles,omitempty"`
+	CreatedBy     string                 `protobuf:"bytes,5,opt,name=created_by,json=createdBy,proto3" json:"created_by,omitempty"`
+	CreatedAt     *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	ExpiresAt     *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	RevokedBy     string                 `protobuf:"bytes,8,opt,name=revoked_by,json=revokedBy,proto3" json:"revoked_by,omitempty"`
+	RevokedAt     *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=revoked_at,json=revokedAt,proto3" json:"revoked_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ApiKeyInfo) Reset() {
+	*x = ApiKeyInfo{}
+	mi := &file_command_service_proto_msgTypes[44]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ApiKeyInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ApiKeyInfo) ProtoMessage() {}
+
+func (x *ApiKeyInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[44]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ApiKeyInfo.ProtoReflect.Descriptor instead.
+func (*ApiKeyInfo) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{44}
+}
+
+func (x *ApiKeyInfo) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *ApiKeyInfo) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *ApiKeyInfo) GetSubject() string {
+	if x != nil {
+		return x.Subject
+	}
+	return ""
+}
+
+func (x *ApiKeyInfo) GetRoles() []string {
+	if x != nil {
+		return x.Roles
+	}
+	return nil
+}
+
+func (x *ApiKeyInfo) GetCreatedBy() string {
+	if x != nil {
+		return x.CreatedBy
+	}
+	return ""
+}
+
+func (x *ApiKeyInfo) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *ApiKeyInfo) GetExpiresAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return nil
+}
+
+func (x *ApiKeyInfo) GetRevokedBy() string {
+	if x != nil {
+		return x.RevokedBy
+	}
+	return ""
+}
+
+func (x *ApiKeyInfo) GetRevokedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.RevokedAt
+	}
+	return nil
+}
+
+// secret возвращается только при создании ключа
+type CreateApiKeyResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           *ApiKeyInfo            `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Secret        string                 `protobuf:"bytes,2,opt,name=secret,proto3" json:"secret,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateApiKeyResponse) Reset() {
+	*x = CreateApiKeyResponse{}
+	mi := &file_command_service_proto_msgTypes[45]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateApiKeyResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateApiKeyResponse) ProtoMessage() {}
+
+func (x *CreateApiKeyResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[45]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateApiKeyResponse.ProtoReflect.Descriptor instead.
+func (*CreateApiKeyResponse) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{45}
+}
+
+func (x *CreateApiKeyResponse) GetKey() *ApiKeyInfo {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+func (x *CreateApiKeyResponse) GetSecret() string {
+	if x != nil {
+		return x.Secret
+	}
+	return ""
+}
+
+type ListApiKeysRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	IncludeRevoked bool                   `protobuf:"varint,1,opt,name=include_revoked,json=includeRevoked,proto3" json:"include_revoked,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *ListApiKeysRequest) Reset() {
+	*x = ListApiKeysRequest{}
+	mi := &file_command_service_proto_msgTypes[46]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListApiKeysRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListApiKeysRequest) ProtoMessage() {}
+
+func (x *ListApiKeysRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[46]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListApiKeysRequest.ProtoReflect.Descriptor instead.
+func (*ListApiKeysRequest) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{46}
+}
+
+func (x *ListApiKeysRequest) GetIncludeRevoked() bool {
+	if x != nil {
+		return x.IncludeRevoked
+	}
+	return false
+}
+
+type ListApiKeysResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Keys          []*ApiKeyInfo          `protobuf:"bytes,1,rep,name=keys,proto3" json:"keys,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListApiKeysResponse) Reset() {
+	*x = ListApiKeysResponse{}
+	mi := &file_command_service_proto_msgTypes[47]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListApiKeysResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListApiKeysResponse) ProtoMessage() {}
+
+func (x *ListApiKeysResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[47]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListApiKeysResponse.ProtoReflect.Descriptor instead.
+func (*ListApiKeysResponse) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{47}
+}
+
+func (x *ListApiKeysResponse) GetKeys() []*ApiKeyInfo {
+	if x != nil {
+		return x.Keys
+	}
+	return nil
+}
+
+type RevokeApiKeyRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KeyId         string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RevokeApiKeyRequest) Reset() {
+	*x = RevokeApiKeyRequest{}
+	mi := &file_command_service_proto_msgTypes[48]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RevokeApiKeyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RevokeApiKeyRequest) ProtoMessage() {}
+
+func (x *RevokeApiKeyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[48]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RevokeApiKeyRequest.ProtoReflect.Descriptor instead.
+func (*RevokeApiKeyRequest) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{48}
+}
+
+func (x *RevokeApiKeyRequest) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+// разрешение роли: methods - "Service.Method", "Service.*" или "*";
+// непустые command_types и serial_prefixes ограничивают типы команд
+// и роутеры (по префиксу серийного номера), к которым оно относится
+type Permission struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Methods        []string               `protobuf:"bytes,1,rep,name=methods,proto3" json:"methods,omitempty"`
+	CommandTypes   []string               `protobuf:"bytes,2,rep,name=command_types,json=commandTypes,proto3" json:"command_types,omitempty"`
+	SerialPrefixes []string               `protobuf:"bytes,3,rep,name=serial_prefixes,json=serialPrefixes,proto3" json:"serial_prefixes,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *Permission) Reset() {
+	*x = Permission{}
+	mi := &file_command_service_proto_msgTypes[49]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Permission) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Permission) ProtoMessage() {}
+
+func (x *Permission) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[49]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Permission.ProtoReflect.Descriptor instead.
+func (*Permission) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{49}
+}
+
+func (x *Permission) GetMethods() []string {
+	if x != nil {
+		return x.Methods
+	}
+	return nil
+}
+
+func (x *Permission) GetCommandTypes() []string {
+	if x != nil {
+		return x.CommandTypes
+	}
+	return nil
+}
+
+func (x *Permission) GetSerialPrefixes() []string {
+	if x != nil {
+		return x.SerialPrefixes
+	}
+	return nil
+}
+
+// роль - именованный набор разрешений; роли принципала берутся
+// из его API-ключа или JWT
+type Role struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Description   string                 `protobuf:"bytes,2,opt,name=description,proto3" json:"description,omitempty"`
+	Permissions   []*Permission          `protobuf:"bytes,3,rep,name=permissions,proto3" json:"permissions,omitempty"`
+	UpdatedBy     string                 `protobuf:"bytes,4,opt,name=updated_by,json=updatedBy,proto3" json:"updated_by,omitempty"`
+	UpdatedAt     *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Role) Reset() {
+	*x = Role{}
+	mi := &file_command_service_proto_msgTypes[50]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Role) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Role) ProtoMessage() {}
+
+func (x *Role) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[50]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Role.ProtoReflect.Descriptor instead.
+func (*Role) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{50}
+}
+
+func (x *Role) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Role) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *Role) GetPermissions() []*Permission {
+	if x != nil {
+		return x.Permissions
+	}
+	return nil
+}
+
+func (x *Role) GetUpdatedBy() string {
+	if x != nil {
+		return x.UpdatedBy
+	}
+	return ""
+}
+
+func (x *Role) GetUpdatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return nil
+}
+
+type ListRolesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListRolesRequest) Reset() {
+	*x = ListRolesRequest{}
+	mi := &file_command_service_proto_msgTypes[51]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListRolesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListRolesRequest) ProtoMessage() {}
+
+func (x *ListRolesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[51]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListRolesRequest.ProtoReflect.Descriptor instead.
+func (*ListRolesRequest) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{51}
+}
+
+type ListRolesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Roles         []*Role                `protobuf:"bytes,1,rep,name=roles,proto3" json:"roles,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListRolesResponse) Reset() {
+	*x = ListRolesResponse{}
+	mi := &file_command_service_proto_msgTypes[52]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListRolesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListRolesResponse) ProtoMessage() {}
+
+func (x *ListRolesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[52]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListRolesResponse.ProtoReflect.Descriptor instead.
+func (*ListRolesResponse) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{52}
+}
+
+func (x *ListRolesResponse) GetRoles() []*Role {
+	if x != nil {
+		return x.Roles
+	}
+	return nil
+}
+
+type PutRoleRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Role          *Role                  `protobuf:"bytes,1,opt,name=role,proto3" json:"role,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PutRoleRequest) Reset() {
+	*x = PutRoleRequest{}
+	mi := &file_command_service_proto_msgTypes[53]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PutRoleRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PutRoleRequest) ProtoMessage() {}
+
+func (x *PutRoleRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[53]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PutRoleRequest.ProtoReflect.Descriptor instead.
+func (*PutRoleRequest) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{53}
+}
+
+func (x *PutRoleRequest) GetRole() *Role {
+	if x != nil {
+		return x.Role
+	}
+	return nil
+}
+
+type DeleteRoleRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteRoleRequest) Reset() {
+	*x = DeleteRoleRequest{}
+	mi := &file_command_service_proto_msgTypes[54]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteRoleRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteRoleRequest) ProtoMessage() {}
+
+func (x *DeleteRoleRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[54]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteRoleRequest.ProtoReflect.Descriptor instead.
+func (*DeleteRoleRequest) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{54}
+}
+
+func (x *DeleteRoleRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+type DeleteRoleResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteRoleResponse) Reset() {
+	*x = DeleteRoleResponse{}
+	mi := &file_command_service_proto_msgTypes[55]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteRoleResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteRoleResponse) ProtoMessage() {}
+
+func (x *DeleteRoleResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[55]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteRoleResponse.ProtoReflect.Descriptor instead.
+func (*DeleteRoleResponse) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{55}
+}
+
+// выдача секрета роутеру: по router_id или по serial_number;
+// роутер с неизвестным serial_number регистрируется (enrollment);
+// прежний секрет роутера перестаёт действовать (ротация)
+type IssueRouterCredentialRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	RouterId      string                 `protobuf:"bytes,1,opt,name=router_id,json=routerId,proto3" json:"router_id,omitempty"`
+	SerialNumber  string                 `protobuf:"bytes,2,opt,name=serial_number,json=serialNumber,proto3" json:"serial_number,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IssueRouterCredentialRequest) Reset() {
+	*x = IssueRouterCredentialRequest{}
+	mi := &file_command_service_proto_msgTypes[56]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IssueRouterCredentialRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IssueRouterCredentialRequest) ProtoMessage() {}
+
+func (x *IssueRouterCredentialRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[56]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IssueRouterCredentialRequest.ProtoReflect.Descriptor instead.
+func (*IssueRouterCredentialRequest) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{56}
+}
+
+func (x *IssueRouterCredentialRequest) GetRouterId() string {
+	if x != nil {
+		return x.RouterId
+	}
+	return ""
+}
+
+func (x *IssueRouterCredentialRequest) GetSerialNumber() string {
+	if x != nil {
+		return x.SerialNumber
+	}
+	return ""
+}
+
+// secret возвращается только при выдаче; роутер передаёт его
+// в метаданных x-router-secret или "authorization: Router <secret>"
+type RouterCredential struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	RouterId      string                 `protobuf:"bytes,1,opt,name=router_id,json=routerId,proto3" json:"router_id,omitempty"`
+	SerialNumber  string                 `protobuf:"bytes,2,opt,name=serial_number,json=serialNumber,proto3" json:"serial_number,omitempty"`
+	Secret        string                 `protobuf:"bytes,3,opt,name=secret,proto3" json:"secret,omitempty"`
+	IssuedBy      string                 `protobuf:"bytes,4,opt,name=issued_by,json=issuedBy,proto3" json:"issued_by,omitempty"`
+	IssuedAt      *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=issued_at,json=issuedAt,proto3" json:"issued_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RouterCredential) Reset() {
+	*x = RouterCredential{}
+	mi := &file_command_service_proto_msgTypes[57]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RouterCredential) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RouterCredential) ProtoMessage() {}
+
+func (x *RouterCredential) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[57]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RouterCredential.ProtoReflect.Descriptor instead.
+func (*RouterCredential) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{57}
+}
+
+func (x *RouterCredential) GetRouterId() string {
+	if x != nil {
+		return x.RouterId
+	}
+	return ""
+}
+
+func (x *RouterCredential) GetSerialNumber() string {
+	if x != nil {
+		return x.SerialNumber
+	}
+	return ""
+}
+
+func (x *RouterCredential) GetSecret() string {
+	if x != nil {
+		return x.Secret
+	}
+	return ""
+}
+
+func (x *RouterCredential) GetIssuedBy() string {
+	if x != nil {
+		return x.IssuedBy
+	}
+	return ""
+}
+
+func (x *RouterCredential) GetIssuedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.IssuedAt
+	}
+	return nil
+}
+
+type RevokeRouterCredentialRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	RouterId      string                 `protobuf:"bytes,1,opt,name=router_id,json=routerId,proto3" json:"router_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RevokeRouterCredentialRequest) Reset() {
+	*x = RevokeRouterCredentialRequest{}
+	mi := &file_command_service_proto_msgTypes[58]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RevokeRouterCredentialRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RevokeRouterCredentialRequest) ProtoMessage() {}
+
+func (x *RevokeRouterCredentialRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[58]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RevokeRouterCredentialRequest.ProtoReflect.Descriptor instead.
+func (*RevokeRouterCredentialRequest) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{58}
+}
+
+func (x *RevokeRouterCredentialRequest) GetRouterId() string {
+	if x != nil {
+		return x.RouterId
+	}
+	return ""
+}
+
+type RevokeRouterCredentialResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RevokeRouterCredentialResponse) Reset() {
+	*x = RevokeRouterCredentialResponse{}
+	mi := &file_command_service_proto_msgTypes[59]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RevokeRouterCredentialResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RevokeRouterCredentialResponse) ProtoMessage() {}
+
+func (x *RevokeRouterCredentialResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[59]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RevokeRouterCredentialResponse.ProtoReflect.Descriptor instead.
+func (*RevokeRouterCredentialResponse) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{59}
+}
+
+// событие журнала аудита: вызов, который что-то изменил или пытался;
+// hash = SHA-256 от prev_hash и полей события, так что изменение или
+// удаление события разрывает цепочку
+type AuditEvent struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Seq           int64                  `protobuf:"varint,1,opt,name=seq,proto3" json:"seq,omitempty"`
+	OccurredAt    *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=occurred_at,json=occurredAt,proto3" json:"occurred_at,omitempty"`
+	Actor         string                 `protobuf:"bytes,3,opt,name=actor,proto3" json:"actor,omitempty"`
+	Action        string                 `protobuf:"bytes,4,opt,name=action,proto3" json:"action,omitempty"`
+	Target        string                 `protobuf:"bytes,5,opt,name=target,proto3" json:"target,omitempty"`
+	RequestDigest string                 `protobuf:"bytes,6,opt,name=request_digest,json=requestDigest,proto3" json:"request_digest,omitempty"`
+	Outcome       string                 `protobuf:"bytes,7,opt,name=outcome,proto3" json:"outcome,omitempty"`
+	Error         string                 `protobuf:"bytes,8,opt,name=error,proto3" json:"error,omitempty"`
+	SourceIp      string                 `protobuf:"bytes,9,opt,name=source_ip,json=sourceIp,proto3" json:"source_ip,omitempty"`
+	PrevHash      []byte                 `protobuf:"bytes,10,opt,name=prev_hash,json=prevHash,proto3" json:"prev_hash,omitempty"`
+	Hash          []byte                 `protobuf:"bytes,11,opt,name=hash,proto3" json:"hash,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AuditEvent) Reset() {
+	*x = AuditEvent{}
+	mi := &file_command_service_proto_msgTypes[60]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AuditEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AuditEvent) ProtoMessage() {}
+
+func (x *AuditEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[60]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AuditEvent.ProtoReflect.Descriptor instead.
+func (*AuditEvent) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{60}
+}
+
+func (x *AuditEvent) GetSeq() int64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *AuditEvent) GetOccurredAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.OccurredAt
+	}
+	return nil
+}
+
+func (x *AuditEvent) GetActor() string {
+	if x != nil {
+		return x.Actor
+	}
+	return ""
+}
+
+func (x *AuditEvent) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *AuditEvent) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *AuditEvent) GetRequestDigest() string {
+	if x != nil {
+		return x.RequestDigest
+	}
+	return ""
+}
+
+func (x *AuditEvent) GetOutcome() string {
+	if x != nil {
+		return x.Outcome
+	}
+	return ""
+}
+
+func (x *AuditEvent) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *AuditEvent) GetSourceIp() string {
+	if x != nil {
+		return x.SourceIp
+	}
+	return ""
+}
+
+func (x *AuditEvent) GetPrevHash() []byte {
+	if x != nil {
+		return x.PrevHash
+	}
+	return nil
+}
+
+func (x *AuditEvent) GetHash() []byte {
+	if x != nil {
+		return x.Hash
+	}
+	return nil
+}
+
+// события идут от новых к старым, page_token - из предыдущего ответа
+type ListAuditEventsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Actor         string                 `protobuf:"bytes,1,opt,name=actor,proto3" json:"actor,omitempty"`
+	Action        string                 `protobuf:"bytes,2,opt,name=action,proto3" json:"action,omitempty"`
+	Occurred      *TimeRange             `protobuf:"bytes,3,opt,name=occurred,proto3" json:"occurred,omitempty"`
+	PageSize      int32                  `protobuf:"varint,4,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	PageToken     string                 `protobuf:"bytes,5,opt,name=page_token,json=pageToken,proto3" json:"page_token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListAuditEventsRequest) Reset() {
+	*x = ListAuditEventsRequest{}
+	mi := &file_command_service_proto_msgTypes[61]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListAuditEventsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListAuditEventsRequest) ProtoMessage() {}
+
+func (x *ListAuditEventsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[61]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListAuditEventsRequest.ProtoReflect.Descriptor instead.
+func (*ListAuditEventsRequest) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{61}
+}
+
+func (x *ListAuditEventsRequest) GetActor() string {
+	if x != nil {
+		return x.Actor
+	}
+	return ""
+}
+
+func (x *ListAuditEventsRequest) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *ListAuditEventsRequest) GetOccurred() *TimeRange {
+	if x != nil {
+		return x.Occurred
+	}
+	return nil
+}
+
+func (x *ListAuditEventsRequest) GetPageSize() int32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *ListAuditEventsRequest) GetPageToken() string {
+	if x != nil {
+		return x.PageToken
+	}
+	return ""
+}
+
+type ListAuditEventsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Events        []*AuditEvent          `protobuf:"bytes,1,rep,name=events,proto3" json:"events,omitempty"`
+	NextPageToken string                 `protobuf:"bytes,2,opt,name=next_page_token,json=nextPageToken,proto3" json:"next_page_token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListAuditEventsResponse) Reset() {
+	*x = ListAuditEventsResponse{}
+	mi := &file_command_service_proto_msgTypes[62]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListAuditEventsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListAuditEventsResponse) ProtoMessage() {}
+
+func (x *ListAuditEventsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[62]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListAuditEventsResponse.ProtoReflect.Descriptor instead.
+func (*ListAuditEventsResponse) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{62}
+}
+
+func (x *ListAuditEventsResponse) GetEvents() []*AuditEvent {
+	if x != nil {
+		return x.Events
+	}
+	return nil
+}
+
+func (x *ListAuditEventsResponse) GetNextPageToken() string {
+	if x != nil {
+		return x.NextPageToken
+	}
+	return ""
+}
+
+// выгрузка идёт от старых к новым, в порядке цепочки: её можно проверить,
+// начиная с prev_hash первого события
+type ExportAuditEventsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Occurred      *TimeRange             `protobuf:"bytes,1,opt,name=occurred,proto3" json:"occurred,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExportAuditEventsRequest) Reset() {
+	*x = ExportAuditEventsRequest{}
+	mi := &file_command_service_proto_msgTypes[63]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExportAuditEventsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExportAuditEventsRequest) ProtoMessage() {}
+
+func (x *ExportAuditEventsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_command_service_proto_msgTypes[63]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExportAuditEventsRequest.ProtoReflect.Descriptor instead.
+func (*ExportAuditEventsRequest) Descriptor() ([]byte, []int) {
+	return file_command_service_proto_rawDescGZIP(), []int{63}
+}
+
+func (x *ExportAuditEventsRequest) GetOccurred() *TimeRange {
+	if x != nil {
+		return x.Occurred
+	}
+	return nil
+}
+
+var File_command_service_proto protoreflect.FileDescriptor
+
+const file_command_service_proto_rawDesc = "" +
+	"\n" +
+	"\x15command_service.proto\x12\x05proto\x1a\x1egoogle/protobuf/duration.proto\x1a\x1fgoogle/protobuf/timestamp.proto\x1a\x1cgoogle/api/annotations.proto\"J\n" +
+	"\x06Router\x12\x1b\n" +
+	"\trouter_id\x18\x01 \x01(\tR\brouterId\x12#\n" +
+	"\rserial_number\x18\x02 \x01(\tR\fserialNumber\"\xea\x01\n" +
+	"\x12SendCommandRequest\x12'\n" +
+	"\arouters\x18\x01 \x03(\v2\r.proto.RouterR\arouters\x12!\n" +
+	"\fcommand_type\x18\x02 \x01(\tR\vcommandType\x12'\n" +
+	"\x0fidempotency_key\x18\x03 \x01(\tR\x0eidempotencyKey\x12\x1f\n" +
+	"\bpriority\x18\x04 \x01(\x05H\x00R\bpriority\x88\x01\x01\x12\x17\n" +
+	"\adry_run\x18\x05 \x01(\bR\x06dryRun\x12\x18\n" +
+	"\apayload\x18\x06 \x01(\tR\apayloadB\v\n" +
+	"\t_priority\"r\n" +
+	"\vPollRequest\x12\x1b\n" +
+	"\trouter_id\x18\x01 \x01(\tR\brouterId\x12#\n" +
+	"\rserial_number\x18\x02 \x01(\tR\fserialNumber\x12!\n" +
+	"\fmax_commands\x18\x03 \x01(\rR\vmaxCommands\"\xa6\x01\n" +
+	"\n" +
+	"AckRequest\x12\x1b\n" +
 	"\trouter_id\x18\x01 \x01(\tR\brouterId\x12#\n" +
 	"\rserial_number\x18\x02 \x01(\tR\fserialNumber\x12!\n" +
-	"\fcommand_type\x18\x03 \x01(\tR\vcommandType\"=\n" +
+	"\fcommand_type\x18\x03 \x01(\tR\vcommandType\x12\x1d\n" +
+	"\n" +
+	"command_id\x18\x04 \x01(\tR\tcommandId\x12\x14\n" +
+	"\x05error\x18\x05 \x01(\tR\x05error\"\xa2\x05\n" +
+	"\vCommandInfo\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1b\n" +
+	"\trouter_id\x18\x02 \x01(\tR\brouterId\x12!\n" +
+	"\fcommand_type\x18\x03 \x01(\tR\vcommandType\x12\x18\n" +
+	"\apayload\x18\x04 \x01(\tR\apayload\x12\x16\n" +
+	"\x06status\x18\x05 \x01(\tR\x06status\x129\n" +
+	"\n" +
+	"created_at\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x123\n" +
+	"\asent_at\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\x06sentAt\x125\n" +
+	"\backed_at\x18\b \x01(\v2\x1a.google.protobuf.TimestampR\aackedAt\x12=\n" +
+	"\fcancelled_at\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\vcancelledAt\x12!\n" +
+	"\fcancelled_by\x18\n" +
+	" \x01(\tR\vcancelledBy\x12#\n" +
+	"\rcancel_reason\x18\v \x01(\tR\fcancelReason\x12\x1a\n" +
+	"\bpriority\x18\f \x01(\x05R\bpriority\x12\x14\n" +
+	"\x05error\x18\r \x01(\tR\x05error\x12\x1f\n" +
+	"\vworkflow_id\x18\x0e \x01(\tR\n" +
+	"workflowId\x12#\n" +
+	"\rworkflow_step\x18\x0f \x01(\tR\fworkflowStep\x12\x1f\n" +
+	"\vcampaign_id\x18\x10 \x01(\tR\n" +
+	"campaignId\x12\x1f\n" +
+	"\vapproval_id\x18\x11 \x01(\tR\n" +
+	"approvalId\x12)\n" +
+	"\x10payload_redacted\x18\x12 \x01(\bR\x0fpayloadRedacted\"2\n" +
+	"\x11GetCommandRequest\x12\x1d\n" +
+	"\n" +
+	"command_id\x18\x01 \x01(\tR\tcommandId\"g\n" +
+	"\tTimeRange\x12.\n" +
+	"\x04from\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\x04from\x12*\n" +
+	"\x02to\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\x02to\"\xc8\x02\n" +
+	"\x13ListCommandsRequest\x12\x1b\n" +
+	"\trouter_id\x18\x01 \x01(\tR\brouterId\x12#\n" +
+	"\rserial_number\x18\x02 \x01(\tR\fserialNumber\x12!\n" +
+	"\fcommand_type\x18\x03 \x01(\tR\vcommandType\x12\x16\n" +
+	"\x06status\x18\x04 \x03(\tR\x06status\x12*\n" +
+	"\acreated\x18\x05 \x01(\v2\x10.proto.TimeRangeR\acreated\x12$\n" +
+	"\x04sent\x18\x06 \x01(\v2\x10.proto.TimeRangeR\x04sent\x12&\n" +
+	"\x05acked\x18\a \x01(\v2\x10.proto.TimeRangeR\x05acked\x12\x1b\n" +
+	"\tpage_size\x18\b \x01(\x05R\bpageSize\x12\x1d\n" +
+	"\n" +
+	"page_token\x18\t \x01(\tR\tpageToken\"n\n" +
+	"\x14ListCommandsResponse\x12.\n" +
+	"\bcommands\x18\x01 \x03(\v2\x12.proto.CommandInfoR\bcommands\x12&\n" +
+	"\x0fnext_page_token\x18\x02 \x01(\tR\rnextPageToken\"\x91\x01\n" +
+	"\x10CoalescedCommand\x12#\n" +
+	"\rserial_number\x18\x01 \x01(\tR\fserialNumber\x12\x1d\n" +
+	"\n" +
+	"command_id\x18\x02 \x01(\tR\tcommandId\x12\x16\n" +
+	"\x06policy\x18\x03 \x01(\tR\x06policy\x12!\n" +
+	"\freplaced_ids\x18\x04 \x03(\tR\vreplacedIds\"\xdc\x01\n" +
+	"\n" +
+	"RouterPlan\x12#\n" +
+	"\rserial_number\x18\x01 \x01(\tR\fserialNumber\x12\x1b\n" +
+	"\trouter_id\x18\x02 \x01(\tR\brouterId\x12\x16\n" +
+	"\x06action\x18\x03 \x01(\tR\x06action\x12\x16\n" +
+	"\x06reason\x18\x04 \x01(\tR\x06reason\x12\x1d\n" +
+	"\n" +
+	"command_id\x18\x05 \x01(\tR\tcommandId\x12!\n" +
+	"\freplaced_ids\x18\x06 \x03(\tR\vreplacedIds\x12\x1a\n" +
+	"\bregister\x18\a \x01(\bR\bregister\"\x80\x02\n" +
 	"\x13SendCommandResponse\x12\x16\n" +
 	"\x06status\x18\x01 \x01(\tR\x06status\x12\x0e\n" +
-	"\x02id\x18\x02 \x03(\tR\x02id\"\x91\x01\n" +
+	"\x02id\x18\x02 \x03(\tR\x02id\x125\n" +
+	"\tcoalesced\x18\x03 \x03(\v2\x17.proto.CoalescedCommandR\tcoalesced\x12\x15\n" +
+	"\x06job_id\x18\x04 \x01(\tR\x05jobId\x12\x1f\n" +
+	"\vapproval_id\x18\x05 \x01(\tR\n" +
+	"approvalId\x12+\n" +
+	"\askipped\x18\x06 \x03(\v2\x11.proto.RouterPlanR\askipped\x12%\n" +
+	"\x04plan\x18\a \x03(\v2\x11.proto.RouterPlanR\x04plan\"\xe4\x01\n" +
 	"\aCommand\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12!\n" +
 	"\fcommand_type\x18\x02 \x01(\tR\vcommandType\x12\x18\n" +
 	"\apayload\x18\x03 \x01(\tR\apayload\x129\n" +
 	"\n" +
-	"created_at\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\":\n" +
+	"created_at\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x12\x1a\n" +
+	"\bpriority\x18\x05 \x01(\x05R\bpriority\x125\n" +
+	"\tsignature\x18\x06 \x01(\v2\x17.proto.CommandSignatureR\tsignature\"\xbd\x01\n" +
+	"\x10CommandSignature\x12\x15\n" +
+	"\x06key_id\x18\x01 \x01(\tR\x05keyId\x12\x1c\n" +
+	"\tsignature\x18\x02 \x01(\fR\tsignature\x129\n" +
+	"\n" +
+	"not_before\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\tnotBefore\x129\n" +
+	"\n" +
+	"expires_at\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\texpiresAt\"\x8a\x01\n" +
+	"\x12CancellationNotice\x12\x1d\n" +
+	"\n" +
+	"command_id\x18\x01 \x01(\tR\tcommandId\x12\x16\n" +
+	"\x06reason\x18\x02 \x01(\tR\x06reason\x12=\n" +
+	"\fcancelled_at\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\vcancelledAt\"\x17\n" +
+	"\x15GetSigningKeysRequest\"z\n" +
+	"\n" +
+	"SigningKey\x12\x15\n" +
+	"\x06key_id\x18\x01 \x01(\tR\x05keyId\x12\x1c\n" +
+	"\talgorithm\x18\x02 \x01(\tR\talgorithm\x12\x1d\n" +
+	"\n" +
+	"public_key\x18\x03 \x01(\fR\tpublicKey\x12\x18\n" +
+	"\acurrent\x18\x04 \x01(\bR\acurrent\"?\n" +
+	"\x16GetSigningKeysResponse\x12%\n" +
+	"\x04keys\x18\x01 \x03(\v2\x11.proto.SigningKeyR\x04keys\"{\n" +
 	"\fPollResponse\x12*\n" +
-	"\bcommands\x18\x01 \x03(\v2\x0e.proto.CommandR\bcommands\"%\n" +
+	"\bcommands\x18\x01 \x03(\v2\x0e.proto.CommandR\bcommands\x12?\n" +
+	"\rcancellations\x18\x02 \x03(\v2\x19.proto.CancellationNoticeR\rcancellations\"\xaa\x01\n" +
+	"\fWorkflowStep\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12!\n" +
+	"\fcommand_type\x18\x02 \x01(\tR\vcommandType\x12\x1d\n" +
+	"\n" +
+	"depends_on\x18\x03 \x03(\tR\tdependsOn\x12\x1f\n" +
+	"\bpriority\x18\x04 \x01(\x05H\x00R\bpriority\x88\x01\x01\x12\x18\n" +
+	"\apayload\x18\x05 \x01(\tR\apayloadB\v\n" +
+	"\t_priority\"\x9b\x01\n" +
+	"\x15SubmitWorkflowRequest\x12#\n" +
+	"\rserial_number\x18\x01 \x01(\tR\fserialNumber\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12)\n" +
+	"\x05steps\x18\x03 \x03(\v2\x13.proto.WorkflowStepR\x05steps\x12\x1e\n" +
+	"\n" +
+	"sequential\x18\x04 \x01(\bR\n" +
+	"sequential\"5\n" +
+	"\x12GetWorkflowRequest\x12\x1f\n" +
+	"\vworkflow_id\x18\x01 \x01(\tR\n" +
+	"workflowId\"q\n" +
+	"\x10WorkflowStepInfo\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x1d\n" +
+	"\n" +
+	"depends_on\x18\x02 \x03(\tR\tdependsOn\x12,\n" +
+	"\acommand\x18\x03 \x01(\v2\x12.proto.CommandInfoR\acommand\"\xd1\x01\n" +
+	"\fWorkflowInfo\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1b\n" +
+	"\trouter_id\x18\x02 \x01(\tR\brouterId\x12\x12\n" +
+	"\x04name\x18\x03 \x01(\tR\x04name\x12\x16\n" +
+	"\x06status\x18\x04 \x01(\tR\x06status\x129\n" +
+	"\n" +
+	"created_at\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x12-\n" +
+	"\x05steps\x18\x06 \x03(\v2\x17.proto.WorkflowStepInfoR\x05steps\"%\n" +
 	"\vAckResponse\x12\x16\n" +
-	"\x06status\x18\x01 \x01(\tR\x06status2\xa8\x02\n" +
+	"\x06status\x18\x01 \x01(\tR\x06status\"p\n" +
+	"\x14CancelCommandRequest\x12\x1d\n" +
+	"\n" +
+	"command_id\x18\x01 \x01(\tR\tcommandId\x12!\n" +
+	"\fcancelled_by\x18\x02 \x01(\tR\vcancelledBy\x12\x16\n" +
+	"\x06reason\x18\x03 \x01(\tR\x06reason\"\xb3\x01\n" +
+	"\x15CancelCommandsRequest\x12\x1b\n" +
+	"\trouter_id\x18\x01 \x01(\tR\brouterId\x12!\n" +
+	"\fcommand_type\x18\x02 \x01(\tR\vcommandType\x12!\n" +
+	"\fcancelled_by\x18\x03 \x01(\tR\vcancelledBy\x12\x16\n" +
+	"\x06reason\x18\x04 \x01(\tR\x06reason\x12\x1f\n" +
+	"\vcampaign_id\x18\x05 \x01(\tR\n" +
+	"campaignId\"V\n" +
+	"\x16CancelCommandsResponse\x12\x1c\n" +
+	"\tcancelled\x18\x01 \x03(\tR\tcancelled\x12\x1e\n" +
+	"\n" +
+	"cancelling\x18\x02 \x03(\tR\n" +
+	"cancelling\"}\n" +
+	"\x0eRouterSelector\x12%\n" +
+	"\x0eserial_numbers\x18\x01 \x03(\tR\rserialNumbers\x12#\n" +
+	"\rserial_prefix\x18\x02 \x01(\tR\fserialPrefix\x12\x1f\n" +
+	"\vall_routers\x18\x03 \x01(\bR\n" +
+	"allRouters\">\n" +
+	"\fCampaignWave\x12\x14\n" +
+	"\x05count\x18\x01 \x01(\rR\x05count\x12\x18\n" +
+	"\apercent\x18\x02 \x01(\x01R\apercent\"\xf5\x02\n" +
+	"\x15CreateCampaignRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12!\n" +
+	"\fcommand_type\x18\x02 \x01(\tR\vcommandType\x12\x1f\n" +
+	"\bpriority\x18\x03 \x01(\x05H\x00R\bpriority\x88\x01\x01\x121\n" +
+	"\bselector\x18\x04 \x01(\v2\x15.proto.RouterSelectorR\bselector\x12)\n" +
+	"\x05waves\x18\x05 \x03(\v2\x13.proto.CampaignWaveR\x05waves\x126\n" +
+	"\tsoak_time\x18\x06 \x01(\v2\x19.google.protobuf.DurationR\bsoakTime\x12(\n" +
+	"\x10max_failure_rate\x18\a \x01(\x01R\x0emaxFailureRate\x12\x1d\n" +
+	"\n" +
+	"created_by\x18\b \x01(\tR\tcreatedBy\x12\x18\n" +
+	"\apayload\x18\t \x01(\tR\apayloadB\v\n" +
+	"\t_priority\"5\n" +
+	"\x12GetCampaignRequest\x12\x1f\n" +
+	"\vcampaign_id\x18\x01 \x01(\tR\n" +
+	"campaignId\"P\n" +
+	"\x15CampaignActionRequest\x12\x1f\n" +
+	"\vcampaign_id\x18\x01 \x01(\tR\n" +
+	"campaignId\x12\x16\n" +
+	"\x06reason\x18\x02 \x01(\tR\x06reason\"\xd8\x01\n" +
+	"\fWaveProgress\x12\x12\n" +
+	"\x04wave\x18\x01 \x01(\rR\x04wave\x12\x18\n" +
+	"\atargets\x18\x02 \x01(\rR\atargets\x12\x1e\n" +
+	"\n" +
+	"unreleased\x18\x03 \x01(\rR\n" +
+	"unreleased\x12=\n" +
+	"\bstatuses\x18\x04 \x03(\v2!.proto.WaveProgress.StatusesEntryR\bstatuses\x1a;\n" +
+	"\rStatusesEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\rR\x05value:\x028\x01\"\xd9\x04\n" +
+	"\fCampaignInfo\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12!\n" +
+	"\fcommand_type\x18\x03 \x01(\tR\vcommandType\x12\x1a\n" +
+	"\bpriority\x18\x04 \x01(\x05R\bpriority\x12\x16\n" +
+	"\x06status\x18\x05 \x01(\tR\x06status\x12!\n" +
+	"\fpause_reason\x18\x06 \x01(\tR\vpauseReason\x12\x1f\n" +
+	"\vtotal_waves\x18\a \x01(\rR\n" +
+	"totalWaves\x12!\n" +
+	"\fcurrent_wave\x18\b \x01(\rR\vcurrentWave\x126\n" +
+	"\tsoak_time\x18\t \x01(\v2\x19.google.protobuf.DurationR\bsoakTime\x12(\n" +
+	"\x10max_failure_rate\x18\n" +
+	" \x01(\x01R\x0emaxFailureRate\x12!\n" +
+	"\ffailure_rate\x18\v \x01(\x01R\vfailureRate\x12<\n" +
+	"\fnext_wave_at\x18\f \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"nextWaveAt\x12\x1d\n" +
+	"\n" +
+	"created_by\x18\r \x01(\tR\tcreatedBy\x129\n" +
+	"\n" +
+	"created_at\x18\x0e \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x12)\n" +
+	"\x05waves\x18\x0f \x03(\v2\x13.proto.WaveProgressR\x05waves\x12\x1f\n" +
+	"\vapproval_id\x18\x10 \x01(\tR\n" +
+	"approvalId\"&\n" +
+	"\rGetJobRequest\x12\x15\n" +
+	"\x06job_id\x18\x01 \x01(\tR\x05jobId\"\xe1\x02\n" +
+	"\aJobInfo\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04kind\x18\x02 \x01(\tR\x04kind\x12\x16\n" +
+	"\x06status\x18\x03 \x01(\tR\x06status\x12\x14\n" +
+	"\x05total\x18\x04 \x01(\rR\x05total\x12\x1c\n" +
+	"\tprocessed\x18\x05 \x01(\rR\tprocessed\x12\x14\n" +
+	"\x05error\x18\x06 \x01(\tR\x05error\x12\x1d\n" +
+	"\n" +
+	"created_by\x18\a \x01(\tR\tcreatedBy\x129\n" +
+	"\n" +
+	"created_at\x18\b \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x129\n" +
+	"\n" +
+	"updated_at\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\tupdatedAt\x12;\n" +
+	"\vfinished_at\x18\n" +
+	" \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"finishedAt\"y\n" +
+	"\x0fListJobsRequest\x12\x12\n" +
+	"\x04kind\x18\x01 \x01(\tR\x04kind\x12\x16\n" +
+	"\x06status\x18\x02 \x03(\tR\x06status\x12\x1b\n" +
+	"\tpage_size\x18\x03 \x01(\rR\bpageSize\x12\x1d\n" +
+	"\n" +
+	"page_token\x18\x04 \x01(\tR\tpageToken\"^\n" +
+	"\x10ListJobsResponse\x12\"\n" +
+	"\x04jobs\x18\x01 \x03(\v2\x0e.proto.JobInfoR\x04jobs\x12&\n" +
+	"\x0fnext_page_token\x18\x02 \x01(\tR\rnextPageToken\")\n" +
+	"\x10CancelJobRequest\x12\x15\n" +
+	"\x06job_id\x18\x01 \x01(\tR\x05jobId\"5\n" +
+	"\x12GetApprovalRequest\x12\x1f\n" +
+	"\vapproval_id\x18\x01 \x01(\tR\n" +
+	"approvalId\"j\n" +
+	"\x15DecideApprovalRequest\x12\x1f\n" +
+	"\vapproval_id\x18\x01 \x01(\tR\n" +
+	"approvalId\x12\x18\n" +
+	"\aapprove\x18\x02 \x01(\bR\aapprove\x12\x16\n" +
+	"\x06reason\x18\x03 \x01(\tR\x06reason\"\xa5\x03\n" +
+	"\fApprovalInfo\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12!\n" +
+	"\fcommand_type\x18\x02 \x01(\tR\vcommandType\x12\x16\n" +
+	"\x06status\x18\x03 \x01(\tR\x06status\x12!\n" +
+	"\frequested_by\x18\x04 \x01(\tR\vrequestedBy\x12=\n" +
+	"\frequested_at\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\vrequestedAt\x129\n" +
+	"\n" +
+	"expires_at\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\texpiresAt\x12\x1d\n" +
+	"\n" +
+	"decided_by\x18\a \x01(\tR\tdecidedBy\x129\n" +
+	"\n" +
+	"decided_at\x18\b \x01(\v2\x1a.google.protobuf.TimestampR\tdecidedAt\x12\x16\n" +
+	"\x06reason\x18\t \x01(\tR\x06reason\x12\x1a\n" +
+	"\bcommands\x18\n" +
+	" \x01(\rR\bcommands\x12\x1f\n" +
+	"\vcampaign_id\x18\v \x01(\tR\n" +
+	"campaignId\"\x86\x01\n" +
+	"\x13CreateApiKeyRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x18\n" +
+	"\asubject\x18\x02 \x01(\tR\asubject\x12\x14\n" +
+	"\x05roles\x18\x03 \x03(\tR\x05roles\x12+\n" +
+	"\x03ttl\x18\x04 \x01(\v2\x19.google.protobuf.DurationR\x03ttl\"\xcf\x02\n" +
+	"\n" +
+	"ApiKeyInfo\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x18\n" +
+	"\asubject\x18\x03 \x01(\tR\asubject\x12\x14\n" +
+	"\x05roles\x18\x04 \x03(\tR\x05roles\x12\x1d\n" +
+	"\n" +
+	"created_by\x18\x05 \x01(\tR\tcreatedBy\x129\n" +
+	"\n" +
+	"created_at\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x129\n" +
+	"\n" +
+	"expires_at\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\texpiresAt\x12\x1d\n" +
+	"\n" +
+	"revoked_by\x18\b \x01(\tR\trevokedBy\x129\n" +
+	"\n" +
+	"revoked_at\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\trevokedAt\"S\n" +
+	"\x14CreateApiKeyResponse\x12#\n" +
+	"\x03key\x18\x01 \x01(\v2\x11.proto.ApiKeyInfoR\x03key\x12\x16\n" +
+	"\x06secret\x18\x02 \x01(\tR\x06secret\"=\n" +
+	"\x12ListApiKeysRequest\x12'\n" +
+	"\x0finclude_revoked\x18\x01 \x01(\bR\x0eincludeRevoked\"<\n" +
+	"\x13ListApiKeysResponse\x12%\n" +
+	"\x04keys\x18\x01 \x03(\v2\x11.proto.ApiKeyInfoR\x04keys\",\n" +
+	"\x13RevokeApiKeyRequest\x12\x15\n" +
+	"\x06key_id\x18\x01 \x01(\tR\x05keyId\"t\n" +
+	"\n" +
+	"Permission\x12\x18\n" +
+	"\amethods\x18\x01 \x03(\tR\amethods\x12#\n" +
+	"\rcommand_types\x18\x02 \x03(\tR\fcommandTypes\x12'\n" +
+	"\x0fserial_prefixes\x18\x03 \x03(\tR\x0eserialPrefixes\"\xcb\x01\n" +
+	"\x04Role\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12 \n" +
+	"\vdescription\x18\x02 \x01(\tR\vdescription\x123\n" +
+	"\vpermissions\x18\x03 \x03(\v2\x11.proto.PermissionR\vpermissions\x12\x1d\n" +
+	"\n" +
+	"updated_by\x18\x04 \x01(\tR\tupdatedBy\x129\n" +
+	"\n" +
+	"updated_at\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\tupdatedAt\"\x12\n" +
+	"\x10ListRolesRequest\"6\n" +
+	"\x11ListRolesResponse\x12!\n" +
+	"\x05roles\x18\x01 \x03(\v2\v.proto.RoleR\x05roles\"1\n" +
+	"\x0ePutRoleRequest\x12\x1f\n" +
+	"\x04role\x18\x01 \x01(\v2\v.proto.RoleR\x04role\"'\n" +
+	"\x11DeleteRoleRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\"\x14\n" +
+	"\x12DeleteRoleResponse\"`\n" +
+	"\x1cIssueRouterCredentialRequest\x12\x1b\n" +
+	"\trouter_id\x18\x01 \x01(\tR\brouterId\x12#\n" +
+	"\rserial_number\x18\x02 \x01(\tR\fserialNumber\"\xc2\x01\n" +
+	"\x10RouterCredential\x12\x1b\n" +
+	"\trouter_id\x18\x01 \x01(\tR\brouterId\x12#\n" +
+	"\rserial_number\x18\x02 \x01(\tR\fserialNumber\x12\x16\n" +
+	"\x06secret\x18\x03 \x01(\tR\x06secret\x12\x1b\n" +
+	"\tissued_by\x18\x04 \x01(\tR\bissuedBy\x127\n" +
+	"\tissued_at\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\bissuedAt\"<\n" +
+	"\x1dRevokeRouterCredentialRequest\x12\x1b\n" +
+	"\trouter_id\x18\x01 \x01(\tR\brouterId\" \n" +
+	"\x1eRevokeRouterCredentialResponse\"\xc6\x02\n" +
+	"\n" +
+	"AuditEvent\x12\x10\n" +
+	"\x03seq\x18\x01 \x01(\x03R\x03seq\x12;\n" +
+	"\voccurred_at\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"occurredAt\x12\x14\n" +
+	"\x05actor\x18\x03 \x01(\tR\x05actor\x12\x16\n" +
+	"\x06action\x18\x04 \x01(\tR\x06action\x12\x16\n" +
+	"\x06target\x18\x05 \x01(\tR\x06target\x12%\n" +
+	"\x0erequest_digest\x18\x06 \x01(\tR\rrequestDigest\x12\x18\n" +
+	"\aoutcome\x18\a \x01(\tR\aoutcome\x12\x14\n" +
+	"\x05error\x18\b \x01(\tR\x05error\x12\x1b\n" +
+	"\tsource_ip\x18\t \x01(\tR\bsourceIp\x12\x1b\n" +
+	"\tprev_hash\x18\n" +
+	" \x01(\fR\bprevHash\x12\x12\n" +
+	"\x04hash\x18\v \x01(\fR\x04hash\"\xb0\x01\n" +
+	"\x16ListAuditEventsRequest\x12\x14\n" +
+	"\x05actor\x18\x01 \x01(\tR\x05actor\x12\x16\n" +
+	"\x06action\x18\x02 \x01(\tR\x06action\x12,\n" +
+	"\boccurred\x18\x03 \x01(\v2\x10.proto.TimeRangeR\boccurred\x12\x1b\n" +
+	"\tpage_size\x18\x04 \x01(\x05R\bpageSize\x12\x1d\n" +
+	"\n" +
+	"page_token\x18\x05 \x01(\tR\tpageToken\"l\n" +
+	"\x17ListAuditEventsResponse\x12)\n" +
+	"\x06events\x18\x01 \x03(\v2\x11.proto.AuditEventR\x06events\x12&\n" +
+	"\x0fnext_page_token\x18\x02 \x01(\tR\rnextPageToken\"H\n" +
+	"\x18ExportAuditEventsRequest\x12,\n" +
+	"\boccurred\x18\x01 \x01(\v2\x10.proto.TimeRangeR\boccurred2\x97\b\n" +
 	"\x0eCommandService\x12e\n" +
 	"\vSendCommand\x12\x19.proto.SendCommandRequest\x1a\x1a.proto.SendCommandResponse\"\x1f\x82\xd3\xe4\x93\x02\x19:\x01*\"\x14/api/v1/send_command\x12Y\n" +
 	"\fPollCommands\x12\x12.proto.PollRequest\x1a\x13.proto.PollResponse\" \x82\xd3\xe4\x93\x02\x1a:\x01*\"\x15/api/v1/commands/poll\x12T\n" +
 	"\n" +
-	"AckCommand\x12\x11.proto.AckRequest\x1a\x12.proto.AckResponse\"\x1f\x82\xd3\xe4\x93\x02\x19:\x01*\"\x14/api/v1/commands/ackB\x0fZ\r./internal/pbb\x06proto3"
+	"AckCommand\x12\x11.proto.AckRequest\x1a\x12.proto.AckResponse\"\x1f\x82\xd3\xe4\x93\x02\x19:\x01*\"\x14/api/v1/commands/ack\x12a\n" +
+	"\n" +
+	"GetCommand\x12\x18.proto.GetCommandRequest\x1a\x12.proto.CommandInfo\"%\x82\xd3\xe4\x93\x02\x1f\x12\x1d/api/v1/commands/{command_id}\x12a\n" +
+	"\fListCommands\x12\x1a.proto.ListCommandsRequest\x1a\x1b.proto.ListCommandsResponse\"\x18\x82\xd3\xe4\x93\x02\x12\x12\x10/api/v1/commands\x12|\n" +
+	"\rCancelCommand\x12\x1b.proto.CancelCommandRequest\x1a\x1d.proto.CancelCommandsResponse\"/\x82\xd3\xe4\x93\x02):\x01*\"$/api/v1/commands/{command_id}/cancel\x12q\n" +
+	"\x0eCancelCommands\x12\x1c.proto.CancelCommandsRequest\x1a\x1d.proto.CancelCommandsResponse\"\"\x82\xd3\xe4\x93\x02\x1c:\x01*\"\x17/api/v1/commands/cancel\x12a\n" +
+	"\x0eSubmitWorkflow\x12\x1c.proto.SubmitWorkflowRequest\x1a\x13.proto.WorkflowInfo\"\x1c\x82\xd3\xe4\x93\x02\x16:\x01*\"\x11/api/v1/workflows\x12f\n" +
+	"\vGetWorkflow\x12\x19.proto.GetWorkflowRequest\x1a\x13.proto.WorkflowInfo\"'\x82\xd3\xe4\x93\x02!\x12\x1f/api/v1/workflows/{workflow_id}\x12k\n" +
+	"\x0eGetSigningKeys\x12\x1c.proto.GetSigningKeysRequest\x1a\x1d.proto.GetSigningKeysResponse\"\x1c\x82\xd3\xe4\x93\x02\x16\x12\x14/api/v1/signing_keys2\xc0\x04\n" +
+	"\x0fCampaignService\x12a\n" +
+	"\x0eCreateCampaign\x12\x1c.proto.CreateCampaignRequest\x1a\x13.proto.CampaignInfo\"\x1c\x82\xd3\xe4\x93\x02\x16:\x01*\"\x11/api/v1/campaigns\x12f\n" +
+	"\vGetCampaign\x12\x19.proto.GetCampaignRequest\x1a\x13.proto.CampaignInfo\"'\x82\xd3\xe4\x93\x02!\x12\x1f/api/v1/campaigns/{campaign_id}\x12t\n" +
+	"\rPauseCampaign\x12\x1c.proto.CampaignActionRequest\x1a\x13.proto.CampaignInfo\"0\x82\xd3\xe4\x93\x02*:\x01*\"%/api/v1/campaigns/{campaign_id}/pause\x12v\n" +
+	"\x0eResumeCampaign\x12\x1c.proto.CampaignActionRequest\x1a\x13.proto.CampaignInfo\"1\x82\xd3\xe4\x93\x02+:\x01*\"&/api/v1/campaigns/{campaign_id}/resume\x12t\n" +
+	"\rAbortCampaign\x12\x1c.proto.CampaignActionRequest\x1a\x13.proto.CampaignInfo\"0\x82\xd3\xe4\x93\x02*:\x01*\"%/api/v1/campaigns/{campaign_id}/abort2\x8d\x02\n" +
+	"\n" +
+	"JobService\x12M\n" +
+	"\x06GetJob\x12\x14.proto.GetJobRequest\x1a\x0e.proto.JobInfo\"\x1d\x82\xd3\xe4\x93\x02\x17\x12\x15/api/v1/jobs/{job_id}\x12Q\n" +
+	"\bListJobs\x12\x16.proto.ListJobsRequest\x1a\x17.proto.ListJobsResponse\"\x14\x82\xd3\xe4\x93\x02\x0e\x12\f/api/v1/jobs\x12]\n" +
+	"\tCancelJob\x12\x17.proto.CancelJobRequest\x1a\x0e.proto.JobInfo\"'\x82\xd3\xe4\x93\x02!:\x01*\"\x1c/api/v1/jobs/{job_id}/cancel2\xf1\x01\n" +
+	"\x0fApprovalService\x12f\n" +
+	"\vGetApproval\x12\x19.proto.GetApprovalRequest\x1a\x13.proto.ApprovalInfo\"'\x82\xd3\xe4\x93\x02!\x12\x1f/api/v1/approvals/{approval_id}\x12v\n" +
+	"\x0eDecideApproval\x12\x1c.proto.DecideApprovalRequest\x1a\x13.proto.ApprovalInfo\"1\x82\xd3\xe4\x93\x02+:\x01*\"&/api/v1/approvals/{approval_id}/decide2\xc1\x02\n" +
+	"\rApiKeyService\x12d\n" +
+	"\fCreateApiKey\x12\x1a.proto.CreateApiKeyRequest\x1a\x1b.proto.CreateApiKeyResponse\"\x1b\x82\xd3\xe4\x93\x02\x15:\x01*\"\x10/api/v1/api_keys\x12^\n" +
+	"\vListApiKeys\x12\x19.proto.ListApiKeysRequest\x1a\x1a.proto.ListApiKeysResponse\"\x18\x82\xd3\xe4\x93\x02\x12\x12\x10/api/v1/api_keys\x12j\n" +
+	"\fRevokeApiKey\x12\x1a.proto.RevokeApiKeyRequest\x1a\x11.proto.ApiKeyInfo\"+\x82\xd3\xe4\x93\x02%:\x01*\" /api/v1/api_keys/{key_id}/revoke2\x9d\x02\n" +
+	"\vRoleService\x12U\n" +
+	"\tListRoles\x12\x17.proto.ListRolesRequest\x1a\x18.proto.ListRolesResponse\"\x15\x82\xd3\xe4\x93\x02\x0f\x12\r/api/v1/roles\x12V\n" +
+	"\aPutRole\x12\x15.proto.PutRoleRequest\x1a\v.proto.Role\"'\x82\xd3\xe4\x93\x02!:\x04role\x1a\x19/api/v1/roles/{role.name}\x12_\n" +
+	"\n" +
+	"DeleteRole\x12\x18.proto.DeleteRoleRequest\x1a\x19.proto.DeleteRoleResponse\"\x1c\x82\xd3\xe4\x93\x02\x16*\x14/api/v1/roles/{name}2\xb9\x02\n" +
+	"\x17RouterCredentialService\x12|\n" +
+	"\x15IssueRouterCredential\x12#.proto.IssueRouterCredentialRequest\x1a\x17.proto.RouterCredential\"%\x82\xd3\xe4\x93\x02\x1f:\x01*\"\x1a/api/v1/router_credentials\x12\x9f\x01\n" +
+	"\x16RevokeRouterCredential\x12$.proto.RevokeRouterCredentialRequest\x1a%.proto.RevokeRouterCredentialResponse\"8\x82\xd3\xe4\x93\x022:\x01*\"-/api/v1/router_credentials/{router_id}/revoke2\xee\x01\n" +
+	"\fAuditService\x12n\n" +
+	"\x0fListAuditEvents\x12\x1d.proto.ListAuditEventsRequest\x1a\x1e.proto.ListAuditEventsResponse\"\x1c\x82\xd3\xe4\x93\x02\x16\x12\x14/api/v1/audit_events\x12n\n" +
+	"\x11ExportAuditEvents\x12\x1f.proto.ExportAuditEventsRequest\x1a\x11.proto.AuditEvent\"#\x82\xd3\xe4\x93\x02\x1d\x12\x1b/api/v1/audit_events:export0\x01B\x0fZ\r./internal/pbb\x06proto3"
 
 var (
 	file_command_service_proto_rawDescOnce sync.Once
@@ -505,33 +4824,198 @@ func file_command_service_proto_rawDescGZIP() []byte {
 	return file_command_service_proto_rawDescData
 }
 
-var file_command_service_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_command_service_proto_msgTypes = make([]protoimpl.MessageInfo, 65)
 var file_command_service_proto_goTypes = []any{
-	(*Router)(nil),                // 0: proto.Router
-	(*SendCommandRequest)(nil),    // 1: proto.SendCommandRequest
-	(*PollRequest)(nil),           // 2: proto.PollRequest
-	(*AckRequest)(nil),            // 3: proto.AckRequest
-	(*SendCommandResponse)(nil),   // 4: proto.SendCommandResponse
-	(*Command)(nil),               // 5: proto.Command
-	(*PollResponse)(nil),          // 6: proto.PollResponse
-	(*AckResponse)(nil),           // 7: proto.AckResponse
-	(*timestamppb.Timestamp)(nil), // 8: google.protobuf.Timestamp
+	(*Router)(nil),                         // 0: proto.Router
+	(*SendCommandRequest)(nil),             // 1: proto.SendCommandRequest
+	(*PollRequest)(nil),                    // 2: proto.PollRequest
+	(*AckRequest)(nil),                     // 3: proto.AckRequest
+	(*CommandInfo)(nil),                    // 4: proto.CommandInfo
+	(*GetCommandRequest)(nil),              // 5: proto.GetCommandRequest
+	(*TimeRange)(nil),                      // 6: proto.TimeRange
+	(*ListCommandsRequest)(nil),            // 7: proto.ListCommandsRequest
+	(*ListCommandsResponse)(nil),           // 8: proto.ListCommandsResponse
+	(*CoalescedCommand)(nil),               // 9: proto.CoalescedCommand
+	(*RouterPlan)(nil),                     // 10: proto.RouterPlan
+	(*SendCommandResponse)(nil),            // 11: proto.SendCommandResponse
+	(*Command)(nil),                        // 12: proto.Command
+	(*CommandSignature)(nil),               // 13: proto.CommandSignature
+	(*CancellationNotice)(nil),             // 14: proto.CancellationNotice
+	(*GetSigningKeysRequest)(nil),          // 15: proto.GetSigningKeysRequest
+	(*SigningKey)(nil),                     // 16: proto.SigningKey
+	(*GetSigningKeysResponse)(nil),         // 17: proto.GetSigningKeysResponse
+	(*PollResponse)(nil),                   // 18: proto.PollResponse
+	(*WorkflowStep)(nil),                   // 19: proto.WorkflowStep
+	(*SubmitWorkflowRequest)(nil),          // 20: proto.SubmitWorkflowRequest
+	(*GetWorkflowRequest)(nil),             // 21: proto.GetWorkflowRequest
+	(*WorkflowStepInfo)(nil),               // 22: proto.WorkflowStepInfo
+	(*WorkflowInfo)(nil),                   // 23: proto.WorkflowInfo
+	(*AckResponse)(nil),                    // 24: proto.AckResponse
+	(*CancelCommandRequest)(nil),           // 25: proto.CancelCommandRequest
+	(*CancelCommandsRequest)(nil),          // 26: proto.CancelCommandsRequest
+	(*CancelCommandsResponse)(nil),         // 27: proto.CancelCommandsResponse
+	(*RouterSelector)(nil),                 // 28: proto.RouterSelector
+	(*CampaignWave)(nil),                   // 29: proto.CampaignWave
+	(*CreateCampaignRequest)(nil),          // 30: proto.CreateCampaignRequest
+	(*GetCampaignRequest)(nil),             // 31: proto.GetCampaignRequest
+	(*CampaignActionRequest)(nil),          // 32: proto.CampaignActionRequest
+	(*WaveProgress)(nil),                   // 33: proto.WaveProgress
+	(*CampaignInfo)(nil),                   // 34: proto.CampaignInfo
+	(*GetJobRequest)(nil),                  // 35: proto.GetJobRequest
+	(*JobInfo)(nil),                        // 36: proto.JobInfo
+	(*ListJobsRequest)(nil),                // 37: proto.ListJobsRequest
+	(*ListJobsResponse)(nil),               // 38: proto.ListJobsResponse
+	(*CancelJobRequest)(nil),               // 39: proto.CancelJobRequest
+	(*GetApprovalRequest)(nil),             // 40: proto.GetApprovalRequest
+	(*DecideApprovalRequest)(nil),          // 41: proto.DecideApprovalRequest
+	(*ApprovalInfo)(nil),                   // 42: proto.ApprovalInfo
+	(*CreateApiKeyRequest)(nil),            // 43: proto.CreateApiKeyRequest
+	(*ApiKeyInfo)(nil),                     // 44: proto.ApiKeyInfo
+	(*CreateApiKeyResponse)(nil),           // 45: proto.CreateApiKeyResponse
+	(*ListApiKeysRequest)(nil),             // 46: proto.ListApiKeysRequest
+	(*ListApiKeysResponse)(nil),            // 47: proto.ListApiKeysResponse
+	(*RevokeApiKeyRequest)(nil),            // 48: proto.RevokeApiKeyRequest
+	(*Permission)(nil),                     // 49: proto.Permission
+	(*Role)(nil),                           // 50: proto.Role
+	(*ListRolesRequest)(nil),               // 51: proto.ListRolesRequest
+	(*ListRolesResponse)(nil),              // 52: proto.ListRolesResponse
+	(*PutRoleRequest)(nil),                 // 53: proto.PutRoleRequest
+	(*DeleteRoleRequest)(nil),              // 54: proto.DeleteRoleRequest
+	(*DeleteRoleResponse)(nil),             // 55: proto.DeleteRoleResponse
+	(*IssueRouterCredentialRequest)(nil),   // 56: proto.IssueRouterCredentialRequest
+	(*RouterCredential)(nil),               // 57: proto.RouterCredential
+	(*RevokeRouterCredentialRequest)(nil),  // 58: proto.RevokeRouterCredentialRequest
+	(*RevokeRouterCredentialResponse)(nil), // 59: proto.RevokeRouterCredentialResponse
+	(*AuditEvent)(nil),                     // 60: proto.AuditEvent
+	(*ListAuditEventsRequest)(nil),         // 61: proto.ListAuditEventsRequest
+	(*ListAuditEventsResponse)(nil),        // 62: proto.ListAuditEventsResponse
+	(*ExportAuditEventsRequest)(nil),       // 63: proto.ExportAuditEventsRequest
+	nil,                                    // 64: proto.WaveProgress.StatusesEntry
+	(*timestamppb.Timestamp)(nil),          // 65: google.protobuf.Timestamp
+	(*durationpb.Duration)(nil),            // 66: google.protobuf.Duration
 }
 var file_command_service_proto_depIdxs = []int32{
-	0, // 0: proto.SendCommandRequest.routers:type_name -> proto.Router
-	8, // 1: proto.Command.created_at:type_name -> google.protobuf.Timestamp
-	5, // 2: proto.PollResponse.commands:type_name -> proto.Command
-	1, // 3: proto.CommandService.SendCommand:input_type -> proto.SendCommandRequest
-	2, // 4: proto.CommandService.PollCommands:input_type -> proto.PollRequest
-	3, // 5: proto.CommandService.AckCommand:input_type -> proto.AckRequest
-	4, // 6: proto.CommandService.SendCommand:output_type -> proto.SendCommandResponse
-	6, // 7: proto.CommandService.PollCommands:output_type -> proto.PollResponse
-	7, // 8: proto.CommandService.AckCommand:output_type -> proto.AckResponse
-	6, // [6:9] is the sub-list for method output_type
-	3, // [3:6] is the sub-list for method input_type
-	3, // [3:3] is the sub-list for extension type_name
-	3, // [3:3] is the sub-list for extension extendee
-	0, // [0:3] is the sub-list for field type_name
+	0,  // 0: proto.SendCommandRequest.routers:type_name -> proto.Router
+	65, // 1: proto.CommandInfo.created_at:type_name -> google.protobuf.Timestamp
+	65, // 2: proto.CommandInfo.sent_at:type_name -> google.protobuf.Timestamp
+	65, // 3: proto.CommandInfo.acked_at:type_name -> google.protobuf.Timestamp
+	65, // 4: proto.CommandInfo.cancelled_at:type_name -> google.protobuf.Timestamp
+	65, // 5: proto.TimeRange.from:type_name -> google.protobuf.Timestamp
+	65, // 6: proto.TimeRange.to:type_name -> google.protobuf.Timestamp
+	6,  // 7: proto.ListCommandsRequest.created:type_name -> proto.TimeRange
+	6,  // 8: proto.ListCommandsRequest.sent:type_name -> proto.TimeRange
+	6,  // 9: proto.ListCommandsRequest.acked:type_name -> proto.TimeRange
+	4,  // 10: proto.ListCommandsResponse.commands:type_name -> proto.CommandInfo
+	9,  // 11: proto.SendCommandResponse.coalesced:type_name -> proto.CoalescedCommand
+	10, // 12: proto.SendCommandResponse.skipped:type_name -> proto.RouterPlan
+	10, // 13: proto.SendCommandResponse.plan:type_name -> proto.RouterPlan
+	65, // 14: proto.Command.created_at:type_name -> google.protobuf.Timestamp
+	13, // 15: proto.Command.signature:type_name -> proto.CommandSignature
+	65, // 16: proto.CommandSignature.not_before:type_name -> google.protobuf.Timestamp
+	65, // 17: proto.CommandSignature.expires_at:type_name -> google.protobuf.Timestamp
+	65, // 18: proto.CancellationNotice.cancelled_at:type_name -> google.protobuf.Timestamp
+	16, // 19: proto.GetSigningKeysResponse.keys:type_name -> proto.SigningKey
+	12, // 20: proto.PollResponse.commands:type_name -> proto.Command
+	14, // 21: proto.PollResponse.cancellations:type_name -> proto.CancellationNotice
+	19, // 22: proto.SubmitWorkflowRequest.steps:type_name -> proto.WorkflowStep
+	4,  // 23: proto.WorkflowStepInfo.command:type_name -> proto.CommandInfo
+	65, // 24: proto.WorkflowInfo.created_at:type_name -> google.protobuf.Timestamp
+	22, // 25: proto.WorkflowInfo.steps:type_name -> proto.WorkflowStepInfo
+	28, // 26: proto.CreateCampaignRequest.selector:type_name -> proto.RouterSelector
+	29, // 27: proto.CreateCampaignRequest.waves:type_name -> proto.CampaignWave
+	66, // 28: proto.CreateCampaignRequest.soak_time:type_name -> google.protobuf.Duration
+	64, // 29: proto.WaveProgress.statuses:type_name -> proto.WaveProgress.StatusesEntry
+	66, // 30: proto.CampaignInfo.soak_time:type_name -> google.protobuf.Duration
+	65, // 31: proto.CampaignInfo.next_wave_at:type_name -> google.protobuf.Timestamp
+	65, // 32: proto.CampaignInfo.created_at:type_name -> google.protobuf.Timestamp
+	33, // 33: proto.CampaignInfo.waves:type_name -> proto.WaveProgress
+	65, // 34: proto.JobInfo.created_at:type_name -> google.protobuf.Timestamp
+	65, // 35: proto.JobInfo.updated_at:type_name -> google.protobuf.Timestamp
+	65, // 36: proto.JobInfo.finished_at:type_name -> google.protobuf.Timestamp
+	36, // 37: proto.ListJobsResponse.jobs:type_name -> proto.JobInfo
+	65, // 38: proto.ApprovalInfo.requested_at:type_name -> google.protobuf.Timestamp
+	65, // 39: proto.ApprovalInfo.expires_at:type_name -> google.protobuf.Timestamp
+	65, // 40: proto.ApprovalInfo.decided_at:type_name -> google.protobuf.Timestamp
+	66, // 41: proto.CreateApiKeyRequest.ttl:type_name -> google.protobuf.Duration
+	65, // 42: proto.ApiKeyInfo.created_at:type_name -> google.protobuf.Timestamp
+	65, // 43: proto.ApiKeyInfo.expires_at:type_name -> google.protobuf.Timestamp
+	65, // 44: proto.ApiKeyInfo.revoked_at:type_name -> google.protobuf.Timestamp
+	44, // 45: proto.CreateApiKeyResponse.key:type_name -> proto.ApiKeyInfo
+	44, // 46: proto.ListApiKeysResponse.keys:type_name -> proto.ApiKeyInfo
+	49, // 47: proto.Role.permissions:type_name -> proto.Permission
+	65, // 48: proto.Role.updated_at:type_name -> google.protobuf.Timestamp
+	50, // 49: proto.ListRolesResponse.roles:type_name -> proto.Role
+	50, // 50: proto.PutRoleRequest.role:type_name -> proto.Role
+	65, // 51: proto.RouterCredential.issued_at:type_name -> google.protobuf.Timestamp
+	65, // 52: proto.AuditEvent.occurred_at:type_name -> google.protobuf.Timestamp
+	6,  // 53: proto.ListAuditEventsRequest.occurred:type_name -> proto.TimeRange
+	60, // 54: proto.ListAuditEventsResponse.events:type_name -> proto.AuditEvent
+	6,  // 55: proto.ExportAuditEventsRequest.occurred:type_name -> proto.TimeRange
+	1,  // 56: proto.CommandService.SendCommand:input_type -> proto.SendCommandRequest
+	2,  // 57: proto.CommandService.PollCommands:input_type -> proto.PollRequest
+	3,  // 58: proto.CommandService.AckCommand:input_type -> proto.AckRequest
+	5,  // 59: proto.CommandService.GetCommand:input_type -> proto.GetCommandRequest
+	7,  // 60: proto.CommandService.ListCommands:input_type -> proto.ListCommandsRequest
+	25, // 61: proto.CommandService.CancelCommand:input_type -> proto.CancelCommandRequest
+	26, // 62: proto.CommandService.CancelCommands:input_type -> proto.CancelCommandsRequest
+	20, // 63: proto.CommandService.SubmitWorkflow:input_type -> proto.SubmitWorkflowRequest
+	21, // 64: proto.CommandService.GetWorkflow:input_type -> proto.GetWorkflowRequest
+	15, // 65: proto.CommandService.GetSigningKeys:input_type -> proto.GetSigningKeysRequest
+	30, // 66: proto.CampaignService.CreateCampaign:input_type -> proto.CreateCampaignRequest
+	31, // 67: proto.CampaignService.GetCampaign:input_type -> proto.GetCampaignRequest
+	32, // 68: proto.CampaignService.PauseCampaign:input_type -> proto.CampaignActionRequest
+	32, // 69: proto.CampaignService.ResumeCampaign:input_type -> proto.CampaignActionRequest
+	32, // 70: proto.CampaignService.AbortCampaign:input_type -> proto.CampaignActionRequest
+	35, // 71: proto.JobService.GetJob:input_type -> proto.GetJobRequest
+	37, // 72: proto.JobService.ListJobs:input_type -> proto.ListJobsRequest
+	39, // 73: proto.JobService.CancelJob:input_type -> proto.CancelJobRequest
+	40, // 74: proto.ApprovalService.GetApproval:input_type -> proto.GetApprovalRequest
+	41, // 75: proto.ApprovalService.DecideApproval:input_type -> proto.DecideApprovalRequest
+	43, // 76: proto.ApiKeyService.CreateApiKey:input_type -> proto.CreateApiKeyRequest
+	46, // 77: proto.ApiKeyService.ListApiKeys:input_type -> proto.ListApiKeysRequest
+	48, // 78: proto.ApiKeyService.RevokeApiKey:input_type -> proto.RevokeApiKeyRequest
+	51, // 79: proto.RoleService.ListRoles:input_type -> proto.ListRolesRequest
+	53, // 80: proto.RoleService.PutRole:input_type -> proto.PutRoleRequest
+	54, // 81: proto.RoleService.DeleteRole:input_type -> proto.DeleteRoleRequest
+	56, // 82: proto.RouterCredentialService.IssueRouterCredential:input_type -> proto.IssueRouterCredentialRequest
+	58, // 83: proto.RouterCredentialService.RevokeRouterCredential:input_type -> proto.RevokeRouterCredentialRequest
+	61, // 84: proto.AuditService.ListAuditEvents:input_type -> proto.ListAuditEventsRequest
+	63, // 85: proto.AuditService.ExportAuditEvents:input_type -> proto.ExportAuditEventsRequest
+	11, // 86: proto.CommandService.SendCommand:output_type -> proto.SendCommandResponse
+	18, // 87: proto.CommandService.PollCommands:output_type -> proto.PollResponse
+	24, // 88: proto.CommandService.AckCommand:output_type -> proto.AckResponse
+	4,  // 89: proto.CommandService.GetCommand:output_type -> proto.CommandInfo
+	8,  // 90: proto.CommandService.ListCommands:output_type -> proto.ListCommandsResponse
+	27, // 91: proto.CommandService.CancelCommand:output_type -> proto.CancelCommandsResponse
+	27, // 92: proto.CommandService.CancelCommands:output_type -> proto.CancelCommandsResponse
+	23, // 93: proto.CommandService.SubmitWorkflow:output_type -> proto.WorkflowInfo
+	23, // 94: proto.CommandService.GetWorkflow:output_type -> proto.WorkflowInfo
+	17, // 95: proto.CommandService.GetSigningKeys:output_type -> proto.GetSigningKeysResponse
+	34, // 96: proto.CampaignService.CreateCampaign:output_type -> proto.CampaignInfo
+	34, // 97: proto.CampaignService.GetCampaign:output_type -> proto.CampaignInfo
+	34, // 98: proto.CampaignService.PauseCampaign:output_type -> proto.CampaignInfo
+	34, // 99: proto.CampaignService.ResumeCampaign:output_type -> proto.CampaignInfo
+	34, // 100: proto.CampaignService.AbortCampaign:output_type -> proto.CampaignInfo
+	36, // 101: proto.JobService.GetJob:output_type -> proto.JobInfo
+	38, // 102: proto.JobService.ListJobs:output_type -> proto.ListJobsResponse
+	36, // 103: proto.JobService.CancelJob:output_type -> proto.JobInfo
+	42, // 104: proto.ApprovalService.GetApproval:output_type -> proto.ApprovalInfo
+	42, // 105: proto.ApprovalService.DecideApproval:output_type -> proto.ApprovalInfo
+	45, // 106: proto.ApiKeyService.CreateApiKey:output_type -> proto.CreateApiKeyResponse
+	47, // 107: proto.ApiKeyService.ListApiKeys:output_type -> proto.ListApiKeysResponse
+	44, // 108: proto.ApiKeyService.RevokeApiKey:output_type -> proto.ApiKeyInfo
+	52, // 109: proto.RoleService.ListRoles:output_type -> proto.ListRolesResponse
+	50, // 110: proto.RoleService.PutRole:output_type -> proto.Role
+	55, // 111: proto.RoleService.DeleteRole:output_type -> proto.DeleteRoleResponse
+	57, // 112: proto.RouterCredentialService.IssueRouterCredential:output_type -> proto.RouterCredential
+	59, // 113: proto.RouterCredentialService.RevokeRouterCredential:output_type -> proto.RevokeRouterCredentialResponse
+	62, // 114: proto.AuditService.ListAuditEvents:output_type -> proto.ListAuditEventsResponse
+	60, // 115: proto.AuditService.ExportAuditEvents:output_type -> proto.AuditEvent
+	86, // [86:116] is the sub-list for method output_type
+	56, // [56:86] is the sub-list for method input_type
+	56, // [56:56] is the sub-list for extension type_name
+	56, // [56:56] is the sub-list for extension extendee
+	0,  // [0:56] is the sub-list for field type_name
 }
 
 func init() { file_command_service_proto_init() }
@@ -539,15 +5023,18 @@ func file_command_service_proto_init() {
 	if File_command_service_proto != nil {
 		return
 	}
+	file_command_service_proto_msgTypes[1].OneofWrappers = []any{}
+	file_command_service_proto_msgTypes[19].OneofWrappers = []any{}
+	file_command_service_proto_msgTypes[30].OneofWrappers = []any{}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_command_service_proto_rawDesc), len(file_command_service_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   8,
+			NumMessages:   65,
 			NumExtensions: 0,
-			NumServices:   1,
+			NumServices:   8,
 		},
 		GoTypes:           file_command_service_proto_goTypes,
 		DependencyIndexes: file_command_service_proto_depIdxs,
@@ -0,0 +1,172 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"router-manager/internal/auth"
+	"router-manager/internal/metrics"
+	"router-manager/internal/repository/redis"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Limit is a token bucket: Burst calls at once, Rate more per second.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Store keeps the buckets, shared by every instance.
+type Store interface {
+	TakeToken(ctx context.Context, key string, rate float64, burst int) (allowed bool, retryAfter time.Duration, err error)
+}
+
+// Limiter limits the calls of each method to its Limit per caller: routers
+// by router id on the router-facing methods, operators by principal. Callers
+// nobody authenticated are also told apart by their address, so that a
+// client naming another router or operator doesn't use up their bucket.
+type Limiter struct {
+	store  Store
+	limits map[string]Limit
+	// methods routers call, whose caller is the router
+	routerMethods map[string]bool
+}
+
+// NewLimiter limits the methods of limits, named "Service.Method".
+func NewLimiter(store Store, limits map[string]Limit, routerMethods ...string) *Limiter {
+	l := &Limiter{store: store, limits: limits, routerMethods: make(map[string]bool)}
+	for _, method := range routerMethods {
+		l.routerMethods[method] = true
+	}
+	return l
+}
+
+// UnaryInterceptor rejects calls over the limit with ResourceExhausted, with
+// a RetryInfo detail and a retry-after header in seconds. It must run after
+// the authenticators, so that callers are who they claim to be. Calls are
+// let through while the store is unavailable.
+func (l *Limiter) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		method := auth.MethodName(info.FullMethod)
+		limit, ok := l.limits[method]
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		caller, kind := l.caller(ctx, info.FullMethod, req)
+		allowed, retryAfter, err := l.store.TakeToken(ctx, method+":"+caller, limit.Rate, limit.Burst)
+		if err != nil {
+			if !redis.IsUnavailable(err) {
+				slog.WarnContext(ctx, "Rate limit not checked", "error", err)
+			}
+			return handler(ctx, req)
+		}
+		if !allowed {
+			metrics.ThrottledCalls.WithLabelValues(method, kind).Inc()
+			return nil, throttled(ctx, method, retryAfter)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// caller is the key of the bucket of the call and the kind of caller,
+// "router" or "operator".
+func (l *Limiter) caller(ctx context.Context, fullMethod string, req any) (string, string) {
+	if router, ok := req.(interface{ GetRouterId() string }); ok && l.routerMethods[fullMethod] {
+		if routerId, ok := auth.RouterFromContext(ctx); ok {
+			return "router:" + routerId, "router"
+		}
+		// without router authentication routers only name themselves
+		return "router:" + router.GetRouterId() + "@" + auth.SourceIP(ctx), "router"
+	}
+
+	if principal, ok := auth.FromContext(ctx); ok {
+		return "principal:" + principal.Subject, "operator"
+	}
+
+	// without authentication operators only name themselves
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("x-caller-id"); len(values) > 0 && values[0] != "" {
+			return "caller:" + values[0] + "@" + auth.SourceIP(ctx), "operator"
+		}
+	}
+	return "anonymous@" + auth.SourceIP(ctx), "operator"
+}
+
+func throttled(ctx context.Context, method string, retryAfter time.Duration) error {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	// there's no header to set outside a gRPC server, e.g. in tests
+	_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(max(seconds, 1))))
+
+	st := status.Newf(codes.ResourceExhausted, "rate limit of %s exceeded, retry in %s",
+		method, retryAfter.Round(time.Millisecond))
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// ParseLimits reads comma-separated limits "Service.Method=N/unit[:burst]",
+// unit being s, m or h, e.g. "CommandService.PollCommands=30/m:5". The
+// burst defaults to N.
+func ParseLimits(spec string) (map[string]Limit, error) {
+	limits := make(map[string]Limit)
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		method, value, ok := strings.Cut(item, "=")
+		if !ok || !strings.Contains(method, ".") {
+			return nil, fmt.Errorf("invalid rate limit %q, want Service.Method=N/unit[:burst]", item)
+		}
+
+		value, burstValue, hasBurst := strings.Cut(value, ":")
+		count, unit, ok := strings.Cut(value, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q, want Service.Method=N/unit[:burst]", item)
+		}
+
+		n, err := strconv.ParseFloat(count, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid rate of %s: %q", method, count)
+		}
+
+		var per time.Duration
+		switch unit {
+		case "s":
+			per = time.Second
+		case "m":
+			per = time.Minute
+		case "h":
+			per = time.Hour
+		default:
+			return nil, fmt.Errorf("invalid rate unit of %s: %q, want s, m or h", method, unit)
+		}
+
+		burst := max(int(math.Ceil(n)), 1)
+		if hasBurst {
+			burst, err = strconv.Atoi(burstValue)
+			if err != nil || burst < 1 {
+				return nil, fmt.Errorf("invalid burst of %s: %q", method, burstValue)
+			}
+		}
+
+		if _, ok := limits[method]; ok {
+			return nil, fmt.Errorf("rate limit of %s is set twice", method)
+		}
+		limits[method] = Limit{Rate: n / per.Seconds(), Burst: burst}
+	}
+	return limits, nil
+}
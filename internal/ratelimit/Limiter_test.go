@@ -0,0 +1,174 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"router-manager/internal/auth"
+	"router-manager/internal/pb"
+	"router-manager/internal/repository/redis"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+var routerMethods = []string{pb.CommandService_PollCommands_FullMethodName, pb.CommandService_AckCommand_FullMethodName}
+
+// fakeStore counts the tokens taken per key, allowing burst of them.
+type fakeStore struct {
+	taken map[string]int
+	err   error
+}
+
+func (s *fakeStore) TakeToken(ctx context.Context, key string, rate float64, burst int) (bool, time.Duration, error) {
+	if s.err != nil {
+		return false, 0, s.err
+	}
+	if s.taken[key] >= burst {
+		return false, 1500 * time.Millisecond, nil
+	}
+	s.taken[key]++
+	return true, 0, nil
+}
+
+func setupLimiter(limits map[string]Limit) (*Limiter, *fakeStore) {
+	store := &fakeStore{taken: make(map[string]int)}
+	return NewLimiter(store, limits, routerMethods...), store
+}
+
+// call runs the request through the interceptor and tells whether it
+// reached the handler.
+func call(l *Limiter, ctx context.Context, method string, req any) (bool, error) {
+	handled := false
+	_, err := l.UnaryInterceptor()(ctx, req, &grpc.UnaryServerInfo{FullMethod: method},
+		func(ctx context.Context, req any) (any, error) {
+			handled = true
+			return nil, nil
+		})
+	return handled, err
+}
+
+// from returns a context of a call from the address.
+func from(address string) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(address), Port: 40000}})
+}
+
+func TestLimiter_Routers(t *testing.T) {
+	l, store := setupLimiter(map[string]Limit{"CommandService.PollCommands": {Rate: 1, Burst: 2}})
+
+	r1 := auth.NewRouterContext(from("10.0.0.7"), "r-1")
+	poll := &pb.PollRequest{RouterId: "r-1", SerialNumber: "SN1"}
+	for range 2 {
+		handled, err := call(l, r1, pb.CommandService_PollCommands_FullMethodName, poll)
+		require.NoError(t, err)
+		assert.True(t, handled)
+	}
+
+	handled, err := call(l, r1, pb.CommandService_PollCommands_FullMethodName, poll)
+	assert.False(t, handled)
+	st := status.Convert(err)
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+	assert.Contains(t, st.Message(), "retry in 1.5s")
+	require.Len(t, st.Details(), 1)
+	assert.Equal(t, 1500*time.Millisecond, st.Details()[0].(*errdetails.RetryInfo).RetryDelay.AsDuration())
+
+	// each router has its bucket, wherever it calls from
+	handled, err = call(l, auth.NewRouterContext(from("10.0.0.7"), "r-2"), pb.CommandService_PollCommands_FullMethodName,
+		&pb.PollRequest{RouterId: "r-2"})
+	require.NoError(t, err)
+	assert.True(t, handled)
+	assert.Equal(t, map[string]int{"CommandService.PollCommands:router:r-1": 2, "CommandService.PollCommands:router:r-2": 1}, store.taken)
+}
+
+func TestLimiter_UnauthenticatedRouters(t *testing.T) {
+	l, store := setupLimiter(map[string]Limit{"CommandService.PollCommands": {Rate: 1, Burst: 1}})
+	poll := &pb.PollRequest{RouterId: "r-1", SerialNumber: "SN1"}
+
+	handled, err := call(l, from("192.0.2.1"), pb.CommandService_PollCommands_FullMethodName, poll)
+	require.NoError(t, err)
+	assert.True(t, handled)
+	_, err = call(l, from("192.0.2.1"), pb.CommandService_PollCommands_FullMethodName, poll)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// a client claiming to be r-1 doesn't use up the bucket of r-1
+	handled, err = call(l, from("10.0.0.7"), pb.CommandService_PollCommands_FullMethodName, poll)
+	require.NoError(t, err)
+	assert.True(t, handled)
+
+	assert.Equal(t, map[string]int{
+		"CommandService.PollCommands:router:r-1@192.0.2.1": 1,
+		"CommandService.PollCommands:router:r-1@10.0.0.7":  1,
+	}, store.taken)
+}
+
+func TestLimiter_Operators(t *testing.T) {
+	l, store := setupLimiter(map[string]Limit{"CommandService.SendCommand": {Rate: 1, Burst: 1}})
+	req := &pb.SendCommandRequest{CommandType: "REBOOT"}
+
+	alice := auth.NewContext(context.Background(), &auth.Principal{Subject: "alice"})
+	handled, err := call(l, alice, pb.CommandService_SendCommand_FullMethodName, req)
+	require.NoError(t, err)
+	assert.True(t, handled)
+
+	_, err = call(l, alice, pb.CommandService_SendCommand_FullMethodName, req)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// without authentication, by x-caller-id and address
+	bob := metadata.NewIncomingContext(from("10.0.0.8"), metadata.Pairs("x-caller-id", "bob"))
+	handled, err = call(l, bob, pb.CommandService_SendCommand_FullMethodName, req)
+	require.NoError(t, err)
+	assert.True(t, handled)
+
+	// methods without a limit aren't counted
+	handled, err = call(l, alice, pb.CommandService_ListCommands_FullMethodName, &pb.ListCommandsRequest{})
+	require.NoError(t, err)
+	assert.True(t, handled)
+
+	assert.Equal(t, map[string]int{"CommandService.SendCommand:principal:alice": 1, "CommandService.SendCommand:caller:bob@10.0.0.8": 1}, store.taken)
+}
+
+func TestLimiter_StoreUnavailable(t *testing.T) {
+	l, store := setupLimiter(map[string]Limit{"CommandService.PollCommands": {Rate: 1, Burst: 1}})
+
+	for _, err := range []error{redis.ErrCacheUnavailable, fmt.Errorf("NOSCRIPT")} {
+		store.err = err
+		handled, err := call(l, context.Background(), pb.CommandService_PollCommands_FullMethodName, &pb.PollRequest{RouterId: "r-1"})
+		require.NoError(t, err)
+		assert.True(t, handled)
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	limits, err := ParseLimits(" CommandService.PollCommands=30/m:5, CommandService.SendCommand=2/s,, RoleService.PutRole=1.5/h")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Limit{
+		"CommandService.PollCommands": {Rate: 0.5, Burst: 5},
+		"CommandService.SendCommand":  {Rate: 2, Burst: 2},
+		"RoleService.PutRole":         {Rate: 1.5 / 3600, Burst: 2},
+	}, limits)
+
+	limits, err = ParseLimits("")
+	require.NoError(t, err)
+	assert.Empty(t, limits)
+
+	for _, spec := range []string{
+		"PollCommands=1/s",
+		"CommandService.PollCommands",
+		"CommandService.PollCommands=1",
+		"CommandService.PollCommands=0/s",
+		"CommandService.PollCommands=1/d",
+		"CommandService.PollCommands=1/s:0",
+		"CommandService.PollCommands=1/s,CommandService.PollCommands=2/s",
+	} {
+		_, err := ParseLimits(spec)
+		assert.Error(t, err, spec)
+	}
+}
@@ -0,0 +1,510 @@
+// Package contract holds the behaviour every command/router store has to
+// follow. Backends run it from their own integration tests:
+//
+//	contract.RunRepositoryContract(t, func(t *testing.T) contract.Repository { ... })
+package contract
+
+import (
+	"context"
+	"fmt"
+	"router-manager/internal/model"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Repository is the method set shared by the PostgreSQL and Redis stores.
+type Repository interface {
+	SaveRouter(ctx context.Context, router *model.Router) error
+	FindRouterByRouterId(ctx context.Context, id string) (*model.Router, error)
+	SaveCommand(ctx context.Context, command *model.Command) error
+	SaveCommandCoalesced(ctx context.Context, command *model.Command, policy string) (*model.CoalesceResult, error)
+	FindCommandsByRouterId(ctx context.Context, routerId uuid.UUID) ([]model.Command, error)
+	FindCommandsByStatus(ctx context.Context, routerId uuid.UUID, status string, limit int) ([]model.Command, error)
+	ChangeStatusByRouterId(ctx context.Context, routerId uuid.UUID, status string) error
+	ChangeStatusByIds(ctx context.Context, routerId uuid.UUID, ids []uuid.UUID, status string) error
+}
+
+// Factory returns a ready to use repository. Subtests only use fresh ids, so
+// the factory may hand out repositories over the same database.
+type Factory func(t *testing.T) Repository
+
+func RunRepositoryContract(t *testing.T, newRepo Factory) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, repo Repository)
+	}{
+		{"SaveRouter_RoundTrip", testSaveRouterRoundTrip},
+		{"SaveRouter_KeepsIdOfKnownSerial", testSaveRouterKeepsIdOfKnownSerial},
+		{"FindRouter_Missing", testFindRouterMissing},
+		{"FindCommands_Missing", testFindCommandsMissing},
+		{"FindCommands_OrderedByCreation", testFindCommandsOrdered},
+		{"FindByStatus_DeliveryOrder", testFindByStatusDeliveryOrder},
+		{"FindByStatus_Limit", testFindByStatusLimit},
+		{"FindByStatus_Cancelling", testFindByStatusCancelling},
+		{"ChangeStatus_PendingToSent", testChangeStatusPendingToSent},
+		{"ChangeStatus_SentToAcked", testChangeStatusSentToAcked},
+		{"ChangeStatus_SkipsOtherStatuses", testChangeStatusSkipsOtherStatuses},
+		{"ChangeStatus_CancellingToCancelled", testChangeStatusCancellingToCancelled},
+		{"ChangeStatus_UnknownRouter", testChangeStatusUnknownRouter},
+		{"ChangeStatus_UnsupportedStatus", testChangeStatusUnsupported},
+		{"ChangeStatusByIds_OnlyGivenIds", testChangeStatusByIdsOnlyGiven},
+		{"Concurrent_SaveAndChangeStatus", testConcurrentSaveAndChangeStatus},
+		{"Coalesce_KeepAll", testCoalesceKeepAll},
+		{"Coalesce_DropIdentical", testCoalesceDropIdentical},
+		{"Coalesce_DropKeepsOtherPayload", testCoalesceDropKeepsOtherPayload},
+		{"Coalesce_ReplacePending", testCoalesceReplacePending},
+		{"Coalesce_ConcurrentDrop", testCoalesceConcurrentDrop},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newRepo(t))
+		})
+	}
+}
+
+/* --- helpers --- */
+
+func newRouter(t *testing.T, repo Repository) *model.Router {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	router := &model.Router{
+		ID:           uuid.New(),
+		SerialNumber: "SN-" + uuid.NewString(),
+		LastSeenAt:   &now,
+		CreatedAt:    now,
+	}
+	require.NoError(t, repo.SaveRouter(context.Background(), router))
+	return router
+}
+
+func newCommand(t *testing.T, repo Repository, routerId uuid.UUID, status string, createdAt time.Time) *model.Command {
+	command := &model.Command{
+		ID:          uuid.New(),
+		RouterID:    routerId,
+		CommandType: "REBOOT",
+		Payload:     []byte(`{"command": "REBOOT"}`),
+		Status:      status,
+		CreatedAt:   createdAt.UTC().Truncate(time.Microsecond),
+	}
+	require.NoError(t, repo.SaveCommand(context.Background(), command))
+	return command
+}
+
+func coalescedCommand(routerId uuid.UUID, payload string) *model.Command {
+	return &model.Command{
+		ID:          uuid.New(),
+		RouterID:    routerId,
+		CommandType: "REBOOT",
+		Payload:     []byte(payload),
+		Status:      model.StatusPending,
+		CreatedAt:   time.Now().UTC().Truncate(time.Microsecond),
+	}
+}
+
+func newPrioritized(t *testing.T, repo Repository, routerId uuid.UUID, priority int, createdAt time.Time) *model.Command {
+	command := &model.Command{
+		ID:          uuid.New(),
+		RouterID:    routerId,
+		CommandType: "RUN_DIAGNOSTICS",
+		Payload:     []byte(`{"command": "RUN_DIAGNOSTICS"}`),
+		Status:      model.StatusPending,
+		Priority:    priority,
+		CreatedAt:   createdAt.UTC().Truncate(time.Microsecond),
+	}
+	require.NoError(t, repo.SaveCommand(context.Background(), command))
+	return command
+}
+
+func ids(commands []model.Command) []uuid.UUID {
+	result := make([]uuid.UUID, 0, len(commands))
+	for _, command := range commands {
+		result = append(result, command.ID)
+	}
+	return result
+}
+
+func statuses(commands []model.Command) map[uuid.UUID]string {
+	result := make(map[uuid.UUID]string, len(commands))
+	for _, command := range commands {
+		result[command.ID] = command.Status
+	}
+	return result
+}
+
+/* --- routers --- */
+
+func testSaveRouterRoundTrip(t *testing.T, repo Repository) {
+	router := newRouter(t, repo)
+
+	found, err := repo.FindRouterByRouterId(context.Background(), router.ID.String())
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, router.ID, found.ID)
+	assert.Equal(t, router.SerialNumber, found.SerialNumber)
+}
+
+func testSaveRouterKeepsIdOfKnownSerial(t *testing.T, repo Repository) {
+	router := newRouter(t, repo)
+
+	again := &model.Router{
+		ID:           uuid.New(),
+		SerialNumber: router.SerialNumber,
+		CreatedAt:    time.Now().UTC(),
+	}
+	require.NoError(t, repo.SaveRouter(context.Background(), again))
+	assert.Equal(t, router.ID, again.ID)
+}
+
+func testFindRouterMissing(t *testing.T, repo Repository) {
+	found, err := repo.FindRouterByRouterId(context.Background(), uuid.NewString())
+	assert.NoError(t, err)
+	assert.Nil(t, found)
+}
+
+/* --- commands --- */
+
+func testFindCommandsMissing(t *testing.T, repo Repository) {
+	commands, err := repo.FindCommandsByRouterId(context.Background(), uuid.New())
+	assert.NoError(t, err)
+	assert.Empty(t, commands)
+}
+
+func testFindCommandsOrdered(t *testing.T, repo Repository) {
+	router := newRouter(t, repo)
+	now := time.Now()
+
+	// saved out of order on purpose
+	second := newCommand(t, repo, router.ID, model.StatusPending, now.Add(-time.Minute))
+	first := newCommand(t, repo, router.ID, model.StatusPending, now.Add(-time.Hour))
+	third := newCommand(t, repo, router.ID, model.StatusPending, now)
+
+	commands, err := repo.FindCommandsByRouterId(context.Background(), router.ID)
+	require.NoError(t, err)
+	require.Len(t, commands, 3)
+	assert.Equal(t, first.ID, commands[0].ID)
+	assert.Equal(t, second.ID, commands[1].ID)
+	assert.Equal(t, third.ID, commands[2].ID)
+
+	assert.Equal(t, first.RouterID, commands[0].RouterID)
+	assert.Equal(t, first.CommandType, commands[0].CommandType)
+	assert.Equal(t, model.StatusPending, commands[0].Status)
+	assert.JSONEq(t, string(first.Payload), string(commands[0].Payload))
+	assert.WithinDuration(t, first.CreatedAt, commands[0].CreatedAt, time.Millisecond)
+}
+
+func testFindByStatusDeliveryOrder(t *testing.T, repo Repository) {
+	router := newRouter(t, repo)
+	now := time.Now()
+
+	normalOld := newPrioritized(t, repo, router.ID, model.DefaultPriority, now.Add(-time.Hour))
+	low := newPrioritized(t, repo, router.ID, model.MinPriority, now.Add(-2*time.Hour))
+	urgent := newPrioritized(t, repo, router.ID, model.MaxPriority, now)
+	normalNew := newPrioritized(t, repo, router.ID, model.DefaultPriority, now.Add(-time.Minute))
+	newCommand(t, repo, router.ID, model.StatusAcked, now.Add(-3*time.Hour))
+
+	commands, err := repo.FindCommandsByStatus(context.Background(), router.ID, model.StatusPending, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{urgent.ID, normalOld.ID, normalNew.ID, low.ID}, ids(commands))
+	assert.Equal(t, model.MaxPriority, commands[0].Priority)
+}
+
+func testFindByStatusLimit(t *testing.T, repo Repository) {
+	router := newRouter(t, repo)
+	now := time.Now()
+
+	first := newPrioritized(t, repo, router.ID, model.DefaultPriority, now.Add(-time.Hour))
+	newPrioritized(t, repo, router.ID, model.DefaultPriority, now)
+	urgent := newPrioritized(t, repo, router.ID, model.MaxPriority, now)
+
+	commands, err := repo.FindCommandsByStatus(context.Background(), router.ID, model.StatusPending, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{urgent.ID, first.ID}, ids(commands))
+}
+
+func testFindByStatusCancelling(t *testing.T, repo Repository) {
+	router := newRouter(t, repo)
+	cancelling := newCommand(t, repo, router.ID, model.StatusCancelling, time.Now())
+	newCommand(t, repo, router.ID, model.StatusPending, time.Now())
+
+	commands, err := repo.FindCommandsByStatus(context.Background(), router.ID, model.StatusCancelling, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{cancelling.ID}, ids(commands))
+
+	// a re-saved command leaves the index of its old status
+	require.NoError(t, repo.ChangeStatusByRouterId(context.Background(), router.ID, model.StatusCancelled))
+
+	commands, err = repo.FindCommandsByStatus(context.Background(), router.ID, model.StatusCancelling, 0)
+	require.NoError(t, err)
+	assert.Empty(t, commands)
+}
+
+func testChangeStatusPendingToSent(t *testing.T, repo Repository) {
+	router := newRouter(t, repo)
+	command := newCommand(t, repo, router.ID, model.StatusPending, time.Now())
+
+	require.NoError(t, repo.ChangeStatusByRouterId(context.Background(), router.ID, model.StatusSent))
+
+	commands, err := repo.FindCommandsByRouterId(context.Background(), router.ID)
+	require.NoError(t, err)
+	require.Len(t, commands, 1)
+	assert.Equal(t, command.ID, commands[0].ID)
+	assert.Equal(t, model.StatusSent, commands[0].Status)
+	assert.NotNil(t, commands[0].SentAt)
+	assert.Nil(t, commands[0].AckedAt)
+}
+
+func testChangeStatusSentToAcked(t *testing.T, repo Repository) {
+	router := newRouter(t, repo)
+	newCommand(t, repo, router.ID, model.StatusPending, time.Now())
+
+	require.NoError(t, repo.ChangeStatusByRouterId(context.Background(), router.ID, model.StatusSent))
+	require.NoError(t, repo.ChangeStatusByRouterId(context.Background(), router.ID, model.StatusAcked))
+
+	commands, err := repo.FindCommandsByRouterId(context.Background(), router.ID)
+	require.NoError(t, err)
+	require.Len(t, commands, 1)
+	assert.Equal(t, model.StatusAcked, commands[0].Status)
+	assert.NotNil(t, commands[0].SentAt)
+	assert.NotNil(t, commands[0].AckedAt)
+}
+
+func testChangeStatusSkipsOtherStatuses(t *testing.T, repo Repository) {
+	router := newRouter(t, repo)
+	now := time.Now()
+	pending := newCommand(t, repo, router.ID, model.StatusPending, now.Add(-time.Minute))
+	acked := newCommand(t, repo, router.ID, model.StatusAcked, now.Add(-time.Hour))
+
+	// only the PENDING command is sent, the ACKED one is not touched
+	require.NoError(t, repo.ChangeStatusByRouterId(context.Background(), router.ID, model.StatusSent))
+
+	commands, err := repo.FindCommandsByRouterId(context.Background(), router.ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[uuid.UUID]string{
+		pending.ID: model.StatusSent,
+		acked.ID:   model.StatusAcked,
+	}, statuses(commands))
+
+	// a PENDING command is never acked before it was sent
+	fresh := newCommand(t, repo, router.ID, model.StatusPending, now)
+	require.NoError(t, repo.ChangeStatusByRouterId(context.Background(), router.ID, model.StatusAcked))
+
+	commands, err = repo.FindCommandsByRouterId(context.Background(), router.ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[uuid.UUID]string{
+		pending.ID: model.StatusAcked,
+		acked.ID:   model.StatusAcked,
+		fresh.ID:   model.StatusPending,
+	}, statuses(commands))
+}
+
+func testChangeStatusCancellingToCancelled(t *testing.T, repo Repository) {
+	router := newRouter(t, repo)
+	now := time.Now()
+	cancelling := newCommand(t, repo, router.ID, model.StatusCancelling, now.Add(-time.Minute))
+	pending := newCommand(t, repo, router.ID, model.StatusPending, now)
+
+	require.NoError(t, repo.ChangeStatusByRouterId(context.Background(), router.ID, model.StatusCancelled))
+
+	commands, err := repo.FindCommandsByRouterId(context.Background(), router.ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[uuid.UUID]string{
+		cancelling.ID: model.StatusCancelled,
+		pending.ID:    model.StatusPending,
+	}, statuses(commands))
+}
+
+func testChangeStatusUnknownRouter(t *testing.T, repo Repository) {
+	err := repo.ChangeStatusByRouterId(context.Background(), uuid.New(), model.StatusSent)
+	assert.NoError(t, err)
+}
+
+func testChangeStatusUnsupported(t *testing.T, repo Repository) {
+	router := newRouter(t, repo)
+	newCommand(t, repo, router.ID, model.StatusPending, time.Now())
+
+	err := repo.ChangeStatusByRouterId(context.Background(), router.ID, model.StatusPending)
+	assert.Error(t, err)
+}
+
+func testChangeStatusByIdsOnlyGiven(t *testing.T, repo Repository) {
+	router := newRouter(t, repo)
+	now := time.Now()
+	delivered := newCommand(t, repo, router.ID, model.StatusPending, now.Add(-time.Minute))
+	left := newCommand(t, repo, router.ID, model.StatusPending, now)
+
+	err := repo.ChangeStatusByIds(context.Background(), router.ID, []uuid.UUID{delivered.ID}, model.StatusSent)
+	require.NoError(t, err)
+
+	commands, err := repo.FindCommandsByRouterId(context.Background(), router.ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[uuid.UUID]string{
+		delivered.ID: model.StatusSent,
+		left.ID:      model.StatusPending,
+	}, statuses(commands))
+
+	pending, err := repo.FindCommandsByStatus(context.Background(), router.ID, model.StatusPending, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{left.ID}, ids(pending))
+}
+
+func testConcurrentSaveAndChangeStatus(t *testing.T, repo Repository) {
+	const workers = 20
+	router := newRouter(t, repo)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			command := &model.Command{
+				ID:          uuid.New(),
+				RouterID:    router.ID,
+				CommandType: fmt.Sprintf("CMD_%d", i),
+				Status:      model.StatusPending,
+				CreatedAt:   time.Now().UTC(),
+			}
+			errs <- repo.SaveCommand(context.Background(), command)
+		}(i)
+		go func() {
+			defer wg.Done()
+			errs <- repo.ChangeStatusByRouterId(context.Background(), router.ID, model.StatusSent)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	// no command is lost, whatever the interleaving was
+	require.NoError(t, repo.ChangeStatusByRouterId(context.Background(), router.ID, model.StatusSent))
+
+	commands, err := repo.FindCommandsByRouterId(context.Background(), router.ID)
+	require.NoError(t, err)
+	require.Len(t, commands, workers)
+	for _, command := range commands {
+		assert.Equal(t, model.StatusSent, command.Status)
+	}
+}
+
+/* --- coalescing --- */
+
+func testCoalesceKeepAll(t *testing.T, repo Repository) {
+	router := newRouter(t, repo)
+	first := newCommand(t, repo, router.ID, model.StatusPending, time.Now())
+
+	command := coalescedCommand(router.ID, string(first.Payload))
+	result, err := repo.SaveCommandCoalesced(context.Background(), command, model.CoalesceKeepAll)
+	require.NoError(t, err)
+	assert.Nil(t, result.ExistingID)
+	assert.Empty(t, result.Replaced)
+
+	commands, err := repo.FindCommandsByRouterId(context.Background(), router.ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[uuid.UUID]string{
+		first.ID:   model.StatusPending,
+		command.ID: model.StatusPending,
+	}, statuses(commands))
+}
+
+func testCoalesceDropIdentical(t *testing.T, repo Repository) {
+	router := newRouter(t, repo)
+	first := newCommand(t, repo, router.ID, model.StatusPending, time.Now())
+
+	// same payload, different formatting
+	command := coalescedCommand(router.ID, `{"command":"REBOOT"}`)
+	result, err := repo.SaveCommandCoalesced(context.Background(), command, model.CoalesceDropIdentical)
+	require.NoError(t, err)
+	require.NotNil(t, result.ExistingID)
+	assert.Equal(t, first.ID, *result.ExistingID)
+
+	commands, err := repo.FindCommandsByRouterId(context.Background(), router.ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[uuid.UUID]string{first.ID: model.StatusPending}, statuses(commands))
+}
+
+func testCoalesceDropKeepsOtherPayload(t *testing.T, repo Repository) {
+	router := newRouter(t, repo)
+	first := newCommand(t, repo, router.ID, model.StatusPending, time.Now())
+	sent := newCommand(t, repo, router.ID, model.StatusSent, time.Now())
+
+	// the payload differs from the PENDING one and the identical one was already sent
+	command := coalescedCommand(router.ID, `{"command": "REBOOT", "delay": 10}`)
+	result, err := repo.SaveCommandCoalesced(context.Background(), command, model.CoalesceDropIdentical)
+	require.NoError(t, err)
+	assert.Nil(t, result.ExistingID)
+
+	commands, err := repo.FindCommandsByRouterId(context.Background(), router.ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[uuid.UUID]string{
+		first.ID:   model.StatusPending,
+		sent.ID:    model.StatusSent,
+		command.ID: model.StatusPending,
+	}, statuses(commands))
+}
+
+func testCoalesceReplacePending(t *testing.T, repo Repository) {
+	router := newRouter(t, repo)
+	now := time.Now()
+	sent := newCommand(t, repo, router.ID, model.StatusSent, now.Add(-time.Hour))
+	first := newCommand(t, repo, router.ID, model.StatusPending, now.Add(-time.Minute))
+	second := newCommand(t, repo, router.ID, model.StatusPending, now.Add(-time.Second))
+
+	command := coalescedCommand(router.ID, `{"command": "REBOOT", "delay": 10}`)
+	result, err := repo.SaveCommandCoalesced(context.Background(), command, model.CoalesceReplacePending)
+	require.NoError(t, err)
+	assert.Nil(t, result.ExistingID)
+	require.Len(t, result.Replaced, 2)
+	assert.ElementsMatch(t, []uuid.UUID{first.ID, second.ID}, []uuid.UUID{result.Replaced[0].ID, result.Replaced[1].ID})
+
+	commands, err := repo.FindCommandsByRouterId(context.Background(), router.ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[uuid.UUID]string{
+		sent.ID:    model.StatusSent,
+		first.ID:   model.StatusCancelled,
+		second.ID:  model.StatusCancelled,
+		command.ID: model.StatusPending,
+	}, statuses(commands))
+
+	for _, found := range commands {
+		if found.ID == first.ID {
+			assert.Equal(t, model.SystemActor, found.CancelledBy)
+			assert.Equal(t, model.SupersededReason(command.ID), found.CancelReason)
+			assert.NotNil(t, found.CancelledAt)
+		}
+	}
+}
+
+func testCoalesceConcurrentDrop(t *testing.T, repo Repository) {
+	const workers = 20
+	router := newRouter(t, repo)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			command := coalescedCommand(router.ID, `{"command": "REBOOT"}`)
+			_, err := repo.SaveCommandCoalesced(context.Background(), command, model.CoalesceDropIdentical)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	commands, err := repo.FindCommandsByRouterId(context.Background(), router.ID)
+	require.NoError(t, err)
+	assert.Len(t, commands, 1)
+}
@@ -2,10 +2,17 @@ package postgres
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"router-manager/internal/model"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	_ "github.com/golang-migrate/migrate/v4/source/file"
@@ -14,58 +21,486 @@ import (
 
 type PostgresRepo interface {
 	SaveCommand(ctx context.Context, cmd *model.Command) error
+	SaveCommandCoalesced(ctx context.Context, cmd *model.Command, policy string) (*model.CoalesceResult, error)
+	SaveCommandsCoalesced(ctx context.Context, cmds []model.Command, policy string) ([]*model.CoalesceResult, error)
+	GetPendingCommands(ctx context.Context, cmds []model.Command) ([]model.Command, error)
 	GetCommandsByRouterId(ctx context.Context, routerId uuid.UUID) ([]model.Command, error)
-	ChangeStatusByRouterId(ctx context.Context, routerId uuid.UUID, status string) error
+	GetCommandsByStatus(ctx context.Context, statuses []string) ([]model.Command, error)
+	GetCommandsByRouterIdAndStatus(ctx context.Context, routerId uuid.UUID, status string, limit int) ([]model.Command, error)
+	CancelCommands(ctx context.Context, filter model.CancelFilter, cancellation model.Cancellation) ([]model.Command, error)
+	GetCommandById(ctx context.Context, id uuid.UUID) (*model.Command, error)
+	ListCommands(ctx context.Context, filter model.CommandFilter, after *model.Cursor, limit int) ([]model.Command, error)
+	ChangeStatusByRouterId(ctx context.Context, routerId uuid.UUID, status string) ([]model.Command, error)
+	ChangeStatusByIds(ctx context.Context, routerId uuid.UUID, ids []uuid.UUID, status string) ([]model.Command, error)
+	MarkCommandsSent(ctx context.Context, routerId uuid.UUID, cmds []model.Command) ([]model.Command, error)
+	CountCommandsByStatus(ctx context.Context) (map[string]int64, error)
+	FailCommand(ctx context.Context, routerId, commandId uuid.UUID, reason string) (bool, error)
+	SaveWorkflow(ctx context.Context, workflow *model.Workflow) error
+	GetWorkflow(ctx context.Context, id uuid.UUID) (*model.Workflow, error)
+	ResolveWorkflows(ctx context.Context, routerId uuid.UUID, cancellation model.Cancellation) (released, cancelled []model.Command, err error)
 	SaveRouter(ctx context.Context, router *model.Router) error
+	SaveRouters(ctx context.Context, routers []model.Router) error
 	FindRouterByRouterId(ctx context.Context, id string) (*model.Router, error)
+	FindRoutersBySelector(ctx context.Context, selector model.RouterSelector) ([]model.Router, error)
+	CountRouters(ctx context.Context, seenSince time.Time) (online, offline int64, err error)
+	SaveRouterCredential(ctx context.Context, credential *model.RouterCredential) (bool, error)
+	GetRouterCredentialByHash(ctx context.Context, hash string) (*model.RouterCredential, error)
+	RevokeRouterCredential(ctx context.Context, routerId uuid.UUID) (bool, error)
+	SaveCampaign(ctx context.Context, campaign *model.Campaign, targets []model.CampaignTarget) error
+	GetCampaign(ctx context.Context, id uuid.UUID) (*model.Campaign, error)
+	GetCampaignsByStatus(ctx context.Context, status string) ([]model.Campaign, error)
+	TransitionCampaign(ctx context.Context, id uuid.UUID, from []string, status, reason string) (*model.Campaign, error)
+	GetCampaignTargets(ctx context.Context, campaignId uuid.UUID, wave int) ([]model.CampaignTarget, error)
+	ReleaseCampaignWave(ctx context.Context, campaignId uuid.UUID, wave int, nextWaveAt *time.Time, commands []model.Command) (bool, error)
+	GetCampaignProgress(ctx context.Context, campaignId uuid.UUID) ([]model.WaveProgress, error)
+	ReserveIdempotencyKey(ctx context.Context, key *model.IdempotencyKey, expiredBefore time.Time) (*model.IdempotencyKey, error)
+	CompleteIdempotencyKey(ctx context.Context, key *model.IdempotencyKey) error
+	ReleaseIdempotencyKey(ctx context.Context, key *model.IdempotencyKey) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context, expiredBefore time.Time) (int64, error)
+	SaveJob(ctx context.Context, job *model.Job) error
+	GetJob(ctx context.Context, id uuid.UUID) (*model.Job, error)
+	ListJobs(ctx context.Context, filter model.JobFilter, after *model.Cursor, limit int) ([]model.Job, error)
+	CancelJob(ctx context.Context, id uuid.UUID) (*model.Job, error)
+	ClaimJob(ctx context.Context, owner string, lease time.Duration) (*model.Job, error)
+	SaveJobProgress(ctx context.Context, job *model.Job, lease time.Duration) (string, error)
+	ReleaseJob(ctx context.Context, job *model.Job) error
+	FinishJob(ctx context.Context, job *model.Job) error
+	SaveApproval(ctx context.Context, approval *model.Approval) error
+	GetApproval(ctx context.Context, id uuid.UUID) (*model.Approval, error)
+	DecideApproval(ctx context.Context, id uuid.UUID, decision model.ApprovalDecision) (*model.Approval, []model.Command, error)
+	ExpireApprovals(ctx context.Context, now time.Time) (expired []uuid.UUID, cancelled []model.Command, err error)
+	SaveApiKey(ctx context.Context, key *model.ApiKey) error
+	GetApiKeyByHash(ctx context.Context, hash string) (*model.ApiKey, error)
+	ListApiKeys(ctx context.Context, includeRevoked bool) ([]model.ApiKey, error)
+	RevokeApiKey(ctx context.Context, id uuid.UUID, by string, at time.Time) (*model.ApiKey, error)
+	ListRoles(ctx context.Context) ([]model.Role, error)
+	SaveRole(ctx context.Context, role *model.Role) error
+	DeleteRole(ctx context.Context, name string) (bool, error)
+	AppendAuditEvents(ctx context.Context, events []model.AuditEvent) error
+	ListAuditEvents(ctx context.Context, filter model.AuditFilter, beforeSeq int64, limit int) ([]model.AuditEvent, error)
+	ExportAuditEvents(ctx context.Context, filter model.AuditFilter, afterSeq int64, limit int) ([]model.AuditEvent, error)
 }
 
+// columns read by scanCommands, in order
+const commandColumns = `id, router_id, command_type, payload,
+			status, priority, sent_at, acked_at, created_at,
+			cancelled_at, COALESCE(cancelled_by, ''), COALESCE(cancel_reason, ''),
+			COALESCE(error, ''), workflow_id, COALESCE(workflow_step, ''), campaign_id, approval_id,
+			COALESCE(signature_key_id, ''), signature, not_before, expires_at`
+
 type PostgresRepository struct {
 	pool *pgxpool.Pool
+	log  *slog.Logger
+}
+
+// Option configures optional PostgresRepository settings.
+type Option func(r *PostgresRepository)
+
+// WithLogger sets the logger of the repository, slog's default one otherwise.
+func WithLogger(logger *slog.Logger) Option {
+	return func(r *PostgresRepository) {
+		r.log = logger
+	}
 }
 
 /* --- work with commmands table --- */
 
-func NewPostgresRepository(pool *pgxpool.Pool) PostgresRepo {
-	return &PostgresRepository{pool: pool}
+func NewPostgresRepository(pool *pgxpool.Pool, opts ...Option) PostgresRepo {
+	r := &PostgresRepository{pool: pool, log: slog.Default()}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
 func (r *PostgresRepository) SaveCommand(ctx context.Context, cmd *model.Command) error {
-	_, err := r.pool.Exec(ctx,
+	return saveCommand(ctx, r.pool, cmd)
+}
+
+// execer is what saveCommand needs from a pool or a transaction.
+type execer interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+}
+
+func saveCommand(ctx context.Context, db execer, cmd *model.Command) error {
+	_, err := db.Exec(ctx,
 		`INSERT INTO commands (
-			id, router_id, command_type, payload, status, sent_at, acked_at, created_at
+			id, router_id, command_type, payload, status, priority, sent_at, acked_at, created_at,
+			cancelled_at, cancelled_by, cancel_reason, error, workflow_id, workflow_step, campaign_id, approval_id,
+			signature_key_id, signature, not_before, expires_at
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11::text, ''), NULLIF($12::text, ''),
+			NULLIF($13::text, ''), $14, NULLIF($15::text, ''), $16, $17,
+			NULLIF($18::text, ''), $19, $20, $21
 		)
 		ON CONFLICT (id) DO UPDATE SET
 			status = EXCLUDED.status,
 			acked_at = EXCLUDED.acked_at,
-			sent_at = COALESCE(EXCLUDED.sent_at, commands.sent_at)`,
+			sent_at = COALESCE(EXCLUDED.sent_at, commands.sent_at),
+			cancelled_at = EXCLUDED.cancelled_at,
+			cancelled_by = EXCLUDED.cancelled_by,
+			cancel_reason = EXCLUDED.cancel_reason,
+			error = EXCLUDED.error`,
 		cmd.ID,
 		cmd.RouterID,
 		cmd.CommandType,
 		cmd.Payload,
 		cmd.Status,
+		cmd.Priority,
 		cmd.SentAt,
 		cmd.AckedAt,
 		cmd.CreatedAt,
+		cmd.CancelledAt,
+		cmd.CancelledBy,
+		cmd.CancelReason,
+		cmd.Error,
+		cmd.WorkflowID,
+		cmd.WorkflowStep,
+		cmd.CampaignID,
+		cmd.ApprovalID,
+		cmd.SignatureKeyID,
+		cmd.Signature,
+		cmd.NotBefore,
+		cmd.ExpiresAt,
 	)
 	return err
 }
 
+// SaveCommandCoalesced saves the command unless the coalescing policy drops
+// it; replaced commands are cancelled in the same transaction. The router row
+// is locked, so concurrent sends to one router are coalesced one by one.
+func (r *PostgresRepository) SaveCommandCoalesced(ctx context.Context, cmd *model.Command, policy string) (*model.CoalesceResult, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM routers WHERE id = $1 FOR UPDATE`, cmd.RouterID); err != nil {
+		return nil, fmt.Errorf("failed to lock router: %w", err)
+	}
+
+	rows, err := tx.Query(ctx,
+		`SELECT `+commandColumns+`
+		FROM commands
+		WHERE router_id = $1 AND command_type = $2 AND status = 'PENDING'
+		ORDER BY created_at ASC`,
+		cmd.RouterID, cmd.CommandType)
+	if err != nil {
+		return nil, err
+	}
+
+	pending, err := scanCommands(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := model.Coalesce(policy, cmd, pending, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if result.ExistingID != nil {
+		return result, tx.Commit(ctx)
+	}
+
+	for i := range result.Replaced {
+		if err := saveCommand(ctx, tx, &result.Replaced[i]); err != nil {
+			return nil, fmt.Errorf("failed to cancel replaced command: %w", err)
+		}
+	}
+	if err := saveCommand(ctx, tx, cmd); err != nil {
+		return nil, err
+	}
+
+	return result, tx.Commit(ctx)
+}
+
+// commandCopyColumns are the columns written by SaveCommandsCoalesced, in
+// the order of copyRow.
+var commandCopyColumns = []string{
+	"id", "router_id", "command_type", "payload", "status", "priority", "sent_at", "acked_at", "created_at",
+	"cancelled_at", "cancelled_by", "cancel_reason", "error", "workflow_id", "workflow_step", "campaign_id",
+	"approval_id", "signature_key_id", "signature", "not_before", "expires_at",
+}
+
+func copyRow(cmd *model.Command) []any {
+	return []any{
+		cmd.ID, cmd.RouterID, cmd.CommandType, cmd.Payload, cmd.Status, cmd.Priority, cmd.SentAt, cmd.AckedAt,
+		cmd.CreatedAt, cmd.CancelledAt, nullIfEmpty(cmd.CancelledBy), nullIfEmpty(cmd.CancelReason),
+		nullIfEmpty(cmd.Error), cmd.WorkflowID, nullIfEmpty(cmd.WorkflowStep), cmd.CampaignID,
+		cmd.ApprovalID, nullIfEmpty(cmd.SignatureKeyID), cmd.Signature, cmd.NotBefore, cmd.ExpiresAt,
+	}
+}
+
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// SaveCommandsCoalesced is SaveCommandCoalesced for many commands in one
+// transaction; new commands are written with COPY. The results are in the
+// order of cmds. Commands for the same router are coalesced with each other
+// as if they were sent one after another. Commands whose id exists already
+// were saved by an earlier attempt of the send and are left alone.
+func (r *PostgresRepository) SaveCommandsCoalesced(ctx context.Context, cmds []model.Command, policy string) ([]*model.CoalesceResult, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	routerIds, commandTypes := commandQueues(cmds)
+
+	// locked in id order, so that concurrent batches can't deadlock
+	if _, err := tx.Exec(ctx,
+		`SELECT 1 FROM routers WHERE id = ANY($1) ORDER BY id FOR UPDATE`,
+		routerIds); err != nil {
+		return nil, fmt.Errorf("failed to lock routers: %w", err)
+	}
+
+	saved, err := findSaved(ctx, tx, cmds)
+	if err != nil {
+		return nil, err
+	}
+	var fresh []model.Command
+	for _, cmd := range cmds {
+		if !saved[cmd.ID] {
+			fresh = append(fresh, cmd)
+		}
+	}
+
+	var pending []model.Command
+	if policy != model.CoalesceKeepAll {
+		pending, err = findPending(ctx, tx, routerIds, commandTypes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	freshResults, inserted, replaced, err := model.CoalesceBatch(policy, fresh, pending, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*model.CoalesceResult, 0, len(cmds))
+	for _, cmd := range cmds {
+		if saved[cmd.ID] {
+			results = append(results, &model.CoalesceResult{AlreadySaved: true})
+			continue
+		}
+		results = append(results, freshResults[0])
+		freshResults = freshResults[1:]
+	}
+
+	for i := range replaced {
+		if err := saveCommand(ctx, tx, &replaced[i]); err != nil {
+			return nil, fmt.Errorf("failed to cancel replaced command: %w", err)
+		}
+	}
+
+	_, err = tx.CopyFrom(ctx,
+		pgx.Identifier{"commands"},
+		commandCopyColumns,
+		pgx.CopyFromSlice(len(inserted), func(i int) ([]any, error) {
+			return copyRow(&inserted[i]), nil
+		}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save commands: %w", err)
+	}
+
+	return results, tx.Commit(ctx)
+}
+
+// commandQueues returns the routers and command types of the commands.
+func commandQueues(cmds []model.Command) ([]uuid.UUID, []string) {
+	var routerIds []uuid.UUID
+	var commandTypes []string
+	for _, cmd := range cmds {
+		routerIds = append(routerIds, cmd.RouterID)
+		commandTypes = append(commandTypes, cmd.CommandType)
+	}
+	return routerIds, commandTypes
+}
+
+// findSaved returns the ids of the commands that exist already.
+func findSaved(ctx context.Context, db querier, cmds []model.Command) (map[uuid.UUID]bool, error) {
+	ids := make([]uuid.UUID, 0, len(cmds))
+	for _, cmd := range cmds {
+		ids = append(ids, cmd.ID)
+	}
+
+	rows, err := db.Query(ctx, `SELECT id FROM commands WHERE id = ANY($1)`, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find saved commands: %w", err)
+	}
+	defer rows.Close()
+
+	saved := make(map[uuid.UUID]bool)
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		saved[id] = true
+	}
+	return saved, rows.Err()
+}
+
+// querier is what findPending and findSaved need from a pool or a transaction.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+func findPending(ctx context.Context, db querier, routerIds []uuid.UUID, commandTypes []string) ([]model.Command, error) {
+	rows, err := db.Query(ctx,
+		`SELECT `+commandColumns+`
+		FROM commands
+		WHERE router_id = ANY($1) AND command_type = ANY($2) AND status = 'PENDING'
+		ORDER BY created_at ASC`,
+		routerIds, commandTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanCommands(rows)
+}
+
+// GetPendingCommands returns the PENDING commands the given commands would
+// be coalesced with, oldest first, without locking anything.
+func (r *PostgresRepository) GetPendingCommands(ctx context.Context, cmds []model.Command) ([]model.Command, error) {
+	routerIds, commandTypes := commandQueues(cmds)
+	return findPending(ctx, r.pool, routerIds, commandTypes)
+}
+
 func (r *PostgresRepository) GetCommandsByRouterId(ctx context.Context, routerId uuid.UUID) ([]model.Command, error) {
 	rows, err := r.pool.Query(ctx,
-		`SELECT 
-			id, router_id, command_type, payload, 
-			status, sent_at, acked_at, created_at
-		FROM commands 
-		WHERE router_id = $1 
+		`SELECT `+commandColumns+`
+		FROM commands
+		WHERE router_id = $1
 		ORDER BY created_at ASC`,
 		routerId)
 
 	if err != nil {
 		return nil, err
 	}
+
+	return scanCommands(rows)
+}
+
+func (r *PostgresRepository) GetCommandsByStatus(ctx context.Context, statuses []string) ([]model.Command, error) {
+	rows, err := r.pool.Query(ctx,
+		`SELECT `+commandColumns+`
+		FROM commands
+		WHERE status = ANY($1)
+		ORDER BY created_at ASC`,
+		statuses)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return scanCommands(rows)
+}
+
+// GetCommandsByRouterIdAndStatus returns the router's commands in the status
+// in delivery order: priority first, then creation time. A limit <= 0 returns
+// all of them.
+func (r *PostgresRepository) GetCommandsByRouterIdAndStatus(ctx context.Context, routerId uuid.UUID, status string, limit int) ([]model.Command, error) {
+	rows, err := r.pool.Query(ctx,
+		`SELECT `+commandColumns+`
+		FROM commands
+		WHERE router_id = $1 AND status = $2
+		ORDER BY priority DESC, created_at ASC, id ASC
+		LIMIT NULLIF($3::int, 0)`,
+		routerId, status, max(limit, 0))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return scanCommands(rows)
+}
+
+// GetCommandById returns nil without an error if there is no such command.
+func (r *PostgresRepository) GetCommandById(ctx context.Context, id uuid.UUID) (*model.Command, error) {
+	rows, err := r.pool.Query(ctx,
+		`SELECT `+commandColumns+`
+		FROM commands
+		WHERE id = $1`,
+		id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	commands, err := scanCommands(rows)
+	if err != nil || len(commands) == 0 {
+		return nil, err
+	}
+
+	return &commands[0], nil
+}
+
+// ListCommands returns up to limit commands matching the filter, newest
+// first, starting after the cursor.
+func (r *PostgresRepository) ListCommands(ctx context.Context, filter model.CommandFilter, after *model.Cursor, limit int) ([]model.Command, error) {
+	var conditions []string
+	var args []interface{}
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.RouterID != nil {
+		where("router_id = $%d", *filter.RouterID)
+	}
+	if filter.SerialNumber != "" {
+		where("router_id IN (SELECT id FROM routers WHERE serial_number = $%d)", filter.SerialNumber)
+	}
+	if filter.CommandType != "" {
+		where("command_type = $%d", filter.CommandType)
+	}
+	if len(filter.Statuses) > 0 {
+		where("status = ANY($%d)", filter.Statuses)
+	}
+	for _, bound := range []struct {
+		condition string
+		value     *time.Time
+	}{
+		{"created_at >= $%d", filter.CreatedFrom},
+		{"created_at < $%d", filter.CreatedTo},
+		{"sent_at >= $%d", filter.SentFrom},
+		{"sent_at < $%d", filter.SentTo},
+		{"acked_at >= $%d", filter.AckedFrom},
+		{"acked_at < $%d", filter.AckedTo},
+	} {
+		if bound.value != nil {
+			where(bound.condition, *bound.value)
+		}
+	}
+	if after != nil {
+		args = append(args, after.CreatedAt, after.ID)
+		conditions = append(conditions, fmt.Sprintf("(created_at, id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+
+	query := `SELECT ` + commandColumns + ` FROM commands`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(` ORDER BY created_at DESC, id DESC LIMIT $%d`, len(args))
+
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanCommands(rows)
+}
+
+func scanCommands(rows pgx.Rows) ([]model.Command, error) {
 	defer rows.Close()
 
 	var commands []model.Command
@@ -77,9 +512,22 @@ func (r *PostgresRepository) GetCommandsByRouterId(ctx context.Context, routerId
 			&cmd.CommandType,
 			&cmd.Payload,
 			&cmd.Status,
+			&cmd.Priority,
 			&cmd.SentAt,
 			&cmd.AckedAt,
 			&cmd.CreatedAt,
+			&cmd.CancelledAt,
+			&cmd.CancelledBy,
+			&cmd.CancelReason,
+			&cmd.Error,
+			&cmd.WorkflowID,
+			&cmd.WorkflowStep,
+			&cmd.CampaignID,
+			&cmd.ApprovalID,
+			&cmd.SignatureKeyID,
+			&cmd.Signature,
+			&cmd.NotBefore,
+			&cmd.ExpiresAt,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan command row: %w", err)
@@ -87,67 +535,1622 @@ func (r *PostgresRepository) GetCommandsByRouterId(ctx context.Context, routerId
 		commands = append(commands, cmd)
 	}
 
-	if err = rows.Err(); err != nil {
+	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("row iteration error: %w", err)
 	}
 
 	return commands, nil
 }
 
-func (r *PostgresRepository) ChangeStatusByRouterId(ctx context.Context, routerId uuid.UUID, status string) error {
-	_, err := r.pool.Exec(ctx,
+// ChangeStatusByRouterId moves every command of the router that is in the
+// previous status to the new one; other commands are left as they are.
+func (r *PostgresRepository) ChangeStatusByRouterId(ctx context.Context, routerId uuid.UUID, status string) ([]model.Command, error) {
+	return r.changeStatus(ctx, routerId, nil, status)
+}
+
+// ChangeStatusByIds is ChangeStatusByRouterId limited to the given commands.
+func (r *PostgresRepository) ChangeStatusByIds(ctx context.Context, routerId uuid.UUID, ids []uuid.UUID, status string) ([]model.Command, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	return r.changeStatus(ctx, routerId, ids, status)
+}
+
+// MarkCommandsSent moves the PENDING commands among cmds to SENT along with
+// the signatures they are delivered with, and returns the updated commands.
+func (r *PostgresRepository) MarkCommandsSent(ctx context.Context, routerId uuid.UUID, cmds []model.Command) ([]model.Command, error) {
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]uuid.UUID, len(cmds))
+	keyIds := make([]string, len(cmds))
+	signatures := make([][]byte, len(cmds))
+	notBefore := make([]*time.Time, len(cmds))
+	expiresAt := make([]*time.Time, len(cmds))
+	for i, cmd := range cmds {
+		ids[i], keyIds[i], signatures[i] = cmd.ID, cmd.SignatureKeyID, cmd.Signature
+		notBefore[i], expiresAt[i] = cmd.NotBefore, cmd.ExpiresAt
+	}
+
+	rows, err := r.pool.Query(ctx,
+		`UPDATE commands
+		SET status = 'SENT',
+			sent_at = NOW(),
+			signature_key_id = NULLIF(delivered.key_id, ''),
+			signature = delivered.sig,
+			not_before = delivered.valid_from,
+			expires_at = delivered.valid_until
+		FROM unnest($2::uuid[], $3::text[], $4::bytea[], $5::timestamp[], $6::timestamp[])
+			AS delivered(command_id, key_id, sig, valid_from, valid_until)
+		WHERE commands.id = delivered.command_id AND router_id = $1 AND status = 'PENDING'
+		RETURNING `+commandColumns,
+		routerId, ids, keyIds, signatures, notBefore, expiresAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return scanCommands(rows)
+}
+
+// changeStatus updates all commands of the router if ids is nil, and
+// returns the updated commands.
+func (r *PostgresRepository) changeStatus(ctx context.Context, routerId uuid.UUID, ids []uuid.UUID, status string) ([]model.Command, error) {
+	previous, ok := model.PreviousStatus(status)
+	if !ok {
+		return nil, fmt.Errorf("unsupported command status: %s", status)
+	}
+
+	rows, err := r.pool.Query(ctx,
 		`UPDATE commands
         SET status = $1,
             sent_at = CASE
-                WHEN $1 = 'SENT' AND status = 'PENDING' THEN NOW()
+                WHEN $1 = 'SENT' THEN NOW()
                 ELSE sent_at
             END,
             acked_at = CASE
-                WHEN $1 = 'ACKED' AND status ='SENT' THEN NOW()
+                WHEN $1 IN ('ACKED', 'FAILED') THEN NOW()
                 ELSE acked_at
             END
-        WHERE router_id = $2`,
-		status, routerId)
+        WHERE router_id = $2 AND status = $3
+            AND ($4::uuid[] IS NULL OR id = ANY($4))
+        RETURNING `+commandColumns,
+		status, routerId, previous, ids)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return scanCommands(rows)
 }
 
-/* --- work with routers table --- */
+// CountCommandsByStatus returns how many commands there are in each
+// status; statuses without commands are left out.
+func (r *PostgresRepository) CountCommandsByStatus(ctx context.Context) (map[string]int64, error) {
+	rows, err := r.pool.Query(ctx,
+		`SELECT status, COUNT(*) FROM commands GROUP BY status`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-func (r *PostgresRepository) SaveRouter(ctx context.Context, router *model.Router) error {
-	_, err := r.pool.Exec(ctx,
-		`INSERT INTO routers (id, serial_number, ip_address, last_seen_at, created_at)
-		VALUES ($1, $2, $3, $4, $5)
-		ON CONFLICT (serial_number) DO UPDATE SET
-			ip_address = EXCLUDED.ip_address,
-			last_seen_at = EXCLUDED.last_seen_at`,
-		router.ID,
-		router.SerialNumber,
-		router.IPAddress,
-		router.LastSeenAt,
-		router.CreatedAt)
-	return err
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var status string
+		var count int64
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+	return counts, rows.Err()
 }
 
-func (r *PostgresRepository) FindRouterByRouterId(ctx context.Context, id string) (*model.Router, error) {
-	var router model.Router
+// CancelCommands cancels every PENDING, BLOCKED, AWAITING_APPROVAL or SENT
+// command matching the filter and returns the updated commands. Those not
+// sent yet become CANCELLED right away, SENT ones become CANCELLING until the router is notified.
+func (r *PostgresRepository) CancelCommands(ctx context.Context, filter model.CancelFilter, cancellation model.Cancellation) ([]model.Command, error) {
+	if filter.Empty() {
+		return nil, fmt.Errorf("cancel filter is empty")
+	}
+
+	rows, err := r.pool.Query(ctx,
+		`UPDATE commands
+		SET status = CASE WHEN status = 'SENT' THEN 'CANCELLING' ELSE 'CANCELLED' END,
+			cancelled_at = $1,
+			cancelled_by = $2,
+			cancel_reason = NULLIF($3::text, '')
+		WHERE status IN ('PENDING', 'BLOCKED', 'AWAITING_APPROVAL', 'SENT')
+			AND ($4::uuid IS NULL OR id = $4)
+			AND ($5::uuid IS NULL OR router_id = $5)
+			AND ($6::text = '' OR command_type = $6)
+			AND ($7::uuid IS NULL OR campaign_id = $7)
+		RETURNING `+commandColumns,
+		cancellation.At,
+		cancellation.By,
+		cancellation.Reason,
+		filter.CommandID,
+		filter.RouterID,
+		filter.CommandType,
+		filter.CampaignID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return scanCommands(rows)
+}
+
+// FailCommand marks a SENT command as FAILED with the error reported by the
+// router. It reports whether the command was updated.
+func (r *PostgresRepository) FailCommand(ctx context.Context, routerId, commandId uuid.UUID, reason string) (bool, error) {
+	tag, err := r.pool.Exec(ctx,
+		`UPDATE commands
+		SET status = 'FAILED', acked_at = NOW(), error = NULLIF($3::text, '')
+		WHERE id = $1 AND router_id = $2 AND status = 'SENT'`,
+		commandId, routerId, reason)
+
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+/* --- work with workflows table --- */
+
+// SaveWorkflow stores the workflow, its commands and their dependencies in
+// one transaction.
+func (r *PostgresRepository) SaveWorkflow(ctx context.Context, workflow *model.Workflow) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO workflows (id, router_id, name, created_at)
+		VALUES ($1, $2, $3, $4)`,
+		workflow.ID, workflow.RouterID, workflow.Name, workflow.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save workflow: %w", err)
+	}
+
+	ids := make(map[string]uuid.UUID, len(workflow.Steps))
+	for i := range workflow.Steps {
+		cmd := &workflow.Steps[i].Command
+		if err := saveCommand(ctx, tx, cmd); err != nil {
+			return fmt.Errorf("failed to save workflow step %s: %w", cmd.WorkflowStep, err)
+		}
+		ids[cmd.WorkflowStep] = cmd.ID
+	}
+
+	for _, step := range workflow.Steps {
+		for _, prerequisite := range step.DependsOn {
+			_, err := tx.Exec(ctx,
+				`INSERT INTO command_dependencies (command_id, depends_on) VALUES ($1, $2)`,
+				step.Command.ID, ids[prerequisite])
+			if err != nil {
+				return fmt.Errorf("failed to save workflow dependency: %w", err)
+			}
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// GetWorkflow returns nil without an error if there is no such workflow.
+func (r *PostgresRepository) GetWorkflow(ctx context.Context, id uuid.UUID) (*model.Workflow, error) {
+	var workflow model.Workflow
 	err := r.pool.QueryRow(ctx,
-		`SELECT id, serial_number, ip_address, last_seen_at, created_at
-		FROM routers
-		WHERE id = $1`,
-		id).Scan(
-		&router.ID,
-		&router.SerialNumber,
-		&router.IPAddress,
-		&router.LastSeenAt,
-		&router.CreatedAt,
-	)
+		`SELECT id, router_id, name, created_at FROM workflows WHERE id = $1`,
+		id).Scan(&workflow.ID, &workflow.RouterID, &workflow.Name, &workflow.CreatedAt)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &router, nil
+
+	rows, err := r.pool.Query(ctx,
+		`SELECT `+commandColumns+`
+		FROM commands
+		WHERE workflow_id = $1
+		ORDER BY created_at ASC, workflow_step ASC`,
+		id)
+	if err != nil {
+		return nil, err
+	}
+
+	commands, err := scanCommands(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[uuid.UUID]string, len(commands))
+	for _, cmd := range commands {
+		keys[cmd.ID] = cmd.WorkflowStep
+	}
+
+	rows, err = r.pool.Query(ctx,
+		`SELECT d.command_id, d.depends_on
+		FROM command_dependencies d
+		JOIN commands c ON c.id = d.command_id
+		WHERE c.workflow_id = $1`,
+		id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dependsOn := make(map[uuid.UUID][]string)
+	for rows.Next() {
+		var commandId, prerequisite uuid.UUID
+		if err := rows.Scan(&commandId, &prerequisite); err != nil {
+			return nil, fmt.Errorf("failed to scan dependency row: %w", err)
+		}
+		dependsOn[commandId] = append(dependsOn[commandId], keys[prerequisite])
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	for _, cmd := range commands {
+		workflow.Steps = append(workflow.Steps, model.WorkflowStep{
+			Command:   cmd,
+			DependsOn: dependsOn[cmd.ID],
+		})
+	}
+
+	return &workflow, nil
+}
+
+// ResolveWorkflows moves the router's BLOCKED commands on: those depending on
+// a failed or cancelled command are cancelled, transitively, and those whose
+// prerequisites are all ACKED become PENDING.
+func (r *PostgresRepository) ResolveWorkflows(ctx context.Context, routerId uuid.UUID, cancellation model.Cancellation) (released, cancelled []model.Command, err error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// every round cancels one more level of dependents
+	for {
+		rows, err := tx.Query(ctx,
+			`UPDATE commands c
+			SET status = 'CANCELLED',
+				cancelled_at = $2,
+				cancelled_by = $3,
+				cancel_reason = NULLIF($4::text, '')
+			WHERE c.router_id = $1 AND c.status = 'BLOCKED'
+				AND EXISTS (
+					SELECT 1 FROM command_dependencies d
+					JOIN commands p ON p.id = d.depends_on
+					WHERE d.command_id = c.id
+						AND p.status IN ('FAILED', 'CANCELLING', 'CANCELLED')
+				)
+			RETURNING `+commandColumns,
+			routerId, cancellation.At, cancellation.By, cancellation.Reason)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to cancel dependent commands: %w", err)
+		}
+
+		commands, err := scanCommands(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(commands) == 0 {
+			break
+		}
+		cancelled = append(cancelled, commands...)
+	}
+
+	rows, err := tx.Query(ctx,
+		`UPDATE commands c
+		SET status = 'PENDING'
+		WHERE c.router_id = $1 AND c.status = 'BLOCKED'
+			AND NOT EXISTS (
+				SELECT 1 FROM command_dependencies d
+				JOIN commands p ON p.id = d.depends_on
+				WHERE d.command_id = c.id AND p.status <> 'ACKED'
+			)
+		RETURNING `+commandColumns,
+		routerId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to release commands: %w", err)
+	}
+
+	released, err = scanCommands(rows)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return released, cancelled, tx.Commit(ctx)
+}
+
+/* --- work with routers table --- */
+
+// SaveRouter upserts the router by serial number: if the serial is already
+// known the router keeps its original id, which is written back to router.ID.
+func (r *PostgresRepository) SaveRouter(ctx context.Context, router *model.Router) error {
+	return r.pool.QueryRow(ctx,
+		`INSERT INTO routers (id, serial_number, ip_address, last_seen_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (serial_number) DO UPDATE SET
+			ip_address = EXCLUDED.ip_address,
+			last_seen_at = EXCLUDED.last_seen_at
+		RETURNING id`,
+		router.ID,
+		router.SerialNumber,
+		router.IPAddress,
+		router.LastSeenAt,
+		router.CreatedAt).Scan(&router.ID)
+}
+
+// rows per INSERT of SaveRouters, well below the limit of 65535 parameters
+const routersPerInsert = 1000
+
+// SaveRouters is SaveRouter for many routers, with multi-row inserts. The
+// ids and decommissioning times are written back to the slice; routers with
+// the same serial number get the same id.
+func (r *PostgresRepository) SaveRouters(ctx context.Context, routers []model.Router) error {
+	// a serial may appear once per INSERT ... ON CONFLICT DO UPDATE
+	var unique []*model.Router
+	seen := make(map[string]bool)
+	for i := range routers {
+		if !seen[routers[i].SerialNumber] {
+			seen[routers[i].SerialNumber] = true
+			unique = append(unique, &routers[i])
+		}
+	}
+
+	saved := make(map[string]model.Router, len(unique))
+	for start := 0; start < len(unique); start += routersPerInsert {
+		chunk := unique[start:min(start+routersPerInsert, len(unique))]
+
+		var values []string
+		var args []any
+		for i, router := range chunk {
+			n := i * 5
+			values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5))
+			args = append(args, router.ID, router.SerialNumber, router.IPAddress, router.LastSeenAt, router.CreatedAt)
+		}
+
+		rows, err := r.pool.Query(ctx,
+			`INSERT INTO routers (id, serial_number, ip_address, last_seen_at, created_at)
+			VALUES `+strings.Join(values, ", ")+`
+			ON CONFLICT (serial_number) DO UPDATE SET
+				ip_address = EXCLUDED.ip_address,
+				last_seen_at = EXCLUDED.last_seen_at
+			RETURNING id, serial_number, decommissioned_at`,
+			args...)
+		if err != nil {
+			return err
+		}
+
+		for rows.Next() {
+			var router model.Router
+			if err := rows.Scan(&router.ID, &router.SerialNumber, &router.DecommissionedAt); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan router row: %w", err)
+			}
+			saved[router.SerialNumber] = router
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to save routers: %w", err)
+		}
+	}
+
+	for i := range routers {
+		router := saved[routers[i].SerialNumber]
+		routers[i].ID = router.ID
+		routers[i].DecommissionedAt = router.DecommissionedAt
+	}
+
+	return nil
+}
+
+// FindRouterByRouterId returns nil without an error if there is no such router.
+func (r *PostgresRepository) FindRouterByRouterId(ctx context.Context, id string) (*model.Router, error) {
+	var router model.Router
+	err := r.pool.QueryRow(ctx,
+		`SELECT id, serial_number, ip_address, last_seen_at, created_at, decommissioned_at
+		FROM routers
+		WHERE id = $1`,
+		id).Scan(
+		&router.ID,
+		&router.SerialNumber,
+		&router.IPAddress,
+		&router.LastSeenAt,
+		&router.CreatedAt,
+		&router.DecommissionedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &router, nil
+}
+
+// CountRouters counts the routers in service: online ones were seen since
+// seenSince, the others are offline.
+func (r *PostgresRepository) CountRouters(ctx context.Context, seenSince time.Time) (online, offline int64, err error) {
+	err = r.pool.QueryRow(ctx,
+		`SELECT
+			COUNT(*) FILTER (WHERE last_seen_at >= $1),
+			COUNT(*) FILTER (WHERE last_seen_at IS NULL OR last_seen_at < $1)
+		FROM routers
+		WHERE decommissioned_at IS NULL`,
+		seenSince).Scan(&online, &offline)
+	return online, offline, err
+}
+
+// FindRoutersBySelector returns the known routers matched by the selector,
+// decommissioned ones included, ordered by serial number.
+func (r *PostgresRepository) FindRoutersBySelector(ctx context.Context, selector model.RouterSelector) ([]model.Router, error) {
+	rows, err := r.pool.Query(ctx,
+		`SELECT id, serial_number, ip_address, last_seen_at, created_at, decommissioned_at
+		FROM routers
+		WHERE ($1::text[] IS NULL OR serial_number = ANY($1))
+			AND ($2::text = '' OR starts_with(serial_number, $2))
+		ORDER BY serial_number`,
+		selector.SerialNumbers, selector.SerialPrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var routers []model.Router
+	for rows.Next() {
+		var router model.Router
+		err := rows.Scan(
+			&router.ID,
+			&router.SerialNumber,
+			&router.IPAddress,
+			&router.LastSeenAt,
+			&router.CreatedAt,
+			&router.DecommissionedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan router row: %w", err)
+		}
+		routers = append(routers, router)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return routers, nil
+}
+
+// SaveRouterCredential replaces the credential of the router, writing its
+// serial number back; it reports whether there is such a router.
+func (r *PostgresRepository) SaveRouterCredential(ctx context.Context, credential *model.RouterCredential) (bool, error) {
+	err := r.pool.QueryRow(ctx,
+		`UPDATE routers
+		SET credential_hash = $2, credential_issued_by = NULLIF($3, ''), credential_issued_at = $4
+		WHERE id = $1
+		RETURNING serial_number`,
+		credential.RouterID,
+		credential.SecretHash,
+		credential.IssuedBy,
+		credential.IssuedAt).Scan(&credential.SerialNumber)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetRouterCredentialByHash returns the credential with the hash, nil if no
+// router has it.
+func (r *PostgresRepository) GetRouterCredentialByHash(ctx context.Context, hash string) (*model.RouterCredential, error) {
+	var credential model.RouterCredential
+	err := r.pool.QueryRow(ctx,
+		`SELECT id, serial_number, credential_hash, COALESCE(credential_issued_by, ''), credential_issued_at
+		FROM routers
+		WHERE credential_hash = $1`,
+		hash).Scan(
+		&credential.RouterID,
+		&credential.SerialNumber,
+		&credential.SecretHash,
+		&credential.IssuedBy,
+		&credential.IssuedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// RevokeRouterCredential removes the credential of the router; it reports
+// whether the router had one.
+func (r *PostgresRepository) RevokeRouterCredential(ctx context.Context, routerId uuid.UUID) (bool, error) {
+	tag, err := r.pool.Exec(ctx,
+		`UPDATE routers
+		SET credential_hash = NULL, credential_issued_by = NULL, credential_issued_at = NULL
+		WHERE id = $1 AND credential_hash IS NOT NULL`,
+		routerId)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+/* --- work with campaigns table --- */
+
+// columns read by scanCampaign, in order
+const campaignColumns = `id, name, command_type, payload, priority, status, COALESCE(pause_reason, ''),
+			total_waves, current_wave, checked_from_wave, soak_seconds, max_failure_rate,
+			next_wave_at, COALESCE(created_by, ''), created_at, updated_at, approval_id`
+
+func scanCampaign(row pgx.Row) (*model.Campaign, error) {
+	var campaign model.Campaign
+	var soakSeconds int64
+	err := row.Scan(
+		&campaign.ID,
+		&campaign.Name,
+		&campaign.CommandType,
+		&campaign.Payload,
+		&campaign.Priority,
+		&campaign.Status,
+		&campaign.PauseReason,
+		&campaign.TotalWaves,
+		&campaign.CurrentWave,
+		&campaign.CheckedFromWave,
+		&soakSeconds,
+		&campaign.MaxFailureRate,
+		&campaign.NextWaveAt,
+		&campaign.CreatedBy,
+		&campaign.CreatedAt,
+		&campaign.UpdatedAt,
+		&campaign.ApprovalID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	campaign.SoakTime = time.Duration(soakSeconds) * time.Second
+	return &campaign, nil
+}
+
+// SaveCampaign stores a new campaign with its targets in one transaction.
+func (r *PostgresRepository) SaveCampaign(ctx context.Context, campaign *model.Campaign, targets []model.CampaignTarget) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO campaigns (
+			id, name, command_type, priority, status, total_waves, current_wave, checked_from_wave,
+			soak_seconds, max_failure_rate, next_wave_at, created_by, created_at, updated_at, payload, approval_id
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NULLIF($12::text, ''), $13, $14, $15, $16)`,
+		campaign.ID,
+		campaign.Name,
+		campaign.CommandType,
+		campaign.Priority,
+		campaign.Status,
+		campaign.TotalWaves,
+		campaign.CurrentWave,
+		campaign.CheckedFromWave,
+		int64(campaign.SoakTime/time.Second),
+		campaign.MaxFailureRate,
+		campaign.NextWaveAt,
+		campaign.CreatedBy,
+		campaign.CreatedAt,
+		campaign.UpdatedAt,
+		campaign.Payload,
+		campaign.ApprovalID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save campaign: %w", err)
+	}
+
+	_, err = tx.CopyFrom(ctx,
+		pgx.Identifier{"campaign_targets"},
+		[]string{"campaign_id", "router_id", "wave"},
+		pgx.CopyFromSlice(len(targets), func(i int) ([]any, error) {
+			return []any{targets[i].CampaignID, targets[i].RouterID, targets[i].Wave}, nil
+		}))
+	if err != nil {
+		return fmt.Errorf("failed to save campaign targets: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// GetCampaign returns nil without an error if there is no such campaign.
+func (r *PostgresRepository) GetCampaign(ctx context.Context, id uuid.UUID) (*model.Campaign, error) {
+	campaign, err := scanCampaign(r.pool.QueryRow(ctx,
+		`SELECT `+campaignColumns+` FROM campaigns WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return campaign, err
+}
+
+func (r *PostgresRepository) GetCampaignsByStatus(ctx context.Context, status string) ([]model.Campaign, error) {
+	rows, err := r.pool.Query(ctx,
+		`SELECT `+campaignColumns+`
+		FROM campaigns
+		WHERE status = $1
+		ORDER BY created_at ASC`,
+		status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var campaigns []model.Campaign
+	for rows.Next() {
+		campaign, err := scanCampaign(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan campaign row: %w", err)
+		}
+		campaigns = append(campaigns, *campaign)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return campaigns, nil
+}
+
+// TransitionCampaign moves the campaign to status if it is in one of the
+// from statuses and returns the updated campaign, or nil if it wasn't moved.
+// A campaign that is started again only checks the failure rate of the
+// waves released from now on.
+func (r *PostgresRepository) TransitionCampaign(ctx context.Context, id uuid.UUID, from []string, status, reason string) (*model.Campaign, error) {
+	campaign, err := scanCampaign(r.pool.QueryRow(ctx,
+		`UPDATE campaigns
+		SET status = $2,
+			pause_reason = NULLIF($3::text, ''),
+			checked_from_wave = CASE
+				WHEN $2 = 'RUNNING' THEN current_wave + 1
+				ELSE checked_from_wave
+			END,
+			updated_at = NOW()
+		WHERE id = $1 AND status = ANY($4)
+		RETURNING `+campaignColumns,
+		id, status, reason, from))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return campaign, err
+}
+
+func (r *PostgresRepository) GetCampaignTargets(ctx context.Context, campaignId uuid.UUID, wave int) ([]model.CampaignTarget, error) {
+	rows, err := r.pool.Query(ctx,
+		`SELECT campaign_id, router_id, wave, command_id
+		FROM campaign_targets
+		WHERE campaign_id = $1 AND wave = $2`,
+		campaignId, wave)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var targets []model.CampaignTarget
+	for rows.Next() {
+		var target model.CampaignTarget
+		if err := rows.Scan(&target.CampaignID, &target.RouterID, &target.Wave, &target.CommandID); err != nil {
+			return nil, fmt.Errorf("failed to scan campaign target row: %w", err)
+		}
+		targets = append(targets, target)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return targets, nil
+}
+
+// ReleaseCampaignWave saves the commands of the wave and makes it the
+// current one. It reports false without saving anything if the campaign
+// isn't RUNNING or the previous wave isn't the current one, e.g. because
+// another instance released the wave first.
+func (r *PostgresRepository) ReleaseCampaignWave(ctx context.Context, campaignId uuid.UUID, wave int, nextWaveAt *time.Time, commands []model.Command) (bool, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx,
+		`UPDATE campaigns
+		SET current_wave = $2, next_wave_at = $3, updated_at = NOW()
+		WHERE id = $1 AND status = 'RUNNING' AND current_wave = $2 - 1`,
+		campaignId, wave, nextWaveAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to advance campaign: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	for i := range commands {
+		if err := saveCommand(ctx, tx, &commands[i]); err != nil {
+			return false, fmt.Errorf("failed to save campaign command: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE campaign_targets t
+		SET command_id = c.id
+		FROM commands c
+		WHERE t.campaign_id = $1 AND t.wave = $2
+			AND c.campaign_id = $1 AND c.router_id = t.router_id`,
+		campaignId, wave)
+	if err != nil {
+		return false, fmt.Errorf("failed to link campaign commands: %w", err)
+	}
+
+	return true, tx.Commit(ctx)
+}
+
+// GetCampaignProgress counts the targets of every wave by command status.
+func (r *PostgresRepository) GetCampaignProgress(ctx context.Context, campaignId uuid.UUID) ([]model.WaveProgress, error) {
+	rows, err := r.pool.Query(ctx,
+		`SELECT t.wave, c.status, COUNT(*)
+		FROM campaign_targets t
+		LEFT JOIN commands c ON c.id = t.command_id
+		WHERE t.campaign_id = $1
+		GROUP BY t.wave, c.status
+		ORDER BY t.wave`,
+		campaignId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var progress []model.WaveProgress
+	for rows.Next() {
+		var wave, count int
+		var status *string
+		if err := rows.Scan(&wave, &status, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan campaign progress row: %w", err)
+		}
+
+		if len(progress) == 0 || progress[len(progress)-1].Wave != wave {
+			progress = append(progress, model.WaveProgress{Wave: wave, Statuses: make(map[string]int)})
+		}
+		current := &progress[len(progress)-1]
+		if status == nil {
+			current.Unreleased += count
+		} else {
+			current.Statuses[*status] += count
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return progress, nil
+}
+
+/* --- work with idempotency_keys table --- */
+
+// ReserveIdempotencyKey stores the key of a new request and returns nil. If
+// the caller already used the key after expiredBefore nothing is stored and
+// the existing key is returned instead; expired keys are taken over. So is
+// a key of the same request that neither completed nor holds its lease any
+// more: it keeps its ID, which is written back to key.
+func (r *PostgresRepository) ReserveIdempotencyKey(ctx context.Context, key *model.IdempotencyKey, expiredBefore time.Time) (*model.IdempotencyKey, error) {
+	err := r.pool.QueryRow(ctx,
+		`INSERT INTO idempotency_keys (caller, idempotency_key, request_hash, id, created_at, lease_expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (caller, idempotency_key) DO UPDATE SET
+			request_hash = EXCLUDED.request_hash,
+			id = CASE WHEN idempotency_keys.created_at < $7 THEN EXCLUDED.id ELSE idempotency_keys.id END,
+			command_ids = NULL,
+			job_id = NULL,
+			approval_id = NULL,
+			created_at = CASE WHEN idempotency_keys.created_at < $7 THEN EXCLUDED.created_at ELSE idempotency_keys.created_at END,
+			lease_expires_at = EXCLUDED.lease_expires_at,
+			completed_at = NULL
+		WHERE idempotency_keys.created_at < $7
+			OR (idempotency_keys.completed_at IS NULL
+				AND idempotency_keys.lease_expires_at <= EXCLUDED.created_at
+				AND idempotency_keys.request_hash = EXCLUDED.request_hash)
+		RETURNING id, created_at`,
+		key.Caller,
+		key.Key,
+		key.RequestHash,
+		key.ID,
+		key.CreatedAt,
+		key.LeaseExpiresAt,
+		expiredBefore).Scan(&key.ID, &key.CreatedAt)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	var existing model.IdempotencyKey
+	err = r.pool.QueryRow(ctx,
+		`SELECT caller, idempotency_key, request_hash, id, COALESCE(command_ids, '{}'), job_id, approval_id,
+			created_at, lease_expires_at, completed_at
+		FROM idempotency_keys
+		WHERE caller = $1 AND idempotency_key = $2`,
+		key.Caller, key.Key).Scan(
+		&existing.Caller,
+		&existing.Key,
+		&existing.RequestHash,
+		&existing.ID,
+		&existing.CommandIDs,
+		&existing.JobID,
+		&existing.ApprovalID,
+		&existing.CreatedAt,
+		&existing.LeaseExpiresAt,
+		&existing.CompletedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load idempotency key: %w", err)
+	}
+
+	return &existing, nil
+}
+
+// CompleteIdempotencyKey stores the result of the request: the commands it
+// created, or the job creating them, and their approval. The key is marked
+// completed at key.CompletedAt even if the request created nothing.
+func (r *PostgresRepository) CompleteIdempotencyKey(ctx context.Context, key *model.IdempotencyKey) error {
+	_, err := r.pool.Exec(ctx,
+		`UPDATE idempotency_keys
+		SET command_ids = $4, job_id = $5, approval_id = $6, completed_at = $7
+		WHERE caller = $1 AND idempotency_key = $2 AND id = $3`,
+		key.Caller, key.Key, key.ID, key.CommandIDs, key.JobID, key.ApprovalID, key.CompletedAt)
+	return err
+}
+
+// ReleaseIdempotencyKey stores what a failed request created before it
+// failed and gives up its lease, so that a retry takes the key over right
+// away and carries on with the same ID instead of starting afresh.
+func (r *PostgresRepository) ReleaseIdempotencyKey(ctx context.Context, key *model.IdempotencyKey) error {
+	_, err := r.pool.Exec(ctx,
+		`UPDATE idempotency_keys
+		SET command_ids = $4, job_id = $5, approval_id = $6, lease_expires_at = created_at
+		WHERE caller = $1 AND idempotency_key = $2 AND id = $3 AND completed_at IS NULL`,
+		key.Caller, key.Key, key.ID, key.CommandIDs, key.JobID, key.ApprovalID)
+	return err
+}
+
+func (r *PostgresRepository) DeleteExpiredIdempotencyKeys(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	tag, err := r.pool.Exec(ctx,
+		`DELETE FROM idempotency_keys WHERE created_at < $1`,
+		expiredBefore)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+/* --- work with jobs table --- */
+
+// columns read by scanJob, in order
+const jobColumns = `id, kind, status, request, total, processed, COALESCE(error, ''),
+			COALESCE(lease_owner, ''), lease_expires_at, approval_id, COALESCE(created_by, ''),
+			created_at, updated_at, finished_at`
+
+func scanJob(row pgx.Row) (*model.Job, error) {
+	var job model.Job
+	err := row.Scan(
+		&job.ID,
+		&job.Kind,
+		&job.Status,
+		&job.Request,
+		&job.Total,
+		&job.Processed,
+		&job.Error,
+		&job.LeaseOwner,
+		&job.LeaseExpiresAt,
+		&job.ApprovalID,
+		&job.CreatedBy,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.FinishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// SaveJob stores a new job; a job whose id exists already is left alone.
+func (r *PostgresRepository) SaveJob(ctx context.Context, job *model.Job) error {
+	_, err := r.pool.Exec(ctx,
+		`INSERT INTO jobs (id, kind, status, request, total, processed, error, approval_id, created_by, created_at, updated_at, finished_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7::text, ''), $8, NULLIF($9::text, ''), $10, $11, $12)
+		ON CONFLICT (id) DO NOTHING`,
+		job.ID,
+		job.Kind,
+		job.Status,
+		job.Request,
+		job.Total,
+		job.Processed,
+		job.Error,
+		job.ApprovalID,
+		job.CreatedBy,
+		job.CreatedAt,
+		job.UpdatedAt,
+		job.FinishedAt)
+	return err
+}
+
+// GetJob returns nil without an error if there is no such job.
+func (r *PostgresRepository) GetJob(ctx context.Context, id uuid.UUID) (*model.Job, error) {
+	job, err := scanJob(r.pool.QueryRow(ctx,
+		`SELECT `+jobColumns+` FROM jobs WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return job, err
+}
+
+// ListJobs returns up to limit jobs matching the filter, newest first,
+// starting after the cursor if it is set.
+func (r *PostgresRepository) ListJobs(ctx context.Context, filter model.JobFilter, after *model.Cursor, limit int) ([]model.Job, error) {
+	var conditions []string
+	var args []interface{}
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.Kind != "" {
+		where("kind = $%d", filter.Kind)
+	}
+	if len(filter.Statuses) > 0 {
+		where("status = ANY($%d)", filter.Statuses)
+	}
+	if after != nil {
+		args = append(args, after.CreatedAt, after.ID)
+		conditions = append(conditions, fmt.Sprintf("(created_at, id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+
+	query := `SELECT ` + jobColumns + ` FROM jobs`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(` ORDER BY created_at DESC, id DESC LIMIT $%d`, len(args))
+
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []model.Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan job row: %w", err)
+		}
+		jobs = append(jobs, *job)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return jobs, nil
+}
+
+// CancelJob cancels a PENDING job right away and marks a RUNNING one
+// CANCELLING for its worker to stop. It returns the updated job, or nil if
+// the job doesn't exist or is finished.
+func (r *PostgresRepository) CancelJob(ctx context.Context, id uuid.UUID) (*model.Job, error) {
+	job, err := scanJob(r.pool.QueryRow(ctx,
+		`UPDATE jobs
+		SET status = CASE WHEN status = 'PENDING' THEN 'CANCELLED' ELSE 'CANCELLING' END,
+			finished_at = CASE WHEN status = 'PENDING' THEN NOW() ELSE finished_at END,
+			updated_at = NOW()
+		WHERE id = $1 AND status IN ('PENDING', 'RUNNING')
+		RETURNING `+jobColumns,
+		id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return job, err
+}
+
+// ClaimJob leases the oldest job that is PENDING or whose worker's lease has
+// expired to owner, and returns it; nil if there is none. Instances claim
+// concurrently without waiting on each other's locks.
+func (r *PostgresRepository) ClaimJob(ctx context.Context, owner string, lease time.Duration) (*model.Job, error) {
+	job, err := scanJob(r.pool.QueryRow(ctx,
+		`UPDATE jobs
+		SET status = CASE WHEN status = 'PENDING' THEN 'RUNNING' ELSE status END,
+			lease_owner = $1,
+			lease_expires_at = NOW() + make_interval(secs => $2),
+			updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE status = 'PENDING'
+				OR (status IN ('RUNNING', 'CANCELLING')
+					AND (lease_expires_at IS NULL OR lease_expires_at < NOW()))
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING `+jobColumns,
+		owner, lease.Seconds()))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r.log.DebugContext(ctx, "Job claimed", "job_id", job.ID, "owner", owner, "processed", job.Processed)
+	return job, nil
+}
+
+// SaveJobProgress stores job.Processed and renews the lease of its worker.
+// It returns the current status of the job, which is CANCELLING if it was
+// cancelled meanwhile, or model.ErrJobLeaseLost if another worker took over.
+func (r *PostgresRepository) SaveJobProgress(ctx context.Context, job *model.Job, lease time.Duration) (string, error) {
+	var status string
+	err := r.pool.QueryRow(ctx,
+		`UPDATE jobs
+		SET processed = $3,
+			lease_expires_at = NOW() + make_interval(secs => $4),
+			updated_at = NOW()
+		WHERE id = $1 AND lease_owner = $2
+		RETURNING status`,
+		job.ID, job.LeaseOwner, job.Processed, lease.Seconds()).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", model.ErrJobLeaseLost
+	}
+	return status, err
+}
+
+// ReleaseJob gives up the lease of an unfinished job, so that the next
+// worker picks it up without waiting for the lease to expire.
+func (r *PostgresRepository) ReleaseJob(ctx context.Context, job *model.Job) error {
+	_, err := r.pool.Exec(ctx,
+		`UPDATE jobs
+		SET processed = $3, lease_owner = NULL, lease_expires_at = NULL, updated_at = NOW()
+		WHERE id = $1 AND lease_owner = $2`,
+		job.ID, job.LeaseOwner, job.Processed)
+	return err
+}
+
+// FinishJob stores the final status of the job and drops its lease.
+func (r *PostgresRepository) FinishJob(ctx context.Context, job *model.Job) error {
+	tag, err := r.pool.Exec(ctx,
+		`UPDATE jobs
+		SET status = $3, processed = $4, error = NULLIF($5::text, ''), updated_at = $6, finished_at = $7,
+			lease_owner = NULL, lease_expires_at = NULL
+		WHERE id = $1 AND lease_owner = $2`,
+		job.ID,
+		job.LeaseOwner,
+		job.Status,
+		job.Processed,
+		job.Error,
+		job.UpdatedAt,
+		job.FinishedAt)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return model.ErrJobLeaseLost
+	}
+	return nil
+}
+
+/* --- work with approvals table --- */
+
+// columns read by scanApproval, in order; the last one counts the commands
+const approvalColumns = `id, command_type, status, requested_by, requested_at, expires_at,
+			COALESCE(decided_by, ''), decided_at, COALESCE(reason, ''),
+			(SELECT COUNT(*) FROM commands WHERE approval_id = approvals.id),
+			(SELECT id FROM campaigns WHERE approval_id = approvals.id LIMIT 1)`
+
+func scanApproval(row pgx.Row) (*model.Approval, error) {
+	var approval model.Approval
+	err := row.Scan(
+		&approval.ID,
+		&approval.CommandType,
+		&approval.Status,
+		&approval.RequestedBy,
+		&approval.RequestedAt,
+		&approval.ExpiresAt,
+		&approval.DecidedBy,
+		&approval.DecidedAt,
+		&approval.Reason,
+		&approval.Commands,
+		&approval.CampaignID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &approval, nil
+}
+
+// SaveApproval stores a new approval; an approval whose id exists already
+// is left alone.
+func (r *PostgresRepository) SaveApproval(ctx context.Context, approval *model.Approval) error {
+	_, err := r.pool.Exec(ctx,
+		`INSERT INTO approvals (id, command_type, status, requested_by, requested_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (id) DO NOTHING`,
+		approval.ID,
+		approval.CommandType,
+		approval.Status,
+		approval.RequestedBy,
+		approval.RequestedAt,
+		approval.ExpiresAt)
+	return err
+}
+
+// GetApproval returns nil without an error if there is no such approval.
+func (r *PostgresRepository) GetApproval(ctx context.Context, id uuid.UUID) (*model.Approval, error) {
+	approval, err := scanApproval(r.pool.QueryRow(ctx,
+		`SELECT `+approvalColumns+` FROM approvals WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return approval, err
+}
+
+// DecideApproval approves or rejects a PENDING approval that hasn't expired
+// and moves its commands to PENDING and its campaign to RUNNING, or cancels
+// the commands and aborts the campaign. It returns the decided approval and
+// the updated commands, or nil if the approval can't be decided: it doesn't
+// exist, isn't PENDING, has expired or its job is still running.
+func (r *PostgresRepository) DecideApproval(ctx context.Context, id uuid.UUID, decision model.ApprovalDecision) (*model.Approval, []model.Command, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	approval, err := scanApproval(tx.QueryRow(ctx,
+		`UPDATE approvals
+		SET status = $2, decided_by = $3, decided_at = $4, reason = NULLIF($5::text, '')
+		WHERE id = $1 AND status = 'PENDING' AND expires_at > $4
+			-- a job still creating commands of the approval would leave them behind
+			AND NOT EXISTS (
+				SELECT 1 FROM jobs
+				WHERE approval_id = $1 AND status IN ('PENDING', 'RUNNING', 'CANCELLING'))
+		RETURNING `+approvalColumns,
+		id, decision.Status(), decision.By, decision.At, decision.Reason))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rows pgx.Rows
+	if decision.Approve {
+		rows, err = tx.Query(ctx,
+			`UPDATE commands SET status = 'PENDING'
+			WHERE approval_id = $1 AND status = 'AWAITING_APPROVAL'
+			RETURNING `+commandColumns,
+			id)
+	} else {
+		rows, err = tx.Query(ctx,
+			`UPDATE commands
+			SET status = 'CANCELLED', cancelled_at = $2, cancelled_by = $3, cancel_reason = $4
+			WHERE approval_id = $1 AND status = 'AWAITING_APPROVAL'
+			RETURNING `+commandColumns,
+			id, decision.At, decision.By, model.RejectedReason(decision.Reason))
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	commands, err := scanCommands(rows)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if decision.Approve {
+		_, err = tx.Exec(ctx,
+			`UPDATE campaigns SET status = 'RUNNING', next_wave_at = $2, updated_at = $2
+			WHERE approval_id = $1 AND status = 'AWAITING_APPROVAL'`,
+			id, decision.At)
+	} else {
+		_, err = tx.Exec(ctx,
+			`UPDATE campaigns SET status = 'ABORTED', pause_reason = $3, updated_at = $2
+			WHERE approval_id = $1 AND status = 'AWAITING_APPROVAL'`,
+			id, decision.At, model.RejectedReason(decision.Reason))
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to update campaign of approval: %w", err)
+	}
+
+	return approval, commands, tx.Commit(ctx)
+}
+
+// ExpireApprovals marks the PENDING approvals that expired before now as
+// EXPIRED, cancels their commands and aborts their campaigns. Approvals
+// whose job is still running expire once it has finished.
+func (r *PostgresRepository) ExpireApprovals(ctx context.Context, now time.Time) (expired []uuid.UUID, cancelled []model.Command, err error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx,
+		`UPDATE approvals
+		SET status = 'EXPIRED', decided_at = $1
+		WHERE status = 'PENDING' AND expires_at <= $1
+			AND NOT EXISTS (
+				SELECT 1 FROM jobs
+				WHERE approval_id = approvals.id AND status IN ('PENDING', 'RUNNING', 'CANCELLING'))
+		RETURNING id`,
+		now)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	expired, err = pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to scan approval ids: %w", err)
+	}
+	if len(expired) == 0 {
+		return nil, nil, nil
+	}
+
+	rows, err = tx.Query(ctx,
+		`UPDATE commands
+		SET status = 'CANCELLED', cancelled_at = $2, cancelled_by = $3, cancel_reason = $4
+		WHERE approval_id = ANY($1) AND status = 'AWAITING_APPROVAL'
+		RETURNING `+commandColumns,
+		expired, now, model.SystemActor, model.ApprovalExpiredReason)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cancelled, err = scanCommands(rows)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE campaigns SET status = 'ABORTED', pause_reason = $3, updated_at = $2
+		WHERE approval_id = ANY($1) AND status = 'AWAITING_APPROVAL'`,
+		expired, now, model.ApprovalExpiredReason)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to abort campaigns of expired approvals: %w", err)
+	}
+
+	return expired, cancelled, tx.Commit(ctx)
+}
+
+/* --- work with api_keys table --- */
+
+// columns read by scanApiKey, in order
+const apiKeyColumns = `id, name, subject, roles, key_hash, COALESCE(created_by, ''), created_at, expires_at,
+			COALESCE(revoked_by, ''), revoked_at`
+
+func scanApiKey(row pgx.Row) (*model.ApiKey, error) {
+	var key model.ApiKey
+	err := row.Scan(
+		&key.ID,
+		&key.Name,
+		&key.Subject,
+		&key.Roles,
+		&key.KeyHash,
+		&key.CreatedBy,
+		&key.CreatedAt,
+		&key.ExpiresAt,
+		&key.RevokedBy,
+		&key.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *PostgresRepository) SaveApiKey(ctx context.Context, key *model.ApiKey) error {
+	roles := key.Roles
+	if roles == nil {
+		roles = []string{}
+	}
+
+	_, err := r.pool.Exec(ctx,
+		`INSERT INTO api_keys (id, name, subject, roles, key_hash, created_by, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8)`,
+		key.ID,
+		key.Name,
+		key.Subject,
+		roles,
+		key.KeyHash,
+		key.CreatedBy,
+		key.CreatedAt,
+		key.ExpiresAt)
+	return err
+}
+
+// GetApiKeyByHash returns nil without an error if no key has the hash. The
+// key is returned even if it is revoked or expired.
+func (r *PostgresRepository) GetApiKeyByHash(ctx context.Context, hash string) (*model.ApiKey, error) {
+	key, err := scanApiKey(r.pool.QueryRow(ctx,
+		`SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = $1`, hash))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return key, err
+}
+
+// ListApiKeys returns the keys, newest first.
+func (r *PostgresRepository) ListApiKeys(ctx context.Context, includeRevoked bool) ([]model.ApiKey, error) {
+	rows, err := r.pool.Query(ctx,
+		`SELECT `+apiKeyColumns+`
+		FROM api_keys
+		WHERE $1 OR revoked_at IS NULL
+		ORDER BY created_at DESC, id DESC`,
+		includeRevoked)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []model.ApiKey
+	for rows.Next() {
+		key, err := scanApiKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan api key row: %w", err)
+		}
+		keys = append(keys, *key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return keys, nil
+}
+
+// RevokeApiKey revokes a key for good. It returns nil if there is no such
+// key or it is already revoked.
+func (r *PostgresRepository) RevokeApiKey(ctx context.Context, id uuid.UUID, by string, at time.Time) (*model.ApiKey, error) {
+	key, err := scanApiKey(r.pool.QueryRow(ctx,
+		`UPDATE api_keys
+		SET revoked_by = NULLIF($2, ''), revoked_at = $3
+		WHERE id = $1 AND revoked_at IS NULL
+		RETURNING `+apiKeyColumns,
+		id, by, at))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return key, err
+}
+
+/* --- work with roles table --- */
+
+// ListRoles returns every role with its permissions, ordered by name.
+func (r *PostgresRepository) ListRoles(ctx context.Context) ([]model.Role, error) {
+	rows, err := r.pool.Query(ctx,
+		`SELECT name, COALESCE(description, ''), permissions, COALESCE(updated_by, ''), updated_at
+		FROM roles
+		ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []model.Role
+	for rows.Next() {
+		var role model.Role
+		var permissions []byte
+		if err := rows.Scan(&role.Name, &role.Description, &permissions, &role.UpdatedBy, &role.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan role row: %w", err)
+		}
+		if err := json.Unmarshal(permissions, &role.Permissions); err != nil {
+			return nil, fmt.Errorf("invalid permissions of role %s: %w", role.Name, err)
+		}
+		roles = append(roles, role)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return roles, nil
+}
+
+// SaveRole creates the role or replaces its description and permissions.
+func (r *PostgresRepository) SaveRole(ctx context.Context, role *model.Role) error {
+	permissions, err := json.Marshal(role.Permissions)
+	if err != nil {
+		return err
+	}
+	if role.Permissions == nil {
+		permissions = []byte("[]")
+	}
+
+	_, err = r.pool.Exec(ctx,
+		`INSERT INTO roles (name, description, permissions, updated_by, updated_at)
+		VALUES ($1, NULLIF($2, ''), $3, NULLIF($4, ''), $5)
+		ON CONFLICT (name) DO UPDATE SET
+			description = EXCLUDED.description,
+			permissions = EXCLUDED.permissions,
+			updated_by = EXCLUDED.updated_by,
+			updated_at = EXCLUDED.updated_at`,
+		role.Name,
+		role.Description,
+		permissions,
+		role.UpdatedBy,
+		role.UpdatedAt)
+	return err
+}
+
+// DeleteRole reports whether there was such a role.
+func (r *PostgresRepository) DeleteRole(ctx context.Context, name string) (bool, error) {
+	tag, err := r.pool.Exec(ctx, `DELETE FROM roles WHERE name = $1`, name)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+/* --- work with audit_events table --- */
+
+// auditChainLock serializes appends to the audit log, so that each event is
+// chained to the last one
+const auditChainLock = 0x61756469745f6c67
+
+// AppendAuditEvents chains the events, in order, to the last one, setting
+// their PrevHash, Hash and Seq, and appends them in one transaction.
+func (r *PostgresRepository) AppendAuditEvents(ctx context.Context, events []model.AuditEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, int64(auditChainLock)); err != nil {
+		return err
+	}
+
+	var prevHash []byte
+	err = tx.QueryRow(ctx, `SELECT hash FROM audit_events ORDER BY seq DESC LIMIT 1`).Scan(&prevHash)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	for i := range events {
+		event := &events[i]
+		event.PrevHash = prevHash
+		event.Hash = event.ComputeHash()
+
+		err = tx.QueryRow(ctx,
+			`INSERT INTO audit_events (
+				occurred_at, actor, action, target, request_digest, outcome, error, source_ip, prev_hash, hash
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			RETURNING seq`,
+			event.OccurredAt,
+			event.Actor,
+			event.Action,
+			event.Target,
+			event.RequestDigest,
+			event.Outcome,
+			event.Error,
+			event.SourceIP,
+			event.PrevHash,
+			event.Hash).Scan(&event.Seq)
+		if err != nil {
+			return err
+		}
+		prevHash = event.Hash
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	r.log.DebugContext(ctx, "Audit events appended", "events", len(events), "last_seq", events[len(events)-1].Seq)
+	return nil
+}
+
+// ListAuditEvents returns the events before beforeSeq, 0 = from the last
+// one, newest first.
+func (r *PostgresRepository) ListAuditEvents(ctx context.Context, filter model.AuditFilter, beforeSeq int64, limit int) ([]model.AuditEvent, error) {
+	return r.queryAuditEvents(ctx, filter, beforeSeq, false, limit)
+}
+
+// ExportAuditEvents returns the events after afterSeq, oldest first: the
+// order they are chained in.
+func (r *PostgresRepository) ExportAuditEvents(ctx context.Context, filter model.AuditFilter, afterSeq int64, limit int) ([]model.AuditEvent, error) {
+	return r.queryAuditEvents(ctx, filter, afterSeq, true, limit)
+}
+
+func (r *PostgresRepository) queryAuditEvents(ctx context.Context, filter model.AuditFilter, fromSeq int64, ascending bool, limit int) ([]model.AuditEvent, error) {
+	var conditions []string
+	var args []interface{}
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.Actor != "" {
+		where("actor = $%d", filter.Actor)
+	}
+	if filter.Action != "" {
+		where("action = $%d", filter.Action)
+	}
+	if filter.OccurredFrom != nil {
+		where("occurred_at >= $%d", *filter.OccurredFrom)
+	}
+	if filter.OccurredTo != nil {
+		where("occurred_at < $%d", *filter.OccurredTo)
+	}
+
+	order := "DESC"
+	switch {
+	case ascending:
+		order = "ASC"
+		where("seq > $%d", fromSeq)
+	case fromSeq > 0:
+		where("seq < $%d", fromSeq)
+	}
+
+	query := `SELECT seq, occurred_at, actor, action, target, request_digest, outcome, error, source_ip, prev_hash, hash
+		FROM audit_events`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(` ORDER BY seq %s LIMIT $%d`, order, len(args))
+
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []model.AuditEvent
+	for rows.Next() {
+		var event model.AuditEvent
+		if err := rows.Scan(
+			&event.Seq,
+			&event.OccurredAt,
+			&event.Actor,
+			&event.Action,
+			&event.Target,
+			&event.RequestDigest,
+			&event.Outcome,
+			&event.Error,
+			&event.SourceIP,
+			&event.PrevHash,
+			&event.Hash,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan audit event row: %w", err)
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return events, nil
 }
@@ -6,7 +6,10 @@ package postgres_test
 
 import (
 	"context"
+	"encoding/json"
 	"router-manager/internal/model"
+	"router-manager/internal/repository/contract"
+	"router-manager/internal/repository/postgres"
 	"router-manager/testhelper"
 	"testing"
 	"time"
@@ -48,11 +51,1093 @@ func TestPostgresRepository(t *testing.T) {
 	commandResult, _ = testDb.Repo.GetCommandsByRouterId(context.Background(), uuid.New())
 	assert.Nil(t, commandResult)
 
-	err = testDb.Repo.ChangeStatusByRouterId(context.Background(), routerId, "SENT")
+	changed, err := testDb.Repo.ChangeStatusByRouterId(context.Background(), routerId, "SENT")
 	assert.NoError(t, err)
+	require.Len(t, changed, 1)
+	assert.Equal(t, commandId, changed[0].ID)
+	assert.Equal(t, "SENT", changed[0].Status)
+	assert.NotNil(t, changed[0].SentAt)
+
+	counts, err := testDb.Repo.CountCommandsByStatus(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"SENT": 1}, counts)
 
 	routerResult, err := testDb.Repo.FindRouterByRouterId(context.Background(), routerId.String())
 	assert.NoError(t, err)
 	assert.Equal(t, routerResult.ID, routerId)
 	assert.Equal(t, routerResult.SerialNumber, router.SerialNumber)
 }
+
+func TestPostgresRepository_CancelCommands(t *testing.T) {
+	testDb := testhelper.SetupTestPostgres(t)
+	ctx := context.Background()
+
+	router := &model.Router{ID: uuid.New(), SerialNumber: "SN123", CreatedAt: time.Now()}
+	require.NoError(t, testDb.Repo.SaveRouter(ctx, router))
+
+	pending := &model.Command{ID: uuid.New(), RouterID: router.ID, CommandType: "REBOOT", Status: "PENDING", CreatedAt: time.Now()}
+	sent := &model.Command{ID: uuid.New(), RouterID: router.ID, CommandType: "REBOOT", Status: "SENT", CreatedAt: time.Now()}
+	acked := &model.Command{ID: uuid.New(), RouterID: router.ID, CommandType: "REBOOT", Status: "ACKED", CreatedAt: time.Now()}
+	other := &model.Command{ID: uuid.New(), RouterID: router.ID, CommandType: "UPDATE_FIRMWARE", Status: "PENDING", CreatedAt: time.Now()}
+	for _, cmd := range []*model.Command{pending, sent, acked, other} {
+		require.NoError(t, testDb.Repo.SaveCommand(ctx, cmd))
+	}
+
+	cancelled, err := testDb.Repo.CancelCommands(ctx,
+		model.CancelFilter{RouterID: &router.ID, CommandType: "REBOOT"},
+		model.Cancellation{By: "alice", Reason: "wrong router", At: time.Now()})
+	require.NoError(t, err)
+	require.Len(t, cancelled, 2)
+
+	byId := map[uuid.UUID]model.Command{}
+	for _, cmd := range cancelled {
+		byId[cmd.ID] = cmd
+	}
+	assert.Equal(t, "CANCELLED", byId[pending.ID].Status)
+	assert.Equal(t, "CANCELLING", byId[sent.ID].Status)
+	assert.Equal(t, "alice", byId[pending.ID].CancelledBy)
+	assert.Equal(t, "wrong router", byId[sent.ID].CancelReason)
+	assert.NotNil(t, byId[sent.ID].CancelledAt)
+
+	// nothing left to cancel
+	cancelled, err = testDb.Repo.CancelCommands(ctx,
+		model.CancelFilter{CommandID: &acked.ID},
+		model.Cancellation{By: "alice", At: time.Now()})
+	require.NoError(t, err)
+	assert.Empty(t, cancelled)
+}
+
+func TestPostgresRepository_ListCommands(t *testing.T) {
+	testDb := testhelper.SetupTestPostgres(t)
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+
+	router := &model.Router{ID: uuid.New(), SerialNumber: "SN-LIST", CreatedAt: now}
+	require.NoError(t, testDb.Repo.SaveRouter(ctx, router))
+
+	var saved []*model.Command
+	for i := 0; i < 5; i++ {
+		cmd := &model.Command{
+			ID:          uuid.New(),
+			RouterID:    router.ID,
+			CommandType: "REBOOT",
+			Status:      "PENDING",
+			CreatedAt:   now.Add(-time.Duration(i) * time.Hour),
+		}
+		require.NoError(t, testDb.Repo.SaveCommand(ctx, cmd))
+		saved = append(saved, cmd)
+	}
+
+	found, err := testDb.Repo.GetCommandById(ctx, saved[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, saved[0].ID, found.ID)
+
+	found, err = testDb.Repo.GetCommandById(ctx, uuid.New())
+	require.NoError(t, err)
+	assert.Nil(t, found)
+
+	from := now.Add(-150 * time.Minute)
+	filter := model.CommandFilter{SerialNumber: "SN-LIST", Statuses: []string{"PENDING"}, CreatedFrom: &from}
+
+	page, err := testDb.Repo.ListCommands(ctx, filter, nil, 2)
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, saved[0].ID, page[0].ID)
+	assert.Equal(t, saved[1].ID, page[1].ID)
+
+	page, err = testDb.Repo.ListCommands(ctx, filter, &model.Cursor{CreatedAt: page[1].CreatedAt, ID: page[1].ID}, 2)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, saved[2].ID, page[0].ID)
+}
+
+func TestPostgresRepository_IdempotencyKeys(t *testing.T) {
+	testDb := testhelper.SetupTestPostgres(t)
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+
+	key := &model.IdempotencyKey{
+		Caller: "orchestrator", Key: "retry-1", RequestHash: "hash", ID: uuid.New(),
+		CreatedAt: now, LeaseExpiresAt: now.Add(time.Minute),
+	}
+	seed := key.ID
+
+	existing, err := testDb.Repo.ReserveIdempotencyKey(ctx, key, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Nil(t, existing)
+
+	// in progress
+	existing, err = testDb.Repo.ReserveIdempotencyKey(ctx, key, now.Add(-time.Hour))
+	require.NoError(t, err)
+	require.NotNil(t, existing)
+	assert.Empty(t, existing.CommandIDs)
+	assert.Nil(t, existing.CompletedAt)
+
+	// a failed request keeps its partial result and lets the retry take over
+	partial := []uuid.UUID{uuid.New()}
+	key.CommandIDs = partial
+	require.NoError(t, testDb.Repo.ReleaseIdempotencyKey(ctx, key))
+
+	retry := &model.IdempotencyKey{
+		Caller: "orchestrator", Key: "retry-1", RequestHash: "hash", ID: uuid.New(),
+		CreatedAt: now.Add(time.Second), LeaseExpiresAt: now.Add(time.Minute),
+	}
+	existing, err = testDb.Repo.ReserveIdempotencyKey(ctx, retry, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Nil(t, existing)
+	assert.Equal(t, seed, retry.ID)
+	assert.Equal(t, now, retry.CreatedAt)
+
+	// so does a request that ran out of its lease, but not for another request
+	other := &model.IdempotencyKey{
+		Caller: "orchestrator", Key: "retry-1", RequestHash: "other", ID: uuid.New(),
+		CreatedAt: now.Add(2 * time.Minute), LeaseExpiresAt: now.Add(3 * time.Minute),
+	}
+	existing, err = testDb.Repo.ReserveIdempotencyKey(ctx, other, now.Add(-time.Hour))
+	require.NoError(t, err)
+	require.NotNil(t, existing)
+	assert.Equal(t, "hash", existing.RequestHash)
+
+	retry.CreatedAt, retry.LeaseExpiresAt = now.Add(2*time.Minute), now.Add(3*time.Minute)
+	existing, err = testDb.Repo.ReserveIdempotencyKey(ctx, retry, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Nil(t, existing)
+	assert.Equal(t, seed, retry.ID)
+
+	// a request that created nothing completes as well
+	completed := now.Add(2 * time.Minute)
+	retry.CommandIDs, retry.CompletedAt = nil, &completed
+	require.NoError(t, testDb.Repo.CompleteIdempotencyKey(ctx, retry))
+
+	retry.CreatedAt, retry.LeaseExpiresAt = now.Add(10*time.Minute), now.Add(11*time.Minute)
+	existing, err = testDb.Repo.ReserveIdempotencyKey(ctx, retry, now.Add(-time.Hour))
+	require.NoError(t, err)
+	require.NotNil(t, existing)
+	assert.Equal(t, "hash", existing.RequestHash)
+	assert.Empty(t, existing.CommandIDs)
+	require.NotNil(t, existing.CompletedAt)
+
+	// completed keys are not released
+	require.NoError(t, testDb.Repo.ReleaseIdempotencyKey(ctx, retry))
+	existing, err = testDb.Repo.ReserveIdempotencyKey(ctx, retry, now.Add(-time.Hour))
+	require.NoError(t, err)
+	require.NotNil(t, existing)
+	assert.NotNil(t, existing.CompletedAt)
+
+	// an expired key is taken over by the next request, with a new id
+	later := &model.IdempotencyKey{
+		Caller: "orchestrator", Key: "retry-1", RequestHash: "new", ID: uuid.New(),
+		CreatedAt: now.Add(2 * time.Hour), LeaseExpiresAt: now.Add(2*time.Hour + time.Minute),
+	}
+	laterId := later.ID
+	existing, err = testDb.Repo.ReserveIdempotencyKey(ctx, later, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Nil(t, existing)
+	assert.Equal(t, laterId, later.ID)
+
+	deleted, err := testDb.Repo.DeleteExpiredIdempotencyKeys(ctx, now.Add(3*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+}
+
+func TestPostgresRepository_Workflows(t *testing.T) {
+	testDb := testhelper.SetupTestPostgres(t)
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+
+	router := &model.Router{ID: uuid.New(), SerialNumber: "SN-WORKFLOW", CreatedAt: now}
+	require.NoError(t, testDb.Repo.SaveRouter(ctx, router))
+
+	// download -> verify -> reboot, and report depends on download only
+	workflow := &model.Workflow{ID: uuid.New(), RouterID: router.ID, Name: "upgrade", CreatedAt: now}
+	step := func(key, status string, dependsOn ...string) model.WorkflowStep {
+		return model.WorkflowStep{
+			Command: model.Command{
+				ID: uuid.New(), RouterID: router.ID, CommandType: key, Status: status,
+				CreatedAt: now, WorkflowID: &workflow.ID, WorkflowStep: key,
+			},
+			DependsOn: dependsOn,
+		}
+	}
+	workflow.Steps = []model.WorkflowStep{
+		step("download", model.StatusPending),
+		step("verify", model.StatusBlocked, "download"),
+		step("reboot", model.StatusBlocked, "verify"),
+		step("report", model.StatusBlocked, "download"),
+	}
+	require.NoError(t, testDb.Repo.SaveWorkflow(ctx, workflow))
+
+	found, err := testDb.Repo.GetWorkflow(ctx, workflow.ID)
+	require.NoError(t, err)
+	require.Len(t, found.Steps, 4)
+	assert.Equal(t, model.WorkflowRunning, found.Status())
+
+	cancellation := model.Cancellation{By: model.SystemActor, Reason: "prerequisite failed", At: now}
+
+	// nothing to release before download is acked
+	released, cancelled, err := testDb.Repo.ResolveWorkflows(ctx, router.ID, cancellation)
+	require.NoError(t, err)
+	assert.Empty(t, released)
+	assert.Empty(t, cancelled)
+
+	_, err = testDb.Repo.ChangeStatusByRouterId(ctx, router.ID, model.StatusSent)
+	require.NoError(t, err)
+	_, err = testDb.Repo.ChangeStatusByRouterId(ctx, router.ID, model.StatusAcked)
+	require.NoError(t, err)
+
+	released, _, err = testDb.Repo.ResolveWorkflows(ctx, router.ID, cancellation)
+	require.NoError(t, err)
+	require.Len(t, released, 2)
+	assert.ElementsMatch(t, []string{"verify", "report"}, []string{released[0].WorkflowStep, released[1].WorkflowStep})
+
+	// verify fails, reboot can't run any more
+	verify := workflow.Steps[1].Command.ID
+	_, err = testDb.Repo.ChangeStatusByIds(ctx, router.ID, []uuid.UUID{verify}, model.StatusSent)
+	require.NoError(t, err)
+	failed, err := testDb.Repo.FailCommand(ctx, router.ID, verify, "checksum mismatch")
+	require.NoError(t, err)
+	assert.True(t, failed)
+
+	released, cancelled, err = testDb.Repo.ResolveWorkflows(ctx, router.ID, cancellation)
+	require.NoError(t, err)
+	assert.Empty(t, released)
+	require.Len(t, cancelled, 1)
+	assert.Equal(t, "reboot", cancelled[0].WorkflowStep)
+	assert.Equal(t, "prerequisite failed", cancelled[0].CancelReason)
+
+	found, err = testDb.Repo.GetWorkflow(ctx, workflow.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.WorkflowFailed, found.Status())
+	for _, step := range found.Steps {
+		if step.Command.WorkflowStep == "verify" {
+			assert.Equal(t, "checksum mismatch", step.Command.Error)
+			assert.Equal(t, []string{"download"}, step.DependsOn)
+		}
+	}
+
+	found, err = testDb.Repo.GetWorkflow(ctx, uuid.New())
+	require.NoError(t, err)
+	assert.Nil(t, found)
+}
+
+func TestPostgresRepository_Campaigns(t *testing.T) {
+	testDb := testhelper.SetupTestPostgres(t)
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+
+	var routers []model.Router
+	for _, serial := range []string{"CMP-1", "CMP-2", "CMP-3", "OTHER-1"} {
+		router := model.Router{ID: uuid.New(), SerialNumber: serial, CreatedAt: now}
+		require.NoError(t, testDb.Repo.SaveRouter(ctx, &router))
+		routers = append(routers, router)
+	}
+
+	found, err := testDb.Repo.FindRoutersBySelector(ctx, model.RouterSelector{SerialPrefix: "CMP-"})
+	require.NoError(t, err)
+	require.Len(t, found, 3)
+	assert.Equal(t, "CMP-1", found[0].SerialNumber)
+
+	found, err = testDb.Repo.FindRoutersBySelector(ctx, model.RouterSelector{SerialNumbers: []string{"OTHER-1", "MISSING"}})
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+
+	campaign := &model.Campaign{
+		ID: uuid.New(), Name: "patch", CommandType: "SECURITY_PATCH", Priority: 90,
+		Payload: json.RawMessage(`{"patch":"CVE-2024-1234"}`), Status: model.CampaignRunning, TotalWaves: 2, CheckedFromWave: 1,
+		SoakTime: time.Minute, MaxFailureRate: 0.5, NextWaveAt: &now,
+		CreatedBy: "ops", CreatedAt: now, UpdatedAt: now,
+	}
+	targets := []model.CampaignTarget{
+		{CampaignID: campaign.ID, RouterID: routers[0].ID, Wave: 1},
+		{CampaignID: campaign.ID, RouterID: routers[1].ID, Wave: 2},
+		{CampaignID: campaign.ID, RouterID: routers[2].ID, Wave: 2},
+	}
+	require.NoError(t, testDb.Repo.SaveCampaign(ctx, campaign, targets))
+
+	saved, err := testDb.Repo.GetCampaign(ctx, campaign.ID)
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, saved.SoakTime)
+	assert.Equal(t, "ops", saved.CreatedBy)
+	assert.JSONEq(t, `{"patch":"CVE-2024-1234"}`, string(saved.Payload))
+
+	wave, err := testDb.Repo.GetCampaignTargets(ctx, campaign.ID, 1)
+	require.NoError(t, err)
+	require.Len(t, wave, 1)
+
+	command := model.Command{
+		ID: uuid.New(), RouterID: routers[0].ID, CommandType: "SECURITY_PATCH",
+		Status: model.StatusPending, Priority: 90, CreatedAt: now, CampaignID: &campaign.ID,
+	}
+	next := now.Add(time.Minute)
+	released, err := testDb.Repo.ReleaseCampaignWave(ctx, campaign.ID, 1, &next, []model.Command{command})
+	require.NoError(t, err)
+	assert.True(t, released)
+
+	// a second release of the same wave is a no-op
+	released, err = testDb.Repo.ReleaseCampaignWave(ctx, campaign.ID, 1, &next, []model.Command{command})
+	require.NoError(t, err)
+	assert.False(t, released)
+
+	progress, err := testDb.Repo.GetCampaignProgress(ctx, campaign.ID)
+	require.NoError(t, err)
+	require.Len(t, progress, 2)
+	assert.Equal(t, 1, progress[0].Statuses[model.StatusPending])
+	assert.Equal(t, 2, progress[1].Unreleased)
+
+	paused, err := testDb.Repo.TransitionCampaign(ctx, campaign.ID, []string{model.CampaignRunning}, model.CampaignPaused, "manual")
+	require.NoError(t, err)
+	require.NotNil(t, paused)
+	assert.Equal(t, "manual", paused.PauseReason)
+
+	// a paused campaign doesn't release waves
+	released, err = testDb.Repo.ReleaseCampaignWave(ctx, campaign.ID, 2, &next, nil)
+	require.NoError(t, err)
+	assert.False(t, released)
+
+	moved, err := testDb.Repo.TransitionCampaign(ctx, campaign.ID, []string{model.CampaignRunning}, model.CampaignPaused, "again")
+	require.NoError(t, err)
+	assert.Nil(t, moved)
+
+	resumed, err := testDb.Repo.TransitionCampaign(ctx, campaign.ID, []string{model.CampaignPaused}, model.CampaignRunning, "")
+	require.NoError(t, err)
+	require.NotNil(t, resumed)
+	assert.Equal(t, 2, resumed.CheckedFromWave)
+	assert.Empty(t, resumed.PauseReason)
+
+	running, err := testDb.Repo.GetCampaignsByStatus(ctx, model.CampaignRunning)
+	require.NoError(t, err)
+	require.Len(t, running, 1)
+
+	cancelled, err := testDb.Repo.CancelCommands(ctx, model.CancelFilter{CampaignID: &campaign.ID},
+		model.Cancellation{By: "ops", Reason: "abort", At: now})
+	require.NoError(t, err)
+	require.Len(t, cancelled, 1)
+	assert.Equal(t, command.ID, cancelled[0].ID)
+}
+
+func TestPostgresRepository_DecommissionedRouters(t *testing.T) {
+	testDb := testhelper.SetupTestPostgres(t)
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+
+	retired := &model.Router{ID: uuid.New(), SerialNumber: "SN-RETIRED", CreatedAt: now}
+	require.NoError(t, testDb.Repo.SaveRouter(ctx, retired))
+	_, err := testDb.Pool.Exec(ctx, `UPDATE routers SET decommissioned_at = $1 WHERE id = $2`, now, retired.ID)
+	require.NoError(t, err)
+
+	// the upsert reports the decommissioning back
+	routers := []model.Router{
+		{ID: uuid.New(), SerialNumber: "SN-RETIRED", CreatedAt: now},
+		{ID: uuid.New(), SerialNumber: "SN-ACTIVE", CreatedAt: now},
+	}
+	require.NoError(t, testDb.Repo.SaveRouters(ctx, routers))
+	require.NotNil(t, routers[0].DecommissionedAt)
+	assert.True(t, now.Equal(*routers[0].DecommissionedAt))
+	assert.Nil(t, routers[1].DecommissionedAt)
+
+	found, err := testDb.Repo.FindRoutersBySelector(ctx, model.RouterSelector{SerialNumbers: []string{"SN-ACTIVE", "SN-RETIRED"}})
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+	assert.Nil(t, found[0].DecommissionedAt)
+	assert.NotNil(t, found[1].DecommissionedAt)
+
+	router, err := testDb.Repo.FindRouterByRouterId(ctx, retired.ID.String())
+	require.NoError(t, err)
+	assert.NotNil(t, router.DecommissionedAt)
+
+	// decommissioned routers aren't counted, online or not
+	seen := &model.Router{ID: uuid.New(), SerialNumber: "SN-SEEN", LastSeenAt: &now, CreatedAt: now}
+	require.NoError(t, testDb.Repo.SaveRouter(ctx, seen))
+	online, offline, err := testDb.Repo.CountRouters(ctx, now.Add(-time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), online)
+	assert.Equal(t, int64(1), offline)
+}
+
+func TestPostgresRepository_GetPendingCommands(t *testing.T) {
+	testDb := testhelper.SetupTestPostgres(t)
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+
+	router := &model.Router{ID: uuid.New(), SerialNumber: "SN-PENDING", CreatedAt: now}
+	require.NoError(t, testDb.Repo.SaveRouter(ctx, router))
+
+	pending := &model.Command{ID: uuid.New(), RouterID: router.ID, CommandType: "REBOOT", Status: model.StatusPending, CreatedAt: now}
+	sent := &model.Command{ID: uuid.New(), RouterID: router.ID, CommandType: "REBOOT", Status: model.StatusSent, CreatedAt: now}
+	other := &model.Command{ID: uuid.New(), RouterID: router.ID, CommandType: "UPDATE_FIRMWARE", Status: model.StatusPending, CreatedAt: now}
+	for _, cmd := range []*model.Command{pending, sent, other} {
+		require.NoError(t, testDb.Repo.SaveCommand(ctx, cmd))
+	}
+
+	// only PENDING commands of the same router and type, and nothing written
+	found, err := testDb.Repo.GetPendingCommands(ctx, []model.Command{{ID: uuid.New(), RouterID: router.ID, CommandType: "REBOOT"}})
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, pending.ID, found[0].ID)
+
+	commands, err := testDb.Repo.GetCommandsByRouterId(ctx, router.ID)
+	require.NoError(t, err)
+	assert.Len(t, commands, 3)
+}
+
+func TestPostgresRepository_BulkSend(t *testing.T) {
+	testDb := testhelper.SetupTestPostgres(t)
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+
+	known := &model.Router{ID: uuid.New(), SerialNumber: "SN-BULK-1", CreatedAt: now}
+	require.NoError(t, testDb.Repo.SaveRouter(ctx, known))
+
+	// a known serial keeps its id, a repeated one gets a single row
+	routers := []model.Router{
+		{ID: uuid.New(), SerialNumber: "SN-BULK-1", CreatedAt: now},
+		{ID: uuid.New(), SerialNumber: "SN-BULK-2", CreatedAt: now},
+		{ID: uuid.New(), SerialNumber: "SN-BULK-2", CreatedAt: now},
+	}
+	require.NoError(t, testDb.Repo.SaveRouters(ctx, routers))
+	assert.Equal(t, known.ID, routers[0].ID)
+	assert.Equal(t, routers[1].ID, routers[2].ID)
+
+	existing := &model.Command{ID: uuid.New(), RouterID: known.ID, CommandType: "UPDATE_FIRMWARE", Status: model.StatusPending, CreatedAt: now}
+	require.NoError(t, testDb.Repo.SaveCommand(ctx, existing))
+
+	command := func(router model.Router) model.Command {
+		return model.Command{
+			ID: uuid.New(), RouterID: router.ID, CommandType: "UPDATE_FIRMWARE",
+			Payload: json.RawMessage(`{"command": "UPDATE_FIRMWARE"}`), Status: model.StatusPending, CreatedAt: now,
+		}
+	}
+	cmds := []model.Command{command(routers[0]), command(routers[1]), command(routers[2])}
+
+	results, err := testDb.Repo.SaveCommandsCoalesced(ctx, cmds, model.CoalesceReplacePending)
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	require.Len(t, results[0].Replaced, 1)
+	assert.Equal(t, existing.ID, results[0].Replaced[0].ID)
+	assert.Empty(t, results[1].Replaced)
+	// the second command for the same router replaces the first one
+	require.Len(t, results[2].Replaced, 1)
+	assert.Equal(t, cmds[1].ID, results[2].Replaced[0].ID)
+
+	pending, err := testDb.Repo.GetCommandsByRouterIdAndStatus(ctx, routers[1].ID, model.StatusPending, 0)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, cmds[2].ID, pending[0].ID)
+
+	replaced, err := testDb.Repo.GetCommandById(ctx, cmds[1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusCancelled, replaced.Status)
+	assert.Equal(t, model.SystemActor, replaced.CancelledBy)
+
+	// identical commands are dropped
+	again := []model.Command{command(routers[0])}
+	again[0].CommandType = "REBOOT"
+	again[0].Payload = json.RawMessage(`{"command": "REBOOT"}`)
+	results, err = testDb.Repo.SaveCommandsCoalesced(ctx, again, model.CoalesceDropIdentical)
+	require.NoError(t, err)
+	assert.Nil(t, results[0].ExistingID)
+
+	dropped := again[0]
+	dropped.ID = uuid.New()
+	results, err = testDb.Repo.SaveCommandsCoalesced(ctx, []model.Command{dropped}, model.CoalesceDropIdentical)
+	require.NoError(t, err)
+	require.NotNil(t, results[0].ExistingID)
+	assert.Equal(t, again[0].ID, *results[0].ExistingID)
+
+	// a command saved by an earlier attempt is neither saved again nor coalesced
+	resent := []model.Command{cmds[2], command(routers[0])}
+	results, err = testDb.Repo.SaveCommandsCoalesced(ctx, resent, model.CoalesceReplacePending)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.True(t, results[0].AlreadySaved)
+	assert.False(t, results[1].AlreadySaved)
+
+	pending, err = testDb.Repo.GetCommandsByRouterIdAndStatus(ctx, routers[1].ID, model.StatusPending, 0)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, cmds[2].ID, pending[0].ID)
+}
+
+func TestPostgresRepository_CommandSignatures(t *testing.T) {
+	testDb := testhelper.SetupTestPostgres(t)
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	expiresAt := now.Add(time.Hour)
+
+	router := &model.Router{ID: uuid.New(), SerialNumber: "SN-SIGNED", CreatedAt: now}
+	require.NoError(t, testDb.Repo.SaveRouter(ctx, router))
+
+	signed := func() model.Command {
+		return model.Command{
+			ID: uuid.New(), RouterID: router.ID, CommandType: "REBOOT",
+			Payload: json.RawMessage(`{"command":"REBOOT"}`), Status: model.StatusPending, CreatedAt: now,
+			SignatureKeyID: "0123456789abcdef", Signature: []byte{1, 2, 3},
+			NotBefore: &now, ExpiresAt: &expiresAt,
+		}
+	}
+
+	// both the single and the bulk writes keep the envelope
+	single := signed()
+	require.NoError(t, testDb.Repo.SaveCommand(ctx, &single))
+	bulk := []model.Command{signed()}
+	_, err := testDb.Repo.SaveCommandsCoalesced(ctx, bulk, model.CoalesceKeepAll)
+	require.NoError(t, err)
+
+	for _, cmd := range []model.Command{single, bulk[0]} {
+		saved, err := testDb.Repo.GetCommandById(ctx, cmd.ID)
+		require.NoError(t, err)
+		assert.Equal(t, cmd.Payload, saved.Payload)
+		assert.Equal(t, cmd.SignatureKeyID, saved.SignatureKeyID)
+		assert.Equal(t, cmd.Signature, saved.Signature)
+		require.NotNil(t, saved.NotBefore)
+		assert.True(t, now.Equal(*saved.NotBefore))
+		require.NotNil(t, saved.ExpiresAt)
+		assert.True(t, expiresAt.Equal(*saved.ExpiresAt))
+	}
+
+	unsigned := &model.Command{ID: uuid.New(), RouterID: router.ID, CommandType: "REBOOT", Status: model.StatusPending, CreatedAt: now}
+	require.NoError(t, testDb.Repo.SaveCommand(ctx, unsigned))
+	saved, err := testDb.Repo.GetCommandById(ctx, unsigned.ID)
+	require.NoError(t, err)
+	assert.Empty(t, saved.SignatureKeyID)
+	assert.Nil(t, saved.Signature)
+	assert.Nil(t, saved.NotBefore)
+
+	// delivery stores the signature the command is delivered with
+	delivered, deliveredUntil := now.Add(time.Minute), now.Add(time.Minute+time.Hour)
+	resigned := single
+	resigned.Signature, resigned.NotBefore, resigned.ExpiresAt = []byte{4, 5, 6}, &delivered, &deliveredUntil
+	sent, err := testDb.Repo.MarkCommandsSent(ctx, router.ID, []model.Command{resigned, *unsigned})
+	require.NoError(t, err)
+	require.Len(t, sent, 2)
+
+	saved, err = testDb.Repo.GetCommandById(ctx, single.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusSent, saved.Status)
+	assert.Equal(t, resigned.Signature, saved.Signature)
+	require.NotNil(t, saved.NotBefore)
+	assert.True(t, delivered.Equal(*saved.NotBefore))
+	require.NotNil(t, saved.ExpiresAt)
+	assert.True(t, deliveredUntil.Equal(*saved.ExpiresAt))
+
+	saved, err = testDb.Repo.GetCommandById(ctx, unsigned.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusSent, saved.Status)
+	assert.Empty(t, saved.SignatureKeyID)
+
+	// a command is marked SENT only once
+	sent, err = testDb.Repo.MarkCommandsSent(ctx, router.ID, []model.Command{resigned})
+	require.NoError(t, err)
+	assert.Empty(t, sent)
+}
+
+func TestPostgresRepository_Jobs(t *testing.T) {
+	testDb := testhelper.SetupTestPostgres(t)
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+
+	job := &model.Job{
+		ID: uuid.New(), Kind: model.JobSendCommand, Status: model.JobPending,
+		Request: json.RawMessage(`{"commandType": "REBOOT"}`), Total: 10,
+		CreatedBy: "orchestrator", CreatedAt: now, UpdatedAt: now,
+	}
+	require.NoError(t, testDb.Repo.SaveJob(ctx, job))
+
+	claimed, err := testDb.Repo.ClaimJob(ctx, "worker-1", time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, claimed)
+	assert.Equal(t, job.ID, claimed.ID)
+	assert.Equal(t, model.JobRunning, claimed.Status)
+	assert.Equal(t, "worker-1", claimed.LeaseOwner)
+
+	// leased jobs aren't claimed twice
+	other, err := testDb.Repo.ClaimJob(ctx, "worker-2", time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, other)
+
+	claimed.Processed = 4
+	current, err := testDb.Repo.SaveJobProgress(ctx, claimed, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, model.JobRunning, current)
+
+	cancelling, err := testDb.Repo.CancelJob(ctx, job.ID)
+	require.NoError(t, err)
+	require.NotNil(t, cancelling)
+	assert.Equal(t, model.JobCancelling, cancelling.Status)
+
+	current, err = testDb.Repo.SaveJobProgress(ctx, claimed, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, model.JobCancelling, current)
+
+	// a released job is claimed again right away, with its progress
+	require.NoError(t, testDb.Repo.ReleaseJob(ctx, claimed))
+	resumed, err := testDb.Repo.ClaimJob(ctx, "worker-2", time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, resumed)
+	assert.Equal(t, 4, resumed.Processed)
+	assert.Equal(t, model.JobCancelling, resumed.Status)
+
+	// the previous owner lost the lease
+	_, err = testDb.Repo.SaveJobProgress(ctx, claimed, time.Minute)
+	assert.ErrorIs(t, err, model.ErrJobLeaseLost)
+
+	resumed.Finish(model.ErrJobCancelled, now.Add(time.Minute))
+	require.NoError(t, testDb.Repo.FinishJob(ctx, resumed))
+
+	found, err := testDb.Repo.GetJob(ctx, job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.JobCancelled, found.Status)
+	assert.Equal(t, 4, found.Processed)
+	assert.Equal(t, "orchestrator", found.CreatedBy)
+	assert.Empty(t, found.LeaseOwner)
+	require.NotNil(t, found.FinishedAt)
+
+	// finished jobs can't be cancelled
+	cancelling, err = testDb.Repo.CancelJob(ctx, job.ID)
+	require.NoError(t, err)
+	assert.Nil(t, cancelling)
+
+	pending := &model.Job{
+		ID: uuid.New(), Kind: model.JobSendCommand, Status: model.JobPending,
+		Request: json.RawMessage(`{}`), CreatedAt: now.Add(time.Second), UpdatedAt: now,
+	}
+	require.NoError(t, testDb.Repo.SaveJob(ctx, pending))
+
+	jobs, err := testDb.Repo.ListJobs(ctx, model.JobFilter{Kind: model.JobSendCommand}, nil, 1)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, pending.ID, jobs[0].ID)
+
+	jobs, err = testDb.Repo.ListJobs(ctx, model.JobFilter{}, &model.Cursor{CreatedAt: jobs[0].CreatedAt, ID: jobs[0].ID}, 10)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, job.ID, jobs[0].ID)
+
+	jobs, err = testDb.Repo.ListJobs(ctx, model.JobFilter{Statuses: []string{model.JobCancelled}}, nil, 10)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, job.ID, jobs[0].ID)
+
+	// an idempotent request turned into a job replays the job
+	key := &model.IdempotencyKey{Caller: "orchestrator", Key: "bulk-1", RequestHash: "hash", CreatedAt: now}
+	_, err = testDb.Repo.ReserveIdempotencyKey(ctx, key, now.Add(-time.Hour))
+	require.NoError(t, err)
+	key.JobID = &job.ID
+	require.NoError(t, testDb.Repo.CompleteIdempotencyKey(ctx, key))
+
+	existing, err := testDb.Repo.ReserveIdempotencyKey(ctx, key, now.Add(-time.Hour))
+	require.NoError(t, err)
+	require.NotNil(t, existing.JobID)
+	assert.Equal(t, job.ID, *existing.JobID)
+
+	found, err = testDb.Repo.GetJob(ctx, uuid.New())
+	require.NoError(t, err)
+	assert.Nil(t, found)
+}
+
+func TestPostgresRepository_Approvals(t *testing.T) {
+	testDb := testhelper.SetupTestPostgres(t)
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+
+	router := &model.Router{ID: uuid.New(), SerialNumber: "SN-APPROVAL", CreatedAt: now}
+	require.NoError(t, testDb.Repo.SaveRouter(ctx, router))
+
+	// awaitingApproval saves an approval and two of its commands
+	awaitingApproval := func(expiresAt time.Time) (*model.Approval, []model.Command) {
+		approval := &model.Approval{
+			ID: uuid.New(), CommandType: "FACTORY_RESET", Status: model.ApprovalPending,
+			RequestedBy: "alice", RequestedAt: now, ExpiresAt: expiresAt,
+		}
+		require.NoError(t, testDb.Repo.SaveApproval(ctx, approval))
+
+		var commands []model.Command
+		for i := 0; i < 2; i++ {
+			commands = append(commands, model.Command{
+				ID: uuid.New(), RouterID: router.ID, CommandType: "FACTORY_RESET", Payload: json.RawMessage(`{}`),
+				Status: model.StatusAwaitingApproval, CreatedAt: now, ApprovalID: &approval.ID,
+			})
+		}
+		_, err := testDb.Repo.SaveCommandsCoalesced(ctx, commands, model.CoalesceKeepAll)
+		require.NoError(t, err)
+
+		return approval, commands
+	}
+
+	approved, _ := awaitingApproval(now.Add(time.Hour))
+
+	found, err := testDb.Repo.GetApproval(ctx, approved.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.ApprovalPending, found.Status)
+	assert.Equal(t, "alice", found.RequestedBy)
+	assert.Equal(t, 2, found.Commands)
+
+	decision := model.ApprovalDecision{Approve: true, By: "bob", Reason: "planned", At: now}
+	decided, commands, err := testDb.Repo.DecideApproval(ctx, approved.ID, decision)
+	require.NoError(t, err)
+	require.NotNil(t, decided)
+	assert.Equal(t, model.ApprovalApproved, decided.Status)
+	assert.Equal(t, "bob", decided.DecidedBy)
+	require.Len(t, commands, 2)
+	assert.Equal(t, model.StatusPending, commands[0].Status)
+	assert.Equal(t, approved.ID, *commands[0].ApprovalID)
+
+	// an approval is decided once
+	decided, _, err = testDb.Repo.DecideApproval(ctx, approved.ID, decision)
+	require.NoError(t, err)
+	assert.Nil(t, decided)
+
+	rejected, _ := awaitingApproval(now.Add(time.Hour))
+	decided, commands, err = testDb.Repo.DecideApproval(ctx, rejected.ID,
+		model.ApprovalDecision{By: "bob", Reason: "wrong routers", At: now})
+	require.NoError(t, err)
+	assert.Equal(t, model.ApprovalRejected, decided.Status)
+	require.Len(t, commands, 2)
+	assert.Equal(t, model.StatusCancelled, commands[0].Status)
+	assert.Equal(t, "bob", commands[0].CancelledBy)
+	assert.Equal(t, "approval rejected: wrong routers", commands[0].CancelReason)
+
+	// not decided while a job still creates its commands, and not expired either
+	withJob, _ := awaitingApproval(now.Add(-time.Minute))
+	job := &model.Job{
+		ID: uuid.New(), Kind: model.JobSendCommand, Status: model.JobRunning, Request: json.RawMessage(`{}`),
+		ApprovalID: &withJob.ID, CreatedAt: now, UpdatedAt: now,
+	}
+	require.NoError(t, testDb.Repo.SaveJob(ctx, job))
+
+	decided, _, err = testDb.Repo.DecideApproval(ctx, withJob.ID, model.ApprovalDecision{Approve: true, By: "bob", At: now.Add(-time.Hour)})
+	require.NoError(t, err)
+	assert.Nil(t, decided)
+
+	expired, _ := awaitingApproval(now.Add(-time.Minute))
+
+	// expired approvals can't be decided
+	decided, _, err = testDb.Repo.DecideApproval(ctx, expired.ID, decision)
+	require.NoError(t, err)
+	assert.Nil(t, decided)
+
+	ids, cancelled, err := testDb.Repo.ExpireApprovals(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{expired.ID}, ids)
+	require.Len(t, cancelled, 2)
+	assert.Equal(t, model.StatusCancelled, cancelled[0].Status)
+	assert.Equal(t, model.ApprovalExpiredReason, cancelled[0].CancelReason)
+
+	found, err = testDb.Repo.GetApproval(ctx, expired.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.ApprovalExpired, found.Status)
+
+	found, err = testDb.Repo.GetApproval(ctx, withJob.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.ApprovalPending, found.Status)
+
+	found, err = testDb.Repo.GetApproval(ctx, uuid.New())
+	require.NoError(t, err)
+	assert.Nil(t, found)
+}
+
+func TestPostgresRepository_CampaignApprovals(t *testing.T) {
+	testDb := testhelper.SetupTestPostgres(t)
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+
+	router := &model.Router{ID: uuid.New(), SerialNumber: "SN-CAMPAIGN-APPROVAL", CreatedAt: now}
+	require.NoError(t, testDb.Repo.SaveRouter(ctx, router))
+
+	// awaitingApproval saves an approval and the campaign it holds back
+	awaitingApproval := func(expiresAt time.Time) (*model.Approval, *model.Campaign) {
+		approval := &model.Approval{
+			ID: uuid.New(), CommandType: "UPDATE_FIRMWARE", Status: model.ApprovalPending,
+			RequestedBy: "alice", RequestedAt: now, ExpiresAt: expiresAt,
+		}
+		require.NoError(t, testDb.Repo.SaveApproval(ctx, approval))
+
+		campaign := &model.Campaign{
+			ID: uuid.New(), CommandType: "UPDATE_FIRMWARE", Priority: 50, Payload: json.RawMessage(`{"version":"2.1.0"}`),
+			Status: model.CampaignAwaitingApproval, TotalWaves: 1, CheckedFromWave: 1, MaxFailureRate: 0.1,
+			CreatedBy: "alice", CreatedAt: now, UpdatedAt: now, ApprovalID: &approval.ID,
+		}
+		targets := []model.CampaignTarget{{CampaignID: campaign.ID, RouterID: router.ID, Wave: 1}}
+		require.NoError(t, testDb.Repo.SaveCampaign(ctx, campaign, targets))
+
+		return approval, campaign
+	}
+
+	approved, campaign := awaitingApproval(now.Add(time.Hour))
+
+	found, err := testDb.Repo.GetApproval(ctx, approved.ID)
+	require.NoError(t, err)
+	assert.Equal(t, &campaign.ID, found.CampaignID)
+	assert.Zero(t, found.Commands)
+
+	saved, err := testDb.Repo.GetCampaign(ctx, campaign.ID)
+	require.NoError(t, err)
+	assert.Equal(t, &approved.ID, saved.ApprovalID)
+
+	decided, commands, err := testDb.Repo.DecideApproval(ctx, approved.ID, model.ApprovalDecision{Approve: true, By: "bob", At: now})
+	require.NoError(t, err)
+	require.NotNil(t, decided)
+	assert.Empty(t, commands)
+
+	saved, err = testDb.Repo.GetCampaign(ctx, campaign.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.CampaignRunning, saved.Status)
+	require.NotNil(t, saved.NextWaveAt)
+
+	rejected, campaign := awaitingApproval(now.Add(time.Hour))
+	_, _, err = testDb.Repo.DecideApproval(ctx, rejected.ID, model.ApprovalDecision{By: "bob", Reason: "wrong build", At: now})
+	require.NoError(t, err)
+
+	saved, err = testDb.Repo.GetCampaign(ctx, campaign.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.CampaignAborted, saved.Status)
+	assert.Equal(t, "approval rejected: wrong build", saved.PauseReason)
+
+	_, campaign = awaitingApproval(now.Add(-time.Minute))
+	_, _, err = testDb.Repo.ExpireApprovals(ctx, now)
+	require.NoError(t, err)
+
+	saved, err = testDb.Repo.GetCampaign(ctx, campaign.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.CampaignAborted, saved.Status)
+	assert.Equal(t, model.ApprovalExpiredReason, saved.PauseReason)
+}
+
+// contractRepo exposes the Get* lookups and status changes under the shared
+// contract signatures.
+type contractRepo struct {
+	postgres.PostgresRepo
+}
+
+func (r contractRepo) FindCommandsByRouterId(ctx context.Context, routerId uuid.UUID) ([]model.Command, error) {
+	return r.GetCommandsByRouterId(ctx, routerId)
+}
+
+func (r contractRepo) FindCommandsByStatus(ctx context.Context, routerId uuid.UUID, status string, limit int) ([]model.Command, error) {
+	return r.GetCommandsByRouterIdAndStatus(ctx, routerId, status, limit)
+}
+
+// the contract doesn't check the updated commands, which Redis doesn't return
+func (r contractRepo) ChangeStatusByRouterId(ctx context.Context, routerId uuid.UUID, status string) error {
+	_, err := r.PostgresRepo.ChangeStatusByRouterId(ctx, routerId, status)
+	return err
+}
+
+func (r contractRepo) ChangeStatusByIds(ctx context.Context, routerId uuid.UUID, ids []uuid.UUID, status string) error {
+	_, err := r.PostgresRepo.ChangeStatusByIds(ctx, routerId, ids, status)
+	return err
+}
+
+func TestPostgresRepository_Contract(t *testing.T) {
+	testDb := testhelper.SetupTestPostgres(t)
+
+	contract.RunRepositoryContract(t, func(t *testing.T) contract.Repository {
+		return contractRepo{testDb.Repo}
+	})
+}
+
+func TestPostgresRepository_ApiKeys(t *testing.T) {
+	testDb := testhelper.SetupTestPostgres(t)
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+
+	_, hash, err := model.NewApiKeySecret()
+	require.NoError(t, err)
+	key := &model.ApiKey{
+		ID: uuid.New(), Name: "deploy", Subject: "deploy-bot", Roles: []string{"noc", "helpdesk"},
+		KeyHash: hash, CreatedBy: "root", CreatedAt: now,
+	}
+	require.NoError(t, testDb.Repo.SaveApiKey(ctx, key))
+
+	noRoles := &model.ApiKey{ID: uuid.New(), Name: "ci", Subject: "ci", KeyHash: "other", CreatedAt: now.Add(time.Second)}
+	require.NoError(t, testDb.Repo.SaveApiKey(ctx, noRoles))
+
+	found, err := testDb.Repo.GetApiKeyByHash(ctx, hash)
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, key.ID, found.ID)
+	assert.Equal(t, []string{"noc", "helpdesk"}, found.Roles)
+	assert.True(t, found.Active(now))
+
+	found, err = testDb.Repo.GetApiKeyByHash(ctx, "missing")
+	require.NoError(t, err)
+	assert.Nil(t, found)
+
+	revoked, err := testDb.Repo.RevokeApiKey(ctx, key.ID, "root", now)
+	require.NoError(t, err)
+	require.NotNil(t, revoked)
+	assert.Equal(t, "root", revoked.RevokedBy)
+	assert.False(t, revoked.Active(now))
+
+	// already revoked
+	revoked, err = testDb.Repo.RevokeApiKey(ctx, key.ID, "root", now)
+	require.NoError(t, err)
+	assert.Nil(t, revoked)
+
+	keys, err := testDb.Repo.ListApiKeys(ctx, false)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, noRoles.ID, keys[0].ID)
+
+	keys, err = testDb.Repo.ListApiKeys(ctx, true)
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+}
+
+func TestPostgresRepository_Roles(t *testing.T) {
+	testDb := testhelper.SetupTestPostgres(t)
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+
+	// admin and noc are seeded
+	roles, err := testDb.Repo.ListRoles(ctx)
+	require.NoError(t, err)
+	require.Len(t, roles, 2)
+	assert.Equal(t, "admin", roles[0].Name)
+	assert.Equal(t, []model.Permission{{Methods: []string{"*"}}}, roles[0].Permissions)
+
+	helpdesk := &model.Role{
+		Name: "helpdesk",
+		Permissions: []model.Permission{{
+			Methods:        []string{"CommandService.SendCommand"},
+			CommandTypes:   []string{"REBOOT"},
+			SerialPrefixes: []string{"MSK-"},
+		}},
+		UpdatedBy: "root",
+		UpdatedAt: now,
+	}
+	require.NoError(t, testDb.Repo.SaveRole(ctx, helpdesk))
+
+	helpdesk.Description = "first line support"
+	helpdesk.Permissions[0].SerialPrefixes = append(helpdesk.Permissions[0].SerialPrefixes, "SPB-")
+	require.NoError(t, testDb.Repo.SaveRole(ctx, helpdesk))
+
+	roles, err = testDb.Repo.ListRoles(ctx)
+	require.NoError(t, err)
+	require.Len(t, roles, 3)
+	assert.Equal(t, *helpdesk, roles[1])
+
+	found, err := testDb.Repo.DeleteRole(ctx, "helpdesk")
+	require.NoError(t, err)
+	assert.True(t, found)
+
+	found, err = testDb.Repo.DeleteRole(ctx, "helpdesk")
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestPostgresRepository_RouterCredentials(t *testing.T) {
+	testDb := testhelper.SetupTestPostgres(t)
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+
+	router := &model.Router{ID: uuid.New(), SerialNumber: "SN-1", CreatedAt: now}
+	require.NoError(t, testDb.Repo.SaveRouter(ctx, router))
+
+	_, hash, err := model.NewRouterSecret()
+	require.NoError(t, err)
+	credential := &model.RouterCredential{RouterID: router.ID, SecretHash: hash, IssuedBy: "root", IssuedAt: now}
+	found, err := testDb.Repo.SaveRouterCredential(ctx, credential)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "SN-1", credential.SerialNumber)
+
+	// polls upsert the router without touching its credential
+	now2 := now.Add(time.Minute)
+	router.LastSeenAt = &now2
+	require.NoError(t, testDb.Repo.SaveRouter(ctx, router))
+
+	got, err := testDb.Repo.GetRouterCredentialByHash(ctx, hash)
+	require.NoError(t, err)
+	assert.Equal(t, credential, got)
+
+	// rotation replaces the secret
+	_, rotated, err := model.NewRouterSecret()
+	require.NoError(t, err)
+	_, err = testDb.Repo.SaveRouterCredential(ctx, &model.RouterCredential{RouterID: router.ID, SecretHash: rotated, IssuedAt: now})
+	require.NoError(t, err)
+
+	got, err = testDb.Repo.GetRouterCredentialByHash(ctx, hash)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+
+	revoked, err := testDb.Repo.RevokeRouterCredential(ctx, router.ID)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	got, err = testDb.Repo.GetRouterCredentialByHash(ctx, rotated)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+
+	revoked, err = testDb.Repo.RevokeRouterCredential(ctx, router.ID)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	found, err = testDb.Repo.SaveRouterCredential(ctx, &model.RouterCredential{RouterID: uuid.New(), SecretHash: hash, IssuedAt: now})
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestPostgresRepository_AuditEvents(t *testing.T) {
+	testDb := testhelper.SetupTestPostgres(t)
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+
+	var events []model.AuditEvent
+	for i, actor := range []string{"alice", "bob", "alice"} {
+		events = append(events, model.AuditEvent{
+			OccurredAt: now.Add(time.Duration(i) * time.Minute),
+			Actor:      actor,
+			Action:     "CommandService.SendCommand",
+			Target:     "serial_number=SN-1 command_type=REBOOT",
+			Outcome:    "OK",
+			SourceIP:   "10.0.0.7",
+		})
+	}
+	// a batch is chained to the events before it
+	require.NoError(t, testDb.Repo.AppendAuditEvents(ctx, events[:1]))
+	require.NoError(t, testDb.Repo.AppendAuditEvents(ctx, events[1:]))
+	for _, event := range events {
+		assert.NotZero(t, event.Seq)
+		assert.Equal(t, event.ComputeHash(), event.Hash)
+	}
+	assert.Equal(t, events[0].Hash, events[1].PrevHash)
+	assert.Equal(t, events[1].Hash, events[2].PrevHash)
+
+	exported, err := testDb.Repo.ExportAuditEvents(ctx, model.AuditFilter{}, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, exported, 3)
+	assert.Nil(t, exported[0].PrevHash)
+	assert.NoError(t, model.VerifyAuditChain(nil, exported))
+
+	// tampering breaks the chain
+	tampered := append([]model.AuditEvent(nil), exported...)
+	tampered[1].Actor = "mallory"
+	assert.Error(t, model.VerifyAuditChain(nil, tampered))
+	assert.Error(t, model.VerifyAuditChain(nil, []model.AuditEvent{exported[0], exported[2]}))
+
+	// newest first, filtered and paged by seq
+	listed, err := testDb.Repo.ListAuditEvents(ctx, model.AuditFilter{Actor: "alice"}, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, listed, 2)
+	assert.Equal(t, exported[2], listed[0])
+	assert.Equal(t, exported[0], listed[1])
+
+	to := now.Add(time.Minute)
+	listed, err = testDb.Repo.ListAuditEvents(ctx, model.AuditFilter{OccurredTo: &to}, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, "alice", listed[0].Actor)
+
+	rest, err := testDb.Repo.ExportAuditEvents(ctx, model.AuditFilter{}, exported[0].Seq, 10)
+	require.NoError(t, err)
+	assert.Equal(t, exported[1:], rest)
+
+	// the log is append-only
+	_, err = testDb.Pool.Exec(ctx, `UPDATE audit_events SET actor = 'mallory'`)
+	assert.Error(t, err)
+	_, err = testDb.Pool.Exec(ctx, `DELETE FROM audit_events`)
+	assert.Error(t, err)
+	_, err = testDb.Pool.Exec(ctx, `TRUNCATE audit_events`)
+	assert.Error(t, err)
+}
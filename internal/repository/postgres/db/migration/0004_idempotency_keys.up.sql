@@ -0,0 +1,12 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    caller TEXT NOT NULL,
+    idempotency_key TEXT NOT NULL,
+    request_hash TEXT NOT NULL,
+    command_ids UUID[],
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    PRIMARY KEY (caller, idempotency_key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_created_idx
+    ON idempotency_keys (created_at);
@@ -0,0 +1,6 @@
+-- +migrate Up
+ALTER TABLE commands ADD COLUMN IF NOT EXISTS priority SMALLINT NOT NULL DEFAULT 50;
+
+-- PollCommands: ORDER BY priority DESC, created_at ASC, id ASC
+CREATE INDEX IF NOT EXISTS commands_router_status_priority_idx
+    ON commands (router_id, status, priority DESC, created_at, id);
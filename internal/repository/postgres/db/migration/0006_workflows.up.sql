@@ -0,0 +1,25 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS workflows (
+    id UUID PRIMARY KEY,
+    router_id UUID NOT NULL REFERENCES routers(id),
+    name TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+ALTER TABLE commands
+    ADD COLUMN IF NOT EXISTS workflow_id UUID REFERENCES workflows(id),
+    ADD COLUMN IF NOT EXISTS workflow_step TEXT,
+    ADD COLUMN IF NOT EXISTS error TEXT;
+
+CREATE INDEX IF NOT EXISTS commands_workflow_idx
+    ON commands (workflow_id) WHERE workflow_id IS NOT NULL;
+
+-- a step is released when every command it depends on is ACKED
+CREATE TABLE IF NOT EXISTS command_dependencies (
+    command_id UUID NOT NULL REFERENCES commands(id),
+    depends_on UUID NOT NULL REFERENCES commands(id),
+    PRIMARY KEY (command_id, depends_on)
+);
+
+CREATE INDEX IF NOT EXISTS command_dependencies_depends_on_idx
+    ON command_dependencies (depends_on);
@@ -0,0 +1,23 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS approvals (
+    id UUID PRIMARY KEY,
+    command_type TEXT NOT NULL,
+    status TEXT NOT NULL,
+    requested_by TEXT NOT NULL,
+    requested_at TIMESTAMP NOT NULL DEFAULT now(),
+    expires_at TIMESTAMP NOT NULL,
+    decided_by TEXT,
+    decided_at TIMESTAMP,
+    reason TEXT
+);
+
+CREATE INDEX IF NOT EXISTS approvals_pending_idx
+    ON approvals (expires_at) WHERE status = 'PENDING';
+
+ALTER TABLE commands ADD COLUMN IF NOT EXISTS approval_id UUID REFERENCES approvals(id);
+
+CREATE INDEX IF NOT EXISTS commands_approval_idx
+    ON commands (approval_id) WHERE approval_id IS NOT NULL;
+
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS approval_id UUID REFERENCES approvals(id);
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS approval_id UUID REFERENCES approvals(id);
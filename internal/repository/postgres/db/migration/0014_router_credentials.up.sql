@@ -0,0 +1,7 @@
+-- +migrate Up
+ALTER TABLE routers ADD COLUMN IF NOT EXISTS credential_hash TEXT;
+ALTER TABLE routers ADD COLUMN IF NOT EXISTS credential_issued_by TEXT;
+ALTER TABLE routers ADD COLUMN IF NOT EXISTS credential_issued_at TIMESTAMP;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_routers_credential_hash ON routers (credential_hash)
+    WHERE credential_hash IS NOT NULL;
@@ -0,0 +1,6 @@
+-- +migrate Up
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS payload JSON;
+
+-- campaigns from before payloads could be set sent the default one
+UPDATE campaigns SET payload = json_build_object('command', command_type) WHERE payload IS NULL;
+ALTER TABLE campaigns ALTER COLUMN payload SET NOT NULL;
@@ -0,0 +1,5 @@
+-- +migrate Up
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS approval_id UUID REFERENCES approvals(id);
+
+CREATE INDEX IF NOT EXISTS campaigns_approval_idx
+    ON campaigns (approval_id) WHERE approval_id IS NOT NULL;
@@ -8,9 +8,12 @@ import (
 	context "context"
 	reflect "reflect"
 	model "router-manager/internal/model"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 	uuid "github.com/google/uuid"
+	pgx "github.com/jackc/pgx/v5"
+	pgconn "github.com/jackc/pgx/v5/pgconn"
 )
 
 // MockPostgresRepo is a mock of PostgresRepo interface.
@@ -36,20 +39,232 @@ func (m *MockPostgresRepo) EXPECT() *MockPostgresRepoMockRecorder {
 	return m.recorder
 }
 
-// ChangeStatusByRouterId mocks base method.
-func (m *MockPostgresRepo) ChangeStatusByRouterId(ctx context.Context, routerId uuid.UUID, status string) error {
+// AppendAuditEvents mocks base method.
+func (m *MockPostgresRepo) AppendAuditEvents(ctx context.Context, events []model.AuditEvent) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ChangeStatusByRouterId", ctx, routerId, status)
+	ret := m.ctrl.Call(m, "AppendAuditEvents", ctx, events)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
+// AppendAuditEvents indicates an expected call of AppendAuditEvents.
+func (mr *MockPostgresRepoMockRecorder) AppendAuditEvents(ctx, events interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AppendAuditEvents", reflect.TypeOf((*MockPostgresRepo)(nil).AppendAuditEvents), ctx, events)
+}
+
+// CancelCommands mocks base method.
+func (m *MockPostgresRepo) CancelCommands(ctx context.Context, filter model.CancelFilter, cancellation model.Cancellation) ([]model.Command, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CancelCommands", ctx, filter, cancellation)
+	ret0, _ := ret[0].([]model.Command)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CancelCommands indicates an expected call of CancelCommands.
+func (mr *MockPostgresRepoMockRecorder) CancelCommands(ctx, filter, cancellation interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelCommands", reflect.TypeOf((*MockPostgresRepo)(nil).CancelCommands), ctx, filter, cancellation)
+}
+
+// CancelJob mocks base method.
+func (m *MockPostgresRepo) CancelJob(ctx context.Context, id uuid.UUID) (*model.Job, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CancelJob", ctx, id)
+	ret0, _ := ret[0].(*model.Job)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CancelJob indicates an expected call of CancelJob.
+func (mr *MockPostgresRepoMockRecorder) CancelJob(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelJob", reflect.TypeOf((*MockPostgresRepo)(nil).CancelJob), ctx, id)
+}
+
+// ChangeStatusByIds mocks base method.
+func (m *MockPostgresRepo) ChangeStatusByIds(ctx context.Context, routerId uuid.UUID, ids []uuid.UUID, status string) ([]model.Command, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ChangeStatusByIds", ctx, routerId, ids, status)
+	ret0, _ := ret[0].([]model.Command)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ChangeStatusByIds indicates an expected call of ChangeStatusByIds.
+func (mr *MockPostgresRepoMockRecorder) ChangeStatusByIds(ctx, routerId, ids, status interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ChangeStatusByIds", reflect.TypeOf((*MockPostgresRepo)(nil).ChangeStatusByIds), ctx, routerId, ids, status)
+}
+
+// ChangeStatusByRouterId mocks base method.
+func (m *MockPostgresRepo) ChangeStatusByRouterId(ctx context.Context, routerId uuid.UUID, status string) ([]model.Command, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ChangeStatusByRouterId", ctx, routerId, status)
+	ret0, _ := ret[0].([]model.Command)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
 // ChangeStatusByRouterId indicates an expected call of ChangeStatusByRouterId.
 func (mr *MockPostgresRepoMockRecorder) ChangeStatusByRouterId(ctx, routerId, status interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ChangeStatusByRouterId", reflect.TypeOf((*MockPostgresRepo)(nil).ChangeStatusByRouterId), ctx, routerId, status)
 }
 
+// ClaimJob mocks base method.
+func (m *MockPostgresRepo) ClaimJob(ctx context.Context, owner string, lease time.Duration) (*model.Job, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimJob", ctx, owner, lease)
+	ret0, _ := ret[0].(*model.Job)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimJob indicates an expected call of ClaimJob.
+func (mr *MockPostgresRepoMockRecorder) ClaimJob(ctx, owner, lease interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimJob", reflect.TypeOf((*MockPostgresRepo)(nil).ClaimJob), ctx, owner, lease)
+}
+
+// CompleteIdempotencyKey mocks base method.
+func (m *MockPostgresRepo) CompleteIdempotencyKey(ctx context.Context, key *model.IdempotencyKey) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CompleteIdempotencyKey", ctx, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CompleteIdempotencyKey indicates an expected call of CompleteIdempotencyKey.
+func (mr *MockPostgresRepoMockRecorder) CompleteIdempotencyKey(ctx, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompleteIdempotencyKey", reflect.TypeOf((*MockPostgresRepo)(nil).CompleteIdempotencyKey), ctx, key)
+}
+
+// CountCommandsByStatus mocks base method.
+func (m *MockPostgresRepo) CountCommandsByStatus(ctx context.Context) (map[string]int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountCommandsByStatus", ctx)
+	ret0, _ := ret[0].(map[string]int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CountCommandsByStatus indicates an expected call of CountCommandsByStatus.
+func (mr *MockPostgresRepoMockRecorder) CountCommandsByStatus(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountCommandsByStatus", reflect.TypeOf((*MockPostgresRepo)(nil).CountCommandsByStatus), ctx)
+}
+
+// CountRouters mocks base method.
+func (m *MockPostgresRepo) CountRouters(ctx context.Context, seenSince time.Time) (int64, int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountRouters", ctx, seenSince)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(int64)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// CountRouters indicates an expected call of CountRouters.
+func (mr *MockPostgresRepoMockRecorder) CountRouters(ctx, seenSince interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountRouters", reflect.TypeOf((*MockPostgresRepo)(nil).CountRouters), ctx, seenSince)
+}
+
+// DecideApproval mocks base method.
+func (m *MockPostgresRepo) DecideApproval(ctx context.Context, id uuid.UUID, decision model.ApprovalDecision) (*model.Approval, []model.Command, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DecideApproval", ctx, id, decision)
+	ret0, _ := ret[0].(*model.Approval)
+	ret1, _ := ret[1].([]model.Command)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// DecideApproval indicates an expected call of DecideApproval.
+func (mr *MockPostgresRepoMockRecorder) DecideApproval(ctx, id, decision interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DecideApproval", reflect.TypeOf((*MockPostgresRepo)(nil).DecideApproval), ctx, id, decision)
+}
+
+// DeleteExpiredIdempotencyKeys mocks base method.
+func (m *MockPostgresRepo) DeleteExpiredIdempotencyKeys(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteExpiredIdempotencyKeys", ctx, expiredBefore)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteExpiredIdempotencyKeys indicates an expected call of DeleteExpiredIdempotencyKeys.
+func (mr *MockPostgresRepoMockRecorder) DeleteExpiredIdempotencyKeys(ctx, expiredBefore interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteExpiredIdempotencyKeys", reflect.TypeOf((*MockPostgresRepo)(nil).DeleteExpiredIdempotencyKeys), ctx, expiredBefore)
+}
+
+// DeleteRole mocks base method.
+func (m *MockPostgresRepo) DeleteRole(ctx context.Context, name string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteRole", ctx, name)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteRole indicates an expected call of DeleteRole.
+func (mr *MockPostgresRepoMockRecorder) DeleteRole(ctx, name interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteRole", reflect.TypeOf((*MockPostgresRepo)(nil).DeleteRole), ctx, name)
+}
+
+// ExpireApprovals mocks base method.
+func (m *MockPostgresRepo) ExpireApprovals(ctx context.Context, now time.Time) ([]uuid.UUID, []model.Command, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExpireApprovals", ctx, now)
+	ret0, _ := ret[0].([]uuid.UUID)
+	ret1, _ := ret[1].([]model.Command)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// ExpireApprovals indicates an expected call of ExpireApprovals.
+func (mr *MockPostgresRepoMockRecorder) ExpireApprovals(ctx, now interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExpireApprovals", reflect.TypeOf((*MockPostgresRepo)(nil).ExpireApprovals), ctx, now)
+}
+
+// ExportAuditEvents mocks base method.
+func (m *MockPostgresRepo) ExportAuditEvents(ctx context.Context, filter model.AuditFilter, afterSeq int64, limit int) ([]model.AuditEvent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExportAuditEvents", ctx, filter, afterSeq, limit)
+	ret0, _ := ret[0].([]model.AuditEvent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExportAuditEvents indicates an expected call of ExportAuditEvents.
+func (mr *MockPostgresRepoMockRecorder) ExportAuditEvents(ctx, filter, afterSeq, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportAuditEvents", reflect.TypeOf((*MockPostgresRepo)(nil).ExportAuditEvents), ctx, filter, afterSeq, limit)
+}
+
+// FailCommand mocks base method.
+func (m *MockPostgresRepo) FailCommand(ctx context.Context, routerId, commandId uuid.UUID, reason string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FailCommand", ctx, routerId, commandId, reason)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FailCommand indicates an expected call of FailCommand.
+func (mr *MockPostgresRepoMockRecorder) FailCommand(ctx, routerId, commandId, reason interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FailCommand", reflect.TypeOf((*MockPostgresRepo)(nil).FailCommand), ctx, routerId, commandId, reason)
+}
+
 // FindRouterByRouterId mocks base method.
 func (m *MockPostgresRepo) FindRouterByRouterId(ctx context.Context, id string) (*model.Router, error) {
 	m.ctrl.T.Helper()
@@ -65,6 +280,140 @@ func (mr *MockPostgresRepoMockRecorder) FindRouterByRouterId(ctx, id interface{}
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindRouterByRouterId", reflect.TypeOf((*MockPostgresRepo)(nil).FindRouterByRouterId), ctx, id)
 }
 
+// FindRoutersBySelector mocks base method.
+func (m *MockPostgresRepo) FindRoutersBySelector(ctx context.Context, selector model.RouterSelector) ([]model.Router, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindRoutersBySelector", ctx, selector)
+	ret0, _ := ret[0].([]model.Router)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindRoutersBySelector indicates an expected call of FindRoutersBySelector.
+func (mr *MockPostgresRepoMockRecorder) FindRoutersBySelector(ctx, selector interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindRoutersBySelector", reflect.TypeOf((*MockPostgresRepo)(nil).FindRoutersBySelector), ctx, selector)
+}
+
+// FinishJob mocks base method.
+func (m *MockPostgresRepo) FinishJob(ctx context.Context, job *model.Job) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FinishJob", ctx, job)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// FinishJob indicates an expected call of FinishJob.
+func (mr *MockPostgresRepoMockRecorder) FinishJob(ctx, job interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FinishJob", reflect.TypeOf((*MockPostgresRepo)(nil).FinishJob), ctx, job)
+}
+
+// GetApiKeyByHash mocks base method.
+func (m *MockPostgresRepo) GetApiKeyByHash(ctx context.Context, hash string) (*model.ApiKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetApiKeyByHash", ctx, hash)
+	ret0, _ := ret[0].(*model.ApiKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetApiKeyByHash indicates an expected call of GetApiKeyByHash.
+func (mr *MockPostgresRepoMockRecorder) GetApiKeyByHash(ctx, hash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetApiKeyByHash", reflect.TypeOf((*MockPostgresRepo)(nil).GetApiKeyByHash), ctx, hash)
+}
+
+// GetApproval mocks base method.
+func (m *MockPostgresRepo) GetApproval(ctx context.Context, id uuid.UUID) (*model.Approval, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetApproval", ctx, id)
+	ret0, _ := ret[0].(*model.Approval)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetApproval indicates an expected call of GetApproval.
+func (mr *MockPostgresRepoMockRecorder) GetApproval(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetApproval", reflect.TypeOf((*MockPostgresRepo)(nil).GetApproval), ctx, id)
+}
+
+// GetCampaign mocks base method.
+func (m *MockPostgresRepo) GetCampaign(ctx context.Context, id uuid.UUID) (*model.Campaign, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCampaign", ctx, id)
+	ret0, _ := ret[0].(*model.Campaign)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCampaign indicates an expected call of GetCampaign.
+func (mr *MockPostgresRepoMockRecorder) GetCampaign(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCampaign", reflect.TypeOf((*MockPostgresRepo)(nil).GetCampaign), ctx, id)
+}
+
+// GetCampaignProgress mocks base method.
+func (m *MockPostgresRepo) GetCampaignProgress(ctx context.Context, campaignId uuid.UUID) ([]model.WaveProgress, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCampaignProgress", ctx, campaignId)
+	ret0, _ := ret[0].([]model.WaveProgress)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCampaignProgress indicates an expected call of GetCampaignProgress.
+func (mr *MockPostgresRepoMockRecorder) GetCampaignProgress(ctx, campaignId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCampaignProgress", reflect.TypeOf((*MockPostgresRepo)(nil).GetCampaignProgress), ctx, campaignId)
+}
+
+// GetCampaignTargets mocks base method.
+func (m *MockPostgresRepo) GetCampaignTargets(ctx context.Context, campaignId uuid.UUID, wave int) ([]model.CampaignTarget, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCampaignTargets", ctx, campaignId, wave)
+	ret0, _ := ret[0].([]model.CampaignTarget)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCampaignTargets indicates an expected call of GetCampaignTargets.
+func (mr *MockPostgresRepoMockRecorder) GetCampaignTargets(ctx, campaignId, wave interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCampaignTargets", reflect.TypeOf((*MockPostgresRepo)(nil).GetCampaignTargets), ctx, campaignId, wave)
+}
+
+// GetCampaignsByStatus mocks base method.
+func (m *MockPostgresRepo) GetCampaignsByStatus(ctx context.Context, status string) ([]model.Campaign, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCampaignsByStatus", ctx, status)
+	ret0, _ := ret[0].([]model.Campaign)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCampaignsByStatus indicates an expected call of GetCampaignsByStatus.
+func (mr *MockPostgresRepoMockRecorder) GetCampaignsByStatus(ctx, status interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCampaignsByStatus", reflect.TypeOf((*MockPostgresRepo)(nil).GetCampaignsByStatus), ctx, status)
+}
+
+// GetCommandById mocks base method.
+func (m *MockPostgresRepo) GetCommandById(ctx context.Context, id uuid.UUID) (*model.Command, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCommandById", ctx, id)
+	ret0, _ := ret[0].(*model.Command)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCommandById indicates an expected call of GetCommandById.
+func (mr *MockPostgresRepoMockRecorder) GetCommandById(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCommandById", reflect.TypeOf((*MockPostgresRepo)(nil).GetCommandById), ctx, id)
+}
+
 // GetCommandsByRouterId mocks base method.
 func (m *MockPostgresRepo) GetCommandsByRouterId(ctx context.Context, routerId uuid.UUID) ([]model.Command, error) {
 	m.ctrl.T.Helper()
@@ -80,6 +429,332 @@ func (mr *MockPostgresRepoMockRecorder) GetCommandsByRouterId(ctx, routerId inte
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCommandsByRouterId", reflect.TypeOf((*MockPostgresRepo)(nil).GetCommandsByRouterId), ctx, routerId)
 }
 
+// GetCommandsByRouterIdAndStatus mocks base method.
+func (m *MockPostgresRepo) GetCommandsByRouterIdAndStatus(ctx context.Context, routerId uuid.UUID, status string, limit int) ([]model.Command, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCommandsByRouterIdAndStatus", ctx, routerId, status, limit)
+	ret0, _ := ret[0].([]model.Command)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCommandsByRouterIdAndStatus indicates an expected call of GetCommandsByRouterIdAndStatus.
+func (mr *MockPostgresRepoMockRecorder) GetCommandsByRouterIdAndStatus(ctx, routerId, status, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCommandsByRouterIdAndStatus", reflect.TypeOf((*MockPostgresRepo)(nil).GetCommandsByRouterIdAndStatus), ctx, routerId, status, limit)
+}
+
+// GetCommandsByStatus mocks base method.
+func (m *MockPostgresRepo) GetCommandsByStatus(ctx context.Context, statuses []string) ([]model.Command, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCommandsByStatus", ctx, statuses)
+	ret0, _ := ret[0].([]model.Command)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCommandsByStatus indicates an expected call of GetCommandsByStatus.
+func (mr *MockPostgresRepoMockRecorder) GetCommandsByStatus(ctx, statuses interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCommandsByStatus", reflect.TypeOf((*MockPostgresRepo)(nil).GetCommandsByStatus), ctx, statuses)
+}
+
+// GetJob mocks base method.
+func (m *MockPostgresRepo) GetJob(ctx context.Context, id uuid.UUID) (*model.Job, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetJob", ctx, id)
+	ret0, _ := ret[0].(*model.Job)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetJob indicates an expected call of GetJob.
+func (mr *MockPostgresRepoMockRecorder) GetJob(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetJob", reflect.TypeOf((*MockPostgresRepo)(nil).GetJob), ctx, id)
+}
+
+// GetPendingCommands mocks base method.
+func (m *MockPostgresRepo) GetPendingCommands(ctx context.Context, cmds []model.Command) ([]model.Command, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPendingCommands", ctx, cmds)
+	ret0, _ := ret[0].([]model.Command)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPendingCommands indicates an expected call of GetPendingCommands.
+func (mr *MockPostgresRepoMockRecorder) GetPendingCommands(ctx, cmds interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPendingCommands", reflect.TypeOf((*MockPostgresRepo)(nil).GetPendingCommands), ctx, cmds)
+}
+
+// GetRouterCredentialByHash mocks base method.
+func (m *MockPostgresRepo) GetRouterCredentialByHash(ctx context.Context, hash string) (*model.RouterCredential, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRouterCredentialByHash", ctx, hash)
+	ret0, _ := ret[0].(*model.RouterCredential)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRouterCredentialByHash indicates an expected call of GetRouterCredentialByHash.
+func (mr *MockPostgresRepoMockRecorder) GetRouterCredentialByHash(ctx, hash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRouterCredentialByHash", reflect.TypeOf((*MockPostgresRepo)(nil).GetRouterCredentialByHash), ctx, hash)
+}
+
+// GetWorkflow mocks base method.
+func (m *MockPostgresRepo) GetWorkflow(ctx context.Context, id uuid.UUID) (*model.Workflow, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetWorkflow", ctx, id)
+	ret0, _ := ret[0].(*model.Workflow)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetWorkflow indicates an expected call of GetWorkflow.
+func (mr *MockPostgresRepoMockRecorder) GetWorkflow(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWorkflow", reflect.TypeOf((*MockPostgresRepo)(nil).GetWorkflow), ctx, id)
+}
+
+// ListApiKeys mocks base method.
+func (m *MockPostgresRepo) ListApiKeys(ctx context.Context, includeRevoked bool) ([]model.ApiKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListApiKeys", ctx, includeRevoked)
+	ret0, _ := ret[0].([]model.ApiKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListApiKeys indicates an expected call of ListApiKeys.
+func (mr *MockPostgresRepoMockRecorder) ListApiKeys(ctx, includeRevoked interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListApiKeys", reflect.TypeOf((*MockPostgresRepo)(nil).ListApiKeys), ctx, includeRevoked)
+}
+
+// ListAuditEvents mocks base method.
+func (m *MockPostgresRepo) ListAuditEvents(ctx context.Context, filter model.AuditFilter, beforeSeq int64, limit int) ([]model.AuditEvent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListAuditEvents", ctx, filter, beforeSeq, limit)
+	ret0, _ := ret[0].([]model.AuditEvent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListAuditEvents indicates an expected call of ListAuditEvents.
+func (mr *MockPostgresRepoMockRecorder) ListAuditEvents(ctx, filter, beforeSeq, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAuditEvents", reflect.TypeOf((*MockPostgresRepo)(nil).ListAuditEvents), ctx, filter, beforeSeq, limit)
+}
+
+// ListCommands mocks base method.
+func (m *MockPostgresRepo) ListCommands(ctx context.Context, filter model.CommandFilter, after *model.Cursor, limit int) ([]model.Command, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListCommands", ctx, filter, after, limit)
+	ret0, _ := ret[0].([]model.Command)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListCommands indicates an expected call of ListCommands.
+func (mr *MockPostgresRepoMockRecorder) ListCommands(ctx, filter, after, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListCommands", reflect.TypeOf((*MockPostgresRepo)(nil).ListCommands), ctx, filter, after, limit)
+}
+
+// ListJobs mocks base method.
+func (m *MockPostgresRepo) ListJobs(ctx context.Context, filter model.JobFilter, after *model.Cursor, limit int) ([]model.Job, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListJobs", ctx, filter, after, limit)
+	ret0, _ := ret[0].([]model.Job)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListJobs indicates an expected call of ListJobs.
+func (mr *MockPostgresRepoMockRecorder) ListJobs(ctx, filter, after, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListJobs", reflect.TypeOf((*MockPostgresRepo)(nil).ListJobs), ctx, filter, after, limit)
+}
+
+// ListRoles mocks base method.
+func (m *MockPostgresRepo) ListRoles(ctx context.Context) ([]model.Role, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListRoles", ctx)
+	ret0, _ := ret[0].([]model.Role)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListRoles indicates an expected call of ListRoles.
+func (mr *MockPostgresRepoMockRecorder) ListRoles(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListRoles", reflect.TypeOf((*MockPostgresRepo)(nil).ListRoles), ctx)
+}
+
+// MarkCommandsSent mocks base method.
+func (m *MockPostgresRepo) MarkCommandsSent(ctx context.Context, routerId uuid.UUID, cmds []model.Command) ([]model.Command, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkCommandsSent", ctx, routerId, cmds)
+	ret0, _ := ret[0].([]model.Command)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MarkCommandsSent indicates an expected call of MarkCommandsSent.
+func (mr *MockPostgresRepoMockRecorder) MarkCommandsSent(ctx, routerId, cmds interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkCommandsSent", reflect.TypeOf((*MockPostgresRepo)(nil).MarkCommandsSent), ctx, routerId, cmds)
+}
+
+// ReleaseCampaignWave mocks base method.
+func (m *MockPostgresRepo) ReleaseCampaignWave(ctx context.Context, campaignId uuid.UUID, wave int, nextWaveAt *time.Time, commands []model.Command) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReleaseCampaignWave", ctx, campaignId, wave, nextWaveAt, commands)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReleaseCampaignWave indicates an expected call of ReleaseCampaignWave.
+func (mr *MockPostgresRepoMockRecorder) ReleaseCampaignWave(ctx, campaignId, wave, nextWaveAt, commands interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReleaseCampaignWave", reflect.TypeOf((*MockPostgresRepo)(nil).ReleaseCampaignWave), ctx, campaignId, wave, nextWaveAt, commands)
+}
+
+// ReleaseIdempotencyKey mocks base method.
+func (m *MockPostgresRepo) ReleaseIdempotencyKey(ctx context.Context, key *model.IdempotencyKey) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReleaseIdempotencyKey", ctx, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReleaseIdempotencyKey indicates an expected call of ReleaseIdempotencyKey.
+func (mr *MockPostgresRepoMockRecorder) ReleaseIdempotencyKey(ctx, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReleaseIdempotencyKey", reflect.TypeOf((*MockPostgresRepo)(nil).ReleaseIdempotencyKey), ctx, key)
+}
+
+// ReleaseJob mocks base method.
+func (m *MockPostgresRepo) ReleaseJob(ctx context.Context, job *model.Job) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReleaseJob", ctx, job)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReleaseJob indicates an expected call of ReleaseJob.
+func (mr *MockPostgresRepoMockRecorder) ReleaseJob(ctx, job interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReleaseJob", reflect.TypeOf((*MockPostgresRepo)(nil).ReleaseJob), ctx, job)
+}
+
+// ReserveIdempotencyKey mocks base method.
+func (m *MockPostgresRepo) ReserveIdempotencyKey(ctx context.Context, key *model.IdempotencyKey, expiredBefore time.Time) (*model.IdempotencyKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReserveIdempotencyKey", ctx, key, expiredBefore)
+	ret0, _ := ret[0].(*model.IdempotencyKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReserveIdempotencyKey indicates an expected call of ReserveIdempotencyKey.
+func (mr *MockPostgresRepoMockRecorder) ReserveIdempotencyKey(ctx, key, expiredBefore interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReserveIdempotencyKey", reflect.TypeOf((*MockPostgresRepo)(nil).ReserveIdempotencyKey), ctx, key, expiredBefore)
+}
+
+// ResolveWorkflows mocks base method.
+func (m *MockPostgresRepo) ResolveWorkflows(ctx context.Context, routerId uuid.UUID, cancellation model.Cancellation) ([]model.Command, []model.Command, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResolveWorkflows", ctx, routerId, cancellation)
+	ret0, _ := ret[0].([]model.Command)
+	ret1, _ := ret[1].([]model.Command)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// ResolveWorkflows indicates an expected call of ResolveWorkflows.
+func (mr *MockPostgresRepoMockRecorder) ResolveWorkflows(ctx, routerId, cancellation interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResolveWorkflows", reflect.TypeOf((*MockPostgresRepo)(nil).ResolveWorkflows), ctx, routerId, cancellation)
+}
+
+// RevokeApiKey mocks base method.
+func (m *MockPostgresRepo) RevokeApiKey(ctx context.Context, id uuid.UUID, by string, at time.Time) (*model.ApiKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeApiKey", ctx, id, by, at)
+	ret0, _ := ret[0].(*model.ApiKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RevokeApiKey indicates an expected call of RevokeApiKey.
+func (mr *MockPostgresRepoMockRecorder) RevokeApiKey(ctx, id, by, at interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeApiKey", reflect.TypeOf((*MockPostgresRepo)(nil).RevokeApiKey), ctx, id, by, at)
+}
+
+// RevokeRouterCredential mocks base method.
+func (m *MockPostgresRepo) RevokeRouterCredential(ctx context.Context, routerId uuid.UUID) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeRouterCredential", ctx, routerId)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RevokeRouterCredential indicates an expected call of RevokeRouterCredential.
+func (mr *MockPostgresRepoMockRecorder) RevokeRouterCredential(ctx, routerId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeRouterCredential", reflect.TypeOf((*MockPostgresRepo)(nil).RevokeRouterCredential), ctx, routerId)
+}
+
+// SaveApiKey mocks base method.
+func (m *MockPostgresRepo) SaveApiKey(ctx context.Context, key *model.ApiKey) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveApiKey", ctx, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveApiKey indicates an expected call of SaveApiKey.
+func (mr *MockPostgresRepoMockRecorder) SaveApiKey(ctx, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveApiKey", reflect.TypeOf((*MockPostgresRepo)(nil).SaveApiKey), ctx, key)
+}
+
+// SaveApproval mocks base method.
+func (m *MockPostgresRepo) SaveApproval(ctx context.Context, approval *model.Approval) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveApproval", ctx, approval)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveApproval indicates an expected call of SaveApproval.
+func (mr *MockPostgresRepoMockRecorder) SaveApproval(ctx, approval interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveApproval", reflect.TypeOf((*MockPostgresRepo)(nil).SaveApproval), ctx, approval)
+}
+
+// SaveCampaign mocks base method.
+func (m *MockPostgresRepo) SaveCampaign(ctx context.Context, campaign *model.Campaign, targets []model.CampaignTarget) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveCampaign", ctx, campaign, targets)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveCampaign indicates an expected call of SaveCampaign.
+func (mr *MockPostgresRepoMockRecorder) SaveCampaign(ctx, campaign, targets interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveCampaign", reflect.TypeOf((*MockPostgresRepo)(nil).SaveCampaign), ctx, campaign, targets)
+}
+
 // SaveCommand mocks base method.
 func (m *MockPostgresRepo) SaveCommand(ctx context.Context, cmd *model.Command) error {
 	m.ctrl.T.Helper()
@@ -94,6 +769,79 @@ func (mr *MockPostgresRepoMockRecorder) SaveCommand(ctx, cmd interface{}) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveCommand", reflect.TypeOf((*MockPostgresRepo)(nil).SaveCommand), ctx, cmd)
 }
 
+// SaveCommandCoalesced mocks base method.
+func (m *MockPostgresRepo) SaveCommandCoalesced(ctx context.Context, cmd *model.Command, policy string) (*model.CoalesceResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveCommandCoalesced", ctx, cmd, policy)
+	ret0, _ := ret[0].(*model.CoalesceResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SaveCommandCoalesced indicates an expected call of SaveCommandCoalesced.
+func (mr *MockPostgresRepoMockRecorder) SaveCommandCoalesced(ctx, cmd, policy interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveCommandCoalesced", reflect.TypeOf((*MockPostgresRepo)(nil).SaveCommandCoalesced), ctx, cmd, policy)
+}
+
+// SaveCommandsCoalesced mocks base method.
+func (m *MockPostgresRepo) SaveCommandsCoalesced(ctx context.Context, cmds []model.Command, policy string) ([]*model.CoalesceResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveCommandsCoalesced", ctx, cmds, policy)
+	ret0, _ := ret[0].([]*model.CoalesceResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SaveCommandsCoalesced indicates an expected call of SaveCommandsCoalesced.
+func (mr *MockPostgresRepoMockRecorder) SaveCommandsCoalesced(ctx, cmds, policy interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveCommandsCoalesced", reflect.TypeOf((*MockPostgresRepo)(nil).SaveCommandsCoalesced), ctx, cmds, policy)
+}
+
+// SaveJob mocks base method.
+func (m *MockPostgresRepo) SaveJob(ctx context.Context, job *model.Job) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveJob", ctx, job)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveJob indicates an expected call of SaveJob.
+func (mr *MockPostgresRepoMockRecorder) SaveJob(ctx, job interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveJob", reflect.TypeOf((*MockPostgresRepo)(nil).SaveJob), ctx, job)
+}
+
+// SaveJobProgress mocks base method.
+func (m *MockPostgresRepo) SaveJobProgress(ctx context.Context, job *model.Job, lease time.Duration) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveJobProgress", ctx, job, lease)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SaveJobProgress indicates an expected call of SaveJobProgress.
+func (mr *MockPostgresRepoMockRecorder) SaveJobProgress(ctx, job, lease interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveJobProgress", reflect.TypeOf((*MockPostgresRepo)(nil).SaveJobProgress), ctx, job, lease)
+}
+
+// SaveRole mocks base method.
+func (m *MockPostgresRepo) SaveRole(ctx context.Context, role *model.Role) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveRole", ctx, role)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveRole indicates an expected call of SaveRole.
+func (mr *MockPostgresRepoMockRecorder) SaveRole(ctx, role interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveRole", reflect.TypeOf((*MockPostgresRepo)(nil).SaveRole), ctx, role)
+}
+
 // SaveRouter mocks base method.
 func (m *MockPostgresRepo) SaveRouter(ctx context.Context, router *model.Router) error {
 	m.ctrl.T.Helper()
@@ -107,3 +855,147 @@ func (mr *MockPostgresRepoMockRecorder) SaveRouter(ctx, router interface{}) *gom
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveRouter", reflect.TypeOf((*MockPostgresRepo)(nil).SaveRouter), ctx, router)
 }
+
+// SaveRouterCredential mocks base method.
+func (m *MockPostgresRepo) SaveRouterCredential(ctx context.Context, credential *model.RouterCredential) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveRouterCredential", ctx, credential)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SaveRouterCredential indicates an expected call of SaveRouterCredential.
+func (mr *MockPostgresRepoMockRecorder) SaveRouterCredential(ctx, credential interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveRouterCredential", reflect.TypeOf((*MockPostgresRepo)(nil).SaveRouterCredential), ctx, credential)
+}
+
+// SaveRouters mocks base method.
+func (m *MockPostgresRepo) SaveRouters(ctx context.Context, routers []model.Router) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveRouters", ctx, routers)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveRouters indicates an expected call of SaveRouters.
+func (mr *MockPostgresRepoMockRecorder) SaveRouters(ctx, routers interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveRouters", reflect.TypeOf((*MockPostgresRepo)(nil).SaveRouters), ctx, routers)
+}
+
+// SaveWorkflow mocks base method.
+func (m *MockPostgresRepo) SaveWorkflow(ctx context.Context, workflow *model.Workflow) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveWorkflow", ctx, workflow)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveWorkflow indicates an expected call of SaveWorkflow.
+func (mr *MockPostgresRepoMockRecorder) SaveWorkflow(ctx, workflow interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveWorkflow", reflect.TypeOf((*MockPostgresRepo)(nil).SaveWorkflow), ctx, workflow)
+}
+
+// TransitionCampaign mocks base method.
+func (m *MockPostgresRepo) TransitionCampaign(ctx context.Context, id uuid.UUID, from []string, status, reason string) (*model.Campaign, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TransitionCampaign", ctx, id, from, status, reason)
+	ret0, _ := ret[0].(*model.Campaign)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TransitionCampaign indicates an expected call of TransitionCampaign.
+func (mr *MockPostgresRepoMockRecorder) TransitionCampaign(ctx, id, from, status, reason interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TransitionCampaign", reflect.TypeOf((*MockPostgresRepo)(nil).TransitionCampaign), ctx, id, from, status, reason)
+}
+
+// Mockexecer is a mock of execer interface.
+type Mockexecer struct {
+	ctrl     *gomock.Controller
+	recorder *MockexecerMockRecorder
+}
+
+// MockexecerMockRecorder is the mock recorder for Mockexecer.
+type MockexecerMockRecorder struct {
+	mock *Mockexecer
+}
+
+// NewMockexecer creates a new mock instance.
+func NewMockexecer(ctrl *gomock.Controller) *Mockexecer {
+	mock := &Mockexecer{ctrl: ctrl}
+	mock.recorder = &MockexecerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *Mockexecer) EXPECT() *MockexecerMockRecorder {
+	return m.recorder
+}
+
+// Exec mocks base method.
+func (m *Mockexecer) Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, sql}
+	for _, a := range arguments {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Exec", varargs...)
+	ret0, _ := ret[0].(pgconn.CommandTag)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Exec indicates an expected call of Exec.
+func (mr *MockexecerMockRecorder) Exec(ctx, sql interface{}, arguments ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, sql}, arguments...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Exec", reflect.TypeOf((*Mockexecer)(nil).Exec), varargs...)
+}
+
+// Mockquerier is a mock of querier interface.
+type Mockquerier struct {
+	ctrl     *gomock.Controller
+	recorder *MockquerierMockRecorder
+}
+
+// MockquerierMockRecorder is the mock recorder for Mockquerier.
+type MockquerierMockRecorder struct {
+	mock *Mockquerier
+}
+
+// NewMockquerier creates a new mock instance.
+func NewMockquerier(ctrl *gomock.Controller) *Mockquerier {
+	mock := &Mockquerier{ctrl: ctrl}
+	mock.recorder = &MockquerierMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *Mockquerier) EXPECT() *MockquerierMockRecorder {
+	return m.recorder
+}
+
+// Query mocks base method.
+func (m *Mockquerier) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, sql}
+	for _, a := range args {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Query", varargs...)
+	ret0, _ := ret[0].(pgx.Rows)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Query indicates an expected call of Query.
+func (mr *MockquerierMockRecorder) Query(ctx, sql interface{}, args ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, sql}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Query", reflect.TypeOf((*Mockquerier)(nil).Query), varargs...)
+}
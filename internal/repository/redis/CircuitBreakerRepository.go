@@ -0,0 +1,173 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"router-manager/internal/model"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrCacheUnavailable is returned instead of calling Redis while the cache is unhealthy.
+var ErrCacheUnavailable = errors.New("redis cache is unavailable")
+
+// CacheHealth is the circuit state shared with the background health watcher.
+// It decides how many consecutive failures open the circuit.
+type CacheHealth interface {
+	// Healthy reports whether the cache may be read.
+	Healthy() bool
+	// Writable reports whether the cache may be written to: while it is
+	// rebuilt it is, though it isn't read yet.
+	Writable() bool
+	ReportFailure(err error)
+	ReportSuccess()
+}
+
+// CircuitBreakerRepository skips Redis entirely while the cache is unhealthy,
+// except for writes while it is rebuilt, and reports connection errors to the
+// circuit.
+type CircuitBreakerRepository struct {
+	repo   RedisRepo
+	health CacheHealth
+}
+
+func NewCircuitBreakerRepository(repo RedisRepo, health CacheHealth) RedisRepo {
+	return &CircuitBreakerRepository{repo: repo, health: health}
+}
+
+func (r *CircuitBreakerRepository) SaveCommand(ctx context.Context, command *model.Command) error {
+	if !r.health.Writable() {
+		return ErrCacheUnavailable
+	}
+	return r.check(ctx, r.repo.SaveCommand(ctx, command))
+}
+
+func (r *CircuitBreakerRepository) SaveCommands(ctx context.Context, commands []model.Command) error {
+	if !r.health.Writable() {
+		return ErrCacheUnavailable
+	}
+	return r.check(ctx, r.repo.SaveCommands(ctx, commands))
+}
+
+func (r *CircuitBreakerRepository) FindCommandsByRouterId(ctx context.Context, routerId uuid.UUID) ([]model.Command, error) {
+	if !r.health.Healthy() {
+		return nil, ErrCacheUnavailable
+	}
+	commands, err := r.repo.FindCommandsByRouterId(ctx, routerId)
+	return commands, r.check(ctx, err)
+}
+
+func (r *CircuitBreakerRepository) FindCommandsByStatus(ctx context.Context, routerId uuid.UUID, status string, limit int) ([]model.Command, error) {
+	if !r.health.Healthy() {
+		return nil, ErrCacheUnavailable
+	}
+	commands, err := r.repo.FindCommandsByStatus(ctx, routerId, status, limit)
+	return commands, r.check(ctx, err)
+}
+
+func (r *CircuitBreakerRepository) ChangeStatusByIds(ctx context.Context, routerId uuid.UUID, ids []uuid.UUID, status string) error {
+	if !r.health.Writable() {
+		return ErrCacheUnavailable
+	}
+	return r.check(ctx, r.repo.ChangeStatusByIds(ctx, routerId, ids, status))
+}
+
+func (r *CircuitBreakerRepository) FailCommand(ctx context.Context, routerId, commandId uuid.UUID, reason string) error {
+	if !r.health.Writable() {
+		return ErrCacheUnavailable
+	}
+	return r.check(ctx, r.repo.FailCommand(ctx, routerId, commandId, reason))
+}
+
+func (r *CircuitBreakerRepository) ChangeStatusByRouterId(ctx context.Context, routerId uuid.UUID, status string) error {
+	if !r.health.Writable() {
+		return ErrCacheUnavailable
+	}
+	return r.check(ctx, r.repo.ChangeStatusByRouterId(ctx, routerId, status))
+}
+
+func (r *CircuitBreakerRepository) UpdateCommands(ctx context.Context, commands []model.Command) error {
+	if !r.health.Writable() {
+		return ErrCacheUnavailable
+	}
+	return r.check(ctx, r.repo.UpdateCommands(ctx, commands))
+}
+
+func (r *CircuitBreakerRepository) SaveCommandCoalesced(ctx context.Context, command *model.Command, policy string) (*model.CoalesceResult, error) {
+	if !r.health.Writable() {
+		return nil, ErrCacheUnavailable
+	}
+	result, err := r.repo.SaveCommandCoalesced(ctx, command, policy)
+	return result, r.check(ctx, err)
+}
+
+func (r *CircuitBreakerRepository) SaveRouter(ctx context.Context, router *model.Router) error {
+	if !r.health.Writable() {
+		return ErrCacheUnavailable
+	}
+	return r.check(ctx, r.repo.SaveRouter(ctx, router))
+}
+
+func (r *CircuitBreakerRepository) SaveRouters(ctx context.Context, routers []model.Router) error {
+	if !r.health.Writable() {
+		return ErrCacheUnavailable
+	}
+	return r.check(ctx, r.repo.SaveRouters(ctx, routers))
+}
+
+func (r *CircuitBreakerRepository) FindRouterByRouterId(ctx context.Context, id string) (*model.Router, error) {
+	if !r.health.Healthy() {
+		return nil, ErrCacheUnavailable
+	}
+	router, err := r.repo.FindRouterByRouterId(ctx, id)
+	return router, r.check(ctx, err)
+}
+
+func (r *CircuitBreakerRepository) ResyncCommands(ctx context.Context, commands []model.Command, since time.Time) error {
+	if !r.health.Writable() {
+		return ErrCacheUnavailable
+	}
+	return r.check(ctx, r.repo.ResyncCommands(ctx, commands, since))
+}
+
+func (r *CircuitBreakerRepository) check(ctx context.Context, err error) error {
+	report(ctx, r.health, err)
+	return err
+}
+
+// report tells the circuit whether Redis answered. A call that ran out of
+// the caller's own time says nothing about Redis: a slow client must not
+// open the circuit for the whole instance.
+func report(ctx context.Context, health CacheHealth, err error) {
+	switch {
+	case ctx.Err() != nil:
+	case isConnectionError(err):
+		health.ReportFailure(err)
+	default:
+		// an answer, even an error one, means Redis is reachable
+		health.ReportSuccess()
+	}
+}
+
+// IsUnavailable reports whether err means the cache was skipped or unreachable,
+// as opposed to a bad request against a working cache.
+func IsUnavailable(err error) bool {
+	return errors.Is(err, ErrCacheUnavailable) || isConnectionError(err)
+}
+
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	// timeouts of the client's own dial, read and write deadlines are
+	// net.Errors; the context errors of the caller are not
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, redis.ErrClosed)
+}
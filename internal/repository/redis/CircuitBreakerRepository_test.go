@@ -0,0 +1,149 @@
+package redis_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"router-manager/internal/model"
+	"router-manager/internal/repository/redis"
+	"router-manager/internal/repository/redis/mocks"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeHealth struct {
+	healthy   bool
+	resyncing bool
+	failures  int
+	successes int
+}
+
+func (h *fakeHealth) Healthy() bool { return h.healthy }
+
+func (h *fakeHealth) Writable() bool { return h.healthy || h.resyncing }
+
+func (h *fakeHealth) ReportFailure(err error) {
+	h.healthy = false
+	h.failures++
+}
+
+func (h *fakeHealth) ReportSuccess() {
+	h.successes++
+}
+
+func TestCircuitBreaker_SkipsRedisWhenUnhealthy(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockRedis := mocks.NewMockRedisRepo(ctrl)
+	health := &fakeHealth{healthy: false}
+
+	repo := redis.NewCircuitBreakerRepository(mockRedis, health)
+
+	err := repo.SaveCommand(context.Background(), &model.Command{ID: uuid.New()})
+	assert.ErrorIs(t, err, redis.ErrCacheUnavailable)
+
+	commands, err := repo.FindCommandsByRouterId(context.Background(), uuid.New())
+	assert.ErrorIs(t, err, redis.ErrCacheUnavailable)
+	assert.Nil(t, commands)
+}
+
+func TestCircuitBreaker_WritesWhileResyncing(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockRedis := mocks.NewMockRedisRepo(ctrl)
+	health := &fakeHealth{resyncing: true}
+
+	repo := redis.NewCircuitBreakerRepository(mockRedis, health)
+	command := &model.Command{ID: uuid.New(), RouterID: uuid.New()}
+
+	// writes made during the rebuild mustn't be lost...
+	mockRedis.EXPECT().SaveCommand(gomock.Any(), command).Return(nil).Times(1)
+	assert.NoError(t, repo.SaveCommand(context.Background(), command))
+
+	// ...but the half-built cache isn't read
+	_, err := repo.FindCommandsByStatus(context.Background(), command.RouterID, "PENDING", 0)
+	assert.ErrorIs(t, err, redis.ErrCacheUnavailable)
+	_, err = repo.FindRouterByRouterId(context.Background(), command.RouterID.String())
+	assert.ErrorIs(t, err, redis.ErrCacheUnavailable)
+}
+
+func TestCircuitBreaker_OpensOnConnectionError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockRedis := mocks.NewMockRedisRepo(ctrl)
+	health := &fakeHealth{healthy: true}
+
+	repo := redis.NewCircuitBreakerRepository(mockRedis, health)
+	routerId := uuid.New()
+
+	mockRedis.EXPECT().
+		FindCommandsByRouterId(gomock.Any(), routerId).
+		Return(nil, io.EOF).
+		Times(1)
+
+	_, err := repo.FindCommandsByRouterId(context.Background(), routerId)
+	assert.True(t, redis.IsUnavailable(err))
+	assert.False(t, health.Healthy())
+
+	// the next call doesn't reach Redis at all
+	_, err = repo.FindCommandsByRouterId(context.Background(), routerId)
+	assert.ErrorIs(t, err, redis.ErrCacheUnavailable)
+	assert.Equal(t, 1, health.failures)
+}
+
+func TestCircuitBreaker_KeepsClosedOnQueryError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockRedis := mocks.NewMockRedisRepo(ctrl)
+	health := &fakeHealth{healthy: true}
+
+	repo := redis.NewCircuitBreakerRepository(mockRedis, health)
+	routerId := uuid.New()
+
+	mockRedis.EXPECT().
+		ChangeStatusByRouterId(gomock.Any(), routerId, "SENT").
+		Return(fmt.Errorf("wrong type of query")).
+		Times(1)
+
+	err := repo.ChangeStatusByRouterId(context.Background(), routerId, "SENT")
+	assert.Error(t, err)
+	assert.False(t, redis.IsUnavailable(err))
+	assert.True(t, health.Healthy())
+	// Redis answered
+	assert.Equal(t, 1, health.successes)
+}
+
+func TestCircuitBreaker_IgnoresCallerDeadline(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockRedis := mocks.NewMockRedisRepo(ctrl)
+	health := &fakeHealth{healthy: true}
+
+	repo := redis.NewCircuitBreakerRepository(mockRedis, health)
+	routerId := uuid.New()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+
+	// the client gives up when the caller's deadline passes, whatever the
+	// error it reports
+	mockRedis.EXPECT().
+		FindCommandsByRouterId(gomock.Any(), routerId).
+		Return(nil, &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}).
+		Times(1)
+	mockRedis.EXPECT().
+		FindCommandsByStatus(gomock.Any(), routerId, "PENDING", 0).
+		Return(nil, ctx.Err()).
+		Times(1)
+
+	_, err := repo.FindCommandsByRouterId(ctx, routerId)
+	assert.Error(t, err)
+	_, err = repo.FindCommandsByStatus(ctx, routerId, "PENDING", 0)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	assert.True(t, health.Healthy())
+	assert.Zero(t, health.failures)
+	assert.Zero(t, health.successes)
+}
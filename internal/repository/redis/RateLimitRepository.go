@@ -0,0 +1,80 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimitRepo keeps token buckets shared by every instance.
+type RateLimitRepo interface {
+	TakeToken(ctx context.Context, key string, rate float64, burst int) (allowed bool, retryAfter time.Duration, err error)
+}
+
+// A bucket is the hash ratelimit:<key> of its tokens and the time they were
+// counted at, in Redis microseconds so that the clocks of the instances
+// don't matter. It expires once it would be full again.
+var takeToken = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'at')
+local tokens = tonumber(bucket[1])
+local at = tonumber(bucket[2])
+if tokens == nil or at == nil then
+	tokens = burst
+	at = now
+end
+tokens = math.min(burst, tokens + math.max(0, now - at) * rate / 1000000)
+
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) * 1000000 / rate)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'at', string.format('%.0f', now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+return {allowed, wait}
+`)
+
+// RateLimitRepository is skipped while the cache is unhealthy and
+// reports connection errors to the circuit, like CircuitBreakerRepository.
+type RateLimitRepository struct {
+	client *redis.Client
+	health CacheHealth
+}
+
+func NewRateLimitRepository(client *redis.Client, health CacheHealth) RateLimitRepo {
+	return &RateLimitRepository{client: client, health: health}
+}
+
+// TakeToken takes a token from the bucket of key, which holds up to burst
+// tokens and gains rate of them per second. If it is empty, retryAfter is
+// how long until it isn't.
+func (r *RateLimitRepository) TakeToken(ctx context.Context, key string, rate float64, burst int) (bool, time.Duration, error) {
+	if r.health != nil && !r.health.Healthy() {
+		return false, 0, ErrCacheUnavailable
+	}
+
+	result, err := takeToken.Run(ctx, r.client, []string{"ratelimit:" + key}, rate, burst).Int64Slice()
+	if r.health != nil {
+		report(ctx, r.health, err)
+	}
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to take rate limit token: %w", err)
+	}
+	if len(result) != 2 {
+		return false, 0, fmt.Errorf("unexpected rate limit reply %v", result)
+	}
+
+	return result[0] == 1, time.Duration(result[1]) * time.Microsecond, nil
+}
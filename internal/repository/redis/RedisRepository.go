@@ -4,7 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"router-manager/internal/model"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,105 +16,502 @@ import (
 
 type RedisRepo interface {
 	SaveCommand(ctx context.Context, command *model.Command) error
+	SaveCommands(ctx context.Context, commands []model.Command) error
 	FindCommandsByRouterId(ctx context.Context, routerId uuid.UUID) ([]model.Command, error)
+	FindCommandsByStatus(ctx context.Context, routerId uuid.UUID, status string, limit int) ([]model.Command, error)
 	ChangeStatusByRouterId(ctx context.Context, routerId uuid.UUID, status string) error
+	ChangeStatusByIds(ctx context.Context, routerId uuid.UUID, ids []uuid.UUID, status string) error
+	FailCommand(ctx context.Context, routerId, commandId uuid.UUID, reason string) error
+	UpdateCommands(ctx context.Context, commands []model.Command) error
+	SaveCommandCoalesced(ctx context.Context, command *model.Command, policy string) (*model.CoalesceResult, error)
 	SaveRouter(ctx context.Context, router *model.Router) error
+	SaveRouters(ctx context.Context, routers []model.Router) error
 	FindRouterByRouterId(ctx context.Context, id string) (*model.Router, error)
+	ResyncCommands(ctx context.Context, commands []model.Command, since time.Time) error
 }
 
+// how many times an optimistic transaction is retried on concurrent writes
+const maxTxRetries = 10
+
 type RedisRepository struct {
 	client *redis.Client
+	log    *slog.Logger
+}
+
+// Option configures optional RedisRepository settings.
+type Option func(r *RedisRepository)
+
+// WithLogger sets the logger of the repository, slog's default one otherwise.
+func WithLogger(logger *slog.Logger) Option {
+	return func(r *RedisRepository) {
+		r.log = logger
+	}
 }
 
-func NewRedisRepository(client *redis.Client) RedisRepo {
-	return &RedisRepository{client: client}
+func NewRedisRepository(client *redis.Client, opts ...Option) RedisRepo {
+	r := &RedisRepository{client: client, log: slog.Default()}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
 /* --- work with commmands table --- */
 
-func (r *RedisRepository) SaveCommand(ctx context.Context, command *model.Command) error {
-	data, err := json.Marshal(command)
-	if err != nil {
-		return err
+// Commands of a router are kept in the hash command:<routerId> by id. The
+// PENDING and CANCELLING ones are also indexed in the sorted sets
+// command:<routerId>:<status> in delivery order: the score is the inverted
+// priority and members of equal score, "<created_at nanos>:<id>", sort by
+// creation time.
+
+// statuses that have a delivery index
+var indexedStatuses = []string{model.StatusPending, model.StatusCancelling}
+
+func commandsKey(routerId uuid.UUID) string {
+	return "command:" + routerId.String()
+}
+
+func statusKey(routerId uuid.UUID, status string) string {
+	return commandsKey(routerId) + ":" + status
+}
+
+func isIndexed(status string) bool {
+	for _, indexed := range indexedStatuses {
+		if status == indexed {
+			return true
+		}
 	}
+	return false
+}
+
+func deliveryMember(command *model.Command) redis.Z {
+	return redis.Z{
+		Score:  float64(model.MaxPriority - command.Priority),
+		Member: fmt.Sprintf("%020d:%s", command.CreatedAt.UnixNano(), command.ID),
+	}
+}
+
+func idFromMember(member string) string {
+	return member[strings.LastIndexByte(member, ':')+1:]
+}
+
+// writeCommands queues the writes that store the commands and keep the
+// status indexes in sync.
+func writeCommands(ctx context.Context, pipe redis.Pipeliner, commands []model.Command) error {
+	for i := range commands {
+		command := &commands[i]
+		data, err := json.Marshal(command)
+		if err != nil {
+			return fmt.Errorf("failed to marshal command: %w", err)
+		}
+
+		pipe.HSet(ctx, commandsKey(command.RouterID), command.ID.String(), data)
+
+		member := deliveryMember(command)
+		for _, status := range indexedStatuses {
+			if command.Status == status {
+				pipe.ZAdd(ctx, statusKey(command.RouterID, status), member)
+			} else {
+				pipe.ZRem(ctx, statusKey(command.RouterID, status), member.Member)
+			}
+		}
+	}
+	return nil
+}
+
+func decodeCommands(values []string) ([]model.Command, error) {
+	commands := make([]model.Command, 0, len(values))
+	for _, v := range values {
+		var command model.Command
+		if err := json.Unmarshal([]byte(v), &command); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal command: %w", err)
+		}
+		commands = append(commands, command)
+	}
+	return commands, nil
+}
+
+func (r *RedisRepository) SaveCommand(ctx context.Context, command *model.Command) error {
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		return writeCommands(ctx, pipe, []model.Command{*command})
+	})
+	return err
+}
 
-	key := "command:" + command.RouterID.String()
-	_, err = r.client.RPush(ctx, key, data).Result()
+// SaveCommands writes the commands in one round trip. Unlike SaveCommand
+// it isn't atomic: a failure may leave some of them unwritten.
+func (r *RedisRepository) SaveCommands(ctx context.Context, commands []model.Command) error {
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		return writeCommands(ctx, pipe, commands)
+	})
 	return err
 }
 
 func (r *RedisRepository) FindCommandsByRouterId(ctx context.Context, routerId uuid.UUID) ([]model.Command, error) {
-	key := fmt.Sprintf("command:%s", routerId.String())
-	values, err := r.client.LRange(ctx, key, 0, -1).Result()
+	values, err := r.client.HVals(ctx, commandsKey(routerId)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commands from Redis: %w", err)
+	}
+
+	commands, err := decodeCommands(values)
+	if err != nil || len(commands) == 0 {
+		return nil, err
+	}
+
+	sortByCreation(commands)
+
+	return commands, nil
+}
+
+// FindCommandsByStatus returns up to limit commands of the router in the
+// status, in delivery order. Only PENDING and CANCELLING commands can be
+// looked up; a limit <= 0 returns all of them.
+func (r *RedisRepository) FindCommandsByStatus(ctx context.Context, routerId uuid.UUID, status string, limit int) ([]model.Command, error) {
+	if !isIndexed(status) {
+		return nil, fmt.Errorf("commands can't be looked up by status %s", status)
+	}
+
+	stop := int64(-1)
+	if limit > 0 {
+		stop = int64(limit) - 1
+	}
+
+	members, err := r.client.ZRange(ctx, statusKey(routerId, status), 0, stop).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commands from Redis: %w", err)
+	}
+	if len(members) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, 0, len(members))
+	for _, member := range members {
+		ids = append(ids, idFromMember(member))
+	}
+
+	values, err := r.client.HMGet(ctx, commandsKey(routerId), ids...).Result()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get commands from Redis: %w", err)
 	}
 
 	var commands []model.Command
-	for _, v := range values {
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue // removed after the index was read
+		}
 		var command model.Command
-		if err := json.Unmarshal([]byte(v), &command); err != nil {
+		if err := json.Unmarshal([]byte(data), &command); err != nil {
 			return nil, fmt.Errorf("failed to unmarshal command: %w", err)
 		}
-		commands = append(commands, command)
+		if command.Status == status {
+			commands = append(commands, command)
+		}
 	}
 
 	return commands, nil
 }
 
+// ChangeStatusByRouterId moves every command of the router that is in the
+// previous status to the new one; other commands are left as they are.
 func (r *RedisRepository) ChangeStatusByRouterId(ctx context.Context, routerId uuid.UUID, status string) error {
-	key := fmt.Sprintf("command:%s", routerId.String())
-	values, err := r.client.LRange(ctx, key, 0, -1).Result()
-	if err != nil {
-		return fmt.Errorf("failed to get commands from Redis: %w", err)
+	if _, ok := model.PreviousStatus(status); !ok {
+		return fmt.Errorf("unsupported command status: %s", status)
 	}
 
-	if len(values) == 0 {
+	now := time.Now()
+	return r.updateCommands(ctx, routerId, func(cmd *model.Command) bool {
+		return cmd.Transition(status, now)
+	})
+}
+
+// ChangeStatusByIds is ChangeStatusByRouterId limited to the given commands.
+func (r *RedisRepository) ChangeStatusByIds(ctx context.Context, routerId uuid.UUID, ids []uuid.UUID, status string) error {
+	if _, ok := model.PreviousStatus(status); !ok {
+		return fmt.Errorf("unsupported command status: %s", status)
+	}
+	if len(ids) == 0 {
 		return nil
 	}
 
-	var updated []string
-	for _, v := range values {
-		var cmd model.Command
-		if err := json.Unmarshal([]byte(v), &cmd); err != nil {
-			return fmt.Errorf("failed to unmarshal command: %w", err)
+	selected := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		selected[id] = true
+	}
+
+	now := time.Now()
+	return r.updateCommands(ctx, routerId, func(cmd *model.Command) bool {
+		return selected[cmd.ID] && cmd.Transition(status, now)
+	})
+}
+
+// FailCommand marks a SENT command as FAILED with the error reported by the router.
+func (r *RedisRepository) FailCommand(ctx context.Context, routerId, commandId uuid.UUID, reason string) error {
+	now := time.Now()
+	return r.updateCommands(ctx, routerId, func(cmd *model.Command) bool {
+		if cmd.ID != commandId || !cmd.Transition(model.StatusFailed, now) {
+			return false
+		}
+		cmd.Error = reason
+		return true
+	})
+}
+
+// UpdateCommands overwrites cached commands with the given versions, matched
+// by id. Commands that aren't cached are skipped.
+func (r *RedisRepository) UpdateCommands(ctx context.Context, commands []model.Command) error {
+	byRouter := make(map[uuid.UUID]map[uuid.UUID]model.Command)
+	for _, command := range commands {
+		if byRouter[command.RouterID] == nil {
+			byRouter[command.RouterID] = make(map[uuid.UUID]model.Command)
+		}
+		byRouter[command.RouterID][command.ID] = command
+	}
+
+	for routerId, updates := range byRouter {
+		err := r.updateCommands(ctx, routerId, func(cmd *model.Command) bool {
+			updated, ok := updates[cmd.ID]
+			if ok {
+				*cmd = updated
+			}
+			return ok
+		})
+		if err != nil {
+			return err
 		}
+	}
+
+	return nil
+}
 
-		now := time.Now()
-		if status == "SENT" && cmd.Status == "PENDING" {
-			cmd.SentAt = &now
-		} else if status == "ACKED" && cmd.Status == "SENT" {
-			cmd.AckedAt = &now
-		} else {
-			return fmt.Errorf("wrong type of query")
+// updateCommands applies update to every command of the router; update
+// reports whether it changed the command.
+func (r *RedisRepository) updateCommands(ctx context.Context, routerId uuid.UUID, update func(cmd *model.Command) bool) error {
+	return r.rewriteCommands(ctx, routerId, func(commands []model.Command) ([]model.Command, bool, error) {
+		changed := false
+		for i := range commands {
+			if update(&commands[i]) {
+				changed = true
+			}
 		}
+		return commands, changed, nil
+	})
+}
 
-		cmd.Status = status
+// rewriteCommands stores the commands returned by rewrite, unless it reports
+// no change; only new and modified commands are written. The commands are
+// updated in an optimistic transaction that is retried if they change under us.
+func (r *RedisRepository) rewriteCommands(ctx context.Context, routerId uuid.UUID, rewrite func(commands []model.Command) ([]model.Command, bool, error)) error {
+	key := commandsKey(routerId)
 
-		data, err := json.Marshal(cmd)
+	txf := func(tx *redis.Tx) error {
+		values, err := tx.HGetAll(ctx, key).Result()
 		if err != nil {
-			updated = append(updated, v)
-			continue
+			return fmt.Errorf("failed to get commands from Redis: %w", err)
+		}
+
+		commands := make([]model.Command, 0, len(values))
+		for _, v := range values {
+			var cmd model.Command
+			if err := json.Unmarshal([]byte(v), &cmd); err != nil {
+				return fmt.Errorf("failed to unmarshal command: %w", err)
+			}
+			commands = append(commands, cmd)
+		}
+		sortByCreation(commands)
+
+		commands, changed, err := rewrite(commands)
+		if err != nil || !changed {
+			return err
+		}
+
+		var updated []model.Command
+		for _, cmd := range commands {
+			data, err := json.Marshal(cmd)
+			if err != nil {
+				return fmt.Errorf("failed to marshal command: %w", err)
+			}
+			if values[cmd.ID.String()] != string(data) {
+				updated = append(updated, cmd)
+			}
 		}
-		updated = append(updated, string(data))
+		if len(updated) == 0 {
+			return nil
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return writeCommands(ctx, pipe, updated)
+		})
+		return err
 	}
 
-	pipe := r.client.TxPipeline()
-	pipe.Del(context.Background(), key)
-	if len(updated) > 0 {
-		pipe.RPush(context.Background(), key, updated)
+	return r.watch(ctx, txf, key)
+}
+
+// watch runs txf in an optimistic transaction over keys, retried if they
+// change under us.
+func (r *RedisRepository) watch(ctx context.Context, txf func(tx *redis.Tx) error, keys ...string) error {
+	for i := 0; i < maxTxRetries; i++ {
+		err := r.client.Watch(ctx, txf, keys...)
+		if err == redis.TxFailedErr {
+			r.log.DebugContext(ctx, "Concurrent update of Redis key, retrying", "key", keys[0], "attempt", i+1)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update Redis: %w", err)
+		}
+		return nil
 	}
-	_, err = pipe.Exec(context.Background())
+
+	return fmt.Errorf("failed to update Redis: too many concurrent updates of %s", keys[0])
+}
+
+func sortByCreation(commands []model.Command) {
+	sort.Slice(commands, func(i, j int) bool {
+		if !commands[i].CreatedAt.Equal(commands[j].CreatedAt) {
+			return commands[i].CreatedAt.Before(commands[j].CreatedAt)
+		}
+		return commands[i].ID.String() < commands[j].ID.String()
+	})
+}
+
+// SaveCommandCoalesced saves the command unless the coalescing policy drops
+// it; replaced commands are cancelled in the same transaction.
+func (r *RedisRepository) SaveCommandCoalesced(ctx context.Context, command *model.Command, policy string) (*model.CoalesceResult, error) {
+	var result *model.CoalesceResult
+
+	err := r.rewriteCommands(ctx, command.RouterID, func(commands []model.Command) ([]model.Command, bool, error) {
+		var err error
+		result, err = model.Coalesce(policy, command, commands, time.Now())
+		if err != nil || result.ExistingID != nil {
+			return nil, false, err
+		}
+
+		replaced := make(map[uuid.UUID]model.Command, len(result.Replaced))
+		for _, cmd := range result.Replaced {
+			replaced[cmd.ID] = cmd
+		}
+		for i := range commands {
+			if cmd, ok := replaced[commands[i].ID]; ok {
+				commands[i] = cmd
+			}
+		}
+
+		return append(commands, *command), true, nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to update Redis: %w", err)
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// ResyncCommands rebuilds the cache from commands, what PostgreSQL held when
+// the resync started at since. The cache is written to meanwhile, so it is
+// merged router by router rather than replaced: a cached command further
+// along than its snapshot is kept, and so is one missing from the snapshot
+// if it was created since. The other cached commands are outdated and dropped.
+func (r *RedisRepository) ResyncCommands(ctx context.Context, commands []model.Command, since time.Time) error {
+	byRouter := make(map[uuid.UUID][]model.Command)
+	for _, command := range commands {
+		byRouter[command.RouterID] = append(byRouter[command.RouterID], command)
+	}
+
+	iter := r.client.Scan(ctx, 0, "command:*", 100).Iterator()
+	for iter.Next(ctx) {
+		// the status indexes, command:<routerId>:<status>, aren't router ids
+		routerId, err := uuid.Parse(strings.TrimPrefix(iter.Val(), "command:"))
+		if err != nil {
+			continue
+		}
+		if _, ok := byRouter[routerId]; !ok {
+			byRouter[routerId] = nil
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("failed to scan command keys: %w", err)
+	}
+
+	for routerId, snapshot := range byRouter {
+		if err := r.resyncRouter(ctx, routerId, snapshot, since); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// resyncRouter merges the snapshot of the router's commands into the cache
+// and rebuilds its status indexes, see ResyncCommands.
+func (r *RedisRepository) resyncRouter(ctx context.Context, routerId uuid.UUID, snapshot []model.Command, since time.Time) error {
+	key := commandsKey(routerId)
+	keys := []string{key}
+	for _, status := range indexedStatuses {
+		keys = append(keys, statusKey(routerId, status))
+	}
+
+	txf := func(tx *redis.Tx) error {
+		values, err := tx.HGetAll(ctx, key).Result()
+		if err != nil {
+			return fmt.Errorf("failed to get commands from Redis: %w", err)
+		}
+
+		cached := make(map[uuid.UUID]model.Command, len(values))
+		for _, v := range values {
+			var cmd model.Command
+			if err := json.Unmarshal([]byte(v), &cmd); err != nil {
+				return fmt.Errorf("failed to unmarshal command: %w", err)
+			}
+			cached[cmd.ID] = cmd
+		}
+
+		commands := make([]model.Command, 0, len(snapshot))
+		for _, cmd := range snapshot {
+			if current, ok := cached[cmd.ID]; ok && current.NewerThan(&cmd) {
+				cmd = current
+			}
+			delete(cached, cmd.ID)
+			commands = append(commands, cmd)
+		}
+		for _, cmd := range cached {
+			if !cmd.CreatedAt.Before(since) {
+				commands = append(commands, cmd)
+			}
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, keys...)
+			return writeCommands(ctx, pipe, commands)
+		})
+		return err
+	}
+
+	return r.watch(ctx, txf, keys...)
+}
+
 /* --- work with routers table --- */
 
+// SaveRouter upserts the router by serial number: if the serial is already
+// known the router keeps its original id, which is written back to router.ID.
 func (r *RedisRepository) SaveRouter(ctx context.Context, router *model.Router) error {
+	serialKey := "router:serial:" + router.SerialNumber
+	created, err := r.client.SetNX(ctx, serialKey, router.ID.String(), 0).Result()
+	if err != nil {
+		return err
+	}
+
+	if !created {
+		existing, err := r.client.Get(ctx, serialKey).Result()
+		if err != nil {
+			return err
+		}
+		id, err := uuid.Parse(existing)
+		if err != nil {
+			return fmt.Errorf("invalid router id for serial %s: %w", router.SerialNumber, err)
+		}
+		router.ID = id
+	}
+
 	data, err := json.Marshal(router)
 	if err != nil {
 		return err
@@ -122,12 +522,50 @@ func (r *RedisRepository) SaveRouter(ctx context.Context, router *model.Router)
 	return err
 }
 
+// SaveRouters is SaveRouter for many routers in two round trips.
+func (r *RedisRepository) SaveRouters(ctx context.Context, routers []model.Router) error {
+	ids := make([]*redis.StringCmd, len(routers))
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := range routers {
+			serialKey := "router:serial:" + routers[i].SerialNumber
+			pipe.SetNX(ctx, serialKey, routers[i].ID.String(), 0)
+			ids[i] = pipe.Get(ctx, serialKey)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := range routers {
+			router := &routers[i]
+			id, err := uuid.Parse(ids[i].Val())
+			if err != nil {
+				return fmt.Errorf("invalid router id for serial %s: %w", router.SerialNumber, err)
+			}
+			router.ID = id
+
+			data, err := json.Marshal(router)
+			if err != nil {
+				return err
+			}
+			pipe.Set(ctx, "router:"+router.ID.String(), data, 24*time.Hour)
+		}
+		return nil
+	})
+	return err
+}
+
 func (r *RedisRepository) FindRouterByRouterId(ctx context.Context, id string) (*model.Router, error) {
 	key := "router:" + id
 	data, err := r.client.Get(ctx, key).Result()
 	if err == redis.Nil {
 		return nil, nil
 	}
+	if err != nil {
+		return nil, err
+	}
 
 	var router model.Router
 	if err := json.Unmarshal([]byte(data), &router); err != nil {
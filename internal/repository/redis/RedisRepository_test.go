@@ -7,6 +7,7 @@ package redis_test
 import (
 	"context"
 	"router-manager/internal/model"
+	"router-manager/internal/repository/contract"
 	"router-manager/internal/repository/redis"
 	"router-manager/testhelper"
 	"testing"
@@ -56,8 +57,13 @@ func TestRedisRepository(t *testing.T) {
 	err = repo.ChangeStatusByRouterId(context.Background(), routerId, "ACKED")
 	assert.NoError(t, err)
 
+	// ACKED commands are not sent again
 	err = repo.ChangeStatusByRouterId(context.Background(), routerId, "SENT")
-	assert.Error(t, err)
+	assert.NoError(t, err)
+
+	resultCommand, err = repo.FindCommandsByRouterId(context.Background(), routerId)
+	assert.NoError(t, err)
+	assert.Equal(t, resultCommand[0].Status, "ACKED")
 
 	resultRouter, err := repo.FindRouterByRouterId(context.Background(), routerId.String())
 	assert.NoError(t, err)
@@ -65,3 +71,180 @@ func TestRedisRepository(t *testing.T) {
 	assert.Equal(t, resultRouter.ID, routerId)
 	assert.Equal(t, resultRouter.SerialNumber, router.SerialNumber)
 }
+
+func TestRedisRepository_UpdateCommands(t *testing.T) {
+	testRedis := testhelper.SetupTestRedis(t)
+	repo := redis.NewRedisRepository(testRedis.Client)
+	ctx := context.Background()
+
+	routerId := uuid.New()
+	first := model.Command{ID: uuid.New(), RouterID: routerId, CommandType: "REBOOT", Status: "PENDING", CreatedAt: time.Now()}
+	second := model.Command{ID: uuid.New(), RouterID: routerId, CommandType: "REBOOT", Status: "PENDING", CreatedAt: time.Now()}
+	assert.NoError(t, repo.SaveCommand(ctx, &first))
+	assert.NoError(t, repo.SaveCommand(ctx, &second))
+
+	now := time.Now()
+	cancelled := second
+	cancelled.Status = "CANCELLED"
+	cancelled.CancelledAt = &now
+	cancelled.CancelledBy = "alice"
+
+	// commands that aren't cached are ignored
+	notCached := model.Command{ID: uuid.New(), RouterID: uuid.New(), Status: "CANCELLED"}
+
+	err := repo.UpdateCommands(ctx, []model.Command{cancelled, notCached})
+	assert.NoError(t, err)
+
+	commands, err := repo.FindCommandsByRouterId(ctx, routerId)
+	assert.NoError(t, err)
+	assert.Len(t, commands, 2)
+	assert.Equal(t, "PENDING", commands[0].Status)
+	assert.Equal(t, "CANCELLED", commands[1].Status)
+	assert.Equal(t, "alice", commands[1].CancelledBy)
+
+	commands, err = repo.FindCommandsByRouterId(ctx, notCached.RouterID)
+	assert.NoError(t, err)
+	assert.Empty(t, commands)
+}
+
+func TestRedisRepository_Bulk(t *testing.T) {
+	testRedis := testhelper.SetupTestRedis(t)
+	repo := redis.NewRedisRepository(testRedis.Client)
+	ctx := context.Background()
+
+	known := &model.Router{ID: uuid.New(), SerialNumber: "SN-BULK-1", CreatedAt: time.Now()}
+	assert.NoError(t, repo.SaveRouter(ctx, known))
+
+	routers := []model.Router{
+		{ID: uuid.New(), SerialNumber: "SN-BULK-1"},
+		{ID: uuid.New(), SerialNumber: "SN-BULK-2"},
+	}
+	assert.NoError(t, repo.SaveRouters(ctx, routers))
+	assert.Equal(t, known.ID, routers[0].ID)
+
+	found, err := repo.FindRouterByRouterId(ctx, routers[1].ID.String())
+	assert.NoError(t, err)
+	assert.Equal(t, "SN-BULK-2", found.SerialNumber)
+
+	now := time.Now()
+	pending := model.Command{ID: uuid.New(), RouterID: routers[0].ID, CommandType: "REBOOT", Status: "PENDING", CreatedAt: now}
+	other := model.Command{ID: uuid.New(), RouterID: routers[1].ID, CommandType: "REBOOT", Status: "PENDING", CreatedAt: now}
+	cancelled := pending
+	cancelled.Status = "CANCELLED"
+
+	// the last version of a command wins
+	assert.NoError(t, repo.SaveCommands(ctx, []model.Command{pending, other, cancelled}))
+
+	commands, err := repo.FindCommandsByStatus(ctx, routers[0].ID, "PENDING", 0)
+	assert.NoError(t, err)
+	assert.Empty(t, commands)
+
+	commands, err = repo.FindCommandsByStatus(ctx, routers[1].ID, "PENDING", 0)
+	assert.NoError(t, err)
+	assert.Len(t, commands, 1)
+}
+
+func TestRedisRepository_ResyncCommands(t *testing.T) {
+	testRedis := testhelper.SetupTestRedis(t)
+	repo := redis.NewRedisRepository(testRedis.Client)
+	ctx := context.Background()
+
+	routerId := uuid.New()
+	before := time.Now().Add(-time.Minute)
+	command := func(status string, createdAt time.Time) model.Command {
+		return model.Command{ID: uuid.New(), RouterID: routerId, CommandType: "REBOOT", Status: status, CreatedAt: createdAt}
+	}
+
+	// cached before the resync: still PENDING here, SENT in the snapshot...
+	behind := command("PENDING", before)
+	// ...already SENT here, still PENDING in the snapshot...
+	ahead := command("SENT", before)
+	// ...CANCELLED in PostgreSQL meanwhile, so not in the snapshot
+	outdated := command("PENDING", before)
+	assert.NoError(t, repo.SaveCommands(ctx, []model.Command{behind, ahead, outdated}))
+
+	since := time.Now()
+	// created by another instance once the resync started
+	created := command("PENDING", since.Add(time.Millisecond))
+	assert.NoError(t, repo.SaveCommand(ctx, &created))
+
+	// another router only has outdated commands
+	gone := model.Command{ID: uuid.New(), RouterID: uuid.New(), Status: "PENDING", CreatedAt: before}
+	assert.NoError(t, repo.SaveCommand(ctx, &gone))
+
+	sentBehind := behind
+	sentBehind.Status = "SENT"
+	pendingAhead := ahead
+	pendingAhead.Status = "PENDING"
+	missed := command("PENDING", before)
+
+	err := repo.ResyncCommands(ctx, []model.Command{sentBehind, pendingAhead, missed}, since)
+	assert.NoError(t, err)
+
+	commands, err := repo.FindCommandsByRouterId(ctx, routerId)
+	assert.NoError(t, err)
+	byId := make(map[uuid.UUID]string)
+	for _, cmd := range commands {
+		byId[cmd.ID] = cmd.Status
+	}
+	assert.Equal(t, map[uuid.UUID]string{
+		behind.ID:  "SENT",
+		ahead.ID:   "SENT",
+		missed.ID:  "PENDING",
+		created.ID: "PENDING",
+	}, byId)
+
+	// the delivery index was rebuilt as well
+	pending, err := repo.FindCommandsByStatus(ctx, routerId, "PENDING", 0)
+	assert.NoError(t, err)
+	var pendingIds []uuid.UUID
+	for _, cmd := range pending {
+		pendingIds = append(pendingIds, cmd.ID)
+	}
+	assert.ElementsMatch(t, []uuid.UUID{missed.ID, created.ID}, pendingIds)
+
+	commands, err = repo.FindCommandsByRouterId(ctx, gone.RouterID)
+	assert.NoError(t, err)
+	assert.Empty(t, commands)
+}
+
+func TestRedisRepository_Contract(t *testing.T) {
+	testRedis := testhelper.SetupTestRedis(t)
+
+	contract.RunRepositoryContract(t, func(t *testing.T) contract.Repository {
+		return redis.NewRedisRepository(testRedis.Client)
+	})
+}
+
+func TestRateLimitRepository(t *testing.T) {
+	testRedis := testhelper.SetupTestRedis(t)
+	ctx := context.Background()
+
+	repo := redis.NewRateLimitRepository(testRedis.Client, nil)
+
+	// a full bucket lets the burst through, then waits for the rate
+	for range 3 {
+		allowed, _, err := repo.TakeToken(ctx, "CommandService.PollCommands:router:r-1", 10, 3)
+		assert.NoError(t, err)
+		assert.True(t, allowed)
+	}
+	allowed, retryAfter, err := repo.TakeToken(ctx, "CommandService.PollCommands:router:r-1", 10, 3)
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+	assert.True(t, retryAfter > 0 && retryAfter <= 100*time.Millisecond, retryAfter)
+
+	// other callers have their own bucket
+	allowed, _, err = repo.TakeToken(ctx, "CommandService.PollCommands:router:r-2", 10, 3)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+
+	time.Sleep(retryAfter)
+	allowed, _, err = repo.TakeToken(ctx, "CommandService.PollCommands:router:r-1", 10, 3)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+
+	// buckets go away once full again
+	ttl, err := testRedis.Client.PTTL(ctx, "ratelimit:CommandService.PollCommands:router:r-1").Result()
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0 && ttl <= 1300*time.Millisecond, ttl)
+}
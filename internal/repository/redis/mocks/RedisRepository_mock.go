@@ -8,6 +8,7 @@ import (
 	context "context"
 	reflect "reflect"
 	model "router-manager/internal/model"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 	uuid "github.com/google/uuid"
@@ -36,6 +37,20 @@ func (m *MockRedisRepo) EXPECT() *MockRedisRepoMockRecorder {
 	return m.recorder
 }
 
+// ChangeStatusByIds mocks base method.
+func (m *MockRedisRepo) ChangeStatusByIds(ctx context.Context, routerId uuid.UUID, ids []uuid.UUID, status string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ChangeStatusByIds", ctx, routerId, ids, status)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ChangeStatusByIds indicates an expected call of ChangeStatusByIds.
+func (mr *MockRedisRepoMockRecorder) ChangeStatusByIds(ctx, routerId, ids, status interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ChangeStatusByIds", reflect.TypeOf((*MockRedisRepo)(nil).ChangeStatusByIds), ctx, routerId, ids, status)
+}
+
 // ChangeStatusByRouterId mocks base method.
 func (m *MockRedisRepo) ChangeStatusByRouterId(ctx context.Context, routerId uuid.UUID, status string) error {
 	m.ctrl.T.Helper()
@@ -50,6 +65,20 @@ func (mr *MockRedisRepoMockRecorder) ChangeStatusByRouterId(ctx, routerId, statu
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ChangeStatusByRouterId", reflect.TypeOf((*MockRedisRepo)(nil).ChangeStatusByRouterId), ctx, routerId, status)
 }
 
+// FailCommand mocks base method.
+func (m *MockRedisRepo) FailCommand(ctx context.Context, routerId, commandId uuid.UUID, reason string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FailCommand", ctx, routerId, commandId, reason)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// FailCommand indicates an expected call of FailCommand.
+func (mr *MockRedisRepoMockRecorder) FailCommand(ctx, routerId, commandId, reason interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FailCommand", reflect.TypeOf((*MockRedisRepo)(nil).FailCommand), ctx, routerId, commandId, reason)
+}
+
 // FindCommandsByRouterId mocks base method.
 func (m *MockRedisRepo) FindCommandsByRouterId(ctx context.Context, routerId uuid.UUID) ([]model.Command, error) {
 	m.ctrl.T.Helper()
@@ -65,6 +94,21 @@ func (mr *MockRedisRepoMockRecorder) FindCommandsByRouterId(ctx, routerId interf
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindCommandsByRouterId", reflect.TypeOf((*MockRedisRepo)(nil).FindCommandsByRouterId), ctx, routerId)
 }
 
+// FindCommandsByStatus mocks base method.
+func (m *MockRedisRepo) FindCommandsByStatus(ctx context.Context, routerId uuid.UUID, status string, limit int) ([]model.Command, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindCommandsByStatus", ctx, routerId, status, limit)
+	ret0, _ := ret[0].([]model.Command)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindCommandsByStatus indicates an expected call of FindCommandsByStatus.
+func (mr *MockRedisRepoMockRecorder) FindCommandsByStatus(ctx, routerId, status, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindCommandsByStatus", reflect.TypeOf((*MockRedisRepo)(nil).FindCommandsByStatus), ctx, routerId, status, limit)
+}
+
 // FindRouterByRouterId mocks base method.
 func (m *MockRedisRepo) FindRouterByRouterId(ctx context.Context, id string) (*model.Router, error) {
 	m.ctrl.T.Helper()
@@ -80,6 +124,20 @@ func (mr *MockRedisRepoMockRecorder) FindRouterByRouterId(ctx, id interface{}) *
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindRouterByRouterId", reflect.TypeOf((*MockRedisRepo)(nil).FindRouterByRouterId), ctx, id)
 }
 
+// ResyncCommands mocks base method.
+func (m *MockRedisRepo) ResyncCommands(ctx context.Context, commands []model.Command, since time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResyncCommands", ctx, commands, since)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResyncCommands indicates an expected call of ResyncCommands.
+func (mr *MockRedisRepoMockRecorder) ResyncCommands(ctx, commands, since interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResyncCommands", reflect.TypeOf((*MockRedisRepo)(nil).ResyncCommands), ctx, commands, since)
+}
+
 // SaveCommand mocks base method.
 func (m *MockRedisRepo) SaveCommand(ctx context.Context, command *model.Command) error {
 	m.ctrl.T.Helper()
@@ -94,6 +152,35 @@ func (mr *MockRedisRepoMockRecorder) SaveCommand(ctx, command interface{}) *gomo
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveCommand", reflect.TypeOf((*MockRedisRepo)(nil).SaveCommand), ctx, command)
 }
 
+// SaveCommandCoalesced mocks base method.
+func (m *MockRedisRepo) SaveCommandCoalesced(ctx context.Context, command *model.Command, policy string) (*model.CoalesceResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveCommandCoalesced", ctx, command, policy)
+	ret0, _ := ret[0].(*model.CoalesceResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SaveCommandCoalesced indicates an expected call of SaveCommandCoalesced.
+func (mr *MockRedisRepoMockRecorder) SaveCommandCoalesced(ctx, command, policy interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveCommandCoalesced", reflect.TypeOf((*MockRedisRepo)(nil).SaveCommandCoalesced), ctx, command, policy)
+}
+
+// SaveCommands mocks base method.
+func (m *MockRedisRepo) SaveCommands(ctx context.Context, commands []model.Command) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveCommands", ctx, commands)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveCommands indicates an expected call of SaveCommands.
+func (mr *MockRedisRepoMockRecorder) SaveCommands(ctx, commands interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveCommands", reflect.TypeOf((*MockRedisRepo)(nil).SaveCommands), ctx, commands)
+}
+
 // SaveRouter mocks base method.
 func (m *MockRedisRepo) SaveRouter(ctx context.Context, router *model.Router) error {
 	m.ctrl.T.Helper()
@@ -107,3 +194,31 @@ func (mr *MockRedisRepoMockRecorder) SaveRouter(ctx, router interface{}) *gomock
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveRouter", reflect.TypeOf((*MockRedisRepo)(nil).SaveRouter), ctx, router)
 }
+
+// SaveRouters mocks base method.
+func (m *MockRedisRepo) SaveRouters(ctx context.Context, routers []model.Router) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveRouters", ctx, routers)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveRouters indicates an expected call of SaveRouters.
+func (mr *MockRedisRepoMockRecorder) SaveRouters(ctx, routers interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveRouters", reflect.TypeOf((*MockRedisRepo)(nil).SaveRouters), ctx, routers)
+}
+
+// UpdateCommands mocks base method.
+func (m *MockRedisRepo) UpdateCommands(ctx context.Context, commands []model.Command) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateCommands", ctx, commands)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateCommands indicates an expected call of UpdateCommands.
+func (mr *MockRedisRepoMockRecorder) UpdateCommands(ctx, commands interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateCommands", reflect.TypeOf((*MockRedisRepo)(nil).UpdateCommands), ctx, commands)
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"router-manager/internal/auth"
+	"router-manager/internal/model"
+	"router-manager/internal/pb"
+	"router-manager/internal/repository/postgres"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ApiKeyService lets admins issue and revoke the API keys operators and
+// automations authenticate with.
+type ApiKeyService struct {
+	pb.UnimplementedApiKeyServiceServer
+
+	postgresRepo postgres.PostgresRepo
+}
+
+func NewApiKeyService(pgRepo postgres.PostgresRepo) *ApiKeyService {
+	return &ApiKeyService{
+		postgresRepo: pgRepo,
+	}
+}
+
+// CreateApiKey issues a key; its secret is only ever returned here.
+func (s *ApiKeyService) CreateApiKey(ctx context.Context, req *pb.CreateApiKeyRequest) (*pb.CreateApiKeyResponse, error) {
+	admin, err := requireAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.Name == "" || req.Subject == "" {
+		return nil, status.Error(codes.InvalidArgument, "name and subject are required")
+	}
+	if req.Subject == auth.BootstrapSubject || req.Subject == anonymousCaller {
+		return nil, status.Errorf(codes.InvalidArgument, "subject %q is reserved", req.Subject)
+	}
+
+	secret, hash, err := model.NewApiKeySecret()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to generate key: %v", err)
+	}
+
+	now := time.Now()
+	key := &model.ApiKey{
+		ID:        uuid.New(),
+		Name:      req.Name,
+		Subject:   req.Subject,
+		Roles:     req.Roles,
+		KeyHash:   hash,
+		CreatedBy: admin.Subject,
+		CreatedAt: now,
+	}
+	if req.Ttl != nil {
+		ttl := req.Ttl.AsDuration()
+		if ttl <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "ttl must be positive")
+		}
+		expiresAt := now.Add(ttl)
+		key.ExpiresAt = &expiresAt
+	}
+
+	if err := s.postgresRepo.SaveApiKey(ctx, key); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to save key: %v", err)
+	}
+
+	slog.InfoContext(ctx, "API key created", "key_id", key.ID, "name", key.Name, "subject", key.Subject, "roles", key.Roles, "created_by", admin.Subject)
+
+	return &pb.CreateApiKeyResponse{Key: toApiKeyInfo(key), Secret: secret}, nil
+}
+
+func (s *ApiKeyService) ListApiKeys(ctx context.Context, req *pb.ListApiKeysRequest) (*pb.ListApiKeysResponse, error) {
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	keys, err := s.postgresRepo.ListApiKeys(ctx, req.IncludeRevoked)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list keys: %v", err)
+	}
+
+	response := &pb.ListApiKeysResponse{}
+	for i := range keys {
+		response.Keys = append(response.Keys, toApiKeyInfo(&keys[i]))
+	}
+
+	return response, nil
+}
+
+// RevokeApiKey revokes a key for good; calls with it fail from then on.
+func (s *ApiKeyService) RevokeApiKey(ctx context.Context, req *pb.RevokeApiKeyRequest) (*pb.ApiKeyInfo, error) {
+	admin, err := requireAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keyId, err := uuid.Parse(req.KeyId)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid key_id: %v", err)
+	}
+
+	key, err := s.postgresRepo.RevokeApiKey(ctx, keyId, admin.Subject, time.Now())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to revoke key: %v", err)
+	}
+	if key == nil {
+		return nil, status.Errorf(codes.NotFound, "no active key %s", keyId)
+	}
+
+	slog.InfoContext(ctx, "API key revoked", "key_id", key.ID, "name", key.Name, "subject", key.Subject, "revoked_by", admin.Subject)
+
+	return toApiKeyInfo(key), nil
+}
+
+// requireAdmin returns the principal of the call if it is an admin.
+func requireAdmin(ctx context.Context) (*auth.Principal, error) {
+	principal, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.PermissionDenied, "only an authenticated admin may do this")
+	}
+	if !principal.HasRole(auth.RoleAdmin) {
+		return nil, status.Errorf(codes.PermissionDenied, "%s is not an admin", principal.Subject)
+	}
+	return principal, nil
+}
+
+func toApiKeyInfo(key *model.ApiKey) *pb.ApiKeyInfo {
+	info := &pb.ApiKeyInfo{
+		Id:        key.ID.String(),
+		Name:      key.Name,
+		Subject:   key.Subject,
+		Roles:     key.Roles,
+		CreatedBy: key.CreatedBy,
+		CreatedAt: timestamppb.New(key.CreatedAt),
+		RevokedBy: key.RevokedBy,
+	}
+
+	if key.ExpiresAt != nil {
+		info.ExpiresAt = timestamppb.New(*key.ExpiresAt)
+	}
+	if key.RevokedAt != nil {
+		info.RevokedAt = timestamppb.New(*key.RevokedAt)
+	}
+
+	return info
+}
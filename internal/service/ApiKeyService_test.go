@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"router-manager/internal/auth"
+	"router-manager/internal/model"
+	"router-manager/internal/pb"
+	mockspg "router-manager/internal/repository/postgres/mocks"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func setupApiKeys(t *testing.T) (*ApiKeyService, *mockspg.MockPostgresRepo, context.Context) {
+	ctx := auth.NewContext(context.Background(), &auth.Principal{Subject: "root", Roles: []string{auth.RoleAdmin}})
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockPostgres := mockspg.NewMockPostgresRepo(ctrl)
+
+	s := NewApiKeyService(mockPostgres)
+
+	return s, mockPostgres, ctx
+}
+
+/* --- test CreateApiKey method --- */
+
+func TestCreateApiKey(t *testing.T) {
+	s, mockPostgres, ctx := setupApiKeys(t)
+
+	var saved *model.ApiKey
+	mockPostgres.EXPECT().
+		SaveApiKey(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, key *model.ApiKey) error {
+			saved = key
+			return nil
+		})
+
+	response, err := s.CreateApiKey(ctx, &pb.CreateApiKeyRequest{
+		Name:    "deploy pipeline",
+		Subject: "deploy-bot",
+		Roles:   []string{"noc"},
+		Ttl:     durationpb.New(time.Hour),
+	})
+
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(response.Secret, model.ApiKeyPrefix))
+	// only the hash of the secret is stored
+	assert.Equal(t, model.HashApiKeySecret(response.Secret), saved.KeyHash)
+	assert.NotContains(t, saved.KeyHash, response.Secret)
+	assert.Equal(t, "root", saved.CreatedBy)
+	assert.Equal(t, "deploy-bot", response.Key.Subject)
+	assert.Equal(t, []string{"noc"}, response.Key.Roles)
+	require.NotNil(t, saved.ExpiresAt)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), *saved.ExpiresAt, time.Minute)
+}
+
+func TestCreateApiKey_Invalid(t *testing.T) {
+	s, _, ctx := setupApiKeys(t)
+
+	_, err := s.CreateApiKey(ctx, &pb.CreateApiKeyRequest{Name: "no subject"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = s.CreateApiKey(ctx, &pb.CreateApiKeyRequest{Name: "impostor", Subject: auth.BootstrapSubject})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = s.CreateApiKey(ctx, &pb.CreateApiKeyRequest{Name: "n", Subject: "s", Ttl: durationpb.New(-time.Hour)})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestApiKeys_AdminOnly(t *testing.T) {
+	s, _, _ := setupApiKeys(t)
+
+	for _, ctx := range []context.Context{
+		context.Background(),
+		auth.NewContext(context.Background(), &auth.Principal{Subject: "alice", Roles: []string{"noc"}}),
+	} {
+		_, err := s.CreateApiKey(ctx, &pb.CreateApiKeyRequest{Name: "n", Subject: "s"})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+		_, err = s.ListApiKeys(ctx, &pb.ListApiKeysRequest{})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+		_, err = s.RevokeApiKey(ctx, &pb.RevokeApiKeyRequest{KeyId: uuid.NewString()})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	}
+}
+
+/* --- test ListApiKeys and RevokeApiKey methods --- */
+
+func TestListApiKeys(t *testing.T) {
+	s, mockPostgres, ctx := setupApiKeys(t)
+
+	keys := []model.ApiKey{{ID: uuid.New(), Name: "a", Subject: "alice", KeyHash: "secret-hash", CreatedAt: time.Now()}}
+	mockPostgres.EXPECT().ListApiKeys(gomock.Any(), true).Return(keys, nil)
+
+	response, err := s.ListApiKeys(ctx, &pb.ListApiKeysRequest{IncludeRevoked: true})
+
+	require.NoError(t, err)
+	require.Len(t, response.Keys, 1)
+	assert.Equal(t, "alice", response.Keys[0].Subject)
+	assert.Nil(t, response.Keys[0].ExpiresAt)
+}
+
+func TestRevokeApiKey(t *testing.T) {
+	s, mockPostgres, ctx := setupApiKeys(t)
+
+	keyId := uuid.New()
+	now := time.Now()
+	mockPostgres.EXPECT().
+		RevokeApiKey(gomock.Any(), keyId, "root", gomock.Any()).
+		Return(&model.ApiKey{ID: keyId, Subject: "alice", RevokedBy: "root", RevokedAt: &now}, nil)
+
+	info, err := s.RevokeApiKey(ctx, &pb.RevokeApiKeyRequest{KeyId: keyId.String()})
+
+	require.NoError(t, err)
+	assert.Equal(t, "root", info.RevokedBy)
+	assert.NotNil(t, info.RevokedAt)
+
+	mockPostgres.EXPECT().RevokeApiKey(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil)
+
+	_, err = s.RevokeApiKey(ctx, &pb.RevokeApiKeyRequest{KeyId: uuid.NewString()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"router-manager/internal/auth"
+	"router-manager/internal/model"
+	"router-manager/internal/pb"
+	"router-manager/internal/repository/postgres"
+	"router-manager/internal/repository/redis"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ApprovalService lets a second principal approve or reject the commands
+// that SendCommand held back as AWAITING_APPROVAL, and the campaigns
+// CreateCampaign did.
+type ApprovalService struct {
+	pb.UnimplementedApprovalServiceServer
+
+	postgresRepo postgres.PostgresRepo
+	redisRepo    redis.RedisRepo
+}
+
+func NewApprovalService(pgRepo postgres.PostgresRepo, redisRepo redis.RedisRepo) *ApprovalService {
+	return &ApprovalService{
+		postgresRepo: pgRepo,
+		redisRepo:    redisRepo,
+	}
+}
+
+func (s *ApprovalService) GetApproval(ctx context.Context, req *pb.GetApprovalRequest) (*pb.ApprovalInfo, error) {
+	approvalId, err := uuid.Parse(req.ApprovalId)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid approval_id: %v", err)
+	}
+
+	approval, err := s.postgresRepo.GetApproval(ctx, approvalId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load approval: %v", err)
+	}
+	if approval == nil {
+		return nil, status.Errorf(codes.NotFound, "approval %s not found", approvalId)
+	}
+
+	return toApprovalInfo(approval), nil
+}
+
+// DecideApproval approves or rejects the commands or the campaign of an
+// approval on behalf of the caller, who must not be the one who sent them.
+// The caller must be authenticated: without authentication x-caller-id is
+// whatever the client claims, anyone could pass for a second principal.
+func (s *ApprovalService) DecideApproval(ctx context.Context, req *pb.DecideApprovalRequest) (*pb.ApprovalInfo, error) {
+	approvalId, err := uuid.Parse(req.ApprovalId)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid approval_id: %v", err)
+	}
+
+	principal, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.PermissionDenied, "approvals can only be decided by an authenticated caller")
+	}
+	caller := principal.Subject
+
+	approval, err := s.postgresRepo.GetApproval(ctx, approvalId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load approval: %v", err)
+	}
+	if approval == nil {
+		return nil, status.Errorf(codes.NotFound, "approval %s not found", approvalId)
+	}
+	if approval.RequestedBy == caller {
+		return nil, status.Error(codes.PermissionDenied, "commands can't be approved by the caller who sent them")
+	}
+
+	now := time.Now()
+	if approval.Status != model.ApprovalPending {
+		return nil, status.Errorf(codes.FailedPrecondition, "approval %s is %s", approvalId, approval.Status)
+	}
+	if approval.Expired(now) {
+		return nil, status.Errorf(codes.FailedPrecondition, "approval %s has expired", approvalId)
+	}
+
+	decision := model.ApprovalDecision{
+		Approve: req.Approve,
+		By:      caller,
+		Reason:  req.Reason,
+		At:      now,
+	}
+	decided, commands, err := s.postgresRepo.DecideApproval(ctx, approvalId, decision)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to decide approval: %v", err)
+	}
+	if decided == nil {
+		return nil, status.Errorf(codes.FailedPrecondition,
+			"approval %s can't be decided now, its commands may still be being created", approvalId)
+	}
+
+	if err := s.redisRepo.SaveCommands(ctx, commands); err != nil && !redis.IsUnavailable(err) {
+		slog.WarnContext(ctx, "Failed to update approved commands in Redis", "error", err)
+	}
+
+	slog.InfoContext(ctx, "Approval decided", "approval_id", approvalId, "commands", len(commands),
+		"command_type", decided.CommandType, "requested_by", decided.RequestedBy, "status", decided.Status, "decided_by", caller)
+
+	return toApprovalInfo(decided), nil
+}
+
+// ExpireApprovals cancels the commands of approvals nobody decided in time.
+func (s *ApprovalService) ExpireApprovals(ctx context.Context) {
+	expired, cancelled, err := s.postgresRepo.ExpireApprovals(ctx, time.Now())
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to expire approvals", "error", err)
+		return
+	}
+	if len(expired) == 0 {
+		return
+	}
+
+	if err := s.redisRepo.SaveCommands(ctx, cancelled); err != nil && !redis.IsUnavailable(err) {
+		slog.WarnContext(ctx, "Failed to cancel commands of expired approvals in Redis", "error", err)
+	}
+
+	slog.InfoContext(ctx, "Approvals expired", "approvals", len(expired), "cancelled", len(cancelled))
+}
+
+func toApprovalInfo(approval *model.Approval) *pb.ApprovalInfo {
+	info := &pb.ApprovalInfo{
+		Id:          approval.ID.String(),
+		CommandType: approval.CommandType,
+		Status:      approval.Status,
+		RequestedBy: approval.RequestedBy,
+		RequestedAt: timestamppb.New(approval.RequestedAt),
+		ExpiresAt:   timestamppb.New(approval.ExpiresAt),
+		DecidedBy:   approval.DecidedBy,
+		Reason:      approval.Reason,
+		Commands:    uint32(approval.Commands),
+	}
+
+	if approval.CampaignID != nil {
+		info.CampaignId = approval.CampaignID.String()
+	}
+
+	if approval.DecidedAt != nil {
+		info.DecidedAt = timestamppb.New(*approval.DecidedAt)
+	}
+
+	return info
+}
@@ -0,0 +1,258 @@
+package service
+
+import (
+	"context"
+	"router-manager/internal/auth"
+	"router-manager/internal/model"
+	"router-manager/internal/pb"
+	mockspg "router-manager/internal/repository/postgres/mocks"
+	mocksred "router-manager/internal/repository/redis/mocks"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func setupApprovals(t *testing.T) (*ApprovalService, *mockspg.MockPostgresRepo, *mocksred.MockRedisRepo, context.Context) {
+	ctx := auth.NewContext(context.Background(), &auth.Principal{Subject: "bob", Method: auth.MethodApiKey})
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockPostgres := mockspg.NewMockPostgresRepo(ctrl)
+	mockRedis := mocksred.NewMockRedisRepo(ctrl)
+
+	s := NewApprovalService(mockPostgres, mockRedis)
+
+	return s, mockPostgres, mockRedis, ctx
+}
+
+func pendingApproval() *model.Approval {
+	now := time.Now()
+	return &model.Approval{
+		ID:          uuid.New(),
+		CommandType: "FACTORY_RESET",
+		Status:      model.ApprovalPending,
+		RequestedBy: "alice",
+		RequestedAt: now,
+		ExpiresAt:   now.Add(time.Hour),
+		Commands:    2,
+	}
+}
+
+/* --- test GetApproval method --- */
+
+func TestGetApproval(t *testing.T) {
+	s, mockPostgres, _, ctx := setupApprovals(t)
+
+	approval := pendingApproval()
+	mockPostgres.EXPECT().GetApproval(gomock.Any(), approval.ID).Return(approval, nil)
+
+	info, err := s.GetApproval(ctx, &pb.GetApprovalRequest{ApprovalId: approval.ID.String()})
+
+	require.NoError(t, err)
+	assert.Equal(t, model.ApprovalPending, info.Status)
+	assert.Equal(t, "alice", info.RequestedBy)
+	assert.Equal(t, uint32(2), info.Commands)
+	assert.Nil(t, info.DecidedAt)
+}
+
+func TestGetApproval_NotFound(t *testing.T) {
+	s, mockPostgres, _, ctx := setupApprovals(t)
+
+	mockPostgres.EXPECT().GetApproval(gomock.Any(), gomock.Any()).Return(nil, nil)
+
+	_, err := s.GetApproval(ctx, &pb.GetApprovalRequest{ApprovalId: uuid.NewString()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestGetApproval_Campaign(t *testing.T) {
+	s, mockPostgres, _, ctx := setupApprovals(t)
+
+	approval := pendingApproval()
+	campaignId := uuid.New()
+	approval.Commands, approval.CampaignID = 0, &campaignId
+	mockPostgres.EXPECT().GetApproval(gomock.Any(), approval.ID).Return(approval, nil)
+
+	info, err := s.GetApproval(ctx, &pb.GetApprovalRequest{ApprovalId: approval.ID.String()})
+
+	require.NoError(t, err)
+	assert.Equal(t, campaignId.String(), info.CampaignId)
+	assert.Zero(t, info.Commands)
+}
+
+/* --- test DecideApproval method --- */
+
+func TestDecideApproval_Approve(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setupApprovals(t)
+
+	approval := pendingApproval()
+	mockPostgres.EXPECT().GetApproval(gomock.Any(), approval.ID).Return(approval, nil)
+
+	approved := []model.Command{
+		{ID: uuid.New(), RouterID: uuid.New(), CommandType: "FACTORY_RESET", Status: model.StatusPending, ApprovalID: &approval.ID},
+	}
+	mockPostgres.EXPECT().
+		DecideApproval(gomock.Any(), approval.ID, gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ uuid.UUID, decision model.ApprovalDecision) (*model.Approval, []model.Command, error) {
+			assert.True(t, decision.Approve)
+			assert.Equal(t, "bob", decision.By)
+			assert.Equal(t, "planned maintenance", decision.Reason)
+
+			decided := *approval
+			decided.Status = decision.Status()
+			decided.DecidedBy = decision.By
+			decided.DecidedAt = &decision.At
+			return &decided, approved, nil
+		})
+	// the approved commands become deliverable from the cache too
+	mockRedis.EXPECT().SaveCommands(gomock.Any(), approved).Return(nil)
+
+	info, err := s.DecideApproval(ctx, &pb.DecideApprovalRequest{
+		ApprovalId: approval.ID.String(),
+		Approve:    true,
+		Reason:     "planned maintenance",
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, model.ApprovalApproved, info.Status)
+	assert.Equal(t, "bob", info.DecidedBy)
+	assert.NotNil(t, info.DecidedAt)
+}
+
+func TestDecideApproval_Reject(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setupApprovals(t)
+
+	approval := pendingApproval()
+	mockPostgres.EXPECT().GetApproval(gomock.Any(), approval.ID).Return(approval, nil)
+
+	rejected := *approval
+	rejected.Status = model.ApprovalRejected
+	mockPostgres.EXPECT().
+		DecideApproval(gomock.Any(), approval.ID, gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ uuid.UUID, decision model.ApprovalDecision) (*model.Approval, []model.Command, error) {
+			assert.False(t, decision.Approve)
+			return &rejected, nil, nil
+		})
+	mockRedis.EXPECT().SaveCommands(gomock.Any(), gomock.Any()).Return(nil)
+
+	info, err := s.DecideApproval(ctx, &pb.DecideApprovalRequest{ApprovalId: approval.ID.String()})
+
+	require.NoError(t, err)
+	assert.Equal(t, model.ApprovalRejected, info.Status)
+}
+
+func TestDecideApproval_Refused(t *testing.T) {
+	tests := []struct {
+		name     string
+		caller   string
+		approval func(a *model.Approval)
+		code     codes.Code
+	}{
+		{
+			name:     "by the sender",
+			caller:   "alice",
+			approval: func(a *model.Approval) {},
+			code:     codes.PermissionDenied,
+		},
+		{
+			name:     "already decided",
+			caller:   "bob",
+			approval: func(a *model.Approval) { a.Status = model.ApprovalRejected },
+			code:     codes.FailedPrecondition,
+		},
+		{
+			name:     "expired",
+			caller:   "bob",
+			approval: func(a *model.Approval) { a.ExpiresAt = time.Now().Add(-time.Minute) },
+			code:     codes.FailedPrecondition,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mockPostgres, _, _ := setupApprovals(t)
+			ctx := auth.NewContext(context.Background(), &auth.Principal{Subject: tt.caller, Method: auth.MethodApiKey})
+
+			approval := pendingApproval()
+			tt.approval(approval)
+			mockPostgres.EXPECT().GetApproval(gomock.Any(), approval.ID).Return(approval, nil)
+
+			_, err := s.DecideApproval(ctx, &pb.DecideApprovalRequest{ApprovalId: approval.ID.String(), Approve: true})
+
+			assert.Equal(t, tt.code, status.Code(err))
+		})
+	}
+}
+
+func TestDecideApproval_Anonymous(t *testing.T) {
+	s, _, _, _ := setupApprovals(t)
+
+	_, err := s.DecideApproval(context.Background(), &pb.DecideApprovalRequest{ApprovalId: uuid.NewString(), Approve: true})
+
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestDecideApproval_Unauthenticated(t *testing.T) {
+	s, _, _, _ := setupApprovals(t)
+
+	// with authentication disabled anyone could claim to be a second principal
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-caller-id", "bob"))
+
+	_, err := s.DecideApproval(ctx, &pb.DecideApprovalRequest{ApprovalId: uuid.NewString(), Approve: true})
+
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestDecideApproval_AuthenticatedPrincipal(t *testing.T) {
+	s, mockPostgres, _, _ := setupApprovals(t)
+
+	// x-caller-id can't stand in for the authenticated principal
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-caller-id", "bob"))
+	ctx = auth.NewContext(ctx, &auth.Principal{Subject: "alice", Method: auth.MethodJWT})
+
+	approval := pendingApproval()
+	mockPostgres.EXPECT().GetApproval(gomock.Any(), approval.ID).Return(approval, nil)
+
+	_, err := s.DecideApproval(ctx, &pb.DecideApprovalRequest{ApprovalId: approval.ID.String(), Approve: true})
+
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestDecideApproval_JobStillRunning(t *testing.T) {
+	s, mockPostgres, _, ctx := setupApprovals(t)
+
+	approval := pendingApproval()
+	mockPostgres.EXPECT().GetApproval(gomock.Any(), approval.ID).Return(approval, nil)
+	mockPostgres.EXPECT().DecideApproval(gomock.Any(), approval.ID, gomock.Any()).Return(nil, nil, nil)
+
+	_, err := s.DecideApproval(ctx, &pb.DecideApprovalRequest{ApprovalId: approval.ID.String(), Approve: true})
+
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+/* --- test ExpireApprovals method --- */
+
+func TestExpireApprovals(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setupApprovals(t)
+
+	cancelled := []model.Command{{ID: uuid.New(), RouterID: uuid.New(), Status: model.StatusCancelled}}
+	mockPostgres.EXPECT().ExpireApprovals(gomock.Any(), gomock.Any()).Return([]uuid.UUID{uuid.New()}, cancelled, nil)
+	mockRedis.EXPECT().SaveCommands(gomock.Any(), cancelled).Return(nil)
+
+	s.ExpireApprovals(ctx)
+}
+
+func TestExpireApprovals_Nothing(t *testing.T) {
+	s, mockPostgres, _, ctx := setupApprovals(t)
+
+	mockPostgres.EXPECT().ExpireApprovals(gomock.Any(), gomock.Any()).Return(nil, nil, nil)
+
+	s.ExpireApprovals(ctx)
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"router-manager/internal/model"
+	"router-manager/internal/pb"
+	"router-manager/internal/repository/postgres"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// events read from the database at a time by ExportAuditEvents
+const exportBatchSize = 1000
+
+// AuditService lets auditors read and export the audit log.
+type AuditService struct {
+	pb.UnimplementedAuditServiceServer
+
+	postgresRepo postgres.PostgresRepo
+}
+
+func NewAuditService(pgRepo postgres.PostgresRepo) *AuditService {
+	return &AuditService{
+		postgresRepo: pgRepo,
+	}
+}
+
+func (s *AuditService) ListAuditEvents(ctx context.Context, req *pb.ListAuditEventsRequest) (*pb.ListAuditEventsResponse, error) {
+	filter := model.AuditFilter{
+		Actor:  req.Actor,
+		Action: req.Action,
+	}
+	filter.OccurredFrom, filter.OccurredTo = timeRange(req.Occurred)
+
+	pageSize := int(req.PageSize)
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	var before int64
+	if req.PageToken != "" {
+		seq, err := decodeSeqToken(req.PageToken)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid page_token: %v", err)
+		}
+		before = seq
+	}
+
+	// one extra row tells whether there is a next page
+	events, err := s.postgresRepo.ListAuditEvents(ctx, filter, before, pageSize+1)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list audit events: %v", err)
+	}
+
+	response := &pb.ListAuditEventsResponse{}
+	if len(events) > pageSize {
+		events = events[:pageSize]
+		response.NextPageToken = encodeSeqToken(events[pageSize-1].Seq)
+	}
+
+	for i := range events {
+		response.Events = append(response.Events, toAuditEvent(&events[i]))
+	}
+
+	return response, nil
+}
+
+// ExportAuditEvents streams the events of the time range in the order they
+// are chained in, so that the export can be checked against the hashes.
+func (s *AuditService) ExportAuditEvents(req *pb.ExportAuditEventsRequest, stream grpc.ServerStreamingServer[pb.AuditEvent]) error {
+	ctx := stream.Context()
+
+	var filter model.AuditFilter
+	filter.OccurredFrom, filter.OccurredTo = timeRange(req.Occurred)
+
+	var after int64
+	for {
+		events, err := s.postgresRepo.ExportAuditEvents(ctx, filter, after, exportBatchSize)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to export audit events: %v", err)
+		}
+
+		for i := range events {
+			if err := stream.Send(toAuditEvent(&events[i])); err != nil {
+				return err
+			}
+		}
+		if len(events) < exportBatchSize {
+			return nil
+		}
+		after = events[len(events)-1].Seq
+	}
+}
+
+func toAuditEvent(event *model.AuditEvent) *pb.AuditEvent {
+	return &pb.AuditEvent{
+		Seq:           event.Seq,
+		OccurredAt:    timestamppb.New(event.OccurredAt),
+		Actor:         event.Actor,
+		Action:        event.Action,
+		Target:        event.Target,
+		RequestDigest: event.RequestDigest,
+		Outcome:       event.Outcome,
+		Error:         event.Error,
+		SourceIp:      event.SourceIP,
+		PrevHash:      event.PrevHash,
+		Hash:          event.Hash,
+	}
+}
+
+func encodeSeqToken(seq int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(seq, 10)))
+}
+
+func decodeSeqToken(token string) (int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(raw), 10, 64)
+}
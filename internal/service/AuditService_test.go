@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"router-manager/internal/model"
+	"router-manager/internal/pb"
+	mockspg "router-manager/internal/repository/postgres/mocks"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func setupAudit(t *testing.T) (*AuditService, *mockspg.MockPostgresRepo) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockPostgres := mockspg.NewMockPostgresRepo(ctrl)
+
+	return NewAuditService(mockPostgres), mockPostgres
+}
+
+func auditEvents(seqs ...int64) []model.AuditEvent {
+	events := make([]model.AuditEvent, len(seqs))
+	for i, seq := range seqs {
+		events[i] = model.AuditEvent{Seq: seq, Actor: "alice", Action: "CommandService.SendCommand", Outcome: "OK"}
+	}
+	return events
+}
+
+// exportStream collects what ExportAuditEvents sends.
+type exportStream struct {
+	grpc.ServerStream
+	events []*pb.AuditEvent
+}
+
+func (s *exportStream) Context() context.Context { return context.Background() }
+
+func (s *exportStream) Send(event *pb.AuditEvent) error {
+	s.events = append(s.events, event)
+	return nil
+}
+
+/* --- test ListAuditEvents method --- */
+
+func TestListAuditEvents(t *testing.T) {
+	s, mockPostgres := setupAudit(t)
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	mockPostgres.EXPECT().
+		ListAuditEvents(gomock.Any(), model.AuditFilter{Actor: "alice", OccurredFrom: &from}, int64(0), 3).
+		Return(auditEvents(9, 8, 7), nil)
+
+	response, err := s.ListAuditEvents(context.Background(), &pb.ListAuditEventsRequest{
+		Actor:    "alice",
+		Occurred: &pb.TimeRange{From: timestamppb.New(from)},
+		PageSize: 2,
+	})
+	require.NoError(t, err)
+	require.Len(t, response.Events, 2)
+	assert.Equal(t, int64(9), response.Events[0].Seq)
+	assert.Equal(t, "alice", response.Events[0].Actor)
+	require.NotEmpty(t, response.NextPageToken)
+
+	// the next page starts before the last event returned
+	mockPostgres.EXPECT().
+		ListAuditEvents(gomock.Any(), gomock.Any(), int64(8), 3).
+		Return(auditEvents(7), nil)
+
+	response, err = s.ListAuditEvents(context.Background(), &pb.ListAuditEventsRequest{
+		Actor:     "alice",
+		PageSize:  2,
+		PageToken: response.NextPageToken,
+	})
+	require.NoError(t, err)
+	require.Len(t, response.Events, 1)
+	assert.Empty(t, response.NextPageToken)
+}
+
+func TestListAuditEvents_InvalidPageToken(t *testing.T) {
+	s, _ := setupAudit(t)
+
+	_, err := s.ListAuditEvents(context.Background(), &pb.ListAuditEventsRequest{PageToken: "***"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestListAuditEvents_DbError(t *testing.T) {
+	s, mockPostgres := setupAudit(t)
+
+	mockPostgres.EXPECT().
+		ListAuditEvents(gomock.Any(), gomock.Any(), int64(0), defaultPageSize+1).
+		Return(nil, fmt.Errorf("connection refused"))
+
+	_, err := s.ListAuditEvents(context.Background(), &pb.ListAuditEventsRequest{})
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+/* --- test ExportAuditEvents method --- */
+
+func TestExportAuditEvents(t *testing.T) {
+	s, mockPostgres := setupAudit(t)
+
+	full := make([]int64, exportBatchSize)
+	for i := range full {
+		full[i] = int64(i + 1)
+	}
+
+	gomock.InOrder(
+		mockPostgres.EXPECT().
+			ExportAuditEvents(gomock.Any(), gomock.Any(), int64(0), exportBatchSize).
+			Return(auditEvents(full...), nil),
+		mockPostgres.EXPECT().
+			ExportAuditEvents(gomock.Any(), gomock.Any(), int64(exportBatchSize), exportBatchSize).
+			Return(auditEvents(exportBatchSize+1, exportBatchSize+2), nil),
+	)
+
+	stream := &exportStream{}
+	require.NoError(t, s.ExportAuditEvents(&pb.ExportAuditEventsRequest{}, stream))
+
+	require.Len(t, stream.events, exportBatchSize+2)
+	for i, event := range stream.events {
+		assert.Equal(t, int64(i+1), event.Seq)
+	}
+}
+
+func TestExportAuditEvents_DbError(t *testing.T) {
+	s, mockPostgres := setupAudit(t)
+
+	mockPostgres.EXPECT().
+		ExportAuditEvents(gomock.Any(), gomock.Any(), int64(0), exportBatchSize).
+		Return(nil, fmt.Errorf("connection refused"))
+
+	err := s.ExportAuditEvents(&pb.ExportAuditEventsRequest{}, &exportStream{})
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
@@ -0,0 +1,425 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"router-manager/internal/metrics"
+	"router-manager/internal/model"
+	"router-manager/internal/pb"
+	"router-manager/internal/repository/postgres"
+	"router-manager/internal/repository/redis"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// CampaignService rolls commands out to many routers in waves. Waves are
+// released by AdvanceCampaigns, which the application calls periodically.
+type CampaignService struct {
+	pb.UnimplementedCampaignServiceServer
+
+	postgresRepo postgres.PostgresRepo
+	redisRepo    redis.RedisRepo
+
+	// used to cancel the commands of aborted campaigns, to request the
+	// approval of command types that need one and to seal the commands of
+	// waves
+	commands *CommandService
+}
+
+func NewCampaignService(pgRepo postgres.PostgresRepo, redisRepo redis.RedisRepo, commands *CommandService) *CampaignService {
+	return &CampaignService{
+		postgresRepo: pgRepo,
+		redisRepo:    redisRepo,
+		commands:     commands,
+	}
+}
+
+func (s *CampaignService) CreateCampaign(ctx context.Context, req *pb.CreateCampaignRequest) (*pb.CampaignInfo, error) {
+	if req.CommandType == "" {
+		return nil, status.Error(codes.InvalidArgument, "command_type is required")
+	}
+	if req.MaxFailureRate <= 0 || req.MaxFailureRate > 1 {
+		return nil, status.Error(codes.InvalidArgument, "max_failure_rate must be greater than 0 and at most 1")
+	}
+
+	if err := validatePayload(req.Payload); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid payload: %v", err)
+	}
+
+	soakTime := req.SoakTime.AsDuration()
+	if soakTime < 0 {
+		return nil, status.Error(codes.InvalidArgument, "soak_time can't be negative")
+	}
+
+	priority := model.LookupCommandType(req.CommandType).Priority
+	if req.Priority != nil {
+		priority = int(*req.Priority)
+		if !model.ValidPriority(priority) {
+			return nil, status.Errorf(codes.InvalidArgument, "priority must be between %d and %d", model.MinPriority, model.MaxPriority)
+		}
+	}
+
+	var selector model.RouterSelector
+	if req.Selector != nil {
+		selector = model.RouterSelector{
+			SerialNumbers: req.Selector.SerialNumbers,
+			SerialPrefix:  req.Selector.SerialPrefix,
+			All:           req.Selector.AllRouters,
+		}
+	}
+	if err := selector.Validate(); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid selector: %v", err)
+	}
+
+	routers, err := s.postgresRepo.FindRoutersBySelector(ctx, selector)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to find routers: %v", err)
+	}
+	if unknown := unknownSerials(selector.SerialNumbers, routers); len(unknown) > 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown routers: %v", unknown)
+	}
+	routers = slices.DeleteFunc(routers, func(router model.Router) bool {
+		return router.DecommissionedAt != nil
+	})
+	if len(routers) == 0 {
+		return nil, status.Error(codes.FailedPrecondition, "no routers match the selector")
+	}
+
+	var sizes []model.WaveSize
+	for _, wave := range req.Waves {
+		sizes = append(sizes, model.WaveSize{Count: int(wave.Count), Percent: wave.Percent})
+	}
+	waves, err := model.PlanWaves(len(routers), sizes)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid waves: %v", err)
+	}
+
+	now := time.Now()
+	campaign := &model.Campaign{
+		ID:              uuid.New(),
+		Name:            req.Name,
+		CommandType:     req.CommandType,
+		Priority:        priority,
+		Status:          model.CampaignRunning,
+		TotalWaves:      len(waves),
+		CheckedFromWave: 1,
+		SoakTime:        soakTime,
+		MaxFailureRate:  req.MaxFailureRate,
+		NextWaveAt:      &now,
+		CreatedBy:       actorFromContext(ctx, req.CreatedBy),
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	payload, err := s.commands.sealSensitive(campaign.CommandType, campaign.ID, commandPayload(req.CommandType, req.Payload))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to seal payload: %v", err)
+	}
+	campaign.Payload = payload
+
+	// routers are shuffled so that a wave isn't made of a single serial range
+	rand.Shuffle(len(routers), func(i, j int) {
+		routers[i], routers[j] = routers[j], routers[i]
+	})
+
+	targets := make([]model.CampaignTarget, 0, len(routers))
+	for wave, size := range waves {
+		for _, router := range routers[len(targets) : len(targets)+size] {
+			targets = append(targets, model.CampaignTarget{
+				CampaignID: campaign.ID,
+				RouterID:   router.ID,
+				Wave:       wave + 1,
+			})
+		}
+	}
+
+	// one approval holds the whole campaign back: no wave is released
+	// before a second principal approves it
+	if s.commands.requiresApproval(req.CommandType) {
+		approvalId, err := s.commands.requestApproval(ctx, req.CommandType, approvalID(campaign.ID))
+		if err != nil {
+			return nil, err
+		}
+		campaign.Status = model.CampaignAwaitingApproval
+		campaign.NextWaveAt = nil
+		campaign.ApprovalID = &approvalId
+	}
+
+	if err := s.postgresRepo.SaveCampaign(ctx, campaign, targets); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to save campaign: %v", err)
+	}
+
+	s.commands.log.InfoContext(ctx, "Campaign created", "campaign_id", campaign.ID, "command_type", campaign.CommandType,
+		"routers", len(targets), "waves", len(waves), "status", campaign.Status)
+
+	// the first wave doesn't wait for the next tick
+	if campaign.Status == model.CampaignRunning {
+		if err := s.advance(ctx, campaign, now); err != nil {
+			s.commands.log.ErrorContext(ctx, "Failed to release first wave of campaign", "campaign_id", campaign.ID, "error", err)
+		}
+	}
+
+	return s.campaignInfo(ctx, campaign.ID)
+}
+
+func (s *CampaignService) GetCampaign(ctx context.Context, req *pb.GetCampaignRequest) (*pb.CampaignInfo, error) {
+	campaignId, err := uuid.Parse(req.CampaignId)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid campaign_id: %v", err)
+	}
+
+	return s.campaignInfo(ctx, campaignId)
+}
+
+func (s *CampaignService) PauseCampaign(ctx context.Context, req *pb.CampaignActionRequest) (*pb.CampaignInfo, error) {
+	campaignId, err := s.transition(ctx, req.CampaignId, []string{model.CampaignRunning}, model.CampaignPaused, req.Reason)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.campaignInfo(ctx, campaignId)
+}
+
+func (s *CampaignService) ResumeCampaign(ctx context.Context, req *pb.CampaignActionRequest) (*pb.CampaignInfo, error) {
+	campaignId, err := s.transition(ctx, req.CampaignId, []string{model.CampaignPaused}, model.CampaignRunning, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return s.campaignInfo(ctx, campaignId)
+}
+
+// AbortCampaign stops the campaign for good and cancels its commands that
+// weren't acked yet.
+func (s *CampaignService) AbortCampaign(ctx context.Context, req *pb.CampaignActionRequest) (*pb.CampaignInfo, error) {
+	campaignId, err := s.transition(ctx, req.CampaignId,
+		[]string{model.CampaignAwaitingApproval, model.CampaignRunning, model.CampaignPaused}, model.CampaignAborted, req.Reason)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.commands.cancel(ctx, model.CancelFilter{CampaignID: &campaignId}, model.SystemActor, abortReason(req.Reason)); err != nil {
+		return nil, err
+	}
+
+	return s.campaignInfo(ctx, campaignId)
+}
+
+// transition moves the campaign from one of the from statuses to status.
+func (s *CampaignService) transition(ctx context.Context, id string, from []string, to, reason string) (uuid.UUID, error) {
+	campaignId, err := uuid.Parse(id)
+	if err != nil {
+		return uuid.Nil, status.Errorf(codes.InvalidArgument, "invalid campaign_id: %v", err)
+	}
+
+	campaign, err := s.postgresRepo.TransitionCampaign(ctx, campaignId, from, to, reason)
+	if err != nil {
+		return uuid.Nil, status.Errorf(codes.Internal, "failed to update campaign: %v", err)
+	}
+	if campaign == nil {
+		existing, err := s.postgresRepo.GetCampaign(ctx, campaignId)
+		if err != nil {
+			return uuid.Nil, status.Errorf(codes.Internal, "failed to load campaign: %v", err)
+		}
+		if existing == nil {
+			return uuid.Nil, status.Errorf(codes.NotFound, "campaign %s not found", campaignId)
+		}
+		return uuid.Nil, status.Errorf(codes.FailedPrecondition, "campaign %s is %s", campaignId, existing.Status)
+	}
+
+	s.commands.log.InfoContext(ctx, "Campaign transitioned", "campaign_id", campaignId, "status", to)
+
+	return campaignId, nil
+}
+
+// AdvanceCampaigns releases the next wave of every RUNNING campaign whose
+// soak time has passed, pauses campaigns whose failure rate is too high and
+// completes those that are done.
+func (s *CampaignService) AdvanceCampaigns(ctx context.Context) {
+	campaigns, err := s.postgresRepo.GetCampaignsByStatus(ctx, model.CampaignRunning)
+	if err != nil {
+		s.commands.log.ErrorContext(ctx, "Failed to load running campaigns", "error", err)
+		return
+	}
+
+	now := time.Now()
+	for i := range campaigns {
+		if err := s.advance(ctx, &campaigns[i], now); err != nil {
+			s.commands.log.ErrorContext(ctx, "Failed to advance campaign", "campaign_id", campaigns[i].ID, "error", err)
+		}
+	}
+}
+
+func (s *CampaignService) advance(ctx context.Context, campaign *model.Campaign, now time.Time) error {
+	progress, err := s.postgresRepo.GetCampaignProgress(ctx, campaign.ID)
+	if err != nil {
+		return fmt.Errorf("failed to load progress: %w", err)
+	}
+
+	if rate := model.FailureRate(progress, campaign.CheckedFromWave); rate > campaign.MaxFailureRate {
+		reason := fmt.Sprintf("failure rate %.2f exceeds %.2f", rate, campaign.MaxFailureRate)
+		if _, err := s.postgresRepo.TransitionCampaign(ctx, campaign.ID, []string{model.CampaignRunning}, model.CampaignPaused, reason); err != nil {
+			return fmt.Errorf("failed to pause: %w", err)
+		}
+		s.commands.log.WarnContext(ctx, "Campaign paused", "campaign_id", campaign.ID, "reason", reason)
+		return nil
+	}
+
+	if campaign.CurrentWave < campaign.TotalWaves {
+		if campaign.NextWaveAt != nil && now.Before(*campaign.NextWaveAt) {
+			return nil
+		}
+		return s.releaseWave(ctx, campaign, campaign.CurrentWave+1, now)
+	}
+
+	for _, wave := range progress {
+		if wave.Active() {
+			return nil
+		}
+	}
+
+	if _, err := s.postgresRepo.TransitionCampaign(ctx, campaign.ID, []string{model.CampaignRunning}, model.CampaignCompleted, ""); err != nil {
+		return fmt.Errorf("failed to complete: %w", err)
+	}
+	s.commands.log.InfoContext(ctx, "Campaign completed", "campaign_id", campaign.ID)
+
+	return nil
+}
+
+func (s *CampaignService) releaseWave(ctx context.Context, campaign *model.Campaign, wave int, now time.Time) error {
+	targets, err := s.postgresRepo.GetCampaignTargets(ctx, campaign.ID, wave)
+	if err != nil {
+		return fmt.Errorf("failed to load targets of wave %d: %w", wave, err)
+	}
+
+	payload, err := s.commands.openSealed(campaign.ID, campaign.Payload)
+	if err != nil {
+		return fmt.Errorf("failed to open payload: %w", err)
+	}
+
+	commands := make([]model.Command, 0, len(targets))
+	for _, target := range targets {
+		commands = append(commands, model.Command{
+			ID:          uuid.New(),
+			RouterID:    target.RouterID,
+			CommandType: campaign.CommandType,
+			Payload:     payload,
+			Status:      model.StatusPending,
+			Priority:    campaign.Priority,
+			CreatedAt:   now,
+			CampaignID:  &campaign.ID,
+		})
+	}
+
+	if err := s.commands.sealCommands(commands); err != nil {
+		return err
+	}
+
+	nextWaveAt := now.Add(campaign.SoakTime)
+	released, err := s.postgresRepo.ReleaseCampaignWave(ctx, campaign.ID, wave, &nextWaveAt, commands)
+	if err != nil {
+		return fmt.Errorf("failed to release wave %d: %w", wave, err)
+	}
+	if !released {
+		// paused, aborted or released by another instance meanwhile
+		return nil
+	}
+	metrics.CommandsCreated.WithLabelValues(campaign.CommandType).Add(float64(len(commands)))
+
+	for i := range commands {
+		if err := s.redisRepo.SaveCommand(ctx, &commands[i]); err != nil && !redis.IsUnavailable(err) {
+			s.commands.log.WarnContext(ctx, "Failed to save command in Redis", "error", err)
+		}
+	}
+
+	s.commands.log.InfoContext(ctx, "Campaign wave released", "campaign_id", campaign.ID, "wave", wave, "waves", campaign.TotalWaves, "routers", len(commands))
+
+	return nil
+}
+
+func (s *CampaignService) campaignInfo(ctx context.Context, campaignId uuid.UUID) (*pb.CampaignInfo, error) {
+	campaign, err := s.postgresRepo.GetCampaign(ctx, campaignId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load campaign: %v", err)
+	}
+	if campaign == nil {
+		return nil, status.Errorf(codes.NotFound, "campaign %s not found", campaignId)
+	}
+
+	progress, err := s.postgresRepo.GetCampaignProgress(ctx, campaignId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load campaign progress: %v", err)
+	}
+
+	return toCampaignInfo(campaign, progress), nil
+}
+
+// unknownSerials returns the requested serial numbers no router was found for.
+func unknownSerials(serials []string, routers []model.Router) []string {
+	known := make(map[string]bool, len(routers))
+	for _, router := range routers {
+		known[router.SerialNumber] = true
+	}
+
+	var unknown []string
+	for _, serial := range serials {
+		if !known[serial] {
+			unknown = append(unknown, serial)
+		}
+	}
+	return unknown
+}
+
+func abortReason(reason string) string {
+	if reason == "" {
+		return "campaign aborted"
+	}
+	return "campaign aborted: " + reason
+}
+
+func toCampaignInfo(campaign *model.Campaign, progress []model.WaveProgress) *pb.CampaignInfo {
+	info := &pb.CampaignInfo{
+		Id:             campaign.ID.String(),
+		Name:           campaign.Name,
+		CommandType:    campaign.CommandType,
+		Priority:       int32(campaign.Priority),
+		Status:         campaign.Status,
+		PauseReason:    campaign.PauseReason,
+		TotalWaves:     uint32(campaign.TotalWaves),
+		CurrentWave:    uint32(campaign.CurrentWave),
+		SoakTime:       durationpb.New(campaign.SoakTime),
+		MaxFailureRate: campaign.MaxFailureRate,
+		FailureRate:    model.FailureRate(progress, campaign.CheckedFromWave),
+		CreatedBy:      campaign.CreatedBy,
+		CreatedAt:      timestamppb.New(campaign.CreatedAt),
+	}
+
+	if campaign.ApprovalID != nil {
+		info.ApprovalId = campaign.ApprovalID.String()
+	}
+
+	if campaign.NextWaveAt != nil && campaign.CurrentWave < campaign.TotalWaves {
+		info.NextWaveAt = timestamppb.New(*campaign.NextWaveAt)
+	}
+
+	for _, wave := range progress {
+		statuses := make(map[string]uint32, len(wave.Statuses))
+		for status, n := range wave.Statuses {
+			statuses[status] = uint32(n)
+		}
+		info.Waves = append(info.Waves, &pb.WaveProgress{
+			Wave:       uint32(wave.Wave),
+			Targets:    uint32(wave.Targets()),
+			Unreleased: uint32(wave.Unreleased),
+			Statuses:   statuses,
+		})
+	}
+
+	return info
+}
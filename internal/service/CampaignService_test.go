@@ -0,0 +1,504 @@
+package service
+
+import (
+	"context"
+	"router-manager/internal/auth"
+	"router-manager/internal/config"
+	"router-manager/internal/encryption"
+	"router-manager/internal/model"
+	"router-manager/internal/pb"
+	mockspg "router-manager/internal/repository/postgres/mocks"
+	mocksred "router-manager/internal/repository/redis/mocks"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func setupCampaigns(t *testing.T) (*CampaignService, *mockspg.MockPostgresRepo, *mocksred.MockRedisRepo, context.Context) {
+	ctx := context.Background()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockPostgres := mockspg.NewMockPostgresRepo(ctrl)
+	mockRedis := mocksred.NewMockRedisRepo(ctrl)
+
+	s := NewCampaignService(mockPostgres, mockRedis, NewCommandService(mockPostgres, mockRedis))
+
+	return s, mockPostgres, mockRedis, ctx
+}
+
+func testRouters(n int) []model.Router {
+	var routers []model.Router
+	for i := 0; i < n; i++ {
+		routers = append(routers, model.Router{ID: uuid.New(), SerialNumber: uuid.NewString()})
+	}
+	return routers
+}
+
+/* --- test CreateCampaign method --- */
+
+func TestCreateCampaign_PlansWavesAndReleasesFirst(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setupCampaigns(t)
+
+	mockPostgres.EXPECT().
+		FindRoutersBySelector(gomock.Any(), model.RouterSelector{SerialPrefix: "SN"}).
+		Return(testRouters(10), nil)
+
+	var saved *model.Campaign
+	var targets []model.CampaignTarget
+	mockPostgres.EXPECT().
+		SaveCampaign(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, campaign *model.Campaign, t []model.CampaignTarget) error {
+			saved, targets = campaign, t
+			return nil
+		})
+
+	mockPostgres.EXPECT().
+		GetCampaignProgress(gomock.Any(), gomock.Any()).
+		Return(nil, nil).
+		Times(2)
+
+	mockPostgres.EXPECT().
+		GetCampaignTargets(gomock.Any(), gomock.Any(), 1).
+		DoAndReturn(func(_ context.Context, id uuid.UUID, wave int) ([]model.CampaignTarget, error) {
+			return targets[:1], nil
+		})
+
+	mockPostgres.EXPECT().
+		ReleaseCampaignWave(gomock.Any(), gomock.Any(), 1, gomock.Any(), gomock.Len(1)).
+		Return(true, nil)
+
+	mockRedis.EXPECT().
+		SaveCommand(gomock.Any(), gomock.AssignableToTypeOf(&model.Command{})).
+		Return(nil)
+
+	mockPostgres.EXPECT().
+		GetCampaign(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, id uuid.UUID) (*model.Campaign, error) {
+			return saved, nil
+		})
+
+	// the authenticated caller is recorded, not the name in the request
+	ctx = auth.NewContext(ctx, &auth.Principal{Subject: "alice"})
+	info, err := s.CreateCampaign(ctx, &pb.CreateCampaignRequest{
+		Name:           "patch",
+		CommandType:    "SECURITY_PATCH",
+		Selector:       &pb.RouterSelector{SerialPrefix: "SN"},
+		Waves:          []*pb.CampaignWave{{Count: 1}, {Percent: 50}},
+		SoakTime:       durationpb.New(time.Hour),
+		MaxFailureRate: 0.1,
+		CreatedBy:      "mallory",
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, "alice", saved.CreatedBy)
+	assert.JSONEq(t, `{"command": "SECURITY_PATCH"}`, string(saved.Payload))
+	assert.Equal(t, model.CampaignRunning, info.Status)
+	assert.Equal(t, uint32(3), info.TotalWaves)
+	assert.Equal(t, int32(90), info.Priority)
+
+	// 1 router, then 50% of 10, then the remaining 4
+	waves := make(map[int]int)
+	for _, target := range targets {
+		waves[target.Wave]++
+	}
+	assert.Equal(t, map[int]int{1: 1, 2: 5, 3: 4}, waves)
+}
+
+func TestCreateCampaign_SealsSensitivePayload(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setupCampaigns(t)
+	setupKeyring(t, s.commands)
+
+	mockPostgres.EXPECT().FindRoutersBySelector(gomock.Any(), gomock.Any()).Return(testRouters(2), nil)
+
+	var saved *model.Campaign
+	var targets []model.CampaignTarget
+	mockPostgres.EXPECT().
+		SaveCampaign(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, campaign *model.Campaign, t []model.CampaignTarget) error {
+			saved, targets = campaign, t
+			return nil
+		})
+	mockPostgres.EXPECT().GetCampaignProgress(gomock.Any(), gomock.Any()).Return(nil, nil).Times(2)
+	mockPostgres.EXPECT().
+		GetCampaignTargets(gomock.Any(), gomock.Any(), 1).
+		DoAndReturn(func(_ context.Context, id uuid.UUID, wave int) ([]model.CampaignTarget, error) {
+			return targets, nil
+		})
+
+	// the commands of the wave get the payload, sealed each on its own
+	mockPostgres.EXPECT().
+		ReleaseCampaignWave(gomock.Any(), gomock.Any(), 1, gomock.Any(), gomock.Len(2)).
+		DoAndReturn(func(_ context.Context, _ uuid.UUID, _ int, _ *time.Time, commands []model.Command) (bool, error) {
+			for _, command := range commands {
+				require.NoError(t, s.commands.keyring.Open(&command))
+				assert.JSONEq(t, `{"password": "hunter2"}`, string(command.Payload))
+			}
+			return true, nil
+		})
+	mockRedis.EXPECT().SaveCommand(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+	mockPostgres.EXPECT().
+		GetCampaign(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, id uuid.UUID) (*model.Campaign, error) {
+			return saved, nil
+		})
+
+	_, err := s.CreateCampaign(ctx, &pb.CreateCampaignRequest{
+		CommandType:    "SET_WIFI_PASSWORD",
+		Payload:        `{"password": "hunter2"}`,
+		Selector:       &pb.RouterSelector{AllRouters: true},
+		MaxFailureRate: 0.1,
+	})
+
+	require.NoError(t, err)
+	assert.True(t, encryption.IsSealed(saved.Payload))
+}
+
+func TestCreateCampaign_InvalidPayload(t *testing.T) {
+	s, _, _, ctx := setupCampaigns(t)
+
+	_, err := s.CreateCampaign(ctx, &pb.CreateCampaignRequest{
+		CommandType:    "REBOOT",
+		Payload:        `{"delay"}`,
+		Selector:       &pb.RouterSelector{AllRouters: true},
+		MaxFailureRate: 0.1,
+	})
+
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestCreateCampaign_InvalidSelector(t *testing.T) {
+	s, _, _, ctx := setupCampaigns(t)
+
+	_, err := s.CreateCampaign(ctx, &pb.CreateCampaignRequest{
+		CommandType:    "REBOOT",
+		Selector:       &pb.RouterSelector{SerialPrefix: "SN", AllRouters: true},
+		MaxFailureRate: 0.1,
+	})
+
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestCreateCampaign_InvalidFailureRate(t *testing.T) {
+	s, _, _, ctx := setupCampaigns(t)
+
+	_, err := s.CreateCampaign(ctx, &pb.CreateCampaignRequest{
+		CommandType: "REBOOT",
+		Selector:    &pb.RouterSelector{AllRouters: true},
+	})
+
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestCreateCampaign_AwaitsApproval(t *testing.T) {
+	s, mockPostgres, _, ctx := setupCampaigns(t)
+	// UPDATE_FIRMWARE needs approval by default
+	cfg := config.LoadService()
+	WithApprovals(cfg.ApprovalCommandTypes, cfg.ApprovalTTL)(s.commands)
+
+	mockPostgres.EXPECT().FindRoutersBySelector(gomock.Any(), gomock.Any()).Return(testRouters(4), nil)
+
+	var approval *model.Approval
+	mockPostgres.EXPECT().
+		SaveApproval(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, a *model.Approval) error {
+			approval = a
+			return nil
+		})
+
+	// no wave is released before the approval is decided
+	var saved *model.Campaign
+	mockPostgres.EXPECT().
+		SaveCampaign(gomock.Any(), gomock.Any(), gomock.Len(4)).
+		DoAndReturn(func(_ context.Context, campaign *model.Campaign, _ []model.CampaignTarget) error {
+			saved = campaign
+			return nil
+		})
+	mockPostgres.EXPECT().GetCampaign(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, id uuid.UUID) (*model.Campaign, error) {
+		return saved, nil
+	})
+	mockPostgres.EXPECT().GetCampaignProgress(gomock.Any(), gomock.Any()).Return(nil, nil)
+
+	ctx = auth.NewContext(ctx, &auth.Principal{Subject: "alice"})
+	info, err := s.CreateCampaign(ctx, &pb.CreateCampaignRequest{
+		CommandType:    "UPDATE_FIRMWARE",
+		Payload:        `{"version": "2.1.0"}`,
+		Selector:       &pb.RouterSelector{AllRouters: true},
+		Waves:          []*pb.CampaignWave{{Count: 1}, {Percent: 100}},
+		MaxFailureRate: 0.1,
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, model.CampaignAwaitingApproval, info.Status)
+	assert.Nil(t, info.NextWaveAt)
+	assert.Equal(t, "UPDATE_FIRMWARE", approval.CommandType)
+	assert.Equal(t, model.ApprovalPending, approval.Status)
+	assert.Equal(t, "alice", approval.RequestedBy)
+	assert.Equal(t, approval.ID.String(), info.ApprovalId)
+	assert.Equal(t, &approval.ID, saved.ApprovalID)
+}
+
+func TestCreateCampaign_UnknownSerials(t *testing.T) {
+	s, mockPostgres, _, ctx := setupCampaigns(t)
+
+	mockPostgres.EXPECT().
+		FindRoutersBySelector(gomock.Any(), gomock.Any()).
+		Return([]model.Router{{ID: uuid.New(), SerialNumber: "SN1"}}, nil)
+
+	_, err := s.CreateCampaign(ctx, &pb.CreateCampaignRequest{
+		CommandType:    "REBOOT",
+		Selector:       &pb.RouterSelector{SerialNumbers: []string{"SN1", "SN2"}},
+		MaxFailureRate: 0.1,
+	})
+
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Contains(t, err.Error(), "SN2")
+}
+
+func TestCreateCampaign_NoRouters(t *testing.T) {
+	s, mockPostgres, _, ctx := setupCampaigns(t)
+
+	mockPostgres.EXPECT().
+		FindRoutersBySelector(gomock.Any(), gomock.Any()).
+		Return(nil, nil)
+
+	_, err := s.CreateCampaign(ctx, &pb.CreateCampaignRequest{
+		CommandType:    "REBOOT",
+		Selector:       &pb.RouterSelector{AllRouters: true},
+		MaxFailureRate: 0.1,
+	})
+
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+/* --- test AdvanceCampaigns method --- */
+
+func TestAdvanceCampaigns_ReleasesNextWave(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setupCampaigns(t)
+
+	past := time.Now().Add(-time.Minute)
+	campaign := model.Campaign{
+		ID: uuid.New(), CommandType: "REBOOT", Payload: []byte(`{"delay": 60}`), Priority: 50,
+		Status: model.CampaignRunning, TotalWaves: 2, CurrentWave: 1, CheckedFromWave: 1,
+		SoakTime: time.Hour, MaxFailureRate: 0.5, NextWaveAt: &past,
+	}
+	targets := []model.CampaignTarget{
+		{CampaignID: campaign.ID, RouterID: uuid.New(), Wave: 2},
+		{CampaignID: campaign.ID, RouterID: uuid.New(), Wave: 2},
+	}
+
+	mockPostgres.EXPECT().
+		GetCampaignsByStatus(gomock.Any(), model.CampaignRunning).
+		Return([]model.Campaign{campaign}, nil)
+
+	mockPostgres.EXPECT().
+		GetCampaignProgress(gomock.Any(), campaign.ID).
+		Return([]model.WaveProgress{
+			{Wave: 1, Statuses: map[string]int{model.StatusAcked: 1}},
+			{Wave: 2, Unreleased: 2},
+		}, nil)
+
+	mockPostgres.EXPECT().
+		GetCampaignTargets(gomock.Any(), campaign.ID, 2).
+		Return(targets, nil)
+
+	mockPostgres.EXPECT().
+		ReleaseCampaignWave(gomock.Any(), campaign.ID, 2, gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ uuid.UUID, _ int, next *time.Time, commands []model.Command) (bool, error) {
+			assert.True(t, next.After(time.Now().Add(59*time.Minute)))
+			require.Len(t, commands, 2)
+			for _, command := range commands {
+				assert.Equal(t, model.StatusPending, command.Status)
+				assert.Equal(t, campaign.ID, *command.CampaignID)
+				assert.JSONEq(t, `{"delay": 60}`, string(command.Payload))
+			}
+			return true, nil
+		})
+
+	mockRedis.EXPECT().
+		SaveCommand(gomock.Any(), gomock.AssignableToTypeOf(&model.Command{})).
+		Return(nil).
+		Times(2)
+
+	s.AdvanceCampaigns(ctx)
+}
+
+func TestAdvanceCampaigns_WaitsForSoakTime(t *testing.T) {
+	s, mockPostgres, _, ctx := setupCampaigns(t)
+
+	later := time.Now().Add(time.Hour)
+	campaign := model.Campaign{
+		ID: uuid.New(), Status: model.CampaignRunning, TotalWaves: 2, CurrentWave: 1,
+		CheckedFromWave: 1, MaxFailureRate: 0.5, NextWaveAt: &later,
+	}
+
+	mockPostgres.EXPECT().
+		GetCampaignsByStatus(gomock.Any(), model.CampaignRunning).
+		Return([]model.Campaign{campaign}, nil)
+
+	mockPostgres.EXPECT().
+		GetCampaignProgress(gomock.Any(), campaign.ID).
+		Return([]model.WaveProgress{{Wave: 1, Statuses: map[string]int{model.StatusSent: 1}}}, nil)
+
+	s.AdvanceCampaigns(ctx)
+}
+
+func TestAdvanceCampaigns_PausesOnFailureRate(t *testing.T) {
+	s, mockPostgres, _, ctx := setupCampaigns(t)
+
+	past := time.Now().Add(-time.Minute)
+	campaign := model.Campaign{
+		ID: uuid.New(), Status: model.CampaignRunning, TotalWaves: 3, CurrentWave: 1,
+		CheckedFromWave: 1, MaxFailureRate: 0.2, NextWaveAt: &past,
+	}
+
+	mockPostgres.EXPECT().
+		GetCampaignsByStatus(gomock.Any(), model.CampaignRunning).
+		Return([]model.Campaign{campaign}, nil)
+
+	mockPostgres.EXPECT().
+		GetCampaignProgress(gomock.Any(), campaign.ID).
+		Return([]model.WaveProgress{
+			{Wave: 1, Statuses: map[string]int{model.StatusAcked: 3, model.StatusFailed: 1}},
+		}, nil)
+
+	mockPostgres.EXPECT().
+		TransitionCampaign(gomock.Any(), campaign.ID, []string{model.CampaignRunning}, model.CampaignPaused, gomock.Any()).
+		Return(&campaign, nil)
+
+	s.AdvanceCampaigns(ctx)
+}
+
+func TestAdvanceCampaigns_IgnoresFailuresBeforeResume(t *testing.T) {
+	s, mockPostgres, _, ctx := setupCampaigns(t)
+
+	later := time.Now().Add(time.Hour)
+	campaign := model.Campaign{
+		ID: uuid.New(), Status: model.CampaignRunning, TotalWaves: 3, CurrentWave: 1,
+		CheckedFromWave: 2, MaxFailureRate: 0.2, NextWaveAt: &later,
+	}
+
+	mockPostgres.EXPECT().
+		GetCampaignsByStatus(gomock.Any(), model.CampaignRunning).
+		Return([]model.Campaign{campaign}, nil)
+
+	mockPostgres.EXPECT().
+		GetCampaignProgress(gomock.Any(), campaign.ID).
+		Return([]model.WaveProgress{
+			{Wave: 1, Statuses: map[string]int{model.StatusFailed: 4}},
+		}, nil)
+
+	s.AdvanceCampaigns(ctx)
+}
+
+func TestAdvanceCampaigns_Completes(t *testing.T) {
+	s, mockPostgres, _, ctx := setupCampaigns(t)
+
+	campaign := model.Campaign{
+		ID: uuid.New(), Status: model.CampaignRunning, TotalWaves: 2, CurrentWave: 2,
+		CheckedFromWave: 1, MaxFailureRate: 0.5,
+	}
+
+	mockPostgres.EXPECT().
+		GetCampaignsByStatus(gomock.Any(), model.CampaignRunning).
+		Return([]model.Campaign{campaign}, nil)
+
+	mockPostgres.EXPECT().
+		GetCampaignProgress(gomock.Any(), campaign.ID).
+		Return([]model.WaveProgress{
+			{Wave: 1, Statuses: map[string]int{model.StatusAcked: 1}},
+			{Wave: 2, Statuses: map[string]int{model.StatusAcked: 2, model.StatusCancelled: 1}},
+		}, nil)
+
+	mockPostgres.EXPECT().
+		TransitionCampaign(gomock.Any(), campaign.ID, []string{model.CampaignRunning}, model.CampaignCompleted, "").
+		Return(&campaign, nil)
+
+	s.AdvanceCampaigns(ctx)
+}
+
+/* --- test campaign actions --- */
+
+func TestPauseCampaign(t *testing.T) {
+	s, mockPostgres, _, ctx := setupCampaigns(t)
+
+	campaign := &model.Campaign{ID: uuid.New(), Status: model.CampaignPaused, PauseReason: "looking into it"}
+
+	mockPostgres.EXPECT().
+		TransitionCampaign(gomock.Any(), campaign.ID, []string{model.CampaignRunning}, model.CampaignPaused, "looking into it").
+		Return(campaign, nil)
+	mockPostgres.EXPECT().GetCampaign(gomock.Any(), campaign.ID).Return(campaign, nil)
+	mockPostgres.EXPECT().GetCampaignProgress(gomock.Any(), campaign.ID).Return(nil, nil)
+
+	info, err := s.PauseCampaign(ctx, &pb.CampaignActionRequest{CampaignId: campaign.ID.String(), Reason: "looking into it"})
+
+	require.NoError(t, err)
+	assert.Equal(t, model.CampaignPaused, info.Status)
+	assert.Equal(t, "looking into it", info.PauseReason)
+}
+
+func TestResumeCampaign_NotPaused(t *testing.T) {
+	s, mockPostgres, _, ctx := setupCampaigns(t)
+
+	campaign := &model.Campaign{ID: uuid.New(), Status: model.CampaignCompleted}
+
+	mockPostgres.EXPECT().
+		TransitionCampaign(gomock.Any(), campaign.ID, []string{model.CampaignPaused}, model.CampaignRunning, "").
+		Return(nil, nil)
+	mockPostgres.EXPECT().GetCampaign(gomock.Any(), campaign.ID).Return(campaign, nil)
+
+	_, err := s.ResumeCampaign(ctx, &pb.CampaignActionRequest{CampaignId: campaign.ID.String()})
+
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestResumeCampaign_NotFound(t *testing.T) {
+	s, mockPostgres, _, ctx := setupCampaigns(t)
+
+	id := uuid.New()
+	mockPostgres.EXPECT().TransitionCampaign(gomock.Any(), id, gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil)
+	mockPostgres.EXPECT().GetCampaign(gomock.Any(), id).Return(nil, nil)
+
+	_, err := s.ResumeCampaign(ctx, &pb.CampaignActionRequest{CampaignId: id.String()})
+
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestAbortCampaign_CancelsCommands(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setupCampaigns(t)
+
+	campaign := &model.Campaign{ID: uuid.New(), Status: model.CampaignAborted}
+	pending := model.Command{ID: uuid.New(), RouterID: uuid.New(), Status: model.StatusCancelled, CampaignID: &campaign.ID}
+
+	mockPostgres.EXPECT().
+		TransitionCampaign(gomock.Any(), campaign.ID, []string{model.CampaignAwaitingApproval, model.CampaignRunning, model.CampaignPaused}, model.CampaignAborted, "bad build").
+		Return(campaign, nil)
+
+	mockPostgres.EXPECT().
+		CancelCommands(gomock.Any(), model.CancelFilter{CampaignID: &campaign.ID}, gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ model.CancelFilter, cancellation model.Cancellation) ([]model.Command, error) {
+			assert.Equal(t, model.SystemActor, cancellation.By)
+			assert.Equal(t, "campaign aborted: bad build", cancellation.Reason)
+			return []model.Command{pending}, nil
+		})
+
+	mockRedis.EXPECT().
+		UpdateCommands(gomock.Any(), []model.Command{pending}).
+		Return(nil)
+
+	mockPostgres.EXPECT().GetCampaign(gomock.Any(), campaign.ID).Return(campaign, nil)
+	mockPostgres.EXPECT().GetCampaignProgress(gomock.Any(), campaign.ID).Return(nil, nil)
+
+	info, err := s.AbortCampaign(ctx, &pb.CampaignActionRequest{CampaignId: campaign.ID.String(), Reason: "bad build"})
+
+	require.NoError(t, err)
+	assert.Equal(t, model.CampaignAborted, info.Status)
+}
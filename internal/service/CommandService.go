@@ -2,38 +2,192 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"router-manager/internal/auth"
+	"router-manager/internal/encryption"
+	"router-manager/internal/logging"
 	"router-manager/internal/metrics"
 	"router-manager/internal/model"
 	"router-manager/internal/pb"
 	"router-manager/internal/repository/postgres"
 	"router-manager/internal/repository/redis"
+	"router-manager/internal/signing"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// page sizes of ListCommands
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
 type CommandService struct {
 	pb.UnimplementedCommandServiceServer
 
 	redisRepo    redis.RedisRepo
 	postgresRepo postgres.PostgresRepo
+
+	// keys are remembered for idempotencyKeyTTL; a request holds its key for
+	// idempotencyKeyLease, then a retry may take it over
+	idempotencyKeyTTL   time.Duration
+	idempotencyKeyLease time.Duration
+
+	// large sends are written sendBatchSize routers at a time; above
+	// asyncSendThreshold routers they run as a job throttled to sendRate
+	// routers per second (0 = unlimited)
+	sendBatchSize      int
+	asyncSendThreshold int
+	sendRate           float64
+
+	// command types whose commands wait for a second principal's approval
+	// for at most approvalTTL
+	approvalTypes map[string]bool
+	approvalTTL   time.Duration
+
+	// unknown serial numbers are registered as new routers, not skipped
+	autoRegisterRouters bool
+
+	// routers seen within it count as online
+	routerOnlineWindow time.Duration
+
+	// signs new commands; nil = commands are sent unsigned
+	signer *signing.Signer
+	// seals the payloads of sensitive command types; nil = stored in plaintext
+	keyring *encryption.Keyring
+
+	log *slog.Logger
+}
+
+// Option configures optional CommandService settings.
+type Option func(s *CommandService)
+
+// WithIdempotencyKeyTTL sets how long SendCommand idempotency keys are remembered.
+func WithIdempotencyKeyTTL(ttl time.Duration) Option {
+	return func(s *CommandService) {
+		s.idempotencyKeyTTL = ttl
+	}
+}
+
+// WithIdempotencyKeyLease sets how long a SendCommand in progress holds its
+// idempotency key; if it didn't finish by then, a retry takes the key over.
+func WithIdempotencyKeyLease(lease time.Duration) Option {
+	return func(s *CommandService) {
+		s.idempotencyKeyLease = lease
+	}
+}
+
+// WithSendBatching sets how many routers a bulk write covers, above how many
+// routers SendCommand starts a job and how many routers per second a job
+// sends to.
+func WithSendBatching(batchSize, asyncThreshold int, rate float64) Option {
+	return func(s *CommandService) {
+		if batchSize > 0 {
+			s.sendBatchSize = batchSize
+		}
+		s.asyncSendThreshold = asyncThreshold
+		s.sendRate = rate
+	}
+}
+
+// WithApprovals makes commands of the given types wait for approval, which
+// expires after ttl.
+func WithApprovals(commandTypes []string, ttl time.Duration) Option {
+	return func(s *CommandService) {
+		s.approvalTypes = make(map[string]bool)
+		for _, commandType := range commandTypes {
+			s.approvalTypes[commandType] = true
+		}
+		s.approvalTTL = ttl
+	}
+}
+
+// WithRouterRegistration sets whether SendCommand registers routers it
+// doesn't know yet or skips them.
+func WithRouterRegistration(autoRegister bool) Option {
+	return func(s *CommandService) {
+		s.autoRegisterRouters = autoRegister
+	}
+}
+
+// WithRouterOnlineWindow sets how recently a router must have called to
+// count as online in the metrics.
+func WithRouterOnlineWindow(window time.Duration) Option {
+	return func(s *CommandService) {
+		s.routerOnlineWindow = window
+	}
+}
+
+// WithCommandSigner signs commands when they are created.
+func WithCommandSigner(signer *signing.Signer) Option {
+	return func(s *CommandService) {
+		s.signer = signer
+	}
+}
+
+// WithPayloadEncryption seals the payloads of sensitive command types when
+// they are created.
+func WithPayloadEncryption(keyring *encryption.Keyring) Option {
+	return func(s *CommandService) {
+		s.keyring = keyring
+	}
 }
 
-func NewCommandService(pgRepo postgres.PostgresRepo, redisRepo redis.RedisRepo) *CommandService {
-	return &CommandService{
+// WithLogger sets the logger of the service, slog's default one otherwise.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *CommandService) {
+		s.log = logger
+	}
+}
+
+func NewCommandService(pgRepo postgres.PostgresRepo, redisRepo redis.RedisRepo, opts ...Option) *CommandService {
+	s := &CommandService{
 		postgresRepo: pgRepo,
 		redisRepo:    redisRepo,
+
+		idempotencyKeyTTL:   24 * time.Hour,
+		idempotencyKeyLease: time.Minute,
+
+		sendBatchSize:      500,
+		asyncSendThreshold: 1000,
+		sendRate:           1000,
+
+		approvalTTL: 24 * time.Hour,
+
+		autoRegisterRouters: true,
+
+		routerOnlineWindow: 2 * time.Minute,
+
+		log: slog.Default(),
+	}
+
+	for _, opt := range opts {
+		opt(s)
 	}
+
+	return s
 }
 
-func (s *CommandService) SendCommand(ctx context.Context, req *pb.SendCommandRequest) (*pb.SendCommandResponse, error) {
+func (s *CommandService) SendCommand(ctx context.Context, req *pb.SendCommandRequest) (_ *pb.SendCommandResponse, err error) {
 	// metrics initialization
-	metrics.SendCommandCalls.Inc()
+	defer func() {
+		metrics.SendCommandCalls.WithLabelValues(metrics.Code(err)).Inc()
+	}()
 
 	timer := prometheus.NewTimer(metrics.SendCommandHistogramm)
 	defer timer.ObserveDuration()
@@ -44,189 +198,1682 @@ func (s *CommandService) SendCommand(ctx context.Context, req *pb.SendCommandReq
 	if req.CommandType == "" {
 		return nil, fmt.Errorf("no command specified")
 	}
-
-	var commandsIds []string
-	for _, routers := range req.Routers {
-		if routers.SerialNumber == "" {
+	// a dry run reports invalid routers in its plan instead
+	for _, router := range req.Routers {
+		if router.SerialNumber == "" && !req.DryRun {
 			return nil, fmt.Errorf("router serial_number is required")
 		}
+	}
+	if req.Priority != nil && !model.ValidPriority(int(*req.Priority)) {
+		return nil, status.Errorf(codes.InvalidArgument, "priority must be between %d and %d", model.MinPriority, model.MaxPriority)
+	}
+	if err := validatePayload(req.Payload); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid payload: %v", err)
+	}
 
-		log.Printf("Sending command to router %s", routers.SerialNumber)
+	if req.DryRun {
+		return s.planSend(ctx, req)
+	}
 
-		now := time.Now()
-		router := &model.Router{
-			ID:           uuid.New(),
-			SerialNumber: routers.SerialNumber,
-			LastSeenAt:   &now,
-			CreatedAt:    now,
-			IPAddress:    nil,
-		}
-		s.SaveRouter(ctx, router)
+	var result *sendResult
+	if req.IdempotencyKey != "" {
+		result, err = s.sendIdempotent(ctx, req)
+	} else {
+		result, err = s.dispatch(ctx, req, uuid.New())
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		cmd := &model.Command{
-			ID:          uuid.New(),
-			RouterID:    router.ID,
-			CommandType: req.CommandType,
-			Payload:     json.RawMessage(fmt.Sprintf(`{"command": "%s"}`, req.CommandType)),
-			Status:      "PENDING",
-			CreatedAt:   time.Now(),
-		}
+	response := &pb.SendCommandResponse{
+		Status:    model.StatusPending,
+		Coalesced: result.coalesced,
+		Skipped:   result.skipped,
+	}
+	if result.approvalId != nil {
+		response.Status = model.StatusAwaitingApproval
+		response.ApprovalId = result.approvalId.String()
+	}
+	for _, id := range result.ids {
+		response.Id = append(response.Id, id.String())
+	}
+	if result.jobId != nil {
+		response.JobId = result.jobId.String()
+	}
+
+	return response, nil
+}
 
-		if err := s.postgresRepo.SaveCommand(ctx, cmd); err != nil {
-			return nil, fmt.Errorf("failed to save command in PostgreSQL: %w", err)
+// sendResult is what SendCommand reports: the commands, or the job that
+// creates them, the approval they await and the routers skipped.
+type sendResult struct {
+	ids        []uuid.UUID
+	coalesced  []*pb.CoalescedCommand
+	skipped    []*pb.RouterPlan
+	jobId      *uuid.UUID
+	approvalId *uuid.UUID
+}
+
+// dispatch sends the commands right away, or starts a job if there are more
+// routers than asyncSendThreshold. Commands of types that need approval are
+// created AWAITING_APPROVAL under a new approval. What it creates gets ids
+// derived from seed. If sending fails halfway, the result holds the commands
+// sent so far.
+func (s *CommandService) dispatch(ctx context.Context, req *pb.SendCommandRequest, seed uuid.UUID) (*sendResult, error) {
+	var approvalId *uuid.UUID
+	if s.requiresApproval(req.CommandType) {
+		id, err := s.requestApproval(ctx, req.CommandType, approvalID(seed))
+		if err != nil {
+			return nil, err
 		}
+		approvalId = &id
+	}
 
-		if err := s.redisRepo.SaveCommand(ctx, cmd); err != nil {
-			fmt.Printf("Warning: failed to save command in Redis: %v\n", err)
+	if s.asyncSendThreshold > 0 && len(req.Routers) > s.asyncSendThreshold {
+		jobId, err := s.startSendJob(ctx, req, approvalId, jobID(seed))
+		if err != nil {
+			return nil, err
 		}
+		return &sendResult{jobId: jobId, approvalId: approvalId}, nil
+	}
+
+	return s.send(ctx, req, approvalId, seed)
+}
 
-		commandsIds = append(commandsIds, cmd.ID.String())
+// Everything a send creates has an id derived from the seed of the send, so
+// that a retry with the same seed finds what an earlier attempt created
+// instead of creating it again: the command for the i-th router of the
+// request, the approval and the job.
+
+func commandID(seed uuid.UUID, i int) uuid.UUID {
+	return uuid.NewSHA1(seed, []byte("command/"+strconv.Itoa(i)))
+}
+
+func approvalID(seed uuid.UUID) uuid.UUID {
+	return uuid.NewSHA1(seed, []byte("approval"))
+}
+
+func jobID(seed uuid.UUID) uuid.UUID {
+	return uuid.NewSHA1(seed, []byte("job"))
+}
+
+// requiresApproval reports whether commands of the type wait for approval.
+func (s *CommandService) requiresApproval(commandType string) bool {
+	return s.approvalTypes[commandType]
+}
+
+// requestApproval stores a PENDING approval requested by the caller.
+func (s *CommandService) requestApproval(ctx context.Context, commandType string, id uuid.UUID) (uuid.UUID, error) {
+	now := time.Now()
+	approval := &model.Approval{
+		ID:          id,
+		CommandType: commandType,
+		Status:      model.ApprovalPending,
+		RequestedBy: callerFromContext(ctx),
+		RequestedAt: now,
+		ExpiresAt:   now.Add(s.approvalTTL),
+	}
+	if err := s.postgresRepo.SaveApproval(ctx, approval); err != nil {
+		return uuid.Nil, status.Errorf(codes.Internal, "failed to save approval: %v", err)
 	}
 
-	log.Printf("Commands sent.")
+	s.log.InfoContext(ctx, "Approval requested", "approval_id", approval.ID, "command_type", commandType, "requested_by", approval.RequestedBy)
 
-	return &pb.SendCommandResponse{
-		Status: "PENDING",
-		Id:     commandsIds,
-	}, nil
+	return approval.ID, nil
 }
 
-func (s *CommandService) PollCommands(ctx context.Context, req *pb.PollRequest) (*pb.PollResponse, error) {
-	// metrics initialization
-	metrics.CommadsPollCalls.Inc()
+// sendPriority is the priority of the request, or the one of its command type.
+func sendPriority(req *pb.SendCommandRequest) int {
+	if req.Priority != nil {
+		return int(*req.Priority)
+	}
+	return model.LookupCommandType(req.CommandType).Priority
+}
 
-	timer := prometheus.NewTimer(metrics.CommandsPollHistogramm)
-	defer timer.ObserveDuration()
+// commandPayload is the payload set for commands of the type, or their
+// default one.
+func commandPayload(commandType, payload string) json.RawMessage {
+	if payload == "" {
+		return model.DefaultPayload(commandType)
+	}
+	return json.RawMessage(payload)
+}
 
-	if req.SerialNumber == "" {
-		return nil, fmt.Errorf("router serial_number is required")
+// validatePayload checks that a payload set by the caller is JSON.
+func validatePayload(payload string) error {
+	if payload != "" && !json.Valid([]byte(payload)) {
+		return fmt.Errorf("not valid JSON")
 	}
+	return nil
+}
 
-	router := s.findRouter(ctx, req.RouterId)
-	if router == nil {
-		return nil, fmt.Errorf("there is no router with such serial_number: %s", req.SerialNumber)
+// send creates one command per router, sendBatchSize routers at a time.
+// Commands coalesced into an already PENDING one return the id of that
+// command and are reported in coalesced. If a batch fails, the result holds
+// the commands of the batches before it.
+func (s *CommandService) send(ctx context.Context, req *pb.SendCommandRequest, approvalId *uuid.UUID, seed uuid.UUID) (*sendResult, error) {
+	result := &sendResult{approvalId: approvalId}
+	for start := 0; start < len(req.Routers); start += s.sendBatchSize {
+		routers := req.Routers[start:min(start+s.sendBatchSize, len(req.Routers))]
+
+		if err := s.sendBatch(ctx, req, routers, start, seed, approvalId, result); err != nil {
+			return result, err
+		}
 	}
 
-	log.Printf("Poll commands for router %s", req.RouterId)
+	s.log.InfoContext(ctx, "Commands sent", "command_type", req.CommandType, "routers", len(req.Routers))
 
+	return result, nil
+}
+
+// resolveRouters finds the routers of the targets and returns a plan per
+// target, SKIP with its reason for the targets that get no command, and the
+// routers to send to: routers[i] is the router of plans[at[i]]. Unknown
+// routers are registered if autoRegisterRouters is set; in a dry run they
+// are only given an id and marked for registration, nothing is written.
+func (s *CommandService) resolveRouters(ctx context.Context, targets []*pb.Router, dryRun bool) ([]*pb.RouterPlan, []model.Router, []int, error) {
 	now := time.Now()
-	router.LastSeenAt = &now
+	plans := make([]*pb.RouterPlan, len(targets))
+	candidates := make([]model.Router, 0, len(targets))
+	var candidateAt []int
+	for i, target := range targets {
+		plans[i] = &pb.RouterPlan{SerialNumber: target.SerialNumber}
+		if target.SerialNumber == "" {
+			plans[i].Action, plans[i].Reason = model.PlanSkip, model.SkipInvalid
+			continue
+		}
+		candidates = append(candidates, model.Router{
+			ID:           uuid.New(),
+			SerialNumber: target.SerialNumber,
+			LastSeenAt:   &now,
+			CreatedAt:    now,
+		})
+		candidateAt = append(candidateAt, i)
+	}
 
-	s.SaveRouter(ctx, router)
+	known := make(map[string]bool)
+	if s.autoRegisterRouters && !dryRun {
+		// the upsert writes the ids and decommissioning of known routers back
+		if err := s.postgresRepo.SaveRouters(ctx, candidates); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to save routers in PostgreSQL: %w", err)
+		}
+		for _, router := range candidates {
+			known[router.SerialNumber] = true
+		}
+	} else {
+		var serials []string
+		for _, router := range candidates {
+			serials = append(serials, router.SerialNumber)
+		}
+		found, err := s.postgresRepo.FindRoutersBySelector(ctx, model.RouterSelector{SerialNumbers: serials})
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to find routers in PostgreSQL: %w", err)
+		}
+		bySerial := make(map[string]model.Router, len(found))
+		for _, router := range found {
+			bySerial[router.SerialNumber] = router
+			known[router.SerialNumber] = true
+		}
 
-	var commands []model.Command
-	commands, err := s.redisRepo.FindCommandsByRouterId(ctx, router.ID)
-	if err != nil {
-		log.Printf("Failed to get commands from Redis: %v", err)
+		// a serial repeated in the request is one new router
+		newIds := make(map[string]uuid.UUID)
+		for i := range candidates {
+			serial := candidates[i].SerialNumber
+			if router, ok := bySerial[serial]; ok {
+				candidates[i] = router
+				continue
+			}
+			if _, ok := newIds[serial]; !ok {
+				newIds[serial] = candidates[i].ID
+			}
+			candidates[i].ID = newIds[serial]
+		}
 	}
 
-	if len(commands) == 0 {
-		commands, err = s.postgresRepo.GetCommandsByRouterId(ctx, router.ID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to load commands from DB: %w", err)
+	var routers []model.Router
+	var at []int
+	for i, router := range candidates {
+		plan := plans[candidateAt[i]]
+		switch {
+		case !known[router.SerialNumber] && !s.autoRegisterRouters:
+			plan.Action, plan.Reason = model.PlanSkip, model.SkipUnknown
+			continue
+		case router.DecommissionedAt != nil:
+			plan.Action, plan.Reason = model.PlanSkip, model.SkipDecommissioned
+			continue
+		case known[router.SerialNumber]:
+			plan.RouterId = router.ID.String()
+		default:
+			plan.Register = true
 		}
+		routers = append(routers, router)
+		at = append(at, candidateAt[i])
 	}
 
-	var pbCommandsResponse []*pb.Command
-	for _, command := range commands {
-		pbCommandsResponse = append(pbCommandsResponse, &pb.Command{
-			Id:          command.ID.String(),
-			CommandType: command.CommandType,
-			Payload:     string(command.Payload),
-			CreatedAt:   timestamppb.New(command.CreatedAt),
-		})
+	return plans, routers, at, nil
+}
+
+// newCommands returns a command of the request for each router.
+func newCommands(routers []model.Router, req *pb.SendCommandRequest, approvalId *uuid.UUID, now time.Time) []model.Command {
+	commandStatus := model.StatusPending
+	if approvalId != nil {
+		commandStatus = model.StatusAwaitingApproval
 	}
 
-	err = s.ChangeStatus(ctx, router.ID, "SENT")
-	if err != nil {
-		return nil, err
+	commands := make([]model.Command, 0, len(routers))
+	for _, router := range routers {
+		commands = append(commands, model.Command{
+			ID:          uuid.New(),
+			RouterID:    router.ID,
+			CommandType: req.CommandType,
+			Payload:     commandPayload(req.CommandType, req.Payload),
+			Status:      commandStatus,
+			Priority:    sendPriority(req),
+			CreatedAt:   now,
+			ApprovalID:  approvalId,
+		})
 	}
+	return commands
+}
 
-	log.Printf("Commands sent to router.")
+// sealCommands signs the commands and seals the payloads of sensitive ones
+// in place, as far as signing and encryption are set up.
+func (s *CommandService) sealCommands(commands []model.Command) error {
+	for i := range commands {
+		if err := s.sealCommand(&commands[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	return &pb.PollResponse{
-		Commands: pbCommandsResponse,
-	}, nil
+// sealCommand signs the command, then seals its payload if its type is
+// sensitive: the signature is over the plaintext, which is what routers get.
+func (s *CommandService) sealCommand(cmd *model.Command) error {
+	if s.signer != nil {
+		if err := s.signer.Sign(cmd, cmd.CreatedAt); err != nil {
+			return fmt.Errorf("failed to sign command %s: %w", cmd.ID, err)
+		}
+	}
+	if s.keyring != nil && model.LookupCommandType(cmd.CommandType).Sensitive {
+		if err := s.keyring.Seal(cmd); err != nil {
+			return fmt.Errorf("failed to seal payload of command %s: %w", cmd.ID, err)
+		}
+	}
+	return nil
 }
 
-func (s *CommandService) AckCommand(ctx context.Context, req *pb.AckRequest) (*pb.AckResponse, error) {
-	// metrics initialization
-	metrics.CommandsAckCalls.Inc()
+// openPayload replaces a sealed payload of the command with its plaintext.
+func (s *CommandService) openPayload(cmd *model.Command) error {
+	if !encryption.IsSealed(cmd.Payload) {
+		return nil
+	}
+	if s.keyring == nil {
+		return fmt.Errorf("payload of command %s is sealed but no payload keys are set", cmd.ID)
+	}
+	return s.keyring.Open(cmd)
+}
 
-	timer := prometheus.NewTimer(metrics.CommandsAckHistogramm)
-	defer timer.ObserveDuration()
+// sealSensitive returns the payload sealed for the id if commands of the
+// type are sensitive: for payloads kept until commands are made of them,
+// e.g. by a campaign.
+func (s *CommandService) sealSensitive(commandType string, id uuid.UUID, payload json.RawMessage) (json.RawMessage, error) {
+	if s.keyring == nil || !model.LookupCommandType(commandType).Sensitive {
+		return payload, nil
+	}
+	return s.keyring.SealPayload(id, payload)
+}
 
-	if req.SerialNumber == "" {
-		return nil, fmt.Errorf("serial_number is required")
+// openSealed returns the plaintext of a payload sealSensitive sealed for the id.
+func (s *CommandService) openSealed(id uuid.UUID, payload json.RawMessage) (json.RawMessage, error) {
+	if !encryption.IsSealed(payload) {
+		return payload, nil
 	}
-	if req.CommandType == "" {
-		return nil, fmt.Errorf("command_type is required")
+	if s.keyring == nil {
+		return nil, fmt.Errorf("payload of %s is sealed but no payload keys are set", id)
 	}
+	return s.keyring.OpenPayload(id, payload)
+}
 
-	router := s.findRouter(ctx, req.RouterId)
-	if router == nil {
-		return nil, fmt.Errorf("there is no router with such serial_number: %s", req.SerialNumber)
+// revealPayloads prepares commands to be shown to the caller: the payloads
+// of sensitive commands are opened for callers allowed to RevealPayloads
+// for them, and removed for the others. It returns the ids of the commands
+// whose payloads were removed.
+func (s *CommandService) revealPayloads(ctx context.Context, commands ...*model.Command) map[uuid.UUID]bool {
+	redacted := make(map[uuid.UUID]bool)
+	serials := make(map[uuid.UUID]string)
+
+	for _, cmd := range commands {
+		if !model.LookupCommandType(cmd.CommandType).Sensitive && !encryption.IsSealed(cmd.Payload) {
+			continue
+		}
+
+		serial, ok := serials[cmd.RouterID]
+		if !ok {
+			if router := s.findRouter(ctx, cmd.RouterID.String()); router != nil {
+				serial = router.SerialNumber
+			}
+			serials[cmd.RouterID] = serial
+		}
+
+		if auth.Allowed(ctx, model.RevealPayloads, cmd.CommandType, serial) {
+			err := s.openPayload(cmd)
+			if err == nil {
+				continue
+			}
+			s.log.ErrorContext(ctx, "Failed to open payload", logging.CommandIDKey, cmd.ID, "error", err)
+		}
+		cmd.Payload = nil
+		redacted[cmd.ID] = true
 	}
 
-	log.Printf("Ack commands for router %s", req.RouterId)
+	return redacted
+}
 
-	now := time.Now()
-	router.LastSeenAt = &now
+// sendPolicy is the coalescing policy of the commands of a send.
+func sendPolicy(commandType string, approvalId *uuid.UUID) string {
+	if approvalId != nil {
+		// a command nobody approved yet must not replace an approved one
+		return model.CoalesceKeepAll
+	}
+	return model.LookupCommandType(commandType).Coalesce
+}
 
-	s.SaveRouter(ctx, router)
+// sendBatch stores the routers and their commands with one bulk write per
+// store, applying the coalescing policy of the command type, and adds them
+// to result. PostgreSQL decides; the cache follows its decision. With an
+// approval the commands are created AWAITING_APPROVAL. The targets are the
+// routers of the request from start on, the command ids are derived from seed.
+func (s *CommandService) sendBatch(ctx context.Context, req *pb.SendCommandRequest, targets []*pb.Router, start int, seed uuid.UUID, approvalId *uuid.UUID, result *sendResult) error {
+	commandType := req.CommandType
+	plans, routers, at, err := s.resolveRouters(ctx, targets, false)
+	if err != nil {
+		return err
+	}
+	for _, plan := range plans {
+		if plan.Action == model.PlanSkip {
+			result.skipped = append(result.skipped, plan)
+		}
+	}
+	if len(routers) == 0 {
+		return nil
+	}
+
+	if err := s.redisRepo.SaveRouters(ctx, routers); err != nil && !redis.IsUnavailable(err) {
+		s.log.WarnContext(ctx, "Failed to save routers in Redis", "error", err)
+	}
 
-	err := s.ChangeStatus(ctx, router.ID, "ACKED")
+	commands := newCommands(routers, req, approvalId, time.Now())
+	for i := range commands {
+		commands[i].ID = commandID(seed, start+at[i])
+	}
+	if err := s.sealCommands(commands); err != nil {
+		return err
+	}
+	policy := sendPolicy(commandType, approvalId)
+	results, err := s.postgresRepo.SaveCommandsCoalesced(ctx, commands, policy)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to save commands in PostgreSQL: %w", err)
 	}
 
-	log.Printf("Commands acked.")
+	var coalesced int
+	var cached []model.Command
+	for i, commandResult := range results {
+		command := commands[i]
+		serialNumber := targets[at[i]].SerialNumber
+		if commandResult.AlreadySaved {
+			result.ids = append(result.ids, command.ID)
+			continue
+		}
+		if commandResult.ExistingID != nil {
+			result.coalesced = append(result.coalesced, &pb.CoalescedCommand{
+				SerialNumber: serialNumber,
+				CommandId:    commandResult.ExistingID.String(),
+				Policy:       policy,
+			})
+			result.ids = append(result.ids, *commandResult.ExistingID)
+			coalesced++
+			continue
+		}
+
+		result.ids = append(result.ids, command.ID)
+		cached = append(cached, command)
+		metrics.CommandsCreated.WithLabelValues(commandType).Inc()
+		if len(commandResult.Replaced) == 0 {
+			continue
+		}
 
-	return &pb.AckResponse{
-		Status: "ACKED",
-	}, nil
+		info := &pb.CoalescedCommand{
+			SerialNumber: serialNumber,
+			CommandId:    command.ID.String(),
+			Policy:       policy,
+		}
+		for _, replaced := range commandResult.Replaced {
+			info.ReplacedIds = append(info.ReplacedIds, replaced.ID.String())
+			cached = append(cached, replaced)
+		}
+		result.coalesced = append(result.coalesced, info)
+		coalesced++
+	}
+
+	// replaced commands come after their replacement, so the cache ends up
+	// with their final CANCELLED version even if they were sent in this batch
+	if err := s.redisRepo.SaveCommands(ctx, cached); err != nil && !redis.IsUnavailable(err) {
+		s.log.WarnContext(ctx, "Failed to save commands in Redis", "error", err)
+	}
+
+	if coalesced > 0 {
+		s.log.InfoContext(ctx, "Commands coalesced", "command_type", commandType, "coalesced", coalesced, "commands", len(commands))
+	}
+	if skipped := len(targets) - len(routers); skipped > 0 {
+		s.log.InfoContext(ctx, "Routers skipped", "skipped", skipped, "routers", len(targets))
+	}
+
+	return nil
 }
 
-func (s *CommandService) findRouter(ctx context.Context, id string) *model.Router {
-	// check if we've already had this router
-	router, err := s.redisRepo.FindRouterByRouterId(ctx, id)
-	if err != nil {
-		fmt.Printf("Redis lookup failed for %s: %v\n", id, err)
+// planSend runs the routers of a dry run through sendBatch's resolution and
+// coalescing without writing anything, and reports what would happen to each.
+func (s *CommandService) planSend(ctx context.Context, req *pb.SendCommandRequest) (*pb.SendCommandResponse, error) {
+	response := &pb.SendCommandResponse{Status: model.StatusPending}
+
+	// the approval isn't requested; any id gives the commands their policy
+	var approvalId *uuid.UUID
+	if s.requiresApproval(req.CommandType) {
+		response.Status = model.StatusAwaitingApproval
+		placeholder := uuid.Nil
+		approvalId = &placeholder
 	}
+	policy := sendPolicy(req.CommandType, approvalId)
 
-	// check in Postgres
-	if router == nil {
-		router, err = s.postgresRepo.FindRouterByRouterId(ctx, id)
+	// pending commands of a batch are read before the batch is simulated, so
+	// commands of earlier batches are carried over like the writes would be
+	var earlier []model.Command
+	for start := 0; start < len(req.Routers); start += s.sendBatchSize {
+		targets := req.Routers[start:min(start+s.sendBatchSize, len(req.Routers))]
+
+		plans, routers, at, err := s.resolveRouters(ctx, targets, true)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to resolve routers: %v", err)
+		}
+
+		now := time.Now()
+		commands := newCommands(routers, req, approvalId, now)
+
+		var pending []model.Command
+		if policy != model.CoalesceKeepAll && len(commands) > 0 {
+			pending, err = s.postgresRepo.GetPendingCommands(ctx, commands)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to load pending commands: %v", err)
+			}
+			pending = mergePending(pending, earlier)
+		}
+
+		results, inserted, replaced, err := model.CoalesceBatch(policy, commands, pending, now)
 		if err != nil {
-			fmt.Printf("Router not found in DB: %v\n", err)
+			return nil, status.Errorf(codes.Internal, "failed to coalesce commands: %v", err)
+		}
+		earlier = append(append(earlier, inserted...), replaced...)
+
+		for i, result := range results {
+			plan := plans[at[i]]
+			if result.ExistingID != nil {
+				plan.Action, plan.Reason = model.PlanCoalesce, policy
+				plan.CommandId = result.ExistingID.String()
+				continue
+			}
+
+			plan.Action = model.PlanCreate
+			for _, superseded := range result.Replaced {
+				plan.Reason = policy
+				plan.ReplacedIds = append(plan.ReplacedIds, superseded.ID.String())
+			}
 		}
+		response.Plan = append(response.Plan, plans...)
 	}
 
-	return router
+	return response, nil
 }
 
-func (s *CommandService) SaveRouter(ctx context.Context, router *model.Router) {
-	if err := s.postgresRepo.SaveRouter(ctx, router); err != nil {
-		log.Printf("ERROR: failed to save router in PostgreSQL: %v", err)
-		return
+// mergePending applies the commands a dry run would have written in earlier
+// batches to the pending commands read from PostgreSQL: new ones are added,
+// replaced ones are no longer PENDING.
+func mergePending(pending, earlier []model.Command) []model.Command {
+	if len(earlier) == 0 {
+		return pending
 	}
 
-	// Redis — опционально
-	if err := s.redisRepo.SaveRouter(ctx, router); err != nil {
-		log.Printf("WARNING: failed to save router in Redis: %v", err)
+	// a command may be written twice: created, then replaced
+	latest := make(map[uuid.UUID]model.Command, len(earlier))
+	var order []uuid.UUID
+	for _, cmd := range earlier {
+		if _, ok := latest[cmd.ID]; !ok {
+			order = append(order, cmd.ID)
+		}
+		latest[cmd.ID] = cmd
+	}
+
+	var merged []model.Command
+	for _, cmd := range pending {
+		if updated, ok := latest[cmd.ID]; ok {
+			cmd = updated
+			delete(latest, cmd.ID)
+		}
+		if cmd.Status == model.StatusPending {
+			merged = append(merged, cmd)
+		}
+	}
+	for _, id := range order {
+		if cmd, ok := latest[id]; ok && cmd.Status == model.StatusPending {
+			merged = append(merged, cmd)
+		}
 	}
+	return merged
 }
 
-func (s *CommandService) ChangeStatus(ctx context.Context, routerId uuid.UUID, status string) error {
-	err := s.redisRepo.ChangeStatusByRouterId(ctx, routerId, status)
-	if err != nil {
-		return fmt.Errorf("failed to change command status in Redis: %w", err)
+// startSendJob queues a job with the id for the request; the job workers run
+// it. A sensitive payload is kept sealed in the job.
+func (s *CommandService) startSendJob(ctx context.Context, req *pb.SendCommandRequest, approvalId *uuid.UUID, id uuid.UUID) (*uuid.UUID, error) {
+	if req.Payload != "" {
+		payload, err := s.sealSensitive(req.CommandType, id, json.RawMessage(req.Payload))
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to seal payload: %v", err)
+		}
+		req = proto.Clone(req).(*pb.SendCommandRequest)
+		req.Payload = string(payload)
 	}
 
-	err = s.postgresRepo.ChangeStatusByRouterId(ctx, routerId, status)
+	request, err := protojson.Marshal(req)
 	if err != nil {
-		return fmt.Errorf("failed to change command status in DB: %w", err)
+		return nil, status.Errorf(codes.Internal, "failed to encode request: %v", err)
 	}
 
-	return nil
+	now := time.Now()
+	job := &model.Job{
+		ID:         id,
+		Kind:       model.JobSendCommand,
+		Status:     model.JobPending,
+		Request:    request,
+		Total:      len(req.Routers),
+		ApprovalID: approvalId,
+		CreatedBy:  callerFromContext(ctx),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := s.postgresRepo.SaveJob(ctx, job); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to save job: %v", err)
+	}
+
+	s.log.InfoContext(ctx, "Job queued", "job_id", job.ID, "command_type", req.CommandType, "routers", job.Total)
+
+	return &job.ID, nil
+}
+
+// RunSendJob is the JobHandler of SEND_COMMAND jobs. It sends the commands
+// of the request batch by batch, to at most sendRate routers per second.
+// A job resumed by another worker sends its last batch again without
+// duplicating it.
+func (s *CommandService) RunSendJob(ctx context.Context, job *model.Job, progress func() error) error {
+	req := &pb.SendCommandRequest{}
+	if err := protojson.Unmarshal(job.Request, req); err != nil {
+		return fmt.Errorf("invalid request: %w", err)
+	}
+	if req.Payload != "" {
+		payload, err := s.openSealed(job.ID, json.RawMessage(req.Payload))
+		if err != nil {
+			return fmt.Errorf("failed to open payload: %w", err)
+		}
+		req.Payload = string(payload)
+	}
+
+	for job.Processed < job.Total {
+		started := time.Now()
+		end := min(job.Processed+s.sendBatchSize, job.Total)
+
+		// the ids are derived from the job, so a batch sent again after a
+		// crash before its progress was saved finds its commands
+		if err := s.sendBatch(ctx, req, req.Routers[job.Processed:end], job.Processed, job.ID, job.ApprovalID, &sendResult{}); err != nil {
+			return err
+		}
+		sent := end - job.Processed
+		job.Processed = end
+
+		if err := progress(); err != nil {
+			return err
+		}
+
+		if s.sendRate > 0 && job.Processed < job.Total {
+			pause := time.Duration(float64(sent)/s.sendRate*float64(time.Second)) - time.Since(started)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(pause):
+			}
+		}
+	}
+
+	return nil
+}
+
+// sendIdempotent sends the commands once per (caller, idempotency key): a
+// retry with the same request gets the original command ids, or job id, back.
+// The request holds the key for idempotencyKeyLease; a retry after it failed,
+// or ran out of its lease without completing, takes the key over and sends
+// again with the same ids, which finds the commands sent already.
+func (s *CommandService) sendIdempotent(ctx context.Context, req *pb.SendCommandRequest) (*sendResult, error) {
+	caller := callerFromContext(ctx)
+	hash, err := requestHash(req)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to hash request: %v", err)
+	}
+
+	now := time.Now()
+	key := &model.IdempotencyKey{
+		Caller:         caller,
+		Key:            req.IdempotencyKey,
+		RequestHash:    hash,
+		ID:             uuid.New(),
+		CreatedAt:      now,
+		LeaseExpiresAt: now.Add(s.idempotencyKeyLease),
+	}
+
+	existing, err := s.postgresRepo.ReserveIdempotencyKey(ctx, key, now.Add(-s.idempotencyKeyTTL))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to store idempotency key: %v", err)
+	}
+
+	if existing != nil {
+		if existing.RequestHash != hash {
+			return nil, status.Errorf(codes.AlreadyExists,
+				"idempotency_key %q was already used with a different request", req.IdempotencyKey)
+		}
+		if existing.CompletedAt == nil {
+			return nil, status.Errorf(codes.Aborted,
+				"request with idempotency_key %q is still in progress", req.IdempotencyKey)
+		}
+
+		s.log.InfoContext(ctx, "Replaying SendCommand", "idempotency_key", req.IdempotencyKey, "caller", caller)
+		return &sendResult{ids: existing.CommandIDs, jobId: existing.JobID, approvalId: existing.ApprovalID}, nil
+	}
+
+	result, err := s.dispatch(ctx, req, key.ID)
+	if err != nil {
+		if result != nil {
+			key.CommandIDs, key.JobID, key.ApprovalID = result.ids, result.jobId, result.approvalId
+		}
+		if err := s.postgresRepo.ReleaseIdempotencyKey(ctx, key); err != nil {
+			s.log.ErrorContext(ctx, "Failed to release idempotency key", "idempotency_key", req.IdempotencyKey, "error", err)
+		}
+		return nil, err
+	}
+
+	// the commands exist already, failing the call now would only make the
+	// client retry; the retry takes the key over once its lease expired
+	completedAt := time.Now()
+	key.CommandIDs, key.JobID, key.ApprovalID = result.ids, result.jobId, result.approvalId
+	key.CompletedAt = &completedAt
+	if err := s.postgresRepo.CompleteIdempotencyKey(ctx, key); err != nil {
+		s.log.ErrorContext(ctx, "Failed to store result of idempotency key", "idempotency_key", req.IdempotencyKey, "error", err)
+	}
+
+	return result, nil
+}
+
+// PurgeIdempotencyKeys deletes idempotency keys older than their TTL.
+func (s *CommandService) PurgeIdempotencyKeys(ctx context.Context) {
+	deleted, err := s.postgresRepo.DeleteExpiredIdempotencyKeys(ctx, time.Now().Add(-s.idempotencyKeyTTL))
+	if err != nil {
+		s.log.ErrorContext(ctx, "Failed to purge idempotency keys", "error", err)
+		return
+	}
+	if deleted > 0 {
+		s.log.InfoContext(ctx, "Purged expired idempotency keys", "deleted", deleted)
+	}
+}
+
+// commandStatuses are reported by the commands gauge even without commands
+var commandStatuses = []string{
+	model.StatusPending, model.StatusSent, model.StatusAcked,
+	model.StatusCancelling, model.StatusCancelled,
+	model.StatusBlocked, model.StatusFailed, model.StatusAwaitingApproval,
+}
+
+// RefreshGauges sets the command and router gauges from PostgreSQL, which
+// other instances write to as well.
+func (s *CommandService) RefreshGauges(ctx context.Context) {
+	counts, err := s.postgresRepo.CountCommandsByStatus(ctx)
+	if err != nil {
+		s.log.ErrorContext(ctx, "Failed to count commands", "error", err)
+	} else {
+		for _, status := range commandStatuses {
+			metrics.Commands.WithLabelValues(status).Set(float64(counts[status]))
+		}
+	}
+
+	online, offline, err := s.postgresRepo.CountRouters(ctx, time.Now().Add(-s.routerOnlineWindow))
+	if err != nil {
+		s.log.ErrorContext(ctx, "Failed to count routers", "error", err)
+		return
+	}
+	metrics.Routers.WithLabelValues(metrics.RoutersOnline).Set(float64(online))
+	metrics.Routers.WithLabelValues(metrics.RoutersOffline).Set(float64(offline))
+}
+
+func (s *CommandService) PollCommands(ctx context.Context, req *pb.PollRequest) (_ *pb.PollResponse, err error) {
+	// metrics initialization
+	defer func() {
+		metrics.CommadsPollCalls.WithLabelValues(metrics.Code(err)).Inc()
+	}()
+
+	timer := prometheus.NewTimer(metrics.CommandsPollHistogramm)
+	defer timer.ObserveDuration()
+
+	if req.SerialNumber == "" {
+		return nil, fmt.Errorf("router serial_number is required")
+	}
+
+	router := s.findRouter(ctx, req.RouterId)
+	if router == nil {
+		return nil, fmt.Errorf("there is no router with such serial_number: %s", req.SerialNumber)
+	}
+
+	s.log.DebugContext(ctx, "Poll commands", "serial_number", req.SerialNumber)
+
+	now := time.Now()
+	router.LastSeenAt = &now
+
+	s.SaveRouter(ctx, router)
+
+	pending, cancelling, err := s.findDeliverable(ctx, router.ID, int(req.MaxCommands))
+	if err != nil {
+		return nil, err
+	}
+
+	// a command whose payload can't be opened stays PENDING
+	for i := range pending {
+		if err := s.openPayload(&pending[i]); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to open payload: %v", err)
+		}
+	}
+	pending, err = s.signForDelivery(ctx, pending, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// only the commands this poll changed are delivered: a concurrent poll,
+	// cancel or ack may have changed the others since they were read;
+	// commands left out by max_commands stay PENDING
+	sent, err := s.markSent(ctx, router.ID, pending)
+	if err != nil {
+		return nil, err
+	}
+	cancelled, err := s.changeStatusByIds(ctx, router.ID, commandIds(cancelling), model.StatusCancelled)
+	if err != nil {
+		return nil, err
+	}
+
+	// only PENDING commands are delivered, cancelled SENT ones come as notices
+	var pbCommandsResponse []*pb.Command
+	for _, command := range changedOnly(pending, sent) {
+		pbCommandsResponse = append(pbCommandsResponse, &pb.Command{
+			Id:          command.ID.String(),
+			CommandType: command.CommandType,
+			Payload:     string(command.Payload),
+			CreatedAt:   timestamppb.New(command.CreatedAt),
+			Priority:    int32(command.Priority),
+			Signature:   toCommandSignature(&command),
+		})
+	}
+
+	var pbCancellations []*pb.CancellationNotice
+	for _, command := range changedOnly(cancelling, cancelled) {
+		notice := &pb.CancellationNotice{
+			CommandId: command.ID.String(),
+			Reason:    command.CancelReason,
+		}
+		if command.CancelledAt != nil {
+			notice.CancelledAt = timestamppb.New(*command.CancelledAt)
+		}
+		pbCancellations = append(pbCancellations, notice)
+	}
+
+	s.log.DebugContext(ctx, "Commands sent to router", "commands", len(pbCommandsResponse), "cancellations", len(pbCancellations))
+
+	return &pb.PollResponse{
+		Commands:      pbCommandsResponse,
+		Cancellations: pbCancellations,
+	}, nil
+}
+
+func (s *CommandService) AckCommand(ctx context.Context, req *pb.AckRequest) (_ *pb.AckResponse, err error) {
+	// metrics initialization
+	defer func() {
+		metrics.CommandsAckCalls.WithLabelValues(metrics.Code(err)).Inc()
+	}()
+
+	timer := prometheus.NewTimer(metrics.CommandsAckHistogramm)
+	defer timer.ObserveDuration()
+
+	if req.SerialNumber == "" {
+		return nil, fmt.Errorf("serial_number is required")
+	}
+	if req.CommandType == "" {
+		return nil, fmt.Errorf("command_type is required")
+	}
+
+	router := s.findRouter(ctx, req.RouterId)
+	if router == nil {
+		return nil, fmt.Errorf("there is no router with such serial_number: %s", req.SerialNumber)
+	}
+
+	s.log.DebugContext(ctx, "Ack commands", "serial_number", req.SerialNumber)
+
+	now := time.Now()
+	router.LastSeenAt = &now
+
+	s.SaveRouter(ctx, router)
+
+	response := &pb.AckResponse{Status: model.StatusAcked}
+
+	switch {
+	case req.Error != "":
+		commandId, err := uuid.Parse(req.CommandId)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "a failure needs a valid command_id: %v", err)
+		}
+		if err := s.failCommand(ctx, router.ID, commandId, req.Error); err != nil {
+			return nil, err
+		}
+		response.Status = model.StatusFailed
+	case req.CommandId != "":
+		commandId, err := uuid.Parse(req.CommandId)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid command_id: %v", err)
+		}
+		if _, err := s.changeStatusByIds(ctx, router.ID, []uuid.UUID{commandId}, model.StatusAcked); err != nil {
+			return nil, err
+		}
+	default:
+		if err := s.ChangeStatus(ctx, router.ID, model.StatusAcked); err != nil {
+			return nil, err
+		}
+	}
+
+	// the ack may have unblocked or failed workflow steps
+	if _, err := s.resolveWorkflows(ctx, router.ID); err != nil {
+		return nil, err
+	}
+
+	s.log.DebugContext(ctx, "Commands acked")
+
+	return response, nil
+}
+
+func (s *CommandService) failCommand(ctx context.Context, routerId, commandId uuid.UUID, reason string) error {
+	failed, err := s.postgresRepo.FailCommand(ctx, routerId, commandId, reason)
+	if err != nil {
+		return fmt.Errorf("failed to change command status in DB: %w", err)
+	}
+	if !failed {
+		return status.Errorf(codes.FailedPrecondition, "command %s of the router isn't SENT", commandId)
+	}
+
+	err = s.redisRepo.FailCommand(ctx, routerId, commandId, reason)
+	if err != nil && !redis.IsUnavailable(err) {
+		s.log.WarnContext(ctx, "Failed to change command status in Redis", "error", err)
+	}
+
+	s.log.InfoContext(ctx, "Command failed", logging.CommandIDKey, commandId, "reason", reason)
+
+	return nil
+}
+
+func (s *CommandService) GetCommand(ctx context.Context, req *pb.GetCommandRequest) (*pb.CommandInfo, error) {
+	commandId, err := uuid.Parse(req.CommandId)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid command_id: %v", err)
+	}
+
+	command, err := s.postgresRepo.GetCommandById(ctx, commandId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load command from DB: %v", err)
+	}
+	if command == nil {
+		return nil, status.Errorf(codes.NotFound, "command %s not found", req.CommandId)
+	}
+
+	redacted := s.revealPayloads(ctx, command)
+	return toCommandInfo(command, redacted[command.ID]), nil
+}
+
+func (s *CommandService) ListCommands(ctx context.Context, req *pb.ListCommandsRequest) (*pb.ListCommandsResponse, error) {
+	filter := model.CommandFilter{
+		SerialNumber: req.SerialNumber,
+		CommandType:  req.CommandType,
+		Statuses:     req.Status,
+	}
+
+	if req.RouterId != "" {
+		routerId, err := uuid.Parse(req.RouterId)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid router_id: %v", err)
+		}
+		filter.RouterID = &routerId
+	}
+	filter.CreatedFrom, filter.CreatedTo = timeRange(req.Created)
+	filter.SentFrom, filter.SentTo = timeRange(req.Sent)
+	filter.AckedFrom, filter.AckedTo = timeRange(req.Acked)
+
+	pageSize := int(req.PageSize)
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	var after *model.Cursor
+	if req.PageToken != "" {
+		cursor, err := decodePageToken(req.PageToken)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid page_token: %v", err)
+		}
+		after = cursor
+	}
+
+	// one extra row tells whether there is a next page
+	commands, err := s.postgresRepo.ListCommands(ctx, filter, after, pageSize+1)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list commands: %v", err)
+	}
+
+	response := &pb.ListCommandsResponse{}
+	if len(commands) > pageSize {
+		commands = commands[:pageSize]
+		last := commands[pageSize-1]
+		response.NextPageToken = encodePageToken(model.Cursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+
+	shown := make([]*model.Command, len(commands))
+	for i := range commands {
+		shown[i] = &commands[i]
+	}
+	redacted := s.revealPayloads(ctx, shown...)
+
+	for i := range commands {
+		response.Commands = append(response.Commands, toCommandInfo(&commands[i], redacted[commands[i].ID]))
+	}
+
+	return response, nil
+}
+
+func (s *CommandService) CancelCommand(ctx context.Context, req *pb.CancelCommandRequest) (*pb.CancelCommandsResponse, error) {
+	commandId, err := uuid.Parse(req.CommandId)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid command_id: %v", err)
+	}
+
+	response, err := s.cancel(ctx, model.CancelFilter{CommandID: &commandId}, actorFromContext(ctx, req.CancelledBy), req.Reason)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response.Cancelled) == 0 && len(response.Cancelling) == 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "command %s doesn't exist or can't be cancelled anymore", req.CommandId)
+	}
+
+	return response, nil
+}
+
+func (s *CommandService) CancelCommands(ctx context.Context, req *pb.CancelCommandsRequest) (*pb.CancelCommandsResponse, error) {
+	filter := model.CancelFilter{CommandType: req.CommandType}
+	if req.RouterId != "" {
+		routerId, err := uuid.Parse(req.RouterId)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid router_id: %v", err)
+		}
+		filter.RouterID = &routerId
+	}
+	if req.CampaignId != "" {
+		campaignId, err := uuid.Parse(req.CampaignId)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid campaign_id: %v", err)
+		}
+		filter.CampaignID = &campaignId
+	}
+
+	if filter.Empty() {
+		return nil, status.Error(codes.InvalidArgument, "router_id, command_type or campaign_id is required")
+	}
+
+	return s.cancel(ctx, filter, actorFromContext(ctx, req.CancelledBy), req.Reason)
+}
+
+// cancel cancels the matching commands in PostgreSQL and mirrors the result to Redis.
+func (s *CommandService) cancel(ctx context.Context, filter model.CancelFilter, cancelledBy, reason string) (*pb.CancelCommandsResponse, error) {
+	cancellation := model.Cancellation{
+		By:     cancelledBy,
+		Reason: reason,
+		At:     time.Now(),
+	}
+
+	commands, err := s.postgresRepo.CancelCommands(ctx, filter, cancellation)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to cancel commands in DB: %v", err)
+	}
+
+	if len(commands) > 0 {
+		if err := s.redisRepo.UpdateCommands(ctx, commands); err != nil && !redis.IsUnavailable(err) {
+			s.log.WarnContext(ctx, "Failed to cancel commands in Redis", "error", err)
+		}
+	}
+
+	// workflow steps depending on a cancelled command can't run any more
+	routers := make(map[uuid.UUID]bool)
+	for _, command := range commands {
+		if command.WorkflowID != nil && !routers[command.RouterID] {
+			routers[command.RouterID] = true
+			dependents, err := s.resolveWorkflows(ctx, command.RouterID)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "%v", err)
+			}
+			commands = append(commands, dependents...)
+		}
+	}
+
+	response := &pb.CancelCommandsResponse{}
+	for _, command := range commands {
+		if command.Status == model.StatusCancelled {
+			response.Cancelled = append(response.Cancelled, command.ID.String())
+		} else {
+			response.Cancelling = append(response.Cancelling, command.ID.String())
+		}
+	}
+
+	s.log.InfoContext(ctx, "Commands cancelled", "cancelled_by", cancelledBy, "commands", len(commands), "reason", reason)
+
+	return response, nil
+}
+
+/* --- workflows --- */
+
+func (s *CommandService) SubmitWorkflow(ctx context.Context, req *pb.SubmitWorkflowRequest) (*pb.WorkflowInfo, error) {
+	if req.SerialNumber == "" {
+		return nil, status.Error(codes.InvalidArgument, "serial_number is required")
+	}
+
+	now := time.Now()
+	router := &model.Router{
+		ID:           uuid.New(),
+		SerialNumber: req.SerialNumber,
+		LastSeenAt:   &now,
+		CreatedAt:    now,
+	}
+
+	workflow := &model.Workflow{
+		ID:        uuid.New(),
+		Name:      req.Name,
+		CreatedAt: now,
+	}
+
+	for i, step := range req.Steps {
+		if step.CommandType == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "step %q has no command_type", step.Key)
+		}
+		if s.requiresApproval(step.CommandType) {
+			return nil, status.Errorf(codes.FailedPrecondition, "%s commands need approval and can only be sent with SendCommand", step.CommandType)
+		}
+
+		dependsOn := step.DependsOn
+		if req.Sequential {
+			if len(dependsOn) > 0 {
+				return nil, status.Error(codes.InvalidArgument, "depends_on can't be set on steps of a sequential workflow")
+			}
+			if i > 0 {
+				dependsOn = []string{req.Steps[i-1].Key}
+			}
+		}
+
+		priority := model.LookupCommandType(step.CommandType).Priority
+		if step.Priority != nil {
+			priority = int(*step.Priority)
+			if !model.ValidPriority(priority) {
+				return nil, status.Errorf(codes.InvalidArgument, "priority must be between %d and %d", model.MinPriority, model.MaxPriority)
+			}
+		}
+		if err := validatePayload(step.Payload); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid payload of step %q: %v", step.Key, err)
+		}
+
+		commandStatus := model.StatusPending
+		if len(dependsOn) > 0 {
+			commandStatus = model.StatusBlocked
+		}
+
+		workflow.Steps = append(workflow.Steps, model.WorkflowStep{
+			Command: model.Command{
+				ID:           uuid.New(),
+				CommandType:  step.CommandType,
+				Payload:      commandPayload(step.CommandType, step.Payload),
+				Status:       commandStatus,
+				Priority:     priority,
+				CreatedAt:    now,
+				WorkflowID:   &workflow.ID,
+				WorkflowStep: step.Key,
+			},
+			DependsOn: dependsOn,
+		})
+	}
+
+	if err := model.ValidateSteps(workflow.Steps); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid workflow: %v", err)
+	}
+
+	s.SaveRouter(ctx, router)
+	workflow.RouterID = router.ID
+	for i := range workflow.Steps {
+		workflow.Steps[i].Command.RouterID = router.ID
+		if err := s.sealCommand(&workflow.Steps[i].Command); err != nil {
+			return nil, status.Errorf(codes.Internal, "%v", err)
+		}
+	}
+
+	if err := s.postgresRepo.SaveWorkflow(ctx, workflow); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to save workflow: %v", err)
+	}
+
+	for _, step := range workflow.Steps {
+		metrics.CommandsCreated.WithLabelValues(step.Command.CommandType).Inc()
+		if err := s.redisRepo.SaveCommand(ctx, &step.Command); err != nil && !redis.IsUnavailable(err) {
+			s.log.WarnContext(ctx, "Failed to save command in Redis", "error", err)
+		}
+	}
+
+	s.log.InfoContext(ctx, "Workflow submitted", "workflow_id", workflow.ID, "steps", len(workflow.Steps), "serial_number", req.SerialNumber)
+
+	return s.workflowInfo(ctx, workflow), nil
+}
+
+func (s *CommandService) GetWorkflow(ctx context.Context, req *pb.GetWorkflowRequest) (*pb.WorkflowInfo, error) {
+	workflowId, err := uuid.Parse(req.WorkflowId)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid workflow_id: %v", err)
+	}
+
+	workflow, err := s.postgresRepo.GetWorkflow(ctx, workflowId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load workflow: %v", err)
+	}
+	if workflow == nil {
+		return nil, status.Errorf(codes.NotFound, "workflow %s not found", workflowId)
+	}
+
+	return s.workflowInfo(ctx, workflow), nil
+}
+
+// resolveWorkflows releases the router's workflow steps whose prerequisites
+// are all ACKED and cancels those that can't run any more. It returns the
+// cancelled steps.
+func (s *CommandService) resolveWorkflows(ctx context.Context, routerId uuid.UUID) ([]model.Command, error) {
+	cancellation := model.Cancellation{
+		By:     model.SystemActor,
+		Reason: "a prerequisite step failed or was cancelled",
+		At:     time.Now(),
+	}
+
+	released, cancelled, err := s.postgresRepo.ResolveWorkflows(ctx, routerId, cancellation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve workflows: %w", err)
+	}
+
+	// SaveCommand overwrites the cached BLOCKED version, and caches the
+	// command if it wasn't
+	for _, command := range append(released, cancelled...) {
+		if err := s.redisRepo.SaveCommand(ctx, &command); err != nil && !redis.IsUnavailable(err) {
+			s.log.WarnContext(ctx, "Failed to update workflow step in Redis", "error", err)
+		}
+	}
+
+	if len(released)+len(cancelled) > 0 {
+		s.log.InfoContext(ctx, "Workflows resolved", logging.RouterIDKey, routerId, "released", len(released), "cancelled", len(cancelled))
+	}
+
+	return cancelled, nil
+}
+
+func (s *CommandService) findRouter(ctx context.Context, id string) *model.Router {
+	// check if we've already had this router
+	router, err := s.redisRepo.FindRouterByRouterId(ctx, id)
+	if err != nil && !redis.IsUnavailable(err) {
+		s.log.WarnContext(ctx, "Router lookup in Redis failed", logging.RouterIDKey, id, "error", err)
+	}
+	cacheLookup("router", router != nil, err)
+
+	// check in Postgres
+	if router == nil {
+		router, err = s.postgresRepo.FindRouterByRouterId(ctx, id)
+		if err != nil {
+			s.log.WarnContext(ctx, "Router lookup in PostgreSQL failed", logging.RouterIDKey, id, "error", err)
+		}
+	}
+
+	return router
+}
+
+// GetSigningKeys returns the public keys routers verify command signatures
+// with; none if commands are unsigned.
+func (s *CommandService) GetSigningKeys(ctx context.Context, req *pb.GetSigningKeysRequest) (*pb.GetSigningKeysResponse, error) {
+	resp := &pb.GetSigningKeysResponse{}
+	if s.signer == nil {
+		return resp, nil
+	}
+
+	for _, key := range s.signer.PublicKeys() {
+		resp.Keys = append(resp.Keys, &pb.SigningKey{
+			KeyId:     key.ID,
+			Algorithm: signing.Algorithm,
+			PublicKey: key.Key,
+			Current:   key.Current,
+		})
+	}
+	return resp, nil
+}
+
+func (s *CommandService) SaveRouter(ctx context.Context, router *model.Router) {
+	if err := s.postgresRepo.SaveRouter(ctx, router); err != nil {
+		s.log.ErrorContext(ctx, "Failed to save router in PostgreSQL", "error", err)
+		return
+	}
+
+	// Redis — опционально
+	if err := s.redisRepo.SaveRouter(ctx, router); err != nil && !redis.IsUnavailable(err) {
+		s.log.WarnContext(ctx, "Failed to save router in Redis", "error", err)
+	}
+}
+
+func (s *CommandService) ChangeStatus(ctx context.Context, routerId uuid.UUID, status string) error {
+	// while the cache is down PostgreSQL is the only store to update
+	err := s.redisRepo.ChangeStatusByRouterId(ctx, routerId, status)
+	if err != nil && !redis.IsUnavailable(err) {
+		return fmt.Errorf("failed to change command status in Redis: %w", err)
+	}
+
+	changed, err := s.postgresRepo.ChangeStatusByRouterId(ctx, routerId, status)
+	if err != nil {
+		return fmt.Errorf("failed to change command status in DB: %w", err)
+	}
+	observeDelivery(changed)
+
+	return nil
+}
+
+// changeStatusByIds is ChangeStatus limited to the given commands. It
+// returns the commands PostgreSQL changed, which may be fewer than asked
+// for; the cache follows its decision.
+func (s *CommandService) changeStatusByIds(ctx context.Context, routerId uuid.UUID, ids []uuid.UUID, status string) ([]model.Command, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	changed, err := s.postgresRepo.ChangeStatusByIds(ctx, routerId, ids, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to change command status in DB: %w", err)
+	}
+	observeDelivery(changed)
+
+	if err := s.redisRepo.UpdateCommands(ctx, changed); err != nil && !redis.IsUnavailable(err) {
+		s.log.WarnContext(ctx, "Failed to change command status in Redis", "error", err)
+	}
+
+	return changed, nil
+}
+
+// markSent moves the PENDING ones among the commands to SENT with the
+// signatures they are delivered with and returns those PostgreSQL changed.
+func (s *CommandService) markSent(ctx context.Context, routerId uuid.UUID, commands []model.Command) ([]model.Command, error) {
+	if len(commands) == 0 {
+		return nil, nil
+	}
+
+	sent, err := s.postgresRepo.MarkCommandsSent(ctx, routerId, commands)
+	if err != nil {
+		return nil, fmt.Errorf("failed to change command status in DB: %w", err)
+	}
+	observeDelivery(sent)
+
+	if err := s.redisRepo.UpdateCommands(ctx, sent); err != nil && !redis.IsUnavailable(err) {
+		s.log.WarnContext(ctx, "Failed to change command status in Redis", "error", err)
+	}
+
+	return sent, nil
+}
+
+// signForDelivery signs the commands again, so that their signatures hold
+// from delivery on however long they waited: BLOCKED behind a workflow
+// step, for approval or for an offline router. The signature they were
+// stored with is checked first, expired or not: a command changed, or
+// made, outside this service, or from before signing was set up, is
+// cancelled instead. Without a signer, commands whose signature expired
+// can't be delivered any more; they are cancelled too. Cancelled commands
+// are left out.
+func (s *CommandService) signForDelivery(ctx context.Context, commands []model.Command, now time.Time) ([]model.Command, error) {
+	deliverable := make([]model.Command, 0, len(commands))
+	for _, command := range commands {
+		var reason string
+		switch {
+		case s.signer != nil:
+			if err := s.signer.Check(&command); err != nil {
+				s.log.ErrorContext(ctx, "Stored signature of command is invalid", logging.CommandIDKey, command.ID, "error", err)
+				reason = model.SignatureInvalidReason
+				break
+			}
+			if err := s.signer.Sign(&command, now); err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to sign command %s: %v", command.ID, err)
+			}
+		case command.ExpiresAt != nil && !now.Before(*command.ExpiresAt):
+			s.log.WarnContext(ctx, "Signature expired before delivery", logging.CommandIDKey, command.ID, "expires_at", *command.ExpiresAt)
+			reason = model.SignatureExpiredReason
+		}
+
+		if reason != "" {
+			if _, err := s.cancel(ctx, model.CancelFilter{CommandID: &command.ID}, model.SystemActor, reason); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		deliverable = append(deliverable, command)
+	}
+	return deliverable, nil
+}
+
+// commandIds returns the ids of the commands.
+func commandIds(commands []model.Command) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(commands))
+	for _, command := range commands {
+		ids = append(ids, command.ID)
+	}
+	return ids
+}
+
+// changedOnly returns the commands that are among the changed ones, in
+// their order. Status changes leave what is delivered as it was, so the
+// commands are returned as they were read, with their payloads opened.
+func changedOnly(commands, changed []model.Command) []model.Command {
+	ids := make(map[uuid.UUID]bool, len(changed))
+	for _, command := range changed {
+		ids[command.ID] = true
+	}
+
+	var kept []model.Command
+	for _, command := range commands {
+		if ids[command.ID] {
+			kept = append(kept, command)
+		}
+	}
+	return kept
+}
+
+// observeDelivery records how long the commands took to be sent or acked
+// since they were created.
+func observeDelivery(commands []model.Command) {
+	for _, command := range commands {
+		switch {
+		case command.Status == model.StatusSent && command.SentAt != nil:
+			metrics.CommandTimeToSent.WithLabelValues(command.CommandType).Observe(command.SentAt.Sub(command.CreatedAt).Seconds())
+		case command.Status == model.StatusAcked && command.AckedAt != nil:
+			metrics.CommandTimeToAck.WithLabelValues(command.CommandType).Observe(command.AckedAt.Sub(command.CreatedAt).Seconds())
+		}
+	}
+}
+
+// findDeliverable returns up to limit PENDING commands of the router in
+// delivery order and all its CANCELLING ones. They are read from Redis while
+// it is healthy, which it only is once resynced from PostgreSQL, and from
+// PostgreSQL otherwise. A stale cache delays commands at most: PostgreSQL
+// decides which of them are delivered, see markSent.
+func (s *CommandService) findDeliverable(ctx context.Context, routerId uuid.UUID, limit int) ([]model.Command, []model.Command, error) {
+	pending, cancelling, err := s.findCachedDeliverable(ctx, routerId, limit)
+	if err != nil && !redis.IsUnavailable(err) {
+		s.log.WarnContext(ctx, "Command lookup in Redis failed", logging.RouterIDKey, routerId, "error", err)
+	}
+	cacheLookup("commands", err == nil, err)
+	if err == nil {
+		return pending, cancelling, nil
+	}
+
+	pending, err = s.postgresRepo.GetCommandsByRouterIdAndStatus(ctx, routerId, model.StatusPending, limit)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load commands from DB: %w", err)
+	}
+
+	cancelling, err = s.postgresRepo.GetCommandsByRouterIdAndStatus(ctx, routerId, model.StatusCancelling, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load commands from DB: %w", err)
+	}
+
+	return pending, cancelling, nil
+}
+
+func (s *CommandService) findCachedDeliverable(ctx context.Context, routerId uuid.UUID, limit int) ([]model.Command, []model.Command, error) {
+	pending, err := s.redisRepo.FindCommandsByStatus(ctx, routerId, model.StatusPending, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cancelling, err := s.redisRepo.FindCommandsByStatus(ctx, routerId, model.StatusCancelling, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pending, cancelling, nil
+}
+
+// cacheLookup counts a Redis lookup: a hit, a miss, or a failure served by
+// PostgreSQL.
+func cacheLookup(lookup string, hit bool, err error) {
+	switch {
+	case err != nil:
+		metrics.CacheFallbacks.WithLabelValues(lookup).Inc()
+	case hit:
+		metrics.CacheLookups.WithLabelValues(lookup, metrics.CacheHit).Inc()
+	default:
+		metrics.CacheLookups.WithLabelValues(lookup, metrics.CacheMiss).Inc()
+	}
+}
+
+// ResyncCache rebuilds the Redis command lists from PostgreSQL. It is called
+// when Redis comes back, since writes were skipped while it was down.
+func (s *CommandService) ResyncCache(ctx context.Context) error {
+	// commands created from now on are written to the cache as well
+	since := time.Now()
+	commands, err := s.postgresRepo.GetCommandsByStatus(ctx, []string{model.StatusPending, model.StatusSent, model.StatusCancelling})
+	if err != nil {
+		return fmt.Errorf("failed to load commands for cache resync: %w", err)
+	}
+
+	if err := s.redisRepo.ResyncCommands(ctx, commands, since); err != nil {
+		return fmt.Errorf("failed to resync commands in Redis: %w", err)
+	}
+
+	s.log.InfoContext(ctx, "Redis cache resynced", "commands", len(commands))
+	return nil
+}
+
+func toCommandInfo(command *model.Command, redacted bool) *pb.CommandInfo {
+	info := &pb.CommandInfo{
+		Id:              command.ID.String(),
+		RouterId:        command.RouterID.String(),
+		CommandType:     command.CommandType,
+		Payload:         string(command.Payload),
+		Status:          command.Status,
+		Priority:        int32(command.Priority),
+		Error:           command.Error,
+		WorkflowStep:    command.WorkflowStep,
+		CreatedAt:       timestamppb.New(command.CreatedAt),
+		CancelledBy:     command.CancelledBy,
+		CancelReason:    command.CancelReason,
+		PayloadRedacted: redacted,
+	}
+
+	if command.WorkflowID != nil {
+		info.WorkflowId = command.WorkflowID.String()
+	}
+	if command.CampaignID != nil {
+		info.CampaignId = command.CampaignID.String()
+	}
+	if command.ApprovalID != nil {
+		info.ApprovalId = command.ApprovalID.String()
+	}
+	if command.SentAt != nil {
+		info.SentAt = timestamppb.New(*command.SentAt)
+	}
+	if command.AckedAt != nil {
+		info.AckedAt = timestamppb.New(*command.AckedAt)
+	}
+	if command.CancelledAt != nil {
+		info.CancelledAt = timestamppb.New(*command.CancelledAt)
+	}
+
+	return info
+}
+
+// toCommandSignature returns the signature delivered with the command, nil if
+// it is unsigned.
+func toCommandSignature(command *model.Command) *pb.CommandSignature {
+	if command.SignatureKeyID == "" {
+		return nil
+	}
+
+	signature := &pb.CommandSignature{
+		KeyId:     command.SignatureKeyID,
+		Signature: command.Signature,
+	}
+	if command.NotBefore != nil {
+		signature.NotBefore = timestamppb.New(*command.NotBefore)
+	}
+	if command.ExpiresAt != nil {
+		signature.ExpiresAt = timestamppb.New(*command.ExpiresAt)
+	}
+	return signature
+}
+
+// workflowInfo returns the workflow with its step payloads revealed to the
+// caller, see revealPayloads.
+func (s *CommandService) workflowInfo(ctx context.Context, workflow *model.Workflow) *pb.WorkflowInfo {
+	steps := make([]*model.Command, len(workflow.Steps))
+	for i := range workflow.Steps {
+		steps[i] = &workflow.Steps[i].Command
+	}
+	redacted := s.revealPayloads(ctx, steps...)
+
+	info := &pb.WorkflowInfo{
+		Id:        workflow.ID.String(),
+		RouterId:  workflow.RouterID.String(),
+		Name:      workflow.Name,
+		Status:    workflow.Status(),
+		CreatedAt: timestamppb.New(workflow.CreatedAt),
+	}
+
+	for i := range workflow.Steps {
+		step := &workflow.Steps[i]
+		info.Steps = append(info.Steps, &pb.WorkflowStepInfo{
+			Key:       step.Command.WorkflowStep,
+			DependsOn: step.DependsOn,
+			Command:   toCommandInfo(&step.Command, redacted[step.Command.ID]),
+		})
+	}
+
+	return info
+}
+
+func timeRange(r *pb.TimeRange) (from, to *time.Time) {
+	if r == nil {
+		return nil, nil
+	}
+	if r.From != nil {
+		t := r.From.AsTime()
+		from = &t
+	}
+	if r.To != nil {
+		t := r.To.AsTime()
+		to = &t
+	}
+	return from, to
+}
+
+// page tokens are opaque to clients: base64 of "<created_at unix nanos>|<id>"
+func encodePageToken(cursor model.Cursor) string {
+	raw := fmt.Sprintf("%d|%s", cursor.CreatedAt.UnixNano(), cursor.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodePageToken(token string) (*model.Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+
+	nanos, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	commandId, err := uuid.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.Cursor{CreatedAt: time.Unix(0, n).UTC(), ID: commandId}, nil
+}
+
+// anonymousCaller is the caller of unauthenticated requests without x-caller-id.
+const anonymousCaller = "anonymous"
+
+// callerFromContext identifies the client that owns idempotency keys, jobs
+// and approval requests: the authenticated principal or, with authentication
+// disabled, the x-caller-id metadata (Grpc-Metadata-X-Caller-Id header over
+// REST).
+func callerFromContext(ctx context.Context) string {
+	if principal, ok := auth.FromContext(ctx); ok {
+		return principal.Subject
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("x-caller-id"); len(values) > 0 && values[0] != "" {
+			return values[0]
+		}
+	}
+	return anonymousCaller
+}
+
+// actorFromContext is who a change is recorded under: the authenticated
+// principal, whatever the request claims. With authentication disabled
+// there is nobody to check the claimed name against, so it is taken as is,
+// or callerFromContext if the request doesn't claim any.
+func actorFromContext(ctx context.Context, claimed string) string {
+	if principal, ok := auth.FromContext(ctx); ok {
+		return principal.Subject
+	}
+	if claimed != "" {
+		return claimed
+	}
+	return callerFromContext(ctx)
+}
+
+// requestHash fingerprints everything in the request except its idempotency key.
+func requestHash(req *pb.SendCommandRequest) (string, error) {
+	body := proto.Clone(req).(*pb.SendCommandRequest)
+	body.IdempotencyKey = ""
+
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
 }
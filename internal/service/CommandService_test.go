@@ -2,17 +2,34 @@ package service
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"fmt"
+	"router-manager/internal/auth"
+	"router-manager/internal/encryption"
+	"router-manager/internal/metrics"
 	"router-manager/internal/model"
 	"router-manager/internal/pb"
 	mockspg "router-manager/internal/repository/postgres/mocks"
+	"router-manager/internal/repository/redis"
 	mocksred "router-manager/internal/repository/redis/mocks"
+	"router-manager/internal/signing"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func setup(t *testing.T) (*CommandService, *mockspg.MockPostgresRepo, *mocksred.MockRedisRepo, context.Context) {
@@ -32,6 +49,15 @@ func setup(t *testing.T) (*CommandService, *mockspg.MockPostgresRepo, *mocksred.
 
 /* --- test SendCommand method --- */
 
+// savedAsSent makes SaveCommandsCoalesced keep every command.
+func savedAsSent(_ context.Context, cmds []model.Command, _ string) ([]*model.CoalesceResult, error) {
+	results := make([]*model.CoalesceResult, len(cmds))
+	for i := range results {
+		results[i] = &model.CoalesceResult{}
+	}
+	return results, nil
+}
+
 func TestSendCommand_ToManyRouters(t *testing.T) {
 	s, mockPostgres, mockRedis, ctx := setup(t)
 
@@ -46,24 +72,20 @@ func TestSendCommand_ToManyRouters(t *testing.T) {
 	}
 
 	mockPostgres.EXPECT().
-		SaveRouter(gomock.Any(), gomock.AssignableToTypeOf(&model.Router{})).
-		Return(nil).
-		Times(2)
+		SaveRouters(gomock.Any(), gomock.Len(2)).
+		Return(nil)
 
 	mockRedis.EXPECT().
-		SaveRouter(gomock.Any(), gomock.AssignableToTypeOf(&model.Router{})).
-		Return(nil).
-		Times(2)
+		SaveRouters(gomock.Any(), gomock.Len(2)).
+		Return(nil)
 
 	mockPostgres.EXPECT().
-		SaveCommand(gomock.Any(), gomock.AssignableToTypeOf(&model.Command{})).
-		Return(nil).
-		Times(2)
+		SaveCommandsCoalesced(gomock.Any(), gomock.Len(2), model.CoalesceDropIdentical).
+		DoAndReturn(savedAsSent)
 
 	mockRedis.EXPECT().
-		SaveCommand(gomock.Any(), gomock.AssignableToTypeOf(&model.Command{})).
-		Return(nil).
-		Times(2)
+		SaveCommands(gomock.Any(), gomock.Len(2)).
+		Return(nil)
 
 	response, err := s.SendCommand(ctx, req)
 
@@ -73,6 +95,7 @@ func TestSendCommand_ToManyRouters(t *testing.T) {
 	assert.Len(t, response.Id, 2)
 	assert.NotEmpty(t, response.Id[0])
 	assert.NotEmpty(t, response.Id[1])
+	assert.Empty(t, response.JobId)
 }
 
 func TestSendCommand_toOneRouter(t *testing.T) {
@@ -87,24 +110,20 @@ func TestSendCommand_toOneRouter(t *testing.T) {
 	}
 
 	mockPostgres.EXPECT().
-		SaveRouter(gomock.Any(), gomock.AssignableToTypeOf(&model.Router{})).
-		Return(nil).
-		Times(1)
+		SaveRouters(gomock.Any(), gomock.Len(1)).
+		Return(nil)
 
 	mockRedis.EXPECT().
-		SaveRouter(gomock.Any(), gomock.AssignableToTypeOf(&model.Router{})).
-		Return(nil).
-		Times(1)
+		SaveRouters(gomock.Any(), gomock.Len(1)).
+		Return(nil)
 
 	mockPostgres.EXPECT().
-		SaveCommand(gomock.Any(), gomock.AssignableToTypeOf(&model.Command{})).
-		Return(nil).
-		Times(1)
+		SaveCommandsCoalesced(gomock.Any(), gomock.Len(1), model.CoalesceDropIdentical).
+		DoAndReturn(savedAsSent)
 
 	mockRedis.EXPECT().
-		SaveCommand(gomock.Any(), gomock.AssignableToTypeOf(&model.Command{})).
-		Return(nil).
-		Times(1)
+		SaveCommands(gomock.Any(), gomock.Len(1)).
+		Return(nil)
 
 	response, err := s.SendCommand(ctx, req)
 
@@ -115,7 +134,764 @@ func TestSendCommand_toOneRouter(t *testing.T) {
 	assert.NotEmpty(t, response.Id[0])
 }
 
+func TestSendCommand_KeepAll(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+
+	mockPostgres.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil)
+	mockRedis.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil)
+	mockPostgres.EXPECT().
+		SaveCommandsCoalesced(gomock.Any(), gomock.Any(), model.CoalesceKeepAll).
+		DoAndReturn(savedAsSent)
+	mockRedis.EXPECT().SaveCommands(gomock.Any(), gomock.Any()).Return(nil)
+
+	response, err := s.SendCommand(ctx, &pb.SendCommandRequest{
+		Routers:     []*pb.Router{{SerialNumber: "SN123"}},
+		CommandType: "RUN_DIAGNOSTICS",
+	})
+
+	require.NoError(t, err)
+	assert.Len(t, response.Id, 1)
+	assert.Empty(t, response.Coalesced)
+}
+
+func TestSendCommand_UsesRouterIdsFromPostgres(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	known := uuid.New()
+
+	mockPostgres.EXPECT().
+		SaveRouters(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, routers []model.Router) error {
+			routers[0].ID = known
+			return nil
+		})
+	mockRedis.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil)
+	mockPostgres.EXPECT().
+		SaveCommandsCoalesced(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, cmds []model.Command, policy string) ([]*model.CoalesceResult, error) {
+			assert.Equal(t, known, cmds[0].RouterID)
+			return savedAsSent(ctx, cmds, policy)
+		})
+	mockRedis.EXPECT().SaveCommands(gomock.Any(), gomock.Any()).Return(nil)
+
+	_, err := s.SendCommand(ctx, &pb.SendCommandRequest{
+		Routers:     []*pb.Router{{SerialNumber: "SN123"}},
+		CommandType: "REBOOT",
+	})
+
+	require.NoError(t, err)
+}
+
+func TestSendCommand_DropIdentical(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	existing := uuid.New()
+
+	mockPostgres.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil)
+	mockRedis.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil)
+	mockPostgres.EXPECT().
+		SaveCommandsCoalesced(gomock.Any(), gomock.Any(), model.CoalesceDropIdentical).
+		Return([]*model.CoalesceResult{{ExistingID: &existing}}, nil)
+	// nothing new is written to the cache
+	mockRedis.EXPECT().SaveCommands(gomock.Any(), gomock.Len(0)).Return(nil)
+
+	response, err := s.SendCommand(ctx, &pb.SendCommandRequest{
+		Routers:     []*pb.Router{{SerialNumber: "SN123"}},
+		CommandType: "REBOOT",
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{existing.String()}, response.Id)
+	require.Len(t, response.Coalesced, 1)
+	assert.Equal(t, "SN123", response.Coalesced[0].SerialNumber)
+	assert.Equal(t, existing.String(), response.Coalesced[0].CommandId)
+	assert.Equal(t, model.CoalesceDropIdentical, response.Coalesced[0].Policy)
+}
+
+func TestSendCommand_DropIdenticalComparesPayload(t *testing.T) {
+	router := model.Router{ID: uuid.New(), SerialNumber: "SN1"}
+	pending := model.Command{
+		ID:          uuid.New(),
+		RouterID:    router.ID,
+		CommandType: "REBOOT",
+		Payload:     []byte(`{"command": "REBOOT", "delay": 60}`),
+		Status:      model.StatusPending,
+	}
+
+	for payload, action := range map[string]string{
+		`{"delay":60,"command":"REBOOT"}`: model.PlanCoalesce,
+		`{"command":"REBOOT","delay":0}`:  model.PlanCreate,
+		"":                                model.PlanCreate,
+	} {
+		t.Run(payload, func(t *testing.T) {
+			s, mockPostgres, _, ctx := setup(t)
+
+			mockPostgres.EXPECT().FindRoutersBySelector(gomock.Any(), gomock.Any()).Return([]model.Router{router}, nil)
+			mockPostgres.EXPECT().GetPendingCommands(gomock.Any(), gomock.Len(1)).Return([]model.Command{pending}, nil)
+
+			response, err := s.SendCommand(ctx, &pb.SendCommandRequest{
+				Routers:     []*pb.Router{{SerialNumber: "SN1"}},
+				CommandType: "REBOOT",
+				Payload:     payload,
+				DryRun:      true,
+			})
+
+			require.NoError(t, err)
+			require.Len(t, response.Plan, 1)
+			assert.Equal(t, action, response.Plan[0].Action)
+		})
+	}
+}
+
+func TestSendCommand_ReplacePending(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	older := model.Command{ID: uuid.New(), Status: model.StatusCancelled}
+
+	mockPostgres.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil)
+	mockRedis.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil)
+	mockPostgres.EXPECT().
+		SaveCommandsCoalesced(gomock.Any(), gomock.Any(), model.CoalesceReplacePending).
+		Return([]*model.CoalesceResult{{Replaced: []model.Command{older}}}, nil)
+	// the cache gets the new command and the cancelled one
+	mockRedis.EXPECT().
+		SaveCommands(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, cmds []model.Command) error {
+			require.Len(t, cmds, 2)
+			assert.Equal(t, model.StatusPending, cmds[0].Status)
+			assert.Equal(t, older, cmds[1])
+			return nil
+		})
+
+	response, err := s.SendCommand(ctx, &pb.SendCommandRequest{
+		Routers:     []*pb.Router{{SerialNumber: "SN123"}},
+		CommandType: "UPDATE_FIRMWARE",
+	})
+
+	require.NoError(t, err)
+	require.Len(t, response.Id, 1)
+	require.Len(t, response.Coalesced, 1)
+	assert.Equal(t, response.Id[0], response.Coalesced[0].CommandId)
+	assert.Equal(t, []string{older.ID.String()}, response.Coalesced[0].ReplacedIds)
+}
+
+func TestSendCommand_SkipsDecommissioned(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	decommissioned := time.Now().Add(-time.Hour)
+
+	mockPostgres.EXPECT().
+		SaveRouters(gomock.Any(), gomock.Len(2)).
+		DoAndReturn(func(_ context.Context, routers []model.Router) error {
+			routers[1].DecommissionedAt = &decommissioned
+			return nil
+		})
+	mockRedis.EXPECT().SaveRouters(gomock.Any(), gomock.Len(1)).Return(nil)
+	mockPostgres.EXPECT().SaveCommandsCoalesced(gomock.Any(), gomock.Len(1), gomock.Any()).DoAndReturn(savedAsSent)
+	mockRedis.EXPECT().SaveCommands(gomock.Any(), gomock.Len(1)).Return(nil)
+
+	response, err := s.SendCommand(ctx, &pb.SendCommandRequest{
+		Routers:     []*pb.Router{{SerialNumber: "SN1"}, {SerialNumber: "SN2"}},
+		CommandType: "REBOOT",
+	})
+
+	require.NoError(t, err)
+	assert.Len(t, response.Id, 1)
+	require.Len(t, response.Skipped, 1)
+	assert.Equal(t, "SN2", response.Skipped[0].SerialNumber)
+	assert.Equal(t, model.PlanSkip, response.Skipped[0].Action)
+	assert.Equal(t, model.SkipDecommissioned, response.Skipped[0].Reason)
+}
+
+func TestSendCommand_SkipsUnknownRouters(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	WithRouterRegistration(false)(s)
+	known := model.Router{ID: uuid.New(), SerialNumber: "SN1"}
+
+	// routers are looked up, not registered
+	mockPostgres.EXPECT().
+		FindRoutersBySelector(gomock.Any(), model.RouterSelector{SerialNumbers: []string{"SN1", "SN2"}}).
+		Return([]model.Router{known}, nil)
+	mockRedis.EXPECT().SaveRouters(gomock.Any(), []model.Router{known}).Return(nil)
+	mockPostgres.EXPECT().
+		SaveCommandsCoalesced(gomock.Any(), gomock.Len(1), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, cmds []model.Command, policy string) ([]*model.CoalesceResult, error) {
+			assert.Equal(t, known.ID, cmds[0].RouterID)
+			return savedAsSent(ctx, cmds, policy)
+		})
+	mockRedis.EXPECT().SaveCommands(gomock.Any(), gomock.Len(1)).Return(nil)
+
+	response, err := s.SendCommand(ctx, &pb.SendCommandRequest{
+		Routers:     []*pb.Router{{SerialNumber: "SN1"}, {SerialNumber: "SN2"}},
+		CommandType: "REBOOT",
+	})
+
+	require.NoError(t, err)
+	assert.Len(t, response.Id, 1)
+	require.Len(t, response.Skipped, 1)
+	assert.Equal(t, "SN2", response.Skipped[0].SerialNumber)
+	assert.Equal(t, model.SkipUnknown, response.Skipped[0].Reason)
+}
+
+/* --- test SendCommand dry runs --- */
+
+func TestSendCommand_DryRun(t *testing.T) {
+	s, mockPostgres, _, ctx := setup(t)
+	decommissioned := time.Now().Add(-time.Hour)
+	sn1 := model.Router{ID: uuid.New(), SerialNumber: "SN1"}
+	sn2 := model.Router{ID: uuid.New(), SerialNumber: "SN2", DecommissionedAt: &decommissioned}
+	pending := model.Command{
+		ID:          uuid.New(),
+		RouterID:    sn1.ID,
+		CommandType: "REBOOT",
+		Payload:     []byte(`{"command": "REBOOT"}`),
+		Status:      model.StatusPending,
+	}
+
+	// nothing but reads: any write fails the test
+	mockPostgres.EXPECT().
+		FindRoutersBySelector(gomock.Any(), model.RouterSelector{SerialNumbers: []string{"SN1", "SN2", "SN3", "SN3"}}).
+		Return([]model.Router{sn1, sn2}, nil)
+	mockPostgres.EXPECT().
+		GetPendingCommands(gomock.Any(), gomock.Len(3)).
+		Return([]model.Command{pending}, nil)
+
+	response, err := s.SendCommand(ctx, &pb.SendCommandRequest{
+		Routers: []*pb.Router{
+			{SerialNumber: "SN1"},
+			{SerialNumber: "SN2"},
+			{SerialNumber: "SN3"},
+			{SerialNumber: ""},
+			{SerialNumber: "SN3"},
+		},
+		CommandType: "REBOOT",
+		DryRun:      true,
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusPending, response.Status)
+	assert.Empty(t, response.Id)
+	require.Len(t, response.Plan, 5)
+
+	assert.Equal(t, model.PlanCoalesce, response.Plan[0].Action)
+	assert.Equal(t, model.CoalesceDropIdentical, response.Plan[0].Reason)
+	assert.Equal(t, pending.ID.String(), response.Plan[0].CommandId)
+	assert.Equal(t, sn1.ID.String(), response.Plan[0].RouterId)
+
+	assert.Equal(t, model.PlanSkip, response.Plan[1].Action)
+	assert.Equal(t, model.SkipDecommissioned, response.Plan[1].Reason)
+
+	assert.Equal(t, model.PlanCreate, response.Plan[2].Action)
+	assert.True(t, response.Plan[2].Register)
+	assert.Empty(t, response.Plan[2].RouterId)
+
+	assert.Equal(t, model.PlanSkip, response.Plan[3].Action)
+	assert.Equal(t, model.SkipInvalid, response.Plan[3].Reason)
+
+	// the same new router got its command from the earlier entry
+	assert.Equal(t, model.PlanCoalesce, response.Plan[4].Action)
+	assert.True(t, response.Plan[4].Register)
+}
+
+func TestSendCommand_DryRunReplacePending(t *testing.T) {
+	s, mockPostgres, _, ctx := setup(t)
+	WithSendBatching(1, 0, 0)(s)
+	router := model.Router{ID: uuid.New(), SerialNumber: "SN1"}
+	older := model.Command{ID: uuid.New(), RouterID: router.ID, CommandType: "UPDATE_FIRMWARE", Status: model.StatusPending}
+
+	// each batch reads the commands as they are in PostgreSQL
+	mockPostgres.EXPECT().FindRoutersBySelector(gomock.Any(), gomock.Any()).Return([]model.Router{router}, nil).Times(2)
+	mockPostgres.EXPECT().GetPendingCommands(gomock.Any(), gomock.Len(1)).Return([]model.Command{older}, nil).Times(2)
+
+	response, err := s.SendCommand(ctx, &pb.SendCommandRequest{
+		Routers:     []*pb.Router{{SerialNumber: "SN1"}, {SerialNumber: "SN1"}},
+		CommandType: "UPDATE_FIRMWARE",
+		DryRun:      true,
+	})
+
+	require.NoError(t, err)
+	require.Len(t, response.Plan, 2)
+	assert.Equal(t, model.PlanCreate, response.Plan[0].Action)
+	assert.Equal(t, model.CoalesceReplacePending, response.Plan[0].Reason)
+	assert.Equal(t, []string{older.ID.String()}, response.Plan[0].ReplacedIds)
+
+	// the second batch replaces the command of the first one, not the
+	// already replaced older one
+	assert.Equal(t, model.PlanCreate, response.Plan[1].Action)
+	require.Len(t, response.Plan[1].ReplacedIds, 1)
+	assert.NotEqual(t, older.ID.String(), response.Plan[1].ReplacedIds[0])
+}
+
+func TestSendCommand_DryRunUnknownRouters(t *testing.T) {
+	s, mockPostgres, _, ctx := setup(t)
+	WithRouterRegistration(false)(s)
+
+	mockPostgres.EXPECT().FindRoutersBySelector(gomock.Any(), gomock.Any()).Return(nil, nil)
+
+	response, err := s.SendCommand(ctx, &pb.SendCommandRequest{
+		Routers:     []*pb.Router{{SerialNumber: "SN1"}},
+		CommandType: "REBOOT",
+		DryRun:      true,
+	})
+
+	require.NoError(t, err)
+	require.Len(t, response.Plan, 1)
+	assert.Equal(t, model.PlanSkip, response.Plan[0].Action)
+	assert.Equal(t, model.SkipUnknown, response.Plan[0].Reason)
+}
+
+func TestSendCommand_DryRunAwaitsApproval(t *testing.T) {
+	s, mockPostgres, _, ctx := setup(t)
+	WithApprovals([]string{"FACTORY_RESET"}, time.Hour)(s)
+
+	// no approval is requested, and held back commands don't coalesce
+	mockPostgres.EXPECT().FindRoutersBySelector(gomock.Any(), gomock.Any()).Return(nil, nil)
+
+	response, err := s.SendCommand(ctx, &pb.SendCommandRequest{
+		Routers:        []*pb.Router{{SerialNumber: "SN1"}},
+		CommandType:    "FACTORY_RESET",
+		IdempotencyKey: "ignored",
+		DryRun:         true,
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusAwaitingApproval, response.Status)
+	assert.Empty(t, response.ApprovalId)
+	require.Len(t, response.Plan, 1)
+	assert.Equal(t, model.PlanCreate, response.Plan[0].Action)
+}
+
+func TestSendCommand_CacheUnavailable(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+
+	mockPostgres.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil)
+	mockRedis.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(redis.ErrCacheUnavailable)
+	mockPostgres.EXPECT().SaveCommandsCoalesced(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(savedAsSent)
+	mockRedis.EXPECT().SaveCommands(gomock.Any(), gomock.Any()).Return(redis.ErrCacheUnavailable)
+
+	response, err := s.SendCommand(ctx, &pb.SendCommandRequest{
+		Routers:     []*pb.Router{{SerialNumber: "SN123"}},
+		CommandType: "REBOOT",
+	})
+
+	require.NoError(t, err)
+	assert.Len(t, response.Id, 1)
+}
+
+func TestSendCommand_Batches(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	WithSendBatching(2, 0, 0)(s)
+
+	// 5 routers in batches of 2, 2 and 1
+	for _, size := range []int{2, 2, 1} {
+		mockPostgres.EXPECT().SaveRouters(gomock.Any(), gomock.Len(size)).Return(nil)
+		mockRedis.EXPECT().SaveRouters(gomock.Any(), gomock.Len(size)).Return(nil)
+		mockPostgres.EXPECT().SaveCommandsCoalesced(gomock.Any(), gomock.Len(size), gomock.Any()).DoAndReturn(savedAsSent)
+		mockRedis.EXPECT().SaveCommands(gomock.Any(), gomock.Len(size)).Return(nil)
+	}
+
+	var routers []*pb.Router
+	for i := 0; i < 5; i++ {
+		routers = append(routers, &pb.Router{SerialNumber: fmt.Sprintf("SN%d", i)})
+	}
+
+	response, err := s.SendCommand(ctx, &pb.SendCommandRequest{Routers: routers, CommandType: "REBOOT"})
+
+	require.NoError(t, err)
+	assert.Len(t, response.Id, 5)
+}
+
+func TestSendCommand_StartsJobAboveThreshold(t *testing.T) {
+	s, mockPostgres, _, ctx := setup(t)
+	WithSendBatching(2, 2, 0)(s)
+
+	// the commands are sent by the job workers, not by the request
+	var job *model.Job
+	mockPostgres.EXPECT().
+		SaveJob(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, j *model.Job) error {
+			job = j
+			return nil
+		})
+
+	response, err := s.SendCommand(ctx, &pb.SendCommandRequest{
+		Routers:     []*pb.Router{{SerialNumber: "SN1"}, {SerialNumber: "SN2"}, {SerialNumber: "SN3"}},
+		CommandType: "REBOOT",
+	})
+
+	require.NoError(t, err)
+	assert.Empty(t, response.Id)
+	assert.Equal(t, job.ID.String(), response.JobId)
+	assert.Equal(t, model.JobSendCommand, job.Kind)
+	assert.Equal(t, model.JobPending, job.Status)
+	assert.Equal(t, 3, job.Total)
+	assert.JSONEq(t, `{"routers": [{"serialNumber": "SN1"}, {"serialNumber": "SN2"}, {"serialNumber": "SN3"}], "commandType": "REBOOT"}`, string(job.Request))
+}
+
+// sendJob returns a job sending REBOOT to the given routers.
+func sendJob(t *testing.T, serials ...string) *model.Job {
+	req := &pb.SendCommandRequest{CommandType: "REBOOT"}
+	for _, serial := range serials {
+		req.Routers = append(req.Routers, &pb.Router{SerialNumber: serial})
+	}
+
+	request, err := protojson.Marshal(req)
+	require.NoError(t, err)
+
+	return &model.Job{ID: uuid.New(), Kind: model.JobSendCommand, Status: model.JobRunning, Request: request, Total: len(serials)}
+}
+
+func TestRunSendJob_Failure(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	WithSendBatching(1, 1, 0)(s)
+
+	job := sendJob(t, "SN1", "SN2")
+
+	mockPostgres.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil)
+	mockRedis.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil)
+	mockPostgres.EXPECT().SaveCommandsCoalesced(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(savedAsSent)
+	mockRedis.EXPECT().SaveCommands(gomock.Any(), gomock.Any()).Return(nil)
+	mockPostgres.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(fmt.Errorf("connection reset"))
+
+	progressed := 0
+	err := s.RunSendJob(ctx, job, func() error {
+		progressed++
+		return nil
+	})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "connection reset")
+	assert.Equal(t, 1, job.Processed)
+	assert.Equal(t, 1, progressed)
+}
+
+func TestRunSendJob_Resumes(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	WithSendBatching(2, 2, 0)(s)
+
+	job := sendJob(t, "SN1", "SN2", "SN3")
+	job.Processed = 2
+
+	mockPostgres.EXPECT().
+		SaveRouters(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, routers []model.Router) error {
+			require.Len(t, routers, 1)
+			assert.Equal(t, "SN3", routers[0].SerialNumber)
+			return nil
+		})
+	mockRedis.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil)
+	mockPostgres.EXPECT().
+		SaveCommandsCoalesced(gomock.Any(), gomock.Len(1), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, cmds []model.Command, policy string) ([]*model.CoalesceResult, error) {
+			// the id of the command for the third router of the job
+			assert.Equal(t, commandID(job.ID, 2), cmds[0].ID)
+			return savedAsSent(ctx, cmds, policy)
+		})
+	mockRedis.EXPECT().SaveCommands(gomock.Any(), gomock.Any()).Return(nil)
+
+	err := s.RunSendJob(ctx, job, func() error { return nil })
+
+	require.NoError(t, err)
+	assert.Equal(t, 3, job.Processed)
+}
+
+func TestRunSendJob_BatchSentAgain(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	WithSendBatching(2, 2, 0)(s)
+
+	// the worker before crashed after the batch but before saving progress
+	job := sendJob(t, "SN1", "SN2")
+
+	mockPostgres.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil)
+	mockRedis.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil)
+	mockPostgres.EXPECT().
+		SaveCommandsCoalesced(gomock.Any(), gomock.Len(2), gomock.Any()).
+		DoAndReturn(func(_ context.Context, cmds []model.Command, _ string) ([]*model.CoalesceResult, error) {
+			assert.Equal(t, commandID(job.ID, 0), cmds[0].ID)
+			assert.Equal(t, commandID(job.ID, 1), cmds[1].ID)
+			return []*model.CoalesceResult{{AlreadySaved: true}, {AlreadySaved: true}}, nil
+		})
+	// nothing new to cache
+	mockRedis.EXPECT().SaveCommands(gomock.Any(), gomock.Len(0)).Return(nil)
+
+	err := s.RunSendJob(ctx, job, func() error { return nil })
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, job.Processed)
+}
+
+func TestRunSendJob_Cancelled(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	WithSendBatching(1, 1, 0)(s)
+
+	job := sendJob(t, "SN1", "SN2")
+
+	// the second batch is never sent
+	mockPostgres.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil)
+	mockRedis.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil)
+	mockPostgres.EXPECT().SaveCommandsCoalesced(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(savedAsSent)
+	mockRedis.EXPECT().SaveCommands(gomock.Any(), gomock.Any()).Return(nil)
+
+	err := s.RunSendJob(ctx, job, func() error { return model.ErrJobCancelled })
+
+	assert.ErrorIs(t, err, model.ErrJobCancelled)
+	assert.Equal(t, 1, job.Processed)
+}
+
+func TestRunSendJob_Throttled(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	// one router per batch, 20 routers per second
+	WithSendBatching(1, 1, 20)(s)
+
+	job := sendJob(t, "SN1", "SN2", "SN3")
+
+	mockPostgres.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil).Times(3)
+	mockRedis.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil).Times(3)
+	mockPostgres.EXPECT().SaveCommandsCoalesced(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(savedAsSent).Times(3)
+	mockRedis.EXPECT().SaveCommands(gomock.Any(), gomock.Any()).Return(nil).Times(3)
+
+	started := time.Now()
+	err := s.RunSendJob(ctx, job, func() error { return nil })
+
+	// two pauses of 50ms between the three batches
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(started), 100*time.Millisecond)
+	assert.Equal(t, 3, job.Processed)
+}
+
+func TestRunSendJob_StopsOnShutdown(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	// one router per batch, one router per minute
+	WithSendBatching(1, 1, 1.0/60)(s)
+
+	job := sendJob(t, "SN1", "SN2")
+
+	mockPostgres.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil)
+	mockRedis.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil)
+	mockPostgres.EXPECT().SaveCommandsCoalesced(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(savedAsSent)
+	mockRedis.EXPECT().SaveCommands(gomock.Any(), gomock.Any()).Return(nil)
+
+	ctx, cancel := context.WithCancel(ctx)
+	err := s.RunSendJob(ctx, job, func() error {
+		cancel()
+		return nil
+	})
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, job.Processed)
+}
+
+func TestSendCommand_AwaitsApproval(t *testing.T) {
+	s, mockPostgres, mockRedis, _ := setup(t)
+	WithApprovals([]string{"FACTORY_RESET"}, time.Hour)(s)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-caller-id", "alice"))
+
+	var approval *model.Approval
+	mockPostgres.EXPECT().
+		SaveApproval(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, a *model.Approval) error {
+			approval = a
+			return nil
+		})
+	mockPostgres.EXPECT().SaveRouters(gomock.Any(), gomock.Len(2)).Return(nil)
+	mockRedis.EXPECT().SaveRouters(gomock.Any(), gomock.Len(2)).Return(nil)
+	// held back commands don't replace approved ones
+	mockPostgres.EXPECT().
+		SaveCommandsCoalesced(gomock.Any(), gomock.Len(2), model.CoalesceKeepAll).
+		DoAndReturn(func(ctx context.Context, cmds []model.Command, policy string) ([]*model.CoalesceResult, error) {
+			for _, cmd := range cmds {
+				assert.Equal(t, model.StatusAwaitingApproval, cmd.Status)
+				assert.Equal(t, approval.ID, *cmd.ApprovalID)
+			}
+			return savedAsSent(ctx, cmds, policy)
+		})
+	mockRedis.EXPECT().SaveCommands(gomock.Any(), gomock.Len(2)).Return(nil)
+
+	response, err := s.SendCommand(ctx, &pb.SendCommandRequest{
+		Routers:     []*pb.Router{{SerialNumber: "SN1"}, {SerialNumber: "SN2"}},
+		CommandType: "FACTORY_RESET",
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusAwaitingApproval, response.Status)
+	assert.Equal(t, approval.ID.String(), response.ApprovalId)
+	assert.Len(t, response.Id, 2)
+	assert.Equal(t, "alice", approval.RequestedBy)
+	assert.Equal(t, model.ApprovalPending, approval.Status)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), approval.ExpiresAt, time.Minute)
+}
+
+func TestSendCommand_AwaitsApprovalInJob(t *testing.T) {
+	s, mockPostgres, _, ctx := setup(t)
+	WithApprovals([]string{"FACTORY_RESET"}, time.Hour)(s)
+	WithSendBatching(2, 2, 0)(s)
+
+	var approval *model.Approval
+	mockPostgres.EXPECT().
+		SaveApproval(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, a *model.Approval) error {
+			approval = a
+			return nil
+		})
+	var job *model.Job
+	mockPostgres.EXPECT().
+		SaveJob(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, j *model.Job) error {
+			job = j
+			return nil
+		})
+
+	response, err := s.SendCommand(ctx, &pb.SendCommandRequest{
+		Routers:     []*pb.Router{{SerialNumber: "SN1"}, {SerialNumber: "SN2"}, {SerialNumber: "SN3"}},
+		CommandType: "FACTORY_RESET",
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusAwaitingApproval, response.Status)
+	assert.Equal(t, approval.ID.String(), response.ApprovalId)
+	assert.Equal(t, job.ID.String(), response.JobId)
+	assert.Equal(t, approval.ID, *job.ApprovalID)
+}
+
+func TestRunSendJob_AwaitsApproval(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+
+	approvalId := uuid.New()
+	job := sendJob(t, "SN1")
+	job.ApprovalID = &approvalId
+
+	mockPostgres.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil)
+	mockRedis.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil)
+	mockPostgres.EXPECT().
+		SaveCommandsCoalesced(gomock.Any(), gomock.Len(1), model.CoalesceKeepAll).
+		DoAndReturn(func(ctx context.Context, cmds []model.Command, policy string) ([]*model.CoalesceResult, error) {
+			assert.Equal(t, model.StatusAwaitingApproval, cmds[0].Status)
+			assert.Equal(t, approvalId, *cmds[0].ApprovalID)
+			return savedAsSent(ctx, cmds, policy)
+		})
+	mockRedis.EXPECT().SaveCommands(gomock.Any(), gomock.Any()).Return(nil)
+
+	err := s.RunSendJob(ctx, job, func() error { return nil })
+
+	require.NoError(t, err)
+}
+
+func TestSendCommand_IdempotencyKeyReplayApproval(t *testing.T) {
+	s, mockPostgres, _, _ := setup(t)
+	WithApprovals([]string{"FACTORY_RESET"}, time.Hour)(s)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-caller-id", "alice"))
+
+	req := &pb.SendCommandRequest{
+		Routers:        []*pb.Router{{SerialNumber: "SN1"}},
+		CommandType:    "FACTORY_RESET",
+		IdempotencyKey: "reset-1",
+	}
+	hash, err := requestHash(req)
+	require.NoError(t, err)
+
+	commandId, approvalId, completed := uuid.New(), uuid.New(), time.Now()
+	mockPostgres.EXPECT().
+		ReserveIdempotencyKey(gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(&model.IdempotencyKey{RequestHash: hash, CommandIDs: []uuid.UUID{commandId}, ApprovalID: &approvalId, CompletedAt: &completed}, nil)
+
+	// no second approval is requested
+	response, err := s.SendCommand(ctx, req)
+
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusAwaitingApproval, response.Status)
+	assert.Equal(t, approvalId.String(), response.ApprovalId)
+	assert.Equal(t, []string{commandId.String()}, response.Id)
+}
+
+func TestSendCommand_Priority(t *testing.T) {
+	tests := []struct {
+		name        string
+		commandType string
+		priority    *int32
+		expected    int
+	}{
+		{"TypeDefault", "RUN_DIAGNOSTICS", nil, 10},
+		{"UnknownTypeDefault", "CUSTOM", nil, model.DefaultPriority},
+		{"Explicit", "RUN_DIAGNOSTICS", proto.Int32(95), 95},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mockPostgres, mockRedis, ctx := setup(t)
+
+			mockPostgres.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil)
+			mockRedis.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil)
+			mockPostgres.EXPECT().
+				SaveCommandsCoalesced(gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(ctx context.Context, cmds []model.Command, policy string) ([]*model.CoalesceResult, error) {
+					assert.Equal(t, tt.expected, cmds[0].Priority)
+					return savedAsSent(ctx, cmds, policy)
+				})
+			mockRedis.EXPECT().SaveCommands(gomock.Any(), gomock.Any()).Return(nil)
+
+			_, err := s.SendCommand(ctx, &pb.SendCommandRequest{
+				Routers:     []*pb.Router{{SerialNumber: "SN123"}},
+				CommandType: tt.commandType,
+				Priority:    tt.priority,
+			})
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestSendCommand_PriorityOutOfRange(t *testing.T) {
+	s, _, _, ctx := setup(t)
+
+	response, err := s.SendCommand(ctx, &pb.SendCommandRequest{
+		Routers:     []*pb.Router{{SerialNumber: "SN123"}},
+		CommandType: "REBOOT",
+		Priority:    proto.Int32(model.MaxPriority + 1),
+	})
+
+	require.Nil(t, response)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
 // test epty routers SendCommand
+func TestSendCommand_Payload(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+
+	mockPostgres.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+	mockRedis.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+	mockRedis.EXPECT().SaveCommands(gomock.Any(), gomock.Len(1)).Return(nil).Times(2)
+
+	var saved []model.Command
+	mockPostgres.EXPECT().
+		SaveCommandsCoalesced(gomock.Any(), gomock.Len(1), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, cmds []model.Command, policy string) ([]*model.CoalesceResult, error) {
+			saved = append(saved, cmds...)
+			return savedAsSent(ctx, cmds, policy)
+		}).Times(2)
+
+	// commands sent without a payload get the default one of their type
+	for _, payload := range []string{`{"version": "2.1.0"}`, ""} {
+		_, err := s.SendCommand(ctx, &pb.SendCommandRequest{
+			Routers:     []*pb.Router{{SerialNumber: "SN1"}},
+			CommandType: "UPDATE_FIRMWARE",
+			Payload:     payload,
+		})
+		require.NoError(t, err)
+	}
+
+	require.Len(t, saved, 2)
+	assert.JSONEq(t, `{"version": "2.1.0"}`, string(saved[0].Payload))
+	assert.JSONEq(t, `{"command": "UPDATE_FIRMWARE"}`, string(saved[1].Payload))
+}
+
+func TestSendCommand_InvalidPayload(t *testing.T) {
+	s, _, _, ctx := setup(t)
+
+	response, err := s.SendCommand(ctx, &pb.SendCommandRequest{
+		Routers:     []*pb.Router{{SerialNumber: "SN1"}},
+		CommandType: "UPDATE_FIRMWARE",
+		Payload:     `{"version":`,
+	})
+
+	require.Nil(t, response)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
 func TestSendCommand_EmptyRouters(t *testing.T) {
 	s, _, _, ctx := setup(t)
 
@@ -149,8 +925,293 @@ func TestSendCommand_EmptyCommandType(t *testing.T) {
 	assert.Contains(t, err.Error(), "no command specified")
 }
 
+func TestSendCommand_IdempotencyKeyFirstCall(t *testing.T) {
+	s, mockPostgres, mockRedis, _ := setup(t)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-caller-id", "orchestrator"))
+
+	req := &pb.SendCommandRequest{
+		Routers:        []*pb.Router{{SerialNumber: "SN123"}},
+		CommandType:    "RUN_DIAGNOSTICS",
+		IdempotencyKey: "retry-1",
+	}
+
+	var seed uuid.UUID
+	mockPostgres.EXPECT().
+		ReserveIdempotencyKey(ctx, gomock.AssignableToTypeOf(&model.IdempotencyKey{}), gomock.Any()).
+		DoAndReturn(func(_ context.Context, key *model.IdempotencyKey, expiredBefore time.Time) (*model.IdempotencyKey, error) {
+			assert.Equal(t, "orchestrator", key.Caller)
+			assert.Equal(t, "retry-1", key.Key)
+			assert.NotEmpty(t, key.RequestHash)
+			assert.NotEqual(t, uuid.Nil, key.ID)
+			assert.WithinDuration(t, time.Now().Add(time.Minute), key.LeaseExpiresAt, time.Second)
+			assert.WithinDuration(t, time.Now().Add(-24*time.Hour), expiredBefore, time.Minute)
+			seed = key.ID
+			return nil, nil
+		})
+
+	mockPostgres.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil)
+	mockRedis.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil)
+	mockPostgres.EXPECT().SaveCommandsCoalesced(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(savedAsSent)
+	mockRedis.EXPECT().SaveCommands(gomock.Any(), gomock.Any()).Return(nil)
+
+	var stored []uuid.UUID
+	mockPostgres.EXPECT().
+		CompleteIdempotencyKey(ctx, gomock.Any()).
+		DoAndReturn(func(_ context.Context, key *model.IdempotencyKey) error {
+			assert.Equal(t, "orchestrator", key.Caller)
+			assert.Equal(t, "retry-1", key.Key)
+			assert.Equal(t, seed, key.ID)
+			assert.Nil(t, key.JobID)
+			assert.NotNil(t, key.CompletedAt)
+			stored = key.CommandIDs
+			return nil
+		})
+
+	response, err := s.SendCommand(ctx, req)
+
+	require.NoError(t, err)
+	require.Len(t, response.Id, 1)
+	assert.Equal(t, stored[0].String(), response.Id[0])
+	assert.Equal(t, commandID(seed, 0).String(), response.Id[0])
+}
+
+func TestSendCommand_IdempotencyKeyCompletedWithoutCommands(t *testing.T) {
+	s, mockPostgres, _, ctx := setup(t)
+	WithRouterRegistration(false)(s)
+
+	req := &pb.SendCommandRequest{
+		Routers:        []*pb.Router{{SerialNumber: "SN-UNKNOWN"}},
+		CommandType:    "REBOOT",
+		IdempotencyKey: "retry-1",
+	}
+
+	mockPostgres.EXPECT().ReserveIdempotencyKey(ctx, gomock.Any(), gomock.Any()).Return(nil, nil)
+	mockPostgres.EXPECT().FindRoutersBySelector(gomock.Any(), gomock.Any()).Return(nil, nil)
+	mockPostgres.EXPECT().
+		CompleteIdempotencyKey(ctx, gomock.Any()).
+		DoAndReturn(func(_ context.Context, key *model.IdempotencyKey) error {
+			assert.Empty(t, key.CommandIDs)
+			assert.Nil(t, key.JobID)
+			assert.NotNil(t, key.CompletedAt)
+			return nil
+		})
+
+	response, err := s.SendCommand(ctx, req)
+	require.NoError(t, err)
+	assert.Empty(t, response.Id)
+
+	// the retry replays the empty result instead of waiting for it
+	hash, err := requestHash(req)
+	require.NoError(t, err)
+	completed := time.Now()
+	mockPostgres.EXPECT().
+		ReserveIdempotencyKey(ctx, gomock.Any(), gomock.Any()).
+		Return(&model.IdempotencyKey{Key: "retry-1", RequestHash: hash, CompletedAt: &completed}, nil)
+
+	response, err = s.SendCommand(ctx, req)
+	require.NoError(t, err)
+	assert.Empty(t, response.Id)
+}
+
+func TestSendCommand_IdempotencyKeyTakenOver(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+
+	// the earlier attempt saved the command and crashed before completing
+	seed := uuid.New()
+	mockPostgres.EXPECT().
+		ReserveIdempotencyKey(ctx, gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, key *model.IdempotencyKey, _ time.Time) (*model.IdempotencyKey, error) {
+			key.ID = seed
+			return nil, nil
+		})
+	mockPostgres.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil)
+	mockRedis.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil)
+	mockPostgres.EXPECT().
+		SaveCommandsCoalesced(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, cmds []model.Command, _ string) ([]*model.CoalesceResult, error) {
+			require.Len(t, cmds, 1)
+			assert.Equal(t, commandID(seed, 0), cmds[0].ID)
+			return []*model.CoalesceResult{{AlreadySaved: true}}, nil
+		})
+	mockRedis.EXPECT().SaveCommands(gomock.Any(), gomock.Len(0)).Return(nil)
+	mockPostgres.EXPECT().CompleteIdempotencyKey(ctx, gomock.Any()).Return(nil)
+
+	response, err := s.SendCommand(ctx, &pb.SendCommandRequest{
+		Routers:        []*pb.Router{{SerialNumber: "SN123"}},
+		CommandType:    "RUN_DIAGNOSTICS",
+		IdempotencyKey: "retry-1",
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{commandID(seed, 0).String()}, response.Id)
+	assert.Empty(t, response.Coalesced)
+}
+
+func TestSendCommand_IdempotencyKeyReplay(t *testing.T) {
+	s, mockPostgres, _, ctx := setup(t)
+
+	req := &pb.SendCommandRequest{
+		Routers:        []*pb.Router{{SerialNumber: "SN123"}},
+		CommandType:    "REBOOT",
+		IdempotencyKey: "retry-1",
+	}
+	hash, err := requestHash(req)
+	require.NoError(t, err)
+
+	original, completed := uuid.New(), time.Now()
+	mockPostgres.EXPECT().
+		ReserveIdempotencyKey(ctx, gomock.Any(), gomock.Any()).
+		Return(&model.IdempotencyKey{Caller: "anonymous", Key: "retry-1", RequestHash: hash, CommandIDs: []uuid.UUID{original}, CompletedAt: &completed}, nil)
+
+	// nothing is saved again
+	response, err := s.SendCommand(ctx, req)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{original.String()}, response.Id)
+}
+
+func TestSendCommand_IdempotencyKeyReplayJob(t *testing.T) {
+	s, mockPostgres, _, ctx := setup(t)
+
+	req := &pb.SendCommandRequest{
+		Routers:        []*pb.Router{{SerialNumber: "SN123"}},
+		CommandType:    "REBOOT",
+		IdempotencyKey: "retry-1",
+	}
+	hash, err := requestHash(req)
+	require.NoError(t, err)
+
+	jobId, completed := uuid.New(), time.Now()
+	mockPostgres.EXPECT().
+		ReserveIdempotencyKey(ctx, gomock.Any(), gomock.Any()).
+		Return(&model.IdempotencyKey{Key: "retry-1", RequestHash: hash, JobID: &jobId, CompletedAt: &completed}, nil)
+
+	response, err := s.SendCommand(ctx, req)
+
+	require.NoError(t, err)
+	assert.Empty(t, response.Id)
+	assert.Equal(t, jobId.String(), response.JobId)
+}
+
+func TestSendCommand_IdempotencyKeyDifferentBody(t *testing.T) {
+	s, mockPostgres, _, ctx := setup(t)
+
+	mockPostgres.EXPECT().
+		ReserveIdempotencyKey(ctx, gomock.Any(), gomock.Any()).
+		Return(&model.IdempotencyKey{Key: "retry-1", RequestHash: "other", CommandIDs: []uuid.UUID{uuid.New()}}, nil)
+
+	response, err := s.SendCommand(ctx, &pb.SendCommandRequest{
+		Routers:        []*pb.Router{{SerialNumber: "SN123"}},
+		CommandType:    "FACTORY_RESET",
+		IdempotencyKey: "retry-1",
+	})
+
+	require.Nil(t, response)
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+}
+
+func TestSendCommand_IdempotencyKeyInProgress(t *testing.T) {
+	s, mockPostgres, _, ctx := setup(t)
+
+	req := &pb.SendCommandRequest{
+		Routers:        []*pb.Router{{SerialNumber: "SN123"}},
+		CommandType:    "REBOOT",
+		IdempotencyKey: "retry-1",
+	}
+	hash, err := requestHash(req)
+	require.NoError(t, err)
+
+	mockPostgres.EXPECT().
+		ReserveIdempotencyKey(ctx, gomock.Any(), gomock.Any()).
+		Return(&model.IdempotencyKey{Key: "retry-1", RequestHash: hash}, nil)
+
+	response, err := s.SendCommand(ctx, req)
+
+	require.Nil(t, response)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+}
+
+func TestSendCommand_IdempotencyKeyReleasedWithPartialResult(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	WithSendBatching(1, 0, 0)(s)
+
+	var seed uuid.UUID
+	mockPostgres.EXPECT().
+		ReserveIdempotencyKey(ctx, gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, key *model.IdempotencyKey, _ time.Time) (*model.IdempotencyKey, error) {
+			seed = key.ID
+			return nil, nil
+		})
+	mockPostgres.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+	mockRedis.EXPECT().SaveRouters(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+	gomock.InOrder(
+		mockPostgres.EXPECT().SaveCommandsCoalesced(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(savedAsSent),
+		mockPostgres.EXPECT().
+			SaveCommandsCoalesced(gomock.Any(), gomock.Any(), gomock.Any()).
+			Return(nil, fmt.Errorf("connection reset")),
+	)
+	mockRedis.EXPECT().SaveCommands(gomock.Any(), gomock.Any()).Return(nil)
+
+	// the key keeps its id, so that the retry finds the first command
+	mockPostgres.EXPECT().
+		ReleaseIdempotencyKey(ctx, gomock.Any()).
+		DoAndReturn(func(_ context.Context, key *model.IdempotencyKey) error {
+			assert.Equal(t, seed, key.ID)
+			assert.Equal(t, []uuid.UUID{commandID(seed, 0)}, key.CommandIDs)
+			assert.Nil(t, key.CompletedAt)
+			return nil
+		})
+
+	response, err := s.SendCommand(ctx, &pb.SendCommandRequest{
+		Routers:        []*pb.Router{{SerialNumber: "SN1"}, {SerialNumber: "SN2"}},
+		CommandType:    "RUN_DIAGNOSTICS",
+		IdempotencyKey: "retry-1",
+	})
+
+	require.Nil(t, response)
+	assert.Error(t, err)
+}
+
 /* --- test PollCommands method --- */
 
+// changedTo makes ChangeStatusByIds change the given commands to the
+// status if they are asked for; the others were changed meanwhile.
+func changedTo(commands ...model.Command) func(context.Context, uuid.UUID, []uuid.UUID, string) ([]model.Command, error) {
+	return func(_ context.Context, _ uuid.UUID, ids []uuid.UUID, status string) ([]model.Command, error) {
+		var changed []model.Command
+		for _, command := range commands {
+			if slices.Contains(ids, command.ID) {
+				command.Status = status
+				changed = append(changed, command)
+			}
+		}
+		return changed, nil
+	}
+}
+
+// markedSent makes MarkCommandsSent mark the commands SENT, in reverse order
+// like PostgreSQL may return them, except those changed meanwhile.
+func markedSent(changedMeanwhile ...uuid.UUID) func(context.Context, uuid.UUID, []model.Command) ([]model.Command, error) {
+	return func(_ context.Context, _ uuid.UUID, commands []model.Command) ([]model.Command, error) {
+		var sent []model.Command
+		for _, command := range slices.Backward(commands) {
+			if !slices.Contains(changedMeanwhile, command.ID) {
+				command.Status = model.StatusSent
+				sent = append(sent, command)
+			}
+		}
+		return sent, nil
+	}
+}
+
+// cacheDown makes PollCommands find the Redis cache unavailable, so that it
+// reads the commands from PostgreSQL.
+func cacheDown(mockRedis *mocksred.MockRedisRepo) {
+	mockRedis.EXPECT().
+		FindCommandsByStatus(gomock.Any(), gomock.Any(), model.StatusPending, gomock.Any()).
+		Return(nil, redis.ErrCacheUnavailable)
+}
+
 func TestPollCommands(t *testing.T) {
 	s, mockPostgres, mockRedis, ctx := setup(t)
 	expectedUuid := uuid.New()
@@ -165,6 +1226,7 @@ func TestPollCommands(t *testing.T) {
 		{RouterID: expectedRouter.ID,
 			CommandType: "REBOOT",
 			Payload:     nil,
+			Status:      model.StatusPending,
 		},
 	}
 
@@ -183,33 +1245,140 @@ func TestPollCommands(t *testing.T) {
 		Return(nil).
 		Times(1)
 
-	mockRedis.EXPECT().
-		FindCommandsByRouterId(gomock.Any(), expectedRouter.ID).
+	cacheDown(mockRedis)
+	mockPostgres.EXPECT().
+		GetCommandsByRouterIdAndStatus(gomock.Any(), expectedRouter.ID, model.StatusPending, 0).
 		Return(expectedCommands, nil).
 		Times(1)
 
+	mockPostgres.EXPECT().
+		GetCommandsByRouterIdAndStatus(gomock.Any(), expectedRouter.ID, model.StatusCancelling, 0).
+		Return(nil, nil).
+		Times(1)
+
+	mockPostgres.EXPECT().
+		MarkCommandsSent(gomock.Any(), gomock.Eq(expectedUuid), expectedCommands).
+		DoAndReturn(markedSent()).
+		Times(1)
+
+	mockRedis.EXPECT().
+		UpdateCommands(gomock.Any(), gomock.Len(1)).
+		Return(nil).
+		Times(1)
+
+	req := &pb.PollRequest{
+		RouterId:     expectedUuid.String(),
+		SerialNumber: "SN123",
+	}
+
+	response, err := s.PollCommands(ctx, req)
+
+	require.NoError(t, err)
+	require.NotNil(t, response)
+	assert.Len(t, response.Commands, 1)
+	assert.NotEmpty(t, response.Commands[0])
+	assert.Equal(t, response.Commands[0].CommandType, "REBOOT")
+}
+
+func TestPollCommands_FromCache(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	router := &model.Router{ID: uuid.New(), SerialNumber: "SN123"}
+	cancelledAt := time.Now()
+	pending := []model.Command{{ID: uuid.New(), RouterID: router.ID, CommandType: "REBOOT", Status: model.StatusPending}}
+	cancelling := []model.Command{{ID: uuid.New(), RouterID: router.ID, CommandType: "UPDATE_FIRMWARE", Status: model.StatusCancelling,
+		CancelledAt: &cancelledAt, CancelReason: "wrong firmware"}}
+
+	mockRedis.EXPECT().FindRouterByRouterId(gomock.Any(), router.ID.String()).Return(router, nil)
+	mockPostgres.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+	mockRedis.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+
+	// a healthy cache is read instead of PostgreSQL, which still decides
+	mockRedis.EXPECT().FindCommandsByStatus(gomock.Any(), router.ID, model.StatusPending, 0).Return(pending, nil)
+	mockRedis.EXPECT().FindCommandsByStatus(gomock.Any(), router.ID, model.StatusCancelling, 0).Return(cancelling, nil)
+	mockPostgres.EXPECT().MarkCommandsSent(gomock.Any(), router.ID, pending).DoAndReturn(markedSent())
+	mockPostgres.EXPECT().ChangeStatusByIds(gomock.Any(), router.ID, commandIds(cancelling), model.StatusCancelled).
+		DoAndReturn(changedTo(cancelling...))
+	mockRedis.EXPECT().UpdateCommands(gomock.Any(), gomock.Len(1)).Return(nil).Times(2)
+
+	response, err := s.PollCommands(ctx, &pb.PollRequest{RouterId: router.ID.String(), SerialNumber: "SN123"})
+	require.NoError(t, err)
+	require.Len(t, response.Commands, 1)
+	assert.Equal(t, pending[0].ID.String(), response.Commands[0].Id)
+	require.Len(t, response.Cancellations, 1)
+	assert.Equal(t, cancelling[0].ID.String(), response.Cancellations[0].CommandId)
+}
+
+func TestPollCommands_CacheLookupFails(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	router := &model.Router{ID: uuid.New(), SerialNumber: "SN123"}
+	pending := []model.Command{{ID: uuid.New(), RouterID: router.ID, CommandType: "REBOOT", Status: model.StatusPending}}
+
+	mockRedis.EXPECT().FindRouterByRouterId(gomock.Any(), router.ID.String()).Return(router, nil)
+	mockPostgres.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+	mockRedis.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+
+	// a failed lookup is served by PostgreSQL, whole
+	mockRedis.EXPECT().FindCommandsByStatus(gomock.Any(), router.ID, model.StatusPending, 0).Return(nil, nil)
+	mockRedis.EXPECT().FindCommandsByStatus(gomock.Any(), router.ID, model.StatusCancelling, 0).
+		Return(nil, fmt.Errorf("failed to unmarshal command"))
+	mockPostgres.EXPECT().GetCommandsByRouterIdAndStatus(gomock.Any(), router.ID, model.StatusPending, 0).Return(pending, nil)
+	mockPostgres.EXPECT().GetCommandsByRouterIdAndStatus(gomock.Any(), router.ID, model.StatusCancelling, 0).Return(nil, nil)
+	mockPostgres.EXPECT().MarkCommandsSent(gomock.Any(), router.ID, pending).DoAndReturn(markedSent())
+	mockRedis.EXPECT().UpdateCommands(gomock.Any(), gomock.Len(1)).Return(nil)
+
+	before := testutil.ToFloat64(metrics.CacheFallbacks.WithLabelValues("commands"))
+	response, err := s.PollCommands(ctx, &pb.PollRequest{RouterId: router.ID.String(), SerialNumber: "SN123"})
+	require.NoError(t, err)
+	require.Len(t, response.Commands, 1)
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.CacheFallbacks.WithLabelValues("commands")))
+}
+func TestPollCommands_CancellationNotice(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	expectedUuid := uuid.New()
+	cancelledAt := time.Now()
+
+	expectedRouter := &model.Router{
+		ID:           expectedUuid,
+		SerialNumber: "SN123",
+	}
+
+	expectedCommands := []model.Command{
+		{ID: uuid.New(), RouterID: expectedUuid, CommandType: "REBOOT", Status: model.StatusPending},
+		{ID: uuid.New(), RouterID: expectedUuid, CommandType: "UPDATE_FIRMWARE", Status: model.StatusCancelling,
+			CancelledAt: &cancelledAt, CancelReason: "wrong firmware"},
+	}
+
 	mockRedis.EXPECT().
-		ChangeStatusByRouterId(gomock.Any(), gomock.Eq(expectedUuid), gomock.Eq("SENT")).
-		Return(nil).
-		Times(1)
+		FindRouterByRouterId(gomock.Any(), expectedUuid.String()).
+		Return(expectedRouter, nil)
+	mockPostgres.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+	mockRedis.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
 
+	cacheDown(mockRedis)
 	mockPostgres.EXPECT().
-		ChangeStatusByRouterId(gomock.Any(), gomock.Eq(expectedUuid), gomock.Eq("SENT")).
-		Return(nil).
-		Times(1)
+		GetCommandsByRouterIdAndStatus(gomock.Any(), expectedUuid, model.StatusPending, 0).
+		Return(expectedCommands[:1], nil)
+	mockPostgres.EXPECT().
+		GetCommandsByRouterIdAndStatus(gomock.Any(), expectedUuid, model.StatusCancelling, 0).
+		Return(expectedCommands[1:], nil)
 
-	req := &pb.PollRequest{
+	cancelled := []uuid.UUID{expectedCommands[1].ID}
+	mockPostgres.EXPECT().MarkCommandsSent(gomock.Any(), expectedUuid, gomock.Len(1)).DoAndReturn(markedSent())
+	mockPostgres.EXPECT().ChangeStatusByIds(gomock.Any(), expectedUuid, cancelled, model.StatusCancelled).DoAndReturn(changedTo(expectedCommands...))
+	mockRedis.EXPECT().UpdateCommands(gomock.Any(), gomock.Len(1)).Return(nil).Times(2)
+
+	response, err := s.PollCommands(ctx, &pb.PollRequest{
 		RouterId:     expectedUuid.String(),
 		SerialNumber: "SN123",
-	}
-
-	response, err := s.PollCommands(ctx, req)
+	})
 
 	require.NoError(t, err)
-	require.NotNil(t, response)
-	assert.Len(t, response.Commands, 1)
-	assert.NotEmpty(t, response.Commands[0])
-	assert.Equal(t, response.Commands[0].CommandType, "REBOOT")
+	require.Len(t, response.Commands, 1)
+	assert.Equal(t, expectedCommands[0].ID.String(), response.Commands[0].Id)
+	require.Len(t, response.Cancellations, 1)
+	assert.Equal(t, expectedCommands[1].ID.String(), response.Cancellations[0].CommandId)
+	assert.Equal(t, "wrong firmware", response.Cancellations[0].Reason)
+	assert.NotNil(t, response.Cancellations[0].CancelledAt)
 }
 
 func TestPollCommands_EmptySerialNumber(t *testing.T) {
@@ -283,19 +1452,20 @@ func TestPollCommands_ErrorStatusChange(t *testing.T) {
 		Return(nil).
 		Times(1)
 
-	mockRedis.EXPECT().
-		FindCommandsByRouterId(gomock.Any(), expectedRouter.ID).
+	cacheDown(mockRedis)
+	mockPostgres.EXPECT().
+		GetCommandsByRouterIdAndStatus(gomock.Any(), expectedRouter.ID, model.StatusPending, 0).
 		Return(expectedCommands, nil).
 		Times(1)
 
-	mockRedis.EXPECT().
-		ChangeStatusByRouterId(gomock.Any(), expectedUuid, gomock.Eq("SENT")).
-		Return(fmt.Errorf("failed to change status")).
+	mockPostgres.EXPECT().
+		GetCommandsByRouterIdAndStatus(gomock.Any(), expectedRouter.ID, model.StatusCancelling, 0).
+		Return(nil, nil).
 		Times(1)
 
 	mockPostgres.EXPECT().
-		ChangeStatusByRouterId(gomock.Any(), expectedUuid, gomock.Eq("SENT")).
-		Return(fmt.Errorf("failed to change status")).
+		MarkCommandsSent(gomock.Any(), expectedUuid, gomock.Any()).
+		Return(nil, fmt.Errorf("failed to change status")).
 		Times(1)
 
 	req := &pb.PollRequest{
@@ -309,6 +1479,89 @@ func TestPollCommands_ErrorStatusChange(t *testing.T) {
 	require.Nil(t, response)
 }
 
+func TestPollCommands_MaxCommands(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	routerId := uuid.New()
+	urgent := model.Command{ID: uuid.New(), RouterID: routerId, CommandType: "FACTORY_RESET_ABORT",
+		Status: model.StatusPending, Priority: model.MaxPriority}
+
+	mockRedis.EXPECT().
+		FindRouterByRouterId(gomock.Any(), routerId.String()).
+		Return(&model.Router{ID: routerId, SerialNumber: "SN123"}, nil)
+	mockPostgres.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+	mockRedis.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+
+	cacheDown(mockRedis)
+	// PostgreSQL is asked for the first command in delivery order
+	mockPostgres.EXPECT().
+		GetCommandsByRouterIdAndStatus(gomock.Any(), routerId, model.StatusPending, 1).
+		Return([]model.Command{urgent}, nil)
+	mockPostgres.EXPECT().
+		GetCommandsByRouterIdAndStatus(gomock.Any(), routerId, model.StatusCancelling, 0).
+		Return(nil, nil)
+
+	// only the delivered command is marked SENT
+	mockPostgres.EXPECT().
+		MarkCommandsSent(gomock.Any(), routerId, []model.Command{urgent}).
+		DoAndReturn(markedSent())
+	mockRedis.EXPECT().UpdateCommands(gomock.Any(), gomock.Len(1)).Return(nil)
+
+	response, err := s.PollCommands(ctx, &pb.PollRequest{
+		RouterId:     routerId.String(),
+		SerialNumber: "SN123",
+		MaxCommands:  1,
+	})
+
+	require.NoError(t, err)
+	require.Len(t, response.Commands, 1)
+	assert.Equal(t, urgent.ID.String(), response.Commands[0].Id)
+	assert.Equal(t, int32(model.MaxPriority), response.Commands[0].Priority)
+}
+
+func TestPollCommands_OnlyChangedCommands(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	routerId := uuid.New()
+	first := model.Command{ID: uuid.New(), RouterID: routerId, CommandType: "REBOOT", Status: model.StatusPending, Priority: 50}
+	cancelled := model.Command{ID: uuid.New(), RouterID: routerId, CommandType: "RUN_DIAGNOSTICS", Status: model.StatusPending, Priority: 40}
+	last := model.Command{ID: uuid.New(), RouterID: routerId, CommandType: "METRICS_POLL", Status: model.StatusPending, Priority: 10}
+
+	mockRedis.EXPECT().
+		FindRouterByRouterId(gomock.Any(), routerId.String()).
+		Return(&model.Router{ID: routerId, SerialNumber: "SN123"}, nil)
+	mockPostgres.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+	mockRedis.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+
+	cacheDown(mockRedis)
+	mockPostgres.EXPECT().
+		GetCommandsByRouterIdAndStatus(gomock.Any(), routerId, model.StatusPending, 0).
+		Return([]model.Command{first, cancelled, last}, nil)
+	mockPostgres.EXPECT().
+		GetCommandsByRouterIdAndStatus(gomock.Any(), routerId, model.StatusCancelling, 0).
+		Return(nil, nil)
+
+	// the second command was cancelled after it was read; the others come
+	// back from PostgreSQL in no particular order
+	mockPostgres.EXPECT().
+		MarkCommandsSent(gomock.Any(), routerId, []model.Command{first, cancelled, last}).
+		DoAndReturn(markedSent(cancelled.ID))
+	mockRedis.EXPECT().
+		UpdateCommands(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, commands []model.Command) error {
+			require.Len(t, commands, 2)
+			for _, command := range commands {
+				assert.Equal(t, model.StatusSent, command.Status)
+			}
+			return nil
+		})
+
+	response, err := s.PollCommands(ctx, &pb.PollRequest{RouterId: routerId.String(), SerialNumber: "SN123"})
+
+	require.NoError(t, err)
+	require.Len(t, response.Commands, 2)
+	assert.Equal(t, first.ID.String(), response.Commands[0].Id)
+	assert.Equal(t, last.ID.String(), response.Commands[1].Id)
+}
+
 /* --- test AckCommands method --- */
 
 func TestAckCommand(t *testing.T) {
@@ -344,7 +1597,12 @@ func TestAckCommand(t *testing.T) {
 
 	mockPostgres.EXPECT().
 		ChangeStatusByRouterId(gomock.Any(), gomock.Eq(expectedUuid), gomock.Eq("ACKED")).
-		Return(nil).
+		Return(nil, nil).
+		Times(1)
+
+	mockPostgres.EXPECT().
+		ResolveWorkflows(gomock.Any(), expectedUuid, gomock.Any()).
+		Return(nil, nil, nil).
 		Times(1)
 
 	req := &pb.AckRequest{
@@ -403,77 +1661,595 @@ func TestAckCommand_NoRouterFound(t *testing.T) {
 	}
 
 	mockPostgres.EXPECT().
-		FindRouterByRouterId(ctx, gomock.Eq(routerId)).
-		Return(nil, fmt.Errorf("failed to query database"))
+		FindRouterByRouterId(ctx, gomock.Eq(routerId)).
+		Return(nil, fmt.Errorf("failed to query database"))
+
+	mockRedis.EXPECT().
+		FindRouterByRouterId(ctx, gomock.Eq(routerId)).
+		Return(nil, fmt.Errorf("failed to query database"))
+
+	response, err := s.AckCommand(ctx, req)
+
+	require.Error(t, err)
+	require.Nil(t, response)
+	assert.Contains(t, err.Error(), "there is no router with such serial_number")
+}
+
+func TestAckCommands_ErrorStatusChange(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	expectedUuid := uuid.New()
+
+	expectedRouter := &model.Router{
+		ID:           expectedUuid,
+		SerialNumber: "SN123",
+		IPAddress:    nil,
+	}
+
+	expectedCommands := []model.Command{
+		{RouterID: expectedRouter.ID,
+			CommandType: "REBOOT",
+			Payload:     nil,
+		},
+	}
+
+	mockRedis.EXPECT().
+		FindRouterByRouterId(gomock.Any(), gomock.Eq(expectedUuid.String())).
+		Return(expectedRouter, nil).
+		Times(1)
+
+	mockPostgres.EXPECT().
+		SaveRouter(gomock.Any(), gomock.AssignableToTypeOf(&model.Router{})).
+		Return(nil).
+		Times(1)
+
+	mockRedis.EXPECT().
+		SaveRouter(gomock.Any(), gomock.AssignableToTypeOf(&model.Router{})).
+		Return(nil).
+		Times(1)
+
+	mockRedis.EXPECT().
+		FindCommandsByRouterId(gomock.Any(), expectedRouter.ID).
+		Return(expectedCommands, nil).
+		Times(1)
+
+	mockRedis.EXPECT().
+		ChangeStatusByRouterId(gomock.Any(), expectedUuid, gomock.Eq("ACKED")).
+		Return(fmt.Errorf("failed to change status")).
+		Times(1)
+
+	mockPostgres.EXPECT().
+		ChangeStatusByRouterId(gomock.Any(), expectedUuid, gomock.Eq("ACKED")).
+		Return(nil, fmt.Errorf("failed to change status")).
+		Times(1)
+
+	req := &pb.AckRequest{
+		RouterId:     expectedUuid.String(),
+		SerialNumber: "SN123",
+		CommandType:  "REBOOT",
+	}
+
+	response, err := s.AckCommand(ctx, req)
+
+	require.Error(t, err)
+	require.Nil(t, response)
+}
+
+func TestAckCommand_ReleasesWorkflowStep(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	routerId := uuid.New()
+	commandId := uuid.New()
+	next := model.Command{ID: uuid.New(), RouterID: routerId, Status: model.StatusPending, WorkflowStep: "verify"}
+
+	mockRedis.EXPECT().FindRouterByRouterId(gomock.Any(), routerId.String()).Return(&model.Router{ID: routerId, SerialNumber: "SN123"}, nil)
+	mockPostgres.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+	mockRedis.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+
+	// only the acked command changes status
+	mockPostgres.EXPECT().
+		ChangeStatusByIds(gomock.Any(), routerId, []uuid.UUID{commandId}, model.StatusAcked).
+		DoAndReturn(changedTo(model.Command{ID: commandId, RouterID: routerId}))
+	mockRedis.EXPECT().UpdateCommands(gomock.Any(), gomock.Len(1)).Return(nil)
+
+	mockPostgres.EXPECT().
+		ResolveWorkflows(gomock.Any(), routerId, gomock.Any()).
+		Return([]model.Command{next}, nil, nil)
+	mockRedis.EXPECT().
+		SaveCommand(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, cmd *model.Command) error {
+			assert.Equal(t, next.ID, cmd.ID)
+			assert.Equal(t, model.StatusPending, cmd.Status)
+			return nil
+		})
+
+	response, err := s.AckCommand(ctx, &pb.AckRequest{
+		RouterId:     routerId.String(),
+		SerialNumber: "SN123",
+		CommandType:  "DOWNLOAD_FIRMWARE",
+		CommandId:    commandId.String(),
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusAcked, response.Status)
+}
+
+func TestAckCommand_Failure(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	routerId := uuid.New()
+	commandId := uuid.New()
+	dependent := model.Command{ID: uuid.New(), RouterID: routerId, Status: model.StatusCancelled}
+
+	mockRedis.EXPECT().FindRouterByRouterId(gomock.Any(), routerId.String()).Return(&model.Router{ID: routerId, SerialNumber: "SN123"}, nil)
+	mockPostgres.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+	mockRedis.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+
+	mockPostgres.EXPECT().FailCommand(gomock.Any(), routerId, commandId, "checksum mismatch").Return(true, nil)
+	mockRedis.EXPECT().FailCommand(gomock.Any(), routerId, commandId, "checksum mismatch").Return(nil)
+
+	mockPostgres.EXPECT().
+		ResolveWorkflows(gomock.Any(), routerId, gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ uuid.UUID, c model.Cancellation) ([]model.Command, []model.Command, error) {
+			assert.Equal(t, model.SystemActor, c.By)
+			return nil, []model.Command{dependent}, nil
+		})
+	mockRedis.EXPECT().SaveCommand(gomock.Any(), gomock.Any()).Return(nil)
+
+	response, err := s.AckCommand(ctx, &pb.AckRequest{
+		RouterId:     routerId.String(),
+		SerialNumber: "SN123",
+		CommandType:  "VERIFY_CHECKSUM",
+		CommandId:    commandId.String(),
+		Error:        "checksum mismatch",
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusFailed, response.Status)
+}
+
+func TestAckCommand_FailureNotSent(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	routerId := uuid.New()
+	commandId := uuid.New()
+
+	mockRedis.EXPECT().FindRouterByRouterId(gomock.Any(), routerId.String()).Return(&model.Router{ID: routerId, SerialNumber: "SN123"}, nil)
+	mockPostgres.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+	mockRedis.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+	mockPostgres.EXPECT().FailCommand(gomock.Any(), routerId, commandId, "boom").Return(false, nil)
+
+	response, err := s.AckCommand(ctx, &pb.AckRequest{
+		RouterId:     routerId.String(),
+		SerialNumber: "SN123",
+		CommandType:  "REBOOT",
+		CommandId:    commandId.String(),
+		Error:        "boom",
+	})
+
+	require.Nil(t, response)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestAckCommand_FailureWithoutCommandId(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	routerId := uuid.New()
+
+	mockRedis.EXPECT().FindRouterByRouterId(gomock.Any(), routerId.String()).Return(&model.Router{ID: routerId, SerialNumber: "SN123"}, nil)
+	mockPostgres.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+	mockRedis.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+
+	response, err := s.AckCommand(ctx, &pb.AckRequest{
+		RouterId:     routerId.String(),
+		SerialNumber: "SN123",
+		CommandType:  "REBOOT",
+		Error:        "boom",
+	})
+
+	require.Nil(t, response)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+/* --- test GetCommand and ListCommands methods --- */
+
+func TestGetCommand(t *testing.T) {
+	s, mockPostgres, _, ctx := setup(t)
+	sentAt := time.Now()
+
+	command := &model.Command{
+		ID:          uuid.New(),
+		RouterID:    uuid.New(),
+		CommandType: "REBOOT",
+		Status:      model.StatusSent,
+		SentAt:      &sentAt,
+		CreatedAt:   sentAt.Add(-time.Minute),
+	}
+
+	mockPostgres.EXPECT().
+		GetCommandById(ctx, command.ID).
+		Return(command, nil)
+
+	response, err := s.GetCommand(ctx, &pb.GetCommandRequest{CommandId: command.ID.String()})
+
+	require.NoError(t, err)
+	assert.Equal(t, command.ID.String(), response.Id)
+	assert.Equal(t, command.RouterID.String(), response.RouterId)
+	assert.Equal(t, model.StatusSent, response.Status)
+	assert.NotNil(t, response.SentAt)
+	assert.Nil(t, response.AckedAt)
+}
+
+func TestGetCommand_NotFound(t *testing.T) {
+	s, mockPostgres, _, ctx := setup(t)
+	commandId := uuid.New()
+
+	mockPostgres.EXPECT().
+		GetCommandById(ctx, commandId).
+		Return(nil, nil)
+
+	response, err := s.GetCommand(ctx, &pb.GetCommandRequest{CommandId: commandId.String()})
+
+	require.Nil(t, response)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestListCommands_Pagination(t *testing.T) {
+	s, mockPostgres, _, ctx := setup(t)
+	routerId := uuid.New()
+	now := time.Now().UTC()
+
+	var page []model.Command
+	for i := 0; i < 3; i++ {
+		page = append(page, model.Command{
+			ID:        uuid.New(),
+			RouterID:  routerId,
+			Status:    "FAILED",
+			CreatedAt: now.Add(-time.Duration(i) * time.Minute),
+		})
+	}
+
+	from := now.Add(-24 * time.Hour)
+	expectedFilter := model.CommandFilter{
+		RouterID:    &routerId,
+		CommandType: "REBOOT",
+		Statuses:    []string{"FAILED"},
+		CreatedFrom: &from,
+	}
+
+	// page_size 2: the third command only says there is a next page
+	mockPostgres.EXPECT().
+		ListCommands(ctx, expectedFilter, (*model.Cursor)(nil), 3).
+		Return(page, nil)
+
+	response, err := s.ListCommands(ctx, &pb.ListCommandsRequest{
+		RouterId:    routerId.String(),
+		CommandType: "REBOOT",
+		Status:      []string{"FAILED"},
+		Created:     &pb.TimeRange{From: timestamppb.New(from)},
+		PageSize:    2,
+	})
+
+	require.NoError(t, err)
+	require.Len(t, response.Commands, 2)
+	require.NotEmpty(t, response.NextPageToken)
+
+	// the token points at the last returned command
+	mockPostgres.EXPECT().
+		ListCommands(ctx, expectedFilter, &model.Cursor{CreatedAt: page[1].CreatedAt, ID: page[1].ID}, 3).
+		Return(page[2:], nil)
+
+	response, err = s.ListCommands(ctx, &pb.ListCommandsRequest{
+		RouterId:    routerId.String(),
+		CommandType: "REBOOT",
+		Status:      []string{"FAILED"},
+		Created:     &pb.TimeRange{From: timestamppb.New(from)},
+		PageSize:    2,
+		PageToken:   response.NextPageToken,
+	})
+
+	require.NoError(t, err)
+	require.Len(t, response.Commands, 1)
+	assert.Equal(t, page[2].ID.String(), response.Commands[0].Id)
+	assert.Empty(t, response.NextPageToken)
+}
+
+func TestListCommands_InvalidPageToken(t *testing.T) {
+	s, _, _, ctx := setup(t)
+
+	response, err := s.ListCommands(ctx, &pb.ListCommandsRequest{PageToken: "not a token"})
+
+	require.Nil(t, response)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+/* --- test CancelCommand(s) methods --- */
+
+func TestCancelCommand(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	commandId := uuid.New()
+
+	cancelled := []model.Command{
+		{ID: commandId, RouterID: uuid.New(), Status: model.StatusCancelled, CancelledBy: "alice"},
+	}
+
+	mockPostgres.EXPECT().
+		CancelCommands(ctx, model.CancelFilter{CommandID: &commandId}, gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ model.CancelFilter, c model.Cancellation) ([]model.Command, error) {
+			assert.Equal(t, "alice", c.By)
+			assert.Equal(t, "queued by mistake", c.Reason)
+			return cancelled, nil
+		})
+
+	mockRedis.EXPECT().
+		UpdateCommands(ctx, cancelled).
+		Return(nil)
+
+	response, err := s.CancelCommand(ctx, &pb.CancelCommandRequest{
+		CommandId:   commandId.String(),
+		CancelledBy: "alice",
+		Reason:      "queued by mistake",
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{commandId.String()}, response.Cancelled)
+	assert.Empty(t, response.Cancelling)
+}
+
+func TestCancelCommand_NotCancellable(t *testing.T) {
+	s, mockPostgres, _, ctx := setup(t)
+	commandId := uuid.New()
+
+	mockPostgres.EXPECT().
+		CancelCommands(ctx, gomock.Any(), gomock.Any()).
+		Return(nil, nil)
+
+	response, err := s.CancelCommand(ctx, &pb.CancelCommandRequest{
+		CommandId:   commandId.String(),
+		CancelledBy: "alice",
+	})
+
+	require.Nil(t, response)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestCancelCommand_InvalidRequest(t *testing.T) {
+	s, _, _, ctx := setup(t)
+
+	_, err := s.CancelCommand(ctx, &pb.CancelCommandRequest{CommandId: "42", CancelledBy: "alice"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestCancelCommand_RecordsAuthenticatedCaller(t *testing.T) {
+	s, mockPostgres, mockRedis, _ := setup(t)
+	ctx := auth.NewContext(context.Background(), &auth.Principal{Subject: "bob"})
+	commandId := uuid.New()
+
+	cancelled := []model.Command{{ID: commandId, RouterID: uuid.New(), Status: model.StatusCancelled, CancelledBy: "bob"}}
+	mockPostgres.EXPECT().
+		CancelCommands(ctx, gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ model.CancelFilter, c model.Cancellation) ([]model.Command, error) {
+			// the name in the request is only trusted without authentication
+			assert.Equal(t, "bob", c.By)
+			return cancelled, nil
+		})
+	mockRedis.EXPECT().UpdateCommands(ctx, cancelled).Return(nil)
+
+	_, err := s.CancelCommand(ctx, &pb.CancelCommandRequest{CommandId: commandId.String(), CancelledBy: "alice"})
+	require.NoError(t, err)
+}
+
+func TestCancelCommand_CallerWithoutName(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-caller-id", "ops-bot"))
+	commandId := uuid.New()
+
+	cancelled := []model.Command{{ID: commandId, RouterID: uuid.New(), Status: model.StatusCancelled}}
+	mockPostgres.EXPECT().
+		CancelCommands(ctx, gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ model.CancelFilter, c model.Cancellation) ([]model.Command, error) {
+			assert.Equal(t, "ops-bot", c.By)
+			return cancelled, nil
+		})
+	mockRedis.EXPECT().UpdateCommands(ctx, cancelled).Return(nil)
+
+	_, err := s.CancelCommand(ctx, &pb.CancelCommandRequest{CommandId: commandId.String()})
+	require.NoError(t, err)
+}
+
+func TestCancelCommand_CancelsWorkflowDependents(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	routerId := uuid.New()
+	workflowId := uuid.New()
+	commandId := uuid.New()
+
+	cancelled := []model.Command{
+		{ID: commandId, RouterID: routerId, Status: model.StatusCancelled, WorkflowID: &workflowId},
+	}
+	dependent := model.Command{ID: uuid.New(), RouterID: routerId, Status: model.StatusCancelled, WorkflowID: &workflowId}
+
+	mockPostgres.EXPECT().CancelCommands(ctx, gomock.Any(), gomock.Any()).Return(cancelled, nil)
+	mockRedis.EXPECT().UpdateCommands(ctx, cancelled).Return(nil)
+	mockPostgres.EXPECT().
+		ResolveWorkflows(ctx, routerId, gomock.Any()).
+		Return(nil, []model.Command{dependent}, nil)
+	mockRedis.EXPECT().SaveCommand(ctx, gomock.Any()).Return(nil)
+
+	response, err := s.CancelCommand(ctx, &pb.CancelCommandRequest{
+		CommandId:   commandId.String(),
+		CancelledBy: "alice",
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{commandId.String(), dependent.ID.String()}, response.Cancelled)
+}
+
+func TestCancelCommands_ByRouterAndType(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	routerId := uuid.New()
+
+	cancelled := []model.Command{
+		{ID: uuid.New(), RouterID: routerId, Status: model.StatusCancelled},
+		{ID: uuid.New(), RouterID: routerId, Status: model.StatusCancelling},
+	}
+
+	mockPostgres.EXPECT().
+		CancelCommands(ctx, model.CancelFilter{RouterID: &routerId, CommandType: "REBOOT"}, gomock.Any()).
+		Return(cancelled, nil)
 
 	mockRedis.EXPECT().
-		FindRouterByRouterId(ctx, gomock.Eq(routerId)).
-		Return(nil, fmt.Errorf("failed to query database"))
+		UpdateCommands(ctx, cancelled).
+		Return(redis.ErrCacheUnavailable)
 
-	response, err := s.AckCommand(ctx, req)
+	response, err := s.CancelCommands(ctx, &pb.CancelCommandsRequest{
+		RouterId:    routerId.String(),
+		CommandType: "REBOOT",
+		CancelledBy: "alice",
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{cancelled[0].ID.String()}, response.Cancelled)
+	assert.Equal(t, []string{cancelled[1].ID.String()}, response.Cancelling)
+}
+
+func TestCancelCommands_EmptyFilter(t *testing.T) {
+	s, _, _, ctx := setup(t)
+
+	response, err := s.CancelCommands(ctx, &pb.CancelCommandsRequest{CancelledBy: "alice"})
 
-	require.Error(t, err)
 	require.Nil(t, response)
-	assert.Contains(t, err.Error(), "there is no router with such serial_number")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
 }
 
-func TestAckCommands_ErrorStatusChange(t *testing.T) {
+/* --- test workflows --- */
+
+func TestSubmitWorkflow_Sequential(t *testing.T) {
 	s, mockPostgres, mockRedis, ctx := setup(t)
-	expectedUuid := uuid.New()
 
-	expectedRouter := &model.Router{
-		ID:           expectedUuid,
+	mockPostgres.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+	mockRedis.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+
+	var saved *model.Workflow
+	mockPostgres.EXPECT().
+		SaveWorkflow(ctx, gomock.Any()).
+		DoAndReturn(func(_ context.Context, workflow *model.Workflow) error {
+			saved = workflow
+			return nil
+		})
+	mockRedis.EXPECT().SaveCommand(ctx, gomock.Any()).Return(nil).Times(3)
+
+	info, err := s.SubmitWorkflow(ctx, &pb.SubmitWorkflowRequest{
 		SerialNumber: "SN123",
-		IPAddress:    nil,
+		Name:         "firmware upgrade",
+		Sequential:   true,
+		Steps: []*pb.WorkflowStep{
+			{Key: "download", CommandType: "DOWNLOAD_FIRMWARE", Payload: `{"version": "2.1.0"}`},
+			{Key: "verify", CommandType: "VERIFY_CHECKSUM"},
+			{Key: "reboot", CommandType: "REBOOT"},
+		},
+	})
+
+	require.NoError(t, err)
+	require.Len(t, saved.Steps, 3)
+	assert.Equal(t, model.WorkflowRunning, info.Status)
+
+	assert.Equal(t, model.StatusPending, saved.Steps[0].Command.Status)
+	assert.Empty(t, saved.Steps[0].DependsOn)
+	assert.Equal(t, model.StatusBlocked, saved.Steps[1].Command.Status)
+	assert.Equal(t, []string{"download"}, saved.Steps[1].DependsOn)
+	assert.Equal(t, model.StatusBlocked, saved.Steps[2].Command.Status)
+	assert.Equal(t, []string{"verify"}, saved.Steps[2].DependsOn)
+
+	assert.JSONEq(t, `{"version": "2.1.0"}`, string(saved.Steps[0].Command.Payload))
+	assert.JSONEq(t, `{"command": "VERIFY_CHECKSUM"}`, string(saved.Steps[1].Command.Payload))
+
+	for _, step := range saved.Steps {
+		assert.Equal(t, saved.ID, *step.Command.WorkflowID)
+		assert.Equal(t, saved.RouterID, step.Command.RouterID)
 	}
+	assert.Equal(t, saved.ID.String(), info.Id)
+	assert.Equal(t, "verify", info.Steps[1].Key)
+}
 
-	expectedCommands := []model.Command{
-		{RouterID: expectedRouter.ID,
-			CommandType: "REBOOT",
-			Payload:     nil,
-		},
+func TestSubmitWorkflow_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		req  *pb.SubmitWorkflowRequest
+	}{
+		{"NoSerial", &pb.SubmitWorkflowRequest{
+			Steps: []*pb.WorkflowStep{{Key: "a", CommandType: "REBOOT"}},
+		}},
+		{"NoSteps", &pb.SubmitWorkflowRequest{SerialNumber: "SN123"}},
+		{"Cycle", &pb.SubmitWorkflowRequest{SerialNumber: "SN123", Steps: []*pb.WorkflowStep{
+			{Key: "a", CommandType: "REBOOT", DependsOn: []string{"b"}},
+			{Key: "b", CommandType: "REBOOT", DependsOn: []string{"a"}},
+		}}},
+		{"UnknownDependency", &pb.SubmitWorkflowRequest{SerialNumber: "SN123", Steps: []*pb.WorkflowStep{
+			{Key: "a", CommandType: "REBOOT", DependsOn: []string{"missing"}},
+		}}},
+		{"DuplicateKey", &pb.SubmitWorkflowRequest{SerialNumber: "SN123", Steps: []*pb.WorkflowStep{
+			{Key: "a", CommandType: "REBOOT"},
+			{Key: "a", CommandType: "REBOOT"},
+		}}},
+		{"DependsOnInSequential", &pb.SubmitWorkflowRequest{SerialNumber: "SN123", Sequential: true, Steps: []*pb.WorkflowStep{
+			{Key: "a", CommandType: "REBOOT"},
+			{Key: "b", CommandType: "REBOOT", DependsOn: []string{"a"}},
+		}}},
+		{"InvalidPayload", &pb.SubmitWorkflowRequest{SerialNumber: "SN123", Steps: []*pb.WorkflowStep{
+			{Key: "a", CommandType: "REBOOT", Payload: "delay=60"},
+		}}},
 	}
 
-	mockRedis.EXPECT().
-		FindRouterByRouterId(gomock.Any(), gomock.Eq(expectedUuid.String())).
-		Return(expectedRouter, nil).
-		Times(1)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, _, ctx := setup(t)
 
-	mockPostgres.EXPECT().
-		SaveRouter(gomock.Any(), gomock.AssignableToTypeOf(&model.Router{})).
-		Return(nil).
-		Times(1)
+			info, err := s.SubmitWorkflow(ctx, tt.req)
 
-	mockRedis.EXPECT().
-		SaveRouter(gomock.Any(), gomock.AssignableToTypeOf(&model.Router{})).
-		Return(nil).
-		Times(1)
+			require.Nil(t, info)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		})
+	}
+}
 
-	mockRedis.EXPECT().
-		FindCommandsByRouterId(gomock.Any(), expectedRouter.ID).
-		Return(expectedCommands, nil).
-		Times(1)
+func TestSubmitWorkflow_ApprovalRequired(t *testing.T) {
+	s, _, _, ctx := setup(t)
+	WithApprovals([]string{"FACTORY_RESET"}, time.Hour)(s)
 
-	mockRedis.EXPECT().
-		ChangeStatusByRouterId(gomock.Any(), expectedUuid, gomock.Eq("ACKED")).
-		Return(fmt.Errorf("failed to change status")).
-		Times(1)
+	info, err := s.SubmitWorkflow(ctx, &pb.SubmitWorkflowRequest{SerialNumber: "SN123", Steps: []*pb.WorkflowStep{
+		{Key: "backup", CommandType: "RUN_DIAGNOSTICS"},
+		{Key: "reset", CommandType: "FACTORY_RESET", DependsOn: []string{"backup"}},
+	}})
 
-	mockPostgres.EXPECT().
-		ChangeStatusByRouterId(gomock.Any(), expectedUuid, gomock.Eq("ACKED")).
-		Return(fmt.Errorf("failed to change status")).
-		Times(1)
+	require.Nil(t, info)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
 
-	req := &pb.AckRequest{
-		RouterId:     expectedUuid.String(),
-		SerialNumber: "SN123",
-		CommandType:  "REBOOT",
-	}
+func TestGetWorkflow(t *testing.T) {
+	s, mockPostgres, _, ctx := setup(t)
+	workflowId := uuid.New()
 
-	response, err := s.AckCommand(ctx, req)
+	mockPostgres.EXPECT().GetWorkflow(ctx, workflowId).Return(&model.Workflow{
+		ID: workflowId,
+		Steps: []model.WorkflowStep{
+			{Command: model.Command{ID: uuid.New(), WorkflowStep: "download", Status: model.StatusAcked}},
+			{Command: model.Command{ID: uuid.New(), WorkflowStep: "verify", Status: model.StatusFailed, Error: "bad checksum"}, DependsOn: []string{"download"}},
+			{Command: model.Command{ID: uuid.New(), WorkflowStep: "reboot", Status: model.StatusCancelled}, DependsOn: []string{"verify"}},
+		},
+	}, nil)
 
-	require.Error(t, err)
-	require.Nil(t, response)
+	info, err := s.GetWorkflow(ctx, &pb.GetWorkflowRequest{WorkflowId: workflowId.String()})
+
+	require.NoError(t, err)
+	assert.Equal(t, model.WorkflowFailed, info.Status)
+	require.Len(t, info.Steps, 3)
+	assert.Equal(t, "bad checksum", info.Steps[1].Command.Error)
+	assert.Equal(t, []string{"verify"}, info.Steps[2].DependsOn)
+}
+
+func TestGetWorkflow_NotFound(t *testing.T) {
+	s, mockPostgres, _, ctx := setup(t)
+	workflowId := uuid.New()
+
+	mockPostgres.EXPECT().GetWorkflow(ctx, workflowId).Return(nil, nil)
+
+	_, err := s.GetWorkflow(ctx, &pb.GetWorkflowRequest{WorkflowId: workflowId.String()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
 }
 
 /* --- test findRouter method --- */
@@ -580,7 +2356,7 @@ func TestChangeStatus(t *testing.T) {
 
 	mockPostgres.EXPECT().
 		ChangeStatusByRouterId(ctx, expectedUuid, "SENT").
-		Return(nil).Times(1)
+		Return(nil, nil).Times(1)
 
 	err := s.ChangeStatus(ctx, expectedUuid, "SENT")
 
@@ -611,10 +2387,650 @@ func TestChangeStatus_ErrorInPostgres(t *testing.T) {
 
 	mockPostgres.EXPECT().
 		ChangeStatusByRouterId(ctx, expectedUuid, gomock.Eq("SENT")).
-		Return(fmt.Errorf("wrong type of query")).Times(1)
+		Return(nil, fmt.Errorf("wrong type of query")).Times(1)
 
 	err := s.ChangeStatus(ctx, expectedUuid, "SENT")
 
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to change command status in DB:")
 }
+
+func TestChangeStatus_CacheUnavailable(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	expectedUuid := uuid.New()
+
+	mockRedis.EXPECT().
+		ChangeStatusByRouterId(ctx, expectedUuid, "SENT").
+		Return(redis.ErrCacheUnavailable).Times(1)
+
+	mockPostgres.EXPECT().
+		ChangeStatusByRouterId(ctx, expectedUuid, "SENT").
+		Return(nil, nil).Times(1)
+
+	err := s.ChangeStatus(ctx, expectedUuid, "SENT")
+
+	assert.NoError(t, err)
+}
+
+/* --- test ResyncCache method --- */
+
+func TestResyncCache(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+
+	expectedCommands := []model.Command{
+		{ID: uuid.New(), RouterID: uuid.New(), CommandType: "REBOOT", Status: "PENDING"},
+		{ID: uuid.New(), RouterID: uuid.New(), CommandType: "REBOOT", Status: "SENT"},
+	}
+
+	mockPostgres.EXPECT().
+		GetCommandsByStatus(ctx, []string{"PENDING", "SENT", "CANCELLING"}).
+		Return(expectedCommands, nil).Times(1)
+
+	// commands created while the snapshot is read are kept in the cache
+	before := time.Now()
+	mockRedis.EXPECT().
+		ResyncCommands(ctx, expectedCommands, gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ []model.Command, since time.Time) error {
+			assert.False(t, since.Before(before))
+			assert.False(t, since.After(time.Now()))
+			return nil
+		}).Times(1)
+
+	assert.NoError(t, s.ResyncCache(ctx))
+}
+
+func TestResyncCache_ErrorInRedis(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+
+	mockPostgres.EXPECT().GetCommandsByStatus(ctx, gomock.Any()).Return(nil, nil)
+	mockRedis.EXPECT().ResyncCommands(ctx, gomock.Any(), gomock.Any()).Return(redis.ErrCacheUnavailable)
+
+	// the cache stays unused until a resync succeeds
+	assert.ErrorIs(t, s.ResyncCache(ctx), redis.ErrCacheUnavailable)
+}
+
+func TestResyncCache_ErrorInPostgres(t *testing.T) {
+	s, mockPostgres, _, ctx := setup(t)
+
+	mockPostgres.EXPECT().
+		GetCommandsByStatus(ctx, gomock.Any()).
+		Return(nil, fmt.Errorf("connection refused")).Times(1)
+
+	// Redis must not be reset from an empty snapshot
+	assert.Error(t, s.ResyncCache(ctx))
+}
+
+/* --- test command signing --- */
+
+func setupSigner(t *testing.T, s *CommandService) ed25519.PublicKey {
+	public, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	s.signer = signing.NewSigner(key, time.Hour)
+	return public
+}
+
+func TestSendCommand_SignsCommands(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	public := setupSigner(t, s)
+
+	mockPostgres.EXPECT().SaveRouters(gomock.Any(), gomock.Len(2)).Return(nil)
+	mockRedis.EXPECT().SaveRouters(gomock.Any(), gomock.Len(2)).Return(nil)
+
+	var saved []model.Command
+	mockPostgres.EXPECT().
+		SaveCommandsCoalesced(gomock.Any(), gomock.Len(2), model.CoalesceDropIdentical).
+		DoAndReturn(func(ctx context.Context, cmds []model.Command, policy string) ([]*model.CoalesceResult, error) {
+			saved = cmds
+			return savedAsSent(ctx, cmds, policy)
+		})
+	mockRedis.EXPECT().SaveCommands(gomock.Any(), gomock.Len(2)).Return(nil)
+
+	_, err := s.SendCommand(ctx, &pb.SendCommandRequest{
+		Routers:     []*pb.Router{{SerialNumber: "SN1"}, {SerialNumber: "SN2"}},
+		CommandType: "REBOOT",
+	})
+	require.NoError(t, err)
+
+	require.Len(t, saved, 2)
+	for i := range saved {
+		assert.Equal(t, signing.KeyID(public), saved[i].SignatureKeyID)
+		assert.True(t, signing.Verify(public, &saved[i], time.Now()))
+	}
+}
+
+func TestPollCommands_DeliversSignature(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	public := setupSigner(t, s)
+
+	router := &model.Router{ID: uuid.New(), SerialNumber: "SN123"}
+	command := model.Command{
+		ID:          uuid.New(),
+		RouterID:    router.ID,
+		CommandType: "REBOOT",
+		Payload:     []byte(`{"command":"REBOOT"}`),
+		Status:      model.StatusPending,
+		CreatedAt:   time.Now().Add(-3 * time.Hour),
+	}
+	// the signature made at creation expired while the command waited
+	require.NoError(t, s.signer.Sign(&command, command.CreatedAt))
+
+	mockRedis.EXPECT().FindRouterByRouterId(gomock.Any(), router.ID.String()).Return(router, nil)
+	mockPostgres.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+	mockRedis.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+	cacheDown(mockRedis)
+	mockPostgres.EXPECT().GetCommandsByRouterIdAndStatus(gomock.Any(), router.ID, model.StatusPending, 0).Return([]model.Command{command}, nil)
+	mockPostgres.EXPECT().GetCommandsByRouterIdAndStatus(gomock.Any(), router.ID, model.StatusCancelling, 0).Return(nil, nil)
+	mockPostgres.EXPECT().
+		MarkCommandsSent(gomock.Any(), router.ID, gomock.Len(1)).
+		DoAndReturn(func(ctx context.Context, routerId uuid.UUID, commands []model.Command) ([]model.Command, error) {
+			// the delivery signature is stored
+			assert.WithinDuration(t, time.Now(), *commands[0].NotBefore, time.Second)
+			assert.NotEqual(t, command.Signature, commands[0].Signature)
+			return markedSent()(ctx, routerId, commands)
+		})
+	mockRedis.EXPECT().UpdateCommands(gomock.Any(), gomock.Len(1)).Return(nil)
+
+	response, err := s.PollCommands(ctx, &pb.PollRequest{RouterId: router.ID.String(), SerialNumber: "SN123"})
+	require.NoError(t, err)
+	require.Len(t, response.Commands, 1)
+
+	// what the router gets is enough to verify the command
+	delivered := response.Commands[0]
+	require.NotNil(t, delivered.Signature)
+	assert.Equal(t, signing.KeyID(public), delivered.Signature.KeyId)
+
+	notBefore, expiresAt := delivered.Signature.NotBefore.AsTime(), delivered.Signature.ExpiresAt.AsTime()
+	received := model.Command{
+		ID:          uuid.MustParse(delivered.Id),
+		RouterID:    router.ID,
+		CommandType: delivered.CommandType,
+		Payload:     []byte(delivered.Payload),
+		NotBefore:   &notBefore,
+		ExpiresAt:   &expiresAt,
+		Signature:   delivered.Signature.Signature,
+	}
+	assert.True(t, signing.Verify(public, &received, time.Now()))
+}
+
+func TestPollCommands_UnsignedCommand(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+
+	router := &model.Router{ID: uuid.New(), SerialNumber: "SN123"}
+	command := model.Command{ID: uuid.New(), RouterID: router.ID, CommandType: "REBOOT", Status: model.StatusPending}
+
+	mockRedis.EXPECT().FindRouterByRouterId(gomock.Any(), router.ID.String()).Return(router, nil)
+	mockPostgres.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+	mockRedis.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+	cacheDown(mockRedis)
+	mockPostgres.EXPECT().GetCommandsByRouterIdAndStatus(gomock.Any(), router.ID, model.StatusPending, 0).Return([]model.Command{command}, nil)
+	mockPostgres.EXPECT().GetCommandsByRouterIdAndStatus(gomock.Any(), router.ID, model.StatusCancelling, 0).Return(nil, nil)
+	mockPostgres.EXPECT().MarkCommandsSent(gomock.Any(), router.ID, gomock.Len(1)).DoAndReturn(markedSent())
+	mockRedis.EXPECT().UpdateCommands(gomock.Any(), gomock.Len(1)).Return(nil)
+
+	response, err := s.PollCommands(ctx, &pb.PollRequest{RouterId: router.ID.String(), SerialNumber: "SN123"})
+	require.NoError(t, err)
+	require.Len(t, response.Commands, 1)
+	assert.Nil(t, response.Commands[0].Signature)
+}
+
+func TestPollCommands_CancelsTamperedCommands(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	setupSigner(t, s)
+
+	router := &model.Router{ID: uuid.New(), SerialNumber: "SN123"}
+	newCommand := func(commandType string) model.Command {
+		return model.Command{ID: uuid.New(), RouterID: router.ID, CommandType: commandType,
+			Payload: []byte(`{"command":"` + commandType + `"}`), Status: model.StatusPending, CreatedAt: time.Now()}
+	}
+	// the payload was changed in the database after the command was signed
+	tampered := newCommand("UPDATE_FIRMWARE")
+	require.NoError(t, s.signer.Sign(&tampered, tampered.CreatedAt))
+	tampered.Payload = []byte(`{"url":"http://evil.example/firmware.bin"}`)
+	// inserted in the database
+	inserted := newCommand("FACTORY_RESET")
+	valid := newCommand("REBOOT")
+	require.NoError(t, s.signer.Sign(&valid, valid.CreatedAt))
+
+	mockRedis.EXPECT().FindRouterByRouterId(gomock.Any(), router.ID.String()).Return(router, nil)
+	mockPostgres.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+	mockRedis.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+	cacheDown(mockRedis)
+	mockPostgres.EXPECT().
+		GetCommandsByRouterIdAndStatus(gomock.Any(), router.ID, model.StatusPending, 0).
+		Return([]model.Command{tampered, inserted, valid}, nil)
+	mockPostgres.EXPECT().GetCommandsByRouterIdAndStatus(gomock.Any(), router.ID, model.StatusCancelling, 0).Return(nil, nil)
+
+	var cancelled []uuid.UUID
+	mockPostgres.EXPECT().
+		CancelCommands(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, filter model.CancelFilter, cancellation model.Cancellation) ([]model.Command, error) {
+			assert.Equal(t, model.SystemActor, cancellation.By)
+			assert.Equal(t, model.SignatureInvalidReason, cancellation.Reason)
+			cancelled = append(cancelled, *filter.CommandID)
+			return []model.Command{{ID: *filter.CommandID, Status: model.StatusCancelled}}, nil
+		}).Times(2)
+	mockRedis.EXPECT().UpdateCommands(gomock.Any(), gomock.Len(1)).Return(nil).Times(2)
+
+	mockPostgres.EXPECT().MarkCommandsSent(gomock.Any(), router.ID, gomock.Len(1)).DoAndReturn(markedSent())
+	mockRedis.EXPECT().UpdateCommands(gomock.Any(), gomock.Len(1)).Return(nil)
+
+	response, err := s.PollCommands(ctx, &pb.PollRequest{RouterId: router.ID.String(), SerialNumber: "SN123"})
+	require.NoError(t, err)
+	require.Len(t, response.Commands, 1)
+	assert.Equal(t, valid.ID.String(), response.Commands[0].Id)
+	assert.Equal(t, []uuid.UUID{tampered.ID, inserted.ID}, cancelled)
+}
+
+func TestPollCommands_CancelsExpiredSignatures(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	setupSigner(t, s)
+
+	router := &model.Router{ID: uuid.New(), SerialNumber: "SN123"}
+	expired := model.Command{ID: uuid.New(), RouterID: router.ID, CommandType: "REBOOT",
+		Payload: []byte(`{"command":"REBOOT"}`), Status: model.StatusPending, CreatedAt: time.Now().Add(-2 * time.Hour)}
+	require.NoError(t, s.signer.Sign(&expired, expired.CreatedAt))
+	valid := model.Command{ID: uuid.New(), RouterID: router.ID, CommandType: "RUN_DIAGNOSTICS",
+		Payload: []byte(`{"command":"RUN_DIAGNOSTICS"}`), Status: model.StatusPending, CreatedAt: time.Now()}
+	require.NoError(t, s.signer.Sign(&valid, valid.CreatedAt))
+	// signing was switched off since
+	s.signer = nil
+
+	mockRedis.EXPECT().FindRouterByRouterId(gomock.Any(), router.ID.String()).Return(router, nil)
+	mockPostgres.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+	mockRedis.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+	cacheDown(mockRedis)
+	mockPostgres.EXPECT().
+		GetCommandsByRouterIdAndStatus(gomock.Any(), router.ID, model.StatusPending, 0).
+		Return([]model.Command{expired, valid}, nil)
+	mockPostgres.EXPECT().GetCommandsByRouterIdAndStatus(gomock.Any(), router.ID, model.StatusCancelling, 0).Return(nil, nil)
+
+	cancelledCommand := expired
+	cancelledCommand.Status = model.StatusCancelled
+	mockPostgres.EXPECT().
+		CancelCommands(gomock.Any(), model.CancelFilter{CommandID: &expired.ID}, gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ model.CancelFilter, cancellation model.Cancellation) ([]model.Command, error) {
+			assert.Equal(t, model.SystemActor, cancellation.By)
+			assert.Equal(t, model.SignatureExpiredReason, cancellation.Reason)
+			return []model.Command{cancelledCommand}, nil
+		})
+	mockRedis.EXPECT().UpdateCommands(gomock.Any(), []model.Command{cancelledCommand}).Return(nil)
+
+	mockPostgres.EXPECT().MarkCommandsSent(gomock.Any(), router.ID, []model.Command{valid}).DoAndReturn(markedSent())
+	mockRedis.EXPECT().UpdateCommands(gomock.Any(), gomock.Len(1)).Return(nil)
+
+	response, err := s.PollCommands(ctx, &pb.PollRequest{RouterId: router.ID.String(), SerialNumber: "SN123"})
+	require.NoError(t, err)
+	require.Len(t, response.Commands, 1)
+	assert.Equal(t, valid.ID.String(), response.Commands[0].Id)
+}
+
+func TestGetSigningKeys(t *testing.T) {
+	s, _, _, ctx := setup(t)
+
+	response, err := s.GetSigningKeys(ctx, &pb.GetSigningKeysRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, response.Keys)
+
+	previous, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	public, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	s.signer = signing.NewSigner(key, time.Hour, previous)
+
+	response, err = s.GetSigningKeys(ctx, &pb.GetSigningKeysRequest{})
+	require.NoError(t, err)
+	require.Len(t, response.Keys, 2)
+	assert.Equal(t, signing.KeyID(public), response.Keys[0].KeyId)
+	assert.Equal(t, signing.Algorithm, response.Keys[0].Algorithm)
+	assert.Equal(t, []byte(public), response.Keys[0].PublicKey)
+	assert.True(t, response.Keys[0].Current)
+	assert.Equal(t, signing.KeyID(previous), response.Keys[1].KeyId)
+	assert.False(t, response.Keys[1].Current)
+}
+
+/* --- test payload encryption --- */
+
+func setupKeyring(t *testing.T, s *CommandService) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+
+	s.keyring, err = encryption.NewKeyring(encryption.MasterKey{ID: "k1", Key: key})
+	require.NoError(t, err)
+}
+
+// authorized returns the context a call of the principal reaches the
+// handler with.
+func authorized(t *testing.T, principal *auth.Principal) context.Context {
+	var granted context.Context
+	handler := func(ctx context.Context, req any) (any, error) {
+		granted = ctx
+		return nil, nil
+	}
+
+	// viewers may read commands, but not reveal payloads
+	store := mockspg.NewMockPostgresRepo(gomock.NewController(t))
+	store.EXPECT().ListRoles(gomock.Any()).Return([]model.Role{{Name: "viewer", Permissions: []model.Permission{
+		{Methods: []string{"CommandService.ListCommands", "CommandService.GetCommand"}},
+	}}}, nil).AnyTimes()
+
+	authorizer := auth.NewAuthorizer(store, time.Minute)
+	_, err := authorizer.UnaryInterceptor()(auth.NewContext(context.Background(), principal), &pb.ListCommandsRequest{},
+		&grpc.UnaryServerInfo{FullMethod: pb.CommandService_ListCommands_FullMethodName}, handler)
+	require.NoError(t, err)
+	return granted
+}
+
+func TestSendCommand_SealsSensitivePayloads(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	public := setupSigner(t, s)
+	setupKeyring(t, s)
+
+	mockPostgres.EXPECT().SaveRouters(gomock.Any(), gomock.Len(1)).Return(nil).Times(2)
+	mockRedis.EXPECT().SaveRouters(gomock.Any(), gomock.Len(1)).Return(nil).Times(2)
+	mockRedis.EXPECT().SaveCommands(gomock.Any(), gomock.Len(1)).Return(nil).Times(2)
+
+	var saved []model.Command
+	mockPostgres.EXPECT().
+		SaveCommandsCoalesced(gomock.Any(), gomock.Len(1), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, cmds []model.Command, policy string) ([]*model.CoalesceResult, error) {
+			saved = append(saved, cmds...)
+			return savedAsSent(ctx, cmds, policy)
+		}).Times(2)
+
+	for _, commandType := range []string{"SET_WIFI_PASSWORD", "REBOOT"} {
+		_, err := s.SendCommand(ctx, &pb.SendCommandRequest{
+			Routers:     []*pb.Router{{SerialNumber: "SN1"}},
+			CommandType: commandType,
+		})
+		require.NoError(t, err)
+	}
+
+	require.Len(t, saved, 2)
+	assert.True(t, encryption.IsSealed(saved[0].Payload))
+	assert.False(t, encryption.IsSealed(saved[1].Payload))
+
+	// the signature is over the payload the router gets
+	require.NoError(t, s.keyring.Open(&saved[0]))
+	assert.True(t, signing.Verify(public, &saved[0], time.Now()))
+}
+
+func TestSendCommand_SealsSensitivePayloadOfJob(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	setupKeyring(t, s)
+	WithSendBatching(2, 1, 0)(s)
+
+	var job *model.Job
+	mockPostgres.EXPECT().
+		SaveJob(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, j *model.Job) error {
+			job = j
+			return nil
+		})
+
+	_, err := s.SendCommand(ctx, &pb.SendCommandRequest{
+		Routers:     []*pb.Router{{SerialNumber: "SN1"}, {SerialNumber: "SN2"}},
+		CommandType: "SET_WIFI_PASSWORD",
+		Payload:     `{"password": "hunter2"}`,
+	})
+	require.NoError(t, err)
+	assert.NotContains(t, string(job.Request), "hunter2")
+
+	// the job sends the commands with the payload of the request
+	mockPostgres.EXPECT().SaveRouters(gomock.Any(), gomock.Len(2)).Return(nil)
+	mockRedis.EXPECT().SaveRouters(gomock.Any(), gomock.Len(2)).Return(nil)
+	mockPostgres.EXPECT().
+		SaveCommandsCoalesced(gomock.Any(), gomock.Len(2), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, cmds []model.Command, policy string) ([]*model.CoalesceResult, error) {
+			for _, cmd := range cmds {
+				require.NoError(t, s.keyring.Open(&cmd))
+				assert.JSONEq(t, `{"password": "hunter2"}`, string(cmd.Payload))
+			}
+			return savedAsSent(ctx, cmds, policy)
+		})
+	mockRedis.EXPECT().SaveCommands(gomock.Any(), gomock.Len(2)).Return(nil)
+
+	require.NoError(t, s.RunSendJob(ctx, job, func() error { return nil }))
+}
+
+func TestPollCommands_OpensSealedPayloads(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	setupKeyring(t, s)
+
+	router := &model.Router{ID: uuid.New(), SerialNumber: "SN123"}
+	command := model.Command{
+		ID:          uuid.New(),
+		RouterID:    router.ID,
+		CommandType: "SET_WIFI_PASSWORD",
+		Payload:     []byte(`{"command":"SET_WIFI_PASSWORD"}`),
+		Status:      model.StatusPending,
+	}
+	require.NoError(t, s.keyring.Seal(&command))
+
+	mockRedis.EXPECT().FindRouterByRouterId(gomock.Any(), router.ID.String()).Return(router, nil)
+	mockPostgres.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+	mockRedis.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+	cacheDown(mockRedis)
+	mockPostgres.EXPECT().GetCommandsByRouterIdAndStatus(gomock.Any(), router.ID, model.StatusPending, 0).Return([]model.Command{command}, nil)
+	mockPostgres.EXPECT().GetCommandsByRouterIdAndStatus(gomock.Any(), router.ID, model.StatusCancelling, 0).Return(nil, nil)
+	mockPostgres.EXPECT().MarkCommandsSent(gomock.Any(), router.ID, gomock.Len(1)).DoAndReturn(markedSent())
+	mockRedis.EXPECT().UpdateCommands(gomock.Any(), gomock.Len(1)).Return(nil)
+
+	response, err := s.PollCommands(ctx, &pb.PollRequest{RouterId: router.ID.String(), SerialNumber: "SN123"})
+	require.NoError(t, err)
+	require.Len(t, response.Commands, 1)
+	assert.Equal(t, `{"command":"SET_WIFI_PASSWORD"}`, response.Commands[0].Payload)
+}
+
+func TestPollCommands_UnknownPayloadKey(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	setupKeyring(t, s)
+
+	router := &model.Router{ID: uuid.New(), SerialNumber: "SN123"}
+	command := model.Command{ID: uuid.New(), RouterID: router.ID, CommandType: "SET_WIFI_PASSWORD",
+		Payload: []byte(`{"command":"SET_WIFI_PASSWORD"}`), Status: model.StatusPending}
+	require.NoError(t, s.keyring.Seal(&command))
+	// the key was dropped from the keyring
+	setupKeyring(t, s)
+
+	mockRedis.EXPECT().FindRouterByRouterId(gomock.Any(), router.ID.String()).Return(router, nil)
+	mockPostgres.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+	mockRedis.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+	cacheDown(mockRedis)
+	mockPostgres.EXPECT().GetCommandsByRouterIdAndStatus(gomock.Any(), router.ID, model.StatusPending, 0).Return([]model.Command{command}, nil)
+	mockPostgres.EXPECT().GetCommandsByRouterIdAndStatus(gomock.Any(), router.ID, model.StatusCancelling, 0).Return(nil, nil)
+
+	// nothing is marked SENT
+	_, err := s.PollCommands(ctx, &pb.PollRequest{RouterId: router.ID.String(), SerialNumber: "SN123"})
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestGetCommand_RedactsSensitivePayloads(t *testing.T) {
+	s, mockPostgres, mockRedis, _ := setup(t)
+	setupKeyring(t, s)
+
+	router := &model.Router{ID: uuid.New(), SerialNumber: "SN123"}
+	sealed := model.Command{ID: uuid.New(), RouterID: router.ID, CommandType: "SET_WIFI_PASSWORD",
+		Payload: []byte(`{"command":"SET_WIFI_PASSWORD"}`), Status: model.StatusPending}
+	require.NoError(t, s.keyring.Seal(&sealed))
+
+	mockRedis.EXPECT().FindRouterByRouterId(gomock.Any(), router.ID.String()).Return(router, nil).AnyTimes()
+	mockPostgres.EXPECT().GetCommandById(gomock.Any(), sealed.ID).
+		DoAndReturn(func(context.Context, uuid.UUID) (*model.Command, error) {
+			command := sealed
+			return &command, nil
+		}).Times(3)
+
+	// unauthenticated callers and callers without the permission get no payload
+	for _, ctx := range []context.Context{
+		context.Background(),
+		authorized(t, &auth.Principal{Subject: "bob", Roles: []string{"viewer"}}),
+	} {
+		response, err := s.GetCommand(ctx, &pb.GetCommandRequest{CommandId: sealed.ID.String()})
+		require.NoError(t, err)
+		assert.Empty(t, response.Payload)
+		assert.True(t, response.PayloadRedacted)
+	}
+
+	response, err := s.GetCommand(authorized(t, &auth.Principal{Subject: "alice", Roles: []string{auth.RoleAdmin}}),
+		&pb.GetCommandRequest{CommandId: sealed.ID.String()})
+	require.NoError(t, err)
+	assert.Equal(t, `{"command":"SET_WIFI_PASSWORD"}`, response.Payload)
+	assert.False(t, response.PayloadRedacted)
+}
+
+/* --- test metrics --- */
+
+// histogram returns the sample count and sum of the histogram name for the
+// command type, as gathered from the registry.
+func histogram(t *testing.T, name, commandType string) (uint64, float64) {
+	families, err := metrics.Registry.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "command_type" && label.GetValue() == commandType {
+					return metric.GetHistogram().GetSampleCount(), metric.GetHistogram().GetSampleSum()
+				}
+			}
+		}
+	}
+	return 0, 0
+}
+
+func TestSendCommand_CountsCreatedCommands(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	created := metrics.CommandsCreated.WithLabelValues("METRICS_SEND")
+	before := testutil.ToFloat64(created)
+	calls := testutil.ToFloat64(metrics.SendCommandCalls.WithLabelValues("OK"))
+
+	mockPostgres.EXPECT().SaveRouters(gomock.Any(), gomock.Len(2)).Return(nil)
+	mockRedis.EXPECT().SaveRouters(gomock.Any(), gomock.Len(2)).Return(nil)
+	// the second router already has an identical command
+	mockPostgres.EXPECT().
+		SaveCommandsCoalesced(gomock.Any(), gomock.Len(2), gomock.Any()).
+		DoAndReturn(func(_ context.Context, cmds []model.Command, _ string) ([]*model.CoalesceResult, error) {
+			existing := uuid.New()
+			return []*model.CoalesceResult{{}, {ExistingID: &existing}}, nil
+		})
+	mockRedis.EXPECT().SaveCommands(gomock.Any(), gomock.Len(1)).Return(nil)
+
+	_, err := s.SendCommand(ctx, &pb.SendCommandRequest{
+		Routers:     []*pb.Router{{SerialNumber: "SN1"}, {SerialNumber: "SN2"}},
+		CommandType: "METRICS_SEND",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, before+1, testutil.ToFloat64(created))
+	assert.Equal(t, calls+1, testutil.ToFloat64(metrics.SendCommandCalls.WithLabelValues("OK")))
+}
+
+func TestPollCommands_Metrics(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	routerId := uuid.New()
+	created := time.Now().Add(-time.Minute)
+	command := model.Command{ID: uuid.New(), RouterID: routerId, CommandType: "METRICS_POLL",
+		Status: model.StatusPending, CreatedAt: created}
+	sentAt := created.Add(30 * time.Second)
+	sent := command
+	sent.Status, sent.SentAt = model.StatusSent, &sentAt
+
+	hits := testutil.ToFloat64(metrics.CacheLookups.WithLabelValues("router", metrics.CacheHit))
+	calls := testutil.ToFloat64(metrics.CommadsPollCalls.WithLabelValues("OK"))
+
+	mockRedis.EXPECT().
+		FindRouterByRouterId(gomock.Any(), routerId.String()).
+		Return(&model.Router{ID: routerId, SerialNumber: "SN123"}, nil)
+	mockPostgres.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil)
+	mockRedis.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(redis.ErrCacheUnavailable)
+
+	cacheDown(mockRedis)
+	mockPostgres.EXPECT().
+		GetCommandsByRouterIdAndStatus(gomock.Any(), routerId, model.StatusPending, 0).
+		Return([]model.Command{command}, nil)
+	mockPostgres.EXPECT().
+		GetCommandsByRouterIdAndStatus(gomock.Any(), routerId, model.StatusCancelling, 0).
+		Return(nil, nil)
+	mockPostgres.EXPECT().
+		MarkCommandsSent(gomock.Any(), routerId, []model.Command{command}).
+		Return([]model.Command{sent}, nil)
+	// the cache is down, the poll doesn't fail
+	mockRedis.EXPECT().UpdateCommands(gomock.Any(), []model.Command{sent}).Return(redis.ErrCacheUnavailable)
+
+	_, err := s.PollCommands(ctx, &pb.PollRequest{RouterId: routerId.String(), SerialNumber: "SN123"})
+	require.NoError(t, err)
+
+	assert.Equal(t, hits+1, testutil.ToFloat64(metrics.CacheLookups.WithLabelValues("router", metrics.CacheHit)))
+	assert.Equal(t, calls+1, testutil.ToFloat64(metrics.CommadsPollCalls.WithLabelValues("OK")))
+
+	count, sum := histogram(t, "command_service_command_time_to_sent_seconds", "METRICS_POLL")
+	assert.Equal(t, uint64(1), count)
+	assert.InDelta(t, 30, sum, 0.001)
+}
+
+func TestAckCommand_Metrics(t *testing.T) {
+	s, mockPostgres, mockRedis, ctx := setup(t)
+	routerId := uuid.New()
+	commandId := uuid.New()
+	created := time.Now().Add(-time.Hour)
+	ackedAt := created.Add(2 * time.Minute)
+
+	invalid := testutil.ToFloat64(metrics.CommandsAckCalls.WithLabelValues("InvalidArgument"))
+
+	mockRedis.EXPECT().
+		FindRouterByRouterId(gomock.Any(), routerId.String()).
+		Return(&model.Router{ID: routerId, SerialNumber: "SN123"}, nil).Times(2)
+	mockPostgres.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+	mockRedis.EXPECT().SaveRouter(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+
+	_, err := s.AckCommand(ctx, &pb.AckRequest{RouterId: routerId.String(), SerialNumber: "SN123",
+		CommandType: "METRICS_ACK", CommandId: "not-a-uuid"})
+	require.Error(t, err)
+	assert.Equal(t, invalid+1, testutil.ToFloat64(metrics.CommandsAckCalls.WithLabelValues("InvalidArgument")))
+
+	mockPostgres.EXPECT().
+		ChangeStatusByIds(gomock.Any(), routerId, []uuid.UUID{commandId}, model.StatusAcked).
+		Return([]model.Command{{ID: commandId, RouterID: routerId, CommandType: "METRICS_ACK",
+			Status: model.StatusAcked, CreatedAt: created, AckedAt: &ackedAt}}, nil)
+	mockRedis.EXPECT().UpdateCommands(gomock.Any(), gomock.Len(1)).Return(nil)
+	mockPostgres.EXPECT().ResolveWorkflows(gomock.Any(), routerId, gomock.Any()).Return(nil, nil, nil)
+
+	_, err = s.AckCommand(ctx, &pb.AckRequest{RouterId: routerId.String(), SerialNumber: "SN123",
+		CommandType: "METRICS_ACK", CommandId: commandId.String()})
+	require.NoError(t, err)
+
+	count, sum := histogram(t, "command_service_command_time_to_ack_seconds", "METRICS_ACK")
+	assert.Equal(t, uint64(1), count)
+	assert.InDelta(t, 120, sum, 0.001)
+}
+
+func TestRefreshGauges(t *testing.T) {
+	s, mockPostgres, _, ctx := setup(t)
+
+	mockPostgres.EXPECT().
+		CountCommandsByStatus(gomock.Any()).
+		Return(map[string]int64{model.StatusPending: 7, model.StatusAcked: 120}, nil)
+	mockPostgres.EXPECT().
+		CountRouters(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, seenSince time.Time) (int64, int64, error) {
+			assert.WithinDuration(t, time.Now().Add(-2*time.Minute), seenSince, time.Second)
+			return 40, 2, nil
+		})
+
+	s.RefreshGauges(ctx)
+
+	assert.Equal(t, float64(7), testutil.ToFloat64(metrics.Commands.WithLabelValues(model.StatusPending)))
+	assert.Equal(t, float64(120), testutil.ToFloat64(metrics.Commands.WithLabelValues(model.StatusAcked)))
+	// statuses without commands are reported too
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.Commands.WithLabelValues(model.StatusFailed)))
+	assert.Equal(t, float64(40), testutil.ToFloat64(metrics.Routers.WithLabelValues(metrics.RoutersOnline)))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.Routers.WithLabelValues(metrics.RoutersOffline)))
+
+	count, err := testutil.GatherAndCount(metrics.Registry, "command_service_commands", "command_service_routers")
+	require.NoError(t, err)
+	assert.Equal(t, 8+2, count)
+}
@@ -0,0 +1,323 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"router-manager/internal/logging"
+	"router-manager/internal/model"
+	"router-manager/internal/pb"
+	"router-manager/internal/repository/postgres"
+	"router-manager/internal/tracing"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// JobHandler runs a job from job.Processed on. After every chunk of work it
+// updates job.Processed and calls progress, which stores it and returns
+// model.ErrJobCancelled once the job is cancelled; the handler returns that
+// error as is. A handler must stop when ctx is done.
+type JobHandler func(ctx context.Context, job *model.Job, progress func() error) error
+
+// JobService reports background jobs and runs them in a pool of workers.
+// Workers of all instances take jobs from PostgreSQL under a lease, so a job
+// whose instance died is resumed by another one once its lease expires.
+type JobService struct {
+	pb.UnimplementedJobServiceServer
+
+	postgresRepo postgres.PostgresRepo
+	handlers     map[string]JobHandler
+
+	// identifies the workers of this instance in job leases
+	owner        string
+	workers      int
+	lease        time.Duration
+	pollInterval time.Duration
+
+	// workers running now
+	running atomic.Int32
+}
+
+// JobOption configures optional JobService settings.
+type JobOption func(s *JobService)
+
+// WithJobWorkers sets the number of workers, how long a job stays leased to
+// its worker without progress and how often idle workers look for jobs.
+func WithJobWorkers(workers int, lease, pollInterval time.Duration) JobOption {
+	return func(s *JobService) {
+		s.workers = workers
+		s.lease = lease
+		s.pollInterval = pollInterval
+	}
+}
+
+func NewJobService(pgRepo postgres.PostgresRepo, opts ...JobOption) *JobService {
+	hostname, _ := os.Hostname()
+	s := &JobService{
+		postgresRepo: pgRepo,
+		handlers:     make(map[string]JobHandler),
+
+		owner:        fmt.Sprintf("%s/%d/%s", hostname, os.Getpid(), uuid.NewString()[:8]),
+		workers:      4,
+		lease:        time.Minute,
+		pollInterval: time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Handle registers the handler of a job kind; it must be called before Run.
+func (s *JobService) Handle(kind string, handler JobHandler) {
+	s.handlers[kind] = handler
+}
+
+func (s *JobService) GetJob(ctx context.Context, req *pb.GetJobRequest) (*pb.JobInfo, error) {
+	jobId, err := uuid.Parse(req.JobId)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid job_id: %v", err)
+	}
+
+	job, err := s.postgresRepo.GetJob(ctx, jobId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load job: %v", err)
+	}
+	if job == nil {
+		return nil, status.Errorf(codes.NotFound, "job %s not found", jobId)
+	}
+
+	return toJobInfo(job), nil
+}
+
+func (s *JobService) ListJobs(ctx context.Context, req *pb.ListJobsRequest) (*pb.ListJobsResponse, error) {
+	filter := model.JobFilter{
+		Kind:     req.Kind,
+		Statuses: req.Status,
+	}
+
+	pageSize := int(req.PageSize)
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	var after *model.Cursor
+	if req.PageToken != "" {
+		cursor, err := decodePageToken(req.PageToken)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid page_token: %v", err)
+		}
+		after = cursor
+	}
+
+	// one extra row tells whether there is a next page
+	jobs, err := s.postgresRepo.ListJobs(ctx, filter, after, pageSize+1)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list jobs: %v", err)
+	}
+
+	response := &pb.ListJobsResponse{}
+	if len(jobs) > pageSize {
+		jobs = jobs[:pageSize]
+		last := jobs[pageSize-1]
+		response.NextPageToken = encodePageToken(model.Cursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+
+	for i := range jobs {
+		response.Jobs = append(response.Jobs, toJobInfo(&jobs[i]))
+	}
+
+	return response, nil
+}
+
+func (s *JobService) CancelJob(ctx context.Context, req *pb.CancelJobRequest) (*pb.JobInfo, error) {
+	jobId, err := uuid.Parse(req.JobId)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid job_id: %v", err)
+	}
+
+	job, err := s.postgresRepo.CancelJob(ctx, jobId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to cancel job: %v", err)
+	}
+	if job == nil {
+		existing, err := s.postgresRepo.GetJob(ctx, jobId)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to load job: %v", err)
+		}
+		if existing == nil {
+			return nil, status.Errorf(codes.NotFound, "job %s not found", jobId)
+		}
+		return nil, status.Errorf(codes.FailedPrecondition, "job %s is %s", jobId, existing.Status)
+	}
+
+	slog.InfoContext(ctx, "Job cancelled", "job_id", jobId, "status", job.Status)
+
+	return toJobInfo(job), nil
+}
+
+// Run runs the workers until ctx is done. Jobs interrupted by the shutdown
+// are released, so that the next instance resumes them right away.
+func (s *JobService) Run(ctx context.Context) {
+	slog.InfoContext(ctx, "Starting job workers", "workers", s.workers, "owner", s.owner)
+
+	var wg sync.WaitGroup
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.running.Add(1)
+			defer s.running.Add(-1)
+			s.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// Running returns how many of the workers are running, none before Run and
+// after it returns.
+func (s *JobService) Running() (running, workers int) {
+	return int(s.running.Load()), s.workers
+}
+
+func (s *JobService) work(ctx context.Context) {
+	for ctx.Err() == nil {
+		if s.runNext(ctx) {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(s.pollInterval):
+		}
+	}
+}
+
+// runNext claims a job and runs it. It reports false if there was none.
+func (s *JobService) runNext(ctx context.Context) bool {
+	job, err := s.postgresRepo.ClaimJob(ctx, s.owner, s.lease)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.ErrorContext(ctx, "Failed to claim job", "error", err)
+		}
+		return false
+	}
+	if job == nil {
+		return false
+	}
+
+	s.execute(ctx, job)
+	return true
+}
+
+func (s *JobService) execute(ctx context.Context, job *model.Job) {
+	ctx = logging.With(ctx, "job_id", job.ID, "job_kind", job.Kind)
+	ctx, span := tracing.Tracer().Start(ctx, "job "+job.Kind, trace.WithNewRoot(),
+		trace.WithAttributes(attribute.String("job.id", job.ID.String()), attribute.String("job.kind", job.Kind)))
+	defer span.End()
+
+	if job.Processed > 0 {
+		slog.InfoContext(ctx, "Job resumed", "processed", job.Processed, "total", job.Total)
+	} else {
+		slog.InfoContext(ctx, "Job started")
+	}
+
+	var err error
+	handler, ok := s.handlers[job.Kind]
+	switch {
+	case job.Status == model.JobCancelling:
+		// cancelled while its previous worker was gone
+		err = model.ErrJobCancelled
+	case !ok:
+		err = fmt.Errorf("unknown job kind %s", job.Kind)
+	default:
+		err = handler(ctx, job, func() error {
+			return s.progress(ctx, job)
+		})
+	}
+
+	if errors.Is(err, model.ErrJobLeaseLost) {
+		slog.WarnContext(ctx, "Job was taken over by another worker")
+		return
+	}
+
+	if ctx.Err() != nil {
+		if err := s.postgresRepo.ReleaseJob(context.WithoutCancel(ctx), job); err != nil {
+			slog.WarnContext(ctx, "Failed to release job", "error", err)
+			return
+		}
+		slog.InfoContext(ctx, "Job released", "processed", job.Processed, "total", job.Total)
+		return
+	}
+
+	if err != nil && !errors.Is(err, model.ErrJobCancelled) {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	job.Finish(err, time.Now())
+	if err := s.postgresRepo.FinishJob(ctx, job); err != nil {
+		slog.ErrorContext(ctx, "Failed to store job result", "error", err)
+		return
+	}
+
+	if job.Status == model.JobFailed {
+		slog.ErrorContext(ctx, "Job failed", "processed", job.Processed, "total", job.Total, "error", job.Error)
+	} else {
+		slog.InfoContext(ctx, "Job finished", "status", job.Status, "processed", job.Processed, "total", job.Total)
+	}
+}
+
+// progress stores the progress of the job and renews its lease.
+func (s *JobService) progress(ctx context.Context, job *model.Job) error {
+	current, err := s.postgresRepo.SaveJobProgress(ctx, job, s.lease)
+	if errors.Is(err, model.ErrJobLeaseLost) {
+		return err
+	}
+	if err != nil {
+		// the job goes on; at worst the lease expires and the work since
+		// the last stored progress is done again
+		slog.WarnContext(ctx, "Failed to store job progress", "job_id", job.ID, "error", err)
+		return nil
+	}
+
+	if current == model.JobCancelling {
+		return model.ErrJobCancelled
+	}
+	return nil
+}
+
+func toJobInfo(job *model.Job) *pb.JobInfo {
+	info := &pb.JobInfo{
+		Id:        job.ID.String(),
+		Kind:      job.Kind,
+		Status:    job.Status,
+		Total:     uint32(job.Total),
+		Processed: uint32(job.Processed),
+		Error:     job.Error,
+		CreatedBy: job.CreatedBy,
+		CreatedAt: timestamppb.New(job.CreatedAt),
+		UpdatedAt: timestamppb.New(job.UpdatedAt),
+	}
+
+	if job.FinishedAt != nil {
+		info.FinishedAt = timestamppb.New(*job.FinishedAt)
+	}
+
+	return info
+}
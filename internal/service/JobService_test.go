@@ -0,0 +1,306 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"router-manager/internal/model"
+	"router-manager/internal/pb"
+	mockspg "router-manager/internal/repository/postgres/mocks"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func setupJobs(t *testing.T) (*JobService, *mockspg.MockPostgresRepo, context.Context) {
+	ctx := context.Background()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockPostgres := mockspg.NewMockPostgresRepo(ctrl)
+
+	s := NewJobService(mockPostgres, WithJobWorkers(1, time.Minute, time.Millisecond))
+
+	return s, mockPostgres, ctx
+}
+
+func runningJob(kind string) *model.Job {
+	now := time.Now()
+	return &model.Job{ID: uuid.New(), Kind: kind, Status: model.JobRunning, Total: 2, CreatedAt: now, UpdatedAt: now}
+}
+
+/* --- test GetJob, ListJobs and CancelJob methods --- */
+
+func TestGetJob(t *testing.T) {
+	s, mockPostgres, ctx := setupJobs(t)
+
+	job := runningJob(model.JobSendCommand)
+	job.Processed = 1
+	mockPostgres.EXPECT().GetJob(gomock.Any(), job.ID).Return(job, nil)
+
+	info, err := s.GetJob(ctx, &pb.GetJobRequest{JobId: job.ID.String()})
+
+	require.NoError(t, err)
+	assert.Equal(t, job.ID.String(), info.Id)
+	assert.Equal(t, model.JobRunning, info.Status)
+	assert.Equal(t, uint32(1), info.Processed)
+	assert.Nil(t, info.FinishedAt)
+}
+
+func TestGetJob_NotFound(t *testing.T) {
+	s, mockPostgres, ctx := setupJobs(t)
+
+	mockPostgres.EXPECT().GetJob(gomock.Any(), gomock.Any()).Return(nil, nil)
+
+	_, err := s.GetJob(ctx, &pb.GetJobRequest{JobId: uuid.NewString()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = s.GetJob(ctx, &pb.GetJobRequest{JobId: "bad"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestListJobs_Pages(t *testing.T) {
+	s, mockPostgres, ctx := setupJobs(t)
+
+	jobs := []model.Job{*runningJob(model.JobSendCommand), *runningJob(model.JobSendCommand), *runningJob(model.JobSendCommand)}
+	filter := model.JobFilter{Kind: model.JobSendCommand, Statuses: []string{model.JobRunning}}
+	mockPostgres.EXPECT().ListJobs(gomock.Any(), filter, nil, 3).Return(jobs, nil)
+
+	response, err := s.ListJobs(ctx, &pb.ListJobsRequest{Kind: model.JobSendCommand, Status: []string{model.JobRunning}, PageSize: 2})
+
+	require.NoError(t, err)
+	require.Len(t, response.Jobs, 2)
+	require.NotEmpty(t, response.NextPageToken)
+
+	cursor := &model.Cursor{CreatedAt: jobs[1].CreatedAt, ID: jobs[1].ID}
+	mockPostgres.EXPECT().ListJobs(gomock.Any(), model.JobFilter{}, gomock.Any(), defaultPageSize+1).
+		DoAndReturn(func(_ context.Context, _ model.JobFilter, after *model.Cursor, _ int) ([]model.Job, error) {
+			assert.Equal(t, cursor.ID, after.ID)
+			assert.True(t, cursor.CreatedAt.Equal(after.CreatedAt))
+			return jobs[2:], nil
+		})
+
+	response, err = s.ListJobs(ctx, &pb.ListJobsRequest{PageToken: response.NextPageToken})
+
+	require.NoError(t, err)
+	require.Len(t, response.Jobs, 1)
+	assert.Empty(t, response.NextPageToken)
+}
+
+func TestCancelJob(t *testing.T) {
+	s, mockPostgres, ctx := setupJobs(t)
+
+	job := runningJob(model.JobSendCommand)
+	job.Status = model.JobCancelling
+	mockPostgres.EXPECT().CancelJob(gomock.Any(), job.ID).Return(job, nil)
+
+	info, err := s.CancelJob(ctx, &pb.CancelJobRequest{JobId: job.ID.String()})
+
+	require.NoError(t, err)
+	assert.Equal(t, model.JobCancelling, info.Status)
+}
+
+func TestCancelJob_Finished(t *testing.T) {
+	s, mockPostgres, ctx := setupJobs(t)
+
+	job := runningJob(model.JobSendCommand)
+	job.Status = model.JobSucceeded
+	mockPostgres.EXPECT().CancelJob(gomock.Any(), job.ID).Return(nil, nil)
+	mockPostgres.EXPECT().GetJob(gomock.Any(), job.ID).Return(job, nil)
+
+	_, err := s.CancelJob(ctx, &pb.CancelJobRequest{JobId: job.ID.String()})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	mockPostgres.EXPECT().CancelJob(gomock.Any(), gomock.Any()).Return(nil, nil)
+	mockPostgres.EXPECT().GetJob(gomock.Any(), gomock.Any()).Return(nil, nil)
+
+	_, err = s.CancelJob(ctx, &pb.CancelJobRequest{JobId: uuid.NewString()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+/* --- test the workers --- */
+
+func TestExecute_Succeeds(t *testing.T) {
+	s, mockPostgres, ctx := setupJobs(t)
+
+	job := runningJob("TEST")
+	s.Handle("TEST", func(ctx context.Context, job *model.Job, progress func() error) error {
+		for job.Processed < job.Total {
+			job.Processed++
+			if err := progress(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	mockPostgres.EXPECT().SaveJobProgress(gomock.Any(), job, time.Minute).Return(model.JobRunning, nil).Times(2)
+	mockPostgres.EXPECT().
+		FinishJob(gomock.Any(), job).
+		DoAndReturn(func(_ context.Context, job *model.Job) error {
+			assert.Equal(t, model.JobSucceeded, job.Status)
+			assert.Equal(t, 2, job.Processed)
+			assert.NotNil(t, job.FinishedAt)
+			return nil
+		})
+
+	s.execute(ctx, job)
+}
+
+func TestExecute_Fails(t *testing.T) {
+	s, mockPostgres, ctx := setupJobs(t)
+
+	job := runningJob("TEST")
+	s.Handle("TEST", func(context.Context, *model.Job, func() error) error {
+		return fmt.Errorf("connection reset")
+	})
+
+	mockPostgres.EXPECT().
+		FinishJob(gomock.Any(), job).
+		DoAndReturn(func(_ context.Context, job *model.Job) error {
+			assert.Equal(t, model.JobFailed, job.Status)
+			assert.Contains(t, job.Error, "connection reset")
+			return nil
+		})
+
+	s.execute(ctx, job)
+}
+
+func TestExecute_UnknownKind(t *testing.T) {
+	s, mockPostgres, ctx := setupJobs(t)
+
+	job := runningJob("UNKNOWN")
+	mockPostgres.EXPECT().
+		FinishJob(gomock.Any(), job).
+		DoAndReturn(func(_ context.Context, job *model.Job) error {
+			assert.Equal(t, model.JobFailed, job.Status)
+			assert.Contains(t, job.Error, "UNKNOWN")
+			return nil
+		})
+
+	s.execute(ctx, job)
+}
+
+func TestExecute_CancelledWhileRunning(t *testing.T) {
+	s, mockPostgres, ctx := setupJobs(t)
+
+	job := runningJob("TEST")
+	s.Handle("TEST", func(ctx context.Context, job *model.Job, progress func() error) error {
+		job.Processed++
+		return progress()
+	})
+
+	mockPostgres.EXPECT().SaveJobProgress(gomock.Any(), job, time.Minute).Return(model.JobCancelling, nil)
+	mockPostgres.EXPECT().
+		FinishJob(gomock.Any(), job).
+		DoAndReturn(func(_ context.Context, job *model.Job) error {
+			assert.Equal(t, model.JobCancelled, job.Status)
+			assert.Equal(t, 1, job.Processed)
+			assert.Empty(t, job.Error)
+			return nil
+		})
+
+	s.execute(ctx, job)
+}
+
+func TestExecute_ClaimedWhileCancelling(t *testing.T) {
+	s, mockPostgres, ctx := setupJobs(t)
+
+	job := runningJob("TEST")
+	job.Status = model.JobCancelling
+	s.Handle("TEST", func(context.Context, *model.Job, func() error) error {
+		t.Fatal("cancelled job was run")
+		return nil
+	})
+
+	mockPostgres.EXPECT().
+		FinishJob(gomock.Any(), job).
+		DoAndReturn(func(_ context.Context, job *model.Job) error {
+			assert.Equal(t, model.JobCancelled, job.Status)
+			return nil
+		})
+
+	s.execute(ctx, job)
+}
+
+func TestExecute_ReleasedOnShutdown(t *testing.T) {
+	s, mockPostgres, ctx := setupJobs(t)
+
+	ctx, cancel := context.WithCancel(ctx)
+	job := runningJob("TEST")
+	s.Handle("TEST", func(ctx context.Context, job *model.Job, progress func() error) error {
+		job.Processed++
+		cancel()
+		return ctx.Err()
+	})
+
+	// the job keeps its status for the next worker
+	mockPostgres.EXPECT().
+		ReleaseJob(gomock.Any(), job).
+		DoAndReturn(func(ctx context.Context, job *model.Job) error {
+			assert.NoError(t, ctx.Err())
+			assert.Equal(t, model.JobRunning, job.Status)
+			assert.Equal(t, 1, job.Processed)
+			return nil
+		})
+
+	s.execute(ctx, job)
+}
+
+func TestExecute_LeaseLost(t *testing.T) {
+	s, mockPostgres, ctx := setupJobs(t)
+
+	job := runningJob("TEST")
+	s.Handle("TEST", func(ctx context.Context, job *model.Job, progress func() error) error {
+		job.Processed++
+		return progress()
+	})
+
+	// the job is left to its new owner
+	mockPostgres.EXPECT().SaveJobProgress(gomock.Any(), job, time.Minute).Return("", model.ErrJobLeaseLost)
+
+	s.execute(ctx, job)
+}
+
+func TestRun_ClaimsJobsUntilShutdown(t *testing.T) {
+	s, mockPostgres, ctx := setupJobs(t)
+
+	ctx, cancel := context.WithCancel(ctx)
+	job := runningJob("TEST")
+	s.Handle("TEST", func(context.Context, *model.Job, func() error) error {
+		running, workers := s.Running()
+		assert.Equal(t, 1, running)
+		assert.Equal(t, 1, workers)
+		return nil
+	})
+
+	mockPostgres.EXPECT().ClaimJob(gomock.Any(), s.owner, time.Minute).Return(job, nil)
+	mockPostgres.EXPECT().FinishJob(gomock.Any(), job).Return(nil)
+	mockPostgres.EXPECT().
+		ClaimJob(gomock.Any(), s.owner, time.Minute).
+		DoAndReturn(func(context.Context, string, time.Duration) (*model.Job, error) {
+			cancel()
+			return nil, nil
+		})
+
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("workers didn't stop")
+	}
+	assert.Equal(t, model.JobSucceeded, job.Status)
+	running, _ := s.Running()
+	assert.Equal(t, 0, running)
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"router-manager/internal/auth"
+	"router-manager/internal/model"
+	"router-manager/internal/pb"
+	"router-manager/internal/repository/postgres"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// RoleService lets admins manage the roles calls are authorized with.
+type RoleService struct {
+	pb.UnimplementedRoleServiceServer
+
+	postgresRepo postgres.PostgresRepo
+}
+
+func NewRoleService(pgRepo postgres.PostgresRepo) *RoleService {
+	return &RoleService{
+		postgresRepo: pgRepo,
+	}
+}
+
+func (s *RoleService) ListRoles(ctx context.Context, req *pb.ListRolesRequest) (*pb.ListRolesResponse, error) {
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	roles, err := s.postgresRepo.ListRoles(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list roles: %v", err)
+	}
+
+	response := &pb.ListRolesResponse{}
+	for i := range roles {
+		response.Roles = append(response.Roles, toRoleInfo(&roles[i]))
+	}
+
+	return response, nil
+}
+
+// PutRole creates the role or replaces its permissions.
+func (s *RoleService) PutRole(ctx context.Context, req *pb.PutRoleRequest) (*pb.Role, error) {
+	admin, err := requireAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.Role == nil || req.Role.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "role name is required")
+	}
+	if req.Role.Name == auth.RoleAdmin {
+		return nil, status.Errorf(codes.InvalidArgument, "role %q is built in", auth.RoleAdmin)
+	}
+
+	role := &model.Role{
+		Name:        req.Role.Name,
+		Description: req.Role.Description,
+		UpdatedBy:   admin.Subject,
+		UpdatedAt:   time.Now(),
+	}
+	for i, p := range req.Role.Permissions {
+		if len(p.Methods) == 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "permission %d has no methods", i)
+		}
+		role.Permissions = append(role.Permissions, model.Permission{
+			Methods:        p.Methods,
+			CommandTypes:   p.CommandTypes,
+			SerialPrefixes: p.SerialPrefixes,
+		})
+	}
+
+	if err := s.postgresRepo.SaveRole(ctx, role); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to save role: %v", err)
+	}
+
+	slog.InfoContext(ctx, "Role set", "role", role.Name, "permissions", len(role.Permissions), "updated_by", admin.Subject)
+
+	return toRoleInfo(role), nil
+}
+
+// DeleteRole deletes the role; principals that have it keep their other
+// roles.
+func (s *RoleService) DeleteRole(ctx context.Context, req *pb.DeleteRoleRequest) (*pb.DeleteRoleResponse, error) {
+	admin, err := requireAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.Name == auth.RoleAdmin {
+		return nil, status.Errorf(codes.InvalidArgument, "role %q is built in", auth.RoleAdmin)
+	}
+
+	found, err := s.postgresRepo.DeleteRole(ctx, req.Name)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to delete role: %v", err)
+	}
+	if !found {
+		return nil, status.Errorf(codes.NotFound, "no role %q", req.Name)
+	}
+
+	slog.InfoContext(ctx, "Role deleted", "role", req.Name, "deleted_by", admin.Subject)
+
+	return &pb.DeleteRoleResponse{}, nil
+}
+
+func toRoleInfo(role *model.Role) *pb.Role {
+	info := &pb.Role{
+		Name:        role.Name,
+		Description: role.Description,
+		UpdatedBy:   role.UpdatedBy,
+		UpdatedAt:   timestamppb.New(role.UpdatedAt),
+	}
+
+	for _, p := range role.Permissions {
+		info.Permissions = append(info.Permissions, &pb.Permission{
+			Methods:        p.Methods,
+			CommandTypes:   p.CommandTypes,
+			SerialPrefixes: p.SerialPrefixes,
+		})
+	}
+
+	return info
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"router-manager/internal/auth"
+	"router-manager/internal/model"
+	"router-manager/internal/pb"
+	mockspg "router-manager/internal/repository/postgres/mocks"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func setupRoles(t *testing.T) (*RoleService, *mockspg.MockPostgresRepo, context.Context) {
+	ctx := auth.NewContext(context.Background(), &auth.Principal{Subject: "root", Roles: []string{auth.RoleAdmin}})
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockPostgres := mockspg.NewMockPostgresRepo(ctrl)
+
+	s := NewRoleService(mockPostgres)
+
+	return s, mockPostgres, ctx
+}
+
+/* --- test PutRole method --- */
+
+func TestPutRole(t *testing.T) {
+	s, mockPostgres, ctx := setupRoles(t)
+
+	var saved *model.Role
+	mockPostgres.EXPECT().
+		SaveRole(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, role *model.Role) error {
+			saved = role
+			return nil
+		})
+
+	response, err := s.PutRole(ctx, &pb.PutRoleRequest{Role: &pb.Role{
+		Name: "helpdesk",
+		Permissions: []*pb.Permission{{
+			Methods:        []string{"CommandService.SendCommand"},
+			CommandTypes:   []string{"REBOOT"},
+			SerialPrefixes: []string{"MSK-"},
+		}},
+	}})
+
+	require.NoError(t, err)
+	assert.Equal(t, "root", saved.UpdatedBy)
+	assert.Equal(t, []model.Permission{{
+		Methods:        []string{"CommandService.SendCommand"},
+		CommandTypes:   []string{"REBOOT"},
+		SerialPrefixes: []string{"MSK-"},
+	}}, saved.Permissions)
+	assert.Equal(t, "helpdesk", response.Name)
+	assert.Equal(t, "root", response.UpdatedBy)
+}
+
+func TestPutRole_Invalid(t *testing.T) {
+	s, _, ctx := setupRoles(t)
+
+	tests := []struct {
+		name string
+		role *pb.Role
+	}{
+		{"no role", nil},
+		{"no name", &pb.Role{}},
+		{"admin", &pb.Role{Name: auth.RoleAdmin}},
+		{"no methods", &pb.Role{Name: "helpdesk", Permissions: []*pb.Permission{{CommandTypes: []string{"REBOOT"}}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.PutRole(ctx, &pb.PutRoleRequest{Role: tt.role})
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		})
+	}
+}
+
+func TestPutRole_NotAdmin(t *testing.T) {
+	s, _, _ := setupRoles(t)
+	ctx := auth.NewContext(context.Background(), &auth.Principal{Subject: "alice", Roles: []string{"noc"}})
+
+	_, err := s.PutRole(ctx, &pb.PutRoleRequest{Role: &pb.Role{Name: "noc"}})
+
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+/* --- test DeleteRole method --- */
+
+func TestDeleteRole(t *testing.T) {
+	s, mockPostgres, ctx := setupRoles(t)
+
+	mockPostgres.EXPECT().DeleteRole(gomock.Any(), "helpdesk").Return(true, nil)
+	_, err := s.DeleteRole(ctx, &pb.DeleteRoleRequest{Name: "helpdesk"})
+	require.NoError(t, err)
+
+	mockPostgres.EXPECT().DeleteRole(gomock.Any(), "helpdesk").Return(false, nil)
+	_, err = s.DeleteRole(ctx, &pb.DeleteRoleRequest{Name: "helpdesk"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = s.DeleteRole(ctx, &pb.DeleteRoleRequest{Name: auth.RoleAdmin})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+/* --- test ListRoles method --- */
+
+func TestListRoles(t *testing.T) {
+	s, mockPostgres, ctx := setupRoles(t)
+
+	mockPostgres.EXPECT().ListRoles(gomock.Any()).Return([]model.Role{
+		{Name: "admin", Permissions: []model.Permission{{Methods: []string{"*"}}}},
+		{Name: "noc", Description: "reads everything"},
+	}, nil)
+
+	response, err := s.ListRoles(ctx, &pb.ListRolesRequest{})
+
+	require.NoError(t, err)
+	require.Len(t, response.Roles, 2)
+	assert.Equal(t, []string{"*"}, response.Roles[0].Permissions[0].Methods)
+	assert.Equal(t, "reads everything", response.Roles[1].Description)
+}
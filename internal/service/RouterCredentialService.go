@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"router-manager/internal/logging"
+	"router-manager/internal/model"
+	"router-manager/internal/pb"
+	"router-manager/internal/repository/postgres"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// RouterCredentialService issues and revokes the secrets routers
+// authenticate their polls and acks with.
+type RouterCredentialService struct {
+	pb.UnimplementedRouterCredentialServiceServer
+
+	postgresRepo postgres.PostgresRepo
+}
+
+func NewRouterCredentialService(pgRepo postgres.PostgresRepo) *RouterCredentialService {
+	return &RouterCredentialService{
+		postgresRepo: pgRepo,
+	}
+}
+
+// IssueRouterCredential enrolls the router or rotates its secret: the
+// previous one stops working. The secret is only ever returned here.
+func (s *RouterCredentialService) IssueRouterCredential(ctx context.Context, req *pb.IssueRouterCredentialRequest) (*pb.RouterCredential, error) {
+	router, err := s.enrollRouter(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if router.DecommissionedAt != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "router %s is decommissioned", router.SerialNumber)
+	}
+
+	secret, hash, err := model.NewRouterSecret()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to generate secret: %v", err)
+	}
+
+	credential := &model.RouterCredential{
+		RouterID:   router.ID,
+		SecretHash: hash,
+		IssuedBy:   callerFromContext(ctx),
+		IssuedAt:   time.Now(),
+	}
+	found, err := s.postgresRepo.SaveRouterCredential(ctx, credential)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to save credential: %v", err)
+	}
+	if !found {
+		return nil, status.Errorf(codes.NotFound, "no router %s", router.ID)
+	}
+
+	slog.InfoContext(ctx, "Router credential issued", logging.RouterIDKey, credential.RouterID, "serial_number", credential.SerialNumber, "issued_by", credential.IssuedBy)
+
+	return &pb.RouterCredential{
+		RouterId:     credential.RouterID.String(),
+		SerialNumber: credential.SerialNumber,
+		Secret:       secret,
+		IssuedBy:     credential.IssuedBy,
+		IssuedAt:     timestamppb.New(credential.IssuedAt),
+	}, nil
+}
+
+// enrollRouter finds the router of the request; an unknown serial number is
+// registered.
+func (s *RouterCredentialService) enrollRouter(ctx context.Context, req *pb.IssueRouterCredentialRequest) (*model.Router, error) {
+	if req.RouterId != "" {
+		if _, err := uuid.Parse(req.RouterId); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid router_id: %v", err)
+		}
+
+		router, err := s.postgresRepo.FindRouterByRouterId(ctx, req.RouterId)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to find router: %v", err)
+		}
+		if router == nil {
+			return nil, status.Errorf(codes.NotFound, "no router %s", req.RouterId)
+		}
+		if req.SerialNumber != "" && req.SerialNumber != router.SerialNumber {
+			return nil, status.Errorf(codes.InvalidArgument, "router %s has serial_number %s", router.ID, router.SerialNumber)
+		}
+		return router, nil
+	}
+
+	if req.SerialNumber == "" {
+		return nil, status.Error(codes.InvalidArgument, "router_id or serial_number is required")
+	}
+
+	routers, err := s.postgresRepo.FindRoutersBySelector(ctx, model.RouterSelector{SerialNumbers: []string{req.SerialNumber}})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to find router: %v", err)
+	}
+	if len(routers) > 0 {
+		return &routers[0], nil
+	}
+
+	router := &model.Router{
+		ID:           uuid.New(),
+		SerialNumber: req.SerialNumber,
+		CreatedAt:    time.Now(),
+	}
+	if err := s.postgresRepo.SaveRouter(ctx, router); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to register router: %v", err)
+	}
+
+	slog.InfoContext(ctx, "Router enrolled", logging.RouterIDKey, router.ID, "serial_number", router.SerialNumber)
+
+	return router, nil
+}
+
+// RevokeRouterCredential makes the router's polls and acks fail until a new
+// secret is issued.
+func (s *RouterCredentialService) RevokeRouterCredential(ctx context.Context, req *pb.RevokeRouterCredentialRequest) (*pb.RevokeRouterCredentialResponse, error) {
+	routerId, err := uuid.Parse(req.RouterId)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid router_id: %v", err)
+	}
+
+	found, err := s.postgresRepo.RevokeRouterCredential(ctx, routerId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to revoke credential: %v", err)
+	}
+	if !found {
+		return nil, status.Errorf(codes.NotFound, "router %s has no credential", routerId)
+	}
+
+	slog.InfoContext(ctx, "Router credential revoked", logging.RouterIDKey, routerId, "revoked_by", callerFromContext(ctx))
+
+	return &pb.RevokeRouterCredentialResponse{}, nil
+}
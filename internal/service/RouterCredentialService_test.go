@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"router-manager/internal/auth"
+	"router-manager/internal/model"
+	"router-manager/internal/pb"
+	mockspg "router-manager/internal/repository/postgres/mocks"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func setupRouterCredentials(t *testing.T) (*RouterCredentialService, *mockspg.MockPostgresRepo, context.Context) {
+	ctx := auth.NewContext(context.Background(), &auth.Principal{Subject: "root", Roles: []string{auth.RoleAdmin}})
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockPostgres := mockspg.NewMockPostgresRepo(ctrl)
+
+	s := NewRouterCredentialService(mockPostgres)
+
+	return s, mockPostgres, ctx
+}
+
+// expectSaveCredential captures the credential saved for a router with the
+// serial number.
+func expectSaveCredential(mockPostgres *mockspg.MockPostgresRepo, serial string) *model.RouterCredential {
+	saved := &model.RouterCredential{}
+	mockPostgres.EXPECT().
+		SaveRouterCredential(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, credential *model.RouterCredential) (bool, error) {
+			credential.SerialNumber = serial
+			*saved = *credential
+			return true, nil
+		})
+	return saved
+}
+
+/* --- test IssueRouterCredential method --- */
+
+func TestIssueRouterCredential_Enroll(t *testing.T) {
+	s, mockPostgres, ctx := setupRouterCredentials(t)
+
+	mockPostgres.EXPECT().
+		FindRoutersBySelector(gomock.Any(), model.RouterSelector{SerialNumbers: []string{"SN-NEW"}}).
+		Return(nil, nil)
+	var registered *model.Router
+	mockPostgres.EXPECT().
+		SaveRouter(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, router *model.Router) error {
+			registered = router
+			return nil
+		})
+	saved := expectSaveCredential(mockPostgres, "SN-NEW")
+
+	response, err := s.IssueRouterCredential(ctx, &pb.IssueRouterCredentialRequest{SerialNumber: "SN-NEW"})
+
+	require.NoError(t, err)
+	assert.Equal(t, "SN-NEW", registered.SerialNumber)
+	assert.Equal(t, registered.ID, saved.RouterID)
+	assert.Equal(t, registered.ID.String(), response.RouterId)
+	assert.True(t, strings.HasPrefix(response.Secret, model.RouterSecretPrefix))
+	// only the hash of the secret is stored
+	assert.Equal(t, model.HashApiKeySecret(response.Secret), saved.SecretHash)
+	assert.Equal(t, "root", saved.IssuedBy)
+}
+
+func TestIssueRouterCredential_Rotate(t *testing.T) {
+	s, mockPostgres, ctx := setupRouterCredentials(t)
+
+	router := &model.Router{ID: uuid.New(), SerialNumber: "SN-1"}
+	mockPostgres.EXPECT().FindRouterByRouterId(gomock.Any(), router.ID.String()).Return(router, nil)
+	saved := expectSaveCredential(mockPostgres, "SN-1")
+
+	response, err := s.IssueRouterCredential(ctx, &pb.IssueRouterCredentialRequest{RouterId: router.ID.String()})
+
+	require.NoError(t, err)
+	assert.Equal(t, router.ID, saved.RouterID)
+	assert.Equal(t, "SN-1", response.SerialNumber)
+}
+
+func TestIssueRouterCredential_Refused(t *testing.T) {
+	retiredAt := time.Now()
+	known := &model.Router{ID: uuid.New(), SerialNumber: "SN-1"}
+	retired := &model.Router{ID: uuid.New(), SerialNumber: "SN-2", DecommissionedAt: &retiredAt}
+
+	tests := []struct {
+		name string
+		req  *pb.IssueRouterCredentialRequest
+		code codes.Code
+	}{
+		{"nothing", &pb.IssueRouterCredentialRequest{}, codes.InvalidArgument},
+		{"invalid id", &pb.IssueRouterCredentialRequest{RouterId: "nope"}, codes.InvalidArgument},
+		{"unknown id", &pb.IssueRouterCredentialRequest{RouterId: uuid.NewString()}, codes.NotFound},
+		{"other serial", &pb.IssueRouterCredentialRequest{RouterId: known.ID.String(), SerialNumber: "SN-9"}, codes.InvalidArgument},
+		{"decommissioned", &pb.IssueRouterCredentialRequest{RouterId: retired.ID.String()}, codes.FailedPrecondition},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mockPostgres, ctx := setupRouterCredentials(t)
+			mockPostgres.EXPECT().
+				FindRouterByRouterId(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, id string) (*model.Router, error) {
+					for _, router := range []*model.Router{known, retired} {
+						if router.ID.String() == id {
+							return router, nil
+						}
+					}
+					return nil, nil
+				}).AnyTimes()
+
+			_, err := s.IssueRouterCredential(ctx, tt.req)
+
+			assert.Equal(t, tt.code, status.Code(err))
+		})
+	}
+}
+
+/* --- test RevokeRouterCredential method --- */
+
+func TestRevokeRouterCredential(t *testing.T) {
+	s, mockPostgres, ctx := setupRouterCredentials(t)
+	routerId := uuid.New()
+
+	mockPostgres.EXPECT().RevokeRouterCredential(gomock.Any(), routerId).Return(true, nil)
+	_, err := s.RevokeRouterCredential(ctx, &pb.RevokeRouterCredentialRequest{RouterId: routerId.String()})
+	require.NoError(t, err)
+
+	mockPostgres.EXPECT().RevokeRouterCredential(gomock.Any(), routerId).Return(false, nil)
+	_, err = s.RevokeRouterCredential(ctx, &pb.RevokeRouterCredentialRequest{RouterId: routerId.String()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
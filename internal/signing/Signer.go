@@ -0,0 +1,196 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"router-manager/internal/model"
+	"time"
+)
+
+// Algorithm is the signature algorithm of command envelopes.
+const Algorithm = "Ed25519"
+
+// envelopeVersion starts the signed bytes, so that the layout can change
+const envelopeVersion = "router-manager-command-v1"
+
+// PublicKey is a key routers verify command signatures with.
+type PublicKey struct {
+	ID  string
+	Key ed25519.PublicKey
+	// Current is the key new commands are signed with
+	Current bool
+}
+
+// Signer signs commands with its current key, so that routers can tell that
+// a command comes from this service unaltered, however it reached them.
+// Commands are signed when they are created and again when they are
+// delivered; signatures are valid for ttl from when they were made.
+type Signer struct {
+	keyID string
+	key   ed25519.PrivateKey
+	ttl   time.Duration
+
+	// the current key first, then the previous ones, still published for
+	// the commands they signed
+	published []PublicKey
+}
+
+// NewSigner signs with key; previous keys are published along with it.
+func NewSigner(key ed25519.PrivateKey, ttl time.Duration, previous ...ed25519.PublicKey) *Signer {
+	public := key.Public().(ed25519.PublicKey)
+	s := &Signer{
+		keyID:     KeyID(public),
+		key:       key,
+		ttl:       ttl,
+		published: []PublicKey{{ID: KeyID(public), Key: public, Current: true}},
+	}
+
+	for _, key := range previous {
+		if !key.Equal(public) {
+			s.published = append(s.published, PublicKey{ID: KeyID(key), Key: key})
+		}
+	}
+	return s
+}
+
+// LoadSigner reads the PKCS #8 private key of keyFile and, if set, the
+// PKIX public keys of previousKeysFile, all PEM encoded.
+func LoadSigner(keyFile, previousKeysFile string, ttl time.Duration) (*Signer, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block in %s", keyFile)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key: %w", err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key is %T, not Ed25519", parsed)
+	}
+
+	var previous []ed25519.PublicKey
+	if previousKeysFile != "" {
+		data, err := os.ReadFile(previousKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("invalid previous key: %w", err)
+			}
+			public, ok := parsed.(ed25519.PublicKey)
+			if !ok {
+				return nil, fmt.Errorf("previous key is %T, not Ed25519", parsed)
+			}
+			previous = append(previous, public)
+		}
+	}
+
+	return NewSigner(key, ttl, previous...), nil
+}
+
+// KeyID identifies a public key: the first 8 bytes of its SHA-256, in hex.
+func KeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// PublicKeys returns the keys routers should accept signatures of.
+func (s *Signer) PublicKeys() []PublicKey {
+	return s.published
+}
+
+// Sign signs the command, valid from at for the ttl of the signer. The
+// payload is compacted first: it is what gets stored and delivered,
+// whichever store it is read from.
+func (s *Signer) Sign(cmd *model.Command, at time.Time) error {
+	if len(cmd.Payload) > 0 {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, cmd.Payload); err != nil {
+			return fmt.Errorf("invalid payload: %w", err)
+		}
+		cmd.Payload = compact.Bytes()
+	}
+
+	notBefore := at.UTC().Truncate(time.Second)
+	expiresAt := notBefore.Add(s.ttl)
+	cmd.NotBefore = &notBefore
+	cmd.ExpiresAt = &expiresAt
+
+	cmd.SignatureKeyID = s.keyID
+	cmd.Signature = ed25519.Sign(s.key, SignedBytes(cmd))
+	return nil
+}
+
+// Check verifies the signature the command was stored with against the
+// current and previous keys, whatever its validity: a command whose
+// signature doesn't match its fields was changed, or made, outside this
+// service.
+func (s *Signer) Check(cmd *model.Command) error {
+	if len(cmd.Signature) == 0 || cmd.NotBefore == nil || cmd.ExpiresAt == nil {
+		return fmt.Errorf("command %s is not signed", cmd.ID)
+	}
+
+	for _, key := range s.published {
+		if key.ID != cmd.SignatureKeyID {
+			continue
+		}
+		if !ed25519.Verify(key.Key, SignedBytes(cmd), cmd.Signature) {
+			return fmt.Errorf("signature of command %s doesn't match it", cmd.ID)
+		}
+		return nil
+	}
+	return fmt.Errorf("command %s is signed with unknown key %s", cmd.ID, cmd.SignatureKeyID)
+}
+
+// SignedBytes are the bytes the signature of a command is over: the
+// envelope version, command id, router id, command type, payload, and
+// not_before and expires_at as Unix seconds, each as a big-endian uint32
+// length followed by the UTF-8 bytes.
+func SignedBytes(cmd *model.Command) []byte {
+	var notBefore, expiresAt int64
+	if cmd.NotBefore != nil {
+		notBefore = cmd.NotBefore.Unix()
+	}
+	if cmd.ExpiresAt != nil {
+		expiresAt = cmd.ExpiresAt.Unix()
+	}
+
+	var buf bytes.Buffer
+	for _, field := range [][]byte{
+		[]byte(envelopeVersion),
+		[]byte(cmd.ID.String()),
+		[]byte(cmd.RouterID.String()),
+		[]byte(cmd.CommandType),
+		cmd.Payload,
+		[]byte(fmt.Sprint(notBefore)),
+		[]byte(fmt.Sprint(expiresAt)),
+	} {
+		binary.Write(&buf, binary.BigEndian, uint32(len(field)))
+		buf.Write(field)
+	}
+	return buf.Bytes()
+}
+
+// Verify reports whether the command carries a valid signature of key that
+// holds at now, as a router would check it.
+func Verify(key ed25519.PublicKey, cmd *model.Command, now time.Time) bool {
+	if cmd.NotBefore == nil || cmd.ExpiresAt == nil || now.Before(*cmd.NotBefore) || !now.Before(*cmd.ExpiresAt) {
+		return false
+	}
+	return ed25519.Verify(key, SignedBytes(cmd), cmd.Signature)
+}
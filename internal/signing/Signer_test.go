@@ -0,0 +1,175 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"router-manager/internal/model"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	public, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	return public, key
+}
+
+func newCommand() *model.Command {
+	return &model.Command{
+		ID:          uuid.New(),
+		RouterID:    uuid.New(),
+		CommandType: "REBOOT",
+		Payload:     []byte(`{ "command": "REBOOT" }`),
+		CreatedAt:   time.Now(),
+	}
+}
+
+func TestSign(t *testing.T) {
+	public, key := newKey(t)
+	signer := NewSigner(key, time.Hour)
+
+	cmd := newCommand()
+	require.NoError(t, signer.Sign(cmd, cmd.CreatedAt))
+
+	assert.Equal(t, KeyID(public), cmd.SignatureKeyID)
+	assert.Equal(t, `{"command":"REBOOT"}`, string(cmd.Payload))
+	assert.Equal(t, cmd.CreatedAt.Truncate(time.Second).Unix(), cmd.NotBefore.Unix())
+	assert.Equal(t, time.Hour, cmd.ExpiresAt.Sub(*cmd.NotBefore))
+	assert.True(t, Verify(public, cmd, time.Now()))
+
+	// the signature only holds within its validity
+	assert.False(t, Verify(public, cmd, cmd.NotBefore.Add(-time.Second)))
+	assert.False(t, Verify(public, cmd, *cmd.ExpiresAt))
+
+	// or with the key it was made with
+	other, _ := newKey(t)
+	assert.False(t, Verify(other, cmd, time.Now()))
+}
+
+func TestSign_Again(t *testing.T) {
+	public, key := newKey(t)
+	signer := NewSigner(key, time.Hour)
+
+	cmd := newCommand()
+	cmd.CreatedAt = time.Now().Add(-2 * time.Hour)
+	require.NoError(t, signer.Sign(cmd, cmd.CreatedAt))
+	assert.False(t, Verify(public, cmd, time.Now()))
+
+	// signed again on delivery, the signature holds from then on
+	delivered := time.Now()
+	require.NoError(t, signer.Sign(cmd, delivered))
+	assert.Equal(t, delivered.Truncate(time.Second).Unix(), cmd.NotBefore.Unix())
+	assert.True(t, Verify(public, cmd, time.Now()))
+}
+
+func TestCheck(t *testing.T) {
+	_, key := newKey(t)
+	previous, previousKey := newKey(t)
+	signer := NewSigner(key, time.Hour, previous)
+
+	// expired signatures of the current and previous keys still check
+	for _, with := range []*Signer{signer, NewSigner(previousKey, time.Hour)} {
+		cmd := newCommand()
+		cmd.CreatedAt = time.Now().Add(-2 * time.Hour)
+		require.NoError(t, with.Sign(cmd, cmd.CreatedAt))
+		assert.NoError(t, signer.Check(cmd))
+	}
+
+	tampered := newCommand()
+	require.NoError(t, signer.Sign(tampered, tampered.CreatedAt))
+	tampered.Payload = []byte(`{"command":"FACTORY_RESET"}`)
+	assert.ErrorContains(t, signer.Check(tampered), "doesn't match")
+
+	_, otherKey := newKey(t)
+	foreign := newCommand()
+	require.NoError(t, NewSigner(otherKey, time.Hour).Sign(foreign, foreign.CreatedAt))
+	assert.ErrorContains(t, signer.Check(foreign), "unknown key")
+
+	assert.ErrorContains(t, signer.Check(newCommand()), "not signed")
+}
+
+func TestVerify_Tampering(t *testing.T) {
+	public, key := newKey(t)
+	signer := NewSigner(key, time.Hour)
+
+	for name, tamper := range map[string]func(cmd *model.Command){
+		"router":       func(cmd *model.Command) { cmd.RouterID = uuid.New() },
+		"command type": func(cmd *model.Command) { cmd.CommandType = "FACTORY_RESET" },
+		"payload":      func(cmd *model.Command) { cmd.Payload = []byte(`{"command":"FACTORY_RESET"}`) },
+		"expiry": func(cmd *model.Command) {
+			later := cmd.ExpiresAt.Add(time.Hour)
+			cmd.ExpiresAt = &later
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cmd := newCommand()
+			require.NoError(t, signer.Sign(cmd, cmd.CreatedAt))
+
+			tamper(cmd)
+			assert.False(t, Verify(public, cmd, time.Now()))
+		})
+	}
+}
+
+func TestSign_InvalidPayload(t *testing.T) {
+	_, key := newKey(t)
+
+	cmd := newCommand()
+	cmd.Payload = []byte(`{`)
+	assert.Error(t, NewSigner(key, time.Hour).Sign(cmd, cmd.CreatedAt))
+}
+
+func TestNewSigner_PublishesPreviousKeys(t *testing.T) {
+	public, key := newKey(t)
+	previous, _ := newKey(t)
+
+	keys := NewSigner(key, time.Hour, previous, public).PublicKeys()
+
+	require.Len(t, keys, 2)
+	assert.Equal(t, PublicKey{ID: KeyID(public), Key: public, Current: true}, keys[0])
+	assert.Equal(t, PublicKey{ID: KeyID(previous), Key: previous}, keys[1])
+}
+
+func TestLoadSigner(t *testing.T) {
+	dir := t.TempDir()
+	public, key := newKey(t)
+	first, _ := newKey(t)
+	second, _ := newKey(t)
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	keyFile := filepath.Join(dir, "signing.key")
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+
+	var previous []byte
+	for _, key := range []ed25519.PublicKey{first, second} {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		require.NoError(t, err)
+		previous = append(previous, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})...)
+	}
+	previousFile := filepath.Join(dir, "previous.pem")
+	require.NoError(t, os.WriteFile(previousFile, previous, 0o600))
+
+	signer, err := LoadSigner(keyFile, previousFile, time.Hour)
+	require.NoError(t, err)
+
+	keys := signer.PublicKeys()
+	require.Len(t, keys, 3)
+	assert.Equal(t, KeyID(public), keys[0].ID)
+	assert.Equal(t, KeyID(first), keys[1].ID)
+	assert.Equal(t, KeyID(second), keys[2].ID)
+
+	signer, err = LoadSigner(keyFile, "", time.Hour)
+	require.NoError(t, err)
+	assert.Len(t, signer.PublicKeys(), 1)
+
+	_, err = LoadSigner(previousFile, "", time.Hour)
+	assert.Error(t, err)
+}
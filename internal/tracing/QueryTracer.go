@@ -0,0 +1,99 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// QueryTracer traces the queries, batches and copies of pgx connections,
+// one span each. Statements are recorded without their arguments.
+type QueryTracer struct {
+	tracer trace.Tracer
+}
+
+// NewQueryTracer traces with provider, nil = the global one.
+func NewQueryTracer(provider trace.TracerProvider) *QueryTracer {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return &QueryTracer{tracer: provider.Tracer(instrumentation)}
+}
+
+var (
+	_ pgx.QueryTracer    = (*QueryTracer)(nil)
+	_ pgx.BatchTracer    = (*QueryTracer)(nil)
+	_ pgx.CopyFromTracer = (*QueryTracer)(nil)
+)
+
+func (t *QueryTracer) start(ctx context.Context, operation string, attrs ...attribute.KeyValue) context.Context {
+	attrs = append(attrs, semconv.DBSystemPostgreSQL, semconv.DBOperationName(operation))
+	ctx, _ = t.tracer.Start(ctx, "postgres "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+	return ctx
+}
+
+func end(ctx context.Context, err error) {
+	span := trace.SpanFromContext(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (t *QueryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return t.start(ctx, operation(data.SQL), semconv.DBQueryText(data.SQL))
+}
+
+func (t *QueryTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	if data.Err == nil {
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	}
+	end(ctx, data.Err)
+}
+
+func (t *QueryTracer) TraceBatchStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchStartData) context.Context {
+	return t.start(ctx, "BATCH", attribute.Int("db.batch.size", data.Batch.Len()))
+}
+
+// TraceBatchQuery records the queries of a batch as events of its span.
+func (t *QueryTracer) TraceBatchQuery(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchQueryData) {
+	attrs := []attribute.KeyValue{semconv.DBQueryText(data.SQL)}
+	if data.Err != nil {
+		attrs = append(attrs, attribute.String("error", data.Err.Error()))
+	}
+	trace.SpanFromContext(ctx).AddEvent("query", trace.WithAttributes(attrs...))
+}
+
+func (t *QueryTracer) TraceBatchEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchEndData) {
+	end(ctx, data.Err)
+}
+
+func (t *QueryTracer) TraceCopyFromStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceCopyFromStartData) context.Context {
+	return t.start(ctx, "COPY", semconv.DBCollectionName(data.TableName.Sanitize()))
+}
+
+func (t *QueryTracer) TraceCopyFromEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	if data.Err == nil {
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	}
+	end(ctx, data.Err)
+}
+
+// operation is the first keyword of the statement, e.g. SELECT; WITH for
+// common table expressions.
+func operation(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "QUERY"
+	}
+	return strings.ToUpper(fields[0])
+}
@@ -0,0 +1,87 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// attr returns the value of the attribute key of span, "" if it has none.
+func attr(span tracetest.SpanStub, key attribute.Key) string {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestQueryTracer(t *testing.T) {
+	provider, exporter := setupProvider()
+	tracer := NewQueryTracer(provider)
+
+	parent, span := provider.Tracer("test").Start(context.Background(), "call")
+	sql := "UPDATE commands SET status = $1 WHERE id = $2"
+	ctx := tracer.TraceQueryStart(parent, nil, pgx.TraceQueryStartData{SQL: sql, Args: []any{"ACKED", "c-1"}})
+	tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{CommandTag: pgconn.NewCommandTag("UPDATE 1")})
+
+	ctx = tracer.TraceQueryStart(parent, nil, pgx.TraceQueryStartData{SQL: "\n\tselect 1"})
+	tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{Err: errors.New("connection reset")})
+	span.End()
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 3)
+
+	update := spans[0]
+	assert.Equal(t, "postgres UPDATE", update.Name)
+	assert.Equal(t, "postgresql", attr(update, "db.system"))
+	assert.Equal(t, "UPDATE", attr(update, "db.operation.name"))
+	// statements, never their arguments
+	assert.Equal(t, sql, attr(update, "db.query.text"))
+	assert.Equal(t, "1", attr(update, "db.rows_affected"))
+	assert.Equal(t, spans[2].SpanContext.SpanID(), update.Parent.SpanID())
+	assert.Equal(t, codes.Unset, update.Status.Code)
+
+	failed := spans[1]
+	assert.Equal(t, "postgres SELECT", failed.Name)
+	assert.Equal(t, codes.Error, failed.Status.Code)
+	assert.Equal(t, "connection reset", failed.Status.Description)
+}
+
+func TestQueryTracer_BatchAndCopy(t *testing.T) {
+	provider, exporter := setupProvider()
+	tracer := NewQueryTracer(provider)
+
+	batch := &pgx.Batch{}
+	batch.Queue("INSERT INTO commands (id) VALUES ($1)", "c-1")
+	batch.Queue("INSERT INTO commands (id) VALUES ($1)", "c-2")
+	ctx := tracer.TraceBatchStart(context.Background(), nil, pgx.TraceBatchStartData{Batch: batch})
+	tracer.TraceBatchQuery(ctx, nil, pgx.TraceBatchQueryData{SQL: "INSERT INTO commands (id) VALUES ($1)"})
+	tracer.TraceBatchQuery(ctx, nil, pgx.TraceBatchQueryData{SQL: "INSERT INTO commands (id) VALUES ($1)"})
+	tracer.TraceBatchEnd(ctx, nil, pgx.TraceBatchEndData{})
+
+	ctx = tracer.TraceCopyFromStart(context.Background(), nil, pgx.TraceCopyFromStartData{
+		TableName:   pgx.Identifier{"commands"},
+		ColumnNames: []string{"id"},
+	})
+	tracer.TraceCopyFromEnd(ctx, nil, pgx.TraceCopyFromEndData{CommandTag: pgconn.NewCommandTag("COPY 500")})
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+
+	assert.Equal(t, "postgres BATCH", spans[0].Name)
+	assert.Equal(t, "2", attr(spans[0], "db.batch.size"))
+	assert.Len(t, spans[0].Events, 2)
+
+	assert.Equal(t, "postgres COPY", spans[1].Name)
+	assert.Equal(t, `"commands"`, attr(spans[1], "db.collection.name"))
+	assert.Equal(t, "500", attr(spans[1], "db.rows_affected"))
+}
@@ -0,0 +1,56 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+)
+
+func TestRedisHook(t *testing.T) {
+	provider, exporter := setupProvider()
+	hook := NewRedisHook(provider)
+
+	ctx := context.Background()
+	process := hook.ProcessHook(func(ctx context.Context, cmd redis.Cmder) error {
+		return cmd.Err()
+	})
+
+	hit := redis.NewStringCmd(ctx, "get", "router:SN1")
+	require.NoError(t, process(ctx, hit))
+
+	miss := redis.NewStringCmd(ctx, "get", "router:SN2")
+	miss.SetErr(redis.Nil)
+	assert.ErrorIs(t, process(ctx, miss), redis.Nil)
+
+	down := redis.NewStatusCmd(ctx, "set", "router:SN1", "r-1")
+	down.SetErr(errors.New("connection refused"))
+	assert.Error(t, process(ctx, down))
+
+	pipeline := hook.ProcessPipelineHook(func(ctx context.Context, cmds []redis.Cmder) error {
+		return nil
+	})
+	require.NoError(t, pipeline(ctx, []redis.Cmder{
+		redis.NewStatusCmd(ctx, "multi"),
+		redis.NewIntCmd(ctx, "zadd", "router:r-1:commands", 1, "c-1"),
+		redis.NewStatusCmd(ctx, "exec"),
+	}))
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 4)
+
+	assert.Equal(t, "redis GET", spans[0].Name)
+	assert.Equal(t, "redis", attr(spans[0], "db.system"))
+	assert.Equal(t, "GET", attr(spans[0], "db.operation.name"))
+	// a missing key isn't a failure
+	assert.Equal(t, codes.Unset, spans[1].Status.Code)
+	assert.Equal(t, codes.Error, spans[2].Status.Code)
+	assert.Equal(t, "connection refused", spans[2].Status.Description)
+
+	assert.Equal(t, "redis pipeline", spans[3].Name)
+	assert.Equal(t, `["MULTI","ZADD","EXEC"]`, attr(spans[3], "db.redis.commands"))
+}
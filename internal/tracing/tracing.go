@@ -0,0 +1,69 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// name of the tracers of the service
+const instrumentation = "router-manager"
+
+// exporters of NewExporter
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// Tracer is the tracer of the service's own spans, from the global provider.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
+
+// NewExporter returns the exporter named by kind: "otlp" sends spans over
+// gRPC to OTEL_EXPORTER_OTLP_ENDPOINT (localhost:4317 by default), "stdout"
+// writes them to w as JSON, "none" returns nil.
+func NewExporter(ctx context.Context, kind string, w io.Writer) (sdktrace.SpanExporter, error) {
+	switch kind {
+	case "", ExporterNone:
+		return nil, nil
+	case ExporterStdout:
+		return stdouttrace.New(stdouttrace.WithWriter(w))
+	case ExporterOTLP:
+		return otlptracegrpc.New(ctx)
+	}
+	return nil, fmt.Errorf("invalid trace exporter %q, want none, stdout or otlp", kind)
+}
+
+// NewProvider returns a provider exporting the spans of serviceName in
+// batches. Traces started here are sampled at sampleRatio; those started
+// by a caller are sampled if the caller's are.
+func NewProvider(exporter sdktrace.SpanExporter, serviceName string, sampleRatio float64) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+	)
+}
+
+// Install makes provider the global one, nil leaving the no-op one, and
+// propagates W3C trace context and baggage either way.
+func Install(provider trace.TracerProvider) {
+	if provider != nil {
+		otel.SetTracerProvider(provider)
+	}
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+}
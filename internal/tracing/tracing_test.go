@@ -0,0 +1,63 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// setupProvider returns a provider recording every span in memory.
+func setupProvider() (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	return sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)), exporter
+}
+
+func TestNewExporter(t *testing.T) {
+	exporter, err := NewExporter(context.Background(), ExporterNone, nil)
+	require.NoError(t, err)
+	assert.Nil(t, exporter)
+
+	var buf bytes.Buffer
+	exporter, err = NewExporter(context.Background(), ExporterStdout, &buf)
+	require.NoError(t, err)
+
+	provider := NewProvider(exporter, "router-manager-test", 1)
+	_, span := provider.Tracer(instrumentation).Start(context.Background(), "tick")
+	span.End()
+	require.NoError(t, provider.Shutdown(context.Background()))
+	assert.Contains(t, buf.String(), `"Name":"tick"`)
+	assert.Contains(t, buf.String(), "router-manager-test")
+
+	_, err = NewExporter(context.Background(), "jaeger", nil)
+	assert.Error(t, err)
+}
+
+func TestInstall_PropagatesTraceContext(t *testing.T) {
+	provider, exporter := setupProvider()
+	Install(provider)
+
+	var handled trace.SpanContext
+	handler := otelhttp.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled = trace.SpanContextFromContext(r.Context())
+	}), "gateway")
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/commands", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", spans[0].Parent.SpanID().String())
+	assert.True(t, spans[0].Parent.IsRemote())
+	assert.Equal(t, spans[0].SpanContext.SpanID(), handled.SpanID())
+}
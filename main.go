@@ -1,9 +1,15 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"log/slog"
 	"os"
 	"router-manager/internal/app"
+	"router-manager/internal/config"
+	"router-manager/internal/logging"
+	"router-manager/internal/tracing"
+	"time"
 
 	_ "router-manager/internal/metrics"
 
@@ -11,43 +17,82 @@ import (
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	_ "github.com/lib/pq"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 func main() {
-	logFile, err := os.OpenFile("/var/log/app.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	logConfig := config.LoadLogging()
+	output, err := logging.Open(logConfig.Output)
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("Couldn't open log output", "output", logConfig.Output, "error", err)
+		os.Exit(1)
 	}
-	defer logFile.Close()
+	defer output.Close()
 
-	log.SetOutput(logFile)
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	logger, err := logging.New(output, logConfig.Format, logConfig.Level)
+	if err != nil {
+		slog.Error("Couldn't set up logging", "error", err)
+		os.Exit(1)
+	}
+	// the standard log, used by libraries, goes there too
+	slog.SetDefault(logger)
+
+	provider := setupTracing()
+	if provider != nil {
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			// flush the spans still batched
+			if err := provider.Shutdown(ctx); err != nil {
+				slog.Error("Failed to flush traces", "error", err)
+			}
+		}()
+	}
 
 	runMigration()
-	myApp := app.NewApplication()
+	myApp := app.NewApplication(logger)
 
 	myApp.Run()
 }
 
+// setupTracing installs the trace exporter of the environment; it returns
+// nil if spans aren't exported.
+func setupTracing() *sdktrace.TracerProvider {
+	traceConfig := config.LoadTracing()
+	exporter, err := tracing.NewExporter(context.Background(), traceConfig.Exporter, os.Stdout)
+	if err != nil {
+		logging.Fatal("Couldn't set up tracing", "error", err)
+	}
+	if exporter == nil {
+		tracing.Install(nil)
+		return nil
+	}
+
+	provider := tracing.NewProvider(exporter, traceConfig.ServiceName, traceConfig.SampleRatio)
+	tracing.Install(provider)
+	slog.Info("Tracing enabled", "exporter", traceConfig.Exporter, "sample_ratio", traceConfig.SampleRatio)
+	return provider
+}
+
 func runMigration() {
 	dsn := os.Getenv("POSTGRES_GO_URL")
 	if dsn == "" {
-		log.Fatal("Dsn id empty")
+		logging.Fatal("POSTGRES_GO_URL is empty")
 	}
 
-	migration, err := migrate.New("file://db/migration", dsn)
+	migration, err := migrate.New("file://"+config.MigrationsDir, dsn)
 	if err != nil {
-		log.Fatalf("Failed to initialize migration: %v", err)
+		logging.Fatal("Failed to initialize migration", "error", err)
 	}
 	defer migration.Close()
 
-	migration.Up()
-	if err != nil && err != migrate.ErrNoChange {
-		log.Fatalf("failed to run execute migration: %v", err)
-	}
-	if err == migrate.ErrNoChange {
-		log.Printf("No changes in table scheme")
-	} else {
-		log.Printf("Migration completed")
+	err = migration.Up()
+	switch {
+	case errors.Is(err, migrate.ErrNoChange):
+		slog.Info("No changes in table scheme")
+	case err != nil:
+		logging.Fatal("Failed to run migration", "error", err)
+	default:
+		slog.Info("Migration completed")
 	}
 }
@@ -24,6 +24,8 @@ import (
 type TestPostgres struct {
 	Repo      postgres.PostgresRepo
 	Container testcontainers.Container
+	// for state the repository doesn't write, like decommissioned routers
+	Pool *pgxpool.Pool
 }
 
 func SetupTestPostgres(t *testing.T) *TestPostgres {
@@ -102,5 +104,6 @@ func SetupTestPostgres(t *testing.T) *TestPostgres {
 	return &TestPostgres{
 		Repo:      repo,
 		Container: container,
+		Pool:      postgresPool,
 	}
 }